@@ -5,6 +5,9 @@
 #define MAX_HTTP2_PATH_CONTENT_LENGTH 100
 #define MAX_HTTP2_STATUS_HEADER_LENGTH 1
 
+// the ips of the pods a socket filter is restricted to, many for the parent of ipvlan or macvlan pods.
+#define FILTER_MAX_PODS 256
+
 #define bpf_printk(fmt, ...)                                    \
 ({                                                              \
                char ____fmt[] = fmt;                            \
@@ -0,0 +1,72 @@
+#ifndef __EVENT_BUDGET_H
+#define __EVENT_BUDGET_H
+
+// per probe token buckets, the probe id indexes both maps. The agent fills the config when the program is loaded,
+// probes without a config or with rate 0 are not limited.
+#define EVENT_BUDGET_MAX_PROBES 32
+#define EVENT_BUDGET_NSEC_PER_SEC 1000000000ULL
+
+typedef struct {
+    // events per second and cpu, the agent divides the node budget by the number of cpus.
+    __u64 rate;
+    __u64 burst;
+} event_budget_config_t;
+
+typedef struct {
+    // tokens are scaled by EVENT_BUDGET_NSEC_PER_SEC to refill at nanosecond resolution.
+    __u64 tokens;
+    __u64 last_ts;
+    __u64 allowed;
+    __u64 dropped;
+} event_budget_state_t;
+
+struct bpf_map_def SEC("maps/event_budget_config_map") event_budget_config_map = {
+    .type = BPF_MAP_TYPE_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(event_budget_config_t),
+    .max_entries = EVENT_BUDGET_MAX_PROBES,
+};
+
+// per cpu buckets need no locking, kprobes can not take bpf spin locks.
+struct bpf_map_def SEC("maps/event_budget_state_map") event_budget_state_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(event_budget_state_t),
+    .max_entries = EVENT_BUDGET_MAX_PROBES,
+};
+
+// event_budget_allow takes a token of the probe, events are dropped and counted once the bucket is empty.
+static __always_inline bool event_budget_allow(__u32 probe) {
+    event_budget_config_t *cfg = bpf_map_lookup_elem(&event_budget_config_map, &probe);
+    if (cfg == NULL || cfg->rate == 0) {
+        return true;
+    }
+    event_budget_state_t *state = bpf_map_lookup_elem(&event_budget_state_map, &probe);
+    if (state == NULL) {
+        return true;
+    }
+
+    __u64 now = bpf_ktime_get_ns();
+    __u64 max = cfg->burst * EVENT_BUDGET_NSEC_PER_SEC;
+    __u64 elapsed = now - state->last_ts;
+    // a long idle period fills the bucket, the product would overflow otherwise.
+    if (elapsed >= max / cfg->rate) {
+        state->tokens = max;
+    } else {
+        state->tokens += elapsed * cfg->rate;
+        if (state->tokens > max) {
+            state->tokens = max;
+        }
+    }
+    state->last_ts = now;
+
+    if (state->tokens < EVENT_BUDGET_NSEC_PER_SEC) {
+        state->dropped++;
+        return false;
+    }
+    state->tokens -= EVENT_BUDGET_NSEC_PER_SEC;
+    state->allowed++;
+    return true;
+}
+
+#endif
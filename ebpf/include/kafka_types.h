@@ -37,7 +37,11 @@ typedef struct {
 
 typedef struct kafka_transaction_t {
     __u64 request_started;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
     __u32 records_count;
+    // error_code of the response, of the first partition reporting one for fetch responses, 0 if it succeeded.
+    __s16 error_code;
     __u8 request_api_key;
     __u8 request_api_version;
     __u8 topic_name_size;
@@ -0,0 +1,21 @@
+#ifndef __PORT_EXCLUSION_H
+#define __PORT_EXCLUSION_H
+
+#define EXCLUDED_PORTS_MAX_ENTRIES 64
+
+// ports never parsed by the L7 plugins (encrypted or opaque traffic), filled by the agent when the program is loaded.
+struct bpf_map_def SEC("maps/excluded_ports_map") excluded_ports_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u16),
+    .value_size = sizeof(__u8),
+    .max_entries = EXCLUDED_PORTS_MAX_ENTRIES,
+};
+
+static __always_inline bool is_excluded_port(conn_tuple_t *tup) {
+    __u16 sport = tup->sport;
+    __u16 dport = tup->dport;
+    return bpf_map_lookup_elem(&excluded_ports_map, &sport) != NULL ||
+           bpf_map_lookup_elem(&excluded_ports_map, &dport) != NULL;
+}
+
+#endif
@@ -41,6 +41,8 @@ enum eth_ip_type {
 };
 
 #define MYSQL_ERROR_MESSAGE_MAX_SIZE 10
+// the length byte and up to two digits of the grpc-status value
+#define GRPC_STATUS_LITERAL_LENGTH 3
 
 struct rpc_package_t {
     __u32 rpc_type; // 4
@@ -60,6 +62,10 @@ struct rpc_package_t {
 	__u8 dubbo_status; // 143
 	__u16 mysql_status; // 145
 	char mysql_msg[MYSQL_ERROR_MESSAGE_MAX_SIZE];
+	__u8 triple; // 157
+	char grpc_status[GRPC_STATUS_LITERAL_LENGTH]; // 160
+	// cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+	__u64 cookie; // 168
 };
 
 #define IP_MF	  0x2000
@@ -248,6 +254,18 @@ static __always_inline bool is_mysql_catalog(const char *buf, __u32 buf_size, st
 #define GRPC_ENCODED_CONTENT_TYPE "\x1d\x75\xd0\x62\x0d\x26\x3d\x4c\x4d\x65\x64"
 #define GRPC_CONTENT_TYPE_LEN (sizeof(GRPC_ENCODED_CONTENT_TYPE) - 1)
 
+// Dubbo 3 Triple clients send tri- headers (tri-service-version, tri-consumer-appname, ...),
+// the huffman code of "tri-" takes 23 bits, the last bit belongs to the next character.
+#define TRIPLE_HEADER_PREFIX "tri-"
+#define TRIPLE_HEADER_PREFIX_LEN (sizeof(TRIPLE_HEADER_PREFIX) - 1)
+#define TRIPLE_ENCODED_HEADER_PREFIX "\x4d\x86"
+#define TRIPLE_ENCODED_HEADER_PREFIX_LAST 0x5a
+#define TRIPLE_ENCODED_HEADER_PREFIX_LEN 3
+#define GRPC_STATUS_HEADER "grpc-status"
+#define GRPC_STATUS_HEADER_LEN (sizeof(GRPC_STATUS_HEADER) - 1)
+#define GRPC_ENCODED_STATUS_HEADER "\x9a\xca\xc8\xb2\x12\x34\xda\x8f"
+#define GRPC_ENCODED_STATUS_HEADER_LEN (sizeof(GRPC_ENCODED_STATUS_HEADER) - 1)
+
 #define DUBBO_MAGIC "\xda\xbb"
 #define DUBBO_MAGIC_LEN 2
 
@@ -631,6 +649,45 @@ static __always_inline rpc_status_t is_content_type_grpc(const struct __sk_buff
     return is_encoded_grpc_content_type(content_type_buf) ? PAYLOAD_GRPC : PAYLOAD_NOT_GRPC;
 }
 
+// scan_new_name_header reads a literal header with a new name, it marks the Dubbo 3 Triple requests
+// by their tri- headers and keeps the raw grpc-status value of trailers-only responses.
+static __always_inline void scan_new_name_header(const struct __sk_buff *skb, skb_info_t *skb_info, __u32 frame_end, struct rpc_package_t *pkg) {
+    string_literal_header len;
+    if (skb_info->data_off + sizeof(len) > frame_end) {
+        return;
+    }
+    bpf_skb_load_bytes(skb, skb_info->data_off, &len, sizeof(len));
+    skb_info->data_off += sizeof(len);
+
+    char name[GRPC_STATUS_HEADER_LEN] = {0};
+    bpf_skb_load_bytes(skb, skb_info->data_off, name, sizeof(name));
+    skb_info->data_off += len.length;
+
+    bool is_grpc_status = false;
+    if (len.is_huffman) {
+        if (len.length >= TRIPLE_ENCODED_HEADER_PREFIX_LEN && !bpf_memcmp(name, TRIPLE_ENCODED_HEADER_PREFIX, 2)
+            && (name[2] & 0xfe) == TRIPLE_ENCODED_HEADER_PREFIX_LAST) {
+            pkg->triple = 1;
+        }
+        is_grpc_status = len.length == GRPC_ENCODED_STATUS_HEADER_LEN && !bpf_memcmp(name, GRPC_ENCODED_STATUS_HEADER, GRPC_ENCODED_STATUS_HEADER_LEN);
+    } else {
+        if (len.length >= TRIPLE_HEADER_PREFIX_LEN && !bpf_memcmp(name, TRIPLE_HEADER_PREFIX, TRIPLE_HEADER_PREFIX_LEN)) {
+            pkg->triple = 1;
+        }
+        is_grpc_status = len.length == GRPC_STATUS_HEADER_LEN && !bpf_memcmp(name, GRPC_STATUS_HEADER, GRPC_STATUS_HEADER_LEN);
+    }
+
+    if (skb_info->data_off + sizeof(len) > frame_end) {
+        return;
+    }
+    if (is_grpc_status) {
+        // decoded in user space, the value may be huffman encoded
+        bpf_skb_load_bytes(skb, skb_info->data_off, pkg->grpc_status, GRPC_STATUS_LITERAL_LENGTH);
+    }
+    bpf_skb_load_bytes(skb, skb_info->data_off, &len, sizeof(len));
+    skb_info->data_off += sizeof(len) + len.length;
+}
+
 static __always_inline rpc_status_t scan_headers(const struct __sk_buff *skb, skb_info_t *skb_info, __u32 frame_length, struct rpc_package_t *pkg) {
     field_index idx;
     rpc_status_t status = PAYLOAD_UNDETERMINED;
@@ -663,10 +720,21 @@ static __always_inline rpc_status_t scan_headers(const struct __sk_buff *skb, sk
                 break;
             }
 
-            status = is_content_type_grpc(skb, skb_info, frame_end, idx.literal.index);
-            if (status != PAYLOAD_UNDETERMINED) {
+            if (!idx.literal.index) {
+                scan_new_name_header(skb, skb_info, frame_end, pkg);
+                continue;
+            }
+
+            rpc_status_t content_type = is_content_type_grpc(skb, skb_info, frame_end, idx.literal.index);
+            if (content_type == PAYLOAD_NOT_GRPC) {
+                status = content_type;
                 break;
             }
+            // keep scanning gRPC headers for the tri- headers and grpc-status behind the content-type
+            if (content_type == PAYLOAD_GRPC) {
+                status = content_type;
+                continue;
+            }
 
             skip_literal_header(skb, skb_info, frame_end, idx.literal.index);
 
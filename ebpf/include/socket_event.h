@@ -0,0 +1,25 @@
+#ifndef __SOCKET_EVENT_H
+#define __SOCKET_EVENT_H
+
+// key of the events of the socket filters of the protocols: the connection in the direction of the packet
+// and the capture (bpf_ktime_get_ns) of the packet. sock_key is the one of protocol.h, included before.
+typedef struct {
+    sock_key conn;
+    __u32 pad;
+    __u64 ts;
+} socket_event_key;
+
+// head of the events of the socket filters of the protocols, followed by their payload:
+// - from_pod: the packet was sent by the pod attached to this veth.
+// - flag: the byte left to the protocol, e.g. the transport of dns, named after its use.
+// - cookie: cookie of the socket sending the packet, 0 if it is not owned by a local socket (e.g. replies from
+//   other nodes).
+// see socketfilter.Header of pkg/plugins/protocols/socketfilter.
+#define SOCKET_EVENT_HEADER(flag) \
+    __u16 payload_len;            \
+    __u8 from_pod;                \
+    __u8 flag;                    \
+    __u32 header_pad;             \
+    __u64 cookie;
+
+#endif
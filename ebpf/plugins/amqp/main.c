@@ -0,0 +1,161 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// frames are decoded in user space, the first bytes of a packet usually hold the method frame,
+// the content header and, for small messages, the body.
+#define AMQP_PAYLOAD_SIZE 256
+
+// frame: type, channel, payload size, payload, frame end.
+// see https://www.rabbitmq.com/resources/specs/amqp0-9-1.pdf
+#define AMQP_FRAME_HEADER_SIZE 7
+#define AMQP_FRAME_METHOD 1
+#define AMQP_FRAME_END 0xCE
+// payload of method frames starts with the class and the method id.
+#define AMQP_METHOD_HEADER_SIZE 4
+
+#define AMQP_CLASS_BASIC 60
+#define AMQP_CLASS_CONFIRM 85
+
+typedef socket_event_key amqp_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[AMQP_PAYLOAD_SIZE];
+} __attribute__((packed)) amqp_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/amqp_scratch_map") amqp_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(amqp_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a method frame, the key is composed in the direction of the packet.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(amqp_event_key),
+    .value_size = sizeof(amqp_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(amqp_payload, AMQP_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_tracked_method checks the methods of publishes, deliveries and their acknowledgements.
+static __always_inline bool is_tracked_method(__u16 class_id, __u16 method_id) {
+    if (class_id == AMQP_CLASS_CONFIRM) {
+        // confirm.select-ok resets the delivery tags of the channel
+        return method_id == 11;
+    }
+    if (class_id != AMQP_CLASS_BASIC) {
+        return false;
+    }
+    switch (method_id) {
+    case 20:  // consume
+    case 21:  // consume-ok
+    case 40:  // publish
+    case 60:  // deliver
+    case 70:  // get
+    case 71:  // get-ok
+    case 80:  // ack
+    case 90:  // reject
+    case 120: // nack
+        return true;
+    default:
+        return false;
+    }
+}
+
+SEC("socket")
+int socket__amqp_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + AMQP_FRAME_HEADER_SIZE + AMQP_METHOD_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[AMQP_FRAME_HEADER_SIZE + AMQP_METHOD_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (hdr[0] != AMQP_FRAME_METHOD) {
+        return 0;
+    }
+    __u32 size = ((__u32)hdr[3] << 24) | ((__u32)hdr[4] << 16) | ((__u32)hdr[5] << 8) | hdr[6];
+    __u16 class_id = ((__u16)hdr[7] << 8) | hdr[8];
+    __u16 method_id = ((__u16)hdr[9] << 8) | hdr[10];
+    if (size < AMQP_METHOD_HEADER_SIZE || !is_tracked_method(class_id, method_id)) {
+        return 0;
+    }
+    // the frame end octet is the strongest hint that this is amqp, check it when the frame fits in the packet.
+    __u32 end = offset + AMQP_FRAME_HEADER_SIZE + size;
+    if (end < skb->len) {
+        __u8 frame_end = 0;
+        if (bpf_skb_load_bytes(skb, end, &frame_end, sizeof(frame_end)) < 0 || frame_end != AMQP_FRAME_END) {
+            return 0;
+        }
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    amqp_event_t *event = bpf_map_lookup_elem(&amqp_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(amqp_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < AMQP_PAYLOAD_SIZE ? len : AMQP_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_amqp_payload(event->payload, skb, offset);
+
+    amqp_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,125 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// messages are decoded in user space, the rpc meta (service, method, correlation id) follows the header.
+#define BRPC_PAYLOAD_SIZE 512
+
+// baidu_std header: "PRPC", body size and meta size, the body is the meta, the payload and the attachment.
+// see brpc/policy/baidu_rpc_protocol.cpp
+#define BRPC_HEADER_SIZE 12
+
+typedef socket_event_key brpc_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[BRPC_PAYLOAD_SIZE];
+} __attribute__((packed)) brpc_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/brpc_scratch_map") brpc_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(brpc_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a baidu_std message, the key is composed in the direction of the packet.
+// clients multiplex their requests on a connection, requests and responses are paired by correlation id in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(brpc_event_key),
+    .value_size = sizeof(brpc_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(brpc_payload, BRPC_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_baidu_std checks the magic and the sizes of the header.
+static __always_inline bool is_baidu_std(const __u8 *buf) {
+    if (buf[0] != 'P' || buf[1] != 'R' || buf[2] != 'P' || buf[3] != 'C') {
+        return false;
+    }
+    __u32 body_size = ((__u32)buf[4] << 24) | ((__u32)buf[5] << 16) | ((__u32)buf[6] << 8) | buf[7];
+    __u32 meta_size = ((__u32)buf[8] << 24) | ((__u32)buf[9] << 16) | ((__u32)buf[10] << 8) | buf[11];
+    return meta_size > 0 && meta_size <= body_size;
+}
+
+SEC("socket")
+int socket__brpc_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + BRPC_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[BRPC_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (!is_baidu_std(hdr)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    brpc_event_t *event = bpf_map_lookup_elem(&brpc_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(brpc_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < BRPC_PAYLOAD_SIZE ? len : BRPC_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_brpc_payload(event->payload, skb, offset);
+
+    brpc_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,173 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// packets are decoded in user space, the layout of queries depends on the negotiated protocol revision.
+#define CLICKHOUSE_PAYLOAD_SIZE 512
+
+// packet types, see src/Core/Protocol.h of clickhouse
+#define CLICKHOUSE_CLIENT_QUERY 1
+#define CLICKHOUSE_SERVER_EXCEPTION 2
+#define CLICKHOUSE_SERVER_PROGRESS 3
+#define CLICKHOUSE_SERVER_END_OF_STREAM 5
+#define CLICKHOUSE_SERVER_PROFILE_INFO 6
+#define CLICKHOUSE_SERVER_LOG 10
+#define CLICKHOUSE_SERVER_PROFILE_EVENTS 14
+
+// query: type, query id (uuids or empty), query kind of the client info
+#define CLICKHOUSE_QUERY_HEADER_SIZE 66
+#define CLICKHOUSE_MAX_QUERY_ID 63
+// exception: type, code (little endian int32), name ("DB::Exception")
+#define CLICKHOUSE_EXCEPTION_HEADER_SIZE 8
+
+typedef socket_event_key clickhouse_event_key;
+
+typedef struct {
+    // the last byte of the packet, end of streams are the last packet of a response.
+    SOCKET_EVENT_HEADER(last_byte)
+    char payload[CLICKHOUSE_PAYLOAD_SIZE];
+} __attribute__((packed)) clickhouse_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/clickhouse_scratch_map") clickhouse_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(clickhouse_event_t),
+    .max_entries = 1,
+};
+
+// queries and the end of their responses, the key is composed in the direction of the packet.
+// Queries are not pipelined on a connection, they are paired with the next end of response in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(clickhouse_event_key),
+    .value_size = sizeof(clickhouse_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(clickhouse_payload, CLICKHOUSE_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_query checks the type and the query kind following the query id, data blocks of the server
+// (empty table name, block info fields 1 and 2) look alike and are left out.
+static __always_inline bool is_query(const __u8 *buf) {
+    if (buf[0] != CLICKHOUSE_CLIENT_QUERY || buf[1] > CLICKHOUSE_MAX_QUERY_ID) {
+        return false;
+    }
+    if (buf[1] == 0 && buf[2] == 1 && buf[3] == 0 && buf[4] == 2) {
+        return false;
+    }
+    // initial or secondary query
+    __u8 kind = buf[2 + (buf[1] & CLICKHOUSE_MAX_QUERY_ID)];
+    return kind == 1 || kind == 2;
+}
+
+// is_exception checks the code and the name of the exception.
+static __always_inline bool is_exception(const __u8 *buf) {
+    return buf[0] == CLICKHOUSE_SERVER_EXCEPTION && buf[3] == 0 && buf[4] == 0 && buf[6] == 'D' && buf[7] == 'B';
+}
+
+// is_response_end checks the packets sent at the end of a response, before the end of stream.
+static __always_inline bool is_response_end(__u8 first, __u8 last) {
+    if (last != CLICKHOUSE_SERVER_END_OF_STREAM) {
+        return false;
+    }
+    switch (first) {
+    case CLICKHOUSE_SERVER_PROGRESS:
+    case CLICKHOUSE_SERVER_END_OF_STREAM:
+    case CLICKHOUSE_SERVER_PROFILE_INFO:
+    case CLICKHOUSE_SERVER_LOG:
+    case CLICKHOUSE_SERVER_PROFILE_EVENTS:
+        return true;
+    default:
+        return false;
+    }
+}
+
+SEC("socket")
+int socket__clickhouse_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset >= skb->len) {
+        return 0;
+    }
+
+    __u8 first = 0, last = 0;
+    if (bpf_skb_load_bytes(skb, offset, &first, 1) < 0 || bpf_skb_load_bytes(skb, skb->len - 1, &last, 1) < 0) {
+        return 0;
+    }
+    bool matched = is_response_end(first, last);
+    if (!matched && first == CLICKHOUSE_CLIENT_QUERY && offset + CLICKHOUSE_QUERY_HEADER_SIZE <= skb->len) {
+        __u8 hdr[CLICKHOUSE_QUERY_HEADER_SIZE];
+        matched = bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) == 0 && is_query(hdr);
+    }
+    if (!matched && first == CLICKHOUSE_SERVER_EXCEPTION && offset + CLICKHOUSE_EXCEPTION_HEADER_SIZE <= skb->len) {
+        __u8 hdr[CLICKHOUSE_EXCEPTION_HEADER_SIZE];
+        matched = bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) == 0 && is_exception(hdr);
+    }
+    if (!matched) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    clickhouse_event_t *event = bpf_map_lookup_elem(&clickhouse_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(clickhouse_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < CLICKHOUSE_PAYLOAD_SIZE ? len : CLICKHOUSE_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    event->last_byte = last;
+    read_into_buffer_clickhouse_payload(event->payload, skb, offset);
+
+    clickhouse_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
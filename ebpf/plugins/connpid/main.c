@@ -0,0 +1,10 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <bpf/bpf_helpers.h>
+#include "../../include/bpf_endian.h"
+#include "../../include/common.h"
+// the kprobes of tcp_sendmsg, tcp_recvmsg and tcp_close record the pid of the tcp sockets of the node in
+// filtered_connections, the protocol plugins attribute the requests of the hostNetwork pods by it.
+#include "../../include/sock.h"
+
+char _license[] SEC("license") = "GPL";
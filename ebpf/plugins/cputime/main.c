@@ -0,0 +1,205 @@
+#include <linux/kconfig.h>
+#include <net/sock.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+
+#include "../../include/event_budget.h"
+
+// budget probe id of the samples
+#define CPU_SAMPLE_PROBE 1
+
+// on-cpu time of the threads that read from tcp connections, only these threads are accounted.
+struct task_cpu_t {
+    // on-cpu time up to the last switch out
+    __u64 total;
+    // switch in time, 0 while the thread is off cpu
+    __u64 since;
+};
+
+// connection as seen from the socket: local and remote address
+struct conn_key_t {
+    __u32 saddr;
+    __u32 daddr;
+    __u16 sport;
+    __u16 dport;
+};
+
+// request being served on a connection, from the first read to the response.
+struct request_t {
+    __u64 start;
+    __u64 cpu_start;
+    __u32 tid;
+    __u32 pad;
+};
+
+struct cpu_sample_t {
+    __u64 start;
+    __u64 end;
+    __u64 cpu;
+    __u32 saddr;
+    __u32 daddr;
+    __u16 sport;
+    __u16 dport;
+    __u32 tid;
+};
+
+struct bpf_map_def SEC("maps/task_cpu_map") task_cpu_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct task_cpu_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/request_map") request_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(struct conn_key_t),
+    .value_size = sizeof(struct request_t),
+    .max_entries = 1024 * 64,
+};
+
+// sockets of the tcp_recvmsg calls in progress, by thread.
+struct bpf_map_def SEC("maps/recv_sock_map") recv_sock_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct sock *),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/cpu_samples_map") cpu_samples_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(struct cpu_sample_t),
+    .max_entries = 1024 * 16,
+};
+
+// fields of the sched_switch tracepoint, see /sys/kernel/debug/tracing/events/sched/sched_switch/format
+struct sched_switch_args {
+    __u64 pad;
+    char prev_comm[16];
+    int prev_pid;
+    int prev_prio;
+    long long prev_state;
+    char next_comm[16];
+    int next_pid;
+    int next_prio;
+};
+
+static __always_inline bool read_conn_key(struct sock *sk, struct conn_key_t *key) {
+    __u16 family = 0;
+    BPF_PROBE_READ_INTO(&family, sk, __sk_common.skc_family);
+    if (family != AF_INET) {
+        return false;
+    }
+    BPF_PROBE_READ_INTO(&key->saddr, sk, __sk_common.skc_rcv_saddr);
+    BPF_PROBE_READ_INTO(&key->daddr, sk, __sk_common.skc_daddr);
+    BPF_PROBE_READ_INTO(&key->sport, sk, __sk_common.skc_num);
+    BPF_PROBE_READ_INTO(&key->dport, sk, __sk_common.skc_dport);
+    key->dport = bpf_ntohs(key->dport);
+    return true;
+}
+
+// cpu_now returns the on-cpu time of the current thread, it starts being accounted on its first read.
+static __always_inline __u64 cpu_now(__u32 tid, __u64 now) {
+    struct task_cpu_t *t = bpf_map_lookup_elem(&task_cpu_map, &tid);
+    if (t == NULL) {
+        struct task_cpu_t init = {.total = 0, .since = now};
+        bpf_map_update_elem(&task_cpu_map, &tid, &init, BPF_NOEXIST);
+        return 0;
+    }
+    if (t->since == 0) {
+        return t->total;
+    }
+    return t->total + now - t->since;
+}
+
+SEC("kprobe/tcp_recvmsg")
+int kprobe_tcp_recvmsg(struct pt_regs *ctx) {
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&recv_sock_map, &tid, &sk, BPF_ANY);
+    return 0;
+}
+
+// the request starts when data is read, blocking reads wait for the request before.
+SEC("kretprobe/tcp_recvmsg")
+int kretprobe_tcp_recvmsg(struct pt_regs *ctx) {
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    struct sock **skp = bpf_map_lookup_elem(&recv_sock_map, &tid);
+    if (skp == NULL) {
+        return 0;
+    }
+    struct sock *sk = *skp;
+    bpf_map_delete_elem(&recv_sock_map, &tid);
+    if ((int)PT_REGS_RC(ctx) <= 0) {
+        return 0;
+    }
+    struct conn_key_t key = {0};
+    if (!read_conn_key(sk, &key)) {
+        return 0;
+    }
+    // the following reads of the request belong to the same window
+    if (bpf_map_lookup_elem(&request_map, &key) != NULL) {
+        return 0;
+    }
+    __u64 now = bpf_ktime_get_ns();
+    struct request_t req = {0};
+    req.start = now;
+    req.cpu_start = cpu_now(tid, now);
+    req.tid = tid;
+    bpf_map_update_elem(&request_map, &key, &req, BPF_ANY);
+    return 0;
+}
+
+// the response ends the window, requests handed over to another thread are not attributed.
+SEC("kprobe/tcp_sendmsg")
+int kprobe_tcp_sendmsg(struct pt_regs *ctx) {
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    struct conn_key_t key = {0};
+    if (!read_conn_key(sk, &key)) {
+        return 0;
+    }
+    struct request_t *req = bpf_map_lookup_elem(&request_map, &key);
+    if (req == NULL) {
+        return 0;
+    }
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    __u64 now = bpf_ktime_get_ns();
+    struct cpu_sample_t sample = {0};
+    sample.start = req->start;
+    sample.end = now;
+    sample.cpu = cpu_now(tid, now) - req->cpu_start;
+    bool same_thread = req->tid == tid;
+    bpf_map_delete_elem(&request_map, &key);
+    if (!same_thread || !event_budget_allow(CPU_SAMPLE_PROBE)) {
+        return 0;
+    }
+    sample.saddr = key.saddr;
+    sample.daddr = key.daddr;
+    sample.sport = key.sport;
+    sample.dport = key.dport;
+    sample.tid = tid;
+    // the timestamp is unique enough as key, a collision only loses one sample.
+    bpf_map_update_elem(&cpu_samples_map, &now, &sample, BPF_ANY);
+    return 0;
+}
+
+SEC("tracepoint/sched/sched_switch")
+int tracepoint_sched_switch(struct sched_switch_args *ctx) {
+    __u64 now = bpf_ktime_get_ns();
+    __u32 prev = ctx->prev_pid;
+    __u32 next = ctx->next_pid;
+    struct task_cpu_t *t = bpf_map_lookup_elem(&task_cpu_map, &prev);
+    if (t != NULL && t->since != 0) {
+        t->total += now - t->since;
+        t->since = 0;
+    }
+    t = bpf_map_lookup_elem(&task_cpu_map, &next);
+    if (t != NULL) {
+        t->since = now;
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,136 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// messages are decoded in user space, the header and the question (name up to 255 bytes, type and class).
+#define DNS_PAYLOAD_SIZE 288
+
+// https://www.rfc-editor.org/rfc/rfc1035#section-4.1.1
+#define DNS_PORT 53
+#define DNS_HEADER_SIZE 12
+#define DNS_FLAG_QR 0x80
+// messages over tcp are prefixed with their length.
+#define DNS_TCP_PREFIX_SIZE 2
+
+typedef socket_event_key dns_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(tcp)
+    char payload[DNS_PAYLOAD_SIZE];
+} __attribute__((packed)) dns_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/dns_scratch_map") dns_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(dns_event_t),
+    .max_entries = 1,
+};
+
+// queries of the pod and responses to it, the key is composed in the direction of the packet.
+// resolvers send several queries (A and AAAA) on a socket, they are paired by id in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(dns_event_key),
+    .value_size = sizeof(dns_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(dns_payload, DNS_PAYLOAD_SIZE, BLK_SIZE)
+
+SEC("socket")
+int socket__dns_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (conn_tuple.sport != DNS_PORT && conn_tuple.dport != DNS_PORT) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u8 tcp = (conn_tuple.metadata & CONN_TYPE_TCP) ? 1 : 0;
+    __u32 offset = skb_info.data_off;
+    if (tcp) {
+        offset += DNS_TCP_PREFIX_SIZE;
+    }
+    if (offset + DNS_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[DNS_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    // a single question, see https://www.rfc-editor.org/rfc/rfc9619
+    if (hdr[4] != 0 || hdr[5] != 1) {
+        return 0;
+    }
+    bool response = (hdr[2] & DNS_FLAG_QR) != 0;
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        // queries sent by the pod, queries to a resolver pod (e.g. coredns) are recorded on the veth of the client.
+        if (response || conn_tuple.dport != DNS_PORT) {
+            return 0;
+        }
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) != NULL) {
+        if (!response || conn_tuple.sport != DNS_PORT) {
+            return 0;
+        }
+    } else {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    dns_event_t *event = bpf_map_lookup_elem(&dns_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(dns_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < DNS_PAYLOAD_SIZE ? len : DNS_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    event->tcp = tcp;
+    read_into_buffer_dns_payload(event->payload, skb, offset);
+
+    dns_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
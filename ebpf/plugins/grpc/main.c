@@ -0,0 +1,319 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
+
+#define GRPC_HEADER_BLOCK_SIZE 128
+// the beginning of the first request message: 1 byte compressed flag, 4 bytes length and the protobuf payload.
+#define GRPC_MESSAGE_SIZE 128
+#define GRPC_MAX_FRAMES_PER_PACKET 8
+
+#define HTTP2_FLAG_END_STREAM 0x1
+#define HTTP2_FLAG_PADDED 0x8
+#define HTTP2_FLAG_PRIORITY 0x20
+
+typedef enum {
+    GRPC_DIRECTION_UNKNOWN,
+    GRPC_DIRECTION_REQUEST,
+    GRPC_DIRECTION_RESPONSE,
+} grpc_direction_t;
+
+// stream key is always composed in the client -> server direction.
+typedef struct {
+    sock_key conn;
+    __u32 stream_id;
+} grpc_stream_key;
+
+typedef struct {
+    __u64 request_ts;
+    __u64 duration;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    __u32 request_bytes;
+    __u32 response_bytes;
+    __u16 request_header_len;
+    __u16 response_header_len;
+    __u16 trailer_len;
+    __u16 request_message_len;
+    __u8 flags;
+    char request_headers[GRPC_HEADER_BLOCK_SIZE];
+    char response_headers[GRPC_HEADER_BLOCK_SIZE];
+    char trailers[GRPC_HEADER_BLOCK_SIZE];
+    char request_message[GRPC_MESSAGE_SIZE];
+} __attribute__((packed)) grpc_stream_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+// request messages are only captured when field extraction is configured, key 0 is set to 1 by user space.
+struct bpf_map_def SEC("maps/grpc_capture_map") grpc_capture_map = {
+    .type = BPF_MAP_TYPE_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = 1,
+};
+
+// client -> server connections that were confirmed to speak HTTP/2.
+struct bpf_map_def SEC("maps/grpc_conn_map") grpc_conn_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(__u8),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/grpc_processing_map") grpc_processing_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(grpc_stream_key),
+    .value_size = sizeof(grpc_stream_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/grpc_scratch_map") grpc_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(grpc_stream_t),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(grpc_stream_key),
+    .value_size = sizeof(grpc_stream_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(grpc_header_block, GRPC_HEADER_BLOCK_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(grpc_message, GRPC_MESSAGE_SIZE, BLK_SIZE)
+
+static __always_inline void compose_sock_key(sock_key *k, conn_tuple_t *tup, bool reverse) {
+    if (reverse) {
+        k->srcIP = tup->daddr_l;
+        k->dstIP = tup->saddr_l;
+        k->srcPort = tup->dport;
+        k->dstPort = tup->sport;
+        return;
+    }
+    k->srcIP = tup->saddr_l;
+    k->dstIP = tup->daddr_l;
+    k->srcPort = tup->sport;
+    k->dstPort = tup->dport;
+}
+
+// guess_direction classifies a header block by its first field when the connection preface was not observed,
+// e.g. the connection was established before the agent started.
+// HPACK static table: 2,3 => :method, 4,5 => :path, 8-14 => :status.
+static __always_inline grpc_direction_t guess_direction(struct __sk_buff *skb, __u32 offset) {
+    field_index idx;
+    if (bpf_skb_load_bytes(skb, offset, &idx.raw, sizeof(idx.raw)) < 0) {
+        return GRPC_DIRECTION_UNKNOWN;
+    }
+    __u8 index = is_indexed(idx.raw) ? idx.indexed.index : idx.literal.index;
+    if (index >= 2 && index <= 5) {
+        return GRPC_DIRECTION_REQUEST;
+    }
+    if (index >= 8 && index <= 14) {
+        return GRPC_DIRECTION_RESPONSE;
+    }
+    return GRPC_DIRECTION_UNKNOWN;
+}
+
+static __always_inline __u16 header_block_len(__u32 length) {
+    return length < GRPC_HEADER_BLOCK_SIZE ? length : GRPC_HEADER_BLOCK_SIZE;
+}
+
+static __always_inline void handle_request_headers(struct __sk_buff *skb, grpc_stream_key *key, __u32 offset, __u32 length) {
+    __u32 zero = 0;
+    grpc_stream_t *stream = bpf_map_lookup_elem(&grpc_scratch_map, &zero);
+    if (!stream) {
+        return;
+    }
+    bpf_memset(stream, 0, sizeof(grpc_stream_t));
+    stream->request_ts = bpf_ktime_get_ns();
+    stream->cookie = bpf_get_socket_cookie(skb);
+    stream->request_header_len = header_block_len(length);
+    read_into_buffer_grpc_header_block(stream->request_headers, skb, offset);
+    bpf_map_update_elem(&grpc_processing_map, key, stream, BPF_ANY);
+}
+
+static __always_inline void complete_stream(grpc_stream_key *key, grpc_stream_t *stream) {
+    __u64 duration = bpf_ktime_get_ns() - stream->request_ts;
+    if (duration > 0) {
+        stream->duration = duration;
+    }
+    bpf_map_update_elem(&metrics_map, key, stream, BPF_ANY);
+    bpf_map_delete_elem(&grpc_processing_map, key);
+}
+
+static __always_inline void handle_response_headers(struct __sk_buff *skb, grpc_stream_key *key, __u32 offset, __u32 length, __u8 flags) {
+    grpc_stream_t *stream = bpf_map_lookup_elem(&grpc_processing_map, key);
+    if (!stream) {
+        return;
+    }
+    stream->flags |= flags;
+    if (stream->response_header_len == 0) {
+        stream->response_header_len = header_block_len(length);
+        read_into_buffer_grpc_header_block(stream->response_headers, skb, offset);
+    } else {
+        stream->trailer_len = header_block_len(length);
+        read_into_buffer_grpc_header_block(stream->trailers, skb, offset);
+    }
+    if (flags & HTTP2_FLAG_END_STREAM) {
+        complete_stream(key, stream);
+    }
+}
+
+static __always_inline bool capture_enabled() {
+    __u32 zero = 0;
+    __u32 *enabled = bpf_map_lookup_elem(&grpc_capture_map, &zero);
+    return enabled && *enabled;
+}
+
+static __always_inline void handle_data(struct __sk_buff *skb, grpc_stream_key *key, grpc_direction_t direction,
+                                        __u32 offset, __u32 length, __u8 flags) {
+    grpc_stream_t *stream = bpf_map_lookup_elem(&grpc_processing_map, key);
+    if (!stream) {
+        return;
+    }
+    if (direction == GRPC_DIRECTION_REQUEST) {
+        if (stream->request_bytes == 0 && capture_enabled()) {
+            if (flags & HTTP2_FLAG_PADDED) {
+                __u8 pad_len = 0;
+                bpf_skb_load_bytes(skb, offset, &pad_len, sizeof(pad_len));
+                offset += 1;
+                length = length > (pad_len + 1) ? length - pad_len - 1 : 0;
+            }
+            stream->request_message_len = length < GRPC_MESSAGE_SIZE ? length : GRPC_MESSAGE_SIZE;
+            read_into_buffer_grpc_message(stream->request_message, skb, offset);
+        }
+        stream->request_bytes += length;
+        return;
+    }
+    stream->response_bytes += length;
+    // plain HTTP/2 responses may end with a DATA frame instead of trailers.
+    if (flags & HTTP2_FLAG_END_STREAM) {
+        stream->flags |= flags;
+        complete_stream(key, stream);
+    }
+}
+
+SEC("socket")
+int socket__grpc_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    if (skb_info.data_off >= skb->len) {
+        return 0;
+    }
+
+    sock_key forward = {0};
+    sock_key reverse = {0};
+    compose_sock_key(&forward, &conn_tuple, false);
+    compose_sock_key(&reverse, &conn_tuple, true);
+
+    // the connection preface is only sent by the client.
+    __u32 data_off = skb_info.data_off;
+    check_and_skip_magic(skb, &skb_info);
+    grpc_direction_t direction = GRPC_DIRECTION_UNKNOWN;
+    __u8 confirmed = 1;
+    if (skb_info.data_off != data_off) {
+        bpf_map_update_elem(&grpc_conn_map, &forward, &confirmed, BPF_ANY);
+        direction = GRPC_DIRECTION_REQUEST;
+    } else if (bpf_map_lookup_elem(&grpc_conn_map, &forward) != NULL) {
+        direction = GRPC_DIRECTION_REQUEST;
+    } else if (bpf_map_lookup_elem(&grpc_conn_map, &reverse) != NULL) {
+        direction = GRPC_DIRECTION_RESPONSE;
+    }
+
+    char frame_buf[HTTP2_FRAME_HEADER_SIZE];
+    struct http2_frame frame;
+    __u32 offset = skb_info.data_off;
+
+#pragma unroll(GRPC_MAX_FRAMES_PER_PACKET)
+    for (__u8 i = 0; i < GRPC_MAX_FRAMES_PER_PACKET; i++) {
+        if (offset + HTTP2_FRAME_HEADER_SIZE > skb->len) {
+            break;
+        }
+        bpf_skb_load_bytes(skb, offset, frame_buf, HTTP2_FRAME_HEADER_SIZE);
+        if (!read_http2_frame_header(frame_buf, HTTP2_FRAME_HEADER_SIZE, &frame)) {
+            break;
+        }
+        offset += HTTP2_FRAME_HEADER_SIZE;
+
+        // stream 0 carries connection level frames (SETTINGS, PING, GOAWAY ...)
+        if (frame.stream_id == 0 || (frame.type != kHeadersFrame && frame.type != kDataFrame)) {
+            offset += frame.length;
+            continue;
+        }
+
+        __u32 block_off = offset;
+        __u32 block_len = frame.length;
+        if (frame.type == kHeadersFrame) {
+            if (frame.flags & HTTP2_FLAG_PADDED) {
+                __u8 pad_len = 0;
+                bpf_skb_load_bytes(skb, block_off, &pad_len, sizeof(pad_len));
+                block_off += 1;
+                block_len = block_len > (pad_len + 1) ? block_len - pad_len - 1 : 0;
+            }
+            if (frame.flags & HTTP2_FLAG_PRIORITY) {
+                block_off += 5;
+                block_len = block_len > 5 ? block_len - 5 : 0;
+            }
+            if (direction == GRPC_DIRECTION_UNKNOWN) {
+                direction = guess_direction(skb, block_off);
+                if (direction == GRPC_DIRECTION_REQUEST) {
+                    bpf_map_update_elem(&grpc_conn_map, &forward, &confirmed, BPF_ANY);
+                } else if (direction == GRPC_DIRECTION_RESPONSE) {
+                    bpf_map_update_elem(&grpc_conn_map, &reverse, &confirmed, BPF_ANY);
+                }
+            }
+        }
+        if (direction == GRPC_DIRECTION_UNKNOWN) {
+            break;
+        }
+
+        grpc_stream_key key = {0};
+        key.conn = direction == GRPC_DIRECTION_REQUEST ? forward : reverse;
+        key.stream_id = frame.stream_id;
+
+        if (frame.type == kHeadersFrame && direction == GRPC_DIRECTION_REQUEST) {
+            // only record requests issued by the pod attached to this veth, the peer veth records the other side.
+            if (bpf_map_lookup_elem(&filter_map, &key.conn.srcIP) != NULL) {
+                handle_request_headers(skb, &key, block_off, block_len);
+            }
+        } else if (frame.type == kHeadersFrame) {
+            handle_response_headers(skb, &key, block_off, block_len, frame.flags);
+        } else {
+            handle_data(skb, &key, direction, offset, frame.length, frame.flags);
+        }
+
+        offset += frame.length;
+    }
+
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -17,6 +17,10 @@ static __always_inline bool is_drop_packet(conn_tuple_t *conn_tuple) {
         return true;
     }
 
+    if (is_excluded_port(conn_tuple)) {
+        return true;
+    }
+
     return false;
 }
 
@@ -35,6 +39,8 @@ int socket__filter_package(struct __sk_buff *skb) {
         return 0;
     }
 
+    track_connect(&conn_tuple, skb_info.tcp_flags);
+
     // read http info
     read_http_info(skb, &conn_tuple, skb_info.data_off);
     return 0;
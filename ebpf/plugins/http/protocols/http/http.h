@@ -13,13 +13,14 @@
 #include "../../../../include/bpf_traffic_helpers.h"
 #include "../../../../include/common.h"
 #include "../../../../include/protocol.h"
+#include "../../../../include/port_exclusion.h"
 #include "./types.h"
 
 struct bpf_map_def SEC("maps/filter_map") filter_map = {
     .type = BPF_MAP_TYPE_HASH,
     .key_size = sizeof(__u32),
 	.value_size = sizeof(__u32),
-	.max_entries = 1,
+	.max_entries = FILTER_MAX_PODS,
 };
 
 struct bpf_map_def SEC("maps/http_processing_map") http_processing_map = {
@@ -29,6 +30,29 @@ struct bpf_map_def SEC("maps/http_processing_map") http_processing_map = {
     .max_entries = 1024 * 16,
 };
 
+// handshakes of the connections opened by the pod, key is composed in the client -> server direction.
+struct bpf_map_def SEC("maps/http_conn_map") http_conn_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(http_conn_t),
+    .max_entries = 1024 * 16,
+};
+
+// bodies of the POST requests, key is composed in the client -> server direction like the requests.
+struct bpf_map_def SEC("maps/http_body_map") http_body_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(http_body_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/http_body_scratch_map") http_body_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(http_body_t),
+    .max_entries = 1,
+};
+
 struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
     .type = BPF_MAP_TYPE_HASH,
     .key_size = sizeof(sock_key),
@@ -36,6 +60,48 @@ struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
 	.max_entries = 1024 * 16,
 };
 
+// set to 1 by user space when headers are allowlisted.
+struct bpf_map_def SEC("maps/http_headers_config_map") http_headers_config_map = {
+    .type = BPF_MAP_TYPE_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = 1,
+};
+
+// heads of the requests and responses, key is composed in the client -> server direction like the requests.
+struct bpf_map_def SEC("maps/http_headers_map") http_headers_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(http_headers_t),
+    .max_entries = 1024 * 4,
+};
+
+struct bpf_map_def SEC("maps/http_headers_scratch_map") http_headers_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(http_headers_t),
+    .max_entries = 1,
+};
+
+// PROXY protocol headers of the connections to the pod, key is composed in the client -> server direction.
+struct bpf_map_def SEC("maps/http_proxy_map") http_proxy_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(http_proxy_t),
+    .max_entries = 1024 * 4,
+};
+
+struct bpf_map_def SEC("maps/http_proxy_scratch_map") http_proxy_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(http_proxy_t),
+    .max_entries = 1,
+};
+
+READ_INTO_BUFFER(http_body, HTTP_BODY_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(http_proxy, HTTP_PROXY_HEADER_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(http_headers, HTTP_HEADERS_SIZE, BLK_SIZE)
+
 static __always_inline __u8 char_to_u8(char c) {
     if (c < '0' || c > '9')
         return -1;
@@ -182,9 +248,214 @@ static __always_inline void compose_conn_key(sock_key *k, conn_tuple_t *conn_tup
     return;
 }
 
+// capture_side finds the end of the request the pod of the veth is, key is composed in the client -> server
+// direction. It returns false for the requests passing by, e.g. of the pods sharing the network of the node.
+static __always_inline bool capture_side(sock_key *key, http_side_t *side) {
+    if (bpf_map_lookup_elem(&filter_map, &key->srcIP) != NULL) {
+        *side = HTTP_SIDE_CLIENT;
+        return true;
+    }
+    if (bpf_map_lookup_elem(&filter_map, &key->dstIP) != NULL) {
+        *side = HTTP_SIDE_SERVER;
+        return true;
+    }
+    return false;
+}
+
+// skip_proxy_header records the PROXY protocol header starting the payload of a connection to the pod and
+// moves offset past it, the first request may follow it in the same packet.
+static __always_inline void skip_proxy_header(struct __sk_buff *skb, conn_tuple_t *conn_tuple, __u32 *offset) {
+    char sig[12];
+    if (skb->len < *offset + sizeof(sig) || bpf_skb_load_bytes(skb, *offset, sig, sizeof(sig)) < 0) {
+        return;
+    }
+    bool v1 = sig[0] == 'P' && sig[1] == 'R' && sig[2] == 'O' && sig[3] == 'X' && sig[4] == 'Y' && sig[5] == ' ';
+    // \r\n\r\n\0\r\nQUIT\n
+    bool v2 = sig[0] == '\r' && sig[1] == '\n' && sig[2] == '\r' && sig[3] == '\n' && sig[4] == 0 && sig[5] == '\r' &&
+              sig[6] == '\n' && sig[7] == 'Q' && sig[8] == 'U' && sig[9] == 'I' && sig[10] == 'T' && sig[11] == '\n';
+    if (!v1 && !v2) {
+        return;
+    }
+    sock_key key = {};
+    compose_conn_key(&key, conn_tuple, HTTP_REQUEST);
+    if (bpf_map_lookup_elem(&filter_map, &key.dstIP) == NULL) {
+        return;
+    }
+    __u32 zero = 0;
+    http_proxy_t *proxy = bpf_map_lookup_elem(&http_proxy_scratch_map, &zero);
+    if (!proxy) {
+        return;
+    }
+    bpf_memset(proxy, 0, sizeof(http_proxy_t));
+    read_into_buffer_http_proxy(proxy->header, skb, *offset);
+    __u32 len = 0;
+    if (v1) {
+#pragma unroll
+        for (int i = 0; i < HTTP_PROXY_V1_MAX_SIZE; i++) {
+            if (proxy->header[i] == '\n') {
+                len = i + 1;
+                break;
+            }
+        }
+        if (len == 0) {
+            return;
+        }
+    } else {
+        len = HTTP_PROXY_V2_PREFIX_SIZE + (((__u8)proxy->header[14] << 8) | (__u8)proxy->header[15]);
+    }
+    bpf_map_update_elem(&http_proxy_map, &key, proxy, BPF_ANY);
+    *offset += len;
+}
+
+// track_connect times the tcp handshake of the connections opened by the pod, from its SYN to the SYN-ACK.
+static __always_inline void track_connect(conn_tuple_t *conn_tuple, __u8 tcp_flags) {
+    __u8 syn = tcp_flags & (TCP_FLAG_SYN | TCP_FLAG_ACK);
+    if (syn == TCP_FLAG_SYN) {
+        sock_key key = {};
+        compose_conn_key(&key, conn_tuple, HTTP_REQUEST);
+        // a new connection on the tuple, the PROXY header of the previous one is stale.
+        bpf_map_delete_elem(&http_proxy_map, &key);
+        if (bpf_map_lookup_elem(&filter_map, &key.srcIP) == NULL) {
+            return;
+        }
+        // retransmitted SYNs are part of the handshake
+        if (bpf_map_lookup_elem(&http_conn_map, &key) != NULL) {
+            return;
+        }
+        http_conn_t conn = {0};
+        conn.syn_ts = bpf_ktime_get_ns();
+        bpf_map_update_elem(&http_conn_map, &key, &conn, BPF_ANY);
+    } else if (syn == (TCP_FLAG_SYN | TCP_FLAG_ACK)) {
+        sock_key key = {};
+        compose_conn_key(&key, conn_tuple, HTTP_RESPONSE);
+        http_conn_t *conn = bpf_map_lookup_elem(&http_conn_map, &key);
+        if (conn && conn->connect_duration == 0) {
+            conn->connect_duration = bpf_ktime_get_ns() - conn->syn_ts;
+        }
+    }
+}
+
+// is_chunked looks for the chunked transfer coding in the start of the response, the heads are not parsed:
+// the value is only looked for in the payload loaded with the status.
+static __always_inline bool is_chunked(char const *payload) {
+#pragma unroll
+    for (int i = 0; i < HTTP_PAYLOAD_SIZE - 9; i++) {
+        if (payload[i] == 'c' && payload[i + 1] == 'h' && payload[i + 2] == 'u' && payload[i + 3] == 'n' &&
+            payload[i + 4] == 'k' && payload[i + 5] == 'e' && payload[i + 6] == 'd' &&
+            payload[i + 7] == '\r' && payload[i + 8] == '\n') {
+            return true;
+        }
+    }
+    return false;
+}
+
+// is_last_chunk reports whether the packet ends with the last chunk of a chunked body.
+static __always_inline bool is_last_chunk(struct __sk_buff *skb, __u32 offset) {
+    if (skb->len < offset + HTTP_LAST_CHUNK_SIZE) {
+        return false;
+    }
+    char end[HTTP_LAST_CHUNK_SIZE];
+    if (bpf_skb_load_bytes(skb, skb->len - HTTP_LAST_CHUNK_SIZE, end, HTTP_LAST_CHUNK_SIZE) < 0) {
+        return false;
+    }
+    return end[0] == '0' && end[1] == '\r' && end[2] == '\n' && end[3] == '\r' && end[4] == '\n';
+}
+
+// track_body records the end of the first packet of a POST request, it holds the body of small requests.
+static __always_inline void track_body(struct __sk_buff *skb, sock_key *key, __u32 offset, http_method_t method) {
+    if (method != HTTP_POST) {
+        bpf_map_delete_elem(&http_body_map, key);
+        return;
+    }
+    __u32 zero = 0;
+    http_body_t *body = bpf_map_lookup_elem(&http_body_scratch_map, &zero);
+    if (!body) {
+        return;
+    }
+    bpf_memset(body, 0, sizeof(http_body_t));
+    if (skb->len - offset > HTTP_BODY_SIZE) {
+        offset = skb->len - HTTP_BODY_SIZE;
+    }
+    read_into_buffer_http_body(body->packet, skb, offset);
+    bpf_map_update_elem(&http_body_map, key, body, BPF_ANY);
+}
+
+// track_segment records the time of the segments following the first packet of a request or a response,
+// and the start of the body of POST requests sent apart from their headers.
+static __always_inline void track_segment(struct __sk_buff *skb, conn_tuple_t *conn_tuple, __u32 offset) {
+    sock_key key = {};
+    compose_conn_key(&key, conn_tuple, HTTP_REQUEST);
+    // the maps only hold the requests of the pod of the veth, whichever end of them it is.
+    http_info_t *request = bpf_map_lookup_elem(&http_processing_map, &key);
+    if (request) {
+        request->request_end_ts = bpf_ktime_get_ns();
+        http_body_t *body = bpf_map_lookup_elem(&http_body_map, &key);
+        if (body && !body->has_segment) {
+            read_into_buffer_http_body(body->segment, skb, offset);
+            body->has_segment = 1;
+        }
+        return;
+    }
+    compose_conn_key(&key, conn_tuple, HTTP_RESPONSE);
+    // the response stays in the metrics map until user space reads it.
+    http_info_t *response = bpf_map_lookup_elem(&metrics_map, &key);
+    if (response && !response->response_complete) {
+        response->response_end_ts = bpf_ktime_get_ns();
+        response->response_bytes += skb->len - offset;
+        if (response->chunked && is_last_chunk(skb, offset)) {
+            response->response_complete = 1;
+        }
+    }
+}
+
+// track_response records the end of the first packet of the response to a POST request.
+static __always_inline void track_response(struct __sk_buff *skb, sock_key *key, __u32 offset) {
+    http_body_t *body = bpf_map_lookup_elem(&http_body_map, key);
+    if (!body) {
+        return;
+    }
+    if (skb->len - offset > HTTP_BODY_SIZE) {
+        offset = skb->len - HTTP_BODY_SIZE;
+    }
+    read_into_buffer_http_body(body->response, skb, offset);
+    body->has_response = 1;
+}
+
+// track_headers records the head of a request, up to HTTP_HEADERS_SIZE bytes of its first packet.
+static __always_inline void track_headers(struct __sk_buff *skb, sock_key *key, __u32 offset) {
+    __u32 zero = 0;
+    __u32 *enabled = bpf_map_lookup_elem(&http_headers_config_map, &zero);
+    if (!enabled || !*enabled) {
+        return;
+    }
+    http_headers_t *headers = bpf_map_lookup_elem(&http_headers_scratch_map, &zero);
+    if (!headers) {
+        return;
+    }
+    bpf_memset(headers, 0, sizeof(http_headers_t));
+    read_into_buffer_http_headers(headers->request, skb, offset);
+    bpf_map_update_elem(&http_headers_map, key, headers, BPF_ANY);
+}
+
+// track_response_headers records the head of the response to a request whose head was recorded.
+static __always_inline void track_response_headers(struct __sk_buff *skb, sock_key *key, __u32 offset) {
+    http_headers_t *headers = bpf_map_lookup_elem(&http_headers_map, key);
+    if (!headers) {
+        return;
+    }
+    read_into_buffer_http_headers(headers->response, skb, offset);
+    headers->has_response = 1;
+}
+
 static __always_inline void read_http_info(struct __sk_buff *skb, conn_tuple_t *conn_tuple, __u32 offset) {
     http_info_t http_info = {0};
 
+    // the connections of L4 load balancers may start with a PROXY protocol header.
+    skip_proxy_header(skb, conn_tuple, &offset);
+    if (offset >= skb->len) {
+        return;
+    }
+
     // Load payload prefix
     http_method_t method=HTTP_METHOD_UNKNOWN;
     http_phase_t phase=HTTP_PHASE_UNKNOWN;
@@ -202,19 +473,34 @@ static __always_inline void read_http_info(struct __sk_buff *skb, conn_tuple_t *
     // Logical processing based on the phase.
     switch (phase) {
         case HTTP_REQUEST: {
-            if (bpf_map_lookup_elem(&filter_map, &conn_key.srcIP) == NULL) {
+            // payloads without a method continue a request or a response.
+            if (method == HTTP_METHOD_UNKNOWN) {
+                track_segment(skb, conn_tuple, offset);
                 return;
             }
 
-            if (method == HTTP_METHOD_UNKNOWN) {
+            http_side_t side = HTTP_SIDE_CLIENT;
+            if (!capture_side(&conn_key, &side)) {
                 return;
             }
-            
+
             http_info.method = method;
+            http_info.side = side;
             __u64 start_ts = bpf_ktime_get_ns();
             http_info.request_ts = start_ts;
+            http_info.request_end_ts = start_ts;
+            if (side == HTTP_SIDE_CLIENT) {
+                http_info.cookie = bpf_get_socket_cookie(skb);
+            }
+            http_conn_t *conn = bpf_map_lookup_elem(&http_conn_map, &conn_key);
+            if (conn) {
+                http_info.connect_duration = conn->connect_duration;
+                bpf_map_delete_elem(&http_conn_map, &conn_key);
+            }
             // Update process map.
             bpf_map_update_elem(&http_processing_map, &conn_key, &http_info, BPF_ANY);
+            track_body(skb, &conn_key, offset, method);
+            track_headers(skb, &conn_key, offset);
             break;
         }
         case HTTP_RESPONSE: {
@@ -223,12 +509,20 @@ static __always_inline void read_http_info(struct __sk_buff *skb, conn_tuple_t *
                 return;
             }
 
-            __u64 duration = bpf_ktime_get_ns() - http_processing->request_ts;
+            __u64 response_ts = bpf_ktime_get_ns();
+            __u64 duration = response_ts - http_processing->request_ts;
             if (duration > 0) {
                 http_processing->duration = duration;
             }
+            http_processing->response_ts = response_ts;
+            http_processing->response_end_ts = response_ts;
 
             http_processing->status_code = read_status_code(payload);
+            http_processing->response_bytes = skb->len - offset;
+            http_processing->chunked = is_chunked(payload);
+            http_processing->response_complete = http_processing->chunked && is_last_chunk(skb, offset);
+            track_response(skb, &conn_key, offset);
+            track_response_headers(skb, &conn_key, offset);
             // Cleanup.
             bpf_map_delete_elem(&http_processing_map, &conn_key);
 
@@ -237,6 +531,10 @@ static __always_inline void read_http_info(struct __sk_buff *skb, conn_tuple_t *
             break;
         }
         case HTTP_PHASE_UNKNOWN:
+            // short segments, e.g. the last chunk of a chunked response.
+            if (skb->len > offset) {
+                track_segment(skb, conn_tuple, offset);
+            }
             return;
         default:
             return;
@@ -2,6 +2,23 @@
 #define HTTP_PAYLOAD_BLOCK_SIZE 16
 #define HTTP_STATUS_OFFSET 9
 #define HTTP_PAYLOAD_PREFIX_SIZE 9
+// the start of the bodies of POST requests, e.g. the operation of GraphQL requests,
+// and the end of the first packet of their responses.
+#define HTTP_BODY_SIZE 256
+// the heads of the requests and of their responses, the allowlisted headers are taken from them in user space.
+#define HTTP_HEADERS_SIZE 512
+
+// the PROXY protocol header of the connections of L4 load balancers: v1 lines have at most 107 bytes,
+// the addresses of the v2 headers are in their first 52 bytes (TCP over IPv6).
+#define HTTP_PROXY_HEADER_SIZE 112
+#define HTTP_PROXY_V1_MAX_SIZE 107
+#define HTTP_PROXY_V2_PREFIX_SIZE 16
+
+// the last chunk of a chunked body without trailers: 0\r\n\r\n
+#define HTTP_LAST_CHUNK_SIZE 5
+
+#define TCP_FLAG_SYN 0x02
+#define TCP_FLAG_ACK 0x10
 
 typedef enum {
     HTTP_PHASE_UNKNOWN,
@@ -9,6 +26,12 @@ typedef enum {
 	HTTP_RESPONSE,
 } http_phase_t;
 
+// the end of the requests the pod of the veth is, the requests are recorded on the veths of both ends.
+typedef enum {
+    HTTP_SIDE_CLIENT,
+    HTTP_SIDE_SERVER,
+} http_side_t;
+
 typedef enum {
     HTTP_METHOD_UNKNOWN,
     HTTP_GET,
@@ -27,4 +50,49 @@ typedef struct {
     __u16 status_code;
     __u8 method;
     char request_fragment[HTTP_PAYLOAD_SIZE];
+    // tcp handshake of the connection, only set for its first request.
+    __u64 connect_duration;
+    // last segment of the request, first and last packet of the response.
+    __u64 request_end_ts;
+    __u64 response_ts;
+    __u64 response_end_ts;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    // http_side_t of the pod of the veth.
+    __u8 side;
+    // the payload of the response packets, the head included.
+    __u32 response_bytes;
+    // set when the response is chunked (Transfer-Encoding: chunked in its first packet), response_complete
+    // once its last chunk is seen.
+    __u8 chunked;
+    __u8 response_complete;
 } __attribute__((packed)) http_info_t;
+
+// the body of a POST request, parsed in user space with the request.
+typedef struct {
+    // the end of the first packet of the request, the headers precede the body if it is shorter.
+    char packet[HTTP_BODY_SIZE];
+    // the start of the next segment of the request, the body sent apart from the headers.
+    char segment[HTTP_BODY_SIZE];
+    __u8 has_segment;
+    // the end of the first packet of the response, it holds the body of small responses (e.g. errors).
+    char response[HTTP_BODY_SIZE];
+    __u8 has_response;
+} __attribute__((packed)) http_body_t;
+
+// the heads of a request and of its response, only captured while headers are allowlisted.
+typedef struct {
+    char request[HTTP_HEADERS_SIZE];
+    char response[HTTP_HEADERS_SIZE];
+    __u8 has_response;
+} __attribute__((packed)) http_headers_t;
+
+typedef struct {
+    __u64 syn_ts;
+    __u64 connect_duration;
+} http_conn_t;
+
+// the PROXY protocol header starting a connection, decoded in user space.
+typedef struct {
+    char header[HTTP_PROXY_HEADER_SIZE];
+} http_proxy_t;
@@ -11,6 +11,7 @@
 #include "../../include/map-defs.h"
 #include "../../include/parsing-maps.h"
 #include "../../include/port_range.h"
+#include "../../include/port_exclusion.h"
 #include "../../include/usm-events.h"
 #include "../../include/kafka_classification.h"
 
@@ -103,7 +104,8 @@ static __always_inline u32 kafka_get_next_tcp_seq(skb_info_t *skb_info) {
     return next_seq;
 }
 
-static __always_inline void kafka_batch_enqueue_wrapper(kafka_info_t *kafka, conn_tuple_t *tup, kafka_transaction_t *transaction) {
+// responded is false for the produce requests with acks=0, which are recorded with a latency of 0.
+static __always_inline void kafka_batch_enqueue_wrapper(kafka_info_t *kafka, conn_tuple_t *tup, kafka_transaction_t *transaction, bool responded) {
     kafka_event_t *event = &kafka->event;
 
     bpf_memcpy(&event->tup, tup, sizeof(conn_tuple_t));
@@ -112,18 +114,51 @@ static __always_inline void kafka_batch_enqueue_wrapper(kafka_info_t *kafka, con
     if (transaction != &event->transaction) {
         bpf_memcpy(&event->transaction, transaction, sizeof(kafka_transaction_t));
     }
+    // tup is the response direction, events are keyed client -> broker.
     sock_key key = {0};
-    key.dstIP = tup->daddr_l;
-    key.dstPort = tup->dport;
-    key.srcIP = tup->saddr_l;
-    key.srcPort = tup->sport;
-    transaction->request_started = bpf_ktime_get_ns() - transaction->request_started;
+    key.dstIP = tup->saddr_l;
+    key.dstPort = tup->sport;
+    key.srcIP = tup->daddr_l;
+    key.srcPort = tup->dport;
+    // request_started holds the latency from here on.
+    transaction->request_started = responded ? bpf_ktime_get_ns() - transaction->request_started : 0;
     bpf_map_update_elem(&kafka_event, &key, transaction, BPF_ANY);
 
 //    bpf_printk("wrapper enqueue, records_count %d", event->transaction.records_count);
     kafka_batch_enqueue(event);
 }
 
+// kafka_read_produce_error reads the error_code of the first partition of the first topic of a produce response,
+// offset is the end of its correlation_id. The error code is left 0 if the fields are beyond the packet.
+static __always_inline void kafka_read_produce_error(struct __sk_buff *skb, u32 offset, kafka_transaction_t *transaction) {
+    bool flexible = transaction->request_api_version >= 9;
+    if (flexible) {
+        offset += sizeof(u8); // Skip the tagged fields of the response header
+        // compact arrays and strings are prefixed by an unsigned varint of their length + 1, the topic counts and
+        // the names of the topics are shorter than 128 and encoded in a byte.
+        s8 topic_name_size = 0;
+        offset += sizeof(u8); // Skip the number of topics
+        if (!read_big_endian_s8(skb, offset, &topic_name_size) || topic_name_size <= 1) {
+            return;
+        }
+        offset += sizeof(u8) + topic_name_size - 1;
+        offset += sizeof(u8); // Skip the number of partitions
+    } else {
+        s16 topic_name_size = 0;
+        offset += sizeof(s32); // Skip the number of topics
+        if (!read_big_endian_s16(skb, offset, &topic_name_size) || topic_name_size <= 0) {
+            return;
+        }
+        offset += sizeof(s16) + topic_name_size;
+        offset += sizeof(s32); // Skip the number of partitions
+    }
+    offset += sizeof(s32); // Skip partition_index
+    s16 error_code = 0;
+    if (read_big_endian_s16(skb, offset, &error_code)) {
+        transaction->error_code = error_code;
+    }
+}
+
 static __always_inline bool kafka_process_new_response(conn_tuple_t *tup, kafka_info_t *kafka, struct __sk_buff* skb, skb_info_t *skb_info) {
     u32 offset = skb_info->data_off;
     u32 orig_offset = offset;
@@ -150,6 +185,13 @@ static __always_inline bool kafka_process_new_response(conn_tuple_t *tup, kafka_
     kafka->response.transaction = *request;
     bpf_map_delete_elem(&kafka_in_flight, &key);
 
+    // only the records of fetch responses are parsed, produce requests complete with the first response packet.
+    if (kafka->response.transaction.request_api_key == KAFKA_PRODUCE) {
+        kafka_read_produce_error(skb, offset, &kafka->response.transaction);
+        kafka_batch_enqueue_wrapper(kafka, tup, &kafka->response.transaction, true);
+        return true;
+    }
+
 //    kafka->response.partitions_count = number_of_partitions;
     kafka->response.state = KAFKA_FETCH_RESPONSE_PARTITION_START;
 //    kafka->response.record_batches_num_bytes = 0;
@@ -386,7 +428,7 @@ static __always_inline bool kafka_process_response(conn_tuple_t *tup, kafka_info
         }
 
         if (response->transaction.records_count) {
-            kafka_batch_enqueue_wrapper(kafka, tup, &response->transaction);
+            kafka_batch_enqueue_wrapper(kafka, tup, &response->transaction, true);
         }
 
         bpf_map_delete_elem(&kafka_response, tup);
@@ -682,7 +724,11 @@ static __always_inline enum parse_result kafka_continue_parse_response_partition
             offset += sizeof(s32); // Skip throttle_time_ms
         }
         if (api_version >= 7) {
-            offset += sizeof(s16); // Skip error_code
+            s16 error_code = 0;
+            if (read_big_endian_s16(skb, offset, &error_code) && error_code != 0) {
+                response->transaction.error_code = error_code;
+            }
+            offset += sizeof(s16);
             offset += sizeof(s32); // Skip session_id
         }
         response->state = KAFKA_FETCH_RESPONSE_NUM_TOPICS;
@@ -766,7 +812,15 @@ static __always_inline enum parse_result kafka_continue_parse_response_partition
             break;
         case KAFKA_FETCH_RESPONSE_PARTITION_START:
             offset += sizeof(s32); // Skip partition_index
-            offset += sizeof(s16); // Skip error_code
+            {
+                // the first error of the partitions is reported, the ones split across packets are not read.
+                s16 error_code = 0;
+                if (response->transaction.error_code == 0 && offset + sizeof(s16) <= data_end &&
+                    read_big_endian_s16(skb, offset, &error_code)) {
+                    response->transaction.error_code = error_code;
+                }
+            }
+            offset += sizeof(s16);
             offset += sizeof(s64); // Skip high_watermark
 
             if (api_version >= 4) {
@@ -917,7 +971,7 @@ static __always_inline enum parse_result kafka_continue_parse_response(kafka_inf
         }
 
         if (ret == RET_DONE) {
-            kafka_batch_enqueue_wrapper(kafka, tup, &response->transaction);
+            kafka_batch_enqueue_wrapper(kafka, tup, &response->transaction, true);
             return ret;
         }
     } else {
@@ -930,7 +984,7 @@ static __always_inline enum parse_result kafka_continue_parse_response(kafka_inf
         // parse), or exit.
         if (ret == RET_DONE) {
             if (response->partitions_count == 0) {
-                kafka_batch_enqueue_wrapper(kafka, tup, &response->transaction);
+                kafka_batch_enqueue_wrapper(kafka, tup, &response->transaction, true);
                 return ret;
             }
 
@@ -991,12 +1045,16 @@ int socket__kafka_filter(struct __sk_buff* skb) {
     }
     bpf_memset(&kafka->event.transaction, 0, sizeof(kafka_transaction_t));
     kafka->event.transaction.request_started = bpf_ktime_get_ns();
+    kafka->event.transaction.cookie = bpf_get_socket_cookie(skb);
 
     conn_tuple_t tup = {0};
     skb_info_t skb_info = {0};
     if (!read_conn_tuple_skb(skb, &skb_info, &tup)) {
         return 0;
     }
+    if (is_excluded_port(&tup)) {
+        return 0;
+    }
     if (!kafka_allow_packet(&skb_info)) {
         return 0;
     }
@@ -1017,6 +1075,23 @@ int socket__kafka_filter(struct __sk_buff* skb) {
 }
 
 READ_INTO_BUFFER(topic_name_parser, TOPIC_NAME_MAX_STRING_SIZE, BLK_SIZE)
+
+// read_produce_acks returns the acks of a produce request, offset points right after the client id.
+static __always_inline s16 read_produce_acks(const kafka_header_t *kafka_header, struct __sk_buff *skb, u32 offset) {
+    s16 transactional_id_size = 0;
+    s16 acks = -1;
+    if (kafka_header->api_version >= 3) {
+        if (!read_big_endian_s16(skb, offset, &transactional_id_size)) {
+            return acks;
+        }
+        offset += sizeof(s16);
+        if (transactional_id_size > 0) {
+            offset += transactional_id_size;
+        }
+    }
+    read_big_endian_s16(skb, offset, &acks);
+    return acks;
+}
 //READ_INTO_BUFFER(client_id, CLIENT_ID_MAX_STRING_SIZE, BLK_SIZE)
 
 static __always_inline bool kafka_process(conn_tuple_t *tup, kafka_info_t *kafka, struct __sk_buff* skb, u32 offset) {
@@ -1054,9 +1129,11 @@ static __always_inline bool kafka_process(conn_tuple_t *tup, kafka_info_t *kafka
     }
 
     bool flexible = false;
+    s16 acks = -1;
 
     switch (kafka_header.api_key) {
     case KAFKA_PRODUCE:
+        acks = read_produce_acks(&kafka_header, skb, offset);
         if (!get_topic_offset_from_produce_request(&kafka_header, skb, &offset)) {
             return false;
         }
@@ -1137,7 +1214,8 @@ static __always_inline bool kafka_process(conn_tuple_t *tup, kafka_info_t *kafka
         return false;
      }
 
-    if (kafka_header.api_key == KAFKA_FETCH) {
+    // produce requests with acks=0 get no response, they are recorded without latency.
+    if (kafka_header.api_key == KAFKA_FETCH || acks != 0) {
         kafka_transaction_t transaction;
         kafka_transaction_key_t key;
         bpf_memset(&key, 0, sizeof(key));
@@ -1149,7 +1227,8 @@ static __always_inline bool kafka_process(conn_tuple_t *tup, kafka_info_t *kafka
         return true;
     }
 
-    kafka_batch_enqueue_wrapper(kafka, tup, kafka_transaction);
+    flip_tuple(tup);
+    kafka_batch_enqueue_wrapper(kafka, tup, kafka_transaction, false);
     return true;
 }
 
@@ -1175,11 +1254,6 @@ int socket__kafka_response_parser(struct __sk_buff *skb) {
     }
     enum parser_level level = parser_state_to_level(response->state);
 
-    sock_key key = {0};
-    key.dstIP = tup.daddr_l;
-    key.dstPort = tup.dport;
-    key.srcIP = tup.saddr_l;
-    key.srcPort = tup.sport;
     enum parse_result result = kafka_continue_parse_response(kafka, &tup, response, skb, skb_info.data_off, skb_info.data_end, level, response->transaction.request_api_version);
     bpf_printk("result: %d", result);
     switch (result) {
@@ -1191,8 +1265,8 @@ int socket__kafka_response_parser(struct __sk_buff *skb) {
         break;
     case RET_DONE:
         bpf_printk("done, records_count %d", response->transaction.records_count);
+        // the transaction was enqueued by the parser
         bpf_map_delete_elem(&kafka_response, &tup);
-        bpf_map_update_elem(&kafka_event, &key, &response->transaction, BPF_ANY);
         break;
     case RET_LOOP_END:
         bpf_printk("loop end, records_count %d", response->transaction.records_count);
@@ -1200,9 +1274,8 @@ int socket__kafka_response_parser(struct __sk_buff *skb) {
 
         if (response->transaction.records_count) {
             bpf_printk("enqueue (loop exceeded), records_count %d", response->transaction.records_count);
-            kafka_batch_enqueue_wrapper(kafka, &tup, &response->transaction);
+            kafka_batch_enqueue_wrapper(kafka, &tup, &response->transaction, true);
         }
-        bpf_map_update_elem(&kafka_event, &key, &response->transaction, BPF_ANY);
         break;
     }
 
@@ -0,0 +1,166 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// requests and responses are small, the messages of a packet are split in user space.
+#define LDAP_PAYLOAD_SIZE 512
+// the sequence header (up to 6 bytes) and the header of the message id
+#define LDAP_HEADER_SIZE 8
+// a search result done with empty matched dn and diagnostic message, and its message id
+#define LDAP_TAIL_SIZE 16
+
+typedef socket_event_key ldap_event_key;
+
+typedef struct {
+    // the packet ends with a search result done, it is held by tail.
+    SOCKET_EVENT_HEADER(has_tail)
+    char tail[LDAP_TAIL_SIZE];
+    char payload[LDAP_PAYLOAD_SIZE];
+} __attribute__((packed)) ldap_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/ldap_scratch_map") ldap_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(ldap_event_t),
+    .max_entries = 1,
+};
+
+// requests and responses, the key is composed in the direction of the packet.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(ldap_event_key),
+    .value_size = sizeof(ldap_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(ldap_payload, LDAP_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_message_id checks the header of the INTEGER message id.
+static __always_inline bool is_message_id(const __u8 *buf) {
+    return buf[0] == 0x02 && buf[1] >= 1 && buf[1] <= 4;
+}
+
+// is_message checks the header of an LDAPMessage: a SEQUENCE with the length in short or long form,
+// followed by the message id.
+static __always_inline bool is_message(const __u8 *buf) {
+    if (buf[0] != 0x30) {
+        return false;
+    }
+    if (buf[1] < 0x80) {
+        return is_message_id(&buf[2]);
+    }
+    if (buf[1] == 0x81) {
+        return is_message_id(&buf[3]);
+    }
+    if (buf[1] == 0x82) {
+        return is_message_id(&buf[4]);
+    }
+    if (buf[1] == 0x83) {
+        return is_message_id(&buf[5]);
+    }
+    if (buf[1] == 0x84) {
+        return is_message_id(&buf[6]);
+    }
+    return false;
+}
+
+// is_search_done checks the end of a packet for a searchResDone without matched dn, diagnostic message
+// and controls, it follows the entries of large results that do not start the packet.
+static __always_inline bool is_search_done(const __u8 *tail) {
+    return tail[7] == 0x65 && tail[8] == 0x07 && tail[9] == 0x0a && tail[10] == 0x01 &&
+           tail[12] == 0x04 && tail[13] == 0x00 && tail[14] == 0x04 && tail[15] == 0x00;
+}
+
+SEC("socket")
+int socket__ldap_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset >= skb->len) {
+        return 0;
+    }
+    __u32 len = skb->len - offset;
+    if (len < LDAP_HEADER_SIZE) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    // requests of the pod and the responses to it, sessions of server pods are ignored in user space.
+    __u8 hdr[LDAP_HEADER_SIZE] = {0};
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    __u8 tail[LDAP_TAIL_SIZE] = {0};
+    __u8 has_tail = 0;
+    if (!from_pod && len >= LDAP_TAIL_SIZE &&
+        bpf_skb_load_bytes(skb, skb->len - LDAP_TAIL_SIZE, tail, sizeof(tail)) == 0 && is_search_done(tail)) {
+        has_tail = 1;
+    }
+    if (!is_message(hdr) && !has_tail) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    ldap_event_t *event = bpf_map_lookup_elem(&ldap_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(ldap_event_t));
+    event->payload_len = len < LDAP_PAYLOAD_SIZE ? len : LDAP_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    event->has_tail = has_tail;
+    if (has_tail) {
+        bpf_memcpy(event->tail, tail, LDAP_TAIL_SIZE);
+    }
+    read_into_buffer_ldap_payload(event->payload, skb, offset);
+
+    ldap_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
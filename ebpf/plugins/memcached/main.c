@@ -0,0 +1,216 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
+
+// the request is decoded in user space, the first bytes hold the command and the key.
+#define MEMCACHED_REQUEST_SIZE 128
+// enough for the status line of text replies and the header of binary replies.
+#define MEMCACHED_RESPONSE_SIZE 64
+
+// https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+#define MEMCACHED_BINARY_HEADER_SIZE 24
+#define MEMCACHED_MAGIC_REQUEST 0x80
+#define MEMCACHED_MAGIC_RESPONSE 0x81
+
+typedef struct {
+    sock_key conn;
+    __u64 request_ts;
+} memcached_event_key;
+
+typedef struct {
+    __u64 request_ts;
+    __u64 duration;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    __u16 request_len;
+    __u16 response_len;
+    __u32 pad;
+    char request[MEMCACHED_REQUEST_SIZE];
+    char response[MEMCACHED_RESPONSE_SIZE];
+} __attribute__((packed)) memcached_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+// in-flight commands, key is composed in the client -> server direction.
+// pipelined commands overwrite each other, only the last one before the first reply is recorded.
+struct bpf_map_def SEC("maps/memcached_processing_map") memcached_processing_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(memcached_event_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/memcached_scratch_map") memcached_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(memcached_event_t),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(memcached_event_key),
+    .value_size = sizeof(memcached_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(memcached_request, MEMCACHED_REQUEST_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(memcached_response, MEMCACHED_RESPONSE_SIZE, BLK_SIZE)
+
+static __always_inline void compose_sock_key(sock_key *k, conn_tuple_t *tup, bool reverse) {
+    if (reverse) {
+        k->srcIP = tup->daddr_l;
+        k->dstIP = tup->saddr_l;
+        k->srcPort = tup->dport;
+        k->dstPort = tup->sport;
+        return;
+    }
+    k->srcIP = tup->saddr_l;
+    k->dstIP = tup->daddr_l;
+    k->srcPort = tup->sport;
+    k->dstPort = tup->dport;
+}
+
+static __always_inline bool has_prefix(const char *buf, const char *prefix, int len) {
+#pragma unroll
+    for (int i = 0; i < len; i++) {
+        if (buf[i] != prefix[i]) {
+            return false;
+        }
+    }
+    return true;
+}
+
+// is_text_command checks the first bytes of the text and meta protocol commands,
+// other lower case protocols on the same connections are dropped in user space.
+static __always_inline bool is_text_command(const char *buf) {
+    switch (buf[0]) {
+    case 'g':
+        // get, gets, gat, gats
+        return has_prefix(buf, "get", 3) || has_prefix(buf, "gat", 3);
+    case 's':
+        return has_prefix(buf, "set ", 4);
+    case 'a':
+        return has_prefix(buf, "add ", 4) || has_prefix(buf, "append ", 7);
+    case 'r':
+        return has_prefix(buf, "replace ", 8);
+    case 'p':
+        return has_prefix(buf, "prepend ", 8);
+    case 'c':
+        return has_prefix(buf, "cas ", 4);
+    case 'd':
+        return has_prefix(buf, "delete ", 7) || has_prefix(buf, "decr ", 5);
+    case 'i':
+        return has_prefix(buf, "incr ", 5);
+    case 't':
+        return has_prefix(buf, "touch ", 6);
+    case 'm':
+        // meta commands: mg, ms, md, ma
+        return (buf[1] == 'g' || buf[1] == 's' || buf[1] == 'd' || buf[1] == 'a') && buf[2] == ' ';
+    default:
+        return false;
+    }
+}
+
+static __always_inline void handle_request(struct __sk_buff *skb, sock_key *key, __u32 offset) {
+    __u32 zero = 0;
+    memcached_event_t *event = bpf_map_lookup_elem(&memcached_scratch_map, &zero);
+    if (!event) {
+        return;
+    }
+    bpf_memset(event, 0, sizeof(memcached_event_t));
+    event->request_ts = bpf_ktime_get_ns();
+    event->cookie = bpf_get_socket_cookie(skb);
+    __u32 len = skb->len - offset;
+    event->request_len = len < MEMCACHED_REQUEST_SIZE ? len : MEMCACHED_REQUEST_SIZE;
+    read_into_buffer_memcached_request(event->request, skb, offset);
+    bpf_map_update_elem(&memcached_processing_map, key, event, BPF_ANY);
+}
+
+static __always_inline void handle_response(struct __sk_buff *skb, sock_key *key, memcached_event_t *event,
+                                            __u32 offset) {
+    event->duration = bpf_ktime_get_ns() - event->request_ts;
+    __u32 len = skb->len - offset;
+    event->response_len = len < MEMCACHED_RESPONSE_SIZE ? len : MEMCACHED_RESPONSE_SIZE;
+    read_into_buffer_memcached_response(event->response, skb, offset);
+
+    memcached_event_key event_key = {0};
+    event_key.conn = *key;
+    event_key.request_ts = event->request_ts;
+    bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+    bpf_map_delete_elem(&memcached_processing_map, key);
+}
+
+SEC("socket")
+int socket__memcached_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    // the shortest frames are the text replies "END\r\n" and "HD\r\n".
+    if (skb_info.data_off + 4 > skb->len) {
+        return 0;
+    }
+
+    char buf[8] = {0};
+    if (bpf_skb_load_bytes(skb, skb_info.data_off, buf, 4) < 0) {
+        return 0;
+    }
+    if (skb_info.data_off + sizeof(buf) <= skb->len) {
+        bpf_skb_load_bytes(skb, skb_info.data_off, buf, sizeof(buf));
+    }
+
+    sock_key key = {0};
+    // packets of a connection with a command in flight are replies.
+    compose_sock_key(&key, &conn_tuple, true);
+    memcached_event_t *event = bpf_map_lookup_elem(&memcached_processing_map, &key);
+    if (event != NULL) {
+        bool binary = (__u8)event->request[0] == MEMCACHED_MAGIC_REQUEST;
+        if (binary ? (__u8)buf[0] == MEMCACHED_MAGIC_RESPONSE : (__u8)buf[0] != MEMCACHED_MAGIC_RESPONSE) {
+            handle_response(skb, &key, event, skb_info.data_off);
+        }
+        return 0;
+    }
+
+    compose_sock_key(&key, &conn_tuple, false);
+    // only record commands issued by the pod attached to this veth.
+    if (bpf_map_lookup_elem(&filter_map, &key.srcIP) == NULL) {
+        return 0;
+    }
+    if ((__u8)buf[0] == MEMCACHED_MAGIC_REQUEST) {
+        if (skb_info.data_off + MEMCACHED_BINARY_HEADER_SIZE > skb->len) {
+            return 0;
+        }
+    } else if (!is_text_command(buf)) {
+        return 0;
+    }
+    handle_request(skb, &key, skb_info.data_off);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
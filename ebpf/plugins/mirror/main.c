@@ -0,0 +1,63 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+
+// the flow selected by the admin, zero fields match anything. The sides match in either direction.
+typedef struct {
+    __u32 ip_a;
+    __u32 ip_b;
+    __u16 port_a;
+    __u16 port_b;
+} mirror_selector_t;
+
+struct bpf_map_def SEC("maps/mirror_selector_map") mirror_selector_map = {
+    .type = BPF_MAP_TYPE_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(mirror_selector_t),
+    .max_entries = 1,
+};
+
+static __always_inline bool match_side(__u32 ip, __u16 port, __u32 want_ip, __u16 want_port) {
+    return (want_ip == 0 || ip == want_ip) && (want_port == 0 || port == want_port);
+}
+
+// socket__mirror_filter keeps the packets of the selected flow on the raw socket, unlike the
+// protocol plugins the packets are read by the agent instead of being recorded in maps.
+SEC("socket")
+int socket__mirror_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    __u32 zero = 0;
+    mirror_selector_t *sel = bpf_map_lookup_elem(&mirror_selector_map, &zero);
+    if (sel == NULL) {
+        return 0;
+    }
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if ((match_side(saddr, conn_tuple.sport, sel->ip_a, sel->port_a) &&
+         match_side(daddr, conn_tuple.dport, sel->ip_b, sel->port_b)) ||
+        (match_side(saddr, conn_tuple.sport, sel->ip_b, sel->port_b) &&
+         match_side(daddr, conn_tuple.dport, sel->ip_a, sel->port_a))) {
+        return skb->len;
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
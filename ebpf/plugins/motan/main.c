@@ -0,0 +1,124 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// messages are decoded in user space, the meta (path, method, group) follows the fixed header.
+#define MOTAN_PAYLOAD_SIZE 512
+
+// see com.weibo.api.motan.protocol.v2motan.MotanV2Header
+#define MOTAN_MAGIC 0xF1
+#define MOTAN_HEADER_SIZE 13
+#define MOTAN_VERSION 1
+// the flags of the message type below the heartbeat bit (0x10): gzip, oneway, proxy and response
+#define MOTAN_MSG_FLAGS 0x0F
+
+typedef socket_event_key motan_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[MOTAN_PAYLOAD_SIZE];
+} __attribute__((packed)) motan_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/motan_scratch_map") motan_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(motan_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a motan message, the key is composed in the direction of the packet.
+// clients multiplex their requests on a connection, requests and responses are paired by request id in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(motan_event_key),
+    .value_size = sizeof(motan_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(motan_payload, MOTAN_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_motan_message checks the magic, the message type and the version, heartbeats are left out.
+static __always_inline bool is_motan_message(const __u8 *buf) {
+    return buf[0] == MOTAN_MAGIC && buf[1] == MOTAN_MAGIC && (buf[2] & ~MOTAN_MSG_FLAGS) == 0 &&
+           (buf[3] >> 3) == MOTAN_VERSION;
+}
+
+SEC("socket")
+int socket__motan_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + MOTAN_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[4];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (!is_motan_message(hdr)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    motan_event_t *event = bpf_map_lookup_elem(&motan_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(motan_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < MOTAN_PAYLOAD_SIZE ? len : MOTAN_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_motan_payload(event->payload, skb, offset);
+
+    motan_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
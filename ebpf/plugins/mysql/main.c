@@ -0,0 +1,190 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
+
+#define MYSQL_STATEMENT_SIZE 256
+#define MYSQL_ERROR_MSG_SIZE 64
+
+// Taken from https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_execute.html
+#define MYSQL_COMMAND_STMT_EXECUTE 0x17
+// '#' followed by the 5 bytes sql state, only sent with CLIENT_PROTOCOL_41.
+#define MYSQL_SQL_STATE_SIZE 6
+
+typedef struct {
+    sock_key conn;
+    __u64 request_ts;
+} mysql_event_key;
+
+typedef struct {
+    __u64 request_ts;
+    __u64 duration;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    __u32 stmt_id;
+    __u16 error_code;
+    __u16 statement_len;
+    __u8 command;
+    __u8 response_type;
+    char statement[MYSQL_STATEMENT_SIZE];
+    char error_msg[MYSQL_ERROR_MSG_SIZE];
+} __attribute__((packed)) mysql_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+// in-flight commands, key is composed in the client -> server direction.
+// the protocol is strictly request/response on a connection, so one entry per connection is enough.
+struct bpf_map_def SEC("maps/mysql_processing_map") mysql_processing_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(mysql_event_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/mysql_scratch_map") mysql_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(mysql_event_t),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(mysql_event_key),
+    .value_size = sizeof(mysql_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(mysql_statement, MYSQL_STATEMENT_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(mysql_error_msg, MYSQL_ERROR_MSG_SIZE, BLK_SIZE)
+
+static __always_inline void compose_sock_key(sock_key *k, conn_tuple_t *tup, bool reverse) {
+    if (reverse) {
+        k->srcIP = tup->daddr_l;
+        k->dstIP = tup->saddr_l;
+        k->srcPort = tup->dport;
+        k->dstPort = tup->sport;
+        return;
+    }
+    k->srcIP = tup->saddr_l;
+    k->dstIP = tup->daddr_l;
+    k->srcPort = tup->sport;
+    k->dstPort = tup->dport;
+}
+
+static __always_inline bool is_tracked_command(__u8 command) {
+    return command == MYSQL_COMMAND_QUERY || command == MYSQL_PREPARE_QUERY || command == MYSQL_COMMAND_STMT_EXECUTE;
+}
+
+static __always_inline void handle_request(struct __sk_buff *skb, sock_key *key, __u32 offset, mysql_hdr *header) {
+    __u32 zero = 0;
+    mysql_event_t *event = bpf_map_lookup_elem(&mysql_scratch_map, &zero);
+    if (!event) {
+        return;
+    }
+    bpf_memset(event, 0, sizeof(mysql_event_t));
+    event->request_ts = bpf_ktime_get_ns();
+    event->cookie = bpf_get_socket_cookie(skb);
+    event->command = header->command_type;
+
+    __u32 payload_len = header->payload_length > 1 ? header->payload_length - 1 : 0;
+    if (header->command_type == MYSQL_COMMAND_STMT_EXECUTE) {
+        bpf_skb_load_bytes(skb, offset, &event->stmt_id, sizeof(event->stmt_id));
+    } else {
+        event->statement_len = payload_len < MYSQL_STATEMENT_SIZE ? payload_len : MYSQL_STATEMENT_SIZE;
+        read_into_buffer_mysql_statement(event->statement, skb, offset);
+    }
+    bpf_map_update_elem(&mysql_processing_map, key, event, BPF_ANY);
+}
+
+static __always_inline void handle_response(struct __sk_buff *skb, sock_key *key, __u32 offset, mysql_hdr *header) {
+    mysql_event_t *event = bpf_map_lookup_elem(&mysql_processing_map, key);
+    if (!event) {
+        return;
+    }
+    event->duration = bpf_ktime_get_ns() - event->request_ts;
+    // the first byte of the payload was already read as command_type.
+    event->response_type = header->command_type;
+
+    if (header->command_type == MYSQL_ERR_RESPONSE) {
+        bpf_skb_load_bytes(skb, offset, &event->error_code, sizeof(event->error_code));
+        offset += sizeof(event->error_code);
+        char marker = 0;
+        bpf_skb_load_bytes(skb, offset, &marker, sizeof(marker));
+        if (marker == '#') {
+            offset += MYSQL_SQL_STATE_SIZE;
+        }
+        read_into_buffer_mysql_error_msg(event->error_msg, skb, offset);
+    } else if (header->command_type == MYSQL_OK00_RESPONSE && event->command == MYSQL_PREPARE_QUERY) {
+        // COM_STMT_PREPARE_OK carries the statement id used by the following COM_STMT_EXECUTE.
+        bpf_skb_load_bytes(skb, offset, &event->stmt_id, sizeof(event->stmt_id));
+    }
+
+    mysql_event_key event_key = {0};
+    event_key.conn = *key;
+    event_key.request_ts = event->request_ts;
+    bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+    bpf_map_delete_elem(&mysql_processing_map, key);
+}
+
+SEC("socket")
+int socket__mysql_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    if (skb_info.data_off + MYSQL_MIN_LENGTH > skb->len) {
+        return 0;
+    }
+
+    mysql_hdr header = {0};
+    if (bpf_skb_load_bytes(skb, skb_info.data_off, &header, sizeof(header)) < 0) {
+        return 0;
+    }
+    if (header.payload_length == 0) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off + sizeof(mysql_hdr);
+
+    sock_key key = {0};
+    // commands always start a new sequence, the first response packet has sequence id 1.
+    if (header.seq_id == 0 && is_tracked_command(header.command_type)) {
+        compose_sock_key(&key, &conn_tuple, false);
+        // only record commands issued by the pod attached to this veth.
+        if (bpf_map_lookup_elem(&filter_map, &key.srcIP) != NULL) {
+            handle_request(skb, &key, offset, &header);
+        }
+        return 0;
+    }
+    if (header.seq_id == 1) {
+        compose_sock_key(&key, &conn_tuple, true);
+        handle_response(skb, &key, offset, &header);
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,173 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// calls are decoded in user space, the statement follows the options of the call.
+#define ORACLE_PAYLOAD_SIZE 512
+
+// tns header: length, checksum, type, flags, header checksum, followed by the data flags of data packets
+#define TNS_HEADER_SIZE 12
+#define TNS_TYPE_DATA 6
+// the largest session data unit
+#define TNS_MAX_PACKET_SIZE (2 * 1024 * 1024)
+
+// ttc message types, the first byte of the data
+#define TTC_FUNCTION 0x03
+#define TTC_ERROR 0x04
+#define TTC_ROW_HEADER 0x06
+#define TTC_ROW_DATA 0x07
+#define TTC_PARAMETERS 0x08
+#define TTC_IO_VECTOR 0x0b
+#define TTC_DESCRIBE 0x10
+#define TTC_PIGGYBACK 0x11
+
+// function codes of the calls
+#define TTC_OALL8 0x5e
+#define TTC_OCOMMIT 0x0e
+#define TTC_OROLLBACK 0x0f
+
+typedef socket_event_key oracle_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[ORACLE_PAYLOAD_SIZE];
+} __attribute__((packed)) oracle_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/oracle_scratch_map") oracle_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(oracle_event_t),
+    .max_entries = 1,
+};
+
+// calls and the first packet of their responses, the key is composed in the direction of the packet.
+// Calls of a session are not pipelined, they are paired with the next response in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(oracle_event_key),
+    .value_size = sizeof(oracle_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(oracle_payload, ORACLE_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_data_packet checks the header of data packets, the length is 2 bytes followed by the packet
+// checksum before 12c, 4 bytes with large session data units.
+static __always_inline bool is_data_packet(const __u8 *hdr, __u32 len) {
+    if (hdr[4] != TNS_TYPE_DATA || hdr[6] != 0 || hdr[7] != 0) {
+        return false;
+    }
+    __u32 len16 = (__u32)hdr[0] << 8 | hdr[1];
+    __u32 len32 = (__u32)hdr[0] << 24 | (__u32)hdr[1] << 16 | (__u32)hdr[2] << 8 | hdr[3];
+    if (hdr[2] == 0 && hdr[3] == 0 && len16 >= len) {
+        return true;
+    }
+    return len32 >= len && len32 <= TNS_MAX_PACKET_SIZE;
+}
+
+// is_call checks the calls carrying a statement, piggybacked calls (e.g. closing cursors before
+// the execution) are checked in user space.
+static __always_inline bool is_call(const __u8 *hdr) {
+    if (hdr[10] == TTC_PIGGYBACK) {
+        return true;
+    }
+    return hdr[10] == TTC_FUNCTION && (hdr[11] == TTC_OALL8 || hdr[11] == TTC_OCOMMIT || hdr[11] == TTC_OROLLBACK);
+}
+
+// is_response checks the messages starting the response to a call.
+static __always_inline bool is_response(const __u8 *hdr) {
+    switch (hdr[10]) {
+    case TTC_ERROR:
+    case TTC_ROW_HEADER:
+    case TTC_ROW_DATA:
+    case TTC_PARAMETERS:
+    case TTC_IO_VECTOR:
+    case TTC_DESCRIBE:
+        return true;
+    default:
+        return false;
+    }
+}
+
+SEC("socket")
+int socket__oracle_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + TNS_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+    __u8 hdr[TNS_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    __u32 len = skb->len - offset;
+    if (!is_data_packet(hdr, len)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+    // calls of the pod and the responses to it, sessions of server pods are ignored in user space.
+    if (from_pod ? !is_call(hdr) : !is_response(hdr)) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    oracle_event_t *event = bpf_map_lookup_elem(&oracle_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(oracle_event_t));
+    event->payload_len = len < ORACLE_PAYLOAD_SIZE ? len : ORACLE_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_oracle_payload(event->payload, skb, offset);
+
+    oracle_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,184 @@
+#include <linux/kconfig.h>
+#include <linux/skbuff.h>
+#include <linux/netdevice.h>
+#include <linux/ip.h>
+#include <linux/tcp.h>
+#include <linux/udp.h>
+#include <uapi/linux/if_ether.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+#include "../../include/event_budget.h"
+
+// budget probe id of the drops, a drop storm must not keep the cpus in the tracepoint.
+#define KFREE_SKB_PROBE 0
+
+// drops of the packets by device, addresses and reason.
+struct drop_key_t {
+    __u32 ifindex;
+    __u32 saddr;
+    __u32 daddr;
+    __u32 reason;
+};
+
+// fields of the kfree_skb tracepoint, see /sys/kernel/debug/tracing/events/skb/kfree_skb/format
+// reason is only set since 5.17, the agent ignores it on the kernels without it.
+struct kfree_skb_args {
+    __u64 pad;
+    void *skbaddr;
+    void *location;
+    __u16 protocol;
+    __u16 pad2;
+    __u32 reason;
+};
+
+struct bpf_map_def SEC("maps/drops_map") drops_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(struct drop_key_t),
+    .value_size = sizeof(__u64),
+    .max_entries = 1024 * 16,
+};
+
+// drops of the netfilter verdicts (iptables rules, network policies) by addresses and destination port.
+struct policy_key_t {
+    __u32 saddr;
+    __u32 daddr;
+    __u16 dport;
+    __u8 protocol;
+    __u8 pad;
+};
+
+struct bpf_map_def SEC("maps/policy_drops_map") policy_drops_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(struct policy_key_t),
+    .value_size = sizeof(__u64),
+    .max_entries = 1024 * 16,
+};
+
+// the value of SKB_DROP_REASON_NETFILTER_DROP of the kernel, set by the agent, 0 on the kernels without
+// reasons: the verdicts are then read on the return of nf_hook_slow.
+struct bpf_map_def SEC("maps/netfilter_reason_map") netfilter_reason_map = {
+    .type = BPF_MAP_TYPE_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = 1,
+};
+
+// packets in nf_hook_slow, by thread.
+struct bpf_map_def SEC("maps/nf_hook_map") nf_hook_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(struct policy_key_t),
+    .max_entries = 1024 * 4,
+};
+
+static __always_inline bool read_iphdr(struct sk_buff *skb, struct iphdr *iph, unsigned char **l4) {
+    unsigned char *head = NULL;
+    __u16 network_header = 0;
+    BPF_PROBE_READ_INTO(&head, skb, head);
+    BPF_PROBE_READ_INTO(&network_header, skb, network_header);
+    if (head == NULL || bpf_probe_read_kernel(iph, sizeof(*iph), head + network_header) < 0) {
+        return false;
+    }
+    *l4 = head + network_header + (iph->ihl << 2);
+    return true;
+}
+
+static __always_inline bool read_policy_key(struct sk_buff *skb, struct policy_key_t *key) {
+    struct iphdr iph = {0};
+    unsigned char *l4 = NULL;
+    if (!read_iphdr(skb, &iph, &l4) || iph.version != 4) {
+        return false;
+    }
+    key->saddr = iph.saddr;
+    key->daddr = iph.daddr;
+    key->protocol = iph.protocol;
+    // the destination port is at the same offset in the tcp and udp headers.
+    if (iph.protocol == IPPROTO_TCP || iph.protocol == IPPROTO_UDP) {
+        __u16 dport = 0;
+        bpf_probe_read_kernel(&dport, sizeof(dport), l4 + offsetof(struct udphdr, dest));
+        key->dport = bpf_ntohs(dport);
+    }
+    return true;
+}
+
+static __always_inline void count_policy_drop(struct policy_key_t *key) {
+    __u64 *count = bpf_map_lookup_elem(&policy_drops_map, key);
+    if (count != NULL) {
+        __sync_fetch_and_add(count, 1);
+        return;
+    }
+    __u64 one = 1;
+    bpf_map_update_elem(&policy_drops_map, key, &one, BPF_NOEXIST);
+}
+
+SEC("tracepoint/skb/kfree_skb")
+int tracepoint_kfree_skb(struct kfree_skb_args *ctx) {
+    // the protocol of the tracepoint is in host order
+    if (ctx->protocol != ETH_P_IP || !event_budget_allow(KFREE_SKB_PROBE)) {
+        return 0;
+    }
+    struct sk_buff *skb = (struct sk_buff *)ctx->skbaddr;
+    struct drop_key_t key = {0};
+    key.reason = ctx->reason;
+    BPF_PROBE_READ_INTO(&key.ifindex, skb, dev, ifindex);
+
+    struct iphdr iph = {0};
+    unsigned char *l4 = NULL;
+    if (!read_iphdr(skb, &iph, &l4)) {
+        return 0;
+    }
+    key.saddr = iph.saddr;
+    key.daddr = iph.daddr;
+
+    __u32 zero = 0;
+    __u32 *netfilter_reason = bpf_map_lookup_elem(&netfilter_reason_map, &zero);
+    if (netfilter_reason != NULL && *netfilter_reason != 0 && ctx->reason == *netfilter_reason) {
+        struct policy_key_t policy = {0};
+        if (read_policy_key(skb, &policy)) {
+            count_policy_drop(&policy);
+        }
+    }
+
+    __u64 *count = bpf_map_lookup_elem(&drops_map, &key);
+    if (count != NULL) {
+        __sync_fetch_and_add(count, 1);
+        return 0;
+    }
+    __u64 one = 1;
+    bpf_map_update_elem(&drops_map, &key, &one, BPF_NOEXIST);
+    return 0;
+}
+
+// the packets of the netfilter hooks, on the kernels without drop reasons.
+SEC("kprobe/nf_hook_slow")
+int kprobe_nf_hook_slow(struct pt_regs *ctx) {
+    struct sk_buff *skb = (struct sk_buff *)PT_REGS_PARM1(ctx);
+    struct policy_key_t key = {0};
+    if (!read_policy_key(skb, &key)) {
+        return 0;
+    }
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&nf_hook_map, &pid_tgid, &key, BPF_ANY);
+    return 0;
+}
+
+// nf_hook_slow returns -EPERM (or the errno of the rule) once a hook dropped the packet.
+SEC("kretprobe/nf_hook_slow")
+int kretprobe_nf_hook_slow(struct pt_regs *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct policy_key_t *key = bpf_map_lookup_elem(&nf_hook_map, &pid_tgid);
+    if (key == NULL) {
+        return 0;
+    }
+    int ret = (int)PT_REGS_RC(ctx);
+    if (ret < 0) {
+        struct policy_key_t drop = *key;
+        count_policy_drop(&drop);
+    }
+    bpf_map_delete_elem(&nf_hook_map, &pid_tgid);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
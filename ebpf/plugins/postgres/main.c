@@ -0,0 +1,241 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
+
+#define PG_QUERY_SIZE 256
+#define PG_TAG_SIZE 32
+#define PG_ERROR_SIZE 128
+#define PG_MAX_MESSAGES_PER_PACKET 16
+
+// message header: one byte type followed by the big endian length, which includes itself but not the type.
+#define PG_MSG_HEADER_SIZE 5
+// ReadyForQuery: 'Z', length 5 and the transaction status ('I', 'T' or 'E').
+#define PG_READY_FOR_QUERY_SIZE 6
+
+// frontend messages, see https://www.postgresql.org/docs/current/protocol-message-formats.html
+#define PG_MSG_QUERY 'Q'
+#define PG_MSG_PARSE 'P'
+#define PG_MSG_BIND 'B'
+// backend messages
+#define PG_MSG_COMMAND_COMPLETE 'C'
+#define PG_MSG_ERROR_RESPONSE 'E'
+#define PG_MSG_READY_FOR_QUERY 'Z'
+
+typedef struct {
+    __u8 type;
+    __u32 length;
+} __attribute__((packed)) pg_msg_hdr;
+
+typedef struct {
+    sock_key conn;
+    __u64 request_ts;
+} pg_event_key;
+
+typedef struct {
+    __u64 request_ts;
+    __u64 duration;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    // bytes of the current backend message continuing in the next packet.
+    __u32 skip;
+    __u16 query_len;
+    __u8 command;
+    __u8 error;
+    char query[PG_QUERY_SIZE];
+    char tag[PG_TAG_SIZE];
+    char error_fields[PG_ERROR_SIZE];
+} __attribute__((packed)) pg_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+// in-flight queries, key is composed in the client -> server direction.
+struct bpf_map_def SEC("maps/pg_processing_map") pg_processing_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(pg_event_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/pg_scratch_map") pg_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(pg_event_t),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(pg_event_key),
+    .value_size = sizeof(pg_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(pg_query, PG_QUERY_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(pg_tag, PG_TAG_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(pg_error, PG_ERROR_SIZE, BLK_SIZE)
+
+static __always_inline void compose_sock_key(sock_key *k, conn_tuple_t *tup, bool reverse) {
+    if (reverse) {
+        k->srcIP = tup->daddr_l;
+        k->dstIP = tup->saddr_l;
+        k->srcPort = tup->dport;
+        k->dstPort = tup->sport;
+        return;
+    }
+    k->srcIP = tup->saddr_l;
+    k->dstIP = tup->daddr_l;
+    k->srcPort = tup->sport;
+    k->dstPort = tup->dport;
+}
+
+static __always_inline bool read_msg_hdr(struct __sk_buff *skb, __u32 offset, pg_msg_hdr *hdr) {
+    if (offset + PG_MSG_HEADER_SIZE > skb->len) {
+        return false;
+    }
+    if (bpf_skb_load_bytes(skb, offset, hdr, PG_MSG_HEADER_SIZE) < 0) {
+        return false;
+    }
+    hdr->length = bpf_ntohl(hdr->length);
+    // lengths below 4 are invalid, large lengths usually mean we are not at a message boundary.
+    return hdr->length >= 4 && hdr->length < (1 << 24);
+}
+
+static __always_inline bool is_ready_for_query(struct __sk_buff *skb, __u32 data_off) {
+    if (data_off + PG_READY_FOR_QUERY_SIZE > skb->len) {
+        return false;
+    }
+    char buf[PG_READY_FOR_QUERY_SIZE];
+    if (bpf_skb_load_bytes(skb, skb->len - PG_READY_FOR_QUERY_SIZE, buf, PG_READY_FOR_QUERY_SIZE) < 0) {
+        return false;
+    }
+    return buf[0] == PG_MSG_READY_FOR_QUERY && buf[1] == 0 && buf[2] == 0 && buf[3] == 0 && buf[4] == 4 + 1 &&
+           (buf[5] == 'I' || buf[5] == 'T' || buf[5] == 'E');
+}
+
+static __always_inline void handle_request(struct __sk_buff *skb, sock_key *key, __u32 offset, pg_msg_hdr *hdr) {
+    __u32 zero = 0;
+    pg_event_t *event = bpf_map_lookup_elem(&pg_scratch_map, &zero);
+    if (!event) {
+        return;
+    }
+    bpf_memset(event, 0, sizeof(pg_event_t));
+    event->request_ts = bpf_ktime_get_ns();
+    event->cookie = bpf_get_socket_cookie(skb);
+    event->command = hdr->type;
+    __u32 payload_len = hdr->length - 4;
+    event->query_len = payload_len < PG_QUERY_SIZE ? payload_len : PG_QUERY_SIZE;
+    read_into_buffer_pg_query(event->query, skb, offset + PG_MSG_HEADER_SIZE);
+    bpf_map_update_elem(&pg_processing_map, key, event, BPF_ANY);
+}
+
+static __always_inline void complete_event(sock_key *key, pg_event_t *event) {
+    event->duration = bpf_ktime_get_ns() - event->request_ts;
+    pg_event_key event_key = {0};
+    event_key.conn = *key;
+    event_key.request_ts = event->request_ts;
+    bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+    bpf_map_delete_elem(&pg_processing_map, key);
+}
+
+static __always_inline void handle_response(struct __sk_buff *skb, sock_key *key, __u32 data_off) {
+    pg_event_t *event = bpf_map_lookup_elem(&pg_processing_map, key);
+    if (!event) {
+        return;
+    }
+
+    __u32 payload_len = skb->len - data_off;
+    if (event->skip < payload_len) {
+        __u32 offset = data_off + event->skip;
+        event->skip = 0;
+        pg_msg_hdr hdr = {0};
+#pragma unroll(PG_MAX_MESSAGES_PER_PACKET)
+        for (__u8 i = 0; i < PG_MAX_MESSAGES_PER_PACKET; i++) {
+            if (!read_msg_hdr(skb, offset, &hdr)) {
+                break;
+            }
+            if (hdr.type == PG_MSG_COMMAND_COMPLETE) {
+                read_into_buffer_pg_tag(event->tag, skb, offset + PG_MSG_HEADER_SIZE);
+            } else if (hdr.type == PG_MSG_ERROR_RESPONSE) {
+                event->error = 1;
+                read_into_buffer_pg_error(event->error_fields, skb, offset + PG_MSG_HEADER_SIZE);
+            } else if (hdr.type == PG_MSG_READY_FOR_QUERY) {
+                complete_event(key, event);
+                return;
+            }
+            __u32 next = offset + 1 + hdr.length;
+            if (next > skb->len) {
+                event->skip = next - skb->len;
+                break;
+            }
+            offset = next;
+        }
+    } else {
+        event->skip -= payload_len;
+    }
+
+    // large result sets have more messages per packet than we can walk, the response always ends with ReadyForQuery.
+    if (is_ready_for_query(skb, data_off)) {
+        complete_event(key, event);
+    }
+}
+
+SEC("socket")
+int socket__postgres_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    if (skb_info.data_off >= skb->len) {
+        return 0;
+    }
+
+    sock_key key = {0};
+    // packets of a connection with a query in flight are responses.
+    compose_sock_key(&key, &conn_tuple, true);
+    if (bpf_map_lookup_elem(&pg_processing_map, &key) != NULL) {
+        handle_response(skb, &key, skb_info.data_off);
+        return 0;
+    }
+
+    pg_msg_hdr hdr = {0};
+    if (!read_msg_hdr(skb, skb_info.data_off, &hdr)) {
+        return 0;
+    }
+    // the extended query protocol starts with Parse, or with Bind when a named statement is reused.
+    if (hdr.type != PG_MSG_QUERY && hdr.type != PG_MSG_PARSE && hdr.type != PG_MSG_BIND) {
+        return 0;
+    }
+    compose_sock_key(&key, &conn_tuple, false);
+    // only record queries issued by the pod attached to this veth.
+    if (bpf_map_lookup_elem(&filter_map, &key.srcIP) != NULL) {
+        handle_request(skb, &key, skb_info.data_off, &hdr);
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
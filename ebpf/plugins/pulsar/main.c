@@ -0,0 +1,129 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// frames are decoded in user space, the commands are a few dozen bytes, the message metadata follows them.
+#define PULSAR_PAYLOAD_SIZE 512
+
+// frame: total size, command size, BaseCommand, the payload of sends and messages.
+// see org.apache.pulsar.common.protocol.Commands and PulsarApi.proto
+#define PULSAR_HEADER_SIZE 10
+// the tag of BaseCommand.type, field 1 of type varint
+#define PULSAR_TYPE_TAG 0x08
+#define PULSAR_MIN_TYPE 2
+
+typedef socket_event_key pulsar_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[PULSAR_PAYLOAD_SIZE];
+} __attribute__((packed)) pulsar_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/pulsar_scratch_map") pulsar_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(pulsar_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a frame, the key is composed in the direction of the packet. Producers and
+// consumers are registered on the connection, sends and receipts are paired in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(pulsar_event_key),
+    .value_size = sizeof(pulsar_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(pulsar_payload, PULSAR_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_pulsar_frame checks the sizes and the type of the command, types are single byte varints.
+static __always_inline bool is_pulsar_frame(const __u8 *buf) {
+    __u32 total_size = ((__u32)buf[0] << 24) | ((__u32)buf[1] << 16) | ((__u32)buf[2] << 8) | buf[3];
+    __u32 command_size = ((__u32)buf[4] << 24) | ((__u32)buf[5] << 16) | ((__u32)buf[6] << 8) | buf[7];
+    // frames are far below 16MB (5MB by default)
+    if (buf[0] != 0 || command_size < 2 || command_size + 4 > total_size) {
+        return false;
+    }
+    return buf[8] == PULSAR_TYPE_TAG && buf[9] >= PULSAR_MIN_TYPE && buf[9] < 0x80;
+}
+
+SEC("socket")
+int socket__pulsar_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + PULSAR_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[PULSAR_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (!is_pulsar_frame(hdr)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    pulsar_event_t *event = bpf_map_lookup_elem(&pulsar_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(pulsar_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < PULSAR_PAYLOAD_SIZE ? len : PULSAR_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_pulsar_payload(event->payload, skb, offset);
+
+    pulsar_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,199 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// long headers are decoded in user space: first byte, version and both connection ids (up to 20 bytes).
+#define QUIC_PAYLOAD_SIZE 64
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-17
+#define QUIC_PORT 443
+#define QUIC_HEADER_FORM_LONG 0x80
+#define QUIC_FIXED_BIT 0x40
+
+typedef socket_event_key quic_event_key;
+
+typedef struct {
+    // the packet was sent by the client, to QUIC_PORT.
+    SOCKET_EVENT_HEADER(from_client)
+    char payload[QUIC_PAYLOAD_SIZE];
+} __attribute__((packed)) quic_event_t;
+
+typedef struct {
+    __u64 first_ts;
+    __u64 last_ts;
+    // first short header (1-RTT) packet of the client and of the server, 0 until their handshake keys are installed.
+    __u64 client_short_ts;
+    __u64 server_short_ts;
+    __u64 client_packets;
+    __u64 server_packets;
+    __u64 client_bytes;
+    __u64 server_bytes;
+    // cookie of the socket of the pod end of the connection.
+    __u64 cookie;
+    // the server is the pod attached to this veth.
+    __u8 server_is_pod;
+    __u8 pad[7];
+} __attribute__((packed)) quic_conn_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/quic_scratch_map") quic_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(quic_event_t),
+    .max_entries = 1,
+};
+
+// connections keyed in the client -> server direction, they are reported and deleted by user space once idle.
+struct bpf_map_def SEC("maps/conn_map") conn_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(quic_conn_t),
+    .max_entries = 1024 * 16,
+};
+
+// long header packets of the handshakes, the key is composed in the direction of the packet.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(quic_event_key),
+    .value_size = sizeof(quic_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(quic_payload, QUIC_PAYLOAD_SIZE, BLK_SIZE)
+
+SEC("socket")
+int socket__quic_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (conn_tuple.l3_proto != ETH_P_IP || (conn_tuple.metadata & CONN_TYPE_TCP)) {
+        return 0;
+    }
+    if (conn_tuple.sport != QUIC_PORT && conn_tuple.dport != QUIC_PORT) {
+        return 0;
+    }
+    // only the ports excluded for quic, the global excluded ports are not applied to this plugin.
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + 1 > skb->len) {
+        return 0;
+    }
+    __u8 first = 0;
+    if (bpf_skb_load_bytes(skb, offset, &first, 1) < 0) {
+        return 0;
+    }
+    bool long_header = (first & QUIC_HEADER_FORM_LONG) != 0;
+    // version negotiation packets are the only ones without the fixed bit, they have a long header.
+    if (!long_header && !(first & QUIC_FIXED_BIT)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+    __u8 from_client = conn_tuple.dport == QUIC_PORT ? 1 : 0;
+
+    sock_key key = {0};
+    if (from_client) {
+        key.srcIP = conn_tuple.saddr_l;
+        key.dstIP = conn_tuple.daddr_l;
+        key.srcPort = conn_tuple.sport;
+        key.dstPort = conn_tuple.dport;
+    } else {
+        key.srcIP = conn_tuple.daddr_l;
+        key.dstIP = conn_tuple.saddr_l;
+        key.srcPort = conn_tuple.dport;
+        key.dstPort = conn_tuple.sport;
+    }
+
+    __u64 now = bpf_ktime_get_ns();
+    __u64 len = skb->len - offset;
+    __u64 cookie = bpf_get_socket_cookie(skb);
+    bool handshaking = long_header;
+    quic_conn_t *conn = bpf_map_lookup_elem(&conn_map, &key);
+    if (!conn) {
+        quic_conn_t new_conn = {0};
+        new_conn.first_ts = now;
+        new_conn.server_is_pod = from_pod != from_client;
+        bpf_map_update_elem(&conn_map, &key, &new_conn, BPF_NOEXIST);
+        conn = bpf_map_lookup_elem(&conn_map, &key);
+        if (!conn) {
+            return 0;
+        }
+    }
+    conn->last_ts = now;
+    if (from_pod && cookie != 0) {
+        conn->cookie = cookie;
+    }
+    if (from_client) {
+        __sync_fetch_and_add(&conn->client_packets, 1);
+        __sync_fetch_and_add(&conn->client_bytes, len);
+        if (!long_header && conn->client_short_ts == 0) {
+            conn->client_short_ts = now;
+        }
+        // long headers after the handshake are retransmissions or probes.
+        handshaking = handshaking && conn->client_short_ts == 0;
+    } else {
+        __sync_fetch_and_add(&conn->server_packets, 1);
+        __sync_fetch_and_add(&conn->server_bytes, len);
+        if (!long_header && conn->server_short_ts == 0) {
+            conn->server_short_ts = now;
+        }
+        handshaking = handshaking && conn->server_short_ts == 0;
+    }
+    if (!handshaking) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    quic_event_t *event = bpf_map_lookup_elem(&quic_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(quic_event_t));
+    event->payload_len = len < QUIC_PAYLOAD_SIZE ? len : QUIC_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->from_client = from_client;
+    event->cookie = cookie;
+    read_into_buffer_quic_payload(event->payload, skb, offset);
+
+    quic_event_key event_key = {0};
+    event_key.conn.srcIP = conn_tuple.saddr_l;
+    event_key.conn.dstIP = conn_tuple.daddr_l;
+    event_key.conn.srcPort = conn_tuple.sport;
+    event_key.conn.dstPort = conn_tuple.dport;
+    event_key.ts = now;
+    bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
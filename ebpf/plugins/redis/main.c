@@ -0,0 +1,179 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
+
+// the request is decoded in user space, the first bytes hold the command and the key.
+#define REDIS_REQUEST_SIZE 128
+// enough for the type and the message of error replies.
+#define REDIS_RESPONSE_SIZE 64
+
+typedef struct {
+    sock_key conn;
+    __u64 request_ts;
+} redis_event_key;
+
+typedef struct {
+    __u64 request_ts;
+    __u64 duration;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    __u16 request_len;
+    __u16 response_len;
+    __u32 pad;
+    char request[REDIS_REQUEST_SIZE];
+    char response[REDIS_RESPONSE_SIZE];
+} __attribute__((packed)) redis_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+// in-flight commands, key is composed in the client -> server direction.
+// pipelined commands overwrite each other, only the last one before the first reply is recorded.
+struct bpf_map_def SEC("maps/redis_processing_map") redis_processing_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(redis_event_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/redis_scratch_map") redis_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(redis_event_t),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(redis_event_key),
+    .value_size = sizeof(redis_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(redis_request, REDIS_REQUEST_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(redis_response, REDIS_RESPONSE_SIZE, BLK_SIZE)
+
+static __always_inline void compose_sock_key(sock_key *k, conn_tuple_t *tup, bool reverse) {
+    if (reverse) {
+        k->srcIP = tup->daddr_l;
+        k->dstIP = tup->saddr_l;
+        k->srcPort = tup->dport;
+        k->dstPort = tup->sport;
+        return;
+    }
+    k->srcIP = tup->saddr_l;
+    k->dstIP = tup->daddr_l;
+    k->srcPort = tup->sport;
+    k->dstPort = tup->dport;
+}
+
+// is_reply_type checks the first byte of RESP2 and RESP3 replies.
+static __always_inline bool is_reply_type(char c) {
+    switch (c) {
+    case '+':
+    case '-':
+    case ':':
+    case '$':
+    case '*':
+    case '_':
+    case ',':
+    case '#':
+    case '!':
+    case '=':
+    case '(':
+    case '%':
+    case '~':
+    case '>':
+        return true;
+    default:
+        return false;
+    }
+}
+
+static __always_inline void handle_request(struct __sk_buff *skb, sock_key *key, __u32 offset) {
+    __u32 zero = 0;
+    redis_event_t *event = bpf_map_lookup_elem(&redis_scratch_map, &zero);
+    if (!event) {
+        return;
+    }
+    bpf_memset(event, 0, sizeof(redis_event_t));
+    event->request_ts = bpf_ktime_get_ns();
+    event->cookie = bpf_get_socket_cookie(skb);
+    __u32 len = skb->len - offset;
+    event->request_len = len < REDIS_REQUEST_SIZE ? len : REDIS_REQUEST_SIZE;
+    read_into_buffer_redis_request(event->request, skb, offset);
+    bpf_map_update_elem(&redis_processing_map, key, event, BPF_ANY);
+}
+
+static __always_inline void handle_response(struct __sk_buff *skb, sock_key *key, __u32 offset) {
+    redis_event_t *event = bpf_map_lookup_elem(&redis_processing_map, key);
+    if (!event) {
+        return;
+    }
+    event->duration = bpf_ktime_get_ns() - event->request_ts;
+    __u32 len = skb->len - offset;
+    event->response_len = len < REDIS_RESPONSE_SIZE ? len : REDIS_RESPONSE_SIZE;
+    read_into_buffer_redis_response(event->response, skb, offset);
+
+    redis_event_key event_key = {0};
+    event_key.conn = *key;
+    event_key.request_ts = event->request_ts;
+    bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+    bpf_map_delete_elem(&redis_processing_map, key);
+}
+
+SEC("socket")
+int socket__redis_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    // the shortest frame is a reply like ":0\r\n".
+    if (skb_info.data_off + 4 > skb->len) {
+        return 0;
+    }
+
+    char first = 0;
+    if (bpf_skb_load_bytes(skb, skb_info.data_off, &first, sizeof(first)) < 0) {
+        return 0;
+    }
+
+    sock_key key = {0};
+    // commands are sent as an array of bulk strings, e.g. *2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n
+    compose_sock_key(&key, &conn_tuple, false);
+    if (first == '*' && bpf_map_lookup_elem(&filter_map, &key.srcIP) != NULL) {
+        handle_request(skb, &key, skb_info.data_off);
+        return 0;
+    }
+    if (is_reply_type(first)) {
+        compose_sock_key(&key, &conn_tuple, true);
+        handle_response(skb, &key, skb_info.data_off);
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,141 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// frames are decoded in user space, the json headers list the ext fields (topic, group) before the opaque.
+#define ROCKETMQ_PAYLOAD_SIZE 512
+
+// frame: total length, serialize type (1 byte) and header length (3 bytes), header, body.
+// see org.apache.rocketmq.remoting.protocol.RemotingCommand
+#define ROCKETMQ_PREFIX_SIZE 8
+#define ROCKETMQ_SERIALIZE_JSON 0
+#define ROCKETMQ_SERIALIZE_ROCKETMQ 1
+// binary header: code, language, version, opaque, flag
+#define ROCKETMQ_BINARY_HEADER_SIZE 13
+#define ROCKETMQ_MAX_LANGUAGE 12
+
+typedef socket_event_key rocketmq_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[ROCKETMQ_PAYLOAD_SIZE];
+} __attribute__((packed)) rocketmq_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/rocketmq_scratch_map") rocketmq_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(rocketmq_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a remoting command, the key is composed in the direction of the packet.
+// clients multiplex their requests on a connection, requests and responses are paired by opaque in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(rocketmq_event_key),
+    .value_size = sizeof(rocketmq_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(rocketmq_payload, ROCKETMQ_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_remoting_command checks the length prefix and the beginning of the header.
+static __always_inline bool is_remoting_command(const __u8 *buf) {
+    __u32 total_len = ((__u32)buf[0] << 24) | ((__u32)buf[1] << 16) | ((__u32)buf[2] << 8) | buf[3];
+    __u32 header_len = ((__u32)buf[5] << 16) | ((__u32)buf[6] << 8) | buf[7];
+    // frames are far below 16MB, headers below 64KB
+    if (buf[0] != 0 || buf[5] != 0 || header_len < 2 || header_len + 4 > total_len) {
+        return false;
+    }
+    const __u8 *header = buf + ROCKETMQ_PREFIX_SIZE;
+    switch (buf[4]) {
+    case ROCKETMQ_SERIALIZE_JSON:
+        return header[0] == '{' && header[1] == '"';
+    case ROCKETMQ_SERIALIZE_ROCKETMQ:
+        // the language code and the upper bytes of the flag
+        return header_len >= ROCKETMQ_BINARY_HEADER_SIZE && header[2] <= ROCKETMQ_MAX_LANGUAGE && header[9] == 0 &&
+               header[10] == 0;
+    default:
+        return false;
+    }
+}
+
+SEC("socket")
+int socket__rocketmq_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + ROCKETMQ_PREFIX_SIZE + ROCKETMQ_BINARY_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[ROCKETMQ_PREFIX_SIZE + ROCKETMQ_BINARY_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (!is_remoting_command(hdr)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    rocketmq_event_t *event = bpf_map_lookup_elem(&rocketmq_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(rocketmq_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < ROCKETMQ_PAYLOAD_SIZE ? len : ROCKETMQ_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_rocketmq_payload(event->payload, skb, offset);
+
+    rocketmq_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
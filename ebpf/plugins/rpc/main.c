@@ -5,9 +5,16 @@
 #include "../../include/common.h"
 #include "../../include/sock.h"
 #include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
 #include "../../include/redis.h"
 #include "../../include/amqp.h"
 
+// the classifiers are attached in direct-action mode next to the filters of the CNI, TC_ACT_UNSPEC passes
+// the packet on to the next filter instead of ending the chain as TC_ACT_OK does.
+#ifndef TC_ACT_UNSPEC
+#define TC_ACT_UNSPEC (-1)
+#endif
+
 struct bpf_map_def SEC("maps/package_map") grpc_trace_map = {
   	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(__u32),
@@ -43,6 +50,21 @@ struct bpf_map_def SEC("maps/package_map") tail_jmp_map = {
 	.max_entries = 16,
 };
 
+// the ip of the pod by the index of its veth, the tc mode shares one collection between the veths.
+struct bpf_map_def SEC("maps/package_map") tc_filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u64),
+    .max_entries = 4096,
+};
+
+struct bpf_map_def SEC("maps/package_map") tc_tail_jmp_map = {
+  	.type = BPF_MAP_TYPE_PROG_ARRAY,
+	.key_size = sizeof(__u32),
+	.value_size = sizeof(u32),
+	.max_entries = 16,
+};
+
 int __get_target_ip() {
     __u32 filter_ip_key = 1;
     __u32 *us_ipAddress;
@@ -54,8 +76,7 @@ int __get_target_ip() {
 }
 
 
-SEC("socket")
-int rpc__filter_package(struct __sk_buff *skb)
+static __always_inline int __filter_package(struct __sk_buff *skb, __u32 target_ip, bool tc)
 {
     skb_info_t skb_info = {0};
     conn_tuple_t skb_tup = {0};
@@ -65,6 +86,9 @@ int rpc__filter_package(struct __sk_buff *skb)
     if (!read_conn_tuple_skb(skb, &skb_info, &skb_tup)) {
         return 0;
     }
+    if (is_excluded_port(&skb_tup)) {
+        return 0;
+    }
     __init_buffer(skb, &skb_info, &buffer);
     const char *buf = &buffer.data[0];
     if (is_dubbo_magic(skb, &skb_info)) {
@@ -78,7 +102,11 @@ int rpc__filter_package(struct __sk_buff *skb)
     } else if (is_redis(buf, buffer.size, &skb_info, &pkg)) {
         pkg.rpc_type = PAYLOAD_REDIS;
     }  else if (is_amqp(&skb_tup, buf, buffer.size)) {
-        bpf_tail_call(skb, &tail_jmp_map, PROG_AMQP_FILTER);
+        if (tc) {
+            bpf_tail_call(skb, &tc_tail_jmp_map, PROG_AMQP_FILTER);
+        } else {
+            bpf_tail_call(skb, &tail_jmp_map, PROG_AMQP_FILTER);
+        }
         return 0;
     } else {
         rpc_status_t status = judge_rpc(skb, &skb_info, &pkg);
@@ -127,9 +155,7 @@ int rpc__filter_package(struct __sk_buff *skb)
         pkg.pid = pid_info->pid;
     }
     if (pkg.phase == P_REQUEST) {
-        __u32 ip;
-        ip = __get_target_ip();
-        if (ip != 0 && ip != pkg.srcIP) {
+        if (target_ip != 0 && target_ip != pkg.srcIP) {
             return 0;
         }
         sock_key req_conn = {0};
@@ -138,6 +164,7 @@ int rpc__filter_package(struct __sk_buff *skb)
         req_conn.srcPort = pkg.srcPort;
         req_conn.dstPort = pkg.dstPort;
         pkg.duration = bpf_ktime_get_ns();
+        pkg.cookie = bpf_get_socket_cookie(skb);
         bpf_map_update_elem(&grpc_request_map, &req_conn, &pkg, BPF_ANY);
     } else if (pkg.phase == P_RESPONSE) {
         sock_key req_conn = {0};
@@ -154,6 +181,8 @@ int rpc__filter_package(struct __sk_buff *skb)
             pkg.dstPort = req_conn.dstPort;
             pkg.duration = bpf_ktime_get_ns() - request_pkg->duration;
             pkg.path_len = request_pkg->path_len;
+            pkg.triple = request_pkg->triple;
+            pkg.cookie = request_pkg->cookie;
             for (int i = 0; i < MAX_HTTP2_PATH_CONTENT_LENGTH; i++) {
                 pkg.path[i] = request_pkg->path[i];
             }
@@ -167,8 +196,29 @@ int rpc__filter_package(struct __sk_buff *skb)
     return 0;
 }
 
-SEC("socket/amqp_filter")
-int socket__amqp_filter(struct __sk_buff* skb) {
+SEC("socket")
+int rpc__filter_package(struct __sk_buff *skb)
+{
+    return __filter_package(skb, __get_target_ip(), false);
+}
+
+// tc__filter_package is attached to the ingress and the egress of the clsact qdisc of the veths, the
+// veth of the packet is the ifindex of the skb, the ip 0 of the shared interfaces lets all the pods pass.
+// The packets are always passed on to the next filter (TC_ACT_UNSPEC), the amqp program tail called
+// returns it as well.
+SEC("classifier")
+int tc__filter_package(struct __sk_buff *skb)
+{
+    __u32 ifindex = skb->ifindex;
+    __u32 *target_ip = bpf_map_lookup_elem(&tc_filter_map, &ifindex);
+    if (!target_ip) {
+        return TC_ACT_UNSPEC;
+    }
+    __filter_package(skb, *target_ip, true);
+    return TC_ACT_UNSPEC;
+}
+
+static __always_inline int __amqp_filter(struct __sk_buff* skb) {
     skb_info_t skb_info = {0};
     conn_tuple_t skb_tup = {0};
     if (!read_conn_tuple_skb(skb, &skb_info, &skb_tup)) {
@@ -240,4 +290,16 @@ int socket__amqp_filter(struct __sk_buff* skb) {
     }
     return 0;
 }
+
+SEC("socket/amqp_filter")
+int socket__amqp_filter(struct __sk_buff* skb) {
+    return __amqp_filter(skb);
+}
+
+SEC("classifier/amqp_filter")
+int tc__amqp_filter(struct __sk_buff* skb) {
+    __amqp_filter(skb);
+    return TC_ACT_UNSPEC;
+}
+
 char _license[] SEC("license") = "GPL";
@@ -0,0 +1,161 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// commands and replies are pipelined by most clients, the lines of a packet are split in user space.
+#define SMTP_PAYLOAD_SIZE 256
+// command verb and separator, reply code and separator
+#define SMTP_HEADER_SIZE 5
+// the message ends with a line holding a single dot
+#define SMTP_DATA_END_SIZE 5
+
+// kinds of the captured packets
+#define SMTP_KIND_LINES 0
+#define SMTP_KIND_DATA_END 1
+
+typedef socket_event_key smtp_event_key;
+
+typedef struct {
+    // SMTP_KIND_DATA_END if the packet ends the message, its payload is the body.
+    SOCKET_EVENT_HEADER(kind)
+    char payload[SMTP_PAYLOAD_SIZE];
+} __attribute__((packed)) smtp_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/smtp_scratch_map") smtp_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(smtp_event_t),
+    .max_entries = 1,
+};
+
+// commands, replies and the end of the messages, the key is composed in the direction of the packet.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(smtp_event_key),
+    .value_size = sizeof(smtp_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(smtp_payload, SMTP_PAYLOAD_SIZE, BLK_SIZE)
+
+static __always_inline bool is_upper(__u8 c) {
+    return c >= 'A' && c <= 'Z';
+}
+
+static __always_inline bool is_digit(__u8 c) {
+    return c >= '0' && c <= '9';
+}
+
+// is_command checks the verb of a command line, e.g. "MAIL ", "DATA\r", "STARTTLS".
+static __always_inline bool is_command(const __u8 *buf) {
+    return is_upper(buf[0]) && is_upper(buf[1]) && is_upper(buf[2]) && is_upper(buf[3]) &&
+           (buf[4] == ' ' || buf[4] == '\r' || is_upper(buf[4]));
+}
+
+// is_reply checks the code of a reply line, e.g. "250 ", "250-" for the lines before the last one.
+static __always_inline bool is_reply(const __u8 *buf) {
+    return buf[0] >= '2' && buf[0] <= '5' && is_digit(buf[1]) && is_digit(buf[2]) &&
+           (buf[3] == ' ' || buf[3] == '-');
+}
+
+static __always_inline bool is_data_end(const __u8 *buf) {
+    return buf[0] == '\r' && buf[1] == '\n' && buf[2] == '.' && buf[3] == '\r' && buf[4] == '\n';
+}
+
+SEC("socket")
+int socket__smtp_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset >= skb->len) {
+        return 0;
+    }
+    __u32 len = skb->len - offset;
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    // commands of the pod and the replies to it, sessions of server pods are ignored in user space.
+    __u8 kind = SMTP_KIND_LINES;
+    __u8 hdr[SMTP_HEADER_SIZE] = {0};
+    if (len >= SMTP_HEADER_SIZE && bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (from_pod) {
+        __u8 end[SMTP_DATA_END_SIZE] = {0};
+        if (len >= SMTP_DATA_END_SIZE) {
+            bpf_skb_load_bytes(skb, skb->len - SMTP_DATA_END_SIZE, end, sizeof(end));
+        } else if (len == SMTP_DATA_END_SIZE - 2) {
+            // the dot line sent alone after the message
+            end[0] = '\r';
+            end[1] = '\n';
+            bpf_skb_load_bytes(skb, offset, &end[2], SMTP_DATA_END_SIZE - 2);
+        }
+        if (is_data_end(end)) {
+            kind = SMTP_KIND_DATA_END;
+        } else if (!is_command(hdr)) {
+            return 0;
+        }
+    } else if (!is_reply(hdr)) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    smtp_event_t *event = bpf_map_lookup_elem(&smtp_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(smtp_event_t));
+    event->payload_len = len < SMTP_PAYLOAD_SIZE ? len : SMTP_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    event->kind = kind;
+    read_into_buffer_smtp_payload(event->payload, skb, offset);
+
+    smtp_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,153 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// commands are decoded in user space, the class name and the header map (service, method) follow the fixed header.
+#define BOLT_PAYLOAD_SIZE 512
+
+// see com.alipay.remoting.rpc.protocol.RpcProtocol and RpcProtocolV2
+#define BOLT_PROTOCOL_V1 1
+#define BOLT_PROTOCOL_V2 2
+// the fixed header of v1 responses, the shortest one
+#define BOLT_MIN_HEADER_SIZE 20
+#define BOLT_TYPE_RESPONSE 0
+#define BOLT_TYPE_REQUEST 1
+#define BOLT_TYPE_ONEWAY 2
+#define BOLT_CMD_REQUEST 1
+#define BOLT_CMD_RESPONSE 2
+
+typedef socket_event_key bolt_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[BOLT_PAYLOAD_SIZE];
+} __attribute__((packed)) bolt_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/bolt_scratch_map") bolt_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(bolt_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a bolt rpc command, the key is composed in the direction of the packet.
+// clients multiplex their requests on a connection, requests and responses are paired by request id in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(bolt_event_key),
+    .value_size = sizeof(bolt_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(bolt_payload, BOLT_PAYLOAD_SIZE, BLK_SIZE)
+
+// is_bolt_command checks the protocol, the type and the command code, heartbeats are left out.
+static __always_inline bool is_bolt_command(const __u8 *buf) {
+    const __u8 *cmd = buf;
+    switch (buf[0]) {
+    case BOLT_PROTOCOL_V1:
+        cmd = buf + 1;
+        break;
+    case BOLT_PROTOCOL_V2:
+        // the protocol version follows the protocol code
+        if (buf[1] != 1 && buf[1] != 2) {
+            return false;
+        }
+        cmd = buf + 2;
+        break;
+    default:
+        return false;
+    }
+    if (cmd[1] != 0) {
+        return false;
+    }
+    switch (cmd[0]) {
+    case BOLT_TYPE_REQUEST:
+    case BOLT_TYPE_ONEWAY:
+        return cmd[2] == BOLT_CMD_REQUEST;
+    case BOLT_TYPE_RESPONSE:
+        return cmd[2] == BOLT_CMD_RESPONSE;
+    default:
+        return false;
+    }
+}
+
+SEC("socket")
+int socket__sofarpc_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    if (offset + BOLT_MIN_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[5];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (!is_bolt_command(hdr)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    bolt_event_t *event = bpf_map_lookup_elem(&bolt_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(bolt_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < BOLT_PAYLOAD_SIZE ? len : BOLT_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_bolt_payload(event->payload, skb, offset);
+
+    bolt_event_key key = {0};
+    key.conn.srcIP = conn_tuple.saddr_l;
+    key.conn.dstIP = conn_tuple.daddr_l;
+    key.conn.srcPort = conn_tuple.sport;
+    key.conn.dstPort = conn_tuple.dport;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
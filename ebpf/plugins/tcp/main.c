@@ -0,0 +1,424 @@
+#include <linux/kconfig.h>
+#include <net/sock.h>
+#include <linux/tcp.h>
+#include <linux/ip.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+#include "../../include/event_budget.h"
+
+// budget probe id of the connects and timeouts of inet_sock_set_state, the roles of the sockets are not
+// limited: they are overwritten in place.
+#define SET_STATE_PROBE 0
+
+// connection as seen from the socket: local and remote address
+struct conn_key_t {
+    __u32 saddr;
+    __u32 daddr;
+    __u16 sport;
+    __u16 dport;
+};
+
+// counters of a connection since the agent last read them, the retransmits and resets are counted by the
+// agent from the events of ebpf/plugins/tcpevents, which owns their kprobes.
+struct tcp_stats_t {
+    __u64 retransmits;
+    // sum and count of the smoothed rtt (us) sampled on the received segments
+    __u64 rtt_sum;
+    __u64 rtt_count;
+    __u32 rtt_max;
+    // resets sent by the socket and received from the peer
+    __u32 resets_sent;
+    __u32 resets_received;
+    // established connections closed by the retransmission or keepalive timer (ETIMEDOUT)
+    __u32 timeouts;
+    // ROLE_* of the socket when it timed out
+    __u8 role;
+    __u8 pad[7];
+    // cookie of the socket, the join key of the connection with the events of tcpevents and the requests
+    // of the protocol plugins, 0 until it is generated.
+    __u64 cookie;
+};
+
+#define ROLE_UNKNOWN 0
+#define ROLE_CLIENT 1
+#define ROLE_SERVER 2
+
+struct bpf_map_def SEC("maps/tcp_stats_map") tcp_stats_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(struct conn_key_t),
+    .value_size = sizeof(struct tcp_stats_t),
+    .max_entries = 1024 * 64,
+};
+
+// ROLE_* of the connections established since the agent started, set as they leave the handshake, looked
+// up by the agent for the resets.
+struct bpf_map_def SEC("maps/conn_role_map") conn_role_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(struct conn_key_t),
+    .value_size = sizeof(__u8),
+    .max_entries = 1024 * 64,
+};
+
+// connect of a socket, from the connect call to the handshake completing or failing.
+struct tcp_connect_t {
+    __u64 ts;
+    __u64 latency;
+    // addresses of the socket, the first 4 bytes of AF_INET
+    __u8 saddr[16];
+    __u8 daddr[16];
+    __u16 sport;
+    __u16 dport;
+    __u16 family;
+    // 1 once established
+    __u16 established;
+    // error of the failed connects (ECONNREFUSED, ETIMEDOUT, ...), 0 if the socket was closed meanwhile
+    __u32 err;
+    __u32 pad;
+};
+
+// fields of the inet_sock_set_state tracepoint, see /sys/kernel/debug/tracing/events/sock/inet_sock_set_state/format
+struct inet_sock_set_state_args {
+    __u64 pad;
+    const void *skaddr;
+    int oldstate;
+    int newstate;
+    __u16 sport;
+    __u16 dport;
+    __u16 family;
+    __u16 protocol;
+    __u8 saddr[4];
+    __u8 daddr[4];
+    __u8 saddr_v6[16];
+    __u8 daddr_v6[16];
+};
+
+// start of the connects in progress, by socket.
+struct bpf_map_def SEC("maps/connect_start_map") connect_start_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(__u64),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/tcp_connect_map") tcp_connect_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(struct tcp_connect_t),
+    .max_entries = 1024 * 16,
+};
+
+// listening socket of a pod: the address of the pod and the port listened on.
+struct listen_key_t {
+    __u32 addr;
+    __u16 port;
+    __u16 pad;
+};
+
+// queues of a listening socket since the agent last read them.
+struct listen_stats_t {
+    // SYNs received with the SYN queue full, dropped or answered with a syncookie
+    __u64 syn_overflows;
+    // SYNs and handshake completing ACKs dropped with the accept queue full
+    __u64 accept_overflows;
+    // deepest accept queue sampled as the connections are accepted, and the backlog of the socket
+    __u32 accept_queue_max;
+    __u32 backlog;
+};
+
+struct bpf_map_def SEC("maps/listen_stats_map") listen_stats_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(struct listen_key_t),
+    .value_size = sizeof(struct listen_stats_t),
+    .max_entries = 1024 * 4,
+};
+
+// the listening sockets are bound to any address in the pods, their pod address is learned from the SYNs.
+struct bpf_map_def SEC("maps/listener_map") listener_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(struct listen_key_t),
+    .max_entries = 1024 * 4,
+};
+
+static __always_inline bool read_conn_key(struct sock *sk, struct conn_key_t *key) {
+    __u16 family = 0;
+    BPF_PROBE_READ_INTO(&family, sk, __sk_common.skc_family);
+    if (family != AF_INET) {
+        return false;
+    }
+    BPF_PROBE_READ_INTO(&key->saddr, sk, __sk_common.skc_rcv_saddr);
+    BPF_PROBE_READ_INTO(&key->daddr, sk, __sk_common.skc_daddr);
+    BPF_PROBE_READ_INTO(&key->sport, sk, __sk_common.skc_num);
+    BPF_PROBE_READ_INTO(&key->dport, sk, __sk_common.skc_dport);
+    key->dport = bpf_ntohs(key->dport);
+    return true;
+}
+
+static __always_inline struct tcp_stats_t *key_stats(struct conn_key_t *key) {
+    struct tcp_stats_t *stats = bpf_map_lookup_elem(&tcp_stats_map, key);
+    if (stats != NULL) {
+        return stats;
+    }
+    struct tcp_stats_t init = {0};
+    bpf_map_update_elem(&tcp_stats_map, key, &init, BPF_NOEXIST);
+    return bpf_map_lookup_elem(&tcp_stats_map, key);
+}
+
+static __always_inline struct tcp_stats_t *conn_stats(struct sock *sk) {
+    struct conn_key_t key = {0};
+    if (sk == NULL || !read_conn_key(sk, &key)) {
+        return NULL;
+    }
+    struct tcp_stats_t *stats = key_stats(&key);
+    if (stats != NULL && stats->cookie == 0) {
+        BPF_PROBE_READ_INTO(&stats->cookie, sk, __sk_common.skc_cookie.counter);
+    }
+    return stats;
+}
+
+// closed returns the stats of a connection timed out, with the role of its socket.
+static __always_inline struct tcp_stats_t *closed(struct sock *sk) {
+    struct conn_key_t key = {0};
+    if (sk == NULL || !read_conn_key(sk, &key)) {
+        return NULL;
+    }
+    struct tcp_stats_t *stats = key_stats(&key);
+    if (stats == NULL) {
+        return NULL;
+    }
+    if (stats->cookie == 0) {
+        BPF_PROBE_READ_INTO(&stats->cookie, sk, __sk_common.skc_cookie.counter);
+    }
+    __u8 *role = bpf_map_lookup_elem(&conn_role_map, &key);
+    if (role != NULL) {
+        stats->role = *role;
+    }
+    return stats;
+}
+
+// the smoothed rtt is sampled on the segments received on the established connections.
+SEC("kprobe/tcp_rcv_established")
+int kprobe_tcp_rcv_established(struct pt_regs *ctx) {
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    __u32 srtt = 0;
+    // srtt_us is the smoothed rtt << 3
+    BPF_PROBE_READ_INTO(&srtt, (struct tcp_sock *)sk, srtt_us);
+    srtt >>= 3;
+    if (srtt == 0) {
+        return 0;
+    }
+    struct tcp_stats_t *stats = conn_stats(sk);
+    if (stats == NULL) {
+        return 0;
+    }
+    __sync_fetch_and_add(&stats->rtt_sum, srtt);
+    __sync_fetch_and_add(&stats->rtt_count, 1);
+    if (srtt > stats->rtt_max) {
+        stats->rtt_max = srtt;
+    }
+    return 0;
+}
+
+static __always_inline struct listen_stats_t *listen_stats(struct sock *sk) {
+    __u64 listener = (__u64)sk;
+    struct listen_key_t *key = bpf_map_lookup_elem(&listener_map, &listener);
+    if (key == NULL) {
+        return NULL;
+    }
+    struct listen_stats_t *stats = bpf_map_lookup_elem(&listen_stats_map, key);
+    if (stats != NULL) {
+        return stats;
+    }
+    struct listen_stats_t init = {0};
+    bpf_map_update_elem(&listen_stats_map, key, &init, BPF_NOEXIST);
+    return bpf_map_lookup_elem(&listen_stats_map, key);
+}
+
+// the accept queue is full once it holds more than the backlog, see sk_acceptq_is_full.
+static __always_inline bool accept_queue_full(struct sock *sk, __u32 *backlog) {
+    __u32 ack_backlog = 0;
+    BPF_PROBE_READ_INTO(&ack_backlog, sk, sk_ack_backlog);
+    BPF_PROBE_READ_INTO(backlog, sk, sk_max_ack_backlog);
+    return ack_backlog > *backlog;
+}
+
+// a SYN to a listening socket, before the request is queued, see tcp_conn_request.
+SEC("kprobe/tcp_conn_request")
+int kprobe_tcp_conn_request(struct pt_regs *ctx) {
+    struct sock *sk = (struct sock *)PT_REGS_PARM3(ctx);
+    struct sk_buff *skb = (struct sk_buff *)PT_REGS_PARM4(ctx);
+    __u16 family = 0;
+    BPF_PROBE_READ_INTO(&family, sk, __sk_common.skc_family);
+    if (family != AF_INET) {
+        return 0;
+    }
+    unsigned char *head = NULL;
+    __u16 network_header = 0;
+    BPF_PROBE_READ_INTO(&head, skb, head);
+    BPF_PROBE_READ_INTO(&network_header, skb, network_header);
+    struct iphdr iph = {0};
+    if (head == NULL || bpf_probe_read_kernel(&iph, sizeof(iph), head + network_header) < 0) {
+        return 0;
+    }
+    struct listen_key_t key = {0};
+    key.addr = iph.daddr;
+    BPF_PROBE_READ_INTO(&key.port, sk, __sk_common.skc_num);
+    __u64 listener = (__u64)sk;
+    bpf_map_update_elem(&listener_map, &listener, &key, BPF_ANY);
+
+    struct listen_stats_t *stats = listen_stats(sk);
+    if (stats == NULL) {
+        return 0;
+    }
+    __u32 backlog = 0;
+    if (accept_queue_full(sk, &backlog)) {
+        __sync_fetch_and_add(&stats->accept_overflows, 1);
+    }
+    int syn_queue = 0;
+    BPF_PROBE_READ_INTO(&syn_queue, (struct inet_connection_sock *)sk, icsk_accept_queue.qlen.counter);
+    if (syn_queue >= (int)backlog) {
+        __sync_fetch_and_add(&stats->syn_overflows, 1);
+    }
+    stats->backlog = backlog;
+    return 0;
+}
+
+// the ACK completing a handshake, the child socket is dropped with the accept queue full.
+SEC("kprobe/tcp_v4_syn_recv_sock")
+int kprobe_tcp_v4_syn_recv_sock(struct pt_regs *ctx) {
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    __u32 backlog = 0;
+    if (!accept_queue_full(sk, &backlog)) {
+        return 0;
+    }
+    struct listen_stats_t *stats = listen_stats(sk);
+    if (stats == NULL) {
+        return 0;
+    }
+    __sync_fetch_and_add(&stats->accept_overflows, 1);
+    return 0;
+}
+
+// the depth of the accept queue is sampled as the application accepts the connections.
+SEC("kprobe/inet_csk_accept")
+int kprobe_inet_csk_accept(struct pt_regs *ctx) {
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    struct listen_stats_t *stats = listen_stats(sk);
+    if (stats == NULL) {
+        return 0;
+    }
+    __u32 ack_backlog = 0, backlog = 0;
+    BPF_PROBE_READ_INTO(&ack_backlog, sk, sk_ack_backlog);
+    BPF_PROBE_READ_INTO(&backlog, sk, sk_max_ack_backlog);
+    if (ack_backlog > stats->accept_queue_max) {
+        stats->accept_queue_max = ack_backlog;
+    }
+    stats->backlog = backlog;
+    return 0;
+}
+
+static __always_inline int connect_start(struct sock *sk) {
+    __u64 key = (__u64)sk;
+    __u64 now = bpf_ktime_get_ns();
+    bpf_map_update_elem(&connect_start_map, &key, &now, BPF_ANY);
+    return 0;
+}
+
+SEC("kprobe/tcp_v4_connect")
+int kprobe_tcp_v4_connect(struct pt_regs *ctx) {
+    return connect_start((struct sock *)PT_REGS_PARM1(ctx));
+}
+
+SEC("kprobe/tcp_v6_connect")
+int kprobe_tcp_v6_connect(struct pt_regs *ctx) {
+    return connect_start((struct sock *)PT_REGS_PARM1(ctx));
+}
+
+static __always_inline void set_role(struct inet_sock_set_state_args *ctx, __u8 role) {
+    if (ctx->family != AF_INET) {
+        return;
+    }
+    struct conn_key_t key = {0};
+    __builtin_memcpy(&key.saddr, ctx->saddr, 4);
+    __builtin_memcpy(&key.daddr, ctx->daddr, 4);
+    key.sport = ctx->sport;
+    key.dport = ctx->dport;
+    bpf_map_update_elem(&conn_role_map, &key, &role, BPF_ANY);
+}
+
+// the established connections closed with ETIMEDOUT were given up by the retransmission or keepalive timer,
+// the connects timing out are reported with the connects.
+static __always_inline int close_end(struct inet_sock_set_state_args *ctx) {
+    int err = 0;
+    BPF_PROBE_READ_INTO(&err, (struct sock *)ctx->skaddr, sk_err);
+    if (err != ETIMEDOUT || !event_budget_allow(SET_STATE_PROBE)) {
+        return 0;
+    }
+    struct tcp_stats_t *stats = closed((struct sock *)ctx->skaddr);
+    if (stats == NULL) {
+        return 0;
+    }
+    __sync_fetch_and_add(&stats->timeouts, 1);
+    return 0;
+}
+
+// the handshake of a connect ends with the socket leaving SYN_SENT, established or closed.
+static __always_inline int connect_end(struct inet_sock_set_state_args *ctx) {
+    __u64 key = (__u64)ctx->skaddr;
+    __u64 *start = bpf_map_lookup_elem(&connect_start_map, &key);
+    if (start == NULL) {
+        return 0;
+    }
+    struct tcp_connect_t event = {0};
+    event.ts = bpf_ktime_get_ns();
+    event.latency = event.ts - *start;
+    bpf_map_delete_elem(&connect_start_map, &key);
+    if (!event_budget_allow(SET_STATE_PROBE)) {
+        return 0;
+    }
+
+    event.sport = ctx->sport;
+    event.dport = ctx->dport;
+    event.family = ctx->family;
+    if (ctx->family == AF_INET) {
+        __builtin_memcpy(event.saddr, ctx->saddr, 4);
+        __builtin_memcpy(event.daddr, ctx->daddr, 4);
+    } else {
+        __builtin_memcpy(event.saddr, ctx->saddr_v6, 16);
+        __builtin_memcpy(event.daddr, ctx->daddr_v6, 16);
+    }
+    if (ctx->newstate == TCP_ESTABLISHED) {
+        event.established = 1;
+        set_role(ctx, ROLE_CLIENT);
+    } else {
+        // tcp_reset and tcp_write_err set the error before the socket is closed.
+        int err = 0;
+        BPF_PROBE_READ_INTO(&err, (struct sock *)ctx->skaddr, sk_err);
+        event.err = err;
+    }
+    // the timestamp is unique enough as key, a collision only loses one connect.
+    bpf_map_update_elem(&tcp_connect_map, &event.ts, &event, BPF_ANY);
+    return 0;
+}
+
+SEC("tracepoint/sock/inet_sock_set_state")
+int tracepoint_inet_sock_set_state(struct inet_sock_set_state_args *ctx) {
+    if (ctx->protocol != IPPROTO_TCP) {
+        return 0;
+    }
+    if (ctx->oldstate == TCP_SYN_RECV && ctx->newstate == TCP_ESTABLISHED) {
+        set_role(ctx, ROLE_SERVER);
+        return 0;
+    }
+    if (ctx->oldstate != TCP_SYN_SENT) {
+        if (ctx->newstate == TCP_CLOSE) {
+            return close_end(ctx);
+        }
+        return 0;
+    }
+    return connect_end(ctx);
+}
+
+char _license[] SEC("license") = "GPL";
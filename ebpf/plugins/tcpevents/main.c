@@ -0,0 +1,87 @@
+#include <linux/kconfig.h>
+#include <net/sock.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+
+#include "../../include/event_budget.h"
+
+#define TCP_EVENT_RETRANSMIT 1
+#define TCP_EVENT_ZERO_WINDOW 2
+#define TCP_EVENT_RESET_SENT 3
+#define TCP_EVENT_RESET_RECEIVED 4
+
+struct tcp_event_t {
+    __u64 ts;
+    __u32 saddr;
+    __u32 daddr;
+    __u16 sport;
+    __u16 dport;
+    __u8 type;
+    __u8 state;
+    __u16 pad;
+    // cookie of the socket, the same as bpf_get_socket_cookie once a socket filter generated it, 0 before.
+    __u64 cookie;
+};
+
+struct bpf_map_def SEC("maps/tcp_events_map") tcp_events_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(struct tcp_event_t),
+    .max_entries = 1024 * 16,
+};
+
+static __always_inline int record_event(struct sock *sk, __u8 type) {
+    if (sk == NULL) {
+        return 0;
+    }
+    __u16 family = 0;
+    BPF_PROBE_READ_INTO(&family, sk, __sk_common.skc_family);
+    if (family != AF_INET) {
+        return 0;
+    }
+    // the event type is the budget probe id, a retransmit storm must not flood the map and the agent.
+    if (!event_budget_allow(type)) {
+        return 0;
+    }
+
+    struct tcp_event_t event = {0};
+    event.ts = bpf_ktime_get_ns();
+    event.type = type;
+    BPF_PROBE_READ_INTO(&event.saddr, sk, __sk_common.skc_rcv_saddr);
+    BPF_PROBE_READ_INTO(&event.daddr, sk, __sk_common.skc_daddr);
+    BPF_PROBE_READ_INTO(&event.sport, sk, __sk_common.skc_num);
+    BPF_PROBE_READ_INTO(&event.dport, sk, __sk_common.skc_dport);
+    BPF_PROBE_READ_INTO(&event.state, sk, __sk_common.skc_state);
+    BPF_PROBE_READ_INTO(&event.cookie, sk, __sk_common.skc_cookie.counter);
+    event.dport = bpf_ntohs(event.dport);
+
+    // the timestamp is unique enough as key, a collision only loses one event.
+    __u64 key = event.ts;
+    bpf_map_update_elem(&tcp_events_map, &key, &event, BPF_ANY);
+    return 0;
+}
+
+SEC("kprobe/tcp_retransmit_skb")
+int kprobe_tcp_retransmit_skb(struct pt_regs *ctx) {
+    return record_event((struct sock *)PT_REGS_PARM1(ctx), TCP_EVENT_RETRANSMIT);
+}
+
+// zero window probes are sent while the peer advertises a zero receive window.
+SEC("kprobe/tcp_send_probe0")
+int kprobe_tcp_send_probe0(struct pt_regs *ctx) {
+    return record_event((struct sock *)PT_REGS_PARM1(ctx), TCP_EVENT_ZERO_WINDOW);
+}
+
+SEC("kprobe/tcp_send_active_reset")
+int kprobe_tcp_send_active_reset(struct pt_regs *ctx) {
+    return record_event((struct sock *)PT_REGS_PARM1(ctx), TCP_EVENT_RESET_SENT);
+}
+
+SEC("kprobe/tcp_reset")
+int kprobe_tcp_reset(struct pt_regs *ctx) {
+    return record_event((struct sock *)PT_REGS_PARM1(ctx), TCP_EVENT_RESET_RECEIVED);
+}
+
+char _license[] SEC("license") = "GPL";
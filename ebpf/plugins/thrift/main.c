@@ -0,0 +1,201 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/port_exclusion.h"
+
+// the message header (with the method name) and the beginning of the result struct
+// or the TApplicationException are decoded in user space.
+#define THRIFT_REQUEST_SIZE 128
+#define THRIFT_RESPONSE_SIZE 128
+
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-binary-protocol.md
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+#define THRIFT_BINARY_VERSION_1 0x80
+#define THRIFT_BINARY_VERSION_2 0x01
+#define THRIFT_COMPACT_PROTOCOL_ID 0x82
+#define THRIFT_COMPACT_VERSION 1
+
+#define THRIFT_CALL 1
+#define THRIFT_REPLY 2
+#define THRIFT_EXCEPTION 3
+#define THRIFT_ONEWAY 4
+
+typedef struct {
+    sock_key conn;
+    __u64 request_ts;
+} thrift_event_key;
+
+typedef struct {
+    __u64 request_ts;
+    __u64 duration;
+    // cookie of the socket of the client sending the request, 0 if it is not owned by a local socket.
+    __u64 cookie;
+    __u16 request_len;
+    __u16 response_len;
+    __u32 pad;
+    char request[THRIFT_REQUEST_SIZE];
+    char response[THRIFT_RESPONSE_SIZE];
+} __attribute__((packed)) thrift_event_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+// in-flight calls, key is composed in the client -> server direction.
+// calls of asynchronous clients overwrite each other, the sequence ids are compared in user space.
+struct bpf_map_def SEC("maps/thrift_processing_map") thrift_processing_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(thrift_event_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/thrift_scratch_map") thrift_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(thrift_event_t),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(thrift_event_key),
+    .value_size = sizeof(thrift_event_t),
+    .max_entries = 1024 * 16,
+};
+
+READ_INTO_BUFFER(thrift_request, THRIFT_REQUEST_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(thrift_response, THRIFT_RESPONSE_SIZE, BLK_SIZE)
+
+static __always_inline void compose_sock_key(sock_key *k, conn_tuple_t *tup, bool reverse) {
+    if (reverse) {
+        k->srcIP = tup->daddr_l;
+        k->dstIP = tup->saddr_l;
+        k->srcPort = tup->dport;
+        k->dstPort = tup->sport;
+        return;
+    }
+    k->srcIP = tup->saddr_l;
+    k->dstIP = tup->daddr_l;
+    k->srcPort = tup->sport;
+    k->dstPort = tup->dport;
+}
+
+// thrift_message_type returns the type of the message starting at buf, 0 if buf does not start a message
+// of the strict binary or the compact protocol.
+static __always_inline __u8 thrift_message_type(const __u8 *buf) {
+    __u8 type = 0;
+    if (buf[0] == THRIFT_BINARY_VERSION_1 && buf[1] == THRIFT_BINARY_VERSION_2 && buf[2] == 0) {
+        type = buf[3];
+    } else if (buf[0] == THRIFT_COMPACT_PROTOCOL_ID && (buf[1] & 0x1f) == THRIFT_COMPACT_VERSION) {
+        type = buf[1] >> 5;
+    }
+    if (type < THRIFT_CALL || type > THRIFT_ONEWAY) {
+        return 0;
+    }
+    return type;
+}
+
+static __always_inline void handle_request(struct __sk_buff *skb, sock_key *key, __u32 offset, bool oneway) {
+    __u32 zero = 0;
+    thrift_event_t *event = bpf_map_lookup_elem(&thrift_scratch_map, &zero);
+    if (!event) {
+        return;
+    }
+    bpf_memset(event, 0, sizeof(thrift_event_t));
+    event->request_ts = bpf_ktime_get_ns();
+    event->cookie = bpf_get_socket_cookie(skb);
+    __u32 len = skb->len - offset;
+    event->request_len = len < THRIFT_REQUEST_SIZE ? len : THRIFT_REQUEST_SIZE;
+    read_into_buffer_thrift_request(event->request, skb, offset);
+    // oneway calls have no reply, they are reported without duration.
+    if (oneway) {
+        thrift_event_key event_key = {0};
+        event_key.conn = *key;
+        event_key.request_ts = event->request_ts;
+        bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+        return;
+    }
+    bpf_map_update_elem(&thrift_processing_map, key, event, BPF_ANY);
+}
+
+static __always_inline void handle_response(struct __sk_buff *skb, sock_key *key, thrift_event_t *event,
+                                            __u32 offset) {
+    event->duration = bpf_ktime_get_ns() - event->request_ts;
+    __u32 len = skb->len - offset;
+    event->response_len = len < THRIFT_RESPONSE_SIZE ? len : THRIFT_RESPONSE_SIZE;
+    read_into_buffer_thrift_response(event->response, skb, offset);
+
+    thrift_event_key event_key = {0};
+    event_key.conn = *key;
+    event_key.request_ts = event->request_ts;
+    bpf_map_update_elem(&metrics_map, &event_key, event, BPF_ANY);
+    bpf_map_delete_elem(&thrift_processing_map, key);
+}
+
+SEC("socket")
+int socket__thrift_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    // the shortest messages are compact replies with a one byte name.
+    if (skb_info.data_off + 8 > skb->len) {
+        return 0;
+    }
+
+    __u8 buf[8] = {0};
+    if (bpf_skb_load_bytes(skb, skb_info.data_off, buf, sizeof(buf)) < 0) {
+        return 0;
+    }
+    __u8 type = thrift_message_type(buf);
+    // the framed transport prefixes the messages with their length, frames are far below 16MB.
+    if (type == 0 && buf[0] == 0) {
+        type = thrift_message_type(buf + 4);
+    }
+    if (type == 0) {
+        return 0;
+    }
+
+    sock_key key = {0};
+    if (type == THRIFT_REPLY || type == THRIFT_EXCEPTION) {
+        compose_sock_key(&key, &conn_tuple, true);
+        thrift_event_t *event = bpf_map_lookup_elem(&thrift_processing_map, &key);
+        if (event != NULL) {
+            handle_response(skb, &key, event, skb_info.data_off);
+        }
+        return 0;
+    }
+
+    compose_sock_key(&key, &conn_tuple, false);
+    // only record calls issued by the pod attached to this veth.
+    if (bpf_map_lookup_elem(&filter_map, &key.srcIP) == NULL) {
+        return 0;
+    }
+    handle_request(skb, &key, skb_info.data_off, type == THRIFT_ONEWAY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
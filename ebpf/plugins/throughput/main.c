@@ -0,0 +1,153 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+
+// indexes of counters_map: the packets sent by the pod of the veth and the packets it received.
+#define COUNTER_SENT 0
+#define COUNTER_RECEIVED 1
+
+// counters of a direction since the program was attached, they are never reset. The window is the one of
+// window_map when the first packet of window_start was counted, the agent drains the counters of a window.
+typedef struct {
+    __u64 packets;
+    __u64 bytes;
+    __u64 window;
+    // bpf_ktime_get_ns of the first packet of the window on this cpu.
+    __u64 window_start;
+} throughput_counter_t;
+
+// tcp and udp flow of the pod attached to this veth: its address and the address of the peer.
+typedef struct {
+    __u32 pod_ip;
+    __u32 peer_ip;
+    __u16 pod_port;
+    __u16 peer_port;
+} throughput_flow_key;
+
+// counters of a flow since the agent last read them, window_start is the bpf_ktime_get_ns of their first
+// packet.
+typedef struct {
+    __u64 packets_sent;
+    __u64 bytes_sent;
+    __u64 packets_received;
+    __u64 bytes_received;
+    __u64 window_start;
+} throughput_flow_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = 1,
+};
+
+// per-cpu, so that the counters are updated without atomics, the agent sums the cpus.
+struct bpf_map_def SEC("maps/counters_map") counters_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(throughput_counter_t),
+    .max_entries = 2,
+};
+
+// the window of the counters, the agent bumps it once it read them.
+struct bpf_map_def SEC("maps/window_map") window_map = {
+    .type = BPF_MAP_TYPE_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u64),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/flows_map") flows_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(throughput_flow_key),
+    .value_size = sizeof(throughput_flow_t),
+    .max_entries = 1024 * 8,
+};
+
+// count_flow adds a packet of the pod to its flow, the packets other than tcp and udp are left out.
+static __always_inline void count_flow(struct __sk_buff *skb, bool sent, __u64 len, __u64 now) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return;
+    }
+    throughput_flow_key key = {0};
+    if (sent) {
+        key.pod_ip = conn_tuple.saddr_l;
+        key.peer_ip = conn_tuple.daddr_l;
+        key.pod_port = conn_tuple.sport;
+        key.peer_port = conn_tuple.dport;
+    } else {
+        key.pod_ip = conn_tuple.daddr_l;
+        key.peer_ip = conn_tuple.saddr_l;
+        key.pod_port = conn_tuple.dport;
+        key.peer_port = conn_tuple.sport;
+    }
+    throughput_flow_t *flow = bpf_map_lookup_elem(&flows_map, &key);
+    if (flow == NULL) {
+        throughput_flow_t init = {0};
+        init.window_start = now;
+        bpf_map_update_elem(&flows_map, &key, &init, BPF_NOEXIST);
+        flow = bpf_map_lookup_elem(&flows_map, &key);
+        if (flow == NULL) {
+            return;
+        }
+    }
+    if (sent) {
+        __sync_fetch_and_add(&flow->packets_sent, 1);
+        __sync_fetch_and_add(&flow->bytes_sent, len);
+    } else {
+        __sync_fetch_and_add(&flow->packets_received, 1);
+        __sync_fetch_and_add(&flow->bytes_received, len);
+    }
+}
+
+SEC("socket")
+int socket__throughput_filter(struct __sk_buff *skb) {
+    if (load_half(skb, offsetof(struct ethhdr, h_proto)) != ETH_P_IP) {
+        return 0;
+    }
+    __u64 saddr = 0, daddr = 0;
+    read_ipv4_skb(skb, ETH_HLEN + offsetof(struct iphdr, saddr), &saddr);
+    read_ipv4_skb(skb, ETH_HLEN + offsetof(struct iphdr, daddr), &daddr);
+    __u32 source = saddr, dest = daddr;
+
+    __u32 index;
+    if (bpf_map_lookup_elem(&filter_map, &source) != NULL) {
+        index = COUNTER_SENT;
+    } else if (bpf_map_lookup_elem(&filter_map, &dest) != NULL) {
+        index = COUNTER_RECEIVED;
+    } else {
+        return 0;
+    }
+    throughput_counter_t *counter = bpf_map_lookup_elem(&counters_map, &index);
+    if (counter == NULL) {
+        return 0;
+    }
+    // the bytes of the ip packets, without the ethernet header.
+    __u64 len = skb->len - ETH_HLEN;
+    __u64 now = bpf_ktime_get_ns();
+    __u32 zero = 0;
+    __u64 *window = bpf_map_lookup_elem(&window_map, &zero);
+    if (window != NULL && (counter->window != *window || counter->window_start == 0)) {
+        counter->window = *window;
+        counter->window_start = now;
+    }
+    counter->packets++;
+    counter->bytes += len;
+    count_flow(skb, index == COUNTER_SENT, len, now);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,264 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+#include "../../include/socket_event.h"
+#include "../../include/port_exclusion.h"
+
+// hellos are decoded in user space, the server name of client hellos may be cut off by long cipher lists.
+#define TLS_PAYLOAD_SIZE 512
+
+// record header (content type, version, length) and handshake type
+#define TLS_HEADER_SIZE 6
+#define TLS_CONTENT_TYPE_HANDSHAKE 0x16
+#define TLS_HANDSHAKE_CLIENT_HELLO 1
+#define TLS_HANDSHAKE_SERVER_HELLO 2
+#define TLS_HANDSHAKE_CERTIFICATE 11
+
+// the certificate message follows the server hello in clear text up to TLS 1.2, TLS 1.3 encrypts it.
+// only the leaf certificate is captured, in chunks of the packets of the server.
+#define TLS_CERT_CHUNK_SIZE 1024
+#define TLS_CERT_CHUNKS 4
+#define TLS_CERT_MAX_SIZE (8 * 1024)
+// handshake header and the lengths of the certificate list and of the leaf
+#define TLS_CERT_HEADER_SIZE 10
+
+typedef socket_event_key tls_event_key;
+
+typedef struct {
+    SOCKET_EVENT_HEADER(pad)
+    char payload[TLS_PAYLOAD_SIZE];
+} __attribute__((packed)) tls_event_t;
+
+typedef struct {
+    // tcp sequence number of the certificate message
+    __u32 start_seq;
+    // bytes of the message up to the end of the leaf certificate
+    __u32 total;
+} tls_cert_capture_t;
+
+typedef struct {
+    __u32 start_seq;
+    // tcp sequence number of the payload
+    __u32 seq;
+    __u32 total;
+    __u16 payload_len;
+    __u16 pad;
+    char payload[TLS_CERT_CHUNK_SIZE];
+} __attribute__((packed)) tls_cert_chunk_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = FILTER_MAX_PODS,
+};
+
+struct bpf_map_def SEC("maps/tls_scratch_map") tls_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(tls_event_t),
+    .max_entries = 1,
+};
+
+// packets starting with a hello, the key is composed in the direction of the packet.
+// the hellos of a connection are paired in user space.
+struct bpf_map_def SEC("maps/metrics_map") metrics_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(tls_event_key),
+    .value_size = sizeof(tls_event_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/cert_scratch_map") cert_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(tls_cert_chunk_t),
+    .max_entries = 1,
+};
+
+// certificates whose capture continues in the next packets of the server, keyed in the direction of the server.
+struct bpf_map_def SEC("maps/cert_capture_map") cert_capture_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(sock_key),
+    .value_size = sizeof(tls_cert_capture_t),
+    .max_entries = 4096,
+};
+
+// chunks of the certificate messages, they are assembled by their sequence numbers in user space.
+struct bpf_map_def SEC("maps/cert_map") cert_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(tls_event_key),
+    .value_size = sizeof(tls_cert_chunk_t),
+    .max_entries = 1024 * 4,
+};
+
+READ_INTO_BUFFER(tls_payload, TLS_PAYLOAD_SIZE, BLK_SIZE)
+READ_INTO_BUFFER(tls_cert, TLS_CERT_CHUNK_SIZE, BLK_SIZE)
+
+// is_hello checks the record header of client and server hellos, SSL 3.0 to TLS 1.3 share the 0x03 major version.
+static __always_inline bool is_hello(const __u8 *buf) {
+    return buf[0] == TLS_CONTENT_TYPE_HANDSHAKE && buf[1] == 0x03 && buf[2] <= 0x04 &&
+           (buf[5] == TLS_HANDSHAKE_CLIENT_HELLO || buf[5] == TLS_HANDSHAKE_SERVER_HELLO);
+}
+
+// capture_cert captures the packet from offset, whose sequence number is seq, up to the end of the leaf.
+// It returns whether the leaf was captured whole.
+static __always_inline bool capture_cert(struct __sk_buff *skb, sock_key *conn, tls_cert_capture_t *capture,
+                                         __u32 offset, __u32 seq) {
+    __u32 zero = 0;
+    tls_cert_chunk_t *chunk = bpf_map_lookup_elem(&cert_scratch_map, &zero);
+    if (!chunk) {
+        return false;
+    }
+    tls_event_key key = {0};
+    key.conn = *conn;
+    key.ts = bpf_ktime_get_ns();
+#pragma unroll
+    for (int i = 0; i < TLS_CERT_CHUNKS; i++) {
+        __u32 captured = seq - capture->start_seq;
+        // retransmissions of the packets before the certificate
+        if ((__s32)captured < 0) {
+            return false;
+        }
+        if (captured >= capture->total) {
+            return true;
+        }
+        if (offset >= skb->len) {
+            return false;
+        }
+        __u32 len = skb->len - offset;
+        if (len > TLS_CERT_CHUNK_SIZE) {
+            len = TLS_CERT_CHUNK_SIZE;
+        }
+        bpf_memset(chunk, 0, sizeof(tls_cert_chunk_t));
+        chunk->start_seq = capture->start_seq;
+        chunk->seq = seq;
+        chunk->total = capture->total;
+        chunk->payload_len = len;
+        read_into_buffer_tls_cert(chunk->payload, skb, offset);
+        bpf_map_update_elem(&cert_map, &key, chunk, BPF_ANY);
+        key.ts++;
+        offset += len;
+        seq += len;
+    }
+    return seq - capture->start_seq >= capture->total;
+}
+
+// start_cert looks for the certificate message after the server hello at offset, in its record or in the next one.
+static __always_inline void start_cert(struct __sk_buff *skb, sock_key *conn, const __u8 *hdr, __u32 offset, __u32 seq) {
+    __u32 record_end = offset + 5 + ((hdr[3] << 8) | hdr[4]);
+    __u8 len[3];
+    if (bpf_skb_load_bytes(skb, offset + TLS_HEADER_SIZE, len, sizeof(len)) < 0) {
+        return;
+    }
+    __u32 next = offset + 5 + 4 + ((len[0] << 16) | (len[1] << 8) | len[2]);
+    if (next == record_end) {
+        __u8 next_hdr[TLS_HEADER_SIZE];
+        if (bpf_skb_load_bytes(skb, record_end, next_hdr, sizeof(next_hdr)) < 0 ||
+            next_hdr[0] != TLS_CONTENT_TYPE_HANDSHAKE) {
+            return;
+        }
+        next = record_end + 5;
+    } else if (next > record_end) {
+        return;
+    }
+
+    __u8 cert[TLS_CERT_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, next, cert, sizeof(cert)) < 0 || cert[0] != TLS_HANDSHAKE_CERTIFICATE) {
+        return;
+    }
+    tls_cert_capture_t capture = {0};
+    capture.start_seq = seq + (next - offset);
+    capture.total = TLS_CERT_HEADER_SIZE + ((cert[7] << 16) | (cert[8] << 8) | cert[9]);
+    if (capture.total > TLS_CERT_MAX_SIZE) {
+        return;
+    }
+    if (!capture_cert(skb, conn, &capture, next, capture.start_seq)) {
+        bpf_map_update_elem(&cert_capture_map, conn, &capture, BPF_ANY);
+    }
+}
+
+SEC("socket")
+int socket__tls_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (!(conn_tuple.metadata & CONN_TYPE_TCP) || conn_tuple.l3_proto != ETH_P_IP) {
+        return 0;
+    }
+    // only the ports excluded for tls, the global excluded ports are not applied to this plugin.
+    if (is_excluded_port(&conn_tuple)) {
+        return 0;
+    }
+    __u32 offset = skb_info.data_off;
+    sock_key conn = {0};
+    conn.srcIP = conn_tuple.saddr_l;
+    conn.dstIP = conn_tuple.daddr_l;
+    conn.srcPort = conn_tuple.sport;
+    conn.dstPort = conn_tuple.dport;
+    tls_cert_capture_t *capture = bpf_map_lookup_elem(&cert_capture_map, &conn);
+    if (capture != NULL) {
+        // retransmissions are captured again, the chunks are assembled by sequence number.
+        if (offset < skb->len && capture_cert(skb, &conn, capture, offset, skb_info.tcp_seq)) {
+            bpf_map_delete_elem(&cert_capture_map, &conn);
+        }
+        return 0;
+    }
+    if (offset + TLS_HEADER_SIZE > skb->len) {
+        return 0;
+    }
+
+    __u8 hdr[TLS_HEADER_SIZE];
+    if (bpf_skb_load_bytes(skb, offset, hdr, sizeof(hdr)) < 0) {
+        return 0;
+    }
+    if (!is_hello(hdr)) {
+        return 0;
+    }
+
+    __u8 from_pod = 0;
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        from_pod = 1;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) == NULL) {
+        return 0;
+    }
+
+    __u32 zero = 0;
+    tls_event_t *event = bpf_map_lookup_elem(&tls_scratch_map, &zero);
+    if (!event) {
+        return 0;
+    }
+    bpf_memset(event, 0, sizeof(tls_event_t));
+    __u32 len = skb->len - offset;
+    event->payload_len = len < TLS_PAYLOAD_SIZE ? len : TLS_PAYLOAD_SIZE;
+    event->from_pod = from_pod;
+    event->cookie = bpf_get_socket_cookie(skb);
+    read_into_buffer_tls_payload(event->payload, skb, offset);
+
+    tls_event_key key = {0};
+    key.conn = conn;
+    key.ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&metrics_map, &key, event, BPF_ANY);
+    if (hdr[5] == TLS_HANDSHAKE_SERVER_HELLO) {
+        start_cert(skb, &conn, hdr, offset, skb_info.tcp_seq);
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
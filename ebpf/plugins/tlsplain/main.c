@@ -0,0 +1,412 @@
+#include <linux/kconfig.h>
+#include <net/sock.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+
+#include "../../include/event_budget.h"
+
+// plaintext captured per call, the agent only decodes the heads of the messages.
+#define TLS_DATA_SIZE 1024
+
+#define TLS_DIRECTION_SEND 0
+#define TLS_DIRECTION_RECV 1
+
+// libraries of the probes, the budget probe id of their events.
+#define TLS_LIBRARY_JSSE 1
+#define TLS_LIBRARY_BORINGSSL 2
+#define TLS_LIBRARY_NODEJS 3
+#define TLS_LIBRARY_KTLS 4
+
+// connection as seen from the process: local and remote address
+struct tls_conn_t {
+    __u32 saddr;
+    __u32 daddr;
+    __u16 sport;
+    __u16 dport;
+};
+
+struct tls_data_event_t {
+    __u64 ts;
+    __u32 pid;
+    __u32 tid;
+    struct tls_conn_t conn;
+    // id of the connection given by the library, e.g. the identity of the java SSLEngine, 0 if unknown.
+    __u64 conn_id;
+    // length of the plaintext of the call, data holds at most TLS_DATA_SIZE - 1 bytes of it.
+    __u32 len;
+    __u8 direction;
+    __u8 library;
+    __u16 pad;
+    char data[TLS_DATA_SIZE];
+};
+
+// last connection read or written by each thread.
+struct bpf_map_def SEC("maps/tid_conn_map") tid_conn_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct tls_conn_t),
+    .max_entries = 1024 * 16,
+};
+
+// plaintext written before the thread sends it on its socket (e.g. SSLEngine.wrap), completed by tcp_sendmsg.
+struct bpf_map_def SEC("maps/pending_send_map") pending_send_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct tls_data_event_t),
+    .max_entries = 1024 * 4,
+};
+
+// arguments of the SSL_read and SSL_write calls of each thread, read on their return.
+struct ssl_args_t {
+    __u64 ssl;
+    __u64 buf;
+    // size_t *readbytes or *written of the _ex functions, 0 for the others.
+    __u64 processed;
+};
+
+struct bpf_map_def SEC("maps/ssl_args_map") ssl_args_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct ssl_args_t),
+    .max_entries = 1024 * 16,
+};
+
+// kTLS reads of each thread, read on their return.
+struct ktls_recv_t {
+    struct sock *sk;
+    __u64 buf;
+};
+
+struct bpf_map_def SEC("maps/ktls_recv_map") ktls_recv_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct ktls_recv_t),
+    .max_entries = 1024 * 16,
+};
+
+struct bpf_map_def SEC("maps/tls_scratch_map") tls_scratch_map = {
+    .type = BPF_MAP_TYPE_PERCPU_ARRAY,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(struct tls_data_event_t),
+    .max_entries = 1,
+};
+
+// events polled by the agent, keyed by their timestamp.
+struct bpf_map_def SEC("maps/tls_data_map") tls_data_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u64),
+    .value_size = sizeof(struct tls_data_event_t),
+    .max_entries = 1024 * 4,
+};
+
+static __always_inline bool read_conn(struct sock *sk, struct tls_conn_t *conn) {
+    __u16 family = 0;
+    BPF_PROBE_READ_INTO(&family, sk, __sk_common.skc_family);
+    if (family != AF_INET) {
+        return false;
+    }
+    BPF_PROBE_READ_INTO(&conn->saddr, sk, __sk_common.skc_rcv_saddr);
+    BPF_PROBE_READ_INTO(&conn->daddr, sk, __sk_common.skc_daddr);
+    BPF_PROBE_READ_INTO(&conn->sport, sk, __sk_common.skc_num);
+    BPF_PROBE_READ_INTO(&conn->dport, sk, __sk_common.skc_dport);
+    conn->dport = bpf_ntohs(conn->dport);
+    return true;
+}
+
+// new_event fills the scratch event with the plaintext at buf, the connection is left to the caller.
+static __always_inline struct tls_data_event_t *new_event(const void *buf, __u32 len, __u8 direction, __u8 library) {
+    __u32 zero = 0;
+    struct tls_data_event_t *event = bpf_map_lookup_elem(&tls_scratch_map, &zero);
+    if (event == NULL) {
+        return NULL;
+    }
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    event->ts = bpf_ktime_get_ns();
+    event->pid = pid_tgid >> 32;
+    event->tid = (__u32)pid_tgid;
+    event->conn_id = 0;
+    event->len = len;
+    event->direction = direction;
+    event->library = library;
+    __builtin_memset(&event->conn, 0, sizeof(event->conn));
+    __u32 n = len < TLS_DATA_SIZE ? len : TLS_DATA_SIZE - 1;
+    bpf_probe_read_user(event->data, n & (TLS_DATA_SIZE - 1), buf);
+    return event;
+}
+
+static __always_inline void output(struct tls_data_event_t *event) {
+    // the timestamp is unique enough as key, a collision only loses one event.
+    __u64 key = event->ts;
+    bpf_map_update_elem(&tls_data_map, &key, event, BPF_ANY);
+}
+
+// track_conn records the connection of the thread, it completes the plaintext written before.
+static __always_inline void track_conn(struct sock *sk, bool send) {
+    struct tls_conn_t conn = {0};
+    if (!read_conn(sk, &conn)) {
+        return;
+    }
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&tid_conn_map, &tid, &conn, BPF_ANY);
+    if (!send) {
+        return;
+    }
+    struct tls_data_event_t *pending = bpf_map_lookup_elem(&pending_send_map, &tid);
+    if (pending == NULL) {
+        return;
+    }
+    pending->conn = conn;
+    output(pending);
+    bpf_map_delete_elem(&pending_send_map, &tid);
+}
+
+SEC("kprobe/tcp_sendmsg")
+int kprobe_tcp_sendmsg(struct pt_regs *ctx) {
+    track_conn((struct sock *)PT_REGS_PARM1(ctx), true);
+    return 0;
+}
+
+SEC("kprobe/tcp_recvmsg")
+int kprobe_tcp_recvmsg(struct pt_regs *ctx) {
+    track_conn((struct sock *)PT_REGS_PARM1(ctx), false);
+    return 0;
+}
+
+// record outputs the plaintext of a call of the library, on the last socket read or written by the thread. The
+// libraries do not know their socket. The plaintext of the libraries encrypting in a buffer (defer_send) is sent
+// on the next socket written by the thread instead.
+static __always_inline void record(__u64 id, const void *buf, int len, __u8 direction, __u8 library, bool defer_send) {
+    if (len <= 0 || !event_budget_allow(library)) {
+        return;
+    }
+    struct tls_data_event_t *event = new_event(buf, len, direction, library);
+    if (event == NULL) {
+        return;
+    }
+    event->conn_id = id;
+    if (direction == TLS_DIRECTION_SEND && defer_send) {
+        bpf_map_update_elem(&pending_send_map, &event->tid, event, BPF_ANY);
+        return;
+    }
+    struct tls_conn_t *conn = bpf_map_lookup_elem(&tid_conn_map, &event->tid);
+    if (conn == NULL) {
+        return;
+    }
+    event->conn = *conn;
+    output(event);
+}
+
+// erda_jsse_probe(__u64 id, const char *buf, int len, int direction) is called by the jsse probe agent with the
+// plaintext of the SSLEngines of the JVM, see tools/jsse-probe. The engines wrap into a buffer the JVM writes after.
+SEC("uprobe/erda_jsse_probe")
+int uprobe_erda_jsse_probe(struct pt_regs *ctx) {
+    record((__u64)PT_REGS_PARM1(ctx), (const void *)PT_REGS_PARM2(ctx), (int)PT_REGS_PARM3(ctx),
+           (__u8)PT_REGS_PARM4(ctx), TLS_LIBRARY_JSSE, true);
+    return 0;
+}
+
+// int SSL_write(SSL *ssl, const void *buf, int num) and int SSL_read(SSL *ssl, void *buf, int num) of BoringSSL
+// and OpenSSL. The plaintext is read on the return, with the bytes written or read. The _ex functions of OpenSSL
+// 1.1.1 and later return 1 and pass the bytes in their last argument.
+static __always_inline void save_ssl_args(struct pt_regs *ctx, bool ex) {
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    struct ssl_args_t args = {
+        .ssl = (__u64)PT_REGS_PARM1(ctx),
+        .buf = (__u64)PT_REGS_PARM2(ctx),
+        .processed = ex ? (__u64)PT_REGS_PARM4(ctx) : 0,
+    };
+    bpf_map_update_elem(&ssl_args_map, &tid, &args, BPF_ANY);
+}
+
+static __always_inline void record_ssl(struct pt_regs *ctx, __u8 direction, __u8 library, bool defer_send) {
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    struct ssl_args_t *args = bpf_map_lookup_elem(&ssl_args_map, &tid);
+    if (args == NULL) {
+        return;
+    }
+    __u64 ssl = args->ssl;
+    const void *buf = (const void *)args->buf;
+    const void *processed = (const void *)args->processed;
+    bpf_map_delete_elem(&ssl_args_map, &tid);
+    int len = (int)PT_REGS_RC(ctx);
+    if (processed != NULL) {
+        __u64 n = 0;
+        if (len != 1 || bpf_probe_read_user(&n, sizeof(n), processed) != 0) {
+            return;
+        }
+        len = (int)n;
+    }
+    record(ssl, buf, len, direction, library, defer_send);
+}
+
+// BoringSSL linked statically by envoy, the BIO of the SSL has read or written the socket in the call.
+SEC("uprobe/SSL_write")
+int uprobe_ssl_write(struct pt_regs *ctx) {
+    save_ssl_args(ctx, false);
+    return 0;
+}
+
+SEC("uretprobe/SSL_write")
+int uretprobe_ssl_write(struct pt_regs *ctx) {
+    record_ssl(ctx, TLS_DIRECTION_SEND, TLS_LIBRARY_BORINGSSL, false);
+    return 0;
+}
+
+SEC("uprobe/SSL_read")
+int uprobe_ssl_read(struct pt_regs *ctx) {
+    save_ssl_args(ctx, false);
+    return 0;
+}
+
+SEC("uretprobe/SSL_read")
+int uretprobe_ssl_read(struct pt_regs *ctx) {
+    record_ssl(ctx, TLS_DIRECTION_RECV, TLS_LIBRARY_BORINGSSL, false);
+    return 0;
+}
+
+// OpenSSL linked statically by node. The TLS sockets of node encrypt into a memory BIO, libuv writes the socket
+// after the call; they decrypt what libuv read from the socket before the call.
+SEC("uprobe/SSL_write")
+int uprobe_node_ssl_write(struct pt_regs *ctx) {
+    save_ssl_args(ctx, false);
+    return 0;
+}
+
+SEC("uretprobe/SSL_write")
+int uretprobe_node_ssl_write(struct pt_regs *ctx) {
+    record_ssl(ctx, TLS_DIRECTION_SEND, TLS_LIBRARY_NODEJS, true);
+    return 0;
+}
+
+SEC("uprobe/SSL_read")
+int uprobe_node_ssl_read(struct pt_regs *ctx) {
+    save_ssl_args(ctx, false);
+    return 0;
+}
+
+SEC("uretprobe/SSL_read")
+int uretprobe_node_ssl_read(struct pt_regs *ctx) {
+    record_ssl(ctx, TLS_DIRECTION_RECV, TLS_LIBRARY_NODEJS, true);
+    return 0;
+}
+
+SEC("uprobe/SSL_write_ex")
+int uprobe_node_ssl_write_ex(struct pt_regs *ctx) {
+    save_ssl_args(ctx, true);
+    return 0;
+}
+
+SEC("uretprobe/SSL_write_ex")
+int uretprobe_node_ssl_write_ex(struct pt_regs *ctx) {
+    record_ssl(ctx, TLS_DIRECTION_SEND, TLS_LIBRARY_NODEJS, true);
+    return 0;
+}
+
+SEC("uprobe/SSL_read_ex")
+int uprobe_node_ssl_read_ex(struct pt_regs *ctx) {
+    save_ssl_args(ctx, true);
+    return 0;
+}
+
+SEC("uretprobe/SSL_read_ex")
+int uretprobe_node_ssl_read_ex(struct pt_regs *ctx) {
+    record_ssl(ctx, TLS_DIRECTION_RECV, TLS_LIBRARY_NODEJS, true);
+    return 0;
+}
+
+// kTLS sockets encrypt in the kernel, the plaintext is the one of their sendmsg and recvmsg. The sockets of the
+// libraries probed in user space enabling kTLS (e.g. OpenSSL 3) are skipped, their plaintext is captured in the
+// SSL calls already.
+static __always_inline void *msg_buf(struct msghdr *msg) {
+    struct iov_iter iter;
+    bpf_probe_read_kernel(&iter, sizeof(iter), &msg->msg_iter);
+    __u8 iter_type = 0;
+    bpf_probe_read_kernel(&iter_type, sizeof(iter_type), &iter.iter_type);
+    struct iovec *iov = NULL;
+    bpf_probe_read_kernel(&iov, sizeof(iov), &iter.iov);
+    if (iov == NULL) {
+        return NULL;
+    }
+    // ITER_UBUF, the user buffer is in place of the iovecs
+    if (iter_type == 6) {
+        return iov;
+    }
+    struct iovec vec;
+    bpf_probe_read_kernel(&vec, sizeof(vec), iov);
+    return vec.iov_base;
+}
+
+static __always_inline bool in_ssl_call() {
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    return bpf_map_lookup_elem(&ssl_args_map, &tid) != NULL;
+}
+
+static __always_inline void record_ktls(struct sock *sk, const void *buf, int len, __u8 direction) {
+    if (buf == NULL || len <= 0 || !event_budget_allow(TLS_LIBRARY_KTLS)) {
+        return;
+    }
+    struct tls_conn_t conn = {0};
+    if (!read_conn(sk, &conn)) {
+        return;
+    }
+    struct tls_data_event_t *event = new_event(buf, len, direction, TLS_LIBRARY_KTLS);
+    if (event == NULL) {
+        return;
+    }
+    event->conn = conn;
+    output(event);
+}
+
+// int tls_sw_sendmsg(struct sock *sk, struct msghdr *msg, size_t size), tls_device_sendmsg for the NIC offload.
+SEC("kprobe/tls_sw_sendmsg")
+int kprobe_tls_sw_sendmsg(struct pt_regs *ctx) {
+    if (in_ssl_call()) {
+        return 0;
+    }
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    record_ktls(sk, msg_buf((struct msghdr *)PT_REGS_PARM2(ctx)), (int)PT_REGS_PARM3(ctx), TLS_DIRECTION_SEND);
+    return 0;
+}
+
+SEC("kprobe/tls_device_sendmsg")
+int kprobe_tls_device_sendmsg(struct pt_regs *ctx) {
+    if (in_ssl_call()) {
+        return 0;
+    }
+    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+    record_ktls(sk, msg_buf((struct msghdr *)PT_REGS_PARM2(ctx)), (int)PT_REGS_PARM3(ctx), TLS_DIRECTION_SEND);
+    return 0;
+}
+
+// int tls_sw_recvmsg(struct sock *sk, struct msghdr *msg, size_t len, ...), the offloaded records are read by it as well.
+SEC("kprobe/tls_sw_recvmsg")
+int kprobe_tls_sw_recvmsg(struct pt_regs *ctx) {
+    if (in_ssl_call()) {
+        return 0;
+    }
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    struct ktls_recv_t recv = {
+        .sk = (struct sock *)PT_REGS_PARM1(ctx),
+        .buf = (__u64)msg_buf((struct msghdr *)PT_REGS_PARM2(ctx)),
+    };
+    bpf_map_update_elem(&ktls_recv_map, &tid, &recv, BPF_ANY);
+    return 0;
+}
+
+SEC("kretprobe/tls_sw_recvmsg")
+int kretprobe_tls_sw_recvmsg(struct pt_regs *ctx) {
+    __u32 tid = (__u32)bpf_get_current_pid_tgid();
+    struct ktls_recv_t *recv = bpf_map_lookup_elem(&ktls_recv_map, &tid);
+    if (recv == NULL) {
+        return 0;
+    }
+    struct sock *sk = recv->sk;
+    const void *buf = (const void *)recv->buf;
+    bpf_map_delete_elem(&ktls_recv_map, &tid);
+    record_ktls(sk, buf, (int)PT_REGS_RC(ctx), TLS_DIRECTION_RECV);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,104 @@
+#include <linux/kconfig.h>
+#include <uapi/linux/bpf.h>
+#include <uapi/linux/if_ether.h>
+#include <uapi/linux/if_packet.h>
+#include <uapi/linux/in.h>
+#include <uapi/linux/ip.h>
+#include <uapi/linux/string.h>
+#include <uapi/linux/tcp.h>
+#include <uapi/linux/types.h>
+
+#include "../../include/bpf_endian.h"
+#include "../../include/bpf_traffic_helpers.h"
+#include "../../include/common.h"
+#include "../../include/protocol.h"
+
+// flow of the pod attached to this veth: its address and the address of the peer.
+typedef struct {
+    __u32 pod_ip;
+    __u32 peer_ip;
+    __u16 pod_port;
+    __u16 peer_port;
+} udp_flow_key;
+
+// counters of a flow since the agent last read them.
+typedef struct {
+    __u64 packets_sent;
+    __u64 bytes_sent;
+    __u64 packets_received;
+    __u64 bytes_received;
+    // cookie of the socket of the pod, the join key of the flow with the other plugins, 0 while no packet
+    // owned by a local socket was counted.
+    __u64 cookie;
+} udp_flow_t;
+
+struct bpf_map_def SEC("maps/filter_map") filter_map = {
+    .type = BPF_MAP_TYPE_HASH,
+    .key_size = sizeof(__u32),
+    .value_size = sizeof(__u32),
+    .max_entries = 1,
+};
+
+struct bpf_map_def SEC("maps/flows_map") flows_map = {
+    .type = BPF_MAP_TYPE_LRU_HASH,
+    .key_size = sizeof(udp_flow_key),
+    .value_size = sizeof(udp_flow_t),
+    .max_entries = 1024 * 16,
+};
+
+SEC("socket")
+int socket__udp_filter(struct __sk_buff *skb) {
+    skb_info_t skb_info = {0};
+    conn_tuple_t conn_tuple = {0};
+
+    if (!read_conn_tuple_skb(skb, &skb_info, &conn_tuple)) {
+        return 0;
+    }
+    if (conn_tuple.l3_proto != ETH_P_IP || (conn_tuple.metadata & CONN_TYPE_TCP)) {
+        return 0;
+    }
+
+    udp_flow_key key = {0};
+    __u32 saddr = conn_tuple.saddr_l;
+    __u32 daddr = conn_tuple.daddr_l;
+    bool sent = false;
+    if (bpf_map_lookup_elem(&filter_map, &saddr) != NULL) {
+        sent = true;
+        key.pod_ip = saddr;
+        key.peer_ip = daddr;
+        key.pod_port = conn_tuple.sport;
+        key.peer_port = conn_tuple.dport;
+    } else if (bpf_map_lookup_elem(&filter_map, &daddr) != NULL) {
+        key.pod_ip = daddr;
+        key.peer_ip = saddr;
+        key.pod_port = conn_tuple.dport;
+        key.peer_port = conn_tuple.sport;
+    } else {
+        return 0;
+    }
+
+    udp_flow_t *flow = bpf_map_lookup_elem(&flows_map, &key);
+    if (flow == NULL) {
+        udp_flow_t init = {0};
+        bpf_map_update_elem(&flows_map, &key, &init, BPF_NOEXIST);
+        flow = bpf_map_lookup_elem(&flows_map, &key);
+        if (flow == NULL) {
+            return 0;
+        }
+    }
+    if (flow->cookie == 0) {
+        flow->cookie = bpf_get_socket_cookie(skb);
+    }
+    // the bytes of the ip packets, without the ethernet header.
+    __u64 len = skb->len - ETH_HLEN;
+    if (sent) {
+        __sync_fetch_and_add(&flow->packets_sent, 1);
+        __sync_fetch_and_add(&flow->bytes_sent, len);
+    } else {
+        __sync_fetch_and_add(&flow->packets_received, 1);
+        __sync_fetch_and_add(&flow->bytes_received, len);
+    }
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
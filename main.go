@@ -3,25 +3,72 @@ package main
 import (
 	_ "embed"
 	_ "net/http/pprof"
+	"os"
 
+	"github.com/erda-project/ebpf-agent/pkg/compat"
 	_ "github.com/erda-project/ebpf-agent/pkg/controller"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/coverage"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/cputime"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/memory"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/mirror"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/packetdrop"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/amqp"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/brpc"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/clickhouse"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dns"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/grpc"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/kafka"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/ldap"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/memcached"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/motan"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/mysql"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/oracle"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/postgres"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/pulsar"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/quic"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/redis"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/rocketmq"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/rpc"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/smtp"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/sofarpc"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/thrift"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tls"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tlsplain"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/servicemap"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/tcp"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/tcpevents"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/throughput"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/topology"
 	_ "github.com/erda-project/ebpf-agent/pkg/plugins/traffic"
+	_ "github.com/erda-project/ebpf-agent/pkg/plugins/udp"
+	"github.com/erda-project/ebpf-agent/pkg/simulate"
+	"github.com/erda-project/ebpf-agent/pkg/supportbundle"
 	"github.com/erda-project/erda-infra/base/servicehub"
 )
 
 //go:embed bootstrap.yaml
 var bootstrapCfg string
 
+// simulateCfg replaces the kprobe and netfilter services with a synthetic node, see package simulate.
+//
+//go:embed simulate.yaml
+var simulateCfg string
+
 ////go:generate go run github.com/cilium/ebpf/cmd/bpf2go -no-global-types -cc clang netfilter ./ebpf/plugins/netfilter/main.c -- -D__TARGET_ARCH_x86 -I./ebpf/include -Wall
 
 func main() {
-	hub := servicehub.New()
+	if len(os.Args) > 1 && os.Args[1] == supportbundle.Command {
+		os.Exit(supportbundle.Main(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == simulate.Flag {
+		bootstrapCfg = simulateCfg
+	}
+	compat.ApplyEnv()
+	logger := supportbundle.Install(bootstrapCfg)
+	hub := servicehub.New(servicehub.WithLogger(logger))
 	hub.RunWithOptions(&servicehub.RunOptions{
 		Content: bootstrapCfg,
 	})
@@ -11,6 +11,24 @@ type Metric struct {
 	Tags        map[string]string      `json:"tags"`
 	Fields      map[string]interface{} `json:"fields"`
 	OrgName     string                 `json:"-"`
+	// CaptureTime is the time (unix nano) the kernel captured the event completing the metric, 0 if
+	// unknown. ConvertTime is the time the plugin converted the metric, the controller stamps the time it
+	// received it when it is 0.
+	CaptureTime int64 `json:"-"`
+	ConvertTime int64 `json:"-"`
+	// SocketCookie is the socket cookie (bpf_get_socket_cookie) of the pod end of the connection, the
+	// join key of the metrics of a connection across plugins, 0 if unknown.
+	SocketCookie uint64 `json:"-"`
+	// Events are the events of the request, e.g. the tcp retransmits of its connection, exported as the
+	// events of its span.
+	Events []Event `json:"-"`
+}
+
+// Event is an event during a request, Timestamp is in unix nano.
+type Event struct {
+	Name       string
+	Timestamp  int64
+	Attributes map[string]string
 }
 
 func (m *Metric) AddTags(k string, v string) {
@@ -0,0 +1,173 @@
+// Package clock abstracts the time of the agent. Windows, timeouts and retries read the time and
+// wait through a Clock, the providers use Real and their tests advance a Fake instead of sleeping,
+// so that the boundaries are tested deterministically.
+//
+// The tickers of the providers and the exporters, the polls of the kernel maps, the idle expiry of
+// the caches and the timestamps of the metrics read the Clock as well. Only the seed of the simulator,
+// the support bundles, the legacy oom watcher and the String methods of the events (debug output)
+// read the time package directly.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// After sends the time on the returned channel once d elapsed.
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+	Sleep(d time.Duration)
+}
+
+// Ticker is a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the time package.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Fake is a clock that only moves by Add. Timers and tickers due within an Add fire in the order
+// of their deadlines, like time.Ticker a ticker drops the ticks its reader is not ready for.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at time.Time
+	// period is the interval of tickers, 0 for timers.
+	period time.Duration
+	c      chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.add(&waiter{at: f.now.Add(d), c: make(chan time.Time, 1)}).c
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &fakeTicker{f: f, w: f.add(&waiter{at: f.now.Add(d), period: d, c: make(chan time.Time, 1)})}
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Add moves the clock forward by d and fires the timers and tickers due.
+func (f *Fake) Add(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].at.After(end) {
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		f.now = w.at
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+			f.insert(w)
+		}
+	}
+	f.now = end
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until n timers and tickers are pending, e.g. until the goroutine under test
+// waits on the clock before it is moved.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// add registers w, timers due now fire at once. The caller holds the lock.
+func (f *Fake) add(w *waiter) *waiter {
+	if w.period == 0 && !w.at.After(f.now) {
+		w.c <- f.now
+		return w
+	}
+	f.insert(w)
+	f.cond.Broadcast()
+	return w
+}
+
+func (f *Fake) insert(w *waiter) {
+	i := sort.Search(len(f.waiters), func(i int) bool { return f.waiters[i].at.After(w.at) })
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = w
+}
+
+func (f *Fake) remove(w *waiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, x := range f.waiters {
+		if x == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.f.remove(t.w) }
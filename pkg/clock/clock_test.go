@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	c := f.After(10 * time.Second)
+	f.Add(9 * time.Second)
+	if _, ok := received(c); ok {
+		t.Fatalf("timer fired before its deadline")
+	}
+	f.Add(time.Second)
+	if at, ok := received(c); !ok || !at.Equal(start.Add(10*time.Second)) {
+		t.Fatalf("timer = %v, %v, want the deadline", at, ok)
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("fired timers should be removed")
+	}
+	if _, ok := received(f.After(0)); !ok {
+		t.Errorf("timers due now should fire at once")
+	}
+	if got := f.Since(start); got != 10*time.Second {
+		t.Errorf("Since() = %v", got)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	ticker := f.NewTicker(5 * time.Second)
+	f.Add(5 * time.Second)
+	if at, ok := received(ticker.C()); !ok || !at.Equal(start.Add(5*time.Second)) {
+		t.Fatalf("tick = %v, %v", at, ok)
+	}
+	// like time.Ticker, the ticks the reader misses are dropped
+	f.Add(20 * time.Second)
+	if at, ok := received(ticker.C()); !ok || !at.Equal(start.Add(10*time.Second)) {
+		t.Fatalf("tick = %v, %v, want the first missed tick", at, ok)
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Fatalf("missed ticks should be dropped")
+	}
+	if !f.Now().Equal(start.Add(25 * time.Second)) {
+		t.Errorf("Now() = %v", f.Now())
+	}
+	ticker.Stop()
+	f.Add(time.Minute)
+	if _, ok := received(ticker.C()); ok {
+		t.Errorf("stopped tickers should not tick")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Minute)
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Add(time.Minute)
+	<-done
+}
@@ -0,0 +1,25 @@
+package clock
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	bootOnce   sync.Once
+	bootOffset int64
+)
+
+// FromKtime converts a bpf_ktime_get_ns timestamp (CLOCK_MONOTONIC) to unix nano. The offset is read
+// once, the wall clock adjustments after the start of the agent are not followed.
+func FromKtime(ns uint64) int64 {
+	bootOnce.Do(func() {
+		var ts unix.Timespec
+		if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err == nil {
+			bootOffset = time.Now().UnixNano() - ts.Nano()
+		}
+	})
+	return int64(ns) + bootOffset
+}
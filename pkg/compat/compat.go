@@ -0,0 +1,182 @@
+// Package compat keeps the settings and dashboards of agents upgraded from the legacy single rpc plugin
+// deployments working while they move to the current settings and the shared L7 tag schema, see
+// pkg/plugins/protocols/enrich.
+//
+// Renamed environment variables are copied to their replacements at startup, unless the replacement is set.
+// Renamed tags are added back under their legacy names by the controller until L7_DISABLE_LEGACY_TAGS=true.
+// Every use of a legacy name is counted and reported as ebpf_agent_deprecation, so that the deployments
+// and dashboards still depending on them can be found before the legacy names are removed:
+//
+//	tags:   host, kind (env or tag), legacy, replacement, measurement (tags only)
+//	fields: count    uses since the previous report, 1 for environment variables
+package compat
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	measurement = "ebpf_agent_deprecation"
+
+	kindEnv = "env"
+	kindTag = "tag"
+)
+
+type Config struct {
+	DisableLegacyTags bool `env:"L7_DISABLE_LEGACY_TAGS"`
+}
+
+// envRenames maps the renamed environment variables to their replacements.
+// No setting has been renamed yet, entries are added together with the renames.
+var envRenames = map[string]string{}
+
+type tagRename struct {
+	// measurements the legacy tag is added to, their _error variants included.
+	measurements []string
+	// component restricts the rename to the metrics of one plugin if set.
+	component string
+	legacy    string
+	current   string
+}
+
+// tagRenames are the tags of the legacy deployments that are superseded by a tag of the current schema.
+var tagRenames = []tagRename{
+	{measurements: []string{"application_http", "application_rpc", "application_mq"}, legacy: "db_host", current: "peer_address"},
+	{measurements: []string{"application_cache"}, component: "REDIS", legacy: "redis_staus", current: "redis_status"},
+	{measurements: []string{"application_mq"}, component: "kafka", legacy: "topic_name", current: "message_bus_destination"},
+}
+
+type usage struct {
+	kind        string
+	legacy      string
+	replacement string
+	measurement string
+}
+
+var (
+	cfg     Config
+	cfgOnce sync.Once
+
+	mu sync.Mutex
+	// envUsed holds the renamed environment variables set on this node.
+	envUsed map[string]string
+	// tagUsed counts the legacy tags added since the previous report.
+	tagUsed = make(map[usage]uint64)
+)
+
+func config() *Config {
+	cfgOnce.Do(func() {
+		envconf.MustLoad(&cfg)
+	})
+	return &cfg
+}
+
+// LegacyTags reports whether the legacy tag names should still be emitted.
+func LegacyTags() bool {
+	return !config().DisableLegacyTags
+}
+
+// ApplyEnv copies the renamed environment variables to their replacements, it must run before
+// the providers load their config.
+func ApplyEnv() {
+	applyEnv(envRenames)
+}
+
+func applyEnv(renames map[string]string) {
+	mu.Lock()
+	defer mu.Unlock()
+	envUsed = make(map[string]string)
+	for legacy, current := range renames {
+		v, ok := os.LookupEnv(legacy)
+		if !ok {
+			continue
+		}
+		envUsed[legacy] = current
+		if _, ok := os.LookupEnv(current); ok {
+			klog.Warningf("deprecated env %s is ignored, %s is set", legacy, current)
+			continue
+		}
+		klog.Warningf("env %s is deprecated, use %s instead", legacy, current)
+		os.Setenv(current, v)
+	}
+}
+
+// Apply adds the legacy tags of the renamed tags of m.
+func Apply(m *metric.Metric) {
+	if m == nil || m.Tags == nil || !LegacyTags() {
+		return
+	}
+	applyTags(m, tagRenames)
+}
+
+func applyTags(m *metric.Metric, renames []tagRename) {
+	name := strings.TrimSuffix(m.Measurement, "_error")
+	for _, r := range renames {
+		if !contains(r.measurements, name) || (r.component != "" && m.Tags["component"] != r.component) {
+			continue
+		}
+		v, ok := m.Tags[r.current]
+		if !ok {
+			continue
+		}
+		// plugins may still set the legacy tag to a value of their own.
+		if _, ok := m.Tags[r.legacy]; !ok {
+			m.Tags[r.legacy] = v
+		}
+		mu.Lock()
+		tagUsed[usage{kind: kindTag, legacy: r.legacy, replacement: r.current, measurement: name}]++
+		mu.Unlock()
+	}
+}
+
+// Metrics returns the uses of legacy names since the previous call.
+func Metrics(timestamp int64) []*metric.Metric {
+	mu.Lock()
+	defer mu.Unlock()
+	host := os.Getenv("NODE_NAME")
+	var ans []*metric.Metric
+	add := func(u usage, count uint64) {
+		tags := map[string]string{
+			"host":        host,
+			"kind":        u.kind,
+			"legacy":      u.legacy,
+			"replacement": u.replacement,
+		}
+		if u.measurement != "" {
+			tags["measurement"] = u.measurement
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			Tags:        tags,
+			Fields: map[string]interface{}{
+				"count": count,
+			},
+		})
+	}
+	for legacy, current := range envUsed {
+		add(usage{kind: kindEnv, legacy: legacy, replacement: current}, 1)
+	}
+	for u, count := range tagUsed {
+		add(u, count)
+	}
+	tagUsed = make(map[usage]uint64)
+	return ans
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package compat
+
+import (
+	"os"
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("LEGACY_ADDR", "collector:7076")
+	t.Setenv("LEGACY_RETRY", "5")
+	t.Setenv("CURRENT_RETRY", "3")
+	os.Unsetenv("CURRENT_ADDR")
+	t.Cleanup(func() { os.Unsetenv("CURRENT_ADDR") })
+
+	applyEnv(map[string]string{
+		"LEGACY_ADDR":  "CURRENT_ADDR",
+		"LEGACY_RETRY": "CURRENT_RETRY",
+		"LEGACY_UNSET": "CURRENT_UNSET",
+	})
+	if v := os.Getenv("CURRENT_ADDR"); v != "collector:7076" {
+		t.Errorf("CURRENT_ADDR: got %q", v)
+	}
+	if v := os.Getenv("CURRENT_RETRY"); v != "3" {
+		t.Errorf("the replacement should win, CURRENT_RETRY: got %q", v)
+	}
+
+	used := map[string]bool{}
+	for _, m := range Metrics(1) {
+		if m.Tags["kind"] == kindEnv {
+			used[m.Tags["legacy"]] = true
+		}
+	}
+	if len(used) != 2 || !used["LEGACY_ADDR"] || !used["LEGACY_RETRY"] {
+		t.Errorf("unexpected deprecated envs %v", used)
+	}
+	applyEnv(nil)
+}
+
+func TestApplyTags(t *testing.T) {
+	Metrics(0)
+	cases := []struct {
+		measurement string
+		tags        map[string]string
+		want        map[string]string
+	}{
+		{
+			measurement: "application_http_error",
+			tags:        map[string]string{"component": "HTTP", "peer_address": "10.0.0.1:80"},
+			want:        map[string]string{"db_host": "10.0.0.1:80"},
+		},
+		{
+			// the rpc plugin keeps the client address of the legacy deployments
+			measurement: "application_rpc",
+			tags:        map[string]string{"component": "DUBBO", "peer_address": "10.0.0.1:20880", "db_host": "10.0.0.2:4000"},
+			want:        map[string]string{"db_host": "10.0.0.2:4000"},
+		},
+		{
+			measurement: "application_cache",
+			tags:        map[string]string{"component": "REDIS", "peer_address": "10.0.0.1:6379", "redis_status": "OK"},
+			want:        map[string]string{"redis_staus": "OK", "db_host": ""},
+		},
+		{
+			measurement: "application_mq",
+			tags:        map[string]string{"component": "ROCKETMQ", "message_bus_destination": "orders"},
+			want:        map[string]string{"topic_name": ""},
+		},
+	}
+	for _, c := range cases {
+		m := &metric.Metric{Measurement: c.measurement, Tags: c.tags}
+		applyTags(m, tagRenames)
+		for k, v := range c.want {
+			if m.Tags[k] != v {
+				t.Errorf("%s %s: got %q, want %q", c.measurement, k, m.Tags[k], v)
+			}
+		}
+	}
+
+	counts := map[string]uint64{}
+	for _, m := range Metrics(1) {
+		if m.Tags["kind"] == kindTag {
+			counts[m.Tags["measurement"]+"/"+m.Tags["legacy"]] = m.Fields["count"].(uint64)
+		}
+	}
+	if len(counts) != 3 || counts["application_http/db_host"] != 1 || counts["application_rpc/db_host"] != 1 ||
+		counts["application_cache/redis_staus"] != 1 {
+		t.Errorf("unexpected deprecated tags %v", counts)
+	}
+	for _, m := range Metrics(2) {
+		if m.Tags["kind"] == kindTag {
+			t.Errorf("uses should be reset after a report, got %v", m.Tags)
+		}
+	}
+}
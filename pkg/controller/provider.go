@@ -5,20 +5,40 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/cilium/ebpf/rlimit"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/compat"
 	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/eventbus"
 	"github.com/erda-project/ebpf-agent/pkg/exporter/collector"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/erda"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/jaeger"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/latency"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/otlp"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/scheduler"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/taglimit"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/tailsampling"
+	"github.com/erda-project/ebpf-agent/pkg/instance"
+	"github.com/erda-project/ebpf-agent/pkg/k8sclient"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/coverage"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/tcpevents"
+	"github.com/erda-project/ebpf-agent/pkg/schema"
+	"github.com/erda-project/ebpf-agent/pkg/supportbundle"
 	"github.com/erda-project/erda-infra/base/servicehub"
 )
 
 type Config struct {
 	Plugins []string `file:"plugins"`
+	// Simulated runs the plugins of the simulation, no eBPF resources are loaded, see package simulate.
+	Simulated bool `file:"simulated"`
 }
 
 type provider struct {
@@ -29,12 +49,28 @@ type provider struct {
 	plugins         []Plugin
 	collectorClient *collector.ReportClient
 	metrics         []*metric.Metric
+	// exportSpans reports the L7 request metrics as Erda spans as well.
+	exportSpans bool
+	// otlpSpans exports the HTTP and RPC requests as OTLP spans, nil unless OTLP_SPAN_ENDPOINT is set.
+	otlpSpans *otlp.Exporter
+	// jaegerSpans exports them to a Jaeger collector, nil unless JAEGER_SPAN_ENDPOINT is set.
+	jaegerSpans *jaeger.Exporter
+	// tailSampling holds the requests and exports their outliers, nil unless TAIL_SAMPLING_ENABLED is set.
+	tailSampling *tailsampling.Buffer
+	// tcpEvents are the tcp anomalies the spans carry as events, nil unless the tcpevents plugin is enabled.
+	tcpEvents   tcpevents.Interface
+	schemaCfg   schema.Config
+	clock       clock.Clock
+	instanceCfg instance.Config
+	instance    *instance.Instance
 }
 
 func (p *provider) Init(ctx servicehub.Context) error {
 	// Allow the current process to lock memory for eBPF resources.
-	if err := rlimit.RemoveMemlock(); err != nil {
-		log.Fatal(err)
+	if !p.Cfg.Simulated {
+		if err := rlimit.RemoveMemlock(); err != nil {
+			log.Fatal(err)
+		}
 	}
 	p.ctx = ctx
 	p.plugins = make([]Plugin, 0, len(p.Cfg.Plugins))
@@ -42,6 +78,13 @@ func (p *provider) Init(ctx servicehub.Context) error {
 	reportConfig := &collector.CollectorConfig{}
 	envconf.MustLoad(reportConfig)
 	p.collectorClient = collector.CreateReportClient(reportConfig)
+	p.exportSpans = erda.Enabled()
+	p.otlpSpans = otlp.New()
+	p.jaegerSpans = jaeger.New()
+	p.tailSampling = tailsampling.New()
+	envconf.MustLoad(&p.schemaCfg)
+	envconf.MustLoad(&p.instanceCfg)
+	p.clock = clock.Real
 	return nil
 }
 
@@ -52,34 +95,84 @@ func (p *provider) Run(ctx context.Context) error {
 			return err
 		}
 		p.plugins = append(p.plugins, plugin)
+		if events, ok := plugin.(tcpevents.Interface); ok {
+			p.tcpEvents = events
+		}
 	}
+	p.instance = instance.Start(p.instanceCfg, p.Cfg.Plugins)
+	klog.Infof("agent instance %s, %d restarts", p.instance.ID(), p.instance.Restarts())
+	p.runLease(ctx)
+	schema.Enable(p.Cfg.Plugins)
+	p.appendSelf(schema.Metrics(p.clock.Now().UnixNano(), true))
 	ch := make(chan *metric.Metric, 1000)
-	for _, plugin := range p.plugins {
-		go plugin.Gather(ch)
+	for i, plugin := range p.plugins {
+		go plugin.Gather(p.observe(p.Cfg.Plugins[i], ch))
+	}
+	if p.otlpSpans != nil {
+		go p.otlpSpans.Run(ctx)
+	}
+	if p.jaegerSpans != nil {
+		go p.jaegerSpans.Run(ctx)
 	}
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := p.clock.NewTicker(5 * time.Second)
+	selfMetricsTicker := p.clock.NewTicker(time.Minute)
+	schemaTicker := p.clock.NewTicker(p.schemaCfg.RefreshInterval)
 	for {
 		select {
 		case m := <-ch:
 			p.Lock()
 			//klog.Infof("metric: %+v", m)
 			if m != nil {
-				p.metrics = append(p.metrics, m)
+				// self metrics of the plugins, e.g. ebpf_plugin_startup
+				p.instance.Stamp(m)
+				supportbundle.RecordMetric(m)
+				eventbus.Publish(m)
+				// the requests held by the tail sampling are exported once their window is over.
+				if p.tailSampling == nil || !p.tailSampling.Add(m, p.clock.Now().UnixNano()) {
+					p.export(m)
+				}
+			}
+			p.Unlock()
+		case <-selfMetricsTicker.C():
+			p.Lock()
+			now := p.clock.Now().UnixNano()
+			self := append(compat.Metrics(now), eventbus.Metrics(now)...)
+			self = append(self, scheduler.Metrics(now)...)
+			self = append(self, taglimit.Metrics(now)...)
+			self = append(self, latency.Metrics(now)...)
+			self = append(self, p.instance.Metrics(now)...)
+			p.appendSelf(self)
+			for _, m := range self {
+				supportbundle.RecordMetric(m)
 			}
+			p.appendSelf(schema.Metrics(now, false))
 			p.Unlock()
-		case <-ticker.C:
+		case <-schemaTicker.C():
 			p.Lock()
-			if len(p.metrics) > 0 {
-				if err := p.collectorClient.Send(p.metrics); err != nil {
+			p.appendSelf(schema.Metrics(p.clock.Now().UnixNano(), true))
+			p.Unlock()
+		case <-ticker.C():
+			p.Lock()
+			if p.tailSampling != nil {
+				for _, m := range p.tailSampling.Release(p.clock.Now().UnixNano()) {
+					p.export(m)
+				}
+			}
+			// non-critical metrics are held back while the node is under pressure.
+			send := scheduler.Schedule(p.metrics)
+			p.metrics = make([]*metric.Metric, 0)
+			if len(send) > 0 {
+				if err := p.collectorClient.Send(send); err != nil {
 					klog.Errorf("send metric to %s collector error: %v", p.collectorClient.CFG.ReportConfig.Collector.Addr, err)
+					p.metrics = send
 					p.Unlock()
 					continue
 				}
-				klog.Infof("send %d metric to %s collector success", len(p.metrics), p.collectorClient.CFG.ReportConfig.Collector.Addr)
-				example := p.metrics[0]
+				latency.Exported(send)
+				klog.Infof("send %d metric to %s collector success", len(send), p.collectorClient.CFG.ReportConfig.Collector.Addr)
+				example := send[0]
 				exampleStr, _ := json.Marshal(example)
 				klog.Infof("example metric: %s", string(exampleStr))
-				p.metrics = make([]*metric.Metric, 0)
 			}
 			p.Unlock()
 		}
@@ -87,6 +180,74 @@ func (p *provider) Run(ctx context.Context) error {
 	return nil
 }
 
+// export queues the metric of a plugin and its spans for the collector.
+func (p *provider) export(m *metric.Metric) {
+	p.metrics = append(p.metrics, m)
+	// the aggregates of the tail sampling are not single requests.
+	if !tailsampling.IsAggregate(m) {
+		if p.tcpEvents != nil && (p.exportSpans || p.otlpSpans != nil || p.jaegerSpans != nil) {
+			m.Events = tcpevents.SpanEvents(p.tcpEvents, m)
+		}
+		if p.exportSpans {
+			if span := erda.Span(m); span != nil {
+				taglimit.Apply(span)
+				p.metrics = append(p.metrics, span)
+			}
+		}
+		if p.otlpSpans != nil {
+			p.otlpSpans.Add(m)
+		}
+		if p.jaegerSpans != nil {
+			p.jaegerSpans.Add(m)
+		}
+	}
+	// after the span conversion, spans only carry the current schema.
+	compat.Apply(m)
+	// the legacy copies of the tags are bounded as well.
+	taglimit.Apply(m)
+}
+
+// appendSelf queues the self metrics of the controller stamped with the instance id.
+func (p *provider) appendSelf(ms []*metric.Metric) {
+	for _, m := range ms {
+		p.instance.Stamp(m)
+		p.metrics = append(p.metrics, m)
+	}
+}
+
+// runLease registers the agent instance as a Lease of the namespace of the agent pod, see package instance.
+func (p *provider) runLease(ctx context.Context) {
+	if p.Cfg.Simulated || !p.instanceCfg.LeaseEnabled {
+		return
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		klog.Warningf("POD_NAMESPACE is not set, the agent instance lease is not registered")
+		return
+	}
+	client, err := kubernetes.NewForConfig(k8sclient.GetRestConfig())
+	if err != nil {
+		klog.Errorf("failed to create the client of the agent instance lease: %v", err)
+		return
+	}
+	go p.instance.RunLease(ctx, client, namespace, p.instanceCfg.LeaseDuration)
+}
+
+// observe returns the channel of a plugin, it stamps the conversion of the metrics the plugin reports,
+// learns their schema and forwards them to ch.
+func (p *provider) observe(plugin string, ch chan<- *metric.Metric) chan *metric.Metric {
+	c := make(chan *metric.Metric)
+	go func() {
+		for m := range c {
+			latency.Converted(m)
+			schema.Observe(plugin, m)
+			coverage.Observe(m)
+			ch <- m
+		}
+	}()
+	return c
+}
+
 func (p *provider) Close() error {
 	return nil
 }
@@ -0,0 +1,181 @@
+// Package eventbus lets derived-signal modules (anomaly detection, SLOs, security baselines, ...)
+// consume the events decoded by the protocol plugins without the plugins knowing about them.
+//
+// The controller publishes every metric it receives from the plugins, the topic of an event is its
+// measurement (application_http, application_rpc_error, ...). A module subscribes to the topics it
+// needs, a topic ending with * is a prefix:
+//
+//	sub := eventbus.Subscribe("slo", 1000, "application_http", "application_http_error")
+//	defer sub.Close()
+//	for e := range sub.C {
+//		...
+//	}
+//
+// Modules reporting derived metrics are plugins themselves, they subscribe in Gather and send
+// their metrics to the controller like any other plugin.
+//
+// Publishing never blocks the controller: events are dropped when the buffer of a subscriber is
+// full. Every subscriber gets its own copy of an event. The deliveries and drops are reported as
+// ebpf_event_bus:
+//
+//	tags:   host, subscriber
+//	fields: delivered, dropped    events since the previous report
+package eventbus
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const measurement = "ebpf_event_bus"
+
+// Event is a metric decoded by a plugin.
+type Event struct {
+	Topic  string
+	Metric *metric.Metric
+}
+
+type Subscription struct {
+	// C receives the events until the subscription is closed.
+	C <-chan Event
+
+	name      string
+	topics    []string
+	ch        chan Event
+	bus       *Bus
+	delivered uint64
+	dropped   uint64
+}
+
+// Close stops the delivery and closes C.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+func (s *Subscription) matches(topic string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+	for _, t := range s.topics {
+		if prefix, ok := strings.CutSuffix(t, "*"); (ok && strings.HasPrefix(topic, prefix)) || t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+type Bus struct {
+	sync.Mutex
+	subscriptions []*Subscription
+}
+
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe delivers the events of the topics, all events if none is given, through a buffer of size events.
+func (b *Bus) Subscribe(name string, size int, topics ...string) *Subscription {
+	ch := make(chan Event, size)
+	s := &Subscription{
+		C:      ch,
+		name:   name,
+		topics: topics,
+		ch:     ch,
+		bus:    b,
+	}
+	b.Lock()
+	b.subscriptions = append(b.subscriptions, s)
+	b.Unlock()
+	return s
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	for i, sub := range b.subscriptions {
+		if sub == s {
+			b.subscriptions = append(b.subscriptions[:i], b.subscriptions[i+1:]...)
+			close(s.ch)
+			return
+		}
+	}
+}
+
+// Publish delivers the metric to the subscribers of its measurement. The metric is copied,
+// the publisher may keep changing it.
+func (b *Bus) Publish(m *metric.Metric) {
+	if m == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	for _, s := range b.subscriptions {
+		if !s.matches(m.Measurement) {
+			continue
+		}
+		select {
+		case s.ch <- Event{Topic: m.Measurement, Metric: clone(m)}:
+			s.delivered++
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Metrics returns the deliveries and drops of the subscribers since the previous call.
+func (b *Bus) Metrics(timestamp int64) []*metric.Metric {
+	b.Lock()
+	defer b.Unlock()
+	host := os.Getenv("NODE_NAME")
+	ans := make([]*metric.Metric, 0, len(b.subscriptions))
+	for _, s := range b.subscriptions {
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			Tags: map[string]string{
+				"host":       host,
+				"subscriber": s.name,
+			},
+			Fields: map[string]interface{}{
+				"delivered": s.delivered,
+				"dropped":   s.dropped,
+			},
+		})
+		s.delivered, s.dropped = 0, 0
+	}
+	return ans
+}
+
+func clone(m *metric.Metric) *metric.Metric {
+	c := *m
+	c.Tags = make(map[string]string, len(m.Tags))
+	for k, v := range m.Tags {
+		c.Tags[k] = v
+	}
+	c.Fields = make(map[string]interface{}, len(m.Fields))
+	for k, v := range m.Fields {
+		c.Fields[k] = v
+	}
+	return &c
+}
+
+var defaultBus = New()
+
+// Subscribe subscribes to the events published by the controller.
+func Subscribe(name string, size int, topics ...string) *Subscription {
+	return defaultBus.Subscribe(name, size, topics...)
+}
+
+// Publish publishes the metric to the subscribers of the controller's bus.
+func Publish(m *metric.Metric) {
+	defaultBus.Publish(m)
+}
+
+// Metrics returns the self metrics of the controller's bus.
+func Metrics(timestamp int64) []*metric.Metric {
+	return defaultBus.Metrics(timestamp)
+}
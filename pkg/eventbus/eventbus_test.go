@@ -0,0 +1,45 @@
+package eventbus
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestPublish(t *testing.T) {
+	b := New()
+	http := b.Subscribe("http", 1, "application_http")
+	all := b.Subscribe("all", 10, "application_*")
+
+	m := &metric.Metric{Measurement: "application_http", Tags: map[string]string{"http_path": "/checkout"}}
+	b.Publish(m)
+	b.Publish(&metric.Metric{Measurement: "application_http"})
+	b.Publish(&metric.Metric{Measurement: "application_rpc"})
+	b.Publish(&metric.Metric{Measurement: "ebpf_plugin_startup"})
+	m.Tags["http_path"] = "/changed"
+
+	e := <-http.C
+	if e.Topic != "application_http" || e.Metric.Tags["http_path"] != "/checkout" {
+		t.Errorf("subscribers should get a copy of the event, got %+v", e.Metric)
+	}
+	if len(all.C) != 3 {
+		t.Errorf("prefix subscription got %d events, want 3", len(all.C))
+	}
+
+	stats := map[string][2]uint64{}
+	for _, s := range b.Metrics(1) {
+		stats[s.Tags["subscriber"]] = [2]uint64{s.Fields["delivered"].(uint64), s.Fields["dropped"].(uint64)}
+	}
+	if stats["http"] != [2]uint64{1, 1} || stats["all"] != [2]uint64{3, 0} {
+		t.Errorf("unexpected deliveries and drops %v", stats)
+	}
+
+	http.Close()
+	if _, ok := <-http.C; ok {
+		t.Error("closed subscription should not receive events")
+	}
+	b.Publish(&metric.Metric{Measurement: "application_http"})
+	if len(b.Metrics(2)) != 1 {
+		t.Error("closed subscription should be removed")
+	}
+}
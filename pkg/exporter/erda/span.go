@@ -0,0 +1,220 @@
+// Package erda converts the L7 request metrics into spans of Erda's trace ingestion format, so that
+// the requests observed by the agent show up in the Erda APM trace views next to the spans of the
+// instrumented services.
+//
+// A span is reported with the name "span", the report client posts it to /collect/trace of the collector.
+// Following the msp span schema, the ids, the operation and the scope are tags and the start and
+// end time (unix nano) are fields:
+//
+//	tags:   trace_id, span_id, parent_span_id, operation_name, span_kind, component,
+//	        terminus_key, env_id, service_name, service_instance_id, application_name, ...,
+//	        events (the tcp anomalies during the request, a json array of name, timestamp and attributes)
+//	fields: start_time, end_time
+//
+// Requests of pods without a terminus key can not be scoped to an Erda service and are not reported.
+// Unless a plugin recovered the trace context of the request (trace_id and parent_span_id tags),
+// every request is the root span of its own trace. The requests captured at an instrumented client
+// keep the span id the client propagated (span_id tag), they are the client span of the trace.
+package erda
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const spanName = "span"
+
+type Config struct {
+	Enabled bool `env:"ERDA_SPAN_EXPORT_ENABLED"`
+}
+
+var (
+	cfg     Config
+	cfgOnce sync.Once
+)
+
+// Enabled reports whether the L7 metrics should also be exported as spans.
+func Enabled() bool {
+	cfgOnce.Do(func() {
+		envconf.MustLoad(&cfg)
+	})
+	return cfg.Enabled
+}
+
+// requestMeasurements are the L7 measurements of single requests.
+var requestMeasurements = map[string]bool{
+	"application_http":        true,
+	"application_http_error":  true,
+	"application_rpc":         true,
+	"application_db":          true,
+	"application_db_error":    true,
+	"application_cache":       true,
+	"application_cache_error": true,
+	"application_mq":          true,
+}
+
+// internalTags are the metric tags that are not span attributes.
+var internalTags = map[string]bool{
+	"_meta":            true,
+	"_metric_scope":    true,
+	"_metric_scope_id": true,
+}
+
+// serviceTags are the span scope tags, they are taken from the <source|target>_ tags of the enricher.
+var serviceTags = []string{
+	"service_name",
+	"service_id",
+	"service_instance_id",
+	"application_id",
+	"application_name",
+	"project_id",
+	"project_name",
+	"runtime_id",
+	"runtime_name",
+	"workspace",
+	"org_id",
+}
+
+// Span returns the span of an L7 request metric, nil for other metrics and requests outside of Erda services.
+func Span(m *metric.Metric) *metric.Metric {
+	if !requestMeasurements[m.Measurement] {
+		return nil
+	}
+	// server spans belong to the target pod, producer and consumer spans to the client pod.
+	side := "target"
+	if kind := m.Tags["span_kind"]; kind == "producer" || kind == "consumer" || kind == "client" {
+		side = "source"
+	}
+	terminusKey := m.Tags[side+"_terminus_key"]
+	if terminusKey == "" && side == "target" {
+		terminusKey = m.Tags["_metric_scope_id"]
+	}
+	if terminusKey == "" {
+		return nil
+	}
+	duration := toInt64(m.Fields["elapsed_sum"])
+
+	tags := make(map[string]string, len(m.Tags)+len(serviceTags)+8)
+	for k, v := range m.Tags {
+		if !internalTags[k] {
+			tags[k] = v
+		}
+	}
+	for _, k := range serviceTags {
+		if v, ok := m.Tags[side+"_"+k]; ok {
+			tags[k] = v
+		}
+	}
+	if tags["service_name"] == "" && side == "target" {
+		tags["service_name"] = m.Tags["peer_service"]
+	}
+	tags["terminus_key"] = terminusKey
+	tags["env_id"] = terminusKey
+	tags["operation_name"] = operationName(m)
+	if tags["span_id"] == "" {
+		tags["span_id"] = newID(1)
+	}
+	if tags["trace_id"] == "" {
+		tags["trace_id"] = newID(2)
+	}
+	if tags["span_kind"] == "" {
+		tags["span_kind"] = "server"
+	}
+	tags["span_layer"] = spanLayer(m.Measurement)
+	if len(m.Events) > 0 {
+		tags["events"] = spanEvents(m.Events)
+	}
+
+	return &metric.Metric{
+		Name:        spanName,
+		Measurement: spanName,
+		Timestamp:   m.Timestamp,
+		OrgName:     m.OrgName,
+		Tags:        tags,
+		Fields: map[string]interface{}{
+			"start_time": m.Timestamp - duration,
+			"end_time":   m.Timestamp,
+		},
+	}
+}
+
+// spanEvent is the json of an event of the events tag.
+type spanEvent struct {
+	Name       string            `json:"name"`
+	Timestamp  int64             `json:"timestamp"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func spanEvents(events []metric.Event) string {
+	ans := make([]spanEvent, 0, len(events))
+	for _, e := range events {
+		ans = append(ans, spanEvent{Name: e.Name, Timestamp: e.Timestamp, Attributes: e.Attributes})
+	}
+	b, _ := json.Marshal(ans)
+	return string(b)
+}
+
+func operationName(m *metric.Metric) string {
+	t := m.Tags
+	switch {
+	case t["graphql_operation_name"] != "":
+		return strings.TrimSpace(t["graphql_operation_type"] + " " + t["graphql_operation_name"])
+	case t["http_method"] != "" && t["http_path"] != "":
+		return t["http_method"] + " " + t["http_path"]
+	case t["rpc_target"] != "":
+		return t["rpc_target"]
+	case t["db_statement"] != "":
+		return t["db_statement"]
+	case t["message_bus_destination"] != "":
+		return t["request_api_name"] + " " + t["message_bus_destination"]
+	}
+	return strings.TrimSuffix(m.Measurement, "_error")
+}
+
+// spanLayer is the layer Erda groups the spans of a trace by.
+func spanLayer(measurement string) string {
+	switch strings.TrimSuffix(measurement, "_error") {
+	case "application_http":
+		return "http"
+	case "application_rpc":
+		return "rpc"
+	case "application_db":
+		return "db"
+	case "application_cache":
+		return "cache"
+	case "application_mq":
+		return "mq"
+	}
+	return "unknown"
+}
+
+// newID returns a random hex id of n*8 bytes, 8 bytes for span ids and 16 bytes for trace ids.
+func newID(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%016x", rand.Uint64())
+	}
+	return b.String()
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package erda
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestSpan(t *testing.T) {
+	m := &metric.Metric{
+		Name:        "application_http",
+		Measurement: "application_http",
+		Timestamp:   2000,
+		Tags: map[string]string{
+			"_metric_scope_id":    "tk-target",
+			"span_kind":           "server",
+			"http_method":         "GET",
+			"http_path":           "/api/users",
+			"target_terminus_key": "tk-target",
+			"target_service_name": "user-service",
+			"source_terminus_key": "tk-source",
+		},
+		Fields: map[string]interface{}{"elapsed_sum": uint64(500)},
+	}
+	s := Span(m)
+	if s == nil {
+		t.Fatal("expected span")
+	}
+	if s.Name != "span" || s.Tags["terminus_key"] != "tk-target" || s.Tags["service_name"] != "user-service" {
+		t.Errorf("unexpected scope: %+v", s.Tags)
+	}
+	if s.Tags["operation_name"] != "GET /api/users" || s.Tags["span_layer"] != "http" {
+		t.Errorf("unexpected operation: %+v", s.Tags)
+	}
+	if len(s.Tags["trace_id"]) != 32 || len(s.Tags["span_id"]) != 16 {
+		t.Errorf("unexpected ids: %s %s", s.Tags["trace_id"], s.Tags["span_id"])
+	}
+	if s.Fields["start_time"] != int64(1500) || s.Fields["end_time"] != int64(2000) {
+		t.Errorf("unexpected times: %+v", s.Fields)
+	}
+	if _, ok := s.Tags["_metric_scope_id"]; ok {
+		t.Error("internal tags should not be span tags")
+	}
+
+	m.Tags["span_kind"] = "producer"
+	if s := Span(m); s == nil || s.Tags["terminus_key"] != "tk-source" {
+		t.Errorf("producer spans belong to the source: %+v", s)
+	}
+
+	// the span id propagated by an instrumented client is kept.
+	m.Tags["span_kind"] = "client"
+	m.Tags["trace_id"] = "4bf92f3577b34da6a3ce929d0e0e4736"
+	m.Tags["span_id"] = "00f067aa0ba902b7"
+	if s := Span(m); s == nil || s.Tags["trace_id"] != m.Tags["trace_id"] || s.Tags["span_id"] != m.Tags["span_id"] {
+		t.Errorf("unexpected ids: %+v", s)
+	}
+
+	delete(m.Tags, "source_terminus_key")
+	if s := Span(m); s != nil {
+		t.Errorf("spans without terminus key should be dropped, got %+v", s)
+	}
+	if s := Span(&metric.Metric{Measurement: "ebpf_plugin_startup"}); s != nil {
+		t.Errorf("only request metrics are spans, got %+v", s)
+	}
+}
+
+func TestSpanEvents(t *testing.T) {
+	m := &metric.Metric{
+		Measurement: "application_http",
+		Timestamp:   2000,
+		Tags:        map[string]string{"target_terminus_key": "tk"},
+		Fields:      map[string]interface{}{"elapsed_sum": uint64(500)},
+	}
+	if s := Span(m); s == nil || s.Tags["events"] != "" {
+		t.Errorf("the spans without events should have no events tag, got %+v", s)
+	}
+	m.Events = []metric.Event{
+		{Name: "tcp.retransmit", Timestamp: 1600, Attributes: map[string]string{"tcp_state": "1"}},
+		{Name: "tcp.reset_received", Timestamp: 1900},
+	}
+	s := Span(m)
+	want := `[{"name":"tcp.retransmit","timestamp":1600,"attributes":{"tcp_state":"1"}},{"name":"tcp.reset_received","timestamp":1900}]`
+	if s == nil || s.Tags["events"] != want {
+		t.Errorf("expected the events %s, got %+v", want, s)
+	}
+}
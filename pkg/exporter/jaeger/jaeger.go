@@ -0,0 +1,238 @@
+// Package jaeger exports the HTTP and RPC requests observed by the agent as Jaeger spans, for the clusters
+// running Jaeger instead of the Erda collector. The spans are the spans of the OTLP export, see package
+// otlp, in the jaeger.thrift model:
+//
+//	process: service.name of the resource, the other resource attributes are process tags
+//	span:    operation, ids, start and duration (us), the attributes are tags, span.kind, error=true
+//	logs:    the events of the span, event=<name> and the attributes of the event
+//
+// The spans are posted in batches of their process, thrift binary encoded, to the HTTP endpoint of the
+// Jaeger collector JAEGER_SPAN_ENDPOINT (e.g. http://jaeger-collector:14268/api/traces) every
+// JAEGER_SPAN_INTERVAL (5s), the export is disabled without an endpoint. JAEGER_SPAN_USERNAME and
+// JAEGER_SPAN_PASSWORD are the basic auth of the collector. At most JAEGER_SPAN_MAX_QUEUED (10000)
+// spans are held between two exports, the spans beyond it and the spans of a failed export are dropped.
+// The gRPC endpoint of the collector is not supported, Jaeger 1.35+ receives the OTLP export as well.
+package jaeger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/otlp"
+)
+
+// sampled is the flag of the sampled spans.
+const sampled = 1
+
+type Config struct {
+	Endpoint  string        `env:"JAEGER_SPAN_ENDPOINT"`
+	Username  string        `env:"JAEGER_SPAN_USERNAME"`
+	Password  string        `env:"JAEGER_SPAN_PASSWORD"`
+	Interval  time.Duration `env:"JAEGER_SPAN_INTERVAL" default:"5s"`
+	MaxQueued int           `env:"JAEGER_SPAN_MAX_QUEUED" default:"10000"`
+	Timeout   time.Duration `env:"JAEGER_SPAN_TIMEOUT" default:"10s"`
+}
+
+var spanKinds = map[int]string{
+	otlp.SpanKindServer:   "server",
+	otlp.SpanKindClient:   "client",
+	otlp.SpanKindProducer: "producer",
+	otlp.SpanKindConsumer: "consumer",
+}
+
+// Exporter queues the spans of the request metrics and posts them to the Jaeger collector.
+type Exporter struct {
+	sync.Mutex
+	cfg    Config
+	clock  clock.Clock
+	client *http.Client
+	// batches are the queued spans by their process, keyed by the json of the resource of the spans.
+	batches map[string]*batch
+	queued  int
+	dropped int
+}
+
+// New returns the exporter of the spans, nil if JAEGER_SPAN_ENDPOINT is not set.
+func New() *Exporter {
+	var cfg Config
+	envconf.MustLoad(&cfg)
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	return newExporter(cfg)
+}
+
+func newExporter(cfg Config) *Exporter {
+	return &Exporter{
+		cfg:     cfg,
+		clock:   clock.Real,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		batches: make(map[string]*batch),
+	}
+}
+
+// Add queues the span of m if it is a request metric.
+func (e *Exporter) Add(m *metric.Metric) {
+	resource, s := otlp.Convert(m)
+	if s == nil {
+		return
+	}
+	key, _ := json.Marshal(resource)
+	e.Lock()
+	defer e.Unlock()
+	if e.queued >= e.cfg.MaxQueued {
+		e.dropped++
+		return
+	}
+	b, ok := e.batches[string(key)]
+	if !ok {
+		b = &batch{process: convertProcess(resource)}
+		e.batches[string(key)] = b
+	}
+	b.spans = append(b.spans, convertSpan(s))
+	e.queued++
+}
+
+func convertProcess(r *otlp.Resource) process {
+	p := process{}
+	for _, a := range r.Attributes {
+		if a.Key == "service.name" {
+			p.serviceName = a.Value.StringValue
+			continue
+		}
+		p.tags = append(p.tags, convertTag(a))
+	}
+	return p
+}
+
+func convertSpan(s *otlp.Span) span {
+	start, _ := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+	end, _ := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+	ans := span{
+		traceIDHigh:   hexID(s.TraceID[:16]),
+		traceIDLow:    hexID(s.TraceID[16:]),
+		spanID:        hexID(s.SpanID),
+		parentSpanID:  hexID(s.ParentSpanID),
+		operationName: s.Name,
+		flags:         sampled,
+		startTime:     start / 1000,
+		duration:      (end - start) / 1000,
+		tags:          make([]tag, 0, len(s.Attributes)+3),
+	}
+	for _, a := range s.Attributes {
+		ans.tags = append(ans.tags, convertTag(a))
+	}
+	if kind, ok := spanKinds[s.Kind]; ok {
+		ans.tags = append(ans.tags, tag{key: "span.kind", vType: tagString, vStr: kind})
+	}
+	for _, e := range s.Events {
+		ts, _ := strconv.ParseInt(e.TimeUnixNano, 10, 64)
+		l := log{timestamp: ts / 1000, fields: []tag{{key: "event", vType: tagString, vStr: e.Name}}}
+		for _, a := range e.Attributes {
+			l.fields = append(l.fields, convertTag(a))
+		}
+		ans.logs = append(ans.logs, l)
+	}
+	if s.Status != nil && s.Status.Code == otlp.StatusCodeError {
+		ans.tags = append(ans.tags, tag{key: "error", vType: tagBool, vBool: true})
+		if s.Status.Message != "" {
+			ans.tags = append(ans.tags, tag{key: "otel.status_description", vType: tagString, vStr: s.Status.Message})
+		}
+	}
+	return ans
+}
+
+func convertTag(a otlp.Attribute) tag {
+	if a.Value.IntValue != "" {
+		if v, err := strconv.ParseInt(a.Value.IntValue, 10, 64); err == nil {
+			return tag{key: a.Key, vType: tagLong, vLong: v}
+		}
+	}
+	return tag{key: a.Key, vType: tagString, vStr: a.Value.StringValue}
+}
+
+// hexID returns the 64 bit id of hex digits, 0 for an empty id.
+func hexID(id string) int64 {
+	v, _ := strconv.ParseUint(id, 16, 64)
+	return int64(v)
+}
+
+// Run exports the queued spans every interval until ctx is done.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := e.clock.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := e.Flush(); err != nil {
+				klog.Errorf("export spans to %s error: %v", e.cfg.Endpoint, err)
+			}
+		}
+	}
+}
+
+// Flush posts the queued spans, one request per process, they are dropped if the export fails.
+func (e *Exporter) Flush() error {
+	e.Lock()
+	batches := e.batches
+	queued, dropped := e.queued, e.dropped
+	e.batches = make(map[string]*batch)
+	e.queued, e.dropped = 0, 0
+	e.Unlock()
+
+	if dropped > 0 {
+		klog.Warningf("%d spans dropped, more than %d spans queued", dropped, e.cfg.MaxQueued)
+	}
+	if queued == 0 {
+		return nil
+	}
+	var errs []string
+	for _, b := range batches {
+		if err := e.post(b); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", b.process.serviceName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d batches failed, %s", len(errs), len(batches), strings.Join(errs, "; "))
+	}
+	klog.Infof("export %d spans to %s success", queued, e.cfg.Endpoint)
+	return nil
+}
+
+func (e *Exporter) post(b *batch) error {
+	var w writer
+	w.batch(b)
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(w.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-thrift")
+	if e.cfg.Username != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%d spans rejected, status %d: %s", len(b.spans), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package jaeger
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/exporter/otlp"
+)
+
+func TestConvertSpan(t *testing.T) {
+	s := convertSpan(&otlp.Span{
+		TraceID:           "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:            "00f067aa0ba902b7",
+		ParentSpanID:      "0000000000000001",
+		Name:              "GET /orders/{id}",
+		Kind:              otlp.SpanKindServer,
+		StartTimeUnixNano: "1000000",
+		EndTimeUnixNano:   "1500000",
+		Attributes: []otlp.Attribute{
+			{Key: "http.request.method", Value: otlp.AnyValue{StringValue: "GET"}},
+			{Key: "http.response.status_code", Value: otlp.AnyValue{IntValue: "503"}},
+		},
+		Status: &otlp.Status{Code: otlp.StatusCodeError, Message: "HTTP 503"},
+	})
+	if s.traceIDHigh != 0x4bf92f3577b34da6 || s.traceIDLow != -0x5c316d62f1f1b8ca || s.spanID != 0x00f067aa0ba902b7 ||
+		s.parentSpanID != 1 || s.startTime != 1000 || s.duration != 500 || s.flags != sampled {
+		t.Errorf("unexpected span %+v", s)
+	}
+	want := []tag{
+		{key: "http.request.method", vType: tagString, vStr: "GET"},
+		{key: "http.response.status_code", vType: tagLong, vLong: 503},
+		{key: "span.kind", vType: tagString, vStr: "server"},
+		{key: "error", vType: tagBool, vBool: true},
+		{key: "otel.status_description", vType: tagString, vStr: "HTTP 503"},
+	}
+	if len(s.tags) != len(want) {
+		t.Fatalf("unexpected tags %+v", s.tags)
+	}
+	for i := range want {
+		if s.tags[i] != want[i] {
+			t.Errorf("tag %d: expected %+v, got %+v", i, want[i], s.tags[i])
+		}
+	}
+}
+
+func TestConvertSpanEvents(t *testing.T) {
+	s := convertSpan(&otlp.Span{
+		TraceID:           "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:            "00f067aa0ba902b7",
+		StartTimeUnixNano: "1000000",
+		EndTimeUnixNano:   "1500000",
+		Events: []otlp.Event{{
+			TimeUnixNano: "1200000",
+			Name:         "tcp.retransmit",
+			Attributes:   []otlp.Attribute{{Key: "tcp_state", Value: otlp.AnyValue{StringValue: "1"}}},
+		}},
+	})
+	if len(s.logs) != 1 {
+		t.Fatalf("expected a log per event, got %+v", s.logs)
+	}
+	want := log{timestamp: 1200, fields: []tag{
+		{key: "event", vType: tagString, vStr: "tcp.retransmit"},
+		{key: "tcp_state", vType: tagString, vStr: "1"},
+	}}
+	if got := s.logs[0]; got.timestamp != want.timestamp || len(got.fields) != 2 ||
+		got.fields[0] != want.fields[0] || got.fields[1] != want.fields[1] {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	var w writer
+	w.span(&span{logs: []log{{timestamp: 3, fields: []tag{{key: "a", vType: tagLong, vLong: 2}}}}})
+	logs := []byte{
+		typeList, 0, 11, typeStruct, 0, 0, 0, 1, // list<Log> of 1
+		typeI64, 0, 1, 0, 0, 0, 0, 0, 0, 0, 3, // timestamp
+		typeList, 0, 2, typeStruct, 0, 0, 0, 1, // list<Tag> of 1
+		typeString, 0, 1, 0, 0, 0, 1, 'a',
+		typeI32, 0, 2, 0, 0, 0, tagLong,
+		typeI64, 0, 6, 0, 0, 0, 0, 0, 0, 0, 2,
+		typeStop, // tag
+		typeStop, // log
+		typeStop, // span
+	}
+	if !bytes.HasSuffix(w.Bytes(), logs) {
+		t.Errorf("expected the logs %v at the end of %v", logs, w.Bytes())
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var w writer
+	w.tags([]tag{{key: "a", vType: tagLong, vLong: 2}})
+	want := []byte{
+		typeStruct, 0, 0, 0, 1, // list<Tag> of 1
+		typeString, 0, 1, 0, 0, 0, 1, 'a', // key
+		typeI32, 0, 2, 0, 0, 0, tagLong, // vType
+		typeI64, 0, 6, 0, 0, 0, 0, 0, 0, 0, 2, // vLong
+		typeStop,
+	}
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("expected %v, got %v", want, w.Bytes())
+	}
+}
+
+func TestExporter(t *testing.T) {
+	var lock sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if r.Header.Get("Content-Type") != "application/x-thrift" || user != "jaeger" || password != "secret" {
+			t.Errorf("unexpected request headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		bodies = append(bodies, body)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	e := newExporter(Config{Endpoint: server.URL, Username: "jaeger", Password: "secret", MaxQueued: 10, Timeout: time.Second})
+	request := func(service string) *metric.Metric {
+		return &metric.Metric{
+			Measurement: "application_http",
+			Timestamp:   2000,
+			Tags:        map[string]string{"target_service_name": service, "http_method": "GET", "http_path": "/"},
+		}
+	}
+	e.Add(request("a"))
+	e.Add(request("a"))
+	e.Add(request("b"))
+	e.Add(&metric.Metric{Measurement: "ebpf_plugin_startup"})
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected a batch per process, got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		// Batch.process.serviceName
+		if !bytes.HasPrefix(body, []byte{typeStruct, 0, 1, typeString, 0, 1, 0, 0, 0, 1}) {
+			t.Errorf("unexpected batch %v", body)
+		}
+	}
+
+	bodies = nil
+	if err := e.Flush(); err != nil || len(bodies) != 0 {
+		t.Errorf("nothing is posted without spans, got %d, %v", len(bodies), err)
+	}
+}
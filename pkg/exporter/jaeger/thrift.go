@@ -0,0 +1,202 @@
+package jaeger
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// thrift types of the binary protocol
+const (
+	typeStop   = 0
+	typeBool   = 2
+	typeI32    = 8
+	typeI64    = 10
+	typeString = 11
+	typeStruct = 12
+	typeList   = 15
+)
+
+// tag types of jaeger.thrift
+const (
+	tagString = 0
+	tagBool   = 2
+	tagLong   = 3
+)
+
+// span reference types of jaeger.thrift
+const refChildOf = 0
+
+// The structs of jaeger.thrift the agent reports, see
+// https://github.com/jaegertracing/jaeger-idl/blob/main/thrift/jaeger.thrift
+type (
+	batch struct {
+		process process
+		spans   []span
+	}
+	process struct {
+		serviceName string
+		tags        []tag
+	}
+	span struct {
+		traceIDLow    int64
+		traceIDHigh   int64
+		spanID        int64
+		parentSpanID  int64
+		operationName string
+		flags         int32
+		// startTime and duration are in microseconds.
+		startTime int64
+		duration  int64
+		tags      []tag
+		logs      []log
+	}
+	// log is an event of the span, timestamp is in microseconds.
+	log struct {
+		timestamp int64
+		fields    []tag
+	}
+	tag struct {
+		key   string
+		vType int32
+		vStr  string
+		vBool bool
+		vLong int64
+	}
+)
+
+// writer encodes the structs in the thrift binary protocol.
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) fieldBegin(typ byte, id int16) {
+	w.WriteByte(typ)
+	w.i16(id)
+}
+
+func (w *writer) fieldStop() {
+	w.WriteByte(typeStop)
+}
+
+func (w *writer) listBegin(elem byte, size int) {
+	w.WriteByte(elem)
+	w.i32(int32(size))
+}
+
+func (w *writer) i16(v int16) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *writer) i32(v int32) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *writer) i64(v int64) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *writer) bool(v bool) {
+	if v {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+func (w *writer) string(v string) {
+	w.i32(int32(len(v)))
+	w.WriteString(v)
+}
+
+func (w *writer) batch(b *batch) {
+	w.fieldBegin(typeStruct, 1)
+	w.process(&b.process)
+	w.fieldBegin(typeList, 2)
+	w.listBegin(typeStruct, len(b.spans))
+	for i := range b.spans {
+		w.span(&b.spans[i])
+	}
+	w.fieldStop()
+}
+
+func (w *writer) process(p *process) {
+	w.fieldBegin(typeString, 1)
+	w.string(p.serviceName)
+	if len(p.tags) > 0 {
+		w.fieldBegin(typeList, 2)
+		w.tags(p.tags)
+	}
+	w.fieldStop()
+}
+
+func (w *writer) span(s *span) {
+	w.fieldBegin(typeI64, 1)
+	w.i64(s.traceIDLow)
+	w.fieldBegin(typeI64, 2)
+	w.i64(s.traceIDHigh)
+	w.fieldBegin(typeI64, 3)
+	w.i64(s.spanID)
+	w.fieldBegin(typeI64, 4)
+	w.i64(s.parentSpanID)
+	w.fieldBegin(typeString, 5)
+	w.string(s.operationName)
+	if s.parentSpanID != 0 {
+		// SpanRef: refType, traceIdLow, traceIdHigh, spanId
+		w.fieldBegin(typeList, 6)
+		w.listBegin(typeStruct, 1)
+		w.fieldBegin(typeI32, 1)
+		w.i32(refChildOf)
+		w.fieldBegin(typeI64, 2)
+		w.i64(s.traceIDLow)
+		w.fieldBegin(typeI64, 3)
+		w.i64(s.traceIDHigh)
+		w.fieldBegin(typeI64, 4)
+		w.i64(s.parentSpanID)
+		w.fieldStop()
+	}
+	w.fieldBegin(typeI32, 7)
+	w.i32(s.flags)
+	w.fieldBegin(typeI64, 8)
+	w.i64(s.startTime)
+	w.fieldBegin(typeI64, 9)
+	w.i64(s.duration)
+	if len(s.tags) > 0 {
+		w.fieldBegin(typeList, 10)
+		w.tags(s.tags)
+	}
+	if len(s.logs) > 0 {
+		w.fieldBegin(typeList, 11)
+		w.listBegin(typeStruct, len(s.logs))
+		for i := range s.logs {
+			w.fieldBegin(typeI64, 1)
+			w.i64(s.logs[i].timestamp)
+			w.fieldBegin(typeList, 2)
+			w.tags(s.logs[i].fields)
+			w.fieldStop()
+		}
+	}
+	w.fieldStop()
+}
+
+func (w *writer) tags(tags []tag) {
+	w.listBegin(typeStruct, len(tags))
+	for i := range tags {
+		t := &tags[i]
+		w.fieldBegin(typeString, 1)
+		w.string(t.key)
+		w.fieldBegin(typeI32, 2)
+		w.i32(t.vType)
+		switch t.vType {
+		case tagString:
+			w.fieldBegin(typeString, 3)
+			w.string(t.vStr)
+		case tagBool:
+			w.fieldBegin(typeBool, 5)
+			w.bool(t.vBool)
+		case tagLong:
+			w.fieldBegin(typeI64, 6)
+			w.i64(t.vLong)
+		}
+		w.fieldStop()
+	}
+}
@@ -0,0 +1,136 @@
+// Package latency measures the delay of the metrics through the pipeline of the agent, so that a
+// backlog (a plugin falling behind its kernel maps, exports failing or held back) is detected before
+// the metrics become stale. Metrics are stamped at every stage:
+//
+//	capture    the kernel captured the event completing the metric, set by the plugins that know it
+//	           (http and the protocol plugins pairing packets in user space)
+//	convert    the plugin handed the metric to the controller, or first converted it when the conversion
+//	           was held for the informer cache (see enrich.Interface.Submit)
+//	export     the metric was sent to the collector
+//
+// The delays between the stages are reported per measurement as ebpf_pipeline_latency:
+//
+//	tags:   host, measurement, stage (kernel_to_userspace or userspace_to_export)
+//	fields: count, sum, max, mean    delays (ns) since the previous report
+package latency
+
+import (
+	"os"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+const (
+	measurement = "ebpf_pipeline_latency"
+
+	StageKernelToUserspace = "kernel_to_userspace"
+	StageUserspaceToExport = "userspace_to_export"
+)
+
+type key struct {
+	measurement string
+	stage       string
+}
+
+type stats struct {
+	count uint64
+	sum   int64
+	max   int64
+}
+
+type Recorder struct {
+	sync.Mutex
+	clock  clock.Clock
+	delays map[key]*stats
+}
+
+func New(clk clock.Clock) *Recorder {
+	return &Recorder{clock: clk, delays: make(map[key]*stats)}
+}
+
+// Converted stamps the conversion of m and records its delay from the capture.
+func (r *Recorder) Converted(m *metric.Metric) {
+	if m == nil {
+		return
+	}
+	if m.ConvertTime == 0 {
+		m.ConvertTime = r.clock.Now().UnixNano()
+	}
+	if m.CaptureTime != 0 {
+		r.record(m.Measurement, StageKernelToUserspace, m.ConvertTime-m.CaptureTime)
+	}
+}
+
+// Exported records the delays of the exported metrics from their conversion, metrics created by the
+// controller (e.g. spans, self metrics) are not stamped and left out.
+func (r *Recorder) Exported(ms []*metric.Metric) {
+	now := r.clock.Now().UnixNano()
+	for _, m := range ms {
+		if m != nil && m.ConvertTime != 0 {
+			r.record(m.Measurement, StageUserspaceToExport, now-m.ConvertTime)
+		}
+	}
+}
+
+func (r *Recorder) record(measurement, stage string, delay int64) {
+	// the capture is converted from the monotonic clock, it may be slightly ahead of the wall clock.
+	delay = max(delay, 0)
+	r.Lock()
+	defer r.Unlock()
+	k := key{measurement: measurement, stage: stage}
+	s := r.delays[k]
+	if s == nil {
+		s = &stats{}
+		r.delays[k] = s
+	}
+	s.count++
+	s.sum += delay
+	s.max = max(s.max, delay)
+}
+
+// Metrics returns the delays since the previous call.
+func (r *Recorder) Metrics(timestamp int64) []*metric.Metric {
+	r.Lock()
+	defer r.Unlock()
+	host := os.Getenv("NODE_NAME")
+	ans := make([]*metric.Metric, 0, len(r.delays))
+	for k, s := range r.delays {
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			Tags: map[string]string{
+				"host":        host,
+				"measurement": k.measurement,
+				"stage":       k.stage,
+			},
+			Fields: map[string]interface{}{
+				"count": s.count,
+				"sum":   s.sum,
+				"max":   s.max,
+				"mean":  s.sum / int64(s.count),
+			},
+		})
+	}
+	r.delays = make(map[key]*stats)
+	return ans
+}
+
+var defaultRecorder = New(clock.Real)
+
+// Converted stamps the conversion of m and records its delay from the capture.
+func Converted(m *metric.Metric) {
+	defaultRecorder.Converted(m)
+}
+
+// Exported records the delays of the exported metrics from their conversion.
+func Exported(ms []*metric.Metric) {
+	defaultRecorder.Exported(ms)
+}
+
+// Metrics returns the delays of the pipeline since the previous call.
+func Metrics(timestamp int64) []*metric.Metric {
+	return defaultRecorder.Metrics(timestamp)
+}
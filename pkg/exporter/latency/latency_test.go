@@ -0,0 +1,51 @@
+package latency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+func TestRecorder(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	r := New(clk)
+	captured := clk.Now().UnixNano()
+
+	clk.Add(200 * time.Millisecond)
+	http := &metric.Metric{Measurement: "application_http", CaptureTime: captured}
+	dns := &metric.Metric{Measurement: "application_dns", CaptureTime: captured}
+	r.Converted(http)
+	clk.Add(400 * time.Millisecond)
+	r.Converted(dns)
+	r.Converted(&metric.Metric{Measurement: "application_db"})
+	if http.ConvertTime != captured+int64(200*time.Millisecond) {
+		t.Errorf("ConvertTime = %d", http.ConvertTime)
+	}
+
+	// the metrics kept after an export error count the delay to their actual export
+	clk.Add(5 * time.Second)
+	r.Exported([]*metric.Metric{http, dns, {Measurement: "ebpf_agent_schema"}})
+
+	got := make(map[key]map[string]interface{})
+	for _, m := range r.Metrics(0) {
+		got[key{m.Tags["measurement"], m.Tags["stage"]}] = m.Fields
+	}
+	// application_db is only converted, the schema record is not stamped
+	if len(got) != 4 {
+		t.Fatalf("unexpected delays %+v", got)
+	}
+	if f := got[key{"application_http", StageKernelToUserspace}]; f["max"] != int64(200*time.Millisecond) || f["count"] != uint64(1) {
+		t.Errorf("unexpected kernel delay of http %+v", f)
+	}
+	if f := got[key{"application_dns", StageKernelToUserspace}]; f["mean"] != int64(600*time.Millisecond) {
+		t.Errorf("unexpected kernel delay of dns %+v", f)
+	}
+	if f := got[key{"application_http", StageUserspaceToExport}]; f["sum"] != int64(5400*time.Millisecond) {
+		t.Errorf("unexpected export delay of http %+v", f)
+	}
+	if len(r.Metrics(0)) != 0 {
+		t.Errorf("the delays should be reset")
+	}
+}
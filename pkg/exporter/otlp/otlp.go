@@ -0,0 +1,243 @@
+// Package otlp exports the HTTP and RPC requests observed by the agent as OpenTelemetry spans, so that
+// the uninstrumented services get trace-like visibility in any OTLP backend (Jaeger, Tempo, the
+// OpenTelemetry collector, ...). Every request metric the plugins report, i.e. the requests kept by
+// their sampling, becomes one span.
+//
+// The spans are posted in the OTLP/HTTP json encoding to OTLP_SPAN_ENDPOINT (e.g.
+// http://otel-collector:4318/v1/traces) every OTLP_SPAN_INTERVAL (5s), the export is disabled without
+// an endpoint. OTLP_SPAN_HEADERS are added to the requests, e.g. "Authorization=Bearer xxx,X-Tenant=a".
+// At most OTLP_SPAN_MAX_QUEUED (10000) spans are held between two exports, the spans beyond it and the
+// spans of a failed export are dropped.
+//
+// The end of a span is the capture time of the packet completing the request, the kernel time
+// converted to unix time, its start the end minus the elapsed time of the request:
+//
+//	resource:   service.name, service.instance.id, erda.*   the server pod, the client pod of client spans
+//	span:       name (e.g. "GET /orders/{id}", the rpc target), kind (server, client), status (error)
+//	attributes: the tags of the metric, http.*, rpc.*, server.address, server.port, peer.service
+//	events:     the tcp anomalies of the connection during the request, e.g. tcp.retransmit (tcpevents)
+//
+// Unless a plugin recovered the trace context of the request (trace_id and parent_span_id tags),
+// every request is the root span of its own trace. The requests captured at an instrumented client
+// keep the span id the client propagated (span_id tag).
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+// The span kinds and the error status code of OTLP.
+const (
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+	SpanKindProducer = 4
+	SpanKindConsumer = 5
+
+	StatusCodeError = 2
+)
+
+const scopeName = "erda-ebpf-agent"
+
+type Config struct {
+	Endpoint  string        `env:"OTLP_SPAN_ENDPOINT"`
+	Headers   string        `env:"OTLP_SPAN_HEADERS"`
+	Interval  time.Duration `env:"OTLP_SPAN_INTERVAL" default:"5s"`
+	MaxQueued int           `env:"OTLP_SPAN_MAX_QUEUED" default:"10000"`
+	Timeout   time.Duration `env:"OTLP_SPAN_TIMEOUT" default:"10s"`
+}
+
+// The OTLP/HTTP json encoding of ExportTraceServiceRequest, the ids are hex and the 64 bit integers
+// decimal strings.
+type (
+	traces struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   Resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	scopeSpans struct {
+		Scope scope   `json:"scope"`
+		Spans []*Span `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+)
+
+type Resource struct {
+	Attributes []Attribute `json:"attributes"`
+}
+
+type Span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []Attribute `json:"attributes,omitempty"`
+	Events            []Event     `json:"events,omitempty"`
+	Status            *Status     `json:"status,omitempty"`
+}
+
+type Event struct {
+	TimeUnixNano string      `json:"timeUnixNano"`
+	Name         string      `json:"name"`
+	Attributes   []Attribute `json:"attributes,omitempty"`
+}
+
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type Attribute struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
+
+func sortAttributes(attributes []Attribute) {
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+}
+
+// Exporter queues the spans of the request metrics and posts them to the OTLP endpoint.
+type Exporter struct {
+	sync.Mutex
+	cfg     Config
+	clock   clock.Clock
+	headers map[string]string
+	client  *http.Client
+	// resources are the queued spans by their resource, keyed by the json of the resource.
+	resources map[string]*resourceSpans
+	queued    int
+	dropped   int
+}
+
+// New returns the exporter of the spans, nil if OTLP_SPAN_ENDPOINT is not set.
+func New() *Exporter {
+	var cfg Config
+	envconf.MustLoad(&cfg)
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	return newExporter(cfg)
+}
+
+func newExporter(cfg Config) *Exporter {
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(cfg.Headers, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.TrimSpace(k) != "" {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return &Exporter{
+		cfg:       cfg,
+		clock:     clock.Real,
+		headers:   headers,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		resources: make(map[string]*resourceSpans),
+	}
+}
+
+// Add queues the span of m if it is a request metric.
+func (e *Exporter) Add(m *metric.Metric) {
+	resource, span := Convert(m)
+	if span == nil {
+		return
+	}
+	key, _ := json.Marshal(resource)
+	e.Lock()
+	defer e.Unlock()
+	if e.queued >= e.cfg.MaxQueued {
+		e.dropped++
+		return
+	}
+	rs, ok := e.resources[string(key)]
+	if !ok {
+		rs = &resourceSpans{Resource: *resource, ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}}}}
+		e.resources[string(key)] = rs
+	}
+	rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, span)
+	e.queued++
+}
+
+// Run exports the queued spans every interval until ctx is done.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := e.clock.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := e.Flush(); err != nil {
+				klog.Errorf("export spans to %s error: %v", e.cfg.Endpoint, err)
+			}
+		}
+	}
+}
+
+// Flush posts the queued spans, they are dropped if the export fails.
+func (e *Exporter) Flush() error {
+	e.Lock()
+	body := traces{ResourceSpans: make([]resourceSpans, 0, len(e.resources))}
+	for _, rs := range e.resources {
+		body.ResourceSpans = append(body.ResourceSpans, *rs)
+	}
+	queued, dropped := e.queued, e.dropped
+	e.resources = make(map[string]*resourceSpans)
+	e.queued, e.dropped = 0, 0
+	e.Unlock()
+
+	if dropped > 0 {
+		klog.Warningf("%d spans dropped, more than %d spans queued", dropped, e.cfg.MaxQueued)
+	}
+	if queued == 0 {
+		return nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%d spans rejected, status %d: %s", queued, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	klog.Infof("export %d spans to %s success", queued, e.cfg.Endpoint)
+	return nil
+}
@@ -0,0 +1,199 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func attributeValue(attributes []Attribute, key string) (AnyValue, bool) {
+	for _, a := range attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return AnyValue{}, false
+}
+
+func TestSpan(t *testing.T) {
+	m := &metric.Metric{
+		Measurement: "application_http",
+		Timestamp:   2000,
+		CaptureTime: 1500,
+		OrgName:     "erda",
+		Tags: map[string]string{
+			"_meta":               "true",
+			"span_kind":           "server",
+			"http_method":         "GET",
+			"http_path":           "/orders/{id}",
+			"http_status_code":    "503",
+			"error":               "true",
+			"peer_address":        "10.0.0.2:8080",
+			"target_service_name": "order",
+			"target_terminus_key": "tk",
+			"source_service_name": "web",
+			"trace_id":            "4bf92f3577b34da6a3ce929d0e0e4736",
+			"parent_span_id":      "00f067aa0ba902b7",
+		},
+		Fields: map[string]interface{}{"elapsed_sum": uint64(500)},
+	}
+	resource, span := Convert(m)
+	if span == nil {
+		t.Fatal("expected a span")
+	}
+	if v, _ := attributeValue(resource.Attributes, "service.name"); v.StringValue != "order" {
+		t.Errorf("server spans belong to the target, got %+v", resource)
+	}
+	if v, _ := attributeValue(resource.Attributes, "erda.terminus_key"); v.StringValue != "tk" {
+		t.Errorf("unexpected resource %+v", resource)
+	}
+	if span.Name != "GET /orders/{id}" || span.Kind != SpanKindServer ||
+		span.StartTimeUnixNano != "1000" || span.EndTimeUnixNano != "1500" {
+		t.Errorf("unexpected span %+v", span)
+	}
+	if span.TraceID != m.Tags["trace_id"] || span.ParentSpanID != m.Tags["parent_span_id"] || len(span.SpanID) != 16 {
+		t.Errorf("unexpected ids %+v", span)
+	}
+	if span.Status == nil || span.Status.Code != StatusCodeError || span.Status.Message != "HTTP 503" {
+		t.Errorf("unexpected status %+v", span.Status)
+	}
+	for key, want := range map[string]AnyValue{
+		"http.request.method":       {StringValue: "GET"},
+		"http.response.status_code": {IntValue: "503"},
+		"http_status_code":          {StringValue: "503"},
+		"server.address":            {StringValue: "10.0.0.2"},
+		"server.port":               {IntValue: "8080"},
+		"peer.service":              {StringValue: "web"},
+	} {
+		if v, _ := attributeValue(span.Attributes, key); v != want {
+			t.Errorf("attribute %s: expected %+v, got %+v", key, want, v)
+		}
+	}
+	if _, ok := attributeValue(span.Attributes, "_meta"); ok {
+		t.Error("internal tags are not attributes")
+	}
+}
+
+func TestSpanEvents(t *testing.T) {
+	m := &metric.Metric{
+		Measurement: "application_rpc",
+		Timestamp:   2000,
+		Fields:      map[string]interface{}{"elapsed_sum": uint64(500)},
+		Events: []metric.Event{
+			{Name: "tcp.retransmit", Timestamp: 1600, Attributes: map[string]string{
+				"tcp_state": "1", "local_address": "10.0.0.2:8080",
+			}},
+			{Name: "tcp.reset_sent", Timestamp: 1900},
+		},
+	}
+	_, span := Convert(m)
+	if span == nil || len(span.Events) != 2 {
+		t.Fatalf("expected the events of the request, got %+v", span)
+	}
+	retransmit := span.Events[0]
+	if retransmit.Name != "tcp.retransmit" || retransmit.TimeUnixNano != "1600" || len(retransmit.Attributes) != 2 ||
+		retransmit.Attributes[0].Key != "local_address" || retransmit.Attributes[1].Value.StringValue != "1" {
+		t.Errorf("unexpected event %+v", retransmit)
+	}
+	if reset := span.Events[1]; reset.Name != "tcp.reset_sent" || reset.TimeUnixNano != "1900" || reset.Attributes != nil {
+		t.Errorf("unexpected event %+v", reset)
+	}
+	b, _ := json.Marshal(span)
+	var decoded map[string]interface{}
+	_ = json.Unmarshal(b, &decoded)
+	if events, ok := decoded["events"].([]interface{}); !ok || len(events) != 2 {
+		t.Errorf("the events should be encoded, got %s", b)
+	}
+}
+
+func TestSpanClient(t *testing.T) {
+	resource, span := Convert(&metric.Metric{
+		Measurement: "application_rpc",
+		Timestamp:   2000,
+		Tags: map[string]string{
+			"span_kind":           "client",
+			"rpc_type":            "GRPC",
+			"rpc_target":          "/helloworld.Greeter/SayHello",
+			"source_service_name": "web",
+			"peer_service":        "greeter",
+			"trace_id":            "not-a-trace-id",
+			"span_id":             "00f067aa0ba902b7",
+		},
+		Fields: map[string]interface{}{"elapsed_sum": int64(300)},
+	})
+	if v, _ := attributeValue(resource.Attributes, "service.name"); v.StringValue != "web" {
+		t.Errorf("client spans belong to the source, got %+v", resource)
+	}
+	if span.Kind != SpanKindClient || span.Name != "/helloworld.Greeter/SayHello" || span.Status != nil ||
+		span.StartTimeUnixNano != "1700" || span.EndTimeUnixNano != "2000" {
+		t.Errorf("unexpected span %+v", span)
+	}
+	if len(span.TraceID) != 32 || span.TraceID == "not-a-trace-id" {
+		t.Errorf("invalid trace ids are replaced, got %s", span.TraceID)
+	}
+	if span.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("the span id propagated by the client is kept, got %s", span.SpanID)
+	}
+	if v, _ := attributeValue(span.Attributes, "rpc.system"); v.StringValue != "grpc" {
+		t.Errorf("unexpected attributes %+v", span.Attributes)
+	}
+	if v, _ := attributeValue(span.Attributes, "peer.service"); v.StringValue != "greeter" {
+		t.Errorf("unexpected attributes %+v", span.Attributes)
+	}
+
+	if _, span := Convert(&metric.Metric{Measurement: "application_db"}); span != nil {
+		t.Errorf("only http and rpc requests are spans, got %+v", span)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	var received traces
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("invalid body %s: %v", body, err)
+		}
+	}))
+	defer server.Close()
+
+	e := newExporter(Config{Endpoint: server.URL, Headers: "Authorization=Bearer token", MaxQueued: 3, Timeout: time.Second})
+	request := func(service string) *metric.Metric {
+		return &metric.Metric{
+			Measurement: "application_http",
+			Timestamp:   2000,
+			Tags:        map[string]string{"target_service_name": service, "http_method": "GET", "http_path": "/"},
+		}
+	}
+	e.Add(request("a"))
+	e.Add(request("a"))
+	e.Add(&metric.Metric{Measurement: "application_db"})
+	e.Add(request("b"))
+	e.Add(request("c"))
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if header != "Bearer token" {
+		t.Errorf("expected the configured headers, got %q", header)
+	}
+	if len(received.ResourceSpans) != 2 {
+		t.Fatalf("expected the spans of two services, got %+v", received)
+	}
+	for _, rs := range received.ResourceSpans {
+		v, _ := attributeValue(rs.Resource.Attributes, "service.name")
+		if n := len(rs.ScopeSpans[0].Spans); v.StringValue == "a" && n != 2 || v.StringValue == "b" && n != 1 {
+			t.Errorf("unexpected spans of %s: %d", v.StringValue, n)
+		}
+	}
+
+	received = traces{}
+	if err := e.Flush(); err != nil || len(received.ResourceSpans) != 0 {
+		t.Errorf("nothing is posted without spans, got %+v, %v", received, err)
+	}
+}
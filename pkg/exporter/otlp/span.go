@@ -0,0 +1,238 @@
+package otlp
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+// requestMeasurements are the L7 measurements of single HTTP and RPC requests.
+var requestMeasurements = map[string]bool{
+	"application_http":       true,
+	"application_http_error": true,
+	"application_rpc":        true,
+}
+
+// internalTags are the metric tags that are not span attributes.
+var internalTags = map[string]bool{
+	"_meta":            true,
+	"_metric_scope":    true,
+	"_metric_scope_id": true,
+	"trace_id":         true,
+	"span_id":          true,
+	"parent_span_id":   true,
+}
+
+// resourceTags map the <source|target>_ tags of the side owning the span to the resource attributes.
+var resourceTags = map[string]string{
+	"service_name":        "service.name",
+	"service_instance_id": "service.instance.id",
+	"application_name":    "erda.application.name",
+	"project_name":        "erda.project.name",
+	"runtime_name":        "erda.runtime.name",
+	"workspace":           "erda.workspace",
+	"terminus_key":        "erda.terminus_key",
+}
+
+// semanticTags map the metric tags to the attributes of the OpenTelemetry semantic conventions, the
+// tags are reported under their own name as well.
+var semanticTags = map[string]string{
+	"http_method":      "http.request.method",
+	"http_path":        "http.route",
+	"http_url":         "url.full",
+	"http_version":     "network.protocol.version",
+	"http_status_code": "http.response.status_code",
+	"rpc_service":      "rpc.service",
+	"rpc_method":       "rpc.method",
+	"grpc_status_code": "rpc.grpc.status_code",
+	"peer_hostname":    "net.peer.name",
+	"cluster_name":     "k8s.cluster.name",
+}
+
+// intAttributes are the semantic attributes with integer values.
+var intAttributes = map[string]bool{
+	"http.response.status_code": true,
+	"rpc.grpc.status_code":      true,
+	"server.port":               true,
+}
+
+var spanKinds = map[string]int{
+	"server":   SpanKindServer,
+	"client":   SpanKindClient,
+	"producer": SpanKindProducer,
+	"consumer": SpanKindConsumer,
+}
+
+// Convert returns the span of an HTTP or RPC request metric and the resource it belongs to, nil for other metrics.
+func Convert(m *metric.Metric) (*Resource, *Span) {
+	if !requestMeasurements[m.Measurement] {
+		return nil, nil
+	}
+	kind := spanKinds[m.Tags["span_kind"]]
+	if kind == 0 {
+		kind = SpanKindServer
+	}
+	// server spans belong to the target pod, client spans to the source pod.
+	side, peer := "target", "source"
+	if kind != SpanKindServer {
+		side, peer = "source", "target"
+	}
+
+	resource := &Resource{}
+	for tag, name := range resourceTags {
+		if v := m.Tags[side+"_"+tag]; v != "" {
+			resource.Attributes = append(resource.Attributes, stringAttribute(name, v))
+		}
+	}
+	if m.Tags[side+"_service_name"] == "" {
+		name := m.Tags["peer_service"]
+		if side == "source" || name == "" {
+			name = "unknown"
+		}
+		resource.Attributes = append(resource.Attributes, stringAttribute("service.name", name))
+	}
+	if m.OrgName != "" {
+		resource.Attributes = append(resource.Attributes, stringAttribute("erda.org.name", m.OrgName))
+	}
+	sortAttributes(resource.Attributes)
+
+	end := m.CaptureTime
+	if end == 0 {
+		end = m.Timestamp
+	}
+	span := &Span{
+		TraceID:           m.Tags["trace_id"],
+		SpanID:            m.Tags["span_id"],
+		ParentSpanID:      m.Tags["parent_span_id"],
+		Name:              operationName(m),
+		Kind:              kind,
+		StartTimeUnixNano: strconv.FormatInt(end-toInt64(m.Fields["elapsed_sum"]), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end, 10),
+	}
+	if !isHexID(span.TraceID, 32) {
+		span.TraceID = newID(2)
+	}
+	if !isHexID(span.SpanID, 16) {
+		span.SpanID = newID(1)
+	}
+	if !isHexID(span.ParentSpanID, 16) {
+		span.ParentSpanID = ""
+	}
+
+	for k, v := range m.Tags {
+		if internalTags[k] || v == "" {
+			continue
+		}
+		span.Attributes = append(span.Attributes, stringAttribute(k, v))
+		if name, ok := semanticTags[k]; ok {
+			span.Attributes = append(span.Attributes, attribute(name, v))
+		}
+	}
+	if rpcType := m.Tags["rpc_type"]; rpcType != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("rpc.system", strings.ToLower(rpcType)))
+	}
+	if host, port, err := net.SplitHostPort(m.Tags["peer_address"]); err == nil {
+		span.Attributes = append(span.Attributes, stringAttribute("server.address", host), attribute("server.port", port))
+	}
+	if name := m.Tags[peer+"_service_name"]; name != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("peer.service", name))
+	} else if peer == "target" && m.Tags["peer_service"] != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("peer.service", m.Tags["peer_service"]))
+	}
+	sortAttributes(span.Attributes)
+	for _, e := range m.Events {
+		event := Event{TimeUnixNano: strconv.FormatInt(e.Timestamp, 10), Name: e.Name}
+		for k, v := range e.Attributes {
+			event.Attributes = append(event.Attributes, stringAttribute(k, v))
+		}
+		sortAttributes(event.Attributes)
+		span.Events = append(span.Events, event)
+	}
+
+	if m.Tags["error"] == "true" || strings.HasSuffix(m.Measurement, "_error") {
+		span.Status = &Status{Code: StatusCodeError, Message: statusMessage(m)}
+	}
+	return resource, span
+}
+
+// operationName is the name of the span, the route for http and the method for rpc.
+func operationName(m *metric.Metric) string {
+	t := m.Tags
+	switch {
+	case t["graphql_operation_name"] != "":
+		return strings.TrimSpace(t["graphql_operation_type"] + " " + t["graphql_operation_name"])
+	case t["http_method"] != "" && t["http_path"] != "":
+		return t["http_method"] + " " + t["http_path"]
+	case t["rpc_target"] != "":
+		return t["rpc_target"]
+	}
+	return strings.TrimSuffix(m.Measurement, "_error")
+}
+
+func statusMessage(m *metric.Metric) string {
+	t := m.Tags
+	switch {
+	case t["grpc_message"] != "":
+		return t["grpc_message"]
+	case t["grpc_status"] != "" && t["grpc_status"] != "OK":
+		return t["grpc_status"]
+	case t["http_status_code"] != "" && t["http_status_code"] != "0":
+		return "HTTP " + t["http_status_code"]
+	}
+	return ""
+}
+
+func attribute(name, value string) Attribute {
+	if intAttributes[name] {
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return Attribute{Key: name, Value: AnyValue{IntValue: value}}
+		}
+	}
+	return stringAttribute(name, value)
+}
+
+func stringAttribute(name, value string) Attribute {
+	return Attribute{Key: name, Value: AnyValue{StringValue: value}}
+}
+
+// isHexID reports whether id is a non-zero lowercase hex id of n digits.
+func isHexID(id string, n int) bool {
+	if len(id) != n || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newID returns a random hex id of n*8 bytes, 8 bytes for span ids and 16 bytes for trace ids.
+func newID(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%016x", rand.Uint64())
+	}
+	return b.String()
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,243 @@
+// Package scheduler defers the non-critical metrics of the agent while the node is under CPU or
+// network pressure, so that the agent adds less load during incidents. RED metrics (the L7
+// measurements) keep flowing, the deferrable measurements (inventories, schema records, ...) are
+// held back until the pressure is over:
+//
+//	EXPORT_PRESSURE_CPU=0.85                                                      node cpu usage (0-1) starting the pressure
+//	EXPORT_PRESSURE_NETWORK_BYTES=0                                               node rx+tx bytes per second starting the pressure, 0 disables it
+//	EXPORT_DEFERRABLE_MEASUREMENTS=*_inventory,ebpf_agent_schema,ebpf_coverage    shell patterns of the measurements
+//	EXPORT_MAX_DEFER=10m                                                          deferred metrics are sent anyway after this delay
+//	EXPORT_MAX_DEFERRED=50000                                                     the oldest deferred metrics are dropped above it
+//
+// The pressure ends once the usage falls below 90% of the thresholds, so that batches are not released
+// and deferred again at every export around the threshold. The state of the scheduler is reported as
+// ebpf_export_scheduler:
+//
+//	tags:   host
+//	fields: pressure, cpu_usage, network_bytes    state at the last export
+//	        deferred                               metrics currently held back
+//	        dropped                                metrics dropped since the previous report
+package scheduler
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/procfs"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	measurement = "ebpf_export_scheduler"
+	// releaseRatio is the share of the thresholds below which the pressure ends.
+	releaseRatio = 0.9
+)
+
+type Config struct {
+	CPUThreshold     float64       `env:"EXPORT_PRESSURE_CPU" default:"0.85"`
+	NetworkThreshold uint64        `env:"EXPORT_PRESSURE_NETWORK_BYTES" default:"0"`
+	Deferrable       string        `env:"EXPORT_DEFERRABLE_MEASUREMENTS" default:"*_inventory,ebpf_agent_schema,ebpf_coverage"`
+	MaxDefer         time.Duration `env:"EXPORT_MAX_DEFER" default:"10m"`
+	MaxDeferred      int           `env:"EXPORT_MAX_DEFERRED" default:"50000"`
+}
+
+// Usage is the load of the node since the previous sample.
+type Usage struct {
+	// CPU is the busy share of the cpus, in [0, 1].
+	CPU float64
+	// NetworkBytes is the rx+tx bytes per second of the interfaces but lo.
+	NetworkBytes uint64
+}
+
+type deferred struct {
+	metric *metric.Metric
+	since  time.Time
+}
+
+type Scheduler struct {
+	sync.Mutex
+	cfg        Config
+	deferrable []string
+	// sample returns the usage of the node, ok is false when it could not be read.
+	sample func() (Usage, bool)
+	clock  clock.Clock
+
+	pressure bool
+	usage    Usage
+	deferred []deferred
+	dropped  uint64
+}
+
+func New(cfg Config) *Scheduler {
+	s := &Scheduler{
+		cfg:    cfg,
+		sample: newProcSampler("/proc", clock.Real).sample,
+		clock:  clock.Real,
+	}
+	for _, m := range strings.Split(cfg.Deferrable, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			s.deferrable = append(s.deferrable, m)
+		}
+	}
+	return s
+}
+
+func (s *Scheduler) isDeferrable(m *metric.Metric) bool {
+	for _, d := range s.deferrable {
+		if ok, _ := path.Match(d, m.Measurement); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// updatePressure samples the usage of the node, the previous state is kept if it can not be read.
+func (s *Scheduler) updatePressure() {
+	usage, ok := s.sample()
+	if !ok {
+		return
+	}
+	s.usage = usage
+	ratio := 1.0
+	if s.pressure {
+		ratio = releaseRatio
+	}
+	cpu := s.cfg.CPUThreshold > 0 && usage.CPU >= s.cfg.CPUThreshold*ratio
+	network := s.cfg.NetworkThreshold > 0 && float64(usage.NetworkBytes) >= float64(s.cfg.NetworkThreshold)*ratio
+	s.pressure = cpu || network
+}
+
+// Schedule returns the metrics of the batch to send now, with the deferred metrics that are released.
+// The deferrable metrics of the batch are held back while the node is under pressure.
+func (s *Scheduler) Schedule(batch []*metric.Metric) []*metric.Metric {
+	s.Lock()
+	defer s.Unlock()
+	s.updatePressure()
+	now := s.clock.Now()
+	send := make([]*metric.Metric, 0, len(batch))
+	for _, m := range batch {
+		if s.pressure && s.isDeferrable(m) {
+			s.deferred = append(s.deferred, deferred{metric: m, since: now})
+			continue
+		}
+		send = append(send, m)
+	}
+	if over := len(s.deferred) - s.cfg.MaxDeferred; over > 0 {
+		s.dropped += uint64(over)
+		s.deferred = append([]deferred(nil), s.deferred[over:]...)
+	}
+	// deferred metrics are in the order they were held back, the oldest are released first.
+	i := 0
+	for i < len(s.deferred) && (!s.pressure || now.Sub(s.deferred[i].since) >= s.cfg.MaxDefer) {
+		send = append(send, s.deferred[i].metric)
+		i++
+	}
+	if i > 0 {
+		s.deferred = append([]deferred(nil), s.deferred[i:]...)
+	}
+	return send
+}
+
+// Metrics returns the state of the scheduler.
+func (s *Scheduler) Metrics(timestamp int64) []*metric.Metric {
+	s.Lock()
+	defer s.Unlock()
+	m := &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   timestamp,
+		Tags: map[string]string{
+			"host": os.Getenv("NODE_NAME"),
+		},
+		Fields: map[string]interface{}{
+			"pressure":      s.pressure,
+			"cpu_usage":     s.usage.CPU,
+			"network_bytes": s.usage.NetworkBytes,
+			"deferred":      len(s.deferred),
+			"dropped":       s.dropped,
+		},
+	}
+	s.dropped = 0
+	return []*metric.Metric{m}
+}
+
+// procSampler computes the usage of the node from the counters of /proc between two samples.
+type procSampler struct {
+	clock    clock.Clock
+	fs       procfs.FS
+	err      error
+	last     time.Time
+	busy     float64
+	total    float64
+	netBytes uint64
+}
+
+func newProcSampler(mountPoint string, clk clock.Clock) *procSampler {
+	fs, err := procfs.NewFS(mountPoint)
+	return &procSampler{clock: clk, fs: fs, err: err}
+}
+
+func (p *procSampler) sample() (Usage, bool) {
+	if p.err != nil {
+		return Usage{}, false
+	}
+	stat, err := p.fs.Stat()
+	if err != nil {
+		return Usage{}, false
+	}
+	netDev, err := p.fs.NetDev()
+	if err != nil {
+		return Usage{}, false
+	}
+	c := stat.CPUTotal
+	idle := c.Idle + c.Iowait
+	total := idle + c.User + c.Nice + c.System + c.IRQ + c.SoftIRQ + c.Steal
+	var netBytes uint64
+	for name, dev := range netDev {
+		if name != "lo" {
+			netBytes += dev.RxBytes + dev.TxBytes
+		}
+	}
+	now := p.clock.Now()
+	last, lastBusy, lastTotal, lastNetBytes := p.last, p.busy, p.total, p.netBytes
+	p.last, p.busy, p.total, p.netBytes = now, total-idle, total, netBytes
+	// the first sample only sets the counters
+	if last.IsZero() || total <= lastTotal {
+		return Usage{}, false
+	}
+	usage := Usage{CPU: (total - idle - lastBusy) / (total - lastTotal)}
+	if seconds := now.Sub(last).Seconds(); seconds > 0 && netBytes >= lastNetBytes {
+		usage.NetworkBytes = uint64(float64(netBytes-lastNetBytes) / seconds)
+	}
+	return usage, true
+}
+
+var (
+	defaultScheduler *Scheduler
+	once             sync.Once
+)
+
+func scheduler() *Scheduler {
+	once.Do(func() {
+		cfg := Config{}
+		envconf.MustLoad(&cfg)
+		defaultScheduler = New(cfg)
+	})
+	return defaultScheduler
+}
+
+// Schedule returns the metrics of the batch to send now.
+func Schedule(batch []*metric.Metric) []*metric.Metric {
+	return scheduler().Schedule(batch)
+}
+
+// Metrics returns the state of the export scheduler.
+func Metrics(timestamp int64) []*metric.Metric {
+	return scheduler().Metrics(timestamp)
+}
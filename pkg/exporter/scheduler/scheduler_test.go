@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+func measurements(ms []*metric.Metric) []string {
+	names := make([]string, 0, len(ms))
+	for _, m := range ms {
+		names = append(names, m.Measurement)
+	}
+	return names
+}
+
+func TestSchedule(t *testing.T) {
+	s := New(Config{CPUThreshold: 0.8, Deferrable: "*_inventory, ebpf_agent_schema", MaxDefer: time.Minute, MaxDeferred: 2})
+	usage, clk := Usage{}, clock.NewFake(time.Unix(0, 0))
+	s.sample = func() (Usage, bool) { return usage, true }
+	s.clock = clk
+	batch := func() []*metric.Metric {
+		return []*metric.Metric{
+			{Measurement: "application_http"},
+			{Measurement: "application_tls_inventory"},
+			{Measurement: "ebpf_agent_schema"},
+		}
+	}
+
+	if send := s.Schedule(batch()); len(send) != 3 {
+		t.Fatalf("nothing is deferred without pressure, got %v", measurements(send))
+	}
+
+	usage.CPU = 0.9
+	if send := s.Schedule(batch()); len(send) != 1 || send[0].Measurement != "application_http" {
+		t.Fatalf("only the red metrics should be sent under pressure, got %v", measurements(send))
+	}
+	// still under pressure below the threshold, the oldest deferred metrics are dropped above the limit
+	usage.CPU = 0.75
+	clk.Add(30 * time.Second)
+	if send := s.Schedule(batch()); len(send) != 1 {
+		t.Fatalf("the pressure should last until the usage falls below 90%% of the threshold, got %v", measurements(send))
+	}
+	if m := s.Metrics(0)[0]; m.Fields["deferred"] != 2 || m.Fields["dropped"] != uint64(2) || m.Fields["pressure"] != true {
+		t.Errorf("unexpected state %+v", m.Fields)
+	}
+
+	// the metrics deferred for longer than MaxDefer are sent anyway
+	clk.Add(40 * time.Second)
+	if send := s.Schedule(nil); len(send) != 0 {
+		t.Fatalf("unexpected metrics %v", measurements(send))
+	}
+	clk.Add(30 * time.Second)
+	if send := s.Schedule(nil); len(send) != 2 {
+		t.Fatalf("expected the overdue metrics, got %v", measurements(send))
+	}
+
+	usage.CPU = 0.9
+	s.Schedule(batch())
+	usage.CPU = 0.5
+	if send := s.Schedule(batch()); len(send) != 5 {
+		t.Fatalf("the deferred metrics should be released after the pressure, got %v", measurements(send))
+	}
+}
+
+func TestNetworkPressure(t *testing.T) {
+	s := New(Config{NetworkThreshold: 1000, Deferrable: "*_inventory", MaxDefer: time.Minute, MaxDeferred: 10})
+	s.sample = func() (Usage, bool) { return Usage{NetworkBytes: 2000}, true }
+	if send := s.Schedule([]*metric.Metric{{Measurement: "application_tls_inventory"}}); len(send) != 0 {
+		t.Errorf("the inventory should be deferred, got %v", measurements(send))
+	}
+	s.sample = func() (Usage, bool) { return Usage{}, false }
+	if send := s.Schedule(nil); len(send) != 0 || !s.pressure {
+		t.Errorf("the pressure should be kept when the usage can not be read, got %v", measurements(send))
+	}
+}
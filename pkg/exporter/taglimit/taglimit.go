@@ -0,0 +1,162 @@
+// Package taglimit bounds the length of the tag values of the exported metrics, so that over-long
+// paths, statements or headers are not rejected by the ingestion of the collector. Values above the
+// limit are truncated and end with a hash of the full value, values sharing a long prefix stay
+// distinguishable and a value is always truncated the same way:
+//
+//	EXPORT_TAG_MAX_LENGTH=1024                              default limit (bytes), 0 disables it
+//	EXPORT_TAG_MAX_LENGTHS=db_statement=4096,http_path=256  limits of single tags, 0 disables the limit of a tag
+//
+// The truncations are counted and reported as ebpf_tag_truncation:
+//
+//	tags:   host, measurement, tag
+//	fields: count    truncated values since the previous report
+package taglimit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	measurement = "ebpf_tag_truncation"
+
+	// suffixLen is the length of the "~" separator and the 8 hex digits of the hash.
+	suffixLen = 9
+)
+
+type Config struct {
+	MaxLength  int    `env:"EXPORT_TAG_MAX_LENGTH" default:"1024"`
+	MaxLengths string `env:"EXPORT_TAG_MAX_LENGTHS"`
+}
+
+type key struct {
+	measurement string
+	tag         string
+}
+
+type Limiter struct {
+	sync.Mutex
+	maxLength  int
+	maxLengths map[string]int
+	truncated  map[key]uint64
+}
+
+func New(cfg Config) *Limiter {
+	l := &Limiter{
+		maxLength:  cfg.MaxLength,
+		maxLengths: make(map[string]int),
+		truncated:  make(map[key]uint64),
+	}
+	for _, item := range strings.Split(cfg.MaxLengths, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		tag, v, _ := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			klog.Warningf("invalid tag length limit %q of EXPORT_TAG_MAX_LENGTHS is ignored", item)
+			continue
+		}
+		l.maxLengths[strings.TrimSpace(tag)] = n
+	}
+	return l
+}
+
+func (l *Limiter) limit(tag string) int {
+	if n, ok := l.maxLengths[tag]; ok {
+		return n
+	}
+	return l.maxLength
+}
+
+// Apply truncates the tag values of m above their limit.
+func (l *Limiter) Apply(m *metric.Metric) {
+	if m == nil {
+		return
+	}
+	for tag, v := range m.Tags {
+		n := l.limit(tag)
+		if n <= 0 || len(v) <= n {
+			continue
+		}
+		m.Tags[tag] = truncate(v, n)
+		l.Lock()
+		l.truncated[key{measurement: m.Measurement, tag: tag}]++
+		l.Unlock()
+	}
+}
+
+// truncate cuts v to n bytes at a rune boundary, the last bytes are replaced by the hash of v.
+// The result is not truncated again, so metrics sent again after an export error keep their values.
+func truncate(v string, n int) string {
+	h := fnv.New32a()
+	h.Write([]byte(v))
+	suffix := fmt.Sprintf("~%08x", h.Sum32())
+	if n <= suffixLen {
+		return suffix[len(suffix)-n:]
+	}
+	cut := n - suffixLen
+	for cut > 0 && !utf8.RuneStart(v[cut]) {
+		cut--
+	}
+	return v[:cut] + suffix
+}
+
+// Metrics returns the truncations since the previous call.
+func (l *Limiter) Metrics(timestamp int64) []*metric.Metric {
+	l.Lock()
+	defer l.Unlock()
+	host := os.Getenv("NODE_NAME")
+	ans := make([]*metric.Metric, 0, len(l.truncated))
+	for k, count := range l.truncated {
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			Tags: map[string]string{
+				"host":        host,
+				"measurement": k.measurement,
+				"tag":         k.tag,
+			},
+			Fields: map[string]interface{}{
+				"count": count,
+			},
+		})
+	}
+	l.truncated = make(map[key]uint64)
+	return ans
+}
+
+var (
+	defaultLimiter *Limiter
+	once           sync.Once
+)
+
+func limiter() *Limiter {
+	once.Do(func() {
+		cfg := Config{}
+		envconf.MustLoad(&cfg)
+		defaultLimiter = New(cfg)
+	})
+	return defaultLimiter
+}
+
+// Apply truncates the tag values of m above the configured limits.
+func Apply(m *metric.Metric) {
+	limiter().Apply(m)
+}
+
+// Metrics returns the truncations of the tag values since the previous call.
+func Metrics(timestamp int64) []*metric.Metric {
+	return limiter().Metrics(timestamp)
+}
@@ -0,0 +1,65 @@
+package taglimit
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestApply(t *testing.T) {
+	l := New(Config{MaxLength: 32, MaxLengths: "db_statement=64, http_path=0, bad=x"})
+	long := "/api/v1/orders/" + strings.Repeat("a", 40)
+	m := &metric.Metric{
+		Measurement: "application_http",
+		Tags: map[string]string{
+			"http_url":     long,
+			"http_path":    long,
+			"db_statement": long,
+			"short":        "ok",
+		},
+	}
+	l.Apply(m)
+
+	if got := m.Tags["http_url"]; len(got) != 32 || !strings.HasPrefix(got, long[:23]+"~") {
+		t.Errorf("http_url = %q, want the default limit", got)
+	}
+	if m.Tags["http_path"] != long || m.Tags["db_statement"] != long || m.Tags["short"] != "ok" {
+		t.Errorf("the values within their limits should be kept, got %+v", m.Tags)
+	}
+
+	// the same value is truncated the same way, another one with the same prefix is distinguished
+	other := &metric.Metric{Measurement: "application_http", Tags: map[string]string{"http_url": long + "b"}}
+	again := &metric.Metric{Measurement: "application_http", Tags: map[string]string{"http_url": long}}
+	l.Apply(other)
+	l.Apply(again)
+	if again.Tags["http_url"] != m.Tags["http_url"] || other.Tags["http_url"] == m.Tags["http_url"] {
+		t.Errorf("unexpected truncations %q, %q, %q", m.Tags["http_url"], again.Tags["http_url"], other.Tags["http_url"])
+	}
+	// truncated values are within the limit, applying again keeps them
+	truncated := m.Tags["http_url"]
+	l.Apply(m)
+	if m.Tags["http_url"] != truncated {
+		t.Errorf("truncated values should not change, got %q", m.Tags["http_url"])
+	}
+
+	ms := l.Metrics(0)
+	if len(ms) != 1 || ms[0].Tags["tag"] != "http_url" || ms[0].Fields["count"] != uint64(3) {
+		t.Fatalf("unexpected truncation metrics %+v", ms)
+	}
+	if len(l.Metrics(0)) != 0 {
+		t.Errorf("the counts should be reset")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	v := strings.Repeat("数据", 10)
+	got := truncate(v, 20)
+	if len(got) > 20 || !utf8.ValidString(got) {
+		t.Errorf("truncate() = %q, want valid utf-8 within the limit", got)
+	}
+	if got := truncate(v, 4); len(got) != 4 {
+		t.Errorf("truncate() = %q, want the end of the hash", got)
+	}
+}
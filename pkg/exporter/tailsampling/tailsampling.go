@@ -0,0 +1,282 @@
+// Package tailsampling holds the L7 request metrics for a short window before they are exported and keeps
+// the full detail of the interesting ones only: the requests ending in an error and the requests
+// above a latency percentile of their endpoint. The other requests of the window are folded into one
+// aggregate per endpoint, so that the collector receives the outliers with their full tags and the bulk
+// of the fast successful requests as counts.
+//
+//	TAIL_SAMPLING_ENABLED=false       the requests are exported one by one unless it is set
+//	TAIL_SAMPLING_WINDOW=10s          the window the requests of an endpoint are held for
+//	TAIL_SAMPLING_PERCENTILE=0.99     the latency percentile of an endpoint the slow requests are above
+//	TAIL_SAMPLING_MAX_BUFFERED=100000 requests held at most, the requests beyond it are exported one by one
+//
+// An endpoint with too few requests in the window for the percentile (fewer than 100 at 0.99) keeps its
+// slowest request when it is above the median, an endpoint with fewer than 3 requests keeps none.
+//
+// The endpoint of a request is its measurement, the source and target pods, the span kind, the status
+// and the operation (http method and path, rpc target, db statement, ...). The kept requests are tagged
+// tail_sampled=error or tail_sampled=slow. An aggregate has the measurement of its requests, the tags
+// they share, tail_sampled=aggregate and the fields elapsed_count, elapsed_sum, elapsed_min, elapsed_max,
+// elapsed_mean. When the requests were sampled before, the aggregate carries the share of the requests
+// they stand for in sample_rate. Aggregates are not reported as spans.
+//
+// The controller publishes the requests on the event bus before they are held, the plugins deriving
+// metrics from the requests see all of them.
+package tailsampling
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	tag          = "tail_sampled"
+	tagAggregate = "aggregate"
+	tagError     = "error"
+	tagSlow      = "slow"
+
+	// minSamples is the number of requests of an endpoint below which none of them is slow, they are
+	// too few to tell an outlier.
+	minSamples = 3
+)
+
+type Config struct {
+	Enabled     bool          `env:"TAIL_SAMPLING_ENABLED" default:"false"`
+	Window      time.Duration `env:"TAIL_SAMPLING_WINDOW" default:"10s"`
+	Percentile  float64       `env:"TAIL_SAMPLING_PERCENTILE" default:"0.99"`
+	MaxBuffered int           `env:"TAIL_SAMPLING_MAX_BUFFERED" default:"100000"`
+}
+
+// requestMeasurements are the L7 measurements of single requests.
+var requestMeasurements = map[string]bool{
+	"application_http":        true,
+	"application_http_error":  true,
+	"application_rpc":         true,
+	"application_rpc_error":   true,
+	"application_db":          true,
+	"application_db_error":    true,
+	"application_cache":       true,
+	"application_cache_error": true,
+	"application_mq":          true,
+}
+
+// endpointTags identify the endpoint of a request.
+var endpointTags = []string{
+	"source_service_instance_id",
+	"target_service_instance_id",
+	"peer_address",
+	"span_kind",
+	"error",
+	"http_status_code",
+	"grpc_status_code",
+	"http_method",
+	"http_path",
+	"rpc_target",
+	"db_statement",
+	"message_bus_destination",
+	"graphql_operation_name",
+}
+
+// endpoint are the requests of an endpoint held since start (unix nano).
+type endpoint struct {
+	start    int64
+	requests []*metric.Metric
+}
+
+// Buffer holds the requests of the endpoints until their window is over.
+type Buffer struct {
+	sync.Mutex
+	cfg       Config
+	endpoints map[string]*endpoint
+	buffered  int
+}
+
+// New returns the buffer of the requests, nil unless TAIL_SAMPLING_ENABLED is set.
+func New() *Buffer {
+	var cfg Config
+	envconf.MustLoad(&cfg)
+	if !cfg.Enabled {
+		return nil
+	}
+	return newBuffer(cfg)
+}
+
+func newBuffer(cfg Config) *Buffer {
+	if cfg.Percentile <= 0 || cfg.Percentile > 1 {
+		cfg.Percentile = 0.99
+	}
+	return &Buffer{cfg: cfg, endpoints: make(map[string]*endpoint)}
+}
+
+// IsAggregate reports whether m is the aggregate of the requests of an endpoint.
+func IsAggregate(m *metric.Metric) bool {
+	return m.Tags[tag] == tagAggregate
+}
+
+// Add holds the request m, it returns false if m is not a request or the buffer is full, m is then
+// exported as is.
+func (b *Buffer) Add(m *metric.Metric, now int64) bool {
+	if !requestMeasurements[m.Measurement] {
+		return false
+	}
+	var k strings.Builder
+	k.WriteString(m.Measurement)
+	for _, name := range endpointTags {
+		k.WriteByte(0)
+		k.WriteString(m.Tags[name])
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.buffered >= b.cfg.MaxBuffered {
+		return false
+	}
+	e, ok := b.endpoints[k.String()]
+	if !ok {
+		e = &endpoint{start: now}
+		b.endpoints[k.String()] = e
+	}
+	e.requests = append(e.requests, m)
+	b.buffered++
+	return true
+}
+
+// Release returns the requests of the endpoints whose window is over at now, the errors and the slow
+// requests one by one and an aggregate of the others.
+func (b *Buffer) Release(now int64) []*metric.Metric {
+	b.Lock()
+	var released []*endpoint
+	for k, e := range b.endpoints {
+		if now-e.start >= b.cfg.Window.Nanoseconds() {
+			released = append(released, e)
+			b.buffered -= len(e.requests)
+			delete(b.endpoints, k)
+		}
+	}
+	b.Unlock()
+
+	var ans []*metric.Metric
+	for _, e := range released {
+		ans = append(ans, b.sample(e.requests)...)
+	}
+	return ans
+}
+
+// sample keeps the errors and the requests above the percentile, the others are folded into an aggregate.
+func (b *Buffer) sample(requests []*metric.Metric) []*metric.Metric {
+	elapsed := make([]int64, len(requests))
+	for i, m := range requests {
+		elapsed[i] = toInt64(m.Fields["elapsed_sum"])
+	}
+	threshold := b.threshold(elapsed)
+
+	var ans, folded []*metric.Metric
+	var foldedElapsed []int64
+	for i, m := range requests {
+		switch {
+		case m.Tags["error"] == "true":
+			m.Tags[tag] = tagError
+			ans = append(ans, m)
+		case elapsed[i] >= threshold:
+			m.Tags[tag] = tagSlow
+			ans = append(ans, m)
+		default:
+			folded = append(folded, m)
+			foldedElapsed = append(foldedElapsed, elapsed[i])
+		}
+	}
+	if len(folded) > 0 {
+		ans = append(ans, aggregate(folded, foldedElapsed))
+	}
+	return ans
+}
+
+// threshold returns the latency the slow requests are at or above: the first one above the percentile,
+// the slowest request of the small windows (fewer than 1/(1-percentile) requests). It is above the
+// median, so that the windows of equal requests have no slow request, and none is slow below minSamples.
+func (b *Buffer) threshold(elapsed []int64) int64 {
+	if len(elapsed) < minSamples {
+		return math.MaxInt64
+	}
+	sorted := append([]int64(nil), elapsed...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(b.cfg.Percentile * float64(len(sorted))))
+	if i > len(sorted)-1 {
+		i = len(sorted) - 1
+	}
+	if sorted[i] <= sorted[len(sorted)/2] {
+		return math.MaxInt64
+	}
+	return sorted[i]
+}
+
+// aggregate folds the requests into a metric with the tags they share.
+func aggregate(requests []*metric.Metric, elapsed []int64) *metric.Metric {
+	first := requests[0]
+	tags := make(map[string]string, len(first.Tags)+1)
+	for k, v := range first.Tags {
+		tags[k] = v
+	}
+	var sum, max, min int64
+	var weight float64
+	min = elapsed[0]
+	for i, m := range requests {
+		for k, v := range tags {
+			if m.Tags[k] != v {
+				delete(tags, k)
+			}
+		}
+		sum += elapsed[i]
+		if elapsed[i] > max {
+			max = elapsed[i]
+		}
+		if elapsed[i] < min {
+			min = elapsed[i]
+		}
+		if rate, ok := m.Fields["sample_rate"].(float64); ok && rate > 0 {
+			weight += 1 / rate
+		} else {
+			weight++
+		}
+	}
+	tags[tag] = tagAggregate
+	count := len(requests)
+	fields := map[string]interface{}{
+		"elapsed_count": count,
+		"elapsed_sum":   sum,
+		"elapsed_max":   max,
+		"elapsed_min":   min,
+		"elapsed_mean":  sum / int64(count),
+	}
+	if share := float64(count) / weight; share < 1 {
+		fields["sample_rate"] = share
+	}
+	return &metric.Metric{
+		Name:        first.Name,
+		Measurement: first.Measurement,
+		Timestamp:   requests[len(requests)-1].Timestamp,
+		OrgName:     first.OrgName,
+		Tags:        tags,
+		Fields:      fields,
+	}
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
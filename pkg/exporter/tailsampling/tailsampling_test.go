@@ -0,0 +1,137 @@
+package tailsampling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func request(ts int64, elapsed uint64, isError bool, url string) *metric.Metric {
+	return &metric.Metric{
+		Name:        "application_http",
+		Measurement: "application_http",
+		Timestamp:   ts,
+		Tags: map[string]string{
+			"target_service_instance_id": "api-1",
+			"http_method":                "GET",
+			"http_path":                  "/orders/{id}",
+			"http_url":                   url,
+			"error":                      map[bool]string{true: "true", false: "false"}[isError],
+		},
+		Fields: map[string]interface{}{"elapsed_sum": elapsed},
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := newBuffer(Config{Window: 10 * time.Second, Percentile: 0.9, MaxBuffered: 100})
+	second := time.Second.Nanoseconds()
+	for i := 0; i < 9; i++ {
+		if !b.Add(request(int64(i)*second, uint64(100+i), false, "http://api/orders/"+string(rune('a'+i))), int64(i)*second) {
+			t.Fatal("requests are held")
+		}
+	}
+	b.Add(request(9*second, 5000, false, "http://api/orders/slow"), 9*second)
+	failed := request(9*second, 50, true, "http://api/orders/failed")
+	b.Add(failed, 9*second)
+	if b.Add(&metric.Metric{Measurement: "ebpf_plugin_startup"}, 9*second) {
+		t.Error("only requests are held")
+	}
+
+	if released := b.Release(9 * second); len(released) != 0 {
+		t.Errorf("the window is not over, got %v", released)
+	}
+	released := b.Release(10 * second)
+	if len(released) != 2 {
+		t.Fatalf("expected the slow request and the aggregate, got %v", released)
+	}
+	var aggregated *metric.Metric
+	for _, m := range released {
+		switch m.Tags[tag] {
+		case tagSlow:
+			if m.Tags["http_url"] != "http://api/orders/slow" {
+				t.Errorf("unexpected slow request %+v", m)
+			}
+		case tagAggregate:
+			aggregated = m
+		default:
+			t.Errorf("unexpected request %+v", m)
+		}
+	}
+	if aggregated == nil || !IsAggregate(aggregated) {
+		t.Fatal("expected an aggregate")
+	}
+	if aggregated.Fields["elapsed_count"] != 9 || aggregated.Fields["elapsed_sum"] != int64(936) ||
+		aggregated.Fields["elapsed_min"] != int64(100) || aggregated.Fields["elapsed_max"] != int64(108) ||
+		aggregated.Timestamp != 8*second {
+		t.Errorf("unexpected aggregate %+v", aggregated.Fields)
+	}
+	if _, ok := aggregated.Tags["http_url"]; ok || aggregated.Tags["http_path"] != "/orders/{id}" {
+		t.Errorf("the aggregate keeps the shared tags only, got %v", aggregated.Tags)
+	}
+	if _, ok := aggregated.Fields["sample_rate"]; ok {
+		t.Error("the requests were not sampled")
+	}
+
+	// the errors are an endpoint of their own
+	released = b.Release(19 * second)
+	if len(released) != 1 || released[0] != failed || failed.Tags[tag] != tagError {
+		t.Errorf("expected the failed request, got %v", released)
+	}
+}
+
+func TestBufferSampled(t *testing.T) {
+	b := newBuffer(Config{Window: time.Second, Percentile: 0.5, MaxBuffered: 3})
+	for i := 0; i < 4; i++ {
+		m := request(0, 100, false, "")
+		m.Fields["sample_rate"] = 0.5
+		if held := b.Add(m, 0); held != (i < 3) {
+			t.Errorf("request %d: held %v, at most 3 requests are held", i, held)
+		}
+	}
+	released := b.Release(time.Second.Nanoseconds())
+	if len(released) != 1 || released[0].Fields["elapsed_count"] != 3 {
+		t.Fatalf("equal requests are not above the percentile, got %v", released)
+	}
+	b = newBuffer(Config{Window: time.Second, Percentile: 0.5, MaxBuffered: 10})
+	for _, elapsed := range []uint64{100, 100, 300} {
+		m := request(0, elapsed, false, "")
+		m.Fields["sample_rate"] = 0.5
+		b.Add(m, 0)
+	}
+	for _, m := range b.Release(time.Second.Nanoseconds()) {
+		if IsAggregate(m) && (m.Fields["elapsed_count"] != 2 || m.Fields["sample_rate"] != 0.5) {
+			t.Errorf("the aggregate stands for the sampled requests, got %+v", m.Fields)
+		}
+	}
+}
+
+func TestBufferSmallWindow(t *testing.T) {
+	second := time.Second.Nanoseconds()
+	for _, c := range []struct {
+		name    string
+		elapsed []uint64
+		slow    []uint64
+	}{
+		{"one outlier", []uint64{100, 110, 105, 98, 2000, 102, 101, 99}, []uint64{2000}},
+		{"equal requests", []uint64{100, 100, 100, 100, 100}, nil},
+		{"too few requests", []uint64{100, 2000}, nil},
+	} {
+		b := newBuffer(Config{Window: time.Second, Percentile: 0.99, MaxBuffered: 100})
+		for _, elapsed := range c.elapsed {
+			b.Add(request(0, elapsed, false, ""), 0)
+		}
+		var slow []uint64
+		for _, m := range b.Release(second) {
+			switch {
+			case m.Tags[tag] == tagSlow:
+				slow = append(slow, m.Fields["elapsed_sum"].(uint64))
+			case m.Fields["elapsed_count"] != len(c.elapsed)-len(c.slow):
+				t.Errorf("%s: unexpected aggregate %+v", c.name, m.Fields)
+			}
+		}
+		if len(slow) != len(c.slow) || len(slow) == 1 && slow[0] != c.slow[0] {
+			t.Errorf("%s: expected the slow requests %v, got %v", c.name, c.slow, slow)
+		}
+	}
+}
@@ -838,6 +838,26 @@ func (decoder *Decoder) Decode(block []byte) ([]Header, error) {
 	return headers, nil
 }
 
+// DecodePartial works like Decode but returns the headers parsed before an error
+// occurred, which is useful for header blocks truncated by a fixed size capture buffer.
+func (decoder *Decoder) DecodePartial(block []byte) ([]Header, error) {
+	headers := make([]Header, 0)
+	buf := block
+	for len(buf) > 0 {
+		var header *Header
+		var err error
+
+		buf, header, err = decoder.parseHeaderField(buf)
+		if err != nil {
+			return headers, err
+		}
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	return headers, nil
+}
+
 // Returns true if there is enough space to accomadate additionalSize
 func (encoder *Encoder) evictEntries(additionalSize int, maxSize int) bool {
 	for encoder.dynamicTableSizeCurrent+additionalSize > maxSize {
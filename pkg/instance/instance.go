@@ -0,0 +1,243 @@
+// Package instance gives the agent of a node a stable identity, so that fleet tooling can follow the
+// agents over their restarts and upgrades. The identity is persisted in AGENT_STATE_DIR, a hostPath
+// volume of the daemonset, and survives the restarts and the rollouts of the agent pod:
+//
+//	instance_id    random id created on the first start of the agent on the node
+//	starts         number of starts of the agent since the id was created
+//	config_hash    hash of the plugins and the environment of the agent, it changes with the settings
+//
+// The controller stamps the id as agent_instance_id on the self metrics (ebpf_*) and reports the
+// identity every minute as ebpf_agent_instance:
+//
+//	tags:   host, agent_instance_id, agent_version, config_hash
+//	fields: restarts                  starts of the agent on the node before this one
+//	        started                   unix nano time of the start
+//	        uptime                    seconds since the start
+//	        config_changed            the config hash differs from the hash of the previous start
+//	        lease_renew_errors        failed lease renewals since the previous report
+//
+// The identity is registered as the coordination.k8s.io Lease ebpf-agent-<node> in POD_NAMESPACE as well,
+// see lease.go. If the state dir can not be written, e.g. without the volume, the id only lives as
+// long as the process and restarts are not counted.
+package instance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/schema"
+)
+
+const (
+	measurement = "ebpf_agent_instance"
+	stateFile   = "instance.json"
+	// TagInstanceID is the tag of the instance id on the self metrics.
+	TagInstanceID = "agent_instance_id"
+	// selfMetricPrefix is the measurement prefix of the metrics about the agent itself.
+	selfMetricPrefix = "ebpf_"
+)
+
+type Config struct {
+	StateDir      string        `env:"AGENT_STATE_DIR" default:"/var/lib/ebpf-agent"`
+	LeaseEnabled  bool          `env:"AGENT_LEASE_ENABLED" default:"true"`
+	LeaseDuration time.Duration `env:"AGENT_LEASE_DURATION" default:"1m"`
+}
+
+// state is the identity persisted in the state dir.
+type state struct {
+	ID         string `json:"id"`
+	Created    int64  `json:"created"`
+	Starts     uint64 `json:"starts"`
+	Version    string `json:"version"`
+	ConfigHash string `json:"config_hash"`
+}
+
+type Instance struct {
+	sync.Mutex
+	clock         clock.Clock
+	host          string
+	version       string
+	id            string
+	starts        uint64
+	started       time.Time
+	configHash    string
+	configChanged bool
+	renewErrors   uint64
+}
+
+// Load reads the identity persisted in dir, creates it on the first start, and counts the start.
+// The instance is returned even if the state can not be read or written, with a new id then.
+func Load(dir, host, version, configHash string, clk clock.Clock) (*Instance, error) {
+	i := &Instance{
+		clock:      clk,
+		host:       host,
+		version:    version,
+		started:    clk.Now(),
+		configHash: configHash,
+	}
+	path := filepath.Join(dir, stateFile)
+	var s state
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &s); err != nil {
+			klog.Warningf("invalid agent instance state %s, creating a new identity: %v", path, err)
+			s = state{}
+		}
+	case !os.IsNotExist(err):
+		i.id, i.starts = newID(), 1
+		return i, err
+	}
+	if s.ID == "" {
+		s = state{ID: newID(), Created: i.started.UnixNano()}
+	}
+	i.configChanged = s.ConfigHash != "" && s.ConfigHash != configHash
+	s.Starts++
+	s.Version = version
+	s.ConfigHash = configHash
+	i.id = s.ID
+	i.starts = s.Starts
+	if data, err = json.Marshal(s); err != nil {
+		return i, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return i, err
+	}
+	// write the new state next to the old one, a crash never leaves a partial state behind.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return i, err
+	}
+	return i, os.Rename(tmp, path)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ID returns the instance id.
+func (i *Instance) ID() string {
+	return i.id
+}
+
+// Restarts returns the starts of the agent on the node before this one.
+func (i *Instance) Restarts() uint64 {
+	return i.starts - 1
+}
+
+// Stamp adds the instance id to m if it is a self metric.
+func (i *Instance) Stamp(m *metric.Metric) {
+	if m != nil && strings.HasPrefix(m.Measurement, selfMetricPrefix) {
+		m.AddTags(TagInstanceID, i.id)
+	}
+}
+
+// renewFailed counts a failed lease renewal.
+func (i *Instance) renewFailed() {
+	i.Lock()
+	i.renewErrors++
+	i.Unlock()
+}
+
+// Metrics returns the identity record of the agent.
+func (i *Instance) Metrics(timestamp int64) []*metric.Metric {
+	i.Lock()
+	renewErrors := i.renewErrors
+	i.renewErrors = 0
+	i.Unlock()
+	return []*metric.Metric{{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   timestamp,
+		Tags: map[string]string{
+			"host":          i.host,
+			TagInstanceID:   i.id,
+			"agent_version": i.version,
+			"config_hash":   i.configHash,
+		},
+		Fields: map[string]interface{}{
+			"restarts":           i.Restarts(),
+			"started":            i.started.UnixNano(),
+			"uptime":             int64(i.clock.Since(i.started).Seconds()),
+			"config_changed":     i.configChanged,
+			"lease_renew_errors": renewErrors,
+		},
+	}}
+}
+
+// nodeEnvs are the environment variables that differ between the agents of a deployment.
+var nodeEnvs = map[string]bool{
+	"NODE_NAME": true,
+	"HOSTNAME":  true,
+	"POD_NAME":  true,
+	"POD_IP":    true,
+	"HOME":      true,
+	"PATH":      true,
+	"PWD":       true,
+	"SHLVL":     true,
+}
+
+// ConfigHash hashes the plugins and the environment of the agent. The variables of the node and the
+// service links kubernetes adds to the containers are left out, they change without a change of the
+// settings.
+func ConfigHash(plugins []string, environ []string) string {
+	envs := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if nodeEnvs[k] || strings.HasPrefix(k, "KUBERNETES_") || isServiceLink(k, v) {
+			continue
+		}
+		envs = append(envs, kv)
+	}
+	sort.Strings(envs)
+	h := fnv.New64a()
+	fmt.Fprintf(h, "plugins=%s;", strings.Join(plugins, ","))
+	for _, kv := range envs {
+		fmt.Fprintf(h, "%s;", kv)
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+var serviceLinkPort = regexp.MustCompile(`_PORT_[0-9]+_(TCP|UDP|SCTP)`)
+
+// isServiceLink reports whether k is one of the docker link variables of a service,
+// e.g. REDIS_SERVICE_HOST, REDIS_PORT=tcp://10.0.0.1:6379 or REDIS_PORT_6379_TCP_ADDR.
+func isServiceLink(k, v string) bool {
+	return strings.Contains(k, "_SERVICE_HOST") || strings.Contains(k, "_SERVICE_PORT") ||
+		(strings.HasSuffix(k, "_PORT") && strings.Contains(v, "://")) || serviceLinkPort.MatchString(k)
+}
+
+var (
+	defaultInstance *Instance
+	defaultOnce     sync.Once
+)
+
+// Start loads the identity of the agent with the plugins of the controller, it is only loaded once.
+func Start(cfg Config, plugins []string) *Instance {
+	defaultOnce.Do(func() {
+		i, err := Load(cfg.StateDir, os.Getenv("NODE_NAME"), schema.AgentVersion(), ConfigHash(plugins, os.Environ()), clock.Real)
+		if err != nil {
+			klog.Warningf("failed to persist the agent instance state in %s, restarts are not tracked: %v", cfg.StateDir, err)
+		}
+		defaultInstance = i
+	})
+	return defaultInstance
+}
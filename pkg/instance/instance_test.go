@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+func TestLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	clk := clock.NewFake(time.Unix(1000, 0))
+
+	first, err := Load(dir, "node-1", "v1", "a", clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID() == "" || first.Restarts() != 0 {
+		t.Fatalf("unexpected first start %s %d", first.ID(), first.Restarts())
+	}
+	second, err := Load(dir, "node-1", "v2", "a", clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID() != first.ID() || second.Restarts() != 1 || second.configChanged {
+		t.Errorf("the identity should survive the restart, got %s %d", second.ID(), second.Restarts())
+	}
+	third, _ := Load(dir, "node-1", "v2", "b", clk)
+	if !third.configChanged || third.Restarts() != 2 {
+		t.Errorf("the config change should be detected")
+	}
+
+	// a wiped state dir gives the node a new identity
+	os.RemoveAll(dir)
+	wiped, _ := Load(dir, "node-1", "v2", "b", clk)
+	if wiped.ID() == first.ID() || wiped.Restarts() != 0 {
+		t.Errorf("unexpected identity after the wipe %s %d", wiped.ID(), wiped.Restarts())
+	}
+}
+
+func TestLoadUnwritable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+	i, err := Load(filepath.Join(file, "state"), "node-1", "v1", "a", clock.Real)
+	if err == nil {
+		t.Errorf("the state should not be writable")
+	}
+	if i == nil || i.ID() == "" {
+		t.Errorf("the instance should get an id anyway")
+	}
+}
+
+func TestStampAndMetrics(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	i, err := Load(t.TempDir(), "node-1", "v1", "a", clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := &metric.Metric{Measurement: "ebpf_event_bus"}
+	app := &metric.Metric{Measurement: "application_http"}
+	i.Stamp(self)
+	i.Stamp(app)
+	if self.Tags[TagInstanceID] != i.ID() || app.Tags != nil {
+		t.Errorf("only self metrics should be stamped %v %v", self.Tags, app.Tags)
+	}
+
+	clk.Add(90 * time.Second)
+	i.renewFailed()
+	ms := i.Metrics(0)
+	if len(ms) != 1 || ms[0].Fields["uptime"] != int64(90) || ms[0].Fields["lease_renew_errors"] != uint64(1) {
+		t.Fatalf("unexpected instance metrics %v", ms)
+	}
+	if ms[0].Tags["agent_version"] != "v1" || ms[0].Tags["config_hash"] != "a" {
+		t.Errorf("unexpected tags %v", ms[0].Tags)
+	}
+	if i.Metrics(0)[0].Fields["lease_renew_errors"] != uint64(0) {
+		t.Errorf("the renew errors should be reset")
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	plugins := []string{"http", "grpc"}
+	base := ConfigHash(plugins, []string{"L7_SLOW_THRESHOLD=1s", "NODE_NAME=node-1"})
+	if h := ConfigHash(plugins, []string{"NODE_NAME=node-2", "HOSTNAME=agent-x", "L7_SLOW_THRESHOLD=1s",
+		"REDIS_SERVICE_HOST=10.0.0.1", "REDIS_PORT=tcp://10.0.0.1:6379", "REDIS_PORT_6379_TCP_ADDR=10.0.0.1",
+		"KUBERNETES_SERVICE_HOST=10.0.0.2"}); h != base {
+		t.Errorf("the node and service link variables should be ignored")
+	}
+	if ConfigHash(plugins, []string{"L7_SLOW_THRESHOLD=2s"}) == base {
+		t.Errorf("a changed setting should change the hash")
+	}
+	if ConfigHash(plugins, []string{"L7_SLOW_THRESHOLD=1s", "COLLECTOR_PORT=7076"}) == base {
+		t.Errorf("settings named *_PORT should be hashed")
+	}
+	if ConfigHash([]string{"http"}, []string{"L7_SLOW_THRESHOLD=1s"}) == base {
+		t.Errorf("the plugins should change the hash")
+	}
+}
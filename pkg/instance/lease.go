@@ -0,0 +1,94 @@
+package instance
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	leasePrefix = "ebpf-agent-"
+
+	annotationVersion    = "ebpf-agent.erda.cloud/version"
+	annotationConfigHash = "ebpf-agent.erda.cloud/config-hash"
+	annotationRestarts   = "ebpf-agent.erda.cloud/restarts"
+	labelNode            = "ebpf-agent.erda.cloud/node"
+)
+
+// RunLease registers the instance as the Lease ebpf-agent-<node> of namespace and renews it every
+// third of its duration until ctx is done. The holder of the lease is the instance id, its acquire
+// time the start of the agent, and the version, config hash and restarts are kept as annotations.
+// The transitions of the lease count the changes of the instance id on the node, e.g. after the
+// state dir was wiped.
+func (i *Instance) RunLease(ctx context.Context, client kubernetes.Interface, namespace string, duration time.Duration) {
+	ticker := i.clock.NewTicker(duration / 3)
+	defer ticker.Stop()
+	for {
+		if err := i.renew(ctx, client, namespace, duration); err != nil {
+			i.renewFailed()
+			klog.Warningf("failed to renew the lease of the agent instance %s: %v", i.id, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (i *Instance) renew(ctx context.Context, client kubernetes.Interface, namespace string, duration time.Duration) error {
+	leases := client.CoordinationV1().Leases(namespace)
+	name := leasePrefix + i.host
+	now := metav1.NewMicroTime(i.clock.Now())
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		i.updateLease(lease, now, duration)
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	i.updateLease(lease, now, duration)
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+func (i *Instance) updateLease(lease *coordinationv1.Lease, now metav1.MicroTime, duration time.Duration) {
+	spec := &lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity != i.id {
+		if spec.HolderIdentity != nil {
+			transitions := int32(1)
+			if spec.LeaseTransitions != nil {
+				transitions += *spec.LeaseTransitions
+			}
+			spec.LeaseTransitions = &transitions
+		}
+		id := i.id
+		spec.HolderIdentity = &id
+	}
+	started := metav1.NewMicroTime(i.started)
+	seconds := int32(duration.Seconds())
+	spec.AcquireTime = &started
+	spec.RenewTime = &now
+	spec.LeaseDurationSeconds = &seconds
+
+	if lease.Labels == nil {
+		lease.Labels = make(map[string]string)
+	}
+	lease.Labels["app"] = "ebpf-agent"
+	lease.Labels[labelNode] = i.host
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[annotationVersion] = i.version
+	lease.Annotations[annotationConfigHash] = i.configHash
+	lease.Annotations[annotationRestarts] = strconv.FormatUint(i.Restarts(), 10)
+}
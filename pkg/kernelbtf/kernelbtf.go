@@ -0,0 +1,200 @@
+// Package kernelbtf provides the kernel type information (BTF) the CO-RE programs (netfilter, oom) are
+// relocated against. Kernels without /sys/kernel/btf/vmlinux, or nodes upgraded to a kernel the image
+// was not built for, are served from a file mounted from the host or a ConfigMap, or from a download:
+//
+//	KERNEL_BTF_PATH=/etc/ebpf-agent/btf                        a BTF file, or a directory of <release>.btf files
+//	KERNEL_BTF_URL=https://mirror/btf/{arch}/{release}.btf     {release} and {arch} are the uname of the node
+//	KERNEL_BTF_RETRY_INTERVAL=5m                               delay before a failed download is retried
+//
+// The sources are tried in this order, the BTF of the running kernel is used if none is configured or
+// none has the types of the node. The file is checked again at every load and read again once it
+// changed, updating the ConfigMap takes effect without rolling a new agent image.
+package kernelbtf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+type Config struct {
+	Path          string        `env:"KERNEL_BTF_PATH"`
+	URL           string        `env:"KERNEL_BTF_URL"`
+	RetryInterval time.Duration `env:"KERNEL_BTF_RETRY_INTERVAL" default:"5m"`
+}
+
+// downloadLimit bounds the size of a downloaded BTF, the BTF of a kernel is a few MB.
+const downloadLimit = 64 << 20
+
+// file identifies a version of a BTF file.
+type file struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type Source struct {
+	cfg     Config
+	release string
+	arch    string
+	client  *http.Client
+	now     func() time.Time
+
+	mu   sync.Mutex
+	file file
+	// spec is the BTF of file, or the downloaded BTF if file is not set.
+	spec *btf.Spec
+	// origin describes where spec was read from.
+	origin string
+	// retryAt is the earliest time a failed download is retried.
+	retryAt time.Time
+}
+
+func NewSource(cfg Config, release, arch string) *Source {
+	return &Source{
+		cfg:     cfg,
+		release: release,
+		arch:    arch,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		now:     time.Now,
+	}
+}
+
+var (
+	defaultSource *Source
+	sourceOnce    sync.Once
+)
+
+func source() *Source {
+	sourceOnce.Do(func() {
+		var cfg Config
+		envconf.MustLoad(&cfg)
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			klog.Errorf("failed to get the kernel release: %v", err)
+		}
+		defaultSource = NewSource(cfg, unix.ByteSliceToString(uts.Release[:]), unix.ByteSliceToString(uts.Machine[:]))
+	})
+	return defaultSource
+}
+
+// CollectionOptions returns opts with the kernel types of the node, see Source.KernelTypes.
+func CollectionOptions(opts ebpf.CollectionOptions) ebpf.CollectionOptions {
+	opts.Programs.KernelTypes = source().KernelTypes()
+	return opts
+}
+
+// Origin returns where the kernel types of the node are read from, "kernel" for the BTF of the running kernel.
+func Origin() string {
+	return source().Origin()
+}
+
+// KernelTypes returns the configured BTF of the node, nil if the BTF of the running kernel is used.
+func (s *Source) KernelTypes() *btf.Spec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.Path != "" {
+		spec, err := s.loadFile()
+		if err == nil {
+			return spec
+		}
+		klog.Warningf("failed to load btf from %s: %v", s.cfg.Path, err)
+	}
+	if s.cfg.URL != "" {
+		spec, err := s.download()
+		if err == nil {
+			return spec
+		}
+		klog.Warningf("failed to download btf: %v", err)
+	}
+	s.file, s.spec, s.origin = file{}, nil, ""
+	return nil
+}
+
+// Origin returns where the last kernel types were read from.
+func (s *Source) Origin() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.origin == "" {
+		return "kernel"
+	}
+	return s.origin
+}
+
+// loadFile returns the BTF of the configured path, it is read again if the file changed since the previous load.
+func (s *Source) loadFile() (*btf.Spec, error) {
+	path := s.cfg.Path
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, s.release+".btf")
+		// ConfigMap keys are written through symlinks, Stat follows them to the current version.
+		if info, err = os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	current := file{path: path, size: info.Size(), modTime: info.ModTime()}
+	if s.spec != nil && s.file == current {
+		return s.spec, nil
+	}
+	spec, err := btf.LoadSpec(path)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("loaded btf of kernel %s from %s", s.release, path)
+	s.file, s.spec, s.origin = current, spec, path
+	return spec, nil
+}
+
+// download returns the BTF of the configured URL, it is downloaded once and again only after a failure.
+func (s *Source) download() (*btf.Spec, error) {
+	if s.spec != nil && s.file.path == "" {
+		return s.spec, nil
+	}
+	if now := s.now(); now.Before(s.retryAt) {
+		return nil, fmt.Errorf("retrying at %s", s.retryAt.Format(time.RFC3339))
+	}
+	url := strings.NewReplacer("{release}", s.release, "{arch}", s.arch).Replace(s.cfg.URL)
+	spec, err := s.fetch(url)
+	if err != nil {
+		s.retryAt = s.now().Add(s.cfg.RetryInterval)
+		return nil, err
+	}
+	klog.Infof("downloaded btf of kernel %s from %s", s.release, url)
+	s.file, s.spec, s.origin = file{}, spec, url
+	return spec, nil
+}
+
+func (s *Source) fetch(url string) (*btf.Spec, error) {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, downloadLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > downloadLimit {
+		return nil, fmt.Errorf("get %s: btf larger than %d bytes", url, downloadLimit)
+	}
+	return btf.LoadSpecFromReader(bytes.NewReader(data))
+}
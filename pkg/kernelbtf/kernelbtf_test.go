@@ -0,0 +1,101 @@
+package kernelbtf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// marshal returns the BTF of a single int type with the given name.
+func marshal(t *testing.T, name string) []byte {
+	b, err := btf.NewBuilder([]btf.Type{&btf.Int{Name: name, Size: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func hasType(spec *btf.Spec, name string) bool {
+	var typ *btf.Int
+	return spec != nil && spec.TypeByName(name, &typ) == nil
+}
+
+func TestKernelTypesPath(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSource(Config{Path: dir}, "5.15.0-87-generic", "x86_64")
+	if spec := s.KernelTypes(); spec != nil || s.Origin() != "kernel" {
+		t.Fatalf("missing file: got %v from %s, want the kernel btf", spec, s.Origin())
+	}
+
+	path := filepath.Join(dir, "5.15.0-87-generic.btf")
+	if err := os.WriteFile(path, marshal(t, "before"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec := s.KernelTypes()
+	if !hasType(spec, "before") || s.Origin() != path {
+		t.Fatalf("got %v from %s, want the types of %s", spec, s.Origin(), path)
+	}
+	if s.KernelTypes() != spec {
+		t.Errorf("unchanged file was read again")
+	}
+
+	// an updated ConfigMap replaces the file.
+	if err := os.WriteFile(path, marshal(t, "after_update"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if spec := s.KernelTypes(); !hasType(spec, "after_update") {
+		t.Errorf("updated file was not read again")
+	}
+}
+
+func TestKernelTypesURL(t *testing.T) {
+	var requests int
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail || r.URL.Path != "/x86_64/5.4.0-1.btf" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(marshal(t, "downloaded"))
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1000, 0)
+	s := NewSource(Config{
+		Path:          filepath.Join(t.TempDir(), "missing"),
+		URL:           srv.URL + "/{arch}/{release}.btf",
+		RetryInterval: time.Minute,
+	}, "5.4.0-1", "x86_64")
+	s.now = func() time.Time { return now }
+
+	if spec := s.KernelTypes(); spec != nil {
+		t.Fatalf("failed download: got %v, want the kernel btf", spec)
+	}
+	fail = false
+	if spec := s.KernelTypes(); spec != nil || requests != 1 {
+		t.Fatalf("download retried after %d requests before the retry interval", requests)
+	}
+	now = now.Add(time.Minute)
+	if spec := s.KernelTypes(); !hasType(spec, "downloaded") {
+		t.Fatalf("got %v, want the downloaded types", spec)
+	}
+	if s.KernelTypes(); requests != 2 {
+		t.Errorf("got %d requests, want the download to be kept", requests)
+	}
+	if want := srv.URL + "/x86_64/5.4.0-1.btf"; s.Origin() != want {
+		t.Errorf("got origin %s, want %s", s.Origin(), want)
+	}
+}
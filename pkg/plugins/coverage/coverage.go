@@ -0,0 +1,206 @@
+// Package coverage reports the observability blind spots of the node per namespace.
+//
+// Every EBPF_COVERAGE_INTERVAL (1m) the running pods of the node are checked for probes, a pod is
+// covered when the interface of its ip is attached by all the protocol plugins, see Attached. Pods are not
+// covered for the reasons:
+//
+//	host_network            the pod shares the network of the node, its traffic does not pass a veth and
+//	                        the host interfaces are not probed (EBPF_HOST_NETWORK)
+//	unsupported_cni         no interface of the node is probed for the ip of the pod, e.g. the ipvlan,
+//	                        macvlan and kube-ovn internal port pods unless EBPF_CNI is set
+//	quarantined_interface   a plugin failed to attach to the interface of the pod, see Quarantine, e.g.
+//	                        rpc in tc mode to the kube-ovn internal ports
+//
+// The report ebpf_coverage carries the namespace and the protocols detected for its pods since the
+// previous report, the counts of the pods, the covered pods and the pods with protocols detected,
+// the fraction of covered pods and the counts of the uncovered pods by reason (uncovered_<reason>).
+package coverage
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+)
+
+const measurement = "ebpf_coverage"
+
+// reasons of the pods without probes
+const (
+	ReasonHostNetwork    = "host_network"
+	ReasonUnsupportedCNI = "unsupported_cni"
+	ReasonQuarantined    = "quarantined_interface"
+)
+
+var reasons = []string{ReasonHostNetwork, ReasonUnsupportedCNI, ReasonQuarantined}
+
+// namespacedIndex is the index of the interfaces outside the namespace of the node in the interfaces of
+// the pods, the plugins quarantine the interfaces of the node only.
+const namespacedIndex = -1
+
+type Config struct {
+	Interval time.Duration `env:"EBPF_COVERAGE_INTERVAL" default:"1m"`
+}
+
+type provider struct {
+	Log logs.Logger
+
+	cfg          Config
+	node         string
+	kprobeHelper kprobe.Interface
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.node = os.Getenv("NODE_NAME")
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	// the pods of the node are only known once the caches are filled.
+	<-p.kprobeHelper.Synced()
+	ticker := p.clock.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		for _, m := range p.report(p.clock.Now().UnixNano()) {
+			c <- m
+		}
+	}
+}
+
+func (p *provider) report(timestamp int64) []*metric.Metric {
+	vethes, err := p.kprobeHelper.GetVethes()
+	if err != nil {
+		p.Log.Errorf("failed to get vethes, err: %v", err)
+		return nil
+	}
+	interfaces := make(map[string]int, len(vethes))
+	live := make(map[int]bool, len(vethes))
+	for _, v := range vethes {
+		interfaces[v.Neigh.IP.String()] = v.Link.Attrs().Index
+		live[v.Link.Attrs().Index] = true
+	}
+	// the ipvlan and macvlan pods share the parent interface.
+	for _, s := range p.kprobeHelper.GetSharedInterfaces() {
+		for _, ip := range s.Pods {
+			interfaces[ip.String()] = s.Link.Attrs().Index
+		}
+		live[s.Link.Attrs().Index] = true
+	}
+	// the kube-ovn pods with an OVS internal port, its index is the one of the namespace of the pod and
+	// never quarantined.
+	for _, n := range p.kprobeHelper.GetNamespacedInterfaces() {
+		interfaces[n.Neigh.IP.String()] = namespacedIndex
+	}
+	// the hostNetwork pods have the ip of the node.
+	for _, h := range p.kprobeHelper.GetHostInterfaces() {
+		interfaces[h.Neigh.IP.String()] = h.Link.Attrs().Index
+		live[h.Link.Attrs().Index] = true
+	}
+	protocols, quarantined := defaultRegistry.snapshot(live)
+	for ifIndex, plugins := range quarantined {
+		p.Log.Debugf("interface %d is quarantined, plugins: %v", ifIndex, plugins)
+	}
+	namespaces := summarize(p.kprobeHelper.GetLocalPods(), interfaces, defaultRegistry.covered(), quarantined, protocols)
+	ans := make([]*metric.Metric, 0, len(namespaces))
+	for namespace, n := range namespaces {
+		ans = append(ans, n.metric(timestamp, p.node, namespace))
+	}
+	return ans
+}
+
+type namespaceCoverage struct {
+	pods      int
+	covered   int
+	detected  int
+	uncovered map[string]int
+	protocols map[string]bool
+}
+
+// summarize checks the running pods for probes and groups them by namespace, interfaces are the
+// indexes of the veths by the ip of their pod and of the host interfaces by the ip of the node, covered
+// are the ips attached by all the plugins (nil if no plugin attaches).
+func summarize(pods []corev1.Pod, interfaces map[string]int, covered map[string]bool,
+	quarantined map[int]map[string]string, protocols map[string]map[string]bool) map[string]*namespaceCoverage {
+	ans := make(map[string]*namespaceCoverage)
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		n := ans[pod.Namespace]
+		if n == nil {
+			n = &namespaceCoverage{uncovered: make(map[string]int), protocols: make(map[string]bool)}
+			ans[pod.Namespace] = n
+		}
+		n.pods++
+		if detected := protocols[string(pod.UID)]; len(detected) > 0 {
+			n.detected++
+			for component := range detected {
+				n.protocols[component] = true
+			}
+		}
+		ifIndex, ok := interfaces[pod.Status.PodIP]
+		switch {
+		case pod.Spec.HostNetwork && !ok:
+			n.uncovered[ReasonHostNetwork]++
+		case !ok:
+			n.uncovered[ReasonUnsupportedCNI]++
+		case len(quarantined[ifIndex]) > 0, covered != nil && !covered[pod.Status.PodIP]:
+			n.uncovered[ReasonQuarantined]++
+		default:
+			n.covered++
+		}
+	}
+	return ans
+}
+
+func (n *namespaceCoverage) metric(timestamp int64, node, namespace string) *metric.Metric {
+	protocols := make([]string, 0, len(n.protocols))
+	for component := range n.protocols {
+		protocols = append(protocols, component)
+	}
+	sort.Strings(protocols)
+	m := &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   timestamp,
+		Tags: map[string]string{
+			"host":      node,
+			"namespace": namespace,
+			"protocols": strings.Join(protocols, ","),
+		},
+		Fields: map[string]interface{}{
+			"pod_count":          n.pods,
+			"covered_pod_count":  n.covered,
+			"detected_pod_count": n.detected,
+			"coverage_ratio":     float64(n.covered) / float64(n.pods),
+		},
+	}
+	for _, reason := range reasons {
+		m.Fields["uncovered_"+reason] = n.uncovered[reason]
+	}
+	return m
+}
+
+func init() {
+	servicehub.Register("coverage", &servicehub.Spec{
+		Services:     []string{"coverage"},
+		Description:  "observability coverage of the pods of the node",
+		Dependencies: []string{"kprobe"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
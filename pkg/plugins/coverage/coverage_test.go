@@ -0,0 +1,99 @@
+package coverage
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func pod(namespace, uid, ip string, hostNetwork bool, phase corev1.PodPhase) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, UID: types.UID(uid)},
+		Spec:       corev1.PodSpec{HostNetwork: hostNetwork},
+		Status:     corev1.PodStatus{Phase: phase, PodIP: ip},
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	r := newRegistry()
+	r.observe(&metric.Metric{Tags: map[string]string{
+		"component": "Http", "source_service_instance_id": "web-1", "target_service_instance_id": "api-1",
+	}})
+	r.observe(&metric.Metric{Tags: map[string]string{"component": "MySQL", "source_service_instance_id": "api-1"}})
+	r.observe(&metric.Metric{Tags: map[string]string{"source_service_instance_id": "web-2"}})
+	r.quarantine("mysql", 12, errors.New("map create: operation not permitted"))
+	r.quarantine("http", 99, errors.New("the veth is gone"))
+
+	protocols, quarantined := r.snapshot(map[int]bool{10: true, 11: true, 12: true})
+	if len(quarantined) != 1 || quarantined[12]["mysql"] == "" {
+		t.Errorf("unexpected quarantined interfaces %v", quarantined)
+	}
+	if _, ok := r.quarantined[99]; ok {
+		t.Errorf("interfaces that are gone should be forgotten")
+	}
+
+	pods := []corev1.Pod{
+		pod("shop", "web-1", "10.0.0.10", false, corev1.PodRunning),
+		pod("shop", "api-1", "10.0.0.11", false, corev1.PodRunning),
+		pod("shop", "worker-1", "10.0.0.12", false, corev1.PodRunning),
+		pod("shop", "job-1", "10.0.0.13", false, corev1.PodSucceeded),
+		pod("kube-system", "proxy-1", "192.168.0.1", true, corev1.PodRunning),
+		pod("kube-system", "cni-1", "10.0.1.5", false, corev1.PodRunning),
+		pod("ingress", "nginx-1", "192.168.0.2", true, corev1.PodRunning),
+	}
+	// the host interface of 192.168.0.2 is probed
+	interfaces := map[string]int{"10.0.0.10": 10, "10.0.0.11": 11, "10.0.0.12": 12, "192.168.0.2": 2}
+	namespaces := summarize(pods, interfaces, nil, quarantined, protocols)
+
+	shop := namespaces["shop"].metric(1, "node-1", "shop")
+	if shop.Tags["protocols"] != "Http,MySQL" || shop.Fields["pod_count"] != 3 || shop.Fields["covered_pod_count"] != 2 ||
+		shop.Fields["detected_pod_count"] != 2 || shop.Fields["uncovered_quarantined_interface"] != 1 ||
+		shop.Fields["coverage_ratio"] != 2.0/3 {
+		t.Errorf("unexpected coverage of shop %+v", shop)
+	}
+	system := namespaces["kube-system"].metric(1, "node-1", "kube-system")
+	if system.Tags["protocols"] != "" || system.Fields["covered_pod_count"] != 0 || system.Fields["coverage_ratio"] != 0.0 ||
+		system.Fields["uncovered_host_network"] != 1 || system.Fields["uncovered_unsupported_cni"] != 1 {
+		t.Errorf("unexpected coverage of kube-system %+v", system)
+	}
+	ingress := namespaces["ingress"].metric(1, "node-1", "ingress")
+	if ingress.Fields["covered_pod_count"] != 1 || ingress.Fields["uncovered_host_network"] != 0 {
+		t.Errorf("the hostNetwork pods should be covered by the host interfaces %+v", ingress)
+	}
+
+	// the pods are covered once all the plugins attached them, the internal port of nginx-2 is not attached
+	// by rpc (tc mode).
+	r.attach("mysql", []string{"10.0.0.10", "10.0.0.11", "10.0.0.12", "192.168.0.2", "10.0.2.2"}, 1)
+	r.attach("rpc", []string{"10.0.0.10", "10.0.0.11", "10.0.0.12", "192.168.0.2"}, 1)
+	r.attach("http", nil, 1)
+	if covered := r.covered(); len(covered) != 0 {
+		t.Errorf("a plugin attaching no interface covers no pod, got %v", covered)
+	}
+	r.attach("http", []string{"10.0.0.10", "10.0.0.11", "10.0.0.12", "192.168.0.2", "10.0.2.2", "10.0.0.14"}, 1)
+	r.attach("http", []string{"10.0.0.14"}, -1)
+	covered := r.covered()
+	if len(covered) != 4 || covered["10.0.2.2"] || covered["10.0.0.14"] {
+		t.Errorf("unexpected covered pods %v", covered)
+	}
+	pods = append(pods, pod("ingress", "nginx-2", "10.0.2.2", false, corev1.PodRunning))
+	interfaces["10.0.2.2"] = namespacedIndex
+	namespaces = summarize(pods, interfaces, covered, quarantined, protocols)
+	ingress = namespaces["ingress"].metric(1, "node-1", "ingress")
+	if ingress.Fields["covered_pod_count"] != 1 || ingress.Fields["uncovered_quarantined_interface"] != 1 {
+		t.Errorf("the pods not attached by all the plugins should not be covered %+v", ingress)
+	}
+	shop = namespaces["shop"].metric(1, "node-1", "shop")
+	if shop.Fields["covered_pod_count"] != 2 {
+		t.Errorf("unexpected coverage of shop %+v", shop)
+	}
+
+	// the protocols are reported once
+	if protocols, _ := r.snapshot(nil); len(protocols) != 0 {
+		t.Errorf("unexpected protocols %v", protocols)
+	}
+}
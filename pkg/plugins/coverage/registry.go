@@ -0,0 +1,134 @@
+package coverage
+
+import (
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+// registry collects what the plugins learn about the pods between two reports.
+type registry struct {
+	sync.Mutex
+	// protocols are the components of the L7 metrics by the uid of the pods on either side.
+	protocols map[string]map[string]bool
+	// quarantined are the interfaces some plugin failed to attach to, by plugin.
+	quarantined map[int]map[string]string
+	// attached are the counts of the attached interfaces of the pods by ip, by plugin.
+	attached map[string]map[string]int
+}
+
+func newRegistry() *registry {
+	return &registry{
+		protocols:   make(map[string]map[string]bool),
+		quarantined: make(map[int]map[string]string),
+		attached:    make(map[string]map[string]int),
+	}
+}
+
+func (r *registry) observe(m *metric.Metric) {
+	component := m.Tags["component"]
+	if component == "" {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, side := range []string{"source", "target"} {
+		uid := m.Tags[side+"_service_instance_id"]
+		if uid == "" {
+			continue
+		}
+		if r.protocols[uid] == nil {
+			r.protocols[uid] = make(map[string]bool)
+		}
+		r.protocols[uid][component] = true
+	}
+}
+
+func (r *registry) quarantine(plugin string, ifIndex int, err error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.quarantined[ifIndex] == nil {
+		r.quarantined[ifIndex] = make(map[string]string)
+	}
+	r.quarantined[ifIndex][plugin] = err.Error()
+}
+
+func (r *registry) attach(plugin string, ips []string, delta int) {
+	r.Lock()
+	defer r.Unlock()
+	if r.attached[plugin] == nil {
+		r.attached[plugin] = make(map[string]int)
+	}
+	for _, ip := range ips {
+		r.attached[plugin][ip] += delta
+		if r.attached[plugin][ip] <= 0 {
+			delete(r.attached[plugin], ip)
+		}
+	}
+}
+
+// covered returns the ips of the pods attached by all the plugins, nil if no plugin attaches.
+func (r *registry) covered() map[string]bool {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.attached) == 0 {
+		return nil
+	}
+	ans := make(map[string]bool)
+	for _, ips := range r.attached {
+		for ip := range ips {
+			ans[ip] = true
+		}
+	}
+	for ip := range ans {
+		for _, ips := range r.attached {
+			if ips[ip] == 0 {
+				delete(ans, ip)
+				break
+			}
+		}
+	}
+	return ans
+}
+
+// snapshot returns the protocols since the previous call and the quarantined interfaces, the
+// interfaces that are gone (e.g. the pod was deleted) are forgotten.
+func (r *registry) snapshot(live map[int]bool) (map[string]map[string]bool, map[int]map[string]string) {
+	r.Lock()
+	defer r.Unlock()
+	protocols := r.protocols
+	r.protocols = make(map[string]map[string]bool)
+	quarantined := make(map[int]map[string]string, len(r.quarantined))
+	for ifIndex, plugins := range r.quarantined {
+		if !live[ifIndex] {
+			delete(r.quarantined, ifIndex)
+			continue
+		}
+		quarantined[ifIndex] = plugins
+	}
+	return protocols, quarantined
+}
+
+var defaultRegistry = newRegistry()
+
+// Observe learns the protocol of an L7 metric for the pods on both sides.
+func Observe(m *metric.Metric) {
+	defaultRegistry.observe(m)
+}
+
+// Quarantine records that plugin failed to attach its probes to the interface, the pod behind it
+// is reported as not covered until the interface is recreated.
+func Quarantine(plugin string, ifIndex int, err error) {
+	defaultRegistry.quarantine(plugin, ifIndex, err)
+}
+
+// Attached records that plugin attached its probes to the interface of the pods of ips, a plugin attaching
+// no interface is recorded with no ips. The pods are reported as covered once all the plugins attached them.
+func Attached(plugin string, ips []string) {
+	defaultRegistry.attach(plugin, ips, 1)
+}
+
+// Detached records that plugin closed its probes on the interface of the pods of ips.
+func Detached(plugin string, ips []string) {
+	defaultRegistry.attach(plugin, ips, -1)
+}
@@ -0,0 +1,200 @@
+package cputime
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/sys/unix"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/eventbudget"
+)
+
+const (
+	programPath = "target/cputime.bpf.o"
+	mapSamples  = "cpu_samples_map"
+
+	// maxSamplesPerConn bounds the memory of the keep-alive connections with many requests per second.
+	maxSamplesPerConn = 64
+	sampleRetention   = 2 * time.Minute
+	// budgetReportInterval is the interval of the ebpf_event_budget metrics of dropped samples.
+	budgetReportInterval = 30 * time.Second
+)
+
+// budgetProbes maps the probes to their event budget ids, CPU_SAMPLE_PROBE of ebpf/plugins/cputime/main.c
+var budgetProbes = map[string]uint32{
+	"tcp_sendmsg": 1,
+}
+
+// Interface provides the on-cpu time of the threads serving the requests of the node, so that the request
+// metrics tell the cpu-bound endpoints from the ones waiting on their dependencies. The thread reading a
+// request is accounted from its first read to the response, requests handed over to another thread (e.g.
+// worker pools, goroutines scheduled on other threads) are not attributed.
+type Interface interface {
+	// Request returns the request of the client endpoint served within [start, end], timestamps are
+	// bpf_ktime_get_ns.
+	Request(clientIP string, clientPort uint16, start, end uint64) (Sample, bool)
+}
+
+type provider struct {
+	sync.Mutex
+	Log logs.Logger
+
+	collection *ebpf.Collection
+	samplesMap *ebpf.Map
+	links      []link.Link
+	budget     *eventbudget.Guard
+	samples    *cache.Cache
+	// drained is the time of the last read of the samples map (bpf_ktime_get_ns).
+	drained uint64
+	clock   clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.samples = cache.New(sampleRetention, 30*time.Second)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Request(clientIP string, clientPort uint16, start, end uint64) (Sample, bool) {
+	// the request metric may be read before the sample of its response
+	p.Lock()
+	if p.samplesMap != nil && p.drained < end {
+		p.drain()
+	}
+	p.Unlock()
+	v, ok := p.samples.Get(clientKey(clientIP, clientPort))
+	if !ok {
+		return Sample{}, false
+	}
+	return v.(*connSamples).find(start, end)
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	if err := p.load(); err != nil {
+		p.Log.Errorf("failed to load cpu time ebpf program, err: %v", err)
+		return
+	}
+	budgetReport := p.clock.Now()
+	for {
+		p.Lock()
+		p.drain()
+		p.Unlock()
+		if p.clock.Since(budgetReport) >= budgetReportInterval {
+			budgetReport = p.clock.Now()
+			p.reportBudget(c)
+		}
+		p.clock.Sleep(1 * time.Second)
+	}
+}
+
+// drain moves the samples of the map to the cache, the caller holds the lock.
+func (p *provider) drain() {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err == nil {
+		p.drained = uint64(ts.Nano())
+	}
+	var (
+		key uint64
+		val cpuSample
+	)
+	for p.samplesMap.Iterate().Next(&key, &val) {
+		p.add(&val)
+		if err := p.samplesMap.Delete(key); err != nil {
+			p.Log.Errorf("delete map error: %v", err)
+		}
+	}
+}
+
+func (p *provider) add(raw *cpuSample) {
+	key := clientKey(net.IP(raw.RemoteIP[:]).String(), raw.RemotePort)
+	v, ok := p.samples.Get(key)
+	if !ok {
+		v = &connSamples{}
+	}
+	cs := v.(*connSamples)
+	cs.add(Sample{Start: raw.Start, End: raw.End, CPU: raw.CPU, Tid: raw.Tid})
+	p.samples.Set(key, cs, cache.DefaultExpiration)
+}
+
+func (p *provider) load() error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	p.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	p.budget, err = eventbudget.Apply(p.collection, "cputime", budgetProbes)
+	if err != nil {
+		return err
+	}
+	l, err := link.Tracepoint("sched", "sched_switch", p.collection.DetachProgram("tracepoint_sched_switch"), nil)
+	if err != nil {
+		return err
+	}
+	p.links = append(p.links, l)
+	if l, err = link.Kprobe("tcp_recvmsg", p.collection.DetachProgram("kprobe_tcp_recvmsg"), nil); err != nil {
+		return err
+	}
+	p.links = append(p.links, l)
+	if l, err = link.Kretprobe("tcp_recvmsg", p.collection.DetachProgram("kretprobe_tcp_recvmsg"), nil); err != nil {
+		return err
+	}
+	p.links = append(p.links, l)
+	if l, err = link.Kprobe("tcp_sendmsg", p.collection.DetachProgram("kprobe_tcp_sendmsg"), nil); err != nil {
+		return err
+	}
+	p.links = append(p.links, l)
+	p.Lock()
+	p.samplesMap = p.collection.DetachMap(mapSamples)
+	p.Unlock()
+	return nil
+}
+
+func (p *provider) reportBudget(c chan *metric.Metric) {
+	metrics, err := p.budget.Metrics(p.clock.Now().UnixNano())
+	if err != nil {
+		p.Log.Errorf("failed to read event budget, err: %v", err)
+		return
+	}
+	for _, m := range metrics {
+		p.Log.Warnf("%v cpu samples of kprobe(%s) dropped by the event budget", m.Fields["dropped_count"], m.Tags["probe"])
+		c <- m
+	}
+}
+
+func (p *provider) Close() error {
+	for _, l := range p.links {
+		l.Close()
+	}
+	if p.collection != nil {
+		p.collection.Close()
+	}
+	return nil
+}
+
+func init() {
+	servicehub.Register("cputime", &servicehub.Spec{
+		Services:     []string{"cputime"},
+		Description:  "ebpf for the on-cpu time of the requests served on the node",
+		Dependencies: []string{},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
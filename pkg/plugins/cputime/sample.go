@@ -0,0 +1,71 @@
+package cputime
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// cpuSample mirrors struct cpu_sample_t of ebpf/plugins/cputime/main.c
+type cpuSample struct {
+	Start     uint64
+	End       uint64
+	CPU       uint64
+	LocalIP   [4]byte
+	RemoteIP  [4]byte
+	LocalPort uint16
+	// RemotePort is the client port for the connections served on the node.
+	RemotePort uint16
+	Tid        uint32
+}
+
+// Sample is a request served on the node: the thread read the request at Start and wrote the response
+// at End (bpf_ktime_get_ns), it was on cpu for CPU nanoseconds in between.
+type Sample struct {
+	Start uint64
+	End   uint64
+	CPU   uint64
+	Tid   uint32
+}
+
+// Ratio is the share of the request the thread was on cpu, close to 1 for cpu-bound requests and
+// close to 0 for the ones waiting on io, locks or their dependencies.
+func (s Sample) Ratio() float64 {
+	if s.End <= s.Start {
+		return 0
+	}
+	return math.Min(float64(s.CPU)/float64(s.End-s.Start), 1)
+}
+
+type connSamples struct {
+	sync.Mutex
+	samples []Sample
+}
+
+// find returns the sample within [start, end], the requests of a connection do not overlap.
+func (cs *connSamples) find(start, end uint64) (Sample, bool) {
+	cs.Lock()
+	defer cs.Unlock()
+	for _, s := range cs.samples {
+		if s.Start >= start && s.End <= end {
+			return s, true
+		}
+	}
+	return Sample{}, false
+}
+
+func (cs *connSamples) add(s Sample) {
+	cs.Lock()
+	defer cs.Unlock()
+	cs.samples = append(cs.samples, s)
+	if len(cs.samples) > maxSamplesPerConn {
+		cs.samples = cs.samples[len(cs.samples)-maxSamplesPerConn:]
+	}
+}
+
+// clientKey is the client endpoint of a connection, it is the same on both nodes unless the client is
+// masqueraded, while the server endpoint may be a service ip before the nat.
+func clientKey(ip string, port uint16) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
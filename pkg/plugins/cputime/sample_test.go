@@ -0,0 +1,52 @@
+package cputime
+
+import "testing"
+
+func TestConnSamplesFind(t *testing.T) {
+	cs := &connSamples{}
+	cs.add(Sample{Start: 100, End: 300, CPU: 50})
+	cs.add(Sample{Start: 1000, End: 1800, CPU: 700})
+
+	s, ok := cs.find(900, 2000)
+	if !ok || s.CPU != 700 {
+		t.Fatalf("find(900, 2000) = %+v, %v", s, ok)
+	}
+	// the sample of the server is within the window seen on the wire
+	if _, ok := cs.find(1100, 2000); ok {
+		t.Fatalf("find(1100, 2000) matched a request started before the window")
+	}
+	if _, ok := cs.find(2000, 3000); ok {
+		t.Fatalf("find(2000, 3000) matched without a request")
+	}
+}
+
+func TestConnSamplesBounded(t *testing.T) {
+	cs := &connSamples{}
+	for i := 0; i < maxSamplesPerConn+10; i++ {
+		cs.add(Sample{Start: uint64(i * 10), End: uint64(i*10 + 5)})
+	}
+	if len(cs.samples) != maxSamplesPerConn {
+		t.Fatalf("len(samples) = %d, want %d", len(cs.samples), maxSamplesPerConn)
+	}
+	if _, ok := cs.find(0, 5); ok {
+		t.Fatalf("the oldest samples are kept")
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	tests := []struct {
+		s    Sample
+		want float64
+	}{
+		{Sample{Start: 0, End: 1000, CPU: 900}, 0.9},
+		{Sample{Start: 0, End: 1000, CPU: 50}, 0.05},
+		// the cpu time is accounted per switch, it may exceed the window slightly
+		{Sample{Start: 0, End: 1000, CPU: 1200}, 1},
+		{Sample{Start: 1000, End: 1000, CPU: 10}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Ratio(); got != tt.want {
+			t.Errorf("%+v.Ratio() = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Package eventbudget configures the in-kernel rate limits of the high frequency probes of the
+// system level plugins, see ebpf/include/event_budget.h. Each probe has a token bucket, events
+// above the budget are dropped in kernel and counted, so a retransmit or syscall storm can not
+// overwhelm the node or the agent.
+//
+//	EBPF_EVENT_BUDGET=10000                                  events per second and probe on the node, 0 disables the limit
+//	EBPF_PROBE_EVENT_BUDGETS={"tcp_retransmit_skb":50000}    budget of single probes
+//
+// The budget of the node is split across the cpus, a burst of one second is allowed.
+package eventbudget
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	mapConfig = "event_budget_config_map"
+	mapState  = "event_budget_state_map"
+	// maxProbes is EVENT_BUDGET_MAX_PROBES of ebpf/include/event_budget.h
+	maxProbes = 32
+
+	measurement = "ebpf_event_budget"
+)
+
+type Config struct {
+	Budget       uint64            `env:"EBPF_EVENT_BUDGET" default:"10000"`
+	ProbeBudgets map[string]uint64 `env:"EBPF_PROBE_EVENT_BUDGETS"`
+}
+
+var (
+	cfg     Config
+	cfgOnce sync.Once
+)
+
+func config() *Config {
+	cfgOnce.Do(func() {
+		envconf.MustLoad(&cfg)
+	})
+	return &cfg
+}
+
+// Budget returns the events per second of the probe on the node, 0 means unlimited.
+func Budget(probe string) uint64 {
+	return config().budget(probe)
+}
+
+func (c *Config) budget(probe string) uint64 {
+	if b, ok := c.ProbeBudgets[probe]; ok {
+		return b
+	}
+	return c.Budget
+}
+
+// bucketConfig mirrors event_budget_config_t
+type bucketConfig struct {
+	Rate  uint64
+	Burst uint64
+}
+
+// bucketState mirrors event_budget_state_t
+type bucketState struct {
+	Tokens    uint64
+	Timestamp uint64
+	Allowed   uint64
+	Dropped   uint64
+}
+
+// Guard reads the counters of the budgets applied to a collection.
+type Guard struct {
+	plugin string
+	node   string
+	probes map[string]uint32
+	state  *ebpf.Map
+	// last holds the dropped and allowed events reported by the previous Metrics call.
+	last map[string]bucketState
+}
+
+// Apply fills the budget config map of a loaded collection, probes maps the probe names to the ids passed to
+// event_budget_allow.
+func Apply(collection *ebpf.Collection, plugin string, probes map[string]uint32) (*Guard, error) {
+	configMap, ok := collection.Maps[mapConfig]
+	if !ok {
+		return nil, fmt.Errorf("map %s not found", mapConfig)
+	}
+	state, ok := collection.Maps[mapState]
+	if !ok {
+		return nil, fmt.Errorf("map %s not found", mapState)
+	}
+	cpus := uint64(runtime.NumCPU())
+	for probe, id := range probes {
+		if id >= maxProbes {
+			return nil, fmt.Errorf("probe %s: id %d exceeds %d", probe, id, maxProbes)
+		}
+		budget := Budget(probe)
+		if budget == 0 {
+			continue
+		}
+		rate := (budget + cpus - 1) / cpus
+		if err := configMap.Put(id, bucketConfig{Rate: rate, Burst: rate}); err != nil {
+			return nil, fmt.Errorf("budget of probe %s: %v", probe, err)
+		}
+	}
+	return &Guard{
+		plugin: plugin,
+		node:   os.Getenv("NODE_NAME"),
+		probes: probes,
+		state:  state,
+		last:   make(map[string]bucketState),
+	}, nil
+}
+
+// Metrics returns the events allowed and dropped by the budgets since the previous call,
+// probes without dropped events are left out.
+func (g *Guard) Metrics(timestamp int64) ([]*metric.Metric, error) {
+	var ans []*metric.Metric
+	for probe, id := range g.probes {
+		var perCPU []bucketState
+		if err := g.state.Lookup(id, &perCPU); err != nil {
+			return nil, fmt.Errorf("lookup budget of probe %s: %v", probe, err)
+		}
+		var total bucketState
+		for _, s := range perCPU {
+			total.Allowed += s.Allowed
+			total.Dropped += s.Dropped
+		}
+		last := g.last[probe]
+		g.last[probe] = total
+		if total.Dropped == last.Dropped {
+			continue
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			Tags: map[string]string{
+				"host":   g.node,
+				"plugin": g.plugin,
+				"probe":  probe,
+			},
+			Fields: map[string]interface{}{
+				"allowed_count": total.Allowed - last.Allowed,
+				"dropped_count": total.Dropped - last.Dropped,
+				"budget":        Budget(probe),
+			},
+		})
+	}
+	return ans, nil
+}
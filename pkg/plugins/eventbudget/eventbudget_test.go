@@ -0,0 +1,59 @@
+package eventbudget
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		envs   map[string]string
+		probe  string
+		expect uint64
+	}{
+		{"node budget", map[string]string{"EBPF_EVENT_BUDGET": "10000"}, "kfree_skb", 10000},
+		{"probe budget", map[string]string{
+			"EBPF_EVENT_BUDGET":        "10000",
+			"EBPF_PROBE_EVENT_BUDGETS": `{"kfree_skb":50000,"inet_sock_set_state":0}`,
+		}, "kfree_skb", 50000},
+		{"probe without limit", map[string]string{
+			"EBPF_EVENT_BUDGET":        "10000",
+			"EBPF_PROBE_EVENT_BUDGETS": `{"inet_sock_set_state":0}`,
+		}, "inet_sock_set_state", 0},
+		{"other probe", map[string]string{
+			"EBPF_EVENT_BUDGET":        "10000",
+			"EBPF_PROBE_EVENT_BUDGETS": `{"kfree_skb":50000}`,
+		}, "tcp_retransmit_skb", 10000},
+		{"node without limit", map[string]string{"EBPF_EVENT_BUDGET": "0"}, "tcp_reset", 0},
+	}
+	for _, tt := range tests {
+		var c Config
+		if err := envconf.Load(&c, tt.envs); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b := c.budget(tt.probe); b != tt.expect {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expect, b)
+		}
+	}
+}
+
+func TestBudgetInvalid(t *testing.T) {
+	for _, budgets := range []string{`{"kfree_skb":-1}`, `{"kfree_skb":"fast"}`, `kfree_skb=100`} {
+		var c Config
+		if err := envconf.Load(&c, map[string]string{"EBPF_PROBE_EVENT_BUDGETS": budgets}); err == nil {
+			t.Errorf("expected %s to be rejected, got %v", budgets, c.ProbeBudgets)
+		}
+	}
+}
+
+func TestDefaultBudget(t *testing.T) {
+	t.Setenv("EBPF_EVENT_BUDGET", "")
+	t.Setenv("EBPF_PROBE_EVENT_BUDGETS", `{"kfree_skb":2000}`)
+	var c Config
+	envconf.MustLoad(&c)
+	if c.budget("kfree_skb") != 2000 || c.budget("inet_sock_set_state") != 10000 {
+		t.Errorf("unexpected budgets %+v", c)
+	}
+}
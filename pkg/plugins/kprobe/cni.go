@@ -0,0 +1,182 @@
+package kprobe
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe/kprobesysctl"
+)
+
+// The host side interfaces of the pods depend on the model of the CNI:
+//
+//	veth      a veth per pod holding the neigh of the pod (bridge, flannel, ...), see getNeighVethes
+//	routed    a veth per pod without neigh, the /32 route of the pod ip points to it (Cilium lxc with
+//	          endpoint routes, Calico, the eni veths of the AWS VPC CNI), see getRoutedVethes. The routes
+//	          of all the tables are read: the pods of the branch ENIs of the AWS VPC CNI (security groups
+//	          for pods) have theirs in the table of their vlan. The traffic of the pods on the secondary
+//	          ENIs passes their veth as well, the ENIs attached at runtime need no probe.
+//	peer      a veth per pod without neigh nor route, the pod ip is the address of its peer (kube-ovn),
+//	          see getPeerVethes
+//	shared    the ipvlan and macvlan pods have no host side interface, their slaves send through the parent
+//	          interface of the node, see getSharedInterfaces. So do the secondary ipvlan and macvlan
+//	          interfaces Multus attaches to the pods, whatever the CNI of their default network.
+//	internal  the kube-ovn pods with an OVS internal port have no interface on the node, see
+//	          getInternalPorts
+//
+// The veth, routed and peer interfaces are probed by all plugins (GetVethes), the secondary veths of the
+// Multus pods (e.g. of the bridge CNI) are found by peer. The shared interfaces of the pods are discovered
+// when EBPF_CNI is ipvlan or macvlan, the ones of the secondary interfaces in all modes, and probed by the
+// plugins telling the pods apart by ip (GetSharedInterfaces). The internal ports are discovered when
+// EBPF_CNI is kube-ovn, and probed within the namespace of their pod by the plugins attaching raw sockets
+// (GetNamespacedInterfaces).
+const (
+	CNIAuto    = "auto"
+	CNIIPVlan  = "ipvlan"
+	CNIMACVlan = "macvlan"
+	CNIKubeOVN = "kube-ovn"
+)
+
+// getAllVethes returns the interfaces of the pods with a veth, by neigh first, by route then by peer.
+func getAllVethes() ([]NeighLink, error) {
+	ans, err := getNeighVethes()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int]bool, len(ans))
+	for _, l := range ans {
+		known[l.Link.Attrs().Index] = true
+	}
+	routed, err := getRoutedVethes(known)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range routed {
+		known[l.Link.Attrs().Index] = true
+	}
+	ans = append(ans, routed...)
+	peers, err := getPeerVethes(known)
+	if err != nil {
+		return nil, err
+	}
+	return append(ans, peers...), nil
+}
+
+// getRoutedVethes returns the veths without neigh routing the ip of a pod, known are the indexes of the
+// veths found by neigh.
+func getRoutedVethes(known map[int]bool) ([]NeighLink, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	ans := make([]NeighLink, 0)
+	for _, l := range links {
+		if l.Type() != "veth" || known[l.Attrs().Index] {
+			continue
+		}
+		filter := &netlink.Route{LinkIndex: l.Attrs().Index, Table: unix.RT_TABLE_UNSPEC}
+		routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return nil, err
+		}
+		if ip, ok := routedIP(routes); ok {
+			ans = append(ans, NeighLink{
+				Neigh: netlink.Neigh{LinkIndex: l.Attrs().Index, IP: ip},
+				Link:  l,
+			})
+		}
+	}
+	return ans, nil
+}
+
+// routedIP returns the pod ip of the routes of a veth, its single host route, which may be in several
+// tables. The local table holds the addresses of the veth itself.
+func routedIP(routes []netlink.Route) (net.IP, bool) {
+	var ip net.IP
+	for _, r := range routes {
+		if r.Dst == nil || r.Table == unix.RT_TABLE_LOCAL {
+			continue
+		}
+		if ones, bits := r.Dst.Mask.Size(); ones != 32 || bits != 32 {
+			continue
+		}
+		if ip != nil {
+			if ip.Equal(r.Dst.IP) {
+				continue
+			}
+			return nil, false
+		}
+		ip = r.Dst.IP
+	}
+	return ip, ip != nil
+}
+
+// getSharedInterfaces returns the parent interfaces of the local pods without veth, vethes are the ips
+// of the pods with a veth, primary whether the ip of the default network of the pods is shared too. The
+// neigh of a shared interface is 0.0.0.0, its pods are in Pods.
+func getSharedInterfaces(pods []corev1.Pod, vethes map[string]bool, hostIP string, primary bool) []NeighLink {
+	parents := sharedParents(pods, vethes, hostIP, primary, func(ip net.IP) (int, bool, error) {
+		routes, err := netlink.RouteGet(ip)
+		if err != nil || len(routes) == 0 {
+			return 0, false, err
+		}
+		return routes[0].LinkIndex, routes[0].Gw != nil, nil
+	})
+	ans := make([]NeighLink, 0, len(parents))
+	for index, ips := range parents {
+		l, err := netlink.LinkByIndex(index)
+		if err != nil {
+			continue
+		}
+		ans = append(ans, NeighLink{
+			Neigh: netlink.Neigh{LinkIndex: index, IP: net.IPv4zero},
+			Link:  l,
+			Pods:  ips,
+		})
+	}
+	return ans
+}
+
+// sharedParents groups the ips of the running pods without veth by the index of the interface route
+// returns for them, the hostNetwork pods are left out. The secondary ips of the Multus pods are grouped
+// when their network is on-link only, the route of an isolated network is the default one of the node.
+func sharedParents(pods []corev1.Pod, vethes map[string]bool, hostIP string, primary bool,
+	route func(net.IP) (index int, gateway bool, err error)) map[int][]net.IP {
+	ans := make(map[int][]net.IP)
+	add := func(addr string, secondary bool) {
+		if vethes[addr] || addr == hostIP {
+			return
+		}
+		ip := net.ParseIP(addr).To4()
+		if ip == nil {
+			return
+		}
+		index, gateway, err := route(ip)
+		if err != nil || index == 0 || (secondary && gateway) {
+			return
+		}
+		ans[index] = append(ans[index], ip)
+	}
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if primary {
+			add(pod.Status.PodIP, false)
+		}
+		for _, ip := range kprobesysctl.SecondaryIPs(pod) {
+			add(ip, true)
+		}
+	}
+	return ans
+}
+
+func validCNI(cni string) bool {
+	switch cni {
+	case CNIAuto, CNIIPVlan, CNIMACVlan, CNIKubeOVN:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package kprobe
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRoutedIP(t *testing.T) {
+	_, pod, _ := net.ParseCIDR("10.0.1.5/32")
+	_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+	_, other, _ := net.ParseCIDR("10.0.1.6/32")
+
+	ip, ok := routedIP([]netlink.Route{{Dst: subnet}, {Dst: pod}, {}})
+	if !ok || ip.String() != "10.0.1.5" {
+		t.Errorf("expected the host route of the pod, got %v %v", ip, ok)
+	}
+	if _, ok := routedIP([]netlink.Route{{Dst: subnet}}); ok {
+		t.Error("a veth without host route has no pod")
+	}
+	if _, ok := routedIP([]netlink.Route{{Dst: pod}, {Dst: other}}); ok {
+		t.Error("a veth routing several pods is not the veth of a pod")
+	}
+
+	// the branch ENI pods of the AWS VPC CNI: the route in the table of the vlan, the local address of the veth
+	ip, ok = routedIP([]netlink.Route{
+		{Dst: pod, Table: 101}, {Dst: pod, Table: unix.RT_TABLE_MAIN}, {Dst: other, Table: unix.RT_TABLE_LOCAL},
+	})
+	if !ok || ip.String() != "10.0.1.5" {
+		t.Errorf("expected the route of the pod in its table, got %v %v", ip, ok)
+	}
+}
+
+func TestSharedParents(t *testing.T) {
+	pod := func(ip string, hostNetwork bool, phase corev1.PodPhase) corev1.Pod {
+		p := corev1.Pod{}
+		p.Spec.HostNetwork = hostNetwork
+		p.Status.PodIP = ip
+		p.Status.Phase = phase
+		return p
+	}
+	pods := []corev1.Pod{
+		pod("10.0.1.5", false, corev1.PodRunning),
+		pod("10.0.1.6", false, corev1.PodRunning),
+		pod("10.0.2.7", false, corev1.PodRunning),
+		pod("10.0.3.8", false, corev1.PodRunning), // veth
+		pod("192.168.0.1", true, corev1.PodRunning),
+		pod("10.0.1.9", false, corev1.PodPending),
+		pod("10.0.9.9", false, corev1.PodRunning), // no route
+	}
+	route := func(ip net.IP) (int, bool, error) {
+		switch ip.To4()[2] {
+		case 1:
+			return 2, false, nil
+		case 2:
+			return 3, false, nil
+		}
+		if ip.To4()[0] == 172 {
+			return 4, true, nil
+		}
+		return 0, false, fmt.Errorf("no route to %s", ip)
+	}
+	parents := sharedParents(pods, map[string]bool{"10.0.3.8": true}, "192.168.0.1", true, route)
+	if len(parents) != 2 || len(parents[2]) != 2 || len(parents[3]) != 1 || parents[3][0].String() != "10.0.2.7" {
+		t.Errorf("unexpected parents %v", parents)
+	}
+
+	// the secondary macvlan interfaces of a Multus pod, the isolated network is routed by the default route
+	multus := pod("10.0.3.9", false, corev1.PodRunning)
+	multus.Annotations = map[string]string{
+		"k8s.v1.cni.cncf.io/network-status": `[{"name":"cbr0","interface":"eth0","ips":["10.0.3.9"],"default":true},
+			{"name":"default/macvlan","interface":"net1","ips":["10.0.1.20"]},
+			{"name":"default/storage","interface":"net2","ips":["172.16.0.5"]}]`,
+	}
+	parents = sharedParents(append(pods, multus), map[string]bool{"10.0.3.8": true, "10.0.3.9": true}, "192.168.0.1",
+		false, route)
+	if len(parents) != 1 || len(parents[2]) != 1 || parents[2][0].String() != "10.0.1.20" {
+		t.Errorf("expected the on-link secondary ip only, got %v", parents)
+	}
+}
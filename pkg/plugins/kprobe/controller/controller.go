@@ -66,6 +66,18 @@ func (c *Controller) GetPodByUID(podUID string) (corev1.Pod, error) {
 	return c.sysctlController.GetPodByUID(podUID)
 }
 
+func (c *Controller) GetPodByPID(pid uint32) (corev1.Pod, error) {
+	return c.sysctlController.GetPodByPID(pid)
+}
+
+func (c *Controller) GetLocalPods() []corev1.Pod {
+	return c.sysctlController.GetLocalPods()
+}
+
+func (c *Controller) Synced() <-chan struct{} {
+	return c.sysctlController.Synced()
+}
+
 func (c *Controller) GetService(ip string) (corev1.Service, error) {
 	return c.sysctlController.GetService(ip)
 }
@@ -1,13 +1,18 @@
 package kprobe
 
 import (
+	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/erda-project/erda-infra/base/servicehub"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
 
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe/controller"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe/kprobesysctl"
 )
@@ -15,21 +20,54 @@ import (
 type Interface interface {
 	GetSysctlStat(pid uint32) (kprobesysctl.SysctlStat, error)
 	GetPodByUID(podUID string) (corev1.Pod, error)
+	// GetPodByPID returns the pod of the cgroup of a process, e.g. of the hostNetwork pods, which share the
+	// ip of the node.
+	GetPodByPID(pid uint32) (corev1.Pod, error)
+	// GetLocalPods returns the pods scheduled to this node.
+	GetLocalPods() []corev1.Pod
 	GetService(ip string) (corev1.Service, error)
 	RegisterNetLinkListener() <-chan NeighLinkEvent
 	GetVethes() ([]NeighLink, error)
+	// GetHostInterfaces returns the interfaces holding the ip of the node, the traffic of the hostNetwork
+	// pods passes them instead of a veth. It is empty unless EBPF_HOST_NETWORK is set.
+	GetHostInterfaces() []NeighLink
+	// GetSharedInterfaces returns the parent interfaces of the ipvlan and macvlan pods with the ips of their
+	// pods, the neigh is 0.0.0.0. It holds the secondary interfaces of the Multus pods only unless EBPF_CNI
+	// is ipvlan or macvlan, see cni.go.
+	GetSharedInterfaces() []NeighLink
+	// GetNamespacedInterfaces returns the OVS internal ports of the kube-ovn pods, NetNS is the namespace of
+	// the port. It is empty unless EBPF_CNI is kube-ovn, see ovs.go.
+	GetNamespacedInterfaces() []NeighLink
+	// Synced is closed once the pod and service caches are filled, see WaitSynced.
+	Synced() <-chan struct{}
+}
+
+type Config struct {
+	// HostNetwork probes the host interfaces for the hostNetwork pods, all the traffic of the node passes
+	// them. The NICs of the node holding another address than HOST_IP are probed too, their address is
+	// resolved to the pods of the node.
+	HostNetwork bool `env:"EBPF_HOST_NETWORK" default:"false"`
+	// CNI is auto for the CNIs with a veth per pod, ipvlan or macvlan for the pods sharing the parent
+	// interface of the node, kube-ovn for the pods with an OVS internal port.
+	CNI string `env:"EBPF_CNI" default:"auto"`
 }
 
 type provider struct {
 	sync.RWMutex
+	cfg              Config
 	kprobeController controller.Controller
 	netLinks         map[int]NeighLink
+	hostLinks        []NeighLink
+	// hostAliases are the addresses of the host interfaces but HOST_IP.
+	hostAliases      map[string]bool
 	netLinkListeners []chan NeighLinkEvent
-	ticker           *time.Ticker
+	clock            clock.Clock
+	ticker           clock.Ticker
 }
 
 func (p *provider) Init(ctx servicehub.Context) error {
 	p.kprobeController = controller.NewController()
+	p.clock = clock.Real
 	p.netLinks = make(map[int]NeighLink)
 	neighs, err := getAllVethes()
 	if err != nil {
@@ -38,15 +76,32 @@ func (p *provider) Init(ctx servicehub.Context) error {
 	for _, neigh := range neighs {
 		p.netLinks[neigh.Link.Attrs().Index] = neigh
 	}
+	envconf.MustLoad(&p.cfg)
+	if !validCNI(p.cfg.CNI) {
+		return fmt.Errorf("invalid EBPF_CNI %q, expected %s, %s, %s or %s", p.cfg.CNI, CNIAuto, CNIIPVlan,
+			CNIMACVlan, CNIKubeOVN)
+	}
+	if p.cfg.HostNetwork {
+		p.hostLinks, err = getHostInterfaces(os.Getenv("HOST_IP"))
+		if err != nil {
+			return err
+		}
+		p.hostAliases = make(map[string]bool)
+		for _, l := range p.hostLinks {
+			if ip := l.Neigh.IP.String(); ip != os.Getenv("HOST_IP") {
+				p.hostAliases[ip] = true
+			}
+		}
+	}
 	return nil
 }
 
 func (p *provider) Start() error {
-	p.ticker = time.NewTicker(5 * time.Second)
+	p.ticker = p.clock.NewTicker(5 * time.Second)
 	go func() {
 		for {
 			select {
-			case <-p.ticker.C:
+			case <-p.ticker.C():
 				p.refreshVethes()
 			}
 		}
@@ -77,6 +132,43 @@ func (p *provider) GetVethes() ([]NeighLink, error) {
 	return ans, nil
 }
 
+func (p *provider) GetHostInterfaces() []NeighLink {
+	return p.hostLinks
+}
+
+func (p *provider) GetSharedInterfaces() []NeighLink {
+	primary := p.cfg.CNI == CNIIPVlan || p.cfg.CNI == CNIMACVlan
+	return getSharedInterfaces(p.GetLocalPods(), p.vethIPs(), os.Getenv("HOST_IP"), primary)
+}
+
+func (p *provider) GetNamespacedInterfaces() []NeighLink {
+	if p.cfg.CNI != CNIKubeOVN {
+		return nil
+	}
+	vethes := p.vethIPs()
+	pods := make(map[string]bool)
+	for _, pod := range p.GetLocalPods() {
+		if !pod.Spec.HostNetwork && pod.Status.Phase == corev1.PodRunning && !vethes[pod.Status.PodIP] {
+			pods[pod.Status.PodIP] = true
+		}
+	}
+	ports, err := getInternalPorts(pods)
+	if err != nil {
+		klog.Errorf("failed to get the ovs internal ports, err: %v", err)
+	}
+	return ports
+}
+
+// vethIPs returns the ips of the pods with a veth.
+func (p *provider) vethIPs() map[string]bool {
+	vethes, _ := p.GetVethes()
+	ips := make(map[string]bool, len(vethes))
+	for _, v := range vethes {
+		ips[v.Neigh.IP.String()] = true
+	}
+	return ips
+}
+
 func (p *provider) Gather(c chan *metric.Metric) {
 	p.kprobeController.Start(c)
 }
@@ -86,13 +178,29 @@ func (p *provider) GetSysctlStat(pid uint32) (kprobesysctl.SysctlStat, error) {
 }
 
 func (p *provider) GetPodByUID(podUID string) (corev1.Pod, error) {
+	// the other NICs of the node, the hostNetwork pods are cached by HOST_IP.
+	if p.hostAliases[podUID] {
+		podUID = os.Getenv("HOST_IP")
+	}
 	return p.kprobeController.GetPodByUID(podUID)
 }
 
+func (p *provider) GetPodByPID(pid uint32) (corev1.Pod, error) {
+	return p.kprobeController.GetPodByPID(pid)
+}
+
+func (p *provider) GetLocalPods() []corev1.Pod {
+	return p.kprobeController.GetLocalPods()
+}
+
 func (p *provider) GetService(ip string) (corev1.Service, error) {
 	return p.kprobeController.GetService(ip)
 }
 
+func (p *provider) Synced() <-chan struct{} {
+	return p.kprobeController.Synced()
+}
+
 func init() {
 	servicehub.Register("kprobe", &servicehub.Spec{
 		Services:     []string{"kprobe"},
@@ -167,7 +167,9 @@ func (k *KprobeSysctlController) refreshPodInfo() error {
 			continue
 		}
 		k.podCache.Set(string(pods.Items[i].UID), pods.Items[i], 30*time.Minute)
-		k.podCache.Set(pods.Items[i].Status.PodIP, pods.Items[i], 30*time.Minute)
+		for _, ip := range podIPs(pods.Items[i]) {
+			k.podCache.Set(ip, pods.Items[i], 30*time.Minute)
+		}
 	}
 	return nil
 }
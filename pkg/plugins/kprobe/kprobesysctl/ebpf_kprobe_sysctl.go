@@ -8,11 +8,13 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/rlimit"
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
 	"github.com/erda-project/ebpf-agent/pkg/envconf"
 	"github.com/erda-project/ebpf-agent/pkg/exporter/collector"
 	"github.com/patrickmn/go-cache"
@@ -37,6 +39,8 @@ type KprobeSysctlController struct {
 	clientSet    *kubernetes.Clientset
 	reportClient *collector.ReportClient
 	objs         bpfObjects
+	synced       chan struct{}
+	clock        clock.Clock
 }
 
 func New(clientSet *kubernetes.Clientset) *KprobeSysctlController {
@@ -56,7 +60,9 @@ func New(clientSet *kubernetes.Clientset) *KprobeSysctlController {
 		podCache:     cache.New(time.Hour, 10*time.Minute),
 		serviceCache: cache.New(time.Hour, 10*time.Minute),
 		reportClient: collector.CreateReportClient(reportConfig),
+		clock:        clock.Real,
 		objs:         objs,
+		synced:       make(chan struct{}),
 	}
 }
 
@@ -74,6 +80,41 @@ func (k *KprobeSysctlController) GetPodByUID(uid string) (corev1.Pod, error) {
 	return corev1.Pod{}, fmt.Errorf("failed to find pod for uid: %s", uid)
 }
 
+// GetPodByPID returns the pod of the cgroup of a process, the cgroup is read from /proc for the processes
+// missing in the cache. It resolves the hostNetwork pods, which share the ip of the node.
+func (k *KprobeSysctlController) GetPodByPID(pid uint32) (corev1.Pod, error) {
+	stat, err := k.GetSysctlStatByPID(pid)
+	if err != nil || strings.TrimSpace(stat.PodUID) == "" {
+		podUID, containerID, podPath, err := readCgroupInfoFromPID(pid)
+		if err != nil {
+			return corev1.Pod{}, err
+		}
+		stat = SysctlStat{Pid: pid, PodUID: podUID, ContainerID: containerID, ID: podPath, IsSystem: podUID == ""}
+		k.updateStat(stat)
+	}
+	// the uids of the systemd cgroups use underscores
+	podUID := strings.ReplaceAll(strings.TrimSpace(stat.PodUID), "_", "-")
+	if podUID == "" {
+		return corev1.Pod{}, fmt.Errorf("pid %d is not in a pod", pid)
+	}
+	return k.GetPodByUID(podUID)
+}
+
+// GetLocalPods returns the pods scheduled to this node, the cache holds every pod twice (by uid and ip).
+func (k *KprobeSysctlController) GetLocalPods() []corev1.Pod {
+	seen := make(map[string]bool)
+	ans := make([]corev1.Pod, 0)
+	for _, item := range k.podCache.Items() {
+		pod, ok := item.Object.(corev1.Pod)
+		if !ok || pod.Status.HostIP != k.hostIP || seen[string(pod.UID)] {
+			continue
+		}
+		seen[string(pod.UID)] = true
+		ans = append(ans, pod)
+	}
+	return ans
+}
+
 func (k *KprobeSysctlController) GetService(ip string) (corev1.Service, error) {
 	if svc, ok := k.serviceCache.Get(ip); ok {
 		return svc.(corev1.Service), nil
@@ -81,6 +122,11 @@ func (k *KprobeSysctlController) GetService(ip string) (corev1.Service, error) {
 	return corev1.Service{}, fmt.Errorf("failed to get service from cache, ip: %s", ip)
 }
 
+// Synced is closed once the pod and service informers have delivered their initial lists.
+func (k *KprobeSysctlController) Synced() <-chan struct{} {
+	return k.synced
+}
+
 func (k *KprobeSysctlController) Start(ch chan<- *SysctlStat) error {
 	if err := k.refreshProcCgroupInfo(); err != nil {
 		return err
@@ -103,12 +149,16 @@ func (k *KprobeSysctlController) Start(ch chan<- *SysctlStat) error {
 				return
 			}
 			k.podCache.Set(string(newPod.UID), *newPod, 30*time.Minute)
-			k.podCache.Set(newPod.Status.PodIP, *newPod, 30*time.Minute)
+			for _, ip := range podIPs(*newPod) {
+				k.podCache.Set(ip, *newPod, 30*time.Minute)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			pod := obj.(*corev1.Pod)
 			k.podCache.Delete(string(pod.UID))
-			k.podCache.Delete(pod.Status.PodIP)
+			for _, ip := range podIPs(*pod) {
+				k.podCache.Delete(ip)
+			}
 		},
 		// UpdateFunc: func(oldObj interface{}, newObj interface{}) {
 		// },
@@ -145,22 +195,29 @@ func (k *KprobeSysctlController) Start(ch chan<- *SysctlStat) error {
 
 	go serviceInformer.Run(serviceInformerStopper)
 
+	go func() {
+		if clientgoCache.WaitForCacheSync(podInformerStopper, podInformer.HasSynced, serviceInformer.HasSynced) {
+			klog.Infof("pod and service caches synced")
+			close(k.synced)
+		}
+	}()
+
 	// todo: add recover and context control
 	go func() {
-		pidTicker := time.NewTicker(time.Hour)
-		podTicker := time.NewTicker(30 * time.Minute)
-		svcTicker := time.NewTicker(time.Minute)
+		pidTicker := k.clock.NewTicker(time.Hour)
+		podTicker := k.clock.NewTicker(30 * time.Minute)
+		svcTicker := k.clock.NewTicker(time.Minute)
 		for {
 			select {
-			case <-pidTicker.C:
+			case <-pidTicker.C():
 				if err := k.refreshProcCgroupInfo(); err != nil {
 					klog.Errorf("failed to refresh cgroup infos, err: %v", err)
 				}
-			case <-podTicker.C:
+			case <-podTicker.C():
 				if err := k.refreshPodInfo(); err != nil {
 					klog.Errorf("failed to refresh pod infos, err: %v", err)
 				}
-			case <-svcTicker.C:
+			case <-svcTicker.C():
 				if err := k.refreshServiceInfo(nil); err != nil {
 					klog.Errorf("failed to refresh service infos, err: %v", err)
 				}
@@ -170,10 +227,10 @@ func (k *KprobeSysctlController) Start(ch chan<- *SysctlStat) error {
 	go k.WatchKprobeSysClone(ch)
 
 	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
+		ticker := k.clock.NewTicker(5 * time.Minute)
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				if err := k.updateServiceNode(); err != nil {
 					klog.Errorf("failed to update service node, err: %v", err)
 				}
@@ -226,7 +283,7 @@ func (k *KprobeSysctlController) WatchKprobeSysClone(ch chan<- *SysctlStat) {
 			}
 			k.updateStat(stat)
 		}
-		time.Sleep(1 * time.Second)
+		k.clock.Sleep(1 * time.Second)
 	}
 }
 
@@ -264,12 +321,12 @@ func EncodeStat(stat *SysctlStat) []byte {
 	return w.Bytes()
 }
 
-func makeServiceNodeMetric(pod corev1.Pod) *metric.Metric {
-	now := time.Now().Unix()
+func makeServiceNodeMetric(pod corev1.Pod, at time.Time) *metric.Metric {
+	now := at.Unix()
 	return &metric.Metric{
 		Measurement: "application_service_node",
 		Name:        "application_service_node",
-		Timestamp:   time.Now().UnixNano(),
+		Timestamp:   at.UnixNano(),
 		Tags: map[string]string{
 			"_meta":               "true",
 			"_metric_scope":       "micro_service",
@@ -315,7 +372,7 @@ func (k *KprobeSysctlController) updateServiceNode() error {
 		if pod.Status.HostIP != k.hostIP || len(pod.Labels["DICE_SERVICE"]) == 0 {
 			continue
 		}
-		m := makeServiceNodeMetric(pod)
+		m := makeServiceNodeMetric(pod, k.clock.Now())
 		serviceNodes = append(serviceNodes, m)
 	}
 	if len(serviceNodes) == 0 {
@@ -0,0 +1,57 @@
+package kprobesysctl
+
+import (
+	"encoding/json"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The pods attached to several networks by Multus list their interfaces in the network-status annotation,
+// the networks-status one before Multus 3.7. The pods are cached by the ips of all their networks, so that
+// the requests on a secondary interface (net1, ...) are attributed to the pod as well and its metrics are
+// not split by the interface they passed.
+const (
+	NetworkStatusAnnotation       = "k8s.v1.cni.cncf.io/network-status"
+	LegacyNetworkStatusAnnotation = "k8s.v1.cni.cncf.io/networks-status"
+)
+
+type networkStatus struct {
+	Name      string   `json:"name"`
+	Interface string   `json:"interface"`
+	IPs       []string `json:"ips"`
+	Default   bool     `json:"default"`
+}
+
+// SecondaryIPs returns the ipv4 addresses of the secondary networks of pod, empty unless Multus attached
+// it to several networks.
+func SecondaryIPs(pod corev1.Pod) []string {
+	value, ok := pod.Annotations[NetworkStatusAnnotation]
+	if !ok {
+		value = pod.Annotations[LegacyNetworkStatusAnnotation]
+	}
+	if value == "" {
+		return nil
+	}
+	var networks []networkStatus
+	if err := json.Unmarshal([]byte(value), &networks); err != nil {
+		return nil
+	}
+	var ans []string
+	for _, n := range networks {
+		if n.Default {
+			continue
+		}
+		for _, ip := range n.IPs {
+			if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil && ip != pod.Status.PodIP {
+				ans = append(ans, ip)
+			}
+		}
+	}
+	return ans
+}
+
+// podIPs returns the keys of pod in the cache by ip, the ip of its default network first.
+func podIPs(pod corev1.Pod) []string {
+	return append([]string{pod.Status.PodIP}, SecondaryIPs(pod)...)
+}
@@ -0,0 +1,37 @@
+package kprobesysctl
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSecondaryIPs(t *testing.T) {
+	pod := corev1.Pod{}
+	pod.Status.PodIP = "10.244.1.5"
+	if ips := podIPs(pod); !reflect.DeepEqual(ips, []string{"10.244.1.5"}) {
+		t.Errorf("expected the pod ip only, got %v", ips)
+	}
+
+	pod.Annotations = map[string]string{
+		NetworkStatusAnnotation: `[{"name":"cbr0","interface":"eth0","ips":["10.244.1.5"],"default":true},
+			{"name":"default/macvlan","interface":"net1","ips":["192.168.10.5","fd00::5"]},
+			{"name":"default/sriov","interface":"net2","ips":["192.168.20.5"]}]`,
+	}
+	if ips := podIPs(pod); !reflect.DeepEqual(ips, []string{"10.244.1.5", "192.168.10.5", "192.168.20.5"}) {
+		t.Errorf("unexpected ips %v", ips)
+	}
+
+	pod.Annotations = map[string]string{
+		LegacyNetworkStatusAnnotation: `[{"name":"default/macvlan","ips":["192.168.10.5"]}]`,
+	}
+	if ips := SecondaryIPs(pod); !reflect.DeepEqual(ips, []string{"192.168.10.5"}) {
+		t.Errorf("expected the ips of the legacy annotation, got %v", ips)
+	}
+
+	pod.Annotations = map[string]string{NetworkStatusAnnotation: "not json"}
+	if ips := SecondaryIPs(pod); len(ips) != 0 {
+		t.Errorf("expected no ips of an invalid annotation, got %v", ips)
+	}
+}
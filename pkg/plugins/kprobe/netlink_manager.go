@@ -1,9 +1,11 @@
 package kprobe
 
 import (
+	"fmt"
+	"net"
+
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
-	"net"
 )
 
 type LinkEventType string
@@ -16,6 +18,11 @@ var (
 type NeighLink struct {
 	Neigh netlink.Neigh
 	Link  netlink.Link
+	// Pods are the ips of the pods of a shared interface (ipvlan, macvlan), see GetSharedInterfaces.
+	Pods []net.IP
+	// NetNS is the path of the network namespace of an interface outside the one of the node (the OVS
+	// internal ports of kube-ovn), see GetNamespacedInterfaces.
+	NetNS string
 }
 
 type NeighLinkEvent struct {
@@ -23,7 +30,8 @@ type NeighLinkEvent struct {
 	NeighLink
 }
 
-func getAllVethes() ([]NeighLink, error) {
+// getNeighVethes returns the veths holding the neigh of their pod, directly or through a bridge.
+func getNeighVethes() ([]NeighLink, error) {
 	links, err := netlink.LinkList()
 	if err != nil {
 		return nil, err
@@ -83,6 +91,56 @@ func getAllVethes() ([]NeighLink, error) {
 	return ans, nil
 }
 
+// getHostInterfaces returns the interfaces of the node holding an address, the one holding hostIP and the
+// other NICs of the multi-NIC nodes (e.g. a storage network). The neigh of a host interface is its address,
+// see hostInterfaceIP. An address is probed on a single interface, the first one holding it.
+func getHostInterfaces(hostIP string) ([]NeighLink, error) {
+	ip := net.ParseIP(hostIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid HOST_IP %q", hostIP)
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	ans := make([]NeighLink, 0)
+	seen := make(map[string]bool)
+	for _, l := range links {
+		addrs, err := netlink.AddrList(l, unix.AF_INET)
+		if err != nil {
+			return nil, err
+		}
+		if addr := hostInterfaceIP(l, addrs, ip); addr != nil && !seen[addr.String()] {
+			seen[addr.String()] = true
+			ans = append(ans, NeighLink{
+				Neigh: netlink.Neigh{LinkIndex: l.Attrs().Index, IP: addr},
+				Link:  l,
+			})
+		}
+	}
+	return ans, nil
+}
+
+// hostInterfaceIP returns the address of l probed for the hostNetwork pods, nil if l is not probed: hostIP
+// if l holds it, else the first global address of the NICs, bonds and vlans. The slaves of a bond are left
+// out, the frames they receive and send pass the bond as well and would be counted twice.
+func hostInterfaceIP(l netlink.Link, addrs []netlink.Addr, hostIP net.IP) net.IP {
+	for _, addr := range addrs {
+		if addr.IP.Equal(hostIP) {
+			return hostIP
+		}
+	}
+	attrs := l.Attrs()
+	if attrs.MasterIndex != 0 || attrs.Flags&net.FlagLoopback != 0 {
+		return nil
+	}
+	switch l.Type() {
+	case "device", "bond", "vlan", "team":
+		return globalIPv4(addrs)
+	}
+	return nil
+}
+
 func (p *provider) getVethesDiff() (added []NeighLink, removed []NeighLink, err error) {
 	neighs, err := getAllVethes()
 	if err != nil {
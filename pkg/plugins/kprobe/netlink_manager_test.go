@@ -0,0 +1,46 @@
+package kprobe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestHostInterfaceIP(t *testing.T) {
+	hostIP := net.ParseIP("192.168.0.10")
+	addrs := func(cidrs ...string) []netlink.Addr {
+		var ans []netlink.Addr
+		for _, cidr := range cidrs {
+			ip, ipNet, _ := net.ParseCIDR(cidr)
+			ipNet.IP = ip
+			ans = append(ans, netlink.Addr{IPNet: ipNet})
+		}
+		return ans
+	}
+	link := func(attrs netlink.LinkAttrs, linkType string) netlink.Link {
+		return &netlink.GenericLink{LinkAttrs: attrs, LinkType: linkType}
+	}
+
+	cases := []struct {
+		name   string
+		link   netlink.Link
+		addrs  []netlink.Addr
+		expect string
+	}{
+		{"bond holding the node ip", link(netlink.LinkAttrs{Index: 5}, "bond"), addrs("192.168.0.10/24"), "192.168.0.10"},
+		{"storage nic", link(netlink.LinkAttrs{Index: 3}, "device"), addrs("10.10.0.4/24"), "10.10.0.4"},
+		{"vlan", link(netlink.LinkAttrs{Index: 6}, "vlan"), addrs("10.20.0.4/24"), "10.20.0.4"},
+		{"bond slave", link(netlink.LinkAttrs{Index: 2, MasterIndex: 5}, "device"), nil, ""},
+		{"loopback", link(netlink.LinkAttrs{Index: 1, Flags: net.FlagLoopback}, "device"), addrs("127.0.0.1/8"), ""},
+		{"cni bridge", link(netlink.LinkAttrs{Index: 7}, "bridge"), addrs("10.244.0.1/24"), ""},
+		{"service dummy", link(netlink.LinkAttrs{Index: 8}, "dummy"), addrs("10.96.0.1/32"), ""},
+		{"nic without address", link(netlink.LinkAttrs{Index: 4}, "device"), nil, ""},
+	}
+	for _, c := range cases {
+		ip := hostInterfaceIP(c.link, c.addrs, hostIP)
+		if (c.expect == "" && ip != nil) || (c.expect != "" && ip.String() != c.expect) {
+			t.Errorf("%s: expected %q, got %v", c.name, c.expect, ip)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package kprobe
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// The kube-ovn pods are plugged into the OVS bridge br-int, the CNI holds neither the neigh nor the route
+// of the pods:
+//
+//	veth            the host end of the veth of a pod is named <container id>_h, the pod ip is the address
+//	                of its peer in the network namespace of the pod, see getPeerVethes
+//	internal port   the pods annotated ovn.kubernetes.io/pod_nic_type=internal-port have an OVS internal
+//	                port in their namespace and no interface on the node, see getInternalPorts. The ports
+//	                are discovered when EBPF_CNI is kube-ovn and probed within the namespace of the pod.
+
+// netNamespace is a network namespace of a process, id is the nsid of the namespace in the one of the node.
+type netNamespace struct {
+	path string
+	id   int
+}
+
+// peerIPs are the resolved pod ips by the index and the name of the veth, the namespaces are only listed
+// for the new veths.
+var peerIPs = struct {
+	sync.Mutex
+	ips map[string]net.IP
+}{ips: make(map[string]net.IP)}
+
+// listNetNamespaces returns the network namespaces of the processes of the node, but the one of the agent.
+func listNetNamespaces() ([]netNamespace, error) {
+	self, err := netns.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer self.Close()
+	paths, err := filepath.Glob("/proc/[0-9]*/ns/net")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{self.UniqueId(): true}
+	ans := make([]netNamespace, 0)
+	for _, path := range paths {
+		ns, err := netns.GetFromPath(path)
+		if err != nil {
+			continue
+		}
+		if id := ns.UniqueId(); !seen[id] {
+			seen[id] = true
+			nsid, err := netlink.GetNetNsIdByFd(int(ns))
+			if err != nil {
+				nsid = -1
+			}
+			ans = append(ans, netNamespace{path: path, id: nsid})
+		}
+		ns.Close()
+	}
+	return ans, nil
+}
+
+// getPeerVethes returns the veths found neither by neigh nor by route with the address of their peer,
+// known are the indexes of the veths found already.
+func getPeerVethes(known map[int]bool) ([]NeighLink, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	peerIPs.Lock()
+	defer peerIPs.Unlock()
+	live := make(map[string]bool)
+	var (
+		namespaces []netNamespace
+		listed     bool
+	)
+	ans := make([]NeighLink, 0)
+	for _, l := range links {
+		attrs := l.Attrs()
+		if l.Type() != "veth" || known[attrs.Index] || attrs.NetNsID < 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", attrs.Name, attrs.Index)
+		live[key] = true
+		ip, ok := peerIPs.ips[key]
+		if !ok {
+			if !listed {
+				namespaces, _ = listNetNamespaces()
+				listed = true
+			}
+			ip = peerIP(namespaces, attrs.NetNsID, attrs.ParentIndex)
+			peerIPs.ips[key] = ip
+		}
+		if ip != nil {
+			ans = append(ans, NeighLink{
+				Neigh: netlink.Neigh{LinkIndex: attrs.Index, IP: ip},
+				Link:  l,
+			})
+		}
+	}
+	for key := range peerIPs.ips {
+		if !live[key] {
+			delete(peerIPs.ips, key)
+		}
+	}
+	return ans, nil
+}
+
+// peerIP returns the address of the link of index in the namespace of nsid, nil if it has none.
+func peerIP(namespaces []netNamespace, nsid, index int) net.IP {
+	for _, ns := range namespaces {
+		if ns.id != nsid {
+			continue
+		}
+		h, err := handleAt(ns.path)
+		if err != nil {
+			return nil
+		}
+		defer h.Delete()
+		l, err := h.LinkByIndex(index)
+		if err != nil {
+			return nil
+		}
+		addrs, err := h.AddrList(l, unix.AF_INET)
+		if err != nil {
+			return nil
+		}
+		return globalIPv4(addrs)
+	}
+	return nil
+}
+
+// getInternalPorts returns the OVS internal ports holding the ip of a local pod, pods are the ips of the
+// pods without interface on the node. The ports are in the namespace NetNS of their pod.
+func getInternalPorts(pods map[string]bool) ([]NeighLink, error) {
+	namespaces, err := listNetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	ans := make([]NeighLink, 0)
+	for _, ns := range namespaces {
+		h, err := handleAt(ns.path)
+		if err != nil {
+			continue
+		}
+		links, err := h.LinkList()
+		if err != nil {
+			h.Delete()
+			continue
+		}
+		for _, l := range links {
+			if l.Type() != "openvswitch" {
+				continue
+			}
+			addrs, err := h.AddrList(l, unix.AF_INET)
+			if err != nil {
+				continue
+			}
+			if ip := globalIPv4(addrs); ip != nil && pods[ip.String()] {
+				ans = append(ans, NeighLink{
+					Neigh: netlink.Neigh{LinkIndex: l.Attrs().Index, IP: ip},
+					Link:  l,
+					NetNS: ns.path,
+				})
+			}
+		}
+		h.Delete()
+	}
+	return ans, nil
+}
+
+func handleAt(path string) (*netlink.Handle, error) {
+	ns, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+	return netlink.NewHandleAt(ns)
+}
+
+// globalIPv4 returns the first global ipv4 address of a link, nil if it has none.
+func globalIPv4(addrs []netlink.Addr) net.IP {
+	for _, addr := range addrs {
+		if ip := addr.IP.To4(); ip != nil && ip.IsGlobalUnicast() {
+			return ip
+		}
+	}
+	return nil
+}
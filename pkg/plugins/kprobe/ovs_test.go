@@ -0,0 +1,27 @@
+package kprobe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestGlobalIPv4(t *testing.T) {
+	addr := func(cidr string) netlink.Addr {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+		return netlink.Addr{IPNet: ipNet}
+	}
+
+	ip := globalIPv4([]netlink.Addr{addr("127.0.0.1/8"), addr("169.254.1.1/32"), addr("10.16.0.7/16")})
+	if ip.String() != "10.16.0.7" {
+		t.Errorf("expected the global address of the port, got %v", ip)
+	}
+	if ip := globalIPv4([]netlink.Addr{addr("fd00:10:16::7/64")}); ip != nil {
+		t.Errorf("expected no ipv4 address, got %v", ip)
+	}
+	if ip := globalIPv4(nil); ip != nil {
+		t.Errorf("expected no address, got %v", ip)
+	}
+}
@@ -0,0 +1,106 @@
+package kprobe
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const measurementStartup = "ebpf_plugin_startup"
+
+type SyncConfig struct {
+	// Timeout bounds the wait of the plugins, metrics converted after it may lack pod attribution.
+	Timeout time.Duration `env:"L7_CACHE_SYNC_TIMEOUT" default:"2m"`
+	// Queue bounds the events a plugin holds back while it waits, see WaitSyncedQueue.
+	Queue int `env:"L7_CACHE_SYNC_QUEUE" default:"10000"`
+}
+
+// WaitSynced blocks the event conversion of a plugin until the pod and service caches of k are synced.
+// It returns the startup gap metric of the plugin, the time its conversion was held back.
+func WaitSynced(k Interface, plugin string) *metric.Metric {
+	cfg := SyncConfig{}
+	envconf.MustLoad(&cfg)
+	m, _, _ := waitSynced[struct{}](k, plugin, nil, cfg, clock.Real)
+	return m
+}
+
+// WaitSyncedQueue is WaitSynced for the plugins converting the events of ch: ch keeps being drained into a
+// queue of at most L7_CACHE_SYNC_QUEUE events meanwhile, so that the drain loops of the kernel maps do not
+// block behind a full channel. The events beyond the queue are dropped and counted in the startup metric.
+// The plugin reads the events from the returned channel, the queued ones first.
+func WaitSyncedQueue[T any](k Interface, plugin string, ch <-chan T) (*metric.Metric, <-chan T) {
+	cfg := SyncConfig{}
+	envconf.MustLoad(&cfg)
+	return waitSyncedQueue(k, plugin, ch, cfg, clock.Real)
+}
+
+func waitSyncedQueue[T any](k Interface, plugin string, ch <-chan T, cfg SyncConfig, clk clock.Clock) (*metric.Metric, <-chan T) {
+	m, queue, dropped := waitSynced(k, plugin, ch, cfg, clk)
+	m.Fields["cache_sync_queued"] = len(queue)
+	m.Fields["cache_sync_dropped"] = dropped
+	if dropped > 0 {
+		klog.Warningf("plugin %s: %d events dropped while waiting for the pod and service caches, raise L7_CACHE_SYNC_QUEUE", plugin, dropped)
+	}
+	if len(queue) == 0 {
+		return m, ch
+	}
+	// the events of ch are forwarded behind the queue to keep their order.
+	out := make(chan T, cap(ch))
+	go func() {
+		for _, e := range queue {
+			out <- e
+		}
+		for e := range ch {
+			out <- e
+		}
+		close(out)
+	}()
+	return m, out
+}
+
+// waitSynced waits for the caches of k, the events of ch are queued meanwhile, nil ch queues none.
+func waitSynced[T any](k Interface, plugin string, ch <-chan T, cfg SyncConfig, clk clock.Clock) (*metric.Metric, []T, int) {
+	var (
+		start   = clk.Now()
+		timeout = clk.After(cfg.Timeout)
+		synced  = true
+		queue   []T
+		dropped int
+	)
+wait:
+	for {
+		select {
+		case <-k.Synced():
+			break wait
+		case <-timeout:
+			synced = false
+			klog.Warningf("plugin %s: pod and service caches not synced after %s, converting events anyway", plugin, cfg.Timeout)
+			break wait
+		case e := <-ch:
+			if len(queue) < cfg.Queue {
+				queue = append(queue, e)
+			} else {
+				dropped++
+			}
+		}
+	}
+	return &metric.Metric{
+		Name:        measurementStartup,
+		Measurement: measurementStartup,
+		Timestamp:   clk.Now().UnixNano(),
+		Tags: map[string]string{
+			"host":         os.Getenv("NODE_NAME"),
+			"plugin":       plugin,
+			"cache_synced": strconv.FormatBool(synced),
+		},
+		Fields: map[string]interface{}{
+			"cache_sync_wait": clk.Since(start).Nanoseconds(),
+		},
+	}, queue, dropped
+}
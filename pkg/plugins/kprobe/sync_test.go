@@ -0,0 +1,92 @@
+package kprobe
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+type syncedHelper struct {
+	Interface
+	synced chan struct{}
+}
+
+func (h *syncedHelper) Synced() <-chan struct{} { return h.synced }
+
+func TestWaitSynced(t *testing.T) {
+	cfg := SyncConfig{Timeout: 2 * time.Minute}
+	tests := []struct {
+		name   string
+		wait   time.Duration
+		synced bool
+	}{
+		{"synced", 30 * time.Second, true},
+		{"timeout", cfg.Timeout, false},
+	}
+	for _, tt := range tests {
+		clk := clock.NewFake(time.Unix(1000, 0))
+		h := &syncedHelper{synced: make(chan struct{})}
+		ch := make(chan *metric.Metric)
+		go func() {
+			m, _, _ := waitSynced[struct{}](h, "http", nil, cfg, clk)
+			ch <- m
+		}()
+
+		clk.BlockUntil(1)
+		clk.Add(tt.wait)
+		if tt.synced {
+			close(h.synced)
+		}
+		m := <-ch
+		if m.Tags["cache_synced"] != strconv.FormatBool(tt.synced) ||
+			m.Fields["cache_sync_wait"] != tt.wait.Nanoseconds() || m.Tags["plugin"] != "http" {
+			t.Errorf("%s: unexpected startup metric %+v", tt.name, m)
+		}
+	}
+}
+
+func TestWaitSyncedQueue(t *testing.T) {
+	cfg := SyncConfig{Timeout: 2 * time.Minute, Queue: 2}
+	clk := clock.NewFake(time.Unix(1000, 0))
+	h := &syncedHelper{synced: make(chan struct{})}
+	events := make(chan int)
+	type result struct {
+		m      *metric.Metric
+		events <-chan int
+	}
+	ch := make(chan result)
+	go func() {
+		m, out := waitSyncedQueue(h, "http", events, cfg, clk)
+		ch <- result{m, out}
+	}()
+
+	clk.BlockUntil(1)
+	// the events are drained while the caches sync, the ones beyond the queue are dropped.
+	for i := 1; i <= 3; i++ {
+		events <- i
+	}
+	close(h.synced)
+	r := <-ch
+	if r.m.Fields["cache_sync_queued"] != 2 || r.m.Fields["cache_sync_dropped"] != 1 {
+		t.Errorf("unexpected startup metric %+v", r.m)
+	}
+	go func() { events <- 4 }()
+	for _, expect := range []int{1, 2, 4} {
+		if e := <-r.events; e != expect {
+			t.Errorf("expected event %d, got %d", expect, e)
+		}
+	}
+}
+
+func TestWaitSyncedQueueEmpty(t *testing.T) {
+	h := &syncedHelper{synced: make(chan struct{})}
+	close(h.synced)
+	events := make(chan int)
+	m, out := waitSyncedQueue(h, "http", events, SyncConfig{Timeout: time.Minute, Queue: 2}, clock.NewFake(time.Unix(1000, 0)))
+	if m.Fields["cache_sync_queued"] != 0 || (<-chan int)(events) != out {
+		t.Errorf("expected the events to be read from the plugin channel without a queue, got %+v", m)
+	}
+}
@@ -2,9 +2,9 @@ package controller
 
 import (
 	"strings"
-	"time"
 
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
 	"github.com/erda-project/ebpf-agent/pkg/criruntime"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe/kprobesysctl"
@@ -28,6 +28,7 @@ type Controller struct {
 	runtimeFactory criruntime.Factory
 
 	kprobeHelper kprobe.Interface
+	clock        clock.Clock
 }
 
 func NewController(helper kprobe.Interface) Controller {
@@ -43,6 +44,7 @@ func NewController(helper kprobe.Interface) Controller {
 	//}
 	return Controller{
 		kprobeHelper: helper,
+		clock:        clock.Real,
 		//clientSet:      clientSet,
 		//config:         config,
 		//runtimeFactory: runtimeFactory,
@@ -104,7 +106,7 @@ func (c *Controller) convertOomEvent2Metric(event *oomprocesser2.OOMEvent, pod v
 	var metric metric.Metric
 	metric.Measurement = "docker_container_summary"
 	metric.Name = "docker_container_summary"
-	metric.Timestamp = time.Now().UnixNano()
+	metric.Timestamp = c.clock.Now().UnixNano()
 	metric.OrgName = pod.Labels["DICE_ORG_NAME"]
 	if len(pod.Status.ContainerStatuses) > 0 {
 		metric.AddTags("name", strings.TrimLeft(pod.Status.ContainerStatuses[0].ContainerID, "containerd://"))
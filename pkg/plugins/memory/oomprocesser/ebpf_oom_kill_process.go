@@ -13,6 +13,8 @@ import (
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/pkg/kernelbtf"
 )
 
 var (
@@ -28,7 +30,7 @@ func WatchOOM(ch chan<- *OOMEvent) {
 		log.Fatal(err)
 	}
 
-	coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	coll, err := ebpf.NewCollectionWithOptions(spec, kernelbtf.CollectionOptions(ebpf.CollectionOptions{}))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,344 @@
+// Package mirror copies the raw packets of a narrowly selected flow to a PCAP file or a tcp sink
+// for deep debugging.
+//
+// Sessions are started by an admin on the node through the local debug server of the agent
+// (localhost:8777, next to pprof), one at a time:
+//
+//	curl localhost:8777/debug/mirror                       # redaction policy and session state
+//	curl -XPOST localhost:8777/debug/mirror -d '{
+//	  "src_ip": "10.0.1.5", "dst_ip": "10.0.2.7", "dst_port": 8080,
+//	  "duration": "30s", "max_bytes": 10485760, "sink": "pcap",
+//	  "acknowledge_redaction_policy": "v1"}'
+//	curl -XDELETE localhost:8777/debug/mirror              # stop early
+//
+// A flow is either a 5-tuple (tcp, the source port may be left out for any client port) or
+// the service name and an http path prefix, the latter mirrors the connections of the pods of
+// the service on this node from their first request to the path on. The request must
+// acknowledge the current RedactionPolicyVersion, the payloads are only partially redacted.
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/utils"
+)
+
+const (
+	adminPath = "/debug/mirror"
+
+	SinkPcap = "pcap"
+	SinkTCP  = "tcp"
+)
+
+type Config struct {
+	// PcapDir is where the pcap sink writes, requests can not choose the path.
+	PcapDir     string        `env:"MIRROR_PCAP_DIR" default:"/tmp/ebpf-agent/mirror"`
+	MaxDuration time.Duration `env:"MIRROR_MAX_DURATION" default:"5m"`
+	MaxBytes    int64         `env:"MIRROR_MAX_BYTES" default:"104857600"`
+}
+
+// Request selects the flow and the bounds of a mirror session.
+type Request struct {
+	SrcIP   string `json:"src_ip,omitempty"`
+	SrcPort uint16 `json:"src_port,omitempty"`
+	DstIP   string `json:"dst_ip,omitempty"`
+	DstPort uint16 `json:"dst_port,omitempty"`
+
+	Service string `json:"service,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	// Duration is a go duration, e.g. 30s.
+	Duration string `json:"duration"`
+	MaxBytes int64  `json:"max_bytes"`
+	// Sink is pcap (the default) or tcp, TCPAddr is the host:port of the tcp sink.
+	Sink    string `json:"sink,omitempty"`
+	TCPAddr string `json:"tcp_addr,omitempty"`
+
+	AcknowledgeRedactionPolicy string `json:"acknowledge_redaction_policy"`
+}
+
+type provider struct {
+	sync.Mutex
+	Log logs.Logger
+
+	cfg          Config
+	kprobeHelper kprobe.Interface
+	active       *session
+	last         *Status
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	// served by the debug server of the agent on localhost only.
+	http.HandleFunc(adminPath, p.serveAdmin)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Close() error {
+	p.Lock()
+	s := p.active
+	p.Unlock()
+	if s != nil {
+		s.stop("agent closed")
+	}
+	return nil
+}
+
+func (p *provider) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p.writeState(w, http.StatusOK)
+	case http.MethodPost:
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if code, err := p.start(req); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		p.writeState(w, http.StatusCreated)
+	case http.MethodDelete:
+		p.Lock()
+		s := p.active
+		p.Unlock()
+		if s == nil {
+			http.Error(w, "no active mirror session", http.StatusNotFound)
+			return
+		}
+		s.stop("stopped by admin")
+		p.writeState(w, http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *provider) writeState(w http.ResponseWriter, code int) {
+	state := struct {
+		PolicyVersion string  `json:"redaction_policy_version"`
+		Policy        string  `json:"redaction_policy"`
+		Session       *Status `json:"session,omitempty"`
+	}{PolicyVersion: RedactionPolicyVersion, Policy: RedactionPolicy}
+	p.Lock()
+	if p.active != nil {
+		p.active.Lock()
+		status := p.active.status
+		p.active.Unlock()
+		state.Session = &status
+	} else {
+		state.Session = p.last
+	}
+	p.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+// validate normalizes the request, it returns the duration of the session.
+func (p *provider) validate(req *Request) (time.Duration, error) {
+	if req.AcknowledgeRedactionPolicy != RedactionPolicyVersion {
+		return 0, fmt.Errorf("the redaction policy %s must be acknowledged with \"acknowledge_redaction_policy\": %q: %s",
+			RedactionPolicyVersion, RedactionPolicyVersion, RedactionPolicy)
+	}
+	switch {
+	case req.Service != "":
+		if len(req.Path) < 2 || req.Path[0] != '/' {
+			return 0, fmt.Errorf("a service selection needs a path prefix below /")
+		}
+		if req.SrcIP != "" || req.DstIP != "" {
+			return 0, fmt.Errorf("select either a 5-tuple or a service and path")
+		}
+	case req.SrcIP != "" && req.DstIP != "" && req.DstPort != 0:
+		if req.Path != "" {
+			return 0, fmt.Errorf("path is only supported with a service selection")
+		}
+		for _, ip := range []string{req.SrcIP, req.DstIP} {
+			if _, err := ip4(ip); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		return 0, fmt.Errorf("select a flow by src_ip, dst_ip and dst_port or by service and path")
+	}
+
+	if req.Duration == "" {
+		return 0, fmt.Errorf("duration is required, at most %s", p.cfg.MaxDuration)
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 || duration > p.cfg.MaxDuration {
+		return 0, fmt.Errorf("invalid duration %q, at most %s", req.Duration, p.cfg.MaxDuration)
+	}
+	if req.MaxBytes <= 0 || req.MaxBytes > p.cfg.MaxBytes {
+		return 0, fmt.Errorf("max_bytes must be in (0, %d]", p.cfg.MaxBytes)
+	}
+
+	if req.Sink == "" {
+		req.Sink = SinkPcap
+	}
+	switch req.Sink {
+	case SinkPcap:
+		if req.TCPAddr != "" {
+			return 0, fmt.Errorf("tcp_addr is only used by the tcp sink")
+		}
+	case SinkTCP:
+		if _, _, err := net.SplitHostPort(req.TCPAddr); err != nil {
+			return 0, fmt.Errorf("invalid tcp_addr %q: %v", req.TCPAddr, err)
+		}
+	default:
+		return 0, fmt.Errorf("unknown sink %q, want %s or %s", req.Sink, SinkPcap, SinkTCP)
+	}
+	return duration, nil
+}
+
+func (p *provider) start(req Request) (int, error) {
+	duration, err := p.validate(&req)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.active != nil {
+		return http.StatusConflict, fmt.Errorf("a mirror session is already active")
+	}
+
+	ifIndexes, sel, servers, err := p.resolve(&req)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	now := p.clock.Now()
+	s := &session{
+		status: Status{
+			Request:  req,
+			Started:  now,
+			Deadline: now.Add(duration),
+			Active:   true,
+		},
+		servers: servers,
+		conns:   make(map[flow]bool),
+		stopper: make(chan struct{}),
+		clock:   p.clock,
+	}
+	if err := p.openSink(s, now); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := s.attach(ifIndexes, sel); err != nil {
+		s.close()
+		return http.StatusInternalServerError, fmt.Errorf("failed to attach mirror program: %v", err)
+	}
+	s.onDone = func(status Status) {
+		p.Log.Infof("mirror session to %s ended (%s), %d packets, %d bytes", status.Target, status.Reason, status.Packets, status.Bytes)
+		p.Lock()
+		defer p.Unlock()
+		if p.active == s {
+			p.active = nil
+			p.last = &status
+		}
+	}
+	p.active = s
+	p.Log.Infof("mirror session started, selection: %+v, redaction policy %s acknowledged, sink: %s, deadline: %s",
+		req, req.AcknowledgeRedactionPolicy, s.status.Target, s.status.Deadline.Format(time.RFC3339))
+	return 0, nil
+}
+
+// resolve finds the veths of the selected pods on this node.
+func (p *provider) resolve(req *Request) ([]int, selector, map[[4]byte]bool, error) {
+	var sel selector
+	vethes, err := p.kprobeHelper.GetVethes()
+	if err != nil {
+		return nil, sel, nil, err
+	}
+	if req.Service != "" {
+		// all tcp traffic of the pods, the connections are selected by path in user space.
+		var ifIndexes []int
+		servers := make(map[[4]byte]bool)
+		for _, v := range vethes {
+			ip := v.Neigh.IP.String()
+			pod, err := p.kprobeHelper.GetPodByUID(ip)
+			if err != nil || pod.Annotations["msp.erda.cloud/service_name"] != req.Service {
+				continue
+			}
+			addr, err := ip4(ip)
+			if err != nil {
+				continue
+			}
+			servers[addr] = true
+			ifIndexes = append(ifIndexes, v.Link.Attrs().Index)
+		}
+		if len(ifIndexes) == 0 {
+			return nil, sel, nil, fmt.Errorf("no pod of service %s on this node", req.Service)
+		}
+		return ifIndexes, sel, servers, nil
+	}
+
+	sel = selector{
+		IPA:   utils.Htonl(utils.IP4toDec(req.SrcIP)),
+		IPB:   utils.Htonl(utils.IP4toDec(req.DstIP)),
+		PortA: req.SrcPort,
+		PortB: req.DstPort,
+	}
+	// the packets of pods talking to each other on the node pass both veths, the client side is captured.
+	for _, ip := range []string{req.SrcIP, req.DstIP} {
+		for _, v := range vethes {
+			if v.Neigh.IP.String() == ip {
+				return []int{v.Link.Attrs().Index}, sel, nil, nil
+			}
+		}
+	}
+	return nil, sel, nil, fmt.Errorf("neither %s nor %s is a pod on this node", req.SrcIP, req.DstIP)
+}
+
+func (p *provider) openSink(s *session, now time.Time) error {
+	switch s.status.Request.Sink {
+	case SinkTCP:
+		conn, err := net.DialTimeout("tcp", s.status.Request.TCPAddr, 3*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to connect to the tcp sink: %v", err)
+		}
+		s.sink, s.status.Target = conn, "tcp://"+s.status.Request.TCPAddr
+	default:
+		if err := os.MkdirAll(p.cfg.PcapDir, 0700); err != nil {
+			return err
+		}
+		path := filepath.Join(p.cfg.PcapDir, fmt.Sprintf("mirror-%s.pcap", now.Format("20060102-150405")))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		s.sink, s.status.Target = file, path
+	}
+	writer, err := newPcapWriter(s.sink)
+	if err != nil {
+		s.sink.Close()
+		return err
+	}
+	s.writer = writer
+	return nil
+}
+
+func init() {
+	servicehub.Register("mirror", &servicehub.Spec{
+		Services:     []string{"mirror"},
+		Description:  "admin triggered mirroring of selected flows to a pcap file or a tcp sink",
+		Dependencies: []string{"kprobe"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
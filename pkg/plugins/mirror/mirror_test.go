@@ -0,0 +1,83 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	p := &provider{cfg: Config{MaxDuration: 5 * time.Minute, MaxBytes: 1 << 20}}
+	valid := Request{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", DstPort: 8080, Duration: "30s", MaxBytes: 1024, AcknowledgeRedactionPolicy: RedactionPolicyVersion}
+	if _, err := p.validate(&valid); err != nil || valid.Sink != SinkPcap {
+		t.Fatalf("unexpected error %v, sink %s", err, valid.Sink)
+	}
+	invalid := map[string]func(r *Request){
+		"no acknowledgement": func(r *Request) { r.AcknowledgeRedactionPolicy = "" },
+		"no dst port":        func(r *Request) { r.DstPort = 0 },
+		"too long":           func(r *Request) { r.Duration = "1h" },
+		"too many bytes":     func(r *Request) { r.MaxBytes = 1 << 30 },
+		"service and tuple":  func(r *Request) { r.Service, r.Path = "order", "/api" },
+		"root path":          func(r *Request) { r.SrcIP, r.DstIP, r.Service, r.Path = "", "", "order", "/" },
+		"tcp sink":           func(r *Request) { r.Sink = SinkTCP },
+	}
+	for name, modify := range invalid {
+		r := valid
+		modify(&r)
+		if _, err := p.validate(&r); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	payload := []byte("GET /api HTTP/1.1\r\nHost: a\r\nauthorization: Bearer abc\r\nCookie: sid=1\r\nX-Api-Key:k\r\n\r\n")
+	redact(payload)
+	want := "GET /api HTTP/1.1\r\nHost: a\r\nauthorization: ****** ***\r\nCookie: *****\r\nX-Api-Key:*\r\n\r\n"
+	if string(payload) != want {
+		t.Errorf("unexpected redaction %q", payload)
+	}
+}
+
+func TestSelected(t *testing.T) {
+	frame := func(src, dst [4]byte, sport, dport uint16, payload string) []byte {
+		b := make([]byte, 14+20+20)
+		binary.BigEndian.PutUint16(b[12:14], 0x0800)
+		ip := b[14:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(40+len(payload)))
+		ip[9] = 6
+		copy(ip[12:16], src[:])
+		copy(ip[16:20], dst[:])
+		tcp := ip[20:]
+		binary.BigEndian.PutUint16(tcp[0:2], sport)
+		binary.BigEndian.PutUint16(tcp[2:4], dport)
+		tcp[12] = 5 << 4
+		return append(b, payload...)
+	}
+	client, server := [4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}
+	s := &session{
+		status:  Status{Request: Request{Service: "order", Path: "/api/orders"}, Active: true},
+		servers: map[[4]byte]bool{server: true},
+		conns:   make(map[flow]bool),
+	}
+	cases := []struct {
+		frame []byte
+		want  bool
+	}{
+		{frame(client, server, 40000, 8080, "GET /health HTTP/1.1\r\n"), false},
+		{frame(client, server, 40001, 8080, "POST /api/orders/1 HTTP/1.1\r\n"), true},
+		{frame(server, client, 8080, 40001, "HTTP/1.1 200 OK\r\n"), true},
+		{frame(server, client, 8080, 40000, "HTTP/1.1 200 OK\r\n"), false},
+	}
+	for i, c := range cases {
+		f, payload, ok := parseFrame(c.frame)
+		if !ok {
+			t.Fatalf("%d: failed to parse frame", i)
+		}
+		if got := s.selected(f, payload); got != c.want {
+			t.Errorf("%d: selected %v, want %v (%s)", i, got, c.want, strings.SplitN(string(payload), "\r\n", 2)[0])
+		}
+	}
+}
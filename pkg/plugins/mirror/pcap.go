@@ -0,0 +1,45 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// see https://wiki.wireshark.org/Development/LibpcapFileFormat
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnapLen      = 65535
+	linkTypeEthernet = 1
+)
+
+// pcapWriter writes the frames unbuffered, so that a tcp sink can follow the capture live,
+// e.g. `nc -l 9999 | wireshark -k -i -`.
+type pcapWriter struct {
+	w io.Writer
+}
+
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(header[6:8], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+// write returns the number of bytes written including the record header.
+func (p *pcapWriter) write(ts time.Time, frame []byte) (int, error) {
+	record := make([]byte, 16, 16+len(frame))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+	return p.w.Write(append(record, frame...))
+}
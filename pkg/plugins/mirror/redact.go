@@ -0,0 +1,48 @@
+package mirror
+
+import (
+	"bytes"
+)
+
+// RedactionPolicyVersion must be acknowledged by the requests of mirror sessions,
+// it changes whenever RedactionPolicy does.
+const RedactionPolicyVersion = "v1"
+
+const RedactionPolicy = "Payloads are copied verbatim except the values of the HTTP headers " +
+	"Authorization, Proxy-Authorization, Cookie, Set-Cookie and X-Api-Key, which are replaced by '*'. " +
+	"Headers split across packets, bodies, query strings and all other protocols are NOT redacted and may " +
+	"contain credentials or personal data. The capture is written unencrypted to the sink."
+
+// redactedHeaders are the lower case header names with the colon.
+var redactedHeaders = [][]byte{
+	[]byte("authorization:"),
+	[]byte("proxy-authorization:"),
+	[]byte("cookie:"),
+	[]byte("set-cookie:"),
+	[]byte("x-api-key:"),
+}
+
+// redact overwrites the values of the redacted headers in the tcp payload in place,
+// the length of the payload is kept so that the frame stays consistent.
+func redact(payload []byte) {
+	for rest := payload; len(rest) > 0; {
+		line := rest
+		if i := bytes.Index(rest, []byte("\r\n")); i >= 0 {
+			line, rest = rest[:i], rest[i+2:]
+		} else {
+			rest = nil
+		}
+		for _, name := range redactedHeaders {
+			if len(line) < len(name) || !bytes.EqualFold(line[:len(name)], name) {
+				continue
+			}
+			value := line[len(name):]
+			for i := range value {
+				if value[i] != ' ' && value[i] != '\t' {
+					value[i] = '*'
+				}
+			}
+			break
+		}
+	}
+}
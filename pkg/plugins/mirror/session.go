@@ -0,0 +1,280 @@
+package mirror
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/utils"
+)
+
+const (
+	programPath = "target/mirror.bpf.o"
+	programName = "socket__mirror_filter"
+	mapSelector = "mirror_selector_map"
+
+	SO_ATTACH_BPF = 0x32 // 50
+
+	// readTimeout bounds how long the readers take to notice the end of the session.
+	readTimeout = 200 * time.Millisecond
+)
+
+// selector is mirror_selector_t of ebpf/plugins/mirror/main.c.
+type selector struct {
+	IPA   uint32
+	IPB   uint32
+	PortA uint16
+	PortB uint16
+}
+
+// flow is the tcp 4-tuple of a frame.
+type flow struct {
+	srcIP, dstIP     [4]byte
+	srcPort, dstPort uint16
+}
+
+func (f flow) reverse() flow {
+	return flow{srcIP: f.dstIP, dstIP: f.srcIP, srcPort: f.dstPort, dstPort: f.srcPort}
+}
+
+// Status is the state of a mirror session, as served by the admin endpoint.
+type Status struct {
+	Request  Request   `json:"request"`
+	Target   string    `json:"target"`
+	Started  time.Time `json:"started"`
+	Deadline time.Time `json:"deadline"`
+	Bytes    int64     `json:"bytes"`
+	Packets  int64     `json:"packets"`
+	Active   bool      `json:"active"`
+	// Reason is why the session ended, e.g. the duration or the byte limit was reached.
+	Reason string `json:"reason,omitempty"`
+}
+
+type session struct {
+	sync.Mutex
+	status     Status
+	servers    map[[4]byte]bool
+	conns      map[flow]bool
+	collection *ebpf.Collection
+	socks      []int
+	sink       io.WriteCloser
+	writer     *pcapWriter
+	stopper    chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+	onDone     func(Status)
+	clock      clock.Clock
+}
+
+// attach captures the frames of the selected flow on the veths until the deadline, the byte limit or stop.
+// servers are the pod ips of a service selection, their connections are mirrored from the first
+// request to the selected path on.
+func (s *session) attach(ifIndexes []int, sel selector) error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	s.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	if err := s.collection.Maps[mapSelector].Put(uint32(0), sel); err != nil {
+		return err
+	}
+	program := s.collection.Programs[programName]
+	if program == nil {
+		return fmt.Errorf("program %s not found", programName)
+	}
+	for _, index := range ifIndexes {
+		sock, err := utils.OpenRawSock(index)
+		if err != nil {
+			return err
+		}
+		s.socks = append(s.socks, sock)
+		if err := syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, program.FD()); err != nil {
+			return err
+		}
+		if err := syscall.SetNonblock(sock, false); err != nil {
+			return err
+		}
+		tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+		if err := unix.SetsockoptTimeval(sock, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return err
+		}
+	}
+	for _, sock := range s.socks {
+		s.wg.Add(1)
+		go s.read(sock)
+	}
+	go func() {
+		select {
+		case <-s.clock.After(s.status.Deadline.Sub(s.clock.Now())):
+			s.stop("duration reached")
+		case <-s.stopper:
+		}
+	}()
+	return nil
+}
+
+func (s *session) read(sock int) {
+	defer s.wg.Done()
+	buf := make([]byte, pcapSnapLen)
+	for {
+		select {
+		case <-s.stopper:
+			return
+		default:
+		}
+		n, _, err := syscall.Recvfrom(sock, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			go s.stop(fmt.Sprintf("read error: %v", err))
+			return
+		}
+		if reason := s.write(s.clock.Now(), buf[:n]); reason != "" {
+			go s.stop(reason)
+			return
+		}
+	}
+}
+
+// write returns the reason to end the session, empty to go on.
+func (s *session) write(ts time.Time, frame []byte) string {
+	f, payload, ok := parseFrame(frame)
+	if !ok {
+		return ""
+	}
+	s.Lock()
+	defer s.Unlock()
+	if !s.status.Active {
+		return ""
+	}
+	if !s.selected(f, payload) {
+		return ""
+	}
+	if s.status.Bytes+int64(16+len(frame)) > s.status.Request.MaxBytes {
+		return "byte limit reached"
+	}
+	redact(payload)
+	n, err := s.writer.write(ts, frame)
+	s.status.Bytes += int64(n)
+	if err != nil {
+		return fmt.Sprintf("sink error: %v", err)
+	}
+	s.status.Packets++
+	return ""
+}
+
+// selected reports whether the frame belongs to the selection, it is called with the lock held.
+func (s *session) selected(f flow, payload []byte) bool {
+	if s.status.Request.Path == "" {
+		return true
+	}
+	// connections are keyed in the client -> server direction
+	toServer := s.servers[f.dstIP]
+	key := f
+	if !toServer {
+		key = f.reverse()
+	}
+	if s.conns[key] {
+		return true
+	}
+	if toServer && isRequestTo(payload, s.status.Request.Path) {
+		s.conns[key] = true
+		return true
+	}
+	return false
+}
+
+func (s *session) stop(reason string) {
+	s.stopOnce.Do(func() {
+		close(s.stopper)
+		s.wg.Wait()
+		s.Lock()
+		s.status.Active = false
+		s.status.Reason = reason
+		status := s.status
+		s.Unlock()
+		s.close()
+		if s.onDone != nil {
+			s.onDone(status)
+		}
+	})
+}
+
+func (s *session) close() {
+	for _, sock := range s.socks {
+		_ = syscall.Close(sock)
+	}
+	if s.collection != nil {
+		s.collection.Close()
+	}
+	if s.sink != nil {
+		_ = s.sink.Close()
+	}
+}
+
+// parseFrame returns the tcp 4-tuple and the payload of an ethernet frame of an ipv4 tcp packet.
+func parseFrame(frame []byte) (flow, []byte, bool) {
+	var f flow
+	const ethLen = 14
+	if len(frame) < ethLen+20 || binary.BigEndian.Uint16(frame[12:14]) != syscall.ETH_P_IP {
+		return f, nil, false
+	}
+	ip := frame[ethLen:]
+	ihl := int(ip[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(ip[2:4]))
+	if ip[9] != syscall.IPPROTO_TCP || ihl < 20 || totalLen > len(ip) || ihl+20 > totalLen {
+		return f, nil, false
+	}
+	copy(f.srcIP[:], ip[12:16])
+	copy(f.dstIP[:], ip[16:20])
+	tcp := ip[ihl:totalLen]
+	f.srcPort = binary.BigEndian.Uint16(tcp[0:2])
+	f.dstPort = binary.BigEndian.Uint16(tcp[2:4])
+	offset := int(tcp[12]>>4) * 4
+	if offset < 20 || offset > len(tcp) {
+		return f, nil, false
+	}
+	return f, tcp[offset:], true
+}
+
+// isRequestTo reports whether the payload starts an http request with a path under prefix.
+func isRequestTo(payload []byte, prefix string) bool {
+	i := bytes.IndexByte(payload, ' ')
+	if i <= 0 || i > 8 {
+		return false
+	}
+	for _, c := range payload[:i] {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return bytes.HasPrefix(payload[i+1:], []byte(prefix))
+}
+
+func ip4(s string) ([4]byte, error) {
+	var ans [4]byte
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return ans, fmt.Errorf("invalid ipv4 address %q", s)
+	}
+	copy(ans[:], ip)
+	return ans, nil
+}
@@ -0,0 +1,40 @@
+package netfilter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+)
+
+// natKey is the key of the nat records: the original source of the connection.
+func natKey(ip string, port uint16) string {
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+// ServiceAddress returns the address the client connected to before the DNAT, e.g. the ClusterIP:port of
+// a k8s service.
+func (n NatInfo) ServiceAddress() string {
+	return net.JoinHostPort(n.OriDstIP, strconv.Itoa(int(n.OriDstPort)))
+}
+
+// natFromFlow returns the nat record of a conntrack flow, false if the destination of the flow was not
+// translated. The dns flows are left out, as in kernel.
+func natFromFlow(f *netlink.ConntrackFlow) (string, NatInfo, bool) {
+	if f.Forward.SrcIP == nil || f.Forward.DstIP == nil || f.Reverse.SrcIP == nil {
+		return "", NatInfo{}, false
+	}
+	if f.Forward.SrcPort == 53 || f.Forward.DstPort == 53 {
+		return "", NatInfo{}, false
+	}
+	if f.Reverse.SrcIP.Equal(f.Forward.DstIP) && f.Reverse.SrcPort == f.Forward.DstPort {
+		return "", NatInfo{}, false
+	}
+	return natKey(f.Forward.SrcIP.String(), f.Forward.SrcPort), NatInfo{
+		OriDstIP:     f.Forward.DstIP.String(),
+		OriDstPort:   f.Forward.DstPort,
+		ReplyDstIP:   f.Reverse.SrcIP.String(),
+		ReplyDstPort: f.Reverse.SrcPort,
+	}, true
+}
@@ -0,0 +1,34 @@
+package netfilter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func flow(src string, sport uint16, dst string, dport uint16, replySrc string, replySport uint16) *netlink.ConntrackFlow {
+	f := &netlink.ConntrackFlow{}
+	f.Forward.SrcIP, f.Forward.SrcPort = net.ParseIP(src), sport
+	f.Forward.DstIP, f.Forward.DstPort = net.ParseIP(dst), dport
+	f.Reverse.SrcIP, f.Reverse.SrcPort = net.ParseIP(replySrc), replySport
+	f.Reverse.DstIP, f.Reverse.DstPort = net.ParseIP(src), sport
+	return f
+}
+
+func TestNatFromFlow(t *testing.T) {
+	key, nat, ok := natFromFlow(flow("10.0.0.1", 40000, "172.16.0.10", 80, "10.0.1.5", 8080))
+	if !ok || key != "10.0.0.1:40000" {
+		t.Fatalf("expected the record of the client, got %s %v", key, ok)
+	}
+	if nat.ReplyDstIP != "10.0.1.5" || nat.ReplyDstPort != 8080 || nat.ServiceAddress() != "172.16.0.10:80" {
+		t.Errorf("unexpected record %+v", nat)
+	}
+
+	if _, _, ok := natFromFlow(flow("10.0.0.1", 40000, "10.0.1.5", 8080, "10.0.1.5", 8080)); ok {
+		t.Error("the flows without DNAT have no record")
+	}
+	if _, _, ok := natFromFlow(flow("10.0.0.1", 40000, "172.16.0.10", 53, "10.0.1.9", 53)); ok {
+		t.Error("the dns flows are left out")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 
 	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/pkg/kernelbtf"
 )
 
 func RunEbpf() *NetfilterObjects {
@@ -14,11 +16,12 @@ func RunEbpf() *NetfilterObjects {
 		panic(err)
 	}
 	var bpfObj NetfilterObjects
-	if err := spec.LoadAndAssign(&bpfObj, &ebpf.CollectionOptions{
+	opts := kernelbtf.CollectionOptions(ebpf.CollectionOptions{
 		Programs: ebpf.ProgramOptions{
 			LogSize: ebpf.DefaultVerifierLogSize * 10,
 		},
-	}); err != nil {
+	})
+	if err := spec.LoadAndAssign(&bpfObj, &opts); err != nil {
 		panic(err)
 	}
 	return &bpfObj
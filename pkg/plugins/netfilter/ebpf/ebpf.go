@@ -9,6 +9,9 @@ import (
 	"k8s.io/klog"
 	"log"
 	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/kernelbtf"
 )
 
 type Ebpf struct {
@@ -19,15 +22,17 @@ type Ebpf struct {
 
 	kprobeIptDoTableKP    link.Link
 	kretprobeIptDoTableKP link.Link
+
+	clock clock.Clock
 }
 
 func NewEbpf() *Ebpf {
-	return &Ebpf{}
+	return &Ebpf{clock: clock.Real}
 }
 
 func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 	var err error
-	e.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	e.collection, err = ebpf.NewCollectionWithOptions(spec, kernelbtf.CollectionOptions(ebpf.CollectionOptions{}))
 	if err != nil {
 		return err
 	}
@@ -63,7 +68,7 @@ func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 					panic(err)
 				}
 			}
-			time.Sleep(1 * time.Second)
+			e.clock.Sleep(1 * time.Second)
 		}
 	}()
 	return nil
@@ -1,15 +1,24 @@
+// Package netfilter resolves the connections the destination of which was translated (DNAT), e.g. the
+// connections to the ClusterIP of a k8s service translated to a pod by kube-proxy. The translations are
+// recorded on nf_nat_setup_info as the connections are set up, and the conntrack table of the node is
+// read again every NETFILTER_CONNTRACK_INTERVAL (30s) for the connections set up before the agent started
+// and the long-lived connections. The records are kept by the original source ip:port of the connection,
+// so that both the client and the server side captures of a connection are resolved to the same service
+// address (NatInfo.ServiceAddress) and backend (ReplyDstIP:ReplyDstPort).
 package netfilter
 
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
 	netebpf "github.com/erda-project/ebpf-agent/pkg/plugins/netfilter/ebpf"
 	"github.com/erda-project/erda-infra/base/servicehub"
 	"github.com/patrickmn/go-cache"
+	"github.com/vishvananda/netlink"
 	"k8s.io/klog"
 	"net"
 	"time"
@@ -17,11 +26,19 @@ import (
 
 type Interface interface {
 	GetNatInfo(ip string, port uint16) (NatInfo, bool)
+	// NatEntries returns the unexpired nat records of this node keyed by the original source ip:port.
+	NatEntries() map[string]NatInfo
+}
+
+type Config struct {
+	ConntrackInterval time.Duration `env:"NETFILTER_CONNTRACK_INTERVAL" default:"30s"`
 }
 
 type provider struct {
+	cfg        Config
 	natEbpfMap *ebpf.Map
 	natCache   *cache.Cache
+	clock      clock.Clock
 }
 
 type NatInfo struct {
@@ -32,18 +49,29 @@ type NatInfo struct {
 }
 
 func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
 	p.natCache = cache.New(time.Minute, 10*time.Second)
+	p.clock = clock.Real
 	return nil
 }
 
 func (p *provider) GetNatInfo(ip string, port uint16) (NatInfo, bool) {
-	natInfo, ok := p.natCache.Get(fmt.Sprintf("%s:%d", ip, port))
+	natInfo, ok := p.natCache.Get(natKey(ip, port))
 	if !ok {
 		return NatInfo{}, false
 	}
 	return natInfo.(NatInfo), true
 }
 
+func (p *provider) NatEntries() map[string]NatInfo {
+	items := p.natCache.Items()
+	ans := make(map[string]NatInfo, len(items))
+	for k, v := range items {
+		ans[k] = v.Object.(NatInfo)
+	}
+	return ans
+}
+
 func (p *provider) Gather(c chan *metric.Metric) {
 	obj := netebpf.RunEbpf()
 	kpNat, err := link.Kprobe("nf_nat_setup_info", obj.K_natSetUpInfo, nil)
@@ -57,6 +85,7 @@ func (p *provider) Gather(c chan *metric.Metric) {
 		panic(err)
 	}
 	defer krpNat.Close()
+	go p.syncConntrack()
 
 	for {
 		var (
@@ -81,7 +110,30 @@ func (p *provider) Gather(c chan *metric.Metric) {
 				ReplyDstIP:   replyDstIP.String(),
 				ReplyDstPort: event.Sport,
 			}
-			p.natCache.Set(fmt.Sprintf("%s:%d", srcIP, event.OriSport), natInfo, time.Minute)
+			p.natCache.Set(natKey(srcIP.String(), event.OriSport), natInfo, time.Minute)
+		}
+	}
+}
+
+// syncConntrack records the translated connections of the conntrack table every ConntrackInterval, they
+// are kept until the following read misses them.
+func (p *provider) syncConntrack() {
+	ttl := 2 * p.cfg.ConntrackInterval
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	ticker := p.clock.NewTicker(p.cfg.ConntrackInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C() {
+		flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, netlink.FAMILY_V4)
+		if err != nil {
+			klog.Warningf("failed to list the conntrack table: %v", err)
+			continue
+		}
+		for _, f := range flows {
+			if key, natInfo, ok := natFromFlow(f); ok {
+				p.natCache.Set(key, natInfo, ttl)
+			}
 		}
 	}
 }
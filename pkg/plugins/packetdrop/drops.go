@@ -0,0 +1,65 @@
+package packetdrop
+
+import (
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const (
+	measurement = "application_packet_drop"
+
+	// directionEgress are the packets sent by the pod, directionIngress the packets to the pod.
+	directionEgress  = "egress"
+	directionIngress = "ingress"
+)
+
+type dropKey struct {
+	pod       string
+	direction string
+	reason    string
+}
+
+type drop struct {
+	tags    map[string]string
+	orgName string
+	drops   uint64
+}
+
+// drops aggregates the dropped packets between two reports by pod, direction and reason.
+type drops struct {
+	entries map[dropKey]*drop
+}
+
+func newDrops() *drops {
+	return &drops{entries: make(map[dropKey]*drop)}
+}
+
+// observe adds count drops, pod carries the tags of the pod the drops are attributed to, the tags of the
+// first drops of a key are reported.
+func (ds *drops) observe(pod *metric.Metric, direction, reason string, count uint64) {
+	k := dropKey{pod: pod.Tags["source_service_instance_id"], direction: direction, reason: reason}
+	d, ok := ds.entries[k]
+	if !ok {
+		d = &drop{tags: pod.Tags, orgName: pod.OrgName}
+		d.tags["direction"] = direction
+		d.tags["drop_reason"] = reason
+		ds.entries[k] = d
+	}
+	d.drops += count
+}
+
+// report returns the drops since the previous report.
+func (ds *drops) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(ds.entries))
+	for _, d := range ds.entries {
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			OrgName:     d.orgName,
+			Tags:        d.tags,
+			Fields:      map[string]interface{}{"drops": d.drops},
+		})
+	}
+	ds.entries = make(map[dropKey]*drop)
+	return ans
+}
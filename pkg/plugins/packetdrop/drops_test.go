@@ -0,0 +1,37 @@
+package packetdrop
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestDrops(t *testing.T) {
+	ds := newDrops()
+	pod := func() *metric.Metric {
+		return &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"source_service_instance_id": "api-1",
+			"source_service_name":        "api",
+		}}
+	}
+	ds.observe(pod(), directionIngress, "NETFILTER_DROP", 3)
+	ds.observe(pod(), directionIngress, "NETFILTER_DROP", 2)
+	ds.observe(pod(), directionEgress, "NETFILTER_DROP", 1)
+	ds.observe(pod(), directionIngress, "TCP_CSUM", 1)
+
+	reported := ds.report(1000)
+	if len(reported) != 3 {
+		t.Fatalf("expected a metric per direction and reason, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurement || m.OrgName != "erda" || m.Tags["source_service_name"] != "api" {
+			t.Errorf("unexpected drops %+v", m)
+		}
+		if m.Tags["direction"] == directionIngress && m.Tags["drop_reason"] == "NETFILTER_DROP" && m.Fields["drops"] != uint64(5) {
+			t.Errorf("unexpected fields %v", m.Fields)
+		}
+	}
+	if len(ds.report(2000)) != 0 {
+		t.Error("the drops are reported once")
+	}
+}
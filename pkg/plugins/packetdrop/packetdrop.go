@@ -0,0 +1,292 @@
+// Package packetdrop reports the packets the kernel drops for the pods of the node, so that the silent
+// packet loss within the node (netfilter rules, full queues, checksum errors, missing sockets, ...) is
+// visible. The drops are counted in kernel on the skb:kfree_skb tracepoint by device, addresses and drop
+// reason, and reported by pod every PACKET_DROP_INTERVAL (1m) in application_packet_drop:
+//
+//	tags     source_* of the pod
+//	         direction     egress for the packets sent by the pod, ingress for the packets to it
+//	         drop_reason   the reason of the kernel (e.g. NETFILTER_DROP, NO_SOCKET, TCP_CSUM),
+//	                       unknown before 5.17, see the format of the tracepoint
+//	         metric_source, _meta, _metric_scope, _metric_scope_id, org_name, cluster_name
+//	fields   drops         packets dropped in the interval
+//
+// The drops are attributed to the pod of the veth the packet was dropped on, otherwise to the pod of the
+// node it was sent by or sent to. The ipv4 packets only are counted, the drops of the node are left out.
+//
+// The packets dropped by the netfilter verdicts (iptables rules, NetworkPolicies) are reported by source
+// and intended destination in application_network_policy_drop, so that a misconfigured policy shows up
+// instead of the timeouts of the connections. They are the drops with reason NETFILTER_DROP, on the
+// kernels without reasons the drop verdicts of nf_hook_slow (kprobe and kretprobe):
+//
+//	tags     source_* of the sending pod, source_address of its ip
+//	         target_* of the destination pod, peer_service of the destination k8s service,
+//	         peer_address of the destination ip:port, protocol (tcp, udp, icmp)
+//	         metric_source, _meta, _metric_scope, _metric_scope_id, org_name, cluster_name
+//	fields   drops         packets dropped in the interval
+//
+// The drops of which neither end is a pod are left out. The tracepoint is limited by the event budget
+// of the kfree_skb probe (see the eventbudget package), the drops above it are left out and reported in
+// ebpf_event_budget.
+package packetdrop
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/eventbudget"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+)
+
+const (
+	programPath = "target/packetdrop.bpf.o"
+	programName = "tracepoint_kfree_skb"
+	mapDrops    = "drops_map"
+	mapPolicy   = "policy_drops_map"
+	// mapNetfilterReason holds the value of NETFILTER_DROP of the kernel.
+	mapNetfilterReason = "netfilter_reason_map"
+)
+
+// budgetProbes maps the tracepoint to its event budget id, KFREE_SKB_PROBE of ebpf/plugins/packetdrop/main.c
+var budgetProbes = map[string]uint32{
+	"kfree_skb": 0,
+}
+
+type Config struct {
+	Interval time.Duration `env:"PACKET_DROP_INTERVAL" default:"1m"`
+}
+
+// rawDropKey mirrors struct drop_key_t of ebpf/plugins/packetdrop/main.c.
+type rawDropKey struct {
+	IfIndex  uint32
+	SourceIP [4]byte
+	DestIP   [4]byte
+	Reason   uint32
+}
+
+type provider struct {
+	Log logs.Logger
+
+	cfg          Config
+	kprobeHelper kprobe.Interface
+	collection   *ebpf.Collection
+	links        []link.Link
+	budget       *eventbudget.Guard
+	reasons      reasons
+	drops        *drops
+	policies     *policies
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.reasons = loadReasons()
+	p.drops = newDrops()
+	p.policies = newPolicies()
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	if err := p.load(); err != nil {
+		p.Log.Errorf("failed to load packet drop ebpf program, err: %v", err)
+		return
+	}
+	if !p.reasons.supported {
+		p.Log.Warnf("the kfree_skb tracepoint has no drop reasons, the drops are reported with drop_reason=unknown")
+	}
+	c <- kprobe.WaitSynced(p.kprobeHelper, "packetdrop")
+	m := p.collection.DetachMap(mapDrops)
+	policyDrops := p.collection.DetachMap(mapPolicy)
+	ticker := p.clock.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		vethes := p.vethes()
+		local := make(map[string]bool, len(vethes))
+		for _, ip := range vethes {
+			local[ip] = true
+		}
+		var (
+			key   rawDropKey
+			count uint64
+		)
+		for m.Iterate().Next(&key, &count) {
+			p.observe(vethes, local, &key, count)
+			if err := m.Delete(key); err != nil {
+				p.Log.Errorf("delete map error: %v", err)
+			}
+		}
+		var policyKey rawPolicyKey
+		for policyDrops.Iterate().Next(&policyKey, &count) {
+			if conn, ok := p.policy(&policyKey); ok {
+				p.policies.observe(conn, count)
+			}
+			if err := policyDrops.Delete(policyKey); err != nil {
+				p.Log.Errorf("delete map error: %v", err)
+			}
+		}
+		now := p.clock.Now().UnixNano()
+		for _, d := range p.drops.report(now) {
+			c <- d
+		}
+		for _, d := range p.policies.report(now) {
+			c <- d
+		}
+		p.reportBudget(c)
+	}
+}
+
+func (p *provider) reportBudget(c chan *metric.Metric) {
+	metrics, err := p.budget.Metrics(p.clock.Now().UnixNano())
+	if err != nil {
+		p.Log.Errorf("failed to read event budget, err: %v", err)
+		return
+	}
+	for _, m := range metrics {
+		p.Log.Warnf("%v drops of tracepoint(%s) left out by the event budget", m.Fields["dropped_count"], m.Tags["probe"])
+		c <- m
+	}
+}
+
+// vethes returns the ips of the pods by the index of their veth.
+func (p *provider) vethes() map[uint32]string {
+	links, err := p.kprobeHelper.GetVethes()
+	if err != nil {
+		p.Log.Errorf("failed to get vethes, err: %v", err)
+		return nil
+	}
+	ans := make(map[uint32]string, len(links))
+	for _, l := range links {
+		ans[uint32(l.Link.Attrs().Index)] = l.Neigh.IP.String()
+	}
+	return ans
+}
+
+// observe attributes the drops of a key to a pod of the node, local are the ips of the pods of the node.
+func (p *provider) observe(vethes map[uint32]string, local map[string]bool, k *rawDropKey, count uint64) {
+	source, dest := net.IP(k.SourceIP[:]).String(), net.IP(k.DestIP[:]).String()
+	var podIP, direction string
+	switch {
+	case vethes[k.IfIndex] == source, vethes[k.IfIndex] == "" && local[source]:
+		podIP, direction = source, directionEgress
+	case vethes[k.IfIndex] == dest, vethes[k.IfIndex] == "" && local[dest]:
+		podIP, direction = dest, directionIngress
+	default:
+		return
+	}
+	pod, err := p.kprobeHelper.GetPodByUID(podIP)
+	if err != nil {
+		return
+	}
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+	}}
+	enrich.PodTags(m, "source", pod)
+	p.drops.observe(m, direction, p.reasons.name(k.Reason), count)
+}
+
+// policy returns the tags of the source and of the intended destination of the packets dropped by a
+// netfilter verdict, false if neither is a pod.
+func (p *provider) policy(k *rawPolicyKey) (*metric.Metric, bool) {
+	source, dest := net.IP(k.SourceIP[:]).String(), net.IP(k.DestIP[:]).String()
+	peer := dest
+	if k.DestPort != 0 {
+		peer = net.JoinHostPort(dest, strconv.Itoa(int(k.DestPort)))
+	}
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source":  "ebpf",
+		"_meta":          "true",
+		"_metric_scope":  "micro_service",
+		"source_address": source,
+		"peer_address":   peer,
+		"protocol":       protocolName(k.Protocol),
+	}}
+	sourcePod, sourceErr := p.kprobeHelper.GetPodByUID(source)
+	if sourceErr == nil {
+		enrich.PodTags(m, "source", sourcePod)
+	}
+	targetPod, targetErr := p.kprobeHelper.GetPodByUID(dest)
+	if targetErr == nil {
+		enrich.PodTags(m, "target", targetPod)
+	} else if svc, err := p.kprobeHelper.GetService(dest); err == nil {
+		m.Tags["peer_service"] = svc.Name
+	}
+	return m, sourceErr == nil || targetErr == nil
+}
+
+func (p *provider) load() error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	p.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	p.budget, err = eventbudget.Apply(p.collection, "packetdrop", budgetProbes)
+	if err != nil {
+		return err
+	}
+	l, err := link.Tracepoint("skb", "kfree_skb", p.collection.DetachProgram(programName), nil)
+	if err != nil {
+		return err
+	}
+	p.links = append(p.links, l)
+
+	if value, ok := p.reasons.value(netfilterDrop); ok {
+		return p.collection.DetachMap(mapNetfilterReason).Put(uint32(0), value)
+	}
+	// the kernels without drop reasons: the verdicts are read on the return of nf_hook_slow.
+	kp, err := link.Kprobe("nf_hook_slow", p.collection.DetachProgram("kprobe_nf_hook_slow"), nil)
+	if err != nil {
+		p.Log.Warnf("failed to attach kprobe(nf_hook_slow), the netfilter drops are not reported: %v", err)
+		return nil
+	}
+	p.links = append(p.links, kp)
+	krp, err := link.Kretprobe("nf_hook_slow", p.collection.DetachProgram("kretprobe_nf_hook_slow"), nil)
+	if err != nil {
+		p.Log.Warnf("failed to attach kretprobe(nf_hook_slow), the netfilter drops are not reported: %v", err)
+		return nil
+	}
+	p.links = append(p.links, krp)
+	return nil
+}
+
+func (p *provider) Close() error {
+	for _, l := range p.links {
+		l.Close()
+	}
+	if p.collection != nil {
+		p.collection.Close()
+	}
+	return nil
+}
+
+func init() {
+	servicehub.Register("packetdrop", &servicehub.Spec{
+		Services:     []string{"packetdrop"},
+		Description:  "ebpf for the packets dropped by the kernel for the pods",
+		Dependencies: []string{"kprobe"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
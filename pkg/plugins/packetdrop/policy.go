@@ -0,0 +1,92 @@
+package packetdrop
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const (
+	measurementPolicy = "application_network_policy_drop"
+
+	// netfilterDrop is the drop reason of the netfilter verdicts.
+	netfilterDrop = "NETFILTER_DROP"
+)
+
+// rawPolicyKey mirrors struct policy_key_t of ebpf/plugins/packetdrop/main.c.
+type rawPolicyKey struct {
+	SourceIP [4]byte
+	DestIP   [4]byte
+	DestPort uint16
+	Protocol uint8
+	Pad      uint8
+}
+
+// protocolName returns the name of an ip protocol, its number if it is not tcp, udp or icmp.
+func protocolName(protocol uint8) string {
+	switch protocol {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+type policyKey struct {
+	source string
+	target string
+}
+
+type policyDrop struct {
+	tags    map[string]string
+	orgName string
+	drops   uint64
+}
+
+// policies aggregates the packets dropped by the netfilter verdicts between two reports by source and
+// intended destination.
+type policies struct {
+	entries map[policyKey]*policyDrop
+}
+
+func newPolicies() *policies {
+	return &policies{entries: make(map[policyKey]*policyDrop)}
+}
+
+// observe adds count drops, conn carries the tags of the source and of the destination of the packets,
+// peer_address is the destination ip:port. The pods are keyed by instance, the other ends by address.
+func (ps *policies) observe(conn *metric.Metric, count uint64) {
+	k := policyKey{source: conn.Tags["source_service_instance_id"], target: conn.Tags["target_service_instance_id"]}
+	if k.source == "" {
+		k.source = conn.Tags["source_address"]
+	}
+	if k.target == "" {
+		k.target = conn.Tags["peer_address"]
+	}
+	d, ok := ps.entries[k]
+	if !ok {
+		d = &policyDrop{tags: conn.Tags, orgName: conn.OrgName}
+		ps.entries[k] = d
+	}
+	d.drops += count
+}
+
+// report returns the drops since the previous report.
+func (ps *policies) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(ps.entries))
+	for _, d := range ps.entries {
+		ans = append(ans, &metric.Metric{
+			Name:        measurementPolicy,
+			Measurement: measurementPolicy,
+			Timestamp:   timestamp,
+			OrgName:     d.orgName,
+			Tags:        d.tags,
+			Fields:      map[string]interface{}{"drops": d.drops},
+		})
+	}
+	ps.entries = make(map[policyKey]*policyDrop)
+	return ans
+}
@@ -0,0 +1,52 @@
+package packetdrop
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestPolicies(t *testing.T) {
+	ps := newPolicies()
+	conn := func(source, target, peer string) *metric.Metric {
+		m := &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"source_address": "10.0.0.1",
+			"peer_address":   peer,
+		}}
+		if source != "" {
+			m.Tags["source_service_instance_id"] = source
+		}
+		if target != "" {
+			m.Tags["target_service_instance_id"] = target
+		}
+		return m
+	}
+	ps.observe(conn("web-1", "db-1", "10.0.1.5:5432"), 3)
+	ps.observe(conn("web-1", "db-1", "10.0.1.5:5432"), 2)
+	ps.observe(conn("web-1", "", "8.8.8.8:53"), 1)
+	ps.observe(conn("", "db-1", "10.0.1.5:5432"), 4)
+
+	reported := ps.report(1000)
+	if len(reported) != 3 {
+		t.Fatalf("expected a metric per source and destination, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurementPolicy || m.OrgName != "erda" {
+			t.Errorf("unexpected drops %+v", m)
+		}
+		if m.Tags["source_service_instance_id"] == "web-1" && m.Tags["target_service_instance_id"] == "db-1" && m.Fields["drops"] != uint64(5) {
+			t.Errorf("unexpected fields %v", m.Fields)
+		}
+	}
+	if len(ps.report(2000)) != 0 {
+		t.Error("the drops are reported once")
+	}
+}
+
+func TestProtocolName(t *testing.T) {
+	for protocol, want := range map[uint8]string{1: "icmp", 6: "tcp", 17: "udp", 132: "132"} {
+		if got := protocolName(protocol); got != want {
+			t.Errorf("protocol %d: expected %s, got %s", protocol, want, got)
+		}
+	}
+}
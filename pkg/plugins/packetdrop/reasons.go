@@ -0,0 +1,73 @@
+package packetdrop
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// formatPaths are the formats of the kfree_skb tracepoint in tracefs, mounted in either place.
+var formatPaths = []string{
+	"/sys/kernel/tracing/events/skb/kfree_skb/format",
+	"/sys/kernel/debug/tracing/events/skb/kfree_skb/format",
+}
+
+// reasonSymbol is an entry of the __print_symbolic of the reasons, e.g. { 2, "NO_SOCKET" }.
+var reasonSymbol = regexp.MustCompile(`\{\s*(\d+),\s*"(\w+)"\s*\}`)
+
+// reasons are the names of the drop reasons of the kernel, the values of enum skb_drop_reason change
+// between the kernel versions, the names are read from the format of the tracepoint.
+type reasons struct {
+	// supported is set when the tracepoint has the reason field (5.17+).
+	supported bool
+	names     map[uint32]string
+}
+
+func loadReasons() reasons {
+	for _, path := range formatPaths {
+		if format, err := os.ReadFile(path); err == nil {
+			return parseReasons(string(format))
+		}
+	}
+	return reasons{}
+}
+
+// parseReasons reads the reason field and the names of the reasons of a tracepoint format.
+func parseReasons(format string) reasons {
+	r := reasons{names: make(map[uint32]string)}
+	for _, line := range strings.Split(format, "\n") {
+		if strings.Contains(line, "field:") && strings.Contains(line, " reason;") {
+			r.supported = true
+		}
+	}
+	for _, m := range reasonSymbol.FindAllStringSubmatch(format, -1) {
+		value, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		r.names[uint32(value)] = m[2]
+	}
+	return r
+}
+
+// value returns the value of a reason of this kernel, false on the kernels without it.
+func (r reasons) value(name string) (uint32, bool) {
+	for value, n := range r.names {
+		if n == name {
+			return value, r.supported
+		}
+	}
+	return 0, false
+}
+
+// name returns the name of a reason, unknown on the kernels without reasons.
+func (r reasons) name(reason uint32) string {
+	if !r.supported {
+		return "unknown"
+	}
+	if name, ok := r.names[reason]; ok {
+		return name
+	}
+	return "reason_" + strconv.FormatUint(uint64(reason), 10)
+}
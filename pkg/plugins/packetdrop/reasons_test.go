@@ -0,0 +1,49 @@
+package packetdrop
+
+import "testing"
+
+const kfreeSkbFormat = `name: kfree_skb
+ID: 1532
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:void * skbaddr;	offset:8;	size:8;	signed:0;
+	field:void * location;	offset:16;	size:8;	signed:0;
+	field:unsigned short protocol;	offset:24;	size:2;	signed:0;
+	field:enum skb_drop_reason reason;	offset:28;	size:4;	signed:0;
+
+print fmt: "skbaddr=%p protocol=%u location=%p reason: %s", REC->skbaddr, REC->protocol, REC->location, __print_symbolic(REC->reason, { 2, "NOT_SPECIFIED" }, { 3, "NO_SOCKET" }, { 6, "TCP_CSUM" }, { 17, "NETFILTER_DROP" })
+`
+
+func TestParseReasons(t *testing.T) {
+	r := parseReasons(kfreeSkbFormat)
+	for reason, want := range map[uint32]string{
+		2:  "NOT_SPECIFIED",
+		3:  "NO_SOCKET",
+		17: "NETFILTER_DROP",
+		99: "reason_99",
+	} {
+		if got := r.name(reason); got != want {
+			t.Errorf("reason %d: expected %s, got %s", reason, want, got)
+		}
+	}
+
+	if value, ok := r.value("NETFILTER_DROP"); !ok || value != 17 {
+		t.Errorf("expected the value of NETFILTER_DROP, got %d", value)
+	}
+
+	// before 5.17
+	old := parseReasons(`	field:void * skbaddr;	offset:8;	size:8;	signed:0;
+	field:void * location;	offset:16;	size:8;	signed:0;
+	field:unsigned short protocol;	offset:24;	size:2;	signed:0;
+print fmt: "skbaddr=%p protocol=%u location=%p", REC->skbaddr, REC->protocol, REC->location`)
+	if old.supported || old.name(3) != "unknown" {
+		t.Errorf("the kernels without reasons report unknown, got %+v", old)
+	}
+	if _, ok := old.value("NETFILTER_DROP"); ok {
+		t.Error("the kernels without reasons have no values")
+	}
+}
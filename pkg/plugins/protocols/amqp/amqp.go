@@ -0,0 +1,136 @@
+package amqp
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/amqp/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_mq"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("amqp", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "amqp", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	routingKey := dbstatement.KeyPattern(m.RoutingKey)
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"amqp_exchange":    m.Exchange,
+			"amqp_routing_key": routingKey,
+			"amqp_queue":       m.Queue,
+			"amqp_method":      m.Method,
+			"amqp_ack":         m.Ack.String(),
+			"amqp_redelivered": strconv.FormatBool(m.Redelivered),
+			"acknowledged":     strconv.FormatBool(m.Ack != ebpf.AckNone),
+		},
+		Fields: map[string]interface{}{
+			"message_count": 1,
+			"body_bytes":    m.BodySize,
+		},
+	}
+	// the latency is the time until the acknowledgement, publisher confirms and manual consumer acks only.
+	if m.Ack != ebpf.AckNone {
+		output.Fields["elapsed_count"] = 1
+		output.Fields["elapsed_sum"] = m.Duration
+		output.Fields["elapsed_max"] = m.Duration
+		output.Fields["elapsed_min"] = m.Duration
+		output.Fields["elapsed_mean"] = m.Duration
+	}
+	failed := m.Ack == ebpf.AckNack || m.Ack == ebpf.AckReject
+	output.Tags["error"] = strconv.FormatBool(failed)
+
+	// the source is the client pod, the target the broker.
+	inCluster := p.enricher.Enrich(output, "RABBITMQ", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	switch m.Kind {
+	case ebpf.KindPublish:
+		// messages published to the default exchange are routed to the queue named by the routing key
+		destination := m.Exchange
+		if destination == "" {
+			destination = routingKey
+		}
+		output.Tags["message_bus_destination"] = destination
+		output.Tags["span_kind"] = "producer"
+		output.Tags["message_bus_status"] = "PUBLISH_SUCCESS"
+		if failed {
+			output.Tags["message_bus_status"] = "PUBLISH_FAILED"
+		}
+	case ebpf.KindConsume:
+		output.Tags["message_bus_destination"] = m.Queue
+		output.Tags["span_kind"] = "consumer"
+		output.Tags["message_bus_status"] = "CONSUME_SUCCESS"
+		if failed {
+			output.Tags["message_bus_status"] = "CONSUME_FAILED"
+		}
+	}
+	// brokers outside the cluster (e.g. cloud rabbitmq) are still reported, they are not part of the topology.
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("amqp", &servicehub.Spec{
+		Services:             []string{"amqp"},
+		Description:          "ebpf for amqp 0.9.1 (rabbitmq)",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
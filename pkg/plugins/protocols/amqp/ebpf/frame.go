@@ -0,0 +1,106 @@
+package ebpf
+
+import (
+	"encoding/binary"
+)
+
+// see https://www.rabbitmq.com/resources/specs/amqp0-9-1.pdf
+const (
+	frameHeaderSize = 7
+	frameEnd        = 0xCE
+
+	frameMethod = 1
+	frameHeader = 2
+
+	classBasic   = 60
+	classConfirm = 85
+
+	methodConsume   = 20
+	methodConsumeOk = 21
+	methodPublish   = 40
+	methodDeliver   = 60
+	methodGet       = 70
+	methodGetOk     = 71
+	methodAck       = 80
+	methodReject    = 90
+	methodNack      = 120
+
+	methodSelectOk = 11
+)
+
+type frame struct {
+	typ     uint8
+	channel uint16
+	payload []byte
+}
+
+// parseFrames splits the captured bytes into frames, the payload of the last one may be truncated.
+func parseFrames(buf []byte) []frame {
+	var frames []frame
+	for len(buf) >= frameHeaderSize {
+		f := frame{
+			typ:     buf[0],
+			channel: binary.BigEndian.Uint16(buf[1:3]),
+		}
+		size := int(binary.BigEndian.Uint32(buf[3:7]))
+		buf = buf[frameHeaderSize:]
+		if size > len(buf) {
+			f.payload = buf
+			frames = append(frames, f)
+			break
+		}
+		f.payload = buf[:size]
+		frames = append(frames, f)
+		buf = buf[size:]
+		if len(buf) == 0 || buf[0] != frameEnd {
+			break
+		}
+		buf = buf[1:]
+	}
+	return frames
+}
+
+// reader reads the argument types of methods, reads past the end return zero values and set err.
+type reader struct {
+	buf []byte
+	err bool
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err || len(r.buf) < n {
+		r.err = true
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) octet() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) short() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) longlong() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) shortstr() string {
+	n := r.octet()
+	if b := r.next(int(n)); b != nil {
+		return string(b)
+	}
+	return ""
+}
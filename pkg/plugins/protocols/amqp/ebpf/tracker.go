@@ -0,0 +1,294 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// ackTimeout is how long a message waits for its acknowledgement, publishes without publisher confirms
+	// and deliveries of auto-ack consumers are reported without duration after it.
+	ackTimeout = uint64(10e9)
+	// connIdleTimeout drops the state (e.g. the consumer tags) of connections without traffic.
+	connIdleTimeout = uint64(30 * 60e9)
+	// maxPendingPerChannel bounds the memory of channels publishing at high rates without confirms.
+	maxPendingPerChannel = 1024
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+	// seq is the publish sequence of publishes and the delivery tag of deliveries.
+	seq uint64
+}
+
+type channelState struct {
+	// publishSeq numbers the publishes, delivery tags of publisher confirms are tagOffset + publishSeq.
+	// The offset is 0 after confirm.select-ok, it is guessed from the first confirm when the channel was
+	// opened before the agent started.
+	publishSeq uint64
+	tagOffset  int64
+	aligned    bool
+
+	publishes  []*pending
+	deliveries []*pending
+
+	consumeQueue string
+	getQueue     string
+	// last is the message the next content header frame belongs to.
+	last *pending
+}
+
+type connState struct {
+	channels map[uint16]*channelState
+	// consumers maps the consumer tags to their queues.
+	consumers map[string]string
+	lastSeen  uint64
+	// cookie is the socket cookie of the client, taken from its packets.
+	cookie uint64
+}
+
+// tracker pairs the messages with their acknowledgements per connection and channel. Connections are
+// keyed in the client -> broker direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]*connState
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]*connState)}
+}
+
+func (c *connState) channel(id uint16) *channelState {
+	ch, ok := c.channels[id]
+	if !ok {
+		ch = &channelState{}
+		c.channels[id] = ch
+	}
+	return ch
+}
+
+// handle processes the frames of a packet, it returns the messages acknowledged by it.
+func (t *tracker) handle(key *EventKey, ev *AmqpEvent) []*Metric {
+	fromPod := ev.FromPod == 1
+	connKey := key.Conn
+	if !fromPod {
+		connKey = ConnKey{
+			SourceIP:   key.Conn.DestIP,
+			DestIP:     key.Conn.SourceIP,
+			SourcePort: key.Conn.DestPort,
+			DestPort:   key.Conn.SourcePort,
+		}
+	}
+	c, ok := t.conns[connKey]
+	if !ok {
+		c = &connState{
+			channels:  make(map[uint16]*channelState),
+			consumers: make(map[string]string),
+		}
+		t.conns[connKey] = c
+	}
+	ts := key.Timestamp
+	c.lastSeen = ts
+	if fromPod && ev.Cookie != 0 {
+		c.cookie = ev.Cookie
+	}
+
+	var done []*Metric
+	for _, f := range parseFrames(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]) {
+		ch := c.channel(f.channel)
+		last := ch.last
+		ch.last = nil
+		switch f.typ {
+		case frameHeader:
+			// class, weight and body size
+			if last != nil && len(f.payload) >= 12 {
+				r := &reader{buf: f.payload[4:]}
+				last.metric.BodySize = r.longlong()
+			}
+			continue
+		case frameMethod:
+		default:
+			continue
+		}
+
+		r := &reader{buf: f.payload}
+		class, method := r.short(), r.short()
+		if class == classConfirm {
+			if method == methodSelectOk && !fromPod {
+				ch.publishSeq, ch.tagOffset, ch.aligned = 0, 0, true
+			}
+			continue
+		}
+		if class != classBasic {
+			continue
+		}
+		// methods sent by the client are only valid from the pod, methods of the broker only to the pod.
+		// Connections of broker pods are ignored that way, their clients are tracked on their own veth.
+		switch method {
+		case methodPublish:
+			if !fromPod {
+				continue
+			}
+			r.short()
+			m := newMetric(&connKey, c.cookie, KindPublish, "basic.publish")
+			m.Exchange = r.shortstr()
+			m.RoutingKey = r.shortstr()
+			ch.publishSeq++
+			p := &pending{metric: m, ts: ts, seq: ch.publishSeq}
+			ch.publishes, done = appendPending(ch.publishes, p, done)
+			ch.last = p
+		case methodDeliver, methodGetOk:
+			if fromPod {
+				continue
+			}
+			m := newMetric(&connKey, c.cookie, KindConsume, "basic.deliver")
+			if method == methodDeliver {
+				m.Queue = c.consumers[r.shortstr()]
+			} else {
+				m.Method = "basic.get"
+				m.Queue = ch.getQueue
+			}
+			tag := r.longlong()
+			m.Redelivered = r.octet()&1 == 1
+			m.Exchange = r.shortstr()
+			m.RoutingKey = r.shortstr()
+			if r.err && tag == 0 {
+				continue
+			}
+			p := &pending{metric: m, ts: ts, seq: tag}
+			ch.deliveries, done = appendPending(ch.deliveries, p, done)
+			ch.last = p
+		case methodGet:
+			if fromPod {
+				r.short()
+				ch.getQueue = r.shortstr()
+			}
+		case methodConsume:
+			if fromPod {
+				r.short()
+				ch.consumeQueue = r.shortstr()
+				if tag := r.shortstr(); tag != "" {
+					c.consumers[tag] = ch.consumeQueue
+				}
+			}
+		case methodConsumeOk:
+			if tag := r.shortstr(); !fromPod && !r.err {
+				c.consumers[tag] = ch.consumeQueue
+			}
+		case methodAck, methodNack, methodReject:
+			tag := r.longlong()
+			bits := r.octet()
+			if r.err {
+				continue
+			}
+			ack, multiple := AckAck, bits&1 == 1
+			switch method {
+			case methodNack:
+				ack = AckNack
+			case methodReject:
+				// the bit of reject is requeue
+				ack, multiple = AckReject, false
+			}
+			if fromPod {
+				done = append(done, ch.ackDeliveries(tag, multiple, ack, ts)...)
+			} else {
+				done = append(done, ch.confirm(tag, multiple, ack, ts)...)
+			}
+		}
+	}
+	return done
+}
+
+func (ch *channelState) ackDeliveries(tag uint64, multiple bool, ack Ack, ts uint64) []*Metric {
+	var done []*Metric
+	ch.deliveries, done = complete(ch.deliveries, func(seq uint64) bool {
+		// multiple with tag 0 acknowledges all outstanding messages
+		return seq == tag || (multiple && (tag == 0 || seq <= tag))
+	}, ack, ts)
+	return done
+}
+
+func (ch *channelState) confirm(tag uint64, multiple bool, ack Ack, ts uint64) []*Metric {
+	if len(ch.publishes) == 0 {
+		return nil
+	}
+	if !ch.aligned {
+		ch.tagOffset = int64(tag) - int64(ch.publishes[0].seq)
+		ch.aligned = true
+	}
+	seq := int64(tag) - ch.tagOffset
+	// a confirm of a publish we have not seen means the guessed offset was wrong
+	if seq > int64(ch.publishSeq) {
+		ch.tagOffset = int64(tag) - int64(ch.publishSeq)
+		seq = int64(ch.publishSeq)
+	}
+	var done []*Metric
+	ch.publishes, done = complete(ch.publishes, func(s uint64) bool {
+		return int64(s) == seq || (multiple && int64(s) <= seq)
+	}, ack, ts)
+	return done
+}
+
+// expire returns the messages not acknowledged within ackTimeout at now (bpf_ktime_get_ns) and
+// drops idle connections.
+func (t *tracker) expire(now uint64) []*Metric {
+	var done []*Metric
+	for key, c := range t.conns {
+		pendingCount := 0
+		for _, ch := range c.channels {
+			for _, list := range []*[]*pending{&ch.publishes, &ch.deliveries} {
+				kept := (*list)[:0]
+				for _, p := range *list {
+					if now-p.ts > ackTimeout {
+						done = append(done, p.metric)
+						continue
+					}
+					kept = append(kept, p)
+				}
+				*list = kept
+				pendingCount += len(kept)
+			}
+		}
+		if pendingCount == 0 && now-c.lastSeen > connIdleTimeout {
+			delete(t.conns, key)
+		}
+	}
+	return done
+}
+
+func appendPending(list []*pending, p *pending, done []*Metric) ([]*pending, []*Metric) {
+	if len(list) >= maxPendingPerChannel {
+		done = append(done, list[0].metric)
+		list = list[1:]
+	}
+	return append(list, p), done
+}
+
+func complete(list []*pending, match func(seq uint64) bool, ack Ack, ts uint64) ([]*pending, []*Metric) {
+	var done []*Metric
+	kept := list[:0]
+	for _, p := range list {
+		if !match(p.seq) {
+			kept = append(kept, p)
+			continue
+		}
+		p.metric.Ack = ack
+		p.metric.Captured = ts
+		if ts > p.ts {
+			p.metric.Duration = ts - p.ts
+		}
+		done = append(done, p.metric)
+	}
+	return kept, done
+}
+
+func newMetric(key *ConnKey, cookie uint64, kind Kind, method string) *Metric {
+	return &Metric{
+		SourceIP:     net.IP(key.SourceIP[:]).String(),
+		SourcePort:   key.SourcePort,
+		DestIP:       net.IP(key.DestIP[:]).String(),
+		DestPort:     key.DestPort,
+		Kind:         kind,
+		Method:       method,
+		SocketCookie: cookie,
+	}
+}
@@ -0,0 +1,144 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func methodFrame(channel uint16, class, method uint16, args ...interface{}) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, class)
+	payload = binary.BigEndian.AppendUint16(payload, method)
+	for _, a := range args {
+		switch v := a.(type) {
+		case uint8:
+			payload = append(payload, v)
+		case uint16:
+			payload = binary.BigEndian.AppendUint16(payload, v)
+		case uint64:
+			payload = binary.BigEndian.AppendUint64(payload, v)
+		case string:
+			payload = append(payload, uint8(len(v)))
+			payload = append(payload, v...)
+		}
+	}
+	return frameBytes(frameMethod, channel, payload)
+}
+
+func headerFrame(channel uint16, bodySize uint64) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, classBasic)
+	payload = binary.BigEndian.AppendUint16(payload, 0)
+	payload = binary.BigEndian.AppendUint64(payload, bodySize)
+	payload = binary.BigEndian.AppendUint16(payload, 0)
+	return frameBytes(frameHeader, channel, payload)
+}
+
+func frameBytes(typ uint8, channel uint16, payload []byte) []byte {
+	b := []byte{typ}
+	b = binary.BigEndian.AppendUint16(b, channel)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = append(b, payload...)
+	return append(b, frameEnd)
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 5672}
+	broker = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, frames ...[]byte) []*Metric {
+	key := EventKey{Conn: broker, Timestamp: ts}
+	ev := AmqpEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	var buf []byte
+	for _, f := range frames {
+		buf = append(buf, f...)
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	return t.handle(&key, &ev)
+}
+
+func TestPublisherConfirms(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, false, methodFrame(1, classConfirm, methodSelectOk))
+	publish := func(ts uint64, rk string) []*Metric {
+		return packet(tr, ts, true, methodFrame(1, classBasic, methodPublish, uint16(0), "orders", rk, uint8(0)), headerFrame(1, 42))
+	}
+	publish(10, "order.created.42")
+	publish(20, "order.created.43")
+	publish(30, "order.paid.44")
+
+	done := packet(tr, 50, false, methodFrame(1, classBasic, methodAck, uint64(2), uint8(1)))
+	if len(done) != 2 {
+		t.Fatalf("multiple ack should confirm 2 publishes, got %d", len(done))
+	}
+	m := done[0]
+	if m.Kind != KindPublish || m.Exchange != "orders" || m.RoutingKey != "order.created.42" || m.BodySize != 42 ||
+		m.Ack != AckAck || m.Duration != 40 || m.SourceIP != "10.0.0.1" || m.DestPort != 5672 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	done = packet(tr, 60, false, methodFrame(1, classBasic, methodNack, uint64(3), uint8(0)))
+	if len(done) != 1 || done[0].Ack != AckNack || done[0].RoutingKey != "order.paid.44" || done[0].Duration != 30 {
+		t.Errorf("unexpected nack %+v", done)
+	}
+}
+
+func TestConfirmsWithoutSelect(t *testing.T) {
+	tr := newTracker()
+	// the channel was put into confirm mode before the agent started, the tags are guessed from the first confirm
+	packet(tr, 10, true, methodFrame(1, classBasic, methodPublish, uint16(0), "", "tasks", uint8(0)))
+	packet(tr, 20, true, methodFrame(1, classBasic, methodPublish, uint16(0), "", "tasks", uint8(0)))
+	done := packet(tr, 30, false, methodFrame(1, classBasic, methodAck, uint64(100), uint8(0)))
+	if len(done) != 1 || done[0].Duration != 20 {
+		t.Fatalf("unexpected confirm %+v", done)
+	}
+	done = packet(tr, 40, false, methodFrame(1, classBasic, methodAck, uint64(101), uint8(0)))
+	if len(done) != 1 || done[0].Duration != 20 {
+		t.Fatalf("unexpected confirm %+v", done)
+	}
+}
+
+func TestConsumerAcks(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, methodFrame(2, classBasic, methodConsume, uint16(0), "emails", "", uint8(0)))
+	packet(tr, 2, false, methodFrame(2, classBasic, methodConsumeOk, "amq.ctag-1"))
+	deliver := func(ts, tag uint64) {
+		packet(tr, ts, false, methodFrame(2, classBasic, methodDeliver, "amq.ctag-1", tag, uint8(0), "", "emails"), headerFrame(2, 7))
+	}
+	deliver(10, 1)
+	deliver(11, 2)
+
+	done := packet(tr, 15, true, methodFrame(2, classBasic, methodReject, uint64(2), uint8(1)))
+	if len(done) != 1 || done[0].Ack != AckReject || done[0].Duration != 4 {
+		t.Fatalf("unexpected reject %+v", done)
+	}
+	done = packet(tr, 20, true, methodFrame(2, classBasic, methodAck, uint64(0), uint8(1)))
+	if len(done) != 1 {
+		t.Fatalf("multiple ack of tag 0 should ack all outstanding deliveries, got %d", len(done))
+	}
+	m := done[0]
+	if m.Kind != KindConsume || m.Queue != "emails" || m.Method != "basic.deliver" || m.BodySize != 7 || m.Duration != 10 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, methodFrame(1, classBasic, methodPublish, uint16(0), "logs", "app", uint8(0)))
+	// deliveries sent by a broker pod to its clients are ignored
+	packet(tr, 1, true, methodFrame(1, classBasic, methodDeliver, "ctag", uint64(1), uint8(0), "", "q"))
+	if done := tr.expire(ackTimeout); len(done) != 0 {
+		t.Fatalf("unexpected expired %+v", done)
+	}
+	done := tr.expire(ackTimeout + 2)
+	if len(done) != 1 || done[0].Ack != AckNone || done[0].Duration != 0 || done[0].Exchange != "logs" {
+		t.Fatalf("unexpected expired %+v", done)
+	}
+	tr.expire(connIdleTimeout + 2)
+	if len(tr.conns) != 0 {
+		t.Errorf("idle connections should be dropped")
+	}
+}
@@ -0,0 +1,95 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	AmqpPayloadSize = 256
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type AmqpEvent struct {
+	socketfilter.Header
+	Payload [AmqpPayloadSize]byte
+}
+
+type Kind int
+
+const (
+	KindPublish Kind = iota
+	KindConsume
+)
+
+func (k Kind) String() string {
+	if k == KindPublish {
+		return "publish"
+	}
+	return "consume"
+}
+
+// Ack is how the message was acknowledged, by the broker for publishes (publisher confirms)
+// and by the client for deliveries.
+type Ack int
+
+const (
+	// AckNone means no acknowledgement was seen, e.g. without publisher confirms or for auto-ack consumers.
+	AckNone Ack = iota
+	AckAck
+	AckNack
+	AckReject
+)
+
+func (a Ack) String() string {
+	switch a {
+	case AckAck:
+		return "ack"
+	case AckNack:
+		return "nack"
+	case AckReject:
+		return "reject"
+	default:
+		return "none"
+	}
+}
+
+type Metric struct {
+	// Source is the client, Dest the broker.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Kind Kind
+	// Method is basic.publish, basic.deliver or basic.get.
+	Method     string
+	Exchange   string
+	RoutingKey string
+	// Queue is the queue of deliveries, known when the consumer was registered or the get was seen.
+	Queue       string
+	Redelivered bool
+	BodySize    uint64
+
+	Ack Ack
+	// Duration is the time until the acknowledgement, from publish to the broker confirm or
+	// from delivery to the client ack, zero without acknowledgement.
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s amqp [%s:%d] --> [%s:%d][%s %s %s %s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Method, m.Exchange, m.RoutingKey, m.Queue,
+		m.Ack, time.Duration(m.Duration).String(),
+	)
+}
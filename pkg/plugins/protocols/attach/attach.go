@@ -0,0 +1,303 @@
+// Package attach keeps the probes of the protocol plugins attached to the interfaces of the pods of the
+// node: the veths are attached at startup and as they are added, the probes of the deleted veths are
+// closed. The interfaces holding the ip of the node are attached too with EBPF_HOST_NETWORK, the requests
+// of the hostNetwork pods are attributed by the pid of their connection (package connpid). The parent
+// interfaces of the ipvlan and macvlan pods and the OVS internal ports of the kube-ovn pods (in the
+// namespace of their pod) are attached once the caches are synced and listed again every refreshInterval,
+// as they are not notified. An interface a plugin fails to attach to is quarantined for the coverage report.
+package attach
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/coverage"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/connpid"
+)
+
+// refreshInterval is the period the shared interfaces and the internal ports are listed.
+const refreshInterval = 30 * time.Second
+
+// Link is an interface probed by the plugins.
+type Link struct {
+	// Index is the index of the interface.
+	Index int
+	// IP is the ip of the pod behind the interface, of the node for a host interface and 0.0.0.0 for a
+	// shared one.
+	IP string
+	// Pods are the ips of the pods of a shared interface (ipvlan, macvlan), sorted.
+	Pods []string
+	// NetNS is the path of the namespace of an internal port (kube-ovn), empty for the interfaces of the
+	// node.
+	NetNS string
+}
+
+// key tells the links apart, the indexes of the internal ports are not unique across the namespaces.
+type key struct {
+	netNS string
+	index int
+}
+
+func (l Link) key() key {
+	return key{netNS: l.NetNS, index: l.Index}
+}
+
+// IPs returns the ips of the pods the probe of the link is restricted to.
+func (l Link) IPs() []string {
+	if ip := net.ParseIP(l.IP); ip == nil || ip.IsUnspecified() {
+		return l.Pods
+	}
+	return append([]string{l.IP}, l.Pods...)
+}
+
+func (l Link) has(ip string) bool {
+	if l.IP == ip {
+		return true
+	}
+	for _, pod := range l.Pods {
+		if pod == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func samePods(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func linkOf(n kprobe.NeighLink) Link {
+	l := Link{Index: n.Link.Attrs().Index, IP: n.Neigh.IP.String(), NetNS: n.NetNS}
+	for _, pod := range n.Pods {
+		l.Pods = append(l.Pods, pod.String())
+	}
+	sort.Strings(l.Pods)
+	return l
+}
+
+// Probe is the probe of a plugin on a link, closed once the link is gone.
+type Probe interface {
+	Close() error
+}
+
+// Loader attaches the probe of a plugin to a link.
+type Loader func(Link) (Probe, error)
+
+// Attacher holds the probes of a plugin by their interface.
+type Attacher struct {
+	sync.RWMutex
+	plugin string
+	load   Loader
+	probes map[key]Probe
+	links  map[key]Link
+	// hosts are the host interfaces by index, a shared interface is often one of them.
+	hosts map[int]Link
+	done  chan struct{}
+	clock clock.Clock
+}
+
+func newAttacher(plugin string, load Loader) *Attacher {
+	return &Attacher{
+		plugin: plugin,
+		load:   load,
+		probes: make(map[key]Probe),
+		links:  make(map[key]Link),
+		hosts:  make(map[int]Link),
+		done:   make(chan struct{}),
+		clock:  clock.Real,
+	}
+}
+
+// Start attaches the probes of plugin to the veths of the pods of k and to its host interfaces, and follows
+// the veths added and deleted, the shared interfaces and the internal ports.
+func Start(plugin string, k kprobe.Interface, load Loader) (*Attacher, error) {
+	vethes, err := k.GetVethes()
+	if err != nil {
+		return nil, err
+	}
+	a := newAttacher(plugin, load)
+	coverage.Attached(plugin, nil)
+	for _, v := range vethes {
+		a.attach(linkOf(v))
+	}
+	if hosts := k.GetHostInterfaces(); len(hosts) > 0 {
+		if err := connpid.Start(); err != nil {
+			klog.Errorf("%s: failed to trace the pid of the connections, the hostNetwork pods are not told apart, err: %v", plugin, err)
+		}
+		for _, h := range hosts {
+			klog.Infof("%s: host interface, index: %d, ip: %s", plugin, h.Link.Attrs().Index, h.Neigh.IP.String())
+			l := linkOf(h)
+			a.hosts[l.Index] = l
+			a.attach(l)
+		}
+	}
+	go a.follow(k.RegisterNetLinkListener())
+	go a.refresh(k)
+	return a, nil
+}
+
+func (a *Attacher) follow(events <-chan kprobe.NeighLinkEvent) {
+	for event := range events {
+		switch event.Type {
+		case kprobe.LinkAdd:
+			klog.Infof("%s: veth add, index: %d, ip: %s", a.plugin, event.Link.Attrs().Index, event.Neigh.IP.String())
+			a.attach(linkOf(event.NeighLink))
+		case kprobe.LinkDelete:
+			klog.Infof("%s: veth del, index: %d", a.plugin, event.Link.Attrs().Index)
+			a.detach(key{index: event.Link.Attrs().Index})
+		default:
+			klog.Infof("%s: unknown event type: %v", a.plugin, event.Type)
+		}
+	}
+}
+
+// refresh attaches the shared interfaces and the internal ports once the pods are known and every
+// refreshInterval until the Attacher is closed.
+func (a *Attacher) refresh(k kprobe.Interface) {
+	select {
+	case <-k.Synced():
+	case <-a.done:
+		return
+	}
+	ticker := a.clock.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		a.attachShared(k.GetSharedInterfaces())
+		a.attachNamespaced(k.GetNamespacedInterfaces())
+		select {
+		case <-ticker.C():
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// attachShared attaches the shared interfaces, again if their pods changed. A shared interface holding the
+// ip of the node keeps the hostNetwork pods.
+func (a *Attacher) attachShared(shared []kprobe.NeighLink) {
+	for _, s := range shared {
+		l := linkOf(s)
+		a.RLock()
+		if host, ok := a.hosts[l.Index]; ok {
+			l.IP = host.IP
+		}
+		current, ok := a.links[l.key()]
+		a.RUnlock()
+		if ok && samePods(current.Pods, l.Pods) {
+			continue
+		}
+		klog.Infof("%s: shared interface, index: %d, pods: %v", a.plugin, l.Index, l.Pods)
+		if ok {
+			a.detach(l.key())
+		}
+		a.attach(l)
+	}
+}
+
+// attachNamespaced attaches the new internal ports and detaches the ones gone.
+func (a *Attacher) attachNamespaced(ports []kprobe.NeighLink) {
+	live := make(map[key]bool, len(ports))
+	for _, port := range ports {
+		l := linkOf(port)
+		live[l.key()] = true
+		a.RLock()
+		_, ok := a.probes[l.key()]
+		a.RUnlock()
+		if ok {
+			continue
+		}
+		klog.Infof("%s: namespaced interface, netns: %s, index: %d, ip: %s", a.plugin, l.NetNS, l.Index, l.IP)
+		a.attach(l)
+	}
+	a.RLock()
+	var gone []key
+	for k := range a.probes {
+		if k.netNS != "" && !live[k] {
+			gone = append(gone, k)
+		}
+	}
+	a.RUnlock()
+	for _, k := range gone {
+		a.detach(k)
+	}
+}
+
+func (a *Attacher) attach(l Link) {
+	a.Lock()
+	defer a.Unlock()
+	if _, ok := a.probes[l.key()]; ok {
+		return
+	}
+	probe, err := a.load(l)
+	if err != nil {
+		klog.Errorf("failed to load %s ebpf program for interface index: %d, ip: %s, netns: %s, err: %v", a.plugin, l.Index, l.IP, l.NetNS, err)
+		// the indexes of the internal ports are not the ones of the node.
+		if l.NetNS == "" {
+			coverage.Quarantine(a.plugin, l.Index, err)
+		}
+		return
+	}
+	a.probes[l.key()] = probe
+	a.links[l.key()] = l
+	coverage.Attached(a.plugin, l.IPs())
+}
+
+func (a *Attacher) detach(k key) {
+	a.Lock()
+	defer a.Unlock()
+	if probe, ok := a.probes[k]; ok {
+		_ = probe.Close()
+		coverage.Detached(a.plugin, a.links[k].IPs())
+		delete(a.probes, k)
+		delete(a.links, k)
+	}
+}
+
+// Local reports whether ip is the ip of a pod behind an attached interface.
+func (a *Attacher) Local(ip string) bool {
+	if a == nil {
+		return false
+	}
+	a.RLock()
+	defer a.RUnlock()
+	for _, l := range a.links {
+		if l.has(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the probes, a nil Attacher (the plugin never gathered) has none.
+func (a *Attacher) Close() {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+	for k, probe := range a.probes {
+		_ = probe.Close()
+		coverage.Detached(a.plugin, a.links[k].IPs())
+		delete(a.probes, k)
+		delete(a.links, k)
+	}
+}
@@ -0,0 +1,208 @@
+package attach
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+)
+
+// fakeKprobe lists the veths and notifies their events, the other methods are not used by the Attacher.
+type fakeKprobe struct {
+	kprobe.Interface
+	vethes []kprobe.NeighLink
+	hosts  []kprobe.NeighLink
+	events chan kprobe.NeighLinkEvent
+	synced chan struct{}
+}
+
+func (f *fakeKprobe) Synced() <-chan struct{} {
+	return f.synced
+}
+
+func (f *fakeKprobe) GetHostInterfaces() []kprobe.NeighLink {
+	return f.hosts
+}
+
+func (f *fakeKprobe) GetVethes() ([]kprobe.NeighLink, error) {
+	return f.vethes, nil
+}
+
+func (f *fakeKprobe) RegisterNetLinkListener() <-chan kprobe.NeighLinkEvent {
+	return f.events
+}
+
+func veth(index int, ip string) kprobe.NeighLink {
+	return kprobe.NeighLink{
+		Neigh: netlink.Neigh{IP: net.ParseIP(ip)},
+		Link:  &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Index: index}},
+	}
+}
+
+type fakeProbe struct {
+	sync.Mutex
+	closed bool
+}
+
+func (f *fakeProbe) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeProbe) isClosed() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.closed
+}
+
+func TestAttacher(t *testing.T) {
+	k := &fakeKprobe{
+		vethes: []kprobe.NeighLink{veth(3, "10.0.1.2"), veth(4, "10.0.1.3")},
+		hosts:  []kprobe.NeighLink{veth(2, "192.168.0.10")},
+		events: make(chan kprobe.NeighLinkEvent),
+		// never synced, the shared interfaces are tested by TestAttachShared.
+		synced: make(chan struct{}),
+	}
+	var (
+		mu     sync.Mutex
+		probes = make(map[int]*fakeProbe)
+	)
+	a, err := Start("test", k, func(l Link) (Probe, error) {
+		if l.Index == 4 {
+			return nil, errors.New("attach failed")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		probes[l.Index] = &fakeProbe{}
+		return probes[l.Index], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Local("10.0.1.2") {
+		t.Errorf("10.0.1.2 should be local")
+	}
+	if a.Local("10.0.1.3") {
+		t.Errorf("10.0.1.3 failed to attach and should not be local")
+	}
+	if !a.Local("192.168.0.10") {
+		t.Errorf("the host interface should be attached")
+	}
+
+	k.events <- kprobe.NeighLinkEvent{Type: kprobe.LinkAdd, NeighLink: veth(5, "10.0.1.4")}
+	k.events <- kprobe.NeighLinkEvent{Type: kprobe.LinkDelete, NeighLink: veth(3, "10.0.1.2")}
+	// the events are handled in order, the repeated add is only received once the delete is done.
+	k.events <- kprobe.NeighLinkEvent{Type: kprobe.LinkAdd, NeighLink: veth(5, "10.0.1.4")}
+	if !a.Local("10.0.1.4") {
+		t.Errorf("10.0.1.4 should be local")
+	}
+	if a.Local("10.0.1.2") {
+		t.Errorf("10.0.1.2 is deleted and should not be local")
+	}
+	mu.Lock()
+	deleted, added := probes[3], probes[5]
+	mu.Unlock()
+	if !deleted.isClosed() {
+		t.Errorf("the probe of the deleted veth should be closed")
+	}
+
+	a.Close()
+	if !added.isClosed() {
+		t.Errorf("the probes should be closed with the Attacher")
+	}
+	if a.Local("10.0.1.4") {
+		t.Errorf("no ip should be local once the Attacher is closed")
+	}
+	close(k.events)
+	// a nil Attacher is closed without probes.
+	var none *Attacher
+	none.Close()
+}
+
+func shared(index int, pods ...string) kprobe.NeighLink {
+	l := veth(index, "0.0.0.0")
+	for _, pod := range pods {
+		l.Pods = append(l.Pods, net.ParseIP(pod))
+	}
+	return l
+}
+
+func TestAttachShared(t *testing.T) {
+	var loaded []Link
+	a := newAttacher("test", func(l Link) (Probe, error) {
+		loaded = append(loaded, l)
+		return &fakeProbe{}, nil
+	})
+	a.hosts[2] = Link{Index: 2, IP: "192.168.0.10"}
+
+	a.attachShared([]kprobe.NeighLink{shared(2, "192.168.0.21", "192.168.0.20"), shared(7, "10.1.0.2")})
+	if len(loaded) != 2 {
+		t.Fatalf("the shared interfaces should be attached, got %v", loaded)
+	}
+	if ips := a.links[key{index: 2}].IPs(); len(ips) != 3 || ips[0] != "192.168.0.10" || ips[1] != "192.168.0.20" {
+		t.Errorf("the shared host interface should keep the ip of the node and the pods sorted, got %v", ips)
+	}
+	if ips := a.links[key{index: 7}].IPs(); len(ips) != 1 || ips[0] != "10.1.0.2" {
+		t.Errorf("the shared interface should be restricted to its pods, got %v", ips)
+	}
+	if !a.Local("192.168.0.21") || !a.Local("10.1.0.2") {
+		t.Errorf("the pods of the shared interfaces should be local")
+	}
+
+	// the same pods are kept attached, a pod added attaches the interface again.
+	probe := a.probes[key{index: 7}].(*fakeProbe)
+	a.attachShared([]kprobe.NeighLink{shared(2, "192.168.0.20", "192.168.0.21"), shared(7, "10.1.0.2", "10.1.0.3")})
+	if len(loaded) != 3 || loaded[2].Index != 7 {
+		t.Fatalf("only the interface with a new pod should be attached again, got %v", loaded)
+	}
+	if !probe.isClosed() {
+		t.Errorf("the probe of the former pods should be closed")
+	}
+	if !a.Local("10.1.0.3") {
+		t.Errorf("the added pod should be local")
+	}
+	a.Close()
+}
+
+func port(netNS string, index int, ip string) kprobe.NeighLink {
+	l := veth(index, ip)
+	l.NetNS = netNS
+	return l
+}
+
+func TestAttachNamespaced(t *testing.T) {
+	var loaded []Link
+	a := newAttacher("test", func(l Link) (Probe, error) {
+		loaded = append(loaded, l)
+		return &fakeProbe{}, nil
+	})
+	a.attach(linkOf(veth(2, "10.0.1.2")))
+
+	// the indexes of the internal ports repeat across the namespaces.
+	a.attachNamespaced([]kprobe.NeighLink{port("/proc/10/ns/net", 2, "10.2.0.2"), port("/proc/11/ns/net", 2, "10.2.0.3")})
+	if len(loaded) != 3 || loaded[1].NetNS != "/proc/10/ns/net" {
+		t.Fatalf("the internal ports should be attached in their namespace, got %v", loaded)
+	}
+	if !a.Local("10.0.1.2") || !a.Local("10.2.0.2") || !a.Local("10.2.0.3") {
+		t.Errorf("the veth and the internal ports should be local")
+	}
+
+	gone := a.probes[key{netNS: "/proc/11/ns/net", index: 2}].(*fakeProbe)
+	a.attachNamespaced([]kprobe.NeighLink{port("/proc/10/ns/net", 2, "10.2.0.2")})
+	if len(loaded) != 3 {
+		t.Errorf("the attached internal port should be kept, got %v", loaded)
+	}
+	if !gone.isClosed() || a.Local("10.2.0.3") {
+		t.Errorf("the probe of the internal port gone should be closed")
+	}
+	if !a.Local("10.0.1.2") {
+		t.Errorf("the veth should be kept with the internal ports")
+	}
+	a.Close()
+}
@@ -0,0 +1,113 @@
+package brpc
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/brpc/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_rpc"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("brpc", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "brpc", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	isError := m.ErrorCode != 0
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"rpc_type":        "BRPC",
+			"rpc_target":      m.Service + "." + m.Method,
+			"rpc_service":     m.Service,
+			"rpc_method":      m.Method,
+			"brpc_error_code": strconv.Itoa(int(m.ErrorCode)),
+			"error":           strconv.FormatBool(isError),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if isError {
+		output.Tags["brpc_error"] = m.ErrorText
+		p.errorCodes.Tag(output.Tags, errorcodes.BRPC, strconv.Itoa(int(m.ErrorCode)))
+	}
+
+	inCluster := p.enricher.Enrich(output, "BRPC", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// unresolved targets are kept with their surrogate identity
+		if !enrich.Surrogate(output) {
+			return nil
+		}
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("brpc", &servicehub.Spec{
+		Services:             []string{"brpc"},
+		Description:          "ebpf for the baidu_std protocol of brpc",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
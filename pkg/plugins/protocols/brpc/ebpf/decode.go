@@ -0,0 +1,140 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// see brpc/policy/baidu_rpc_protocol.cpp and baidu_rpc_meta.proto
+const headerSize = 12
+
+var magic = []byte("PRPC")
+
+// field numbers of RpcMeta, RpcRequestMeta and RpcResponseMeta
+const (
+	metaRequest       protowire.Number = 1
+	metaResponse      protowire.Number = 2
+	metaCorrelationID protowire.Number = 4
+
+	requestServiceName protowire.Number = 1
+	requestMethodName  protowire.Number = 2
+
+	responseErrorCode protowire.Number = 1
+	responseErrorText protowire.Number = 2
+)
+
+type message struct {
+	request       bool
+	correlationID int64
+	service       string
+	method        string
+	errorCode     int32
+	errorText     string
+}
+
+// parseMessages parses the messages of a packet, the meta of the last one may be truncated.
+// Messages whose correlation id is cut off can not be paired and are left out.
+func parseMessages(buf []byte) []message {
+	var messages []message
+	for len(buf) >= headerSize && bytes.Equal(buf[:4], magic) {
+		bodySize := int(binary.BigEndian.Uint32(buf[4:8]))
+		metaSize := int(binary.BigEndian.Uint32(buf[8:12]))
+		if metaSize > bodySize {
+			break
+		}
+		rest := buf[headerSize:]
+		if m, ok := parseMeta(rest[:min(metaSize, len(rest))]); ok {
+			messages = append(messages, m)
+		}
+		if len(rest) < bodySize {
+			break
+		}
+		buf = rest[bodySize:]
+	}
+	return messages
+}
+
+// fields calls fn with the number and the value of each field of a protobuf message, the fields
+// before the truncation are visited.
+func fields(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return
+		}
+		fn(num, typ, b[:n])
+		b = b[n:]
+	}
+}
+
+func varint(typ protowire.Type, value []byte) (uint64, bool) {
+	if typ != protowire.VarintType {
+		return 0, false
+	}
+	v, n := protowire.ConsumeVarint(value)
+	return v, n > 0
+}
+
+func str(typ protowire.Type, value []byte) (string, bool) {
+	if typ != protowire.BytesType {
+		return "", false
+	}
+	v, n := protowire.ConsumeBytes(value)
+	return string(v), n > 0
+}
+
+// parseMeta parses the RpcMeta, requests carry the request meta, responses the response meta.
+func parseMeta(b []byte) (message, bool) {
+	var (
+		m                                         message
+		hasRequest, hasResponse, hasCorrelationID bool
+	)
+	fields(b, func(num protowire.Number, typ protowire.Type, value []byte) {
+		switch num {
+		case metaRequest:
+			v, ok := str(typ, value)
+			if !ok {
+				return
+			}
+			hasRequest = true
+			fields([]byte(v), func(num protowire.Number, typ protowire.Type, value []byte) {
+				switch num {
+				case requestServiceName:
+					m.service, _ = str(typ, value)
+				case requestMethodName:
+					m.method, _ = str(typ, value)
+				}
+			})
+		case metaResponse:
+			v, ok := str(typ, value)
+			if !ok {
+				return
+			}
+			hasResponse = true
+			fields([]byte(v), func(num protowire.Number, typ protowire.Type, value []byte) {
+				switch num {
+				case responseErrorCode:
+					if v, ok := varint(typ, value); ok {
+						m.errorCode = int32(v)
+					}
+				case responseErrorText:
+					m.errorText, _ = str(typ, value)
+				}
+			})
+		case metaCorrelationID:
+			if v, ok := varint(typ, value); ok {
+				m.correlationID = int64(v)
+				hasCorrelationID = true
+			}
+		}
+	})
+	m.request = hasRequest
+	return m, hasCorrelationID && (hasRequest || hasResponse)
+}
@@ -0,0 +1,104 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func baiduStd(meta []byte, payload string) []byte {
+	b := append([]byte(nil), magic...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(meta)+len(payload)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(meta)))
+	return append(append(b, meta...), payload...)
+}
+
+func request(id int64, service, method string) []byte {
+	var r []byte
+	r = protowire.AppendTag(r, requestServiceName, protowire.BytesType)
+	r = protowire.AppendString(r, service)
+	r = protowire.AppendTag(r, requestMethodName, protowire.BytesType)
+	r = protowire.AppendString(r, method)
+	var meta []byte
+	meta = protowire.AppendTag(meta, metaRequest, protowire.BytesType)
+	meta = protowire.AppendBytes(meta, r)
+	meta = protowire.AppendTag(meta, metaCorrelationID, protowire.VarintType)
+	meta = protowire.AppendVarint(meta, uint64(id))
+	return baiduStd(meta, "payload")
+}
+
+func response(id int64, code int32, text string) []byte {
+	var r []byte
+	if code != 0 {
+		r = protowire.AppendTag(r, responseErrorCode, protowire.VarintType)
+		r = protowire.AppendVarint(r, uint64(code))
+		r = protowire.AppendTag(r, responseErrorText, protowire.BytesType)
+		r = protowire.AppendString(r, text)
+	}
+	var meta []byte
+	meta = protowire.AppendTag(meta, metaResponse, protowire.BytesType)
+	meta = protowire.AppendBytes(meta, r)
+	meta = protowire.AppendTag(meta, metaCorrelationID, protowire.VarintType)
+	meta = protowire.AppendVarint(meta, uint64(id))
+	return baiduStd(meta, "payload")
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 8000}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, messages ...[]byte) []*Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	ev := BrpcEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	var buf []byte
+	for _, m := range messages {
+		buf = append(buf, m...)
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	return t.handle(&key, &ev)
+}
+
+func TestCall(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 10, true, request(1, "example.EchoService", "Echo"), request(2, "example.EchoService", "Stream"))
+	done := packet(tr, 30, false, response(2, 1008, "reached timeout=100ms"), response(1, 0, ""))
+	if len(done) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(done))
+	}
+	m := done[0]
+	if m.Method != "Stream" || m.ErrorCode != 1008 || m.ErrorText != "reached timeout=100ms" || m.Duration != 20 {
+		t.Errorf("unexpected call %+v", m)
+	}
+	m = done[1]
+	if m.Service != "example.EchoService" || m.Method != "Echo" || m.ErrorCode != 0 || m.SourcePort != 40000 || m.DestPort != 8000 {
+		t.Errorf("unexpected call %+v", m)
+	}
+
+	// requests to the pod are reported by their clients
+	packet(tr, 40, false, request(3, "example.EchoService", "Echo"))
+	if done := packet(tr, 50, true, response(3, 0, "")); len(done) != 0 {
+		t.Errorf("calls served by the pod should be ignored, got %+v", done)
+	}
+	packet(tr, 60, true, request(4, "example.EchoService", "Echo"))
+	tr.expire(requestTimeout + 61)
+	if len(tr.conns) != 0 {
+		t.Errorf("requests without response should be dropped")
+	}
+}
+
+func TestTruncatedMeta(t *testing.T) {
+	b := request(7, "example.EchoService", "Echo")
+	// the correlation id follows the request meta
+	if messages := parseMessages(b[:len(b)-len("payload")-2]); len(messages) != 0 {
+		t.Errorf("messages without correlation id should be left out, got %+v", messages)
+	}
+	if messages := parseMessages(b[:len(b)-3]); len(messages) != 1 || messages[0].correlationID != 7 || !messages[0].request {
+		t.Errorf("unexpected messages %+v", messages)
+	}
+}
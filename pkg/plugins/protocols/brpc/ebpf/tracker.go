@@ -0,0 +1,99 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// requestTimeout drops requests without response, it is above the default timeouts of brpc.
+	requestTimeout = uint64(60e9)
+	// maxPendingPerConn bounds the memory of connections whose responses are not captured.
+	maxPendingPerConn = 4096
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+}
+
+// tracker pairs the requests with their responses by the correlation id of the connection. Connections are
+// keyed in the client -> server direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]map[int64]*pending
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]map[int64]*pending)}
+}
+
+// handle processes the commands of a packet, it returns the calls completed by it.
+func (t *tracker) handle(key *EventKey, ev *BrpcEvent) []*Metric {
+	fromPod := ev.FromPod == 1
+	connKey := key.Conn
+	if !fromPod {
+		connKey = ConnKey{
+			SourceIP:   key.Conn.DestIP,
+			DestIP:     key.Conn.SourceIP,
+			SourcePort: key.Conn.DestPort,
+			DestPort:   key.Conn.SourcePort,
+		}
+	}
+	var done []*Metric
+	for _, c := range parseMessages(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]) {
+		// requests of the pod and responses to it, the calls served by the pod are reported by their clients.
+		if c.request != fromPod {
+			continue
+		}
+		if c.request {
+			inflight := t.conns[connKey]
+			if inflight == nil {
+				inflight = make(map[int64]*pending)
+				t.conns[connKey] = inflight
+			}
+			if len(inflight) >= maxPendingPerConn {
+				continue
+			}
+			inflight[c.correlationID] = &pending{
+				metric: &Metric{
+					SourceIP:   net.IP(connKey.SourceIP[:]).String(),
+					SourcePort: connKey.SourcePort,
+					DestIP:     net.IP(connKey.DestIP[:]).String(),
+					DestPort:   connKey.DestPort,
+					Service:    c.service,
+					Method:     c.method,
+
+					SocketCookie: ev.Cookie,
+				},
+				ts: key.Timestamp,
+			}
+			continue
+		}
+		p, ok := t.conns[connKey][c.correlationID]
+		if !ok {
+			continue
+		}
+		delete(t.conns[connKey], c.correlationID)
+		m := p.metric
+		m.ErrorCode, m.ErrorText = c.errorCode, c.errorText
+		m.Captured = key.Timestamp
+		if key.Timestamp > p.ts {
+			m.Duration = key.Timestamp - p.ts
+		}
+		done = append(done, m)
+	}
+	return done
+}
+
+// expire drops the requests without response within requestTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, inflight := range t.conns {
+		for id, p := range inflight {
+			if now-p.ts > requestTimeout {
+				delete(inflight, id)
+			}
+		}
+		if len(inflight) == 0 {
+			delete(t.conns, key)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	BrpcPayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type BrpcEvent struct {
+	socketfilter.Header
+	Payload [BrpcPayloadSize]byte
+}
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Service is the full name of the protobuf service, e.g. example.EchoService.
+	Service string
+	Method  string
+
+	// ErrorCode is the error code of the response meta, 0 for successful calls.
+	ErrorCode int32
+	ErrorText string
+
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s brpc [%s:%d] --> [%s:%d][%s.%s] ====> %d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Service, m.Method,
+		m.ErrorCode, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,118 @@
+package clickhouse
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/clickhouse/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup      = "application_db"
+	measurementGroupError = measurementGroup + "_error"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("clickhouse", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "clickhouse", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	measurement := measurementGroup
+	if m.Error {
+		measurement = measurementGroupError
+	}
+	output := &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"db_type":                  "clickhouse",
+			"db_statement":             m.Statement,
+			"db_statement_fingerprint": m.Fingerprint,
+			"db_operation":             m.Operation,
+			"clickhouse_client":        m.Client,
+			"clickhouse_secondary":     strconv.FormatBool(m.Secondary),
+			"error":                    strconv.FormatBool(m.Error),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if m.Error {
+		output.Tags["db_error_code"] = strconv.Itoa(int(m.ErrorCode))
+		output.Tags["db_error"] = m.ErrorMessage
+		output.Tags["clickhouse_exception"] = m.ErrorName
+		p.errorCodes.Tag(output.Tags, errorcodes.ClickHouse, output.Tags["db_error_code"])
+	}
+
+	inCluster := p.enricher.Enrich(output, "CLICKHOUSE", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	output.Tags["db_host"] = output.Tags["peer_address"]
+	// databases outside the cluster (e.g. ClickHouse Cloud) are still reported, they are not part of the topology.
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("clickhouse", &servicehub.Spec{
+		Services:             []string{"clickhouse"},
+		Description:          "ebpf for clickhouse native protocol",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
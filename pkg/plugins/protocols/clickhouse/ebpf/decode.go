@@ -0,0 +1,259 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// packet types, see src/Core/Protocol.h of clickhouse
+const (
+	clientQuery = 1
+
+	serverException     = 2
+	serverProgress      = 3
+	serverEndOfStream   = 5
+	serverProfileInfo   = 6
+	serverLog           = 10
+	serverProfileEvents = 14
+
+	queryKindInitial   = 1
+	queryKindSecondary = 2
+	interfaceTCP       = 1
+
+	// maxSettings bounds the settings read before the query.
+	maxSettings = 256
+)
+
+// protocol revisions changing the layout of queries, see src/Core/ProtocolDefines.h
+const (
+	revisionWithQuotaKeyInClientInfo  = 54060
+	revisionWithVersionPatch          = 54401
+	revisionWithSettingsAsStrings     = 54429
+	revisionWithInterserverSecret     = 54441
+	revisionWithOpenTelemetry         = 54442
+	revisionWithDistributedDepth      = 54448
+	revisionWithInitialQueryStartTime = 54449
+	revisionWithParallelReplicas      = 54453
+	revisionWithQueryAndLineNumbers   = 54475
+)
+
+// revisions are the candidate revisions of the connections whose revision is unknown, the
+// newest first. Only the revisions changing the layout of queries are tried, older revisions
+// serialize the settings in binary and are not supported.
+var revisions = []uint64{
+	revisionWithQueryAndLineNumbers,
+	revisionWithParallelReplicas,
+	revisionWithInitialQueryStartTime,
+	revisionWithDistributedDepth,
+	revisionWithOpenTelemetry,
+	revisionWithInterserverSecret,
+	revisionWithSettingsAsStrings,
+}
+
+type query struct {
+	revision  uint64
+	secondary bool
+	client    string
+	text      string
+}
+
+type response struct {
+	exception bool
+	code      int32
+	name      string
+	message   string
+}
+
+// reader reads the fields of a packet, ok is false once a field was truncated.
+type reader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *reader) uvarint() uint64 {
+	if !r.ok {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.ok = false
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) byte() byte {
+	if !r.ok || len(r.b) < 1 {
+		r.ok = false
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) skip(n int) {
+	if !r.ok || len(r.b) < n {
+		r.ok = false
+		return
+	}
+	r.b = r.b[n:]
+}
+
+func (r *reader) str() string {
+	n := r.uvarint()
+	if !r.ok || uint64(len(r.b)) < n {
+		r.ok = false
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// truncatedStr reads the last field of a packet, it returns the bytes before the truncation.
+func (r *reader) truncatedStr() string {
+	n := r.uvarint()
+	if !r.ok {
+		return ""
+	}
+	s := string(r.b[:min(n, uint64(len(r.b)))])
+	r.b = r.b[len(s):]
+	return s
+}
+
+// parseQuery parses a query sent with the given revision, see Connection::sendQuery and
+// ClientInfo::write. Only the queries of tcp clients are parsed, the text may be truncated.
+func parseQuery(buf []byte, revision uint64) (query, bool) {
+	q := query{revision: revision}
+	r := &reader{b: buf, ok: true}
+	if r.uvarint() != clientQuery {
+		return q, false
+	}
+	r.str() // query id
+	switch r.byte() {
+	case queryKindInitial:
+	case queryKindSecondary:
+		q.secondary = true
+	default:
+		return q, false
+	}
+	r.str() // initial user
+	r.str() // initial query id
+	r.str() // initial address
+	if revision >= revisionWithInitialQueryStartTime {
+		r.skip(8)
+	}
+	if r.byte() != interfaceTCP {
+		return q, false
+	}
+	r.str() // os user
+	r.str() // client hostname
+	q.client = r.str()
+	r.uvarint() // major
+	r.uvarint() // minor
+	// the revision is the minimum of the revisions of the client and the server
+	if clientRevision := r.uvarint(); clientRevision < revision {
+		return q, false
+	}
+	if revision >= revisionWithQuotaKeyInClientInfo {
+		r.str()
+	}
+	if revision >= revisionWithDistributedDepth {
+		r.uvarint()
+	}
+	if revision >= revisionWithVersionPatch {
+		r.uvarint()
+	}
+	if revision >= revisionWithOpenTelemetry {
+		switch r.byte() {
+		case 0:
+		case 1:
+			// trace id, span id, trace state, trace flags
+			r.skip(16 + 8)
+			r.str()
+			r.skip(1)
+		default:
+			return q, false
+		}
+	}
+	if revision >= revisionWithParallelReplicas {
+		r.uvarint() // collaborate with initiator
+		r.uvarint() // count participating replicas
+		r.uvarint() // number of current replica
+	}
+	if revision >= revisionWithQueryAndLineNumbers {
+		r.uvarint() // script query number
+		r.uvarint() // script line number
+	}
+	// settings as strings: name, flags and value, up to an empty name
+	for i := 0; ; i++ {
+		if i == maxSettings || !r.ok {
+			return q, false
+		}
+		if r.str() == "" {
+			break
+		}
+		r.uvarint()
+		r.str()
+	}
+	if revision >= revisionWithInterserverSecret {
+		r.str()
+	}
+	stage := r.uvarint()
+	compression := r.uvarint()
+	if !r.ok || stage > 2 || compression > 1 {
+		return q, false
+	}
+	q.text = r.truncatedStr()
+	return q, r.ok && q.text != ""
+}
+
+// parseQueryUnknownRevision parses a query of a connection whose revision is not known yet, the
+// revision is the first one the query is parsed with.
+func parseQueryUnknownRevision(buf []byte) (query, bool) {
+	for _, revision := range revisions {
+		if q, ok := parseQuery(buf, revision); ok {
+			return q, true
+		}
+	}
+	return query{}, false
+}
+
+// parseResponse recognizes the end of a response: an exception or a packet ending with the end of
+// stream, see TCPHandler::runImpl. The stack trace of exceptions is not read.
+func parseResponse(buf []byte, lastByte byte) (response, bool) {
+	var resp response
+	if len(buf) == 0 {
+		return resp, false
+	}
+	switch buf[0] {
+	case serverException:
+		if len(buf) < 5 {
+			return resp, false
+		}
+		resp.exception = true
+		resp.code = int32(binary.LittleEndian.Uint32(buf[1:5]))
+		r := &reader{b: buf[5:], ok: true}
+		resp.name = r.str()
+		resp.message = strings.TrimPrefix(r.truncatedStr(), resp.name+": ")
+		return resp, r.ok
+	case serverProgress, serverEndOfStream, serverProfileInfo, serverLog, serverProfileEvents:
+		return resp, lastByte == serverEndOfStream
+	default:
+		return resp, false
+	}
+}
+
+// operation returns the first keyword of a statement, e.g. SELECT.
+func operation(statement string) string {
+	statement = strings.TrimLeft(statement, " (")
+	end := strings.IndexFunc(statement, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(statement)
+	}
+	return strings.ToUpper(statement[:end])
+}
@@ -0,0 +1,132 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+type encoder []byte
+
+func (e encoder) uvarint(v uint64) encoder { return binary.AppendUvarint(e, v) }
+func (e encoder) byte(v byte) encoder      { return append(e, v) }
+func (e encoder) str(s string) encoder     { return append(e.uvarint(uint64(len(s))), s...) }
+
+// queryPacket encodes a query as clients of the given revision do with a server of the same revision.
+func queryPacket(revision uint64, text string, settings ...string) []byte {
+	e := encoder(nil).uvarint(clientQuery).str("5c7e4b6e-3a0d-4c43-9d0a-2a52a8b1c2d3")
+	e = e.byte(queryKindInitial).str("").str("").str("[::ffff:127.0.0.1]:0")
+	if revision >= revisionWithInitialQueryStartTime {
+		e = append(e, make([]byte, 8)...)
+	}
+	e = e.byte(interfaceTCP).str("analytics").str("analytics-7d9f").str("clickhouse-go/2.15.0")
+	e = e.uvarint(2).uvarint(15).uvarint(revision).str("")
+	if revision >= revisionWithDistributedDepth {
+		e = e.uvarint(0)
+	}
+	e = e.uvarint(0)
+	if revision >= revisionWithOpenTelemetry {
+		e = e.byte(1)
+		e = append(e, make([]byte, 24)...)
+		e = e.str("").byte(1)
+	}
+	if revision >= revisionWithParallelReplicas {
+		e = e.uvarint(0).uvarint(0).uvarint(0)
+	}
+	for i := 0; i+1 < len(settings); i += 2 {
+		e = e.str(settings[i]).uvarint(0).str(settings[i+1])
+	}
+	e = e.str("")
+	if revision >= revisionWithInterserverSecret {
+		e = e.str("")
+	}
+	return e.uvarint(2).uvarint(0).str(text)
+}
+
+func exceptionPacket(code int32, message string) []byte {
+	b := binary.LittleEndian.AppendUint32([]byte{serverException}, uint32(code))
+	return encoder(b).str("DB::Exception").str("DB::Exception: " + message).str("stack trace").byte(0)
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 9000}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, b []byte) *Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	ev := ClickhouseEvent{}
+	ev.Flag = b[len(b)-1]
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], b))
+	return t.handle(&key, &ev)
+}
+
+func TestParseQuery(t *testing.T) {
+	for _, revision := range []uint64{54460, 54449, 54442, 54429} {
+		q, ok := parseQueryUnknownRevision(queryPacket(revision, "SELECT 1", "max_threads", "4", "readonly", "1"))
+		if !ok || q.text != "SELECT 1" || q.client != "clickhouse-go/2.15.0" || q.revision > revision {
+			t.Errorf("unexpected query of revision %d: %+v", revision, q)
+		}
+	}
+	if _, ok := parseQueryUnknownRevision(queryPacket(54300, "SELECT 1")[:40]); ok {
+		t.Errorf("truncated client info should not be parsed")
+	}
+	// server data blocks: empty table name, block info
+	if _, ok := parseQueryUnknownRevision([]byte{1, 0, 1, 0, 2, 0xff, 0xff, 0xff, 0xff, 0, 1, 1}); ok {
+		t.Errorf("data blocks should not be parsed as queries")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tr := newTracker()
+	long := "SELECT count() FROM events WHERE app = 'orders' AND ts > now() - 3600 GROUP BY region ORDER BY region"
+	for len(long) < 600 {
+		long += " "
+	}
+	packet(tr, 10, true, queryPacket(54460, long))
+	// progress of a long query
+	if m := packet(tr, 20, false, []byte{serverProgress, 10, 100, 0, 0, 0, 0}); m != nil {
+		t.Errorf("progress should not end the query")
+	}
+	m := packet(tr, 30, false, []byte{serverProgress, 1, 1, 0, 0, 0, 0, serverProfileInfo, 1, 1, 1, 0, 0, 0, serverEndOfStream})
+	if m == nil {
+		t.Fatalf("the end of stream should end the query")
+	}
+	if m.Statement != "SELECT count() FROM events WHERE app = ? AND ts > now() - ? GROUP BY region ORDER BY region" ||
+		m.Operation != "SELECT" || m.Fingerprint == "" || m.Error || m.Duration != 20 || m.DestPort != 9000 {
+		t.Errorf("unexpected query %+v", m)
+	}
+	if tr.conns[client].revision != revisionWithParallelReplicas {
+		t.Errorf("the revision of the connection should be kept, got %d", tr.conns[client].revision)
+	}
+
+	packet(tr, 40, true, queryPacket(54460, "INSERT INTO events VALUES"))
+	m = packet(tr, 45, false, exceptionPacket(60, "Table default.events does not exist. (UNKNOWN_TABLE)"))
+	if m == nil || !m.Error || m.ErrorCode != 60 || m.ErrorName != "DB::Exception" || m.Operation != "INSERT" ||
+		m.ErrorMessage != "Table default.events does not exist. (UNKNOWN_TABLE)" {
+		t.Errorf("unexpected query %+v", m)
+	}
+	if m := packet(tr, 50, false, []byte{serverEndOfStream}); m != nil {
+		t.Errorf("responses without query should be ignored, got %+v", m)
+	}
+}
+
+func TestServerAndExpire(t *testing.T) {
+	tr := newTracker()
+	// queries to the pod are reported by their clients
+	packet(tr, 1, false, queryPacket(54460, "SELECT 1"))
+	if m := packet(tr, 2, true, []byte{serverEndOfStream}); m != nil {
+		t.Errorf("queries served by the pod should be ignored, got %+v", m)
+	}
+	packet(tr, 3, true, queryPacket(54460, "SELECT 1"))
+	tr.expire(requestTimeout + 5)
+	if c := tr.conns[client]; c != nil && c.pending != nil {
+		t.Errorf("queries without response should be dropped")
+	}
+	if len(tr.conns) != 0 {
+		t.Errorf("idle connections should be dropped")
+	}
+}
@@ -0,0 +1,126 @@
+package ebpf
+
+import (
+	"net"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+const (
+	// requestTimeout drops queries without response, analytic queries run for minutes.
+	requestTimeout = uint64(600e9)
+	// connIdleTimeout forgets the revision of connections without queries.
+	connIdleTimeout = uint64(600e9)
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+}
+
+// conn is the state of a client connection, queries are not pipelined: a query is answered
+// before the next one is sent.
+type conn struct {
+	// revision is the candidate revision the queries of the connection were parsed with, 0 if unknown.
+	revision uint64
+	pending  *pending
+	lastSeen uint64
+}
+
+// tracker pairs the queries with the end of their responses. Connections are keyed in the
+// client -> server direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]*conn
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]*conn)}
+}
+
+// handle processes a packet, it returns the query completed by it.
+func (t *tracker) handle(key *EventKey, ev *ClickhouseEvent) *Metric {
+	buf := ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]
+	// queries of the pod and the responses to it, connections of server pods are ignored that way.
+	if ev.FromPod == 1 {
+		c := t.conns[key.Conn]
+		if c == nil {
+			c = &conn{}
+		}
+		var (
+			q  query
+			ok bool
+		)
+		if c.revision != 0 {
+			q, ok = parseQuery(buf, c.revision)
+		}
+		if !ok {
+			q, ok = parseQueryUnknownRevision(buf)
+		}
+		if !ok {
+			return nil
+		}
+		statement := dbstatement.Normalize(q.text)
+		c.revision = q.revision
+		c.lastSeen = key.Timestamp
+		c.pending = &pending{
+			metric: &Metric{
+				SourceIP:    net.IP(key.Conn.SourceIP[:]).String(),
+				SourcePort:  key.Conn.SourcePort,
+				DestIP:      net.IP(key.Conn.DestIP[:]).String(),
+				DestPort:    key.Conn.DestPort,
+				Statement:   statement,
+				Fingerprint: dbstatement.Fingerprint(statement),
+				Operation:   operation(statement),
+				Secondary:   q.secondary,
+				Client:      q.client,
+
+				SocketCookie: ev.Cookie,
+			},
+			ts: key.Timestamp,
+		}
+		t.conns[key.Conn] = c
+		return nil
+	}
+	connKey := ConnKey{
+		SourceIP:   key.Conn.DestIP,
+		DestIP:     key.Conn.SourceIP,
+		SourcePort: key.Conn.DestPort,
+		DestPort:   key.Conn.SourcePort,
+	}
+	c := t.conns[connKey]
+	if c == nil || c.pending == nil {
+		return nil
+	}
+	resp, ok := parseResponse(buf, ev.Flag)
+	if !ok {
+		return nil
+	}
+	p := c.pending
+	c.pending = nil
+	c.lastSeen = key.Timestamp
+	m := p.metric
+	if resp.exception {
+		m.Error = true
+		m.ErrorCode = resp.code
+		m.ErrorName = resp.name
+		m.ErrorMessage = resp.message
+	}
+	m.Captured = key.Timestamp
+	if key.Timestamp > p.ts {
+		m.Duration = key.Timestamp - p.ts
+	}
+	return m
+}
+
+// expire drops the queries without response within requestTimeout and the connections idle for
+// connIdleTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, c := range t.conns {
+		if c.pending != nil && now-c.pending.ts > requestTimeout {
+			c.pending = nil
+		}
+		if c.pending == nil && now-c.lastSeen > connIdleTimeout {
+			delete(t.conns, key)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	ClickhousePayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+// ClickhouseEvent is a packet, the Flag of its header is the last byte of the packet: the payload may be
+// truncated.
+type ClickhouseEvent struct {
+	socketfilter.Header
+	Payload [ClickhousePayloadSize]byte
+}
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Statement is the normalized query, literals are replaced by '?', Fingerprint identifies it.
+	Statement   string
+	Fingerprint string
+	// Operation is the first keyword of the query, e.g. SELECT or INSERT.
+	Operation string
+	// Secondary is set for the queries sent by the servers of a distributed query.
+	Secondary bool
+	// Client is the client name of the client info, e.g. clickhouse-go/2.15.0.
+	Client string
+
+	Error bool
+	// ErrorCode is the code of the exception, ErrorName its class, e.g. DB::Exception.
+	ErrorCode    int32
+	ErrorName    string
+	ErrorMessage string
+
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s clickhouse [%s:%d] --> [%s:%d][%s] ====> %d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Statement,
+		m.ErrorCode, time.Duration(m.Duration).String(),
+	)
+}
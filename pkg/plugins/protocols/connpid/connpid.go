@@ -0,0 +1,123 @@
+// Package connpid resolves the tcp connections of the node to the process of their socket: the kprobes of
+// tcp_sendmsg and tcp_recvmsg record the pid of the sockets in filtered_connections (ebpf/include/sock.h), the
+// protocol plugins attaching the interfaces of the node attribute the requests of the hostNetwork pods by it.
+//
+// The kprobes are loaded once for all plugins by Start, Lookup finds nothing before.
+package connpid
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+const (
+	programPath = "target/connpid.bpf.o"
+	mapConns    = "filtered_connections"
+)
+
+var kprobes = []struct {
+	program, symbol string
+	ret             bool
+}{
+	{"kprobe_tcp_sendmsg", "tcp_sendmsg", false},
+	{"kprobe_tcp_recvmsg", "tcp_recvmsg", false},
+	{"kretprobe_tcp_recvmsg", "tcp_recvmsg", true},
+	{"kprobe_tcp_close", "tcp_close", false},
+}
+
+// connKey mirrors connection_info_t, the connection as seen by its socket, the ports in host order.
+type connKey struct {
+	LocalPort  uint16
+	RemotePort uint16
+	LocalIP    [4]byte
+	RemoteIP   [4]byte
+}
+
+// pidInfo mirrors connection_pid_info_t.
+type pidInfo struct {
+	Pid  uint32
+	Comm [16]byte
+	_    uint32
+	Tgid uint64
+}
+
+var (
+	mu    sync.Mutex
+	conns *ebpf.Map
+	// links keep the kprobes attached for the life of the agent.
+	links []link.Link
+)
+
+// Start loads the kprobes, the following calls do nothing once they are loaded.
+func Start() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != nil {
+		return nil
+	}
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	collection, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	attached := make([]link.Link, 0, len(kprobes))
+	for _, k := range kprobes {
+		attach := link.Kprobe
+		if k.ret {
+			attach = link.Kretprobe
+		}
+		l, err := attach(k.symbol, collection.Programs[k.program], nil)
+		if err != nil {
+			for _, l := range attached {
+				_ = l.Close()
+			}
+			collection.Close()
+			return err
+		}
+		attached = append(attached, l)
+	}
+	links = attached
+	conns = collection.DetachMap(mapConns)
+	return nil
+}
+
+// Lookup returns the pid of the socket of the node from localIP:localPort to remoteIP:remotePort, 0 if it
+// is unknown.
+func Lookup(localIP string, localPort uint16, remoteIP string, remotePort uint16) uint32 {
+	mu.Lock()
+	m := conns
+	mu.Unlock()
+	if m == nil {
+		return 0
+	}
+	key := connKey{LocalPort: localPort, RemotePort: remotePort}
+	if !ip4(localIP, &key.LocalIP) || !ip4(remoteIP, &key.RemoteIP) {
+		return 0
+	}
+	var info pidInfo
+	if err := m.Lookup(key, &info); err != nil {
+		return 0
+	}
+	return info.Pid
+}
+
+func ip4(s string, b *[4]byte) bool {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return false
+	}
+	copy(b[:], ip)
+	return true
+}
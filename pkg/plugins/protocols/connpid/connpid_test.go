@@ -0,0 +1,22 @@
+package connpid
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	// connection_info_t and connection_pid_info_t of ebpf/include/sock.h
+	if size := binary.Size(connKey{}); size != 12 {
+		t.Errorf("expected the key on 12 bytes, got %d", size)
+	}
+	if size := binary.Size(pidInfo{}); size != 32 {
+		t.Errorf("expected the pid info on 32 bytes, got %d", size)
+	}
+}
+
+func TestLookupNotStarted(t *testing.T) {
+	if pid := Lookup("192.168.0.1", 8080, "10.0.1.2", 43210); pid != 0 {
+		t.Errorf("expected no pid before Start, got %d", pid)
+	}
+}
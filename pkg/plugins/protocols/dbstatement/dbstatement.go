@@ -0,0 +1,104 @@
+// Package dbstatement normalizes the sql statements and cache keys captured by the database protocol plugins.
+package dbstatement
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+var (
+	placeholderListRegexp = regexp.MustCompile(`\(\s*\?(\s*,\s*\?)+\s*\)`)
+)
+
+// Normalize replaces the literals of a sql statement with '?' and
+// collapses whitespaces, so that statements only differing in their arguments
+// are aggregated into the same series, e.g.
+//
+//	SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'foo'
+//
+// becomes
+//
+//	SELECT * FROM t WHERE id IN (?) AND name = ?
+//
+// Positional parameters like ? or $1 are kept. The statement may be truncated
+// by the kernel probe, an unterminated literal is replaced as a whole.
+func Normalize(statement string) string {
+	var (
+		out       = make([]byte, 0, len(statement))
+		lastSpace = true
+	)
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(statement, i)
+			out = append(out, '?')
+			lastSpace = false
+		case isDigit(c) && (i == 0 || !isIdentifier(statement[i-1])):
+			for i+1 < len(statement) && (isIdentifier(statement[i+1]) || statement[i+1] == '.') {
+				i++
+			}
+			out = append(trimUnaryMinus(out), '?')
+			lastSpace = false
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if !lastSpace {
+				out = append(out, ' ')
+			}
+			lastSpace = true
+		default:
+			out = append(out, c)
+			lastSpace = false
+		}
+	}
+	return placeholderListRegexp.ReplaceAllString(strings.TrimSpace(string(out)), "(?)")
+}
+
+// Fingerprint returns a short id of a normalized statement, statements only differing in the case of
+// their keywords and identifiers share it.
+func Fingerprint(statement string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(statement)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// trimUnaryMinus drops a trailing '-' that is the sign of the following number rather than a subtraction.
+func trimUnaryMinus(out []byte) []byte {
+	if len(out) == 0 || out[len(out)-1] != '-' {
+		return out
+	}
+	prev := bytes.TrimRight(out[:len(out)-1], " ")
+	if len(prev) == 0 || strings.IndexByte("(,=<>+-*/", prev[len(prev)-1]) >= 0 {
+		return out[:len(out)-1]
+	}
+	return out
+}
+
+// skipQuoted returns the index of the closing quote of the literal starting at start,
+// doubled quotes and backslash escapes are part of the literal.
+func skipQuoted(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(s) - 1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,48 @@
+package dbstatement
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		statement string
+		want      string
+	}{
+		{"SELECT * FROM t WHERE id = 1", "SELECT * FROM t WHERE id = ?"},
+		{"select  *\n from t_1 where name='it''s' and v = \"x\"", "select * from t_1 where name=? and v = ?"},
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", "SELECT * FROM t WHERE id IN (?)"},
+		{"INSERT INTO t (a, b) VALUES (0x1F, -2.5)", "INSERT INTO t (a, b) VALUES (?)"},
+		{"UPDATE t SET a = ? WHERE b = ?", "UPDATE t SET a = ? WHERE b = ?"},
+		{"SELECT * FROM t WHERE a = $1 AND b > 10", "SELECT * FROM t WHERE a = $1 AND b > ?"},
+		{"SELECT * FROM t WHERE name = 'trunc", "SELECT * FROM t WHERE name = ?"},
+	}
+	for _, c := range cases {
+		if got := Normalize(c.statement); got != c.want {
+			t.Errorf("Normalize(%q) = %q, want %q", c.statement, got, c.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint(Normalize("SELECT * FROM t WHERE id = 1"))
+	if b := Fingerprint(Normalize("select *  from T where ID = 2")); a != b || len(a) != 16 {
+		t.Errorf("fingerprints %q and %q should be equal", a, b)
+	}
+	if b := Fingerprint(Normalize("SELECT * FROM t WHERE name = 'x'")); a == b {
+		t.Errorf("fingerprints of different statements should differ")
+	}
+}
+
+func TestKeyPattern(t *testing.T) {
+	cases := map[string]string{
+		"user:42:profile": "user:*:profile",
+		"order_20231013":  "order_*",
+		"session:3f2b9c1e-8a7d-4e5f-9b1a-2c3d4e5f6a7b": "session:*",
+		"cache:v2:items": "cache:v*:items",
+		"config":         "config",
+	}
+	for key, want := range cases {
+		if got := KeyPattern(key); got != want {
+			t.Errorf("KeyPattern(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
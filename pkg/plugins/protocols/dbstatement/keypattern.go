@@ -0,0 +1,54 @@
+package dbstatement
+
+import "strings"
+
+// KeyPattern replaces the variable parts of a cache key with '*', segments separated by ':'
+// that look like ids (uuids, hex digests) are replaced as a whole, runs of digits
+// within the other segments, e.g. user:42:profile becomes user:*:profile and
+// order_20231013 becomes order_*.
+func KeyPattern(key string) string {
+	segments := strings.Split(key, ":")
+	for i, s := range segments {
+		if isHexID(s) {
+			segments[i] = "*"
+			continue
+		}
+		segments[i] = replaceDigits(s)
+	}
+	return strings.Join(segments, ":")
+}
+
+func isHexID(s string) bool {
+	if len(s) < 16 {
+		return false
+	}
+	hasDigit := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+		default:
+			return false
+		}
+	}
+	return hasDigit
+}
+
+func replaceDigits(s string) string {
+	var b strings.Builder
+	inDigits := false
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			if !inDigits {
+				b.WriteByte('*')
+			}
+			inDigits = true
+			continue
+		}
+		inDigits = false
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dns/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_dns"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("dns", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "dns", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	transport := "udp"
+	if m.TCP {
+		transport = "tcp"
+	}
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"dns_name":      m.Name,
+			"dns_qtype":     m.QType,
+			"dns_rcode":     m.RCode,
+			"dns_transport": transport,
+			"dns_truncated": strconv.FormatBool(m.Truncated),
+			"dns_timeout":   strconv.FormatBool(m.Timeout),
+			"error":         strconv.FormatBool(m.Error),
+		},
+		Fields: map[string]interface{}{
+			"query_count":  1,
+			"answer_count": m.Answers,
+		},
+	}
+	// queries without response have no latency.
+	if !m.Timeout {
+		output.Fields["elapsed_count"] = 1
+		output.Fields["elapsed_sum"] = m.Duration
+		output.Fields["elapsed_max"] = m.Duration
+		output.Fields["elapsed_min"] = m.Duration
+		output.Fields["elapsed_mean"] = m.Duration
+	}
+
+	// the source is the client pod, the target the resolver (e.g. the coredns pod behind the kube-dns service).
+	inCluster := p.enricher.Enrich(output, "DNS", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	// resolvers outside the cluster (e.g. the resolver of the node) are still reported, they are not part of the topology.
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("dns", &servicehub.Spec{
+		Services:             []string{"dns"},
+		Description:          "ebpf for dns",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,142 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// see https://www.rfc-editor.org/rfc/rfc1035#section-4.1
+const (
+	headerSize    = 12
+	flagResponse  = 0x8000
+	flagTruncated = 0x0200
+	maxNameLength = 255
+	labelPointer  = 0xc0
+)
+
+// qtypes are the names of the common query types, see https://www.iana.org/assignments/dns-parameters.
+var qtypes = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	12:  "PTR",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	35:  "NAPTR",
+	43:  "DS",
+	48:  "DNSKEY",
+	64:  "SVCB",
+	65:  "HTTPS",
+	252: "AXFR",
+	255: "ANY",
+}
+
+// rcodes are the names of the response codes of the header.
+var rcodes = map[uint16]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+// isError reports whether the response code is a failure of the resolver, negative answers are regular outcomes.
+func isError(rcode uint16) bool {
+	switch rcode {
+	case 0, 3, 6, 7, 8:
+		return false
+	default:
+		return true
+	}
+}
+
+type message struct {
+	id        uint16
+	response  bool
+	truncated bool
+	rcode     uint16
+	answers   int
+	name      string
+	qtype     string
+}
+
+// parseMessage parses the header and the question of a message, it returns false if the
+// message is not a query or a response with a single question.
+func parseMessage(buf []byte) (message, bool) {
+	var msg message
+	if len(buf) < headerSize {
+		return msg, false
+	}
+	flags := binary.BigEndian.Uint16(buf[2:4])
+	if binary.BigEndian.Uint16(buf[4:6]) != 1 {
+		return msg, false
+	}
+	msg.id = binary.BigEndian.Uint16(buf[0:2])
+	msg.response = flags&flagResponse != 0
+	msg.truncated = flags&flagTruncated != 0
+	msg.rcode = flags & 0x000f
+	msg.answers = int(binary.BigEndian.Uint16(buf[6:8]))
+
+	name, rest, ok := parseName(buf[headerSize:])
+	if !ok {
+		return msg, false
+	}
+	msg.name = namePattern(name)
+	if len(rest) >= 2 {
+		qtype := binary.BigEndian.Uint16(rest[0:2])
+		msg.qtype = qtypes[qtype]
+		if msg.qtype == "" {
+			msg.qtype = "TYPE" + strconv.Itoa(int(qtype))
+		}
+	}
+	return msg, true
+}
+
+// parseName reads the labels of the question name, names of questions are not compressed.
+func parseName(buf []byte) (string, []byte, bool) {
+	var labels []string
+	length := 0
+	for {
+		if len(buf) == 0 {
+			return "", nil, false
+		}
+		n := int(buf[0])
+		if n == 0 {
+			buf = buf[1:]
+			break
+		}
+		if n&labelPointer != 0 || len(buf) < 1+n {
+			return "", nil, false
+		}
+		length += 1 + n
+		if length > maxNameLength {
+			return "", nil, false
+		}
+		labels = append(labels, strings.ToLower(string(buf[1:1+n])))
+		buf = buf[1+n:]
+	}
+	if len(labels) == 0 {
+		return ".", buf, true
+	}
+	return strings.Join(labels, "."), buf, true
+}
+
+// namePattern replaces the addresses of reverse lookups, the other names are kept as they are.
+func namePattern(name string) string {
+	for _, zone := range []string{"in-addr.arpa", "ip6.arpa"} {
+		if strings.HasSuffix(name, "."+zone) {
+			return "*." + zone
+		}
+	}
+	return name
+}
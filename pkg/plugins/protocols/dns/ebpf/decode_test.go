@@ -0,0 +1,98 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func dnsMessage(id uint16, flags uint16, answers uint16, name string, qtype uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, answers)
+	b = append(b, 0, 0, 0, 0)
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	return binary.BigEndian.AppendUint16(b, 1)
+}
+
+var (
+	client   = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 96, 0, 10}, SourcePort: 40000, DestPort: 53}
+	resolver = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, msg []byte) []*Metric {
+	key := EventKey{Conn: resolver, Timestamp: ts}
+	ev := DnsEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], msg))
+	return t.handle(&key, &ev)
+}
+
+func TestQueries(t *testing.T) {
+	tr := newTracker()
+	name := "Orders.Default.svc.cluster.local"
+	packet(tr, 100, true, dnsMessage(1, 0x0100, 0, name, 1))
+	packet(tr, 110, true, dnsMessage(2, 0x0100, 0, name, 28))
+
+	done := packet(tr, 600, false, dnsMessage(2, 0x8183, 0, name, 28))
+	if len(done) != 1 {
+		t.Fatalf("expected 1 metric, got %d", len(done))
+	}
+	m := done[0]
+	if m.Name != "orders.default.svc.cluster.local" || m.QType != "AAAA" || m.RCode != "NXDOMAIN" || m.Error ||
+		m.Duration != 490 || m.DestPort != 53 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	// responses to another name with the same id are ignored
+	if done := packet(tr, 700, false, dnsMessage(1, 0x8180, 1, "example.com", 1)); len(done) != 0 {
+		t.Errorf("expected no metric, got %+v", done[0])
+	}
+	done = packet(tr, 800, false, dnsMessage(1, 0x8182, 0, name, 1))
+	if len(done) != 1 || done[0].RCode != "SERVFAIL" || !done[0].Error {
+		t.Errorf("unexpected metrics %+v", done)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 0, true, dnsMessage(9, 0x0100, 0, "10.0.0.1.in-addr.arpa", 12))
+	// the retransmission completes the first attempt
+	done := packet(tr, queryTimeout, true, dnsMessage(9, 0x0100, 0, "10.0.0.1.in-addr.arpa", 12))
+	if len(done) != 1 || !done[0].Timeout || done[0].RCode != "TIMEOUT" || done[0].Name != "*.in-addr.arpa" {
+		t.Errorf("unexpected metrics %+v", done)
+	}
+	if done := tr.expire(queryTimeout + 1); len(done) != 0 {
+		t.Errorf("the retransmission should still be pending, got %+v", done[0])
+	}
+	done = tr.expire(2*queryTimeout + 1)
+	if len(done) != 1 || done[0].QType != "PTR" || !done[0].Error {
+		t.Errorf("unexpected metrics %+v", done)
+	}
+	if len(tr.conns) != 0 {
+		t.Errorf("expected no pending queries, got %d sockets", len(tr.conns))
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	if _, ok := parseMessage([]byte{0, 1, 1, 0, 0, 2, 0, 0, 0, 0, 0, 0}); ok {
+		t.Error("messages with several questions should be rejected")
+	}
+	msg := dnsMessage(3, 0x0100, 0, "example.com", 65)
+	if _, ok := parseMessage(msg[:15]); ok {
+		t.Error("truncated names should be rejected")
+	}
+	m, ok := parseMessage(dnsMessage(3, 0x8380, 0, "example.com", 99))
+	if !ok || !m.truncated || m.qtype != "TYPE99" || m.name != "example.com" {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
@@ -0,0 +1,59 @@
+package ebpf
+
+import (
+	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	programPath = "target/dns.bpf.o"
+	programName = "socket__dns_filter"
+	mapMetric   = "metrics_map"
+)
+
+type Interface interface {
+	Load() error
+	Close() error
+}
+
+type provider struct {
+	link attach.Link
+	ch   chan Metric
+
+	filter *socketfilter.Filter
+}
+
+func New(l attach.Link, ch chan Metric) Interface {
+	return &provider{
+		link: l,
+		ch:   ch,
+	}
+}
+
+func (e *provider) Load() error {
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		return exclusion.Apply(c, "dns")
+	})
+	if err != nil {
+		return err
+	}
+	t := newTracker()
+	go socketfilter.Drain(e.filter, e.filter.Collection.DetachMap(mapMetric), func(key *EventKey, val *DnsEvent) {
+		for _, metric := range t.handle(key, val) {
+			e.ch <- *metric
+		}
+	}, func(now uint64) {
+		for _, metric := range t.expire(now) {
+			e.ch <- *metric
+		}
+	})
+	return nil
+}
+
+func (e *provider) Close() error {
+	return e.filter.Close()
+}
@@ -0,0 +1,125 @@
+package ebpf
+
+import (
+	"net"
+	"strconv"
+)
+
+const (
+	// queryTimeout is the default timeout of the resolvers (timeout option of resolv.conf).
+	queryTimeout = uint64(5e9)
+	// maxPendingPerConn bounds the memory of sockets whose responses are not captured.
+	maxPendingPerConn = 256
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+}
+
+// tracker pairs the queries with their responses by the id of the socket. Sockets are
+// keyed in the client -> resolver direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]map[uint16]*pending
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]map[uint16]*pending)}
+}
+
+// handle processes a message, it returns the queries completed by it.
+// Messages must be handled in the order of their timestamps.
+func (t *tracker) handle(key *EventKey, ev *DnsEvent) []*Metric {
+	msg, ok := parseMessage(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))])
+	if !ok {
+		return nil
+	}
+	fromPod := ev.FromPod == 1
+	if msg.response == fromPod {
+		return nil
+	}
+	connKey := key.Conn
+	if !fromPod {
+		connKey = ConnKey{
+			SourceIP:   key.Conn.DestIP,
+			DestIP:     key.Conn.SourceIP,
+			SourcePort: key.Conn.DestPort,
+			DestPort:   key.Conn.SourcePort,
+		}
+	}
+
+	if !msg.response {
+		inflight := t.conns[connKey]
+		if inflight == nil {
+			inflight = make(map[uint16]*pending)
+			t.conns[connKey] = inflight
+		}
+		var done []*Metric
+		// a retransmission of the query, the previous attempt timed out.
+		if p, ok := inflight[msg.id]; ok {
+			done = append(done, timedOut(p))
+		} else if len(inflight) >= maxPendingPerConn {
+			return nil
+		}
+		inflight[msg.id] = &pending{
+			metric: &Metric{
+				SourceIP:   net.IP(connKey.SourceIP[:]).String(),
+				SourcePort: connKey.SourcePort,
+				DestIP:     net.IP(connKey.DestIP[:]).String(),
+				DestPort:   connKey.DestPort,
+				TCP:        ev.Flag == 1,
+				Name:       msg.name,
+				QType:      msg.qtype,
+
+				SocketCookie: ev.Cookie,
+			},
+			ts: key.Timestamp,
+		}
+		return done
+	}
+
+	p, ok := t.conns[connKey][msg.id]
+	// the name guards against the reuse of ids by the sockets of long running resolvers.
+	if !ok || p.metric.Name != msg.name {
+		return nil
+	}
+	delete(t.conns[connKey], msg.id)
+	m := p.metric
+	m.RCode = rcodes[msg.rcode]
+	if m.RCode == "" {
+		m.RCode = "RCODE" + strconv.Itoa(int(msg.rcode))
+	}
+	m.Error = isError(msg.rcode)
+	m.Truncated = msg.truncated
+	m.Answers = msg.answers
+	m.Captured = key.Timestamp
+	if key.Timestamp > p.ts {
+		m.Duration = key.Timestamp - p.ts
+	}
+	return []*Metric{m}
+}
+
+// expire reports the queries without response within queryTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) []*Metric {
+	var done []*Metric
+	for key, inflight := range t.conns {
+		for id, p := range inflight {
+			if now-p.ts > queryTimeout {
+				delete(inflight, id)
+				done = append(done, timedOut(p))
+			}
+		}
+		if len(inflight) == 0 {
+			delete(t.conns, key)
+		}
+	}
+	return done
+}
+
+func timedOut(p *pending) *Metric {
+	m := p.metric
+	m.RCode = "TIMEOUT"
+	m.Timeout = true
+	m.Error = true
+	return m
+}
@@ -0,0 +1,59 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	DnsPayloadSize = 288
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+// DnsEvent is a message, the Flag of its header is 1 for the messages over tcp.
+type DnsEvent struct {
+	socketfilter.Header
+	Payload [DnsPayloadSize]byte
+}
+
+type Metric struct {
+	// Source is the client pod, Dest the resolver.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+	TCP        bool
+
+	// Name is the lower case query name without the trailing dot, reverse lookups are
+	// reported as *.in-addr.arpa and *.ip6.arpa.
+	Name  string
+	QType string
+
+	// RCode is the response code, e.g. NOERROR or NXDOMAIN, TIMEOUT for queries without response.
+	RCode     string
+	Timeout   bool
+	Truncated bool
+	Answers   int
+	// Error reports whether the resolver failed to answer the query, negative answers (NXDOMAIN) are not errors,
+	// the search domains of the pods make them part of most lookups.
+	Error bool
+
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s dns [%s:%d] --> [%s:%d][%s %s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.QType, m.Name,
+		m.RCode, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,77 @@
+package enrich
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+type ColdStartConfig struct {
+	// Window is the age of the pods whose first request is a cold start, the first requests to older
+	// pods are not, e.g. the pods running before the agent started. 0 disables the detection.
+	Window time.Duration `env:"L7_COLD_START_WINDOW" default:"5m"`
+}
+
+// coldStarts remembers the pods younger than the window that already served a request. It is shared
+// by the plugins, the first request to a pod is a cold start whatever its protocol.
+type coldStarts struct {
+	sync.Mutex
+	window    time.Duration
+	served    map[types.UID]time.Time
+	lastPrune time.Time
+}
+
+func newColdStarts(window time.Duration) *coldStarts {
+	return &coldStarts{window: window, served: make(map[types.UID]time.Time)}
+}
+
+// podStart is the time the pod was acknowledged by the kubelet, its creation if not yet known.
+func podStart(pod *corev1.Pod) time.Time {
+	if pod.Status.StartTime != nil {
+		return pod.Status.StartTime.Time
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// observe records a request to pod at now, it returns true with the age of the pod for its first request.
+func (c *coldStarts) observe(pod *corev1.Pod, now time.Time) (bool, time.Duration) {
+	start := podStart(pod)
+	age := now.Sub(start)
+	if c.window <= 0 || start.IsZero() || age < 0 || age > c.window {
+		return false, age
+	}
+	c.Lock()
+	defer c.Unlock()
+	// the pods out of the window can not have cold starts anymore
+	if now.Sub(c.lastPrune) > c.window {
+		for uid, s := range c.served {
+			if now.Sub(s) > c.window {
+				delete(c.served, uid)
+			}
+		}
+		c.lastPrune = now
+	}
+	if _, ok := c.served[pod.UID]; ok {
+		return false, age
+	}
+	c.served[pod.UID] = start
+	return true, age
+}
+
+var (
+	coldStartOnce    sync.Once
+	defaultColdStart *coldStarts
+)
+
+func coldStartTracker() *coldStarts {
+	coldStartOnce.Do(func() {
+		cfg := ColdStartConfig{}
+		envconf.MustLoad(&cfg)
+		defaultColdStart = newColdStarts(cfg.Window)
+	})
+	return defaultColdStart
+}
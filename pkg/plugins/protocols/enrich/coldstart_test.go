@@ -0,0 +1,45 @@
+package enrich
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func pod(uid string, start time.Time) *corev1.Pod {
+	p := &corev1.Pod{}
+	p.UID = types.UID(uid)
+	p.CreationTimestamp = metav1.NewTime(start.Add(-time.Second))
+	p.Status.StartTime = &metav1.Time{Time: start}
+	return p
+}
+
+func TestColdStart(t *testing.T) {
+	c := newColdStarts(5 * time.Minute)
+	start := time.Unix(1700000000, 0)
+	young := pod("a", start)
+	if cold, age := c.observe(young, start.Add(3*time.Second)); !cold || age != 3*time.Second {
+		t.Errorf("the first request should be a cold start, got %v %v", cold, age)
+	}
+	if cold, _ := c.observe(young, start.Add(4*time.Second)); cold {
+		t.Errorf("only the first request is a cold start")
+	}
+	// pods running before the agent started
+	if cold, _ := c.observe(pod("b", start), start.Add(10*time.Minute)); cold {
+		t.Errorf("the first request to an old pod is not a cold start")
+	}
+	// a new pod of the same service
+	next := start.Add(20 * time.Minute)
+	if cold, _ := c.observe(pod("c", next), next.Add(time.Second)); !cold {
+		t.Errorf("the first request to the new pod should be a cold start")
+	}
+	if _, ok := c.served[young.UID]; ok {
+		t.Errorf("pods out of the window should be pruned")
+	}
+	if cold, _ := newColdStarts(0).observe(pod("d", start), start); cold {
+		t.Errorf("the detection should be disabled")
+	}
+}
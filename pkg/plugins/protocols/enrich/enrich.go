@@ -0,0 +1,219 @@
+// Package enrich builds the tag set shared by all L7 measurements
+// (application_http, application_rpc, application_db, application_cache, application_mq, application_dns,
+// application_tls, application_smtp, application_ldap, application_quic).
+//
+// Every converted metric carries the following tags, protocol specific tags
+// (http_*, rpc_*, grpc_*, soap_*, jsonrpc_*, db_*, redis_*, message_bus_*, tls_*, smtp_*, ldap_*, quic_*) are added by the plugins on top of them:
+//
+//	metric_source, _meta, _metric_scope, span_kind, component
+//	_metric_scope_id, org_name, cluster_name   scope of the target pod, the source pod if the target is not a pod
+//	host_ip                                     host of the target pod
+//	peer_address                                target ip:port, resolved through conntrack NAT
+//	service_address                             ip:port of the k8s service the target was addressed by (ClusterIP)
+//	peer_hostname, peer_service                 hostname and service name of the target pod (or k8s service)
+//	source_* / target_*                         platform metadata of both pods, see podTags
+//	cold_start                                  whether it is the first request to the target pod
+//
+// Targets missing the enrichment (neither a pod nor a service) get a surrogate identity instead, so the
+// edge still shows up in the topology as an unknown node: target_service_id, target_service_name and
+// target_service_instance_id are set to unknown-<hash of peer_address and protocol>, target_surrogate=true.
+// Once the target is resolved, its metrics carry the surrogate in target_surrogate_id for L7_SURROGATE_TTL
+// (10m) after the last miss, so that the unknown node can be reconciled with the pod or service.
+// The targets outside the cluster the client addressed by name (the Host header of http, the server name
+// of tls) are named after it instead, target_external=true, see External. The requests an ingress or a
+// gateway forwarded for a pod may be attributed to that pod instead of the gateway, see Forwarded.
+// The hostNetwork pods share the ip of the node, the end of such a pod is the pod of the process of the
+// connection when the plugin knows it (Endpoints.Pid) or the socket of the connection was seen by the kprobes
+// of package connpid, see kprobe.Interface.GetPodByPID.
+//
+// The conversion of an event whose source or target address is neither a pod nor a service is held for
+// L7_ENRICH_GRACE_PERIOD (5s) and attempted again, so that the metrics of pods created a moment ago get
+// their tags once the informer cache has them. The addresses still missing after it (e.g. outside the
+// cluster) are not held again for L7_ENRICH_GRACE_SETTLED_TTL (10m), see Interface.Submit. The metrics of
+// the held conversions keep the timestamp of the first conversion, their hold counts in the
+// userspace_to_export delay of the pipeline latency.
+//
+// The first request to a pod younger than L7_COLD_START_WINDOW (5m) is tagged cold_start=true and carries
+// the age of the pod (ns) in the pod_age field, so that the cold starts of scale-to-zero workloads
+// (e.g. Knative services) are measured apart from their steady-state latency.
+//
+// The legacy tags method, peer_service=<path> of rpc and the scope of rpc (_metric_scope_id of the source pod)
+// are still emitted unless L7_DISABLE_LEGACY_TAGS=true,
+// the legacy tags that are renamed schema tags (e.g. db_host of http/rpc/mq) are added by the controller,
+// see pkg/compat. db_host of application_db and application_cache is part of the schema and always
+// points to the peer.
+package enrich
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/compat"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+)
+
+// Endpoints is the connection a metric was observed on, as seen from the client side.
+type Endpoints struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+	// Captured is the capture (bpf_ktime_get_ns) of the packet completing the metric, 0 if unknown.
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if unknown.
+	SocketCookie uint64
+	// Pid is the process of the connection on the node, 0 if unknown (looked up by package connpid), see
+	// hostNetworkPods.
+	Pid uint32
+}
+
+type Interface interface {
+	// Enrich fills the shared tags of m, it returns false when the target is outside the cluster.
+	Enrich(m *metric.Metric, component string, e Endpoints) bool
+	// LegacyTags reports whether the deprecated tag names should still be emitted.
+	LegacyTags() bool
+	// Submit passes the metric returned by convert to emit, unless a pod lookup of Enrich missed during
+	// convert: the conversion is then held for the grace period and attempted again by Flush.
+	Submit(convert func() *metric.Metric, emit func(*metric.Metric))
+	// Flush attempts the held conversions again once their grace period is over, it is called every
+	// FlushInterval by the goroutine calling Submit.
+	Flush()
+}
+
+type provider struct {
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	clock        clock.Clock
+	// held are the conversions waiting for the informer cache, attempt the conversion being submitted.
+	held    []heldConversion
+	attempt *attempt
+}
+
+func New(k kprobe.Interface, n netfilter.Interface) Interface {
+	return &provider{
+		kprobeHelper: k,
+		netNatHelper: n,
+		clock:        clock.Real,
+	}
+}
+
+func (p *provider) LegacyTags() bool {
+	return compat.LegacyTags()
+}
+
+func (p *provider) Enrich(m *metric.Metric, component string, e Endpoints) bool {
+	if m.Tags == nil {
+		m.Tags = make(map[string]string)
+	}
+	m.Tags["metric_source"] = "ebpf"
+	m.Tags["_meta"] = "true"
+	m.Tags["_metric_scope"] = "micro_service"
+	m.Tags["span_kind"] = "server"
+	m.Tags["component"] = component
+	if e.Captured != 0 {
+		m.CaptureTime = clock.FromKtime(e.Captured)
+	}
+	m.SocketCookie = e.SocketCookie
+
+	dstIP, dstPort := e.DestIP, e.DestPort
+	if natInfo, ok := p.netNatHelper.GetNatInfo(e.SourceIP, e.SourcePort); ok {
+		dstIP, dstPort = natInfo.ReplyDstIP, natInfo.ReplyDstPort
+		m.Tags["service_address"] = natInfo.ServiceAddress()
+	}
+
+	sourcePod, sourceErr := p.kprobeHelper.GetPodByUID(e.SourceIP)
+	targetPod, targetErr := p.kprobeHelper.GetPodByUID(dstIP)
+	var source, target *corev1.Pod
+	if sourceErr == nil {
+		source = &sourcePod
+	}
+	if targetErr == nil {
+		target = &targetPod
+	}
+	hostNetworkPods(source, target, connPID(e, dstIP, dstPort), p.kprobeHelper.GetPodByPID)
+
+	if sourceErr == nil {
+		setScope(m, sourcePod)
+		podTags(m.Tags, "source", sourcePod)
+	} else if p.hold(e.SourceIP) {
+		return false
+	}
+	m.Tags["peer_address"] = fmt.Sprintf("%s:%d", dstIP, dstPort)
+
+	if targetErr == nil {
+		setScope(m, targetPod)
+		m.Tags["host_ip"] = targetPod.Status.HostIP
+		m.Tags["peer_hostname"] = targetPod.Spec.Hostname
+		m.Tags["peer_service"] = targetPod.Annotations["msp.erda.cloud/service_name"]
+		podTags(m.Tags, "target", targetPod)
+		coldStart, age := coldStartTracker().observe(&targetPod, p.clock.Now())
+		m.Tags["cold_start"] = strconv.FormatBool(coldStart)
+		if coldStart {
+			if m.Fields == nil {
+				m.Fields = make(map[string]interface{})
+			}
+			m.Fields["pod_age"] = age.Nanoseconds()
+		}
+		p.reconcile(m, component)
+		return true
+	}
+	if svc, err := p.kprobeHelper.GetService(dstIP); err == nil {
+		m.Tags["peer_service"] = svc.Name
+		m.Tags["service_address"] = m.Tags["peer_address"]
+		p.reconcile(m, component)
+		return true
+	}
+	// only the address is known, e.g. a pod missing in the cache yet or a target outside the cluster.
+	if p.hold(dstIP) {
+		return false
+	}
+	if id, ok := surrogateTracker().miss(m.Tags["peer_address"], component, p.clock.Now()); ok {
+		m.Tags["target_service_id"] = id
+		m.Tags["target_service_name"] = id
+		m.Tags["target_service_instance_id"] = id
+		m.Tags["target_surrogate"] = "true"
+	}
+	return false
+}
+
+// reconcile links a resolved target to the surrogate identity it had before.
+func (p *provider) reconcile(m *metric.Metric, component string) {
+	if id, ok := surrogateTracker().resolved(m.Tags["peer_address"], component, p.clock.Now()); ok {
+		m.Tags["target_surrogate_id"] = id
+	}
+}
+
+func setScope(m *metric.Metric, pod corev1.Pod) {
+	m.OrgName = pod.Labels["DICE_ORG_NAME"]
+	m.Tags["_metric_scope_id"] = pod.Annotations["msp.erda.cloud/terminus_key"]
+	m.Tags["org_name"] = pod.Labels["DICE_ORG_NAME"]
+	m.Tags["cluster_name"] = pod.Labels["DICE_CLUSTER_NAME"]
+}
+
+// PodTags sets the scope and the platform metadata of pod with the given prefix (source or target) on the
+// metrics of the connections between pods, e.g. the tcp statistics, which are not requests.
+func PodTags(m *metric.Metric, prefix string, pod corev1.Pod) {
+	setScope(m, pod)
+	podTags(m.Tags, prefix, pod)
+}
+
+// podTags sets the platform metadata of pod with the given prefix (source or target).
+func podTags(tags map[string]string, prefix string, pod corev1.Pod) {
+	tags[prefix+"_application_id"] = pod.Labels["DICE_APPLICATION_ID"]
+	tags[prefix+"_application_name"] = pod.Labels["DICE_APPLICATION_NAME"]
+	tags[prefix+"_org_id"] = pod.Labels["DICE_ORG_ID"]
+	tags[prefix+"_project_id"] = pod.Labels["DICE_PROJECT_ID"]
+	tags[prefix+"_project_name"] = pod.Labels["DICE_PROJECT_NAME"]
+	tags[prefix+"_runtime_id"] = pod.Labels["DICE_RUNTIME_ID"]
+	tags[prefix+"_runtime_name"] = pod.Annotations["msp.erda.cloud/runtime_name"]
+	tags[prefix+"_service_id"] = pod.Annotations["msp.erda.cloud/service_name"]
+	tags[prefix+"_service_instance_id"] = string(pod.UID)
+	tags[prefix+"_service_name"] = pod.Annotations["msp.erda.cloud/service_name"]
+	tags[prefix+"_terminus_key"] = pod.Annotations["msp.erda.cloud/terminus_key"]
+	tags[prefix+"_workspace"] = pod.Annotations["msp.erda.cloud/workspace"]
+}
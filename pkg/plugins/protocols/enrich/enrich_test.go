@@ -0,0 +1,175 @@
+package enrich
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+)
+
+// fakeKprobe resolves the pods and services of the informer cache, the other methods are not used by Enrich.
+type fakeKprobe struct {
+	kprobe.Interface
+	pods     map[string]corev1.Pod
+	services map[string]corev1.Service
+}
+
+func (f *fakeKprobe) GetPodByUID(ip string) (corev1.Pod, error) {
+	if pod, ok := f.pods[ip]; ok {
+		return pod, nil
+	}
+	return corev1.Pod{}, fmt.Errorf("pod %s not found", ip)
+}
+
+func (f *fakeKprobe) GetPodByPID(pid uint32) (corev1.Pod, error) {
+	return corev1.Pod{}, fmt.Errorf("pid %d is not in a pod", pid)
+}
+
+func (f *fakeKprobe) GetService(ip string) (corev1.Service, error) {
+	if svc, ok := f.services[ip]; ok {
+		return svc, nil
+	}
+	return corev1.Service{}, fmt.Errorf("service %s not found", ip)
+}
+
+// fakeNat holds the nat records keyed by the original source ip:port.
+type fakeNat map[string]netfilter.NatInfo
+
+func (f fakeNat) GetNatInfo(ip string, port uint16) (netfilter.NatInfo, bool) {
+	info, ok := f[fmt.Sprintf("%s:%d", ip, port)]
+	return info, ok
+}
+
+func (f fakeNat) NatEntries() map[string]netfilter.NatInfo {
+	return f
+}
+
+func erdaPod(uid, service, terminusKey, hostIP string) corev1.Pod {
+	pod := corev1.Pod{}
+	pod.UID = types.UID(uid)
+	pod.Labels = map[string]string{
+		"DICE_ORG_NAME":     "erda",
+		"DICE_CLUSTER_NAME": "terminus-dev",
+	}
+	pod.Annotations = map[string]string{
+		"msp.erda.cloud/service_name": service,
+		"msp.erda.cloud/terminus_key": terminusKey,
+	}
+	pod.Status.HostIP = hostIP
+	return pod
+}
+
+func newTestProvider(clk clock.Clock) *provider {
+	svc := corev1.Service{}
+	svc.Name = "order-svc"
+	return &provider{
+		kprobeHelper: &fakeKprobe{
+			pods: map[string]corev1.Pod{
+				"10.0.1.2": erdaPod("web-0", "web", "tk-web", "192.168.0.1"),
+				"10.0.1.5": erdaPod("order-0", "order", "tk-order", "192.168.0.2"),
+			},
+			services: map[string]corev1.Service{"172.16.0.10": svc},
+		},
+		netNatHelper: fakeNat{
+			"10.0.1.2:41000": {OriDstIP: "172.16.0.10", OriDstPort: 80, ReplyDstIP: "10.0.1.5", ReplyDstPort: 8080},
+		},
+		clock: clk,
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	p := newTestProvider(clock.NewFake(time.Unix(1700000000, 0)))
+	cases := []struct {
+		name     string
+		e        Endpoints
+		resolved bool
+		tags     map[string]string
+		absent   []string
+	}{
+		{
+			name:     "pod to pod",
+			e:        Endpoints{SourceIP: "10.0.1.2", SourcePort: 40000, DestIP: "10.0.1.5", DestPort: 8080},
+			resolved: true,
+			tags: map[string]string{
+				"_metric_scope_id":    "tk-order",
+				"source_service_name": "web",
+				"target_service_name": "order",
+				"peer_service":        "order",
+				"peer_address":        "10.0.1.5:8080",
+				"host_ip":             "192.168.0.2",
+			},
+			absent: []string{"service_address", "target_surrogate"},
+		},
+		{
+			name:     "pod to service",
+			e:        Endpoints{SourceIP: "10.0.1.2", SourcePort: 40001, DestIP: "172.16.0.10", DestPort: 80},
+			resolved: true,
+			tags: map[string]string{
+				"_metric_scope_id":    "tk-web",
+				"source_service_name": "web",
+				"peer_service":        "order-svc",
+				"peer_address":        "172.16.0.10:80",
+				"service_address":     "172.16.0.10:80",
+			},
+			absent: []string{"target_service_name", "target_surrogate"},
+		},
+		{
+			name:     "external",
+			e:        Endpoints{SourceIP: "10.0.1.2", SourcePort: 40002, DestIP: "8.8.8.8", DestPort: 53},
+			resolved: false,
+			tags: map[string]string{
+				"_metric_scope_id":    "tk-web",
+				"source_service_name": "web",
+				"peer_address":        "8.8.8.8:53",
+				"target_surrogate":    "true",
+			},
+			absent: []string{"peer_service", "service_address"},
+		},
+		{
+			name:     "nat",
+			e:        Endpoints{SourceIP: "10.0.1.2", SourcePort: 41000, DestIP: "172.16.0.10", DestPort: 80},
+			resolved: true,
+			tags: map[string]string{
+				"_metric_scope_id":    "tk-order",
+				"target_service_name": "order",
+				"peer_address":        "10.0.1.5:8080",
+				"service_address":     "172.16.0.10:80",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &metric.Metric{}
+			if got := p.Enrich(m, "http", c.e); got != c.resolved {
+				t.Errorf("Enrich() = %v, want %v", got, c.resolved)
+			}
+			for k, want := range c.tags {
+				if got := m.Tags[k]; got != want {
+					t.Errorf("tag %s = %q, want %q", k, got, want)
+				}
+			}
+			for _, k := range c.absent {
+				if v, ok := m.Tags[k]; ok {
+					t.Errorf("tag %s should not be set, got %q", k, v)
+				}
+			}
+			if m.OrgName != "erda" || m.Tags["component"] != "http" {
+				t.Errorf("the shared tags are missing: org %q, component %q", m.OrgName, m.Tags["component"])
+			}
+		})
+	}
+
+	m := &metric.Metric{}
+	p.Enrich(m, "http", Endpoints{SourceIP: "10.0.1.2", SourcePort: 40003, DestIP: "1.1.1.1", DestPort: 443})
+	if id := m.Tags["target_service_id"]; !strings.HasPrefix(id, "unknown") || m.Tags["target_service_name"] != id {
+		t.Errorf("the external target should have a surrogate identity, got %q", id)
+	}
+}
@@ -0,0 +1,45 @@
+package enrich
+
+import (
+	"net"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+// External names the target of m outside the cluster after host, the name the client addressed it by
+// (the Host header of http, the server name of tls). The target_service_* tags are set to the name and
+// target_external=true, it reports whether host is a name: ip literals name nothing, the plugins keep
+// the metrics of named targets instead of dropping them.
+func External(m *metric.Metric, host string) bool {
+	name := externalName(host)
+	if name == "" {
+		return false
+	}
+	m.Tags["target_service_id"] = name
+	m.Tags["target_service_name"] = name
+	m.Tags["target_service_instance_id"] = m.Tags["peer_address"]
+	m.Tags["target_external"] = "true"
+	m.Tags["peer_hostname"] = name
+	// the name replaces the surrogate identity of the address
+	delete(m.Tags, "target_surrogate")
+	return true
+}
+
+// externalName returns the lower case host name of host, without its port and trailing dot.
+func externalName(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || len(host) > 253 || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return ""
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return ""
+		}
+	}
+	return host
+}
@@ -0,0 +1,41 @@
+package enrich
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestExternal(t *testing.T) {
+	for host, want := range map[string]string{
+		"api.example.com":       "api.example.com",
+		"API.Example.com:8443":  "api.example.com",
+		"api.example.com.":      "api.example.com",
+		"10.0.3.7:8080":         "",
+		"[2001:db8::1]:443":     "",
+		"":                      "",
+		"evil.com/<script>":     "",
+		"metadata.internal_svc": "metadata.internal_svc",
+	} {
+		if got := externalName(host); got != want {
+			t.Errorf("externalName(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	m := &metric.Metric{Tags: map[string]string{
+		"peer_address":      "93.184.216.34:443",
+		"target_surrogate":  "true",
+		"target_service_id": surrogateID("93.184.216.34:443", "HTTP"),
+	}}
+	if !External(m, "api.example.com") {
+		t.Fatalf("the target should be named")
+	}
+	if m.Tags["target_service_name"] != "api.example.com" || m.Tags["target_service_id"] != "api.example.com" ||
+		m.Tags["target_service_instance_id"] != "93.184.216.34:443" || m.Tags["target_external"] != "true" ||
+		Surrogate(m) {
+		t.Errorf("unexpected tags %v", m.Tags)
+	}
+	if External(&metric.Metric{Tags: map[string]string{}}, "93.184.216.34") {
+		t.Errorf("an ip names nothing")
+	}
+}
@@ -0,0 +1,26 @@
+package enrich
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+// gatewayTags are the source tags of the gateway kept when the source is the client it forwarded for.
+var gatewayTags = []string{"service_id", "service_name", "service_instance_id"}
+
+// Forwarded attributes m to the client pod the source of m (an ingress or a gateway) forwarded the request
+// for, e.g. by X-Forwarded-For or the PROXY protocol. The source_* tags become the ones of client, the gateway is kept in
+// gateway_service_id, gateway_service_name and gateway_service_instance_id, source_forwarded=true.
+func Forwarded(m *metric.Metric, client corev1.Pod) {
+	if m.Tags["source_service_instance_id"] == string(client.UID) {
+		return
+	}
+	for _, name := range gatewayTags {
+		if value, ok := m.Tags["source_"+name]; ok {
+			m.Tags["gateway_"+name] = value
+		}
+	}
+	podTags(m.Tags, "source", client)
+	m.Tags["source_forwarded"] = "true"
+}
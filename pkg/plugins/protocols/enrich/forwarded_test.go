@@ -0,0 +1,32 @@
+package enrich
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestForwarded(t *testing.T) {
+	client := pod("web-1", time.Now())
+	client.Annotations = map[string]string{"msp.erda.cloud/service_name": "web"}
+	m := &metric.Metric{Tags: map[string]string{
+		"source_service_id":          "gateway",
+		"source_service_name":        "gateway",
+		"source_service_instance_id": "gateway-1",
+		"target_service_name":        "api",
+	}}
+	Forwarded(m, *client)
+	if m.Tags["source_service_name"] != "web" || m.Tags["source_service_instance_id"] != "web-1" ||
+		m.Tags["gateway_service_name"] != "gateway" || m.Tags["gateway_service_instance_id"] != "gateway-1" ||
+		m.Tags["source_forwarded"] != "true" || m.Tags["target_service_name"] != "api" {
+		t.Errorf("unexpected tags %v", m.Tags)
+	}
+
+	// a request the client sent itself
+	m = &metric.Metric{Tags: map[string]string{"source_service_instance_id": "web-1"}}
+	Forwarded(m, *client)
+	if _, ok := m.Tags["source_forwarded"]; ok {
+		t.Errorf("the source should not forward for itself: %v", m.Tags)
+	}
+}
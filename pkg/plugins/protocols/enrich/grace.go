@@ -0,0 +1,161 @@
+package enrich
+
+import (
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+type GraceConfig struct {
+	// Period is how long the conversion of an event is held when the pod of its source or target is missing
+	// in the informer cache, the pods created a moment ago send traffic before the cache has their labels
+	// and annotations. The conversion is attempted again after it, the tags of the addresses still missing
+	// fall back to the unknown ones. 0 disables the hold.
+	Period time.Duration `env:"L7_ENRICH_GRACE_PERIOD" default:"5s"`
+	// MaxPending bounds the conversions held by a plugin, the next ones are converted at once.
+	MaxPending int `env:"L7_ENRICH_GRACE_MAX_PENDING" default:"4096"`
+	// SettledTTL is how long an address still missing after the period is not held again, the addresses
+	// outside the cluster only delay the metrics of their first events.
+	SettledTTL time.Duration `env:"L7_ENRICH_GRACE_SETTLED_TTL" default:"10m"`
+}
+
+// FlushInterval is how often the plugins attempt the held conversions again, see Interface.Flush.
+const FlushInterval = time.Second
+
+// graces remembers the addresses that stayed missing through a grace period. It is shared by the plugins,
+// the informer cache does not depend on the protocol.
+type graces struct {
+	sync.Mutex
+	cfg       GraceConfig
+	settled   map[string]time.Time
+	lastPrune time.Time
+}
+
+func newGraces(cfg GraceConfig) *graces {
+	return &graces{cfg: cfg, settled: make(map[string]time.Time)}
+}
+
+// holds reports whether the events of a missing address are held at now.
+func (g *graces) holds(addr string, now time.Time) bool {
+	g.Lock()
+	defer g.Unlock()
+	last, ok := g.settled[addr]
+	return !ok || now.Sub(last) > g.cfg.SettledTTL
+}
+
+// settle records an address still missing after the grace period at now.
+func (g *graces) settle(addr string, now time.Time) {
+	g.Lock()
+	defer g.Unlock()
+	if now.Sub(g.lastPrune) > g.cfg.SettledTTL {
+		for k, last := range g.settled {
+			if now.Sub(last) > g.cfg.SettledTTL {
+				delete(g.settled, k)
+			}
+		}
+		g.lastPrune = now
+	}
+	g.settled[addr] = now
+}
+
+var (
+	graceOnce    sync.Once
+	defaultGrace *graces
+)
+
+func graceTracker() *graces {
+	graceOnce.Do(func() {
+		cfg := GraceConfig{}
+		envconf.MustLoad(&cfg)
+		defaultGrace = newGraces(cfg)
+	})
+	return defaultGrace
+}
+
+// attempt collects the missing addresses held by the conversion in progress.
+type attempt struct {
+	now   time.Time
+	addrs []string
+}
+
+// heldConversion is a conversion waiting for the informer cache, timestamp is the time of the metric of
+// the first conversion, 0 if it returned nil.
+type heldConversion struct {
+	convert   func() *metric.Metric
+	emit      func(*metric.Metric)
+	received  time.Time
+	timestamp int64
+	addrs     []string
+}
+
+func (p *provider) Submit(convert func() *metric.Metric, emit func(*metric.Metric)) {
+	g := graceTracker()
+	if g.cfg.Period <= 0 || len(p.held) >= g.cfg.MaxPending {
+		if m := convert(); m != nil {
+			emit(m)
+		}
+		return
+	}
+	p.attempt = &attempt{now: p.clock.Now()}
+	m := convert()
+	a := p.attempt
+	p.attempt = nil
+	if len(a.addrs) == 0 {
+		if m != nil {
+			emit(m)
+		}
+		return
+	}
+	h := heldConversion{convert: convert, emit: emit, received: a.now, addrs: a.addrs}
+	if m != nil {
+		h.timestamp = m.Timestamp
+	}
+	p.held = append(p.held, h)
+}
+
+func (p *provider) Flush() {
+	g := graceTracker()
+	now := p.clock.Now()
+	// the conversions are held for the same period, they are due in the order they were received.
+	i := 0
+	for ; i < len(p.held) && now.Sub(p.held[i].received) >= g.cfg.Period; i++ {
+		h := p.held[i]
+		p.held[i] = heldConversion{}
+		m := h.convert()
+		for _, addr := range h.addrs {
+			if p.missing(addr) {
+				g.settle(addr, now)
+			}
+		}
+		if m != nil {
+			// the metric keeps the time of its event, the plugins may take it from the kernel or the clock.
+			if h.timestamp != 0 {
+				m.Timestamp = h.timestamp
+			}
+			// the hold is not a delay of the plugin reading its maps, see latency.StageKernelToUserspace.
+			m.ConvertTime = h.received.UnixNano()
+			h.emit(m)
+		}
+	}
+	p.held = p.held[i:]
+}
+
+// hold reports whether the conversion in progress is held for a missing address.
+func (p *provider) hold(addr string) bool {
+	if p.attempt == nil || !graceTracker().holds(addr, p.attempt.now) {
+		return false
+	}
+	p.attempt.addrs = append(p.attempt.addrs, addr)
+	return true
+}
+
+// missing reports whether an address is still neither a pod nor a service.
+func (p *provider) missing(addr string) bool {
+	if _, err := p.kprobeHelper.GetPodByUID(addr); err == nil {
+		return false
+	}
+	_, err := p.kprobeHelper.GetService(addr)
+	return err != nil
+}
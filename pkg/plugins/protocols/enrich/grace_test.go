@@ -0,0 +1,90 @@
+package enrich
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+func TestGraceSettled(t *testing.T) {
+	g := newGraces(GraceConfig{Period: 5 * time.Second, SettledTTL: 10 * time.Minute})
+	now := time.Unix(1700000000, 0)
+	if !g.holds("10.0.3.7", now) {
+		t.Fatalf("the events of a missing address should be held")
+	}
+	g.settle("10.0.3.7", now)
+	if g.holds("10.0.3.7", now.Add(time.Minute)) {
+		t.Errorf("an address missing after the grace period should not be held again")
+	}
+	if !g.holds("10.0.3.8", now.Add(time.Minute)) {
+		t.Errorf("the other addresses should still be held")
+	}
+
+	// the settled addresses are held again after the ttl, e.g. the ip is reused by a new pod
+	later := now.Add(11 * time.Minute)
+	if !g.holds("10.0.3.7", later) {
+		t.Errorf("the settled address should be expired")
+	}
+	g.settle("10.0.3.9", later)
+	if _, ok := g.settled["10.0.3.7"]; ok {
+		t.Errorf("expired addresses should be pruned")
+	}
+}
+
+func TestSubmitFlush(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewFake(start)
+	p := newTestProvider(clk)
+	k := p.kprobeHelper.(*fakeKprobe)
+	period := graceTracker().cfg.Period
+
+	var emitted []*metric.Metric
+	emit := func(m *metric.Metric) { emitted = append(emitted, m) }
+	convert := func(dest string) func() *metric.Metric {
+		return func() *metric.Metric {
+			m := &metric.Metric{Timestamp: clk.Now().UnixNano()}
+			p.Enrich(m, "http", Endpoints{SourceIP: "10.0.1.2", SourcePort: 40000, DestIP: dest, DestPort: 8080})
+			return m
+		}
+	}
+
+	p.Submit(convert("10.0.2.7"), emit)
+	if len(emitted) != 0 || len(p.held) != 1 {
+		t.Fatalf("the conversion of a missing target should be held, %d emitted", len(emitted))
+	}
+	clk.Add(period - time.Second)
+	p.Flush()
+	if len(emitted) != 0 {
+		t.Fatalf("the conversion should be held for the grace period")
+	}
+
+	// the pod shows up in the informer cache during the hold
+	k.pods["10.0.2.7"] = erdaPod("new-0", "new", "tk-new", "192.168.0.3")
+	clk.Add(time.Second)
+	p.Flush()
+	p.Flush()
+	if len(emitted) != 1 {
+		t.Fatalf("the held conversion should be emitted once, got %d", len(emitted))
+	}
+	m := emitted[0]
+	if m.Tags["target_service_name"] != "new" {
+		t.Errorf("the held conversion should be attempted again, target %q", m.Tags["target_service_name"])
+	}
+	if m.Timestamp != start.UnixNano() || m.ConvertTime != start.UnixNano() {
+		t.Errorf("the metric should keep the time of its first conversion, timestamp %d, convert %d",
+			m.Timestamp-start.UnixNano(), m.ConvertTime-start.UnixNano())
+	}
+
+	// the conversions above MaxPending are not held
+	emitted = nil
+	p.held = make([]heldConversion, graceTracker().cfg.MaxPending)
+	p.Submit(convert("10.0.2.8"), emit)
+	if len(emitted) != 1 || len(p.held) != graceTracker().cfg.MaxPending {
+		t.Fatalf("the conversion should be emitted at once when MaxPending are held, %d emitted", len(emitted))
+	}
+	if emitted[0].Tags["target_surrogate"] != "true" || emitted[0].ConvertTime != 0 {
+		t.Errorf("the target should fall back to a surrogate, tags %v", emitted[0].Tags)
+	}
+}
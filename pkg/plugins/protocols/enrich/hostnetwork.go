@@ -0,0 +1,43 @@
+package enrich
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/connpid"
+)
+
+// hostNetworkPods attributes the ends of a connection that are hostNetwork pods to the pod of the process
+// of the connection: the pods found by ip share the ip of the node, the cache returns any of them. The
+// process is the one of the source if the source is a hostNetwork pod, otherwise of the target, pid
+// returns it for the end. The ends are kept when the process is not in a hostNetwork pod, e.g. a daemon of
+// the node.
+func hostNetworkPods(source, target *corev1.Pod, pid func(source bool) uint32, byPID func(uint32) (corev1.Pod, error)) {
+	end, isSource := source, true
+	if end == nil || !end.Spec.HostNetwork {
+		end, isSource = target, false
+	}
+	if end == nil || !end.Spec.HostNetwork {
+		return
+	}
+	id := pid(isSource)
+	if id == 0 {
+		return
+	}
+	if pod, err := byPID(id); err == nil && pod.Spec.HostNetwork {
+		*end = pod
+	}
+}
+
+// connPID returns the process of the end of the connection, the plugin knows it (Pid) or its socket is
+// looked up by package connpid. The target is the one of the address after NAT.
+func connPID(e Endpoints, dstIP string, dstPort uint16) func(source bool) uint32 {
+	return func(source bool) uint32 {
+		if e.Pid != 0 {
+			return e.Pid
+		}
+		if source {
+			return connpid.Lookup(e.SourceIP, e.SourcePort, dstIP, dstPort)
+		}
+		return connpid.Lookup(dstIP, dstPort, e.SourceIP, e.SourcePort)
+	}
+}
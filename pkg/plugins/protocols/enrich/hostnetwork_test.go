@@ -0,0 +1,62 @@
+package enrich
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func hostPod(uid string, hostNetwork bool) corev1.Pod {
+	pod := corev1.Pod{}
+	pod.UID = types.UID(uid)
+	pod.Spec.HostNetwork = hostNetwork
+	return pod
+}
+
+func TestHostNetworkPods(t *testing.T) {
+	byPID := func(pid uint32) (corev1.Pod, error) {
+		switch pid {
+		case 100:
+			return hostPod("ingress-nginx", true), nil
+		case 200:
+			return hostPod("web", false), nil
+		}
+		return corev1.Pod{}, fmt.Errorf("pid %d is not in a pod", pid)
+	}
+
+	// pid returns the process of the end it is asked for, 0 for the other one.
+	pid := func(id uint32, source bool) func(bool) uint32 {
+		return func(s bool) uint32 {
+			if s == source {
+				return id
+			}
+			return 0
+		}
+	}
+
+	source, target := hostPod("node-exporter", true), hostPod("web", false)
+	hostNetworkPods(&source, &target, pid(100, true), byPID)
+	if source.UID != "ingress-nginx" || target.UID != "web" {
+		t.Errorf("the source should be the pod of the process, got %s -> %s", source.UID, target.UID)
+	}
+
+	source, target = hostPod("web", false), hostPod("node-exporter", true)
+	hostNetworkPods(&source, &target, pid(100, false), byPID)
+	if source.UID != "web" || target.UID != "ingress-nginx" {
+		t.Errorf("the target should be the pod of the process, got %s -> %s", source.UID, target.UID)
+	}
+	hostNetworkPods(nil, &target, pid(100, false), byPID)
+	if target.UID != "ingress-nginx" {
+		t.Errorf("the target should be resolved without a source pod, got %s", target.UID)
+	}
+
+	for _, id := range []uint32{0, 200, 300} {
+		source = hostPod("node-exporter", true)
+		hostNetworkPods(&source, nil, pid(id, true), byPID)
+		if source.UID != "node-exporter" {
+			t.Errorf("pid %d: the pod found by ip should be kept, got %s", id, source.UID)
+		}
+	}
+}
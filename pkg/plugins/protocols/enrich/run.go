@@ -0,0 +1,52 @@
+package enrich
+
+import (
+	"runtime/debug"
+
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+)
+
+// Run submits the events of ch to e with convert once the caches of k are synced and emits the metrics
+// to c, the held conversions are flushed every FlushInterval. It blocks, the plugins converting every
+// event the same way run it in the goroutine of their metrics.
+func Run[T any](e Interface, k kprobe.Interface, plugin string, ch <-chan T, convert func(*T) *metric.Metric, c chan<- *metric.Metric) {
+	run(e, k, plugin, ch, convert, c, clock.Real)
+}
+
+func run[T any](e Interface, k kprobe.Interface, plugin string, ch <-chan T, convert func(*T) *metric.Metric, c chan<- *metric.Metric, clk clock.Clock) {
+	defer func() {
+		if err := recover(); err != nil {
+			klog.Errorf("panic: %v", err)
+			klog.Errorf("stack: %s", string(debug.Stack()))
+		}
+	}()
+	startup, events := kprobe.WaitSyncedQueue(k, plugin, ch)
+	c <- startup
+	emit := func(m *metric.Metric) { c <- m }
+	flush := clk.NewTicker(FlushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case event := <-events:
+			e.Submit(func() *metric.Metric { return convert(&event) }, emit)
+		case <-flush.C():
+			e.Flush()
+		}
+	}
+}
+
+// Elapsed are the fields of a single request of duration, the requests are aggregated by the sum of their
+// fields.
+func Elapsed[D ~int64 | ~uint64 | ~int | ~uint32](duration D) map[string]interface{} {
+	return map[string]interface{}{
+		"elapsed_count": 1,
+		"elapsed_sum":   duration,
+		"elapsed_max":   duration,
+		"elapsed_min":   duration,
+		"elapsed_mean":  duration,
+	}
+}
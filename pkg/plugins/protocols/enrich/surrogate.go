@@ -0,0 +1,91 @@
+package enrich
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+type SurrogateConfig struct {
+	// TTL is how long the surrogate identity of an unresolved target is remembered after its last metric,
+	// the metrics resolving the target within it carry target_surrogate_id. 0 disables the surrogate
+	// identities, the plugins drop the metrics of unresolved targets again.
+	TTL time.Duration `env:"L7_SURROGATE_TTL" default:"10m"`
+}
+
+// surrogates remembers the targets that missed the enrichment. It is shared by the plugins, the
+// identity only depends on the address and the protocol of the target.
+type surrogates struct {
+	sync.Mutex
+	ttl       time.Duration
+	missed    map[string]time.Time
+	lastPrune time.Time
+}
+
+func newSurrogates(ttl time.Duration) *surrogates {
+	return &surrogates{ttl: ttl, missed: make(map[string]time.Time)}
+}
+
+// surrogateID is the stable identity of the target address of the protocol.
+func surrogateID(addr, component string) string {
+	h := fnv.New32a()
+	h.Write([]byte(addr + "/" + component))
+	return fmt.Sprintf("unknown-%08x", h.Sum32())
+}
+
+// miss records a metric of an unresolved target at now, it returns the surrogate identity of the target.
+func (s *surrogates) miss(addr, component string, now time.Time) (string, bool) {
+	if s.ttl <= 0 {
+		return "", false
+	}
+	s.Lock()
+	defer s.Unlock()
+	if now.Sub(s.lastPrune) > s.ttl {
+		for k, last := range s.missed {
+			if now.Sub(last) > s.ttl {
+				delete(s.missed, k)
+			}
+		}
+		s.lastPrune = now
+	}
+	s.missed[addr+"/"+component] = now
+	return surrogateID(addr, component), true
+}
+
+// resolved returns the surrogate identity of a resolved target that missed the enrichment within the ttl.
+func (s *surrogates) resolved(addr, component string, now time.Time) (string, bool) {
+	if s.ttl <= 0 {
+		return "", false
+	}
+	s.Lock()
+	defer s.Unlock()
+	last, ok := s.missed[addr+"/"+component]
+	if !ok || now.Sub(last) > s.ttl {
+		return "", false
+	}
+	return surrogateID(addr, component), true
+}
+
+var (
+	surrogateOnce    sync.Once
+	defaultSurrogate *surrogates
+)
+
+func surrogateTracker() *surrogates {
+	surrogateOnce.Do(func() {
+		cfg := SurrogateConfig{}
+		envconf.MustLoad(&cfg)
+		defaultSurrogate = newSurrogates(cfg.TTL)
+	})
+	return defaultSurrogate
+}
+
+// Surrogate reports whether the target of m is only known by its surrogate identity, the plugins
+// dropping the metrics of targets outside the cluster keep them.
+func Surrogate(m *metric.Metric) bool {
+	return m.Tags["target_surrogate"] == "true"
+}
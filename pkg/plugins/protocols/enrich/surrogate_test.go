@@ -0,0 +1,38 @@
+package enrich
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSurrogate(t *testing.T) {
+	s := newSurrogates(10 * time.Minute)
+	now := time.Unix(1700000000, 0)
+	id, ok := s.miss("10.0.3.7:8080", "HTTP", now)
+	if !ok || id != surrogateID("10.0.3.7:8080", "HTTP") || len(id) != len("unknown-")+8 {
+		t.Fatalf("unexpected surrogate %q %v", id, ok)
+	}
+	if other, _ := s.miss("10.0.3.7:8080", "GRPC", now); other == id {
+		t.Errorf("the protocols of an address should have different identities")
+	}
+	if got, ok := s.resolved("10.0.3.7:8080", "HTTP", now.Add(time.Minute)); !ok || got != id {
+		t.Errorf("the resolved target should be reconciled with %q, got %q %v", id, got, ok)
+	}
+	if _, ok := s.resolved("10.0.3.8:8080", "HTTP", now); ok {
+		t.Errorf("targets resolved at once have no surrogate")
+	}
+
+	// the surrogates are forgotten after the ttl
+	later := now.Add(11 * time.Minute)
+	if _, ok := s.resolved("10.0.3.7:8080", "HTTP", later); ok {
+		t.Errorf("the surrogate should be expired")
+	}
+	s.miss("10.0.3.9:6379", "REDIS", later)
+	if _, ok := s.missed["10.0.3.7:8080/HTTP"]; ok {
+		t.Errorf("expired surrogates should be pruned")
+	}
+
+	if _, ok := newSurrogates(0).miss("10.0.3.7:8080", "HTTP", now); ok {
+		t.Errorf("a ttl of 0 disables the surrogates")
+	}
+}
@@ -0,0 +1,454 @@
+// Package errorcodes maps the error codes of the protocols to normalized error types,
+// so that errors of different protocols can be grouped, e.g. all timeouts.
+//
+// The built-in dictionaries cover Dubbo status bytes, MySQL errno, Redis error prefixes,
+// gRPC status codes, Thrift TApplicationException types, RocketMQ response codes,
+// Bolt (SOFA RPC) response statuses, Motan error codes, bRPC error codes, Pulsar server errors,
+// ClickHouse exception codes, Oracle ORA- errors, SMTP reply codes, LDAP result codes and JSON-RPC error codes.
+// They are extended (or overridden) by the JSON file of L7_ERROR_CODES_PATH, keyed by
+// protocol and code, protocols of custom frameworks can be added the same way:
+//
+//	{"mysql": {"3024": {"type": "timeout", "name": "ER_QUERY_TIMEOUT"}}}
+package errorcodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	Dubbo      = "dubbo"
+	MySQL      = "mysql"
+	Redis      = "redis"
+	GRPC       = "grpc"
+	Thrift     = "thrift"
+	RocketMQ   = "rocketmq"
+	Bolt       = "bolt"
+	Motan      = "motan"
+	BRPC       = "brpc"
+	Pulsar     = "pulsar"
+	ClickHouse = "clickhouse"
+	Oracle     = "oracle"
+	SMTP       = "smtp"
+	LDAP       = "ldap"
+	JSONRPC    = "jsonrpc"
+
+	// ThriftUserException is the code of the exceptions declared in the IDL, they are not numbered by thrift.
+	ThriftUserException = "user"
+
+	// TypeUnknown is the error_type of the codes missing in the dictionary.
+	TypeUnknown = "unknown"
+)
+
+// normalized error types
+const (
+	TypeTimeout           = "timeout"
+	TypeCancelled         = "cancelled"
+	TypeInvalidArgument   = "invalid_argument"
+	TypeNotFound          = "not_found"
+	TypeConflict          = "conflict"
+	TypePermissionDenied  = "permission_denied"
+	TypeUnauthenticated   = "unauthenticated"
+	TypeResourceExhausted = "resource_exhausted"
+	TypeUnavailable       = "unavailable"
+	TypeUnimplemented     = "unimplemented"
+	TypeInternal          = "internal"
+	TypeRedirect          = "redirect"
+	TypeUserException     = "user_exception"
+)
+
+type Config struct {
+	Path string `env:"L7_ERROR_CODES_PATH"`
+}
+
+// Entry is the normalized type and the human-readable name of an error code.
+type Entry struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type Interface interface {
+	// Lookup returns the entry of the code of protocol.
+	Lookup(protocol, code string) (Entry, bool)
+	// Tag sets error_type and error_name of the code of protocol, codes missing in the dictionary
+	// are tagged as unknown with the code as the name.
+	Tag(tags map[string]string, protocol, code string)
+}
+
+type dictionary map[string]map[string]Entry
+
+// New loads the built-in dictionaries and the extensions of the configured file, a missing file
+// (e.g. the optional ConfigMap is not created) is ignored.
+func New() (Interface, error) {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	d := defaults()
+	if cfg.Path == "" {
+		return d, nil
+	}
+	raw, err := os.ReadFile(cfg.Path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var extensions dictionary
+	if err := json.Unmarshal(raw, &extensions); err != nil {
+		return nil, fmt.Errorf("invalid error code dictionary %s: %v", cfg.Path, err)
+	}
+	d.merge(extensions)
+	return d, nil
+}
+
+func (d dictionary) merge(extensions dictionary) {
+	for protocol, codes := range extensions {
+		if d[protocol] == nil {
+			d[protocol] = make(map[string]Entry)
+		}
+		for code, e := range codes {
+			d[protocol][code] = e
+		}
+	}
+}
+
+func (d dictionary) Lookup(protocol, code string) (Entry, bool) {
+	e, ok := d[protocol][code]
+	return e, ok
+}
+
+func (d dictionary) Tag(tags map[string]string, protocol, code string) {
+	e, ok := d.Lookup(protocol, code)
+	if !ok {
+		e = Entry{Type: TypeUnknown, Name: code}
+	}
+	tags["error_type"] = e.Type
+	tags["error_name"] = e.Name
+}
+
+func defaults() dictionary {
+	return dictionary{
+		// see org.apache.dubbo.remoting.exchange.Response
+		Dubbo: {
+			"30":  {TypeTimeout, "CLIENT_TIMEOUT"},
+			"31":  {TypeTimeout, "SERVER_TIMEOUT"},
+			"35":  {TypeUnavailable, "CHANNEL_INACTIVE"},
+			"40":  {TypeInvalidArgument, "BAD_REQUEST"},
+			"50":  {TypeInternal, "BAD_RESPONSE"},
+			"60":  {TypeNotFound, "SERVICE_NOT_FOUND"},
+			"70":  {TypeInternal, "SERVICE_ERROR"},
+			"80":  {TypeInternal, "SERVER_ERROR"},
+			"90":  {TypeInternal, "CLIENT_ERROR"},
+			"100": {TypeResourceExhausted, "SERVER_THREADPOOL_EXHAUSTED_ERROR"},
+		},
+		// see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+		MySQL: {
+			"1040": {TypeResourceExhausted, "ER_CON_COUNT_ERROR"},
+			"1044": {TypePermissionDenied, "ER_DBACCESS_DENIED_ERROR"},
+			"1045": {TypeUnauthenticated, "ER_ACCESS_DENIED_ERROR"},
+			"1046": {TypeInvalidArgument, "ER_NO_DB_ERROR"},
+			"1049": {TypeNotFound, "ER_BAD_DB_ERROR"},
+			"1054": {TypeInvalidArgument, "ER_BAD_FIELD_ERROR"},
+			"1062": {TypeConflict, "ER_DUP_ENTRY"},
+			"1064": {TypeInvalidArgument, "ER_PARSE_ERROR"},
+			"1114": {TypeResourceExhausted, "ER_RECORD_FILE_FULL"},
+			"1142": {TypePermissionDenied, "ER_TABLEACCESS_DENIED_ERROR"},
+			"1146": {TypeNotFound, "ER_NO_SUCH_TABLE"},
+			"1205": {TypeTimeout, "ER_LOCK_WAIT_TIMEOUT"},
+			"1213": {TypeConflict, "ER_LOCK_DEADLOCK"},
+			"1216": {TypeConflict, "ER_NO_REFERENCED_ROW"},
+			"1217": {TypeConflict, "ER_ROW_IS_REFERENCED"},
+			"1226": {TypeResourceExhausted, "ER_USER_LIMIT_REACHED"},
+			"1290": {TypeUnavailable, "ER_OPTION_PREVENTS_STATEMENT"},
+			"1317": {TypeCancelled, "ER_QUERY_INTERRUPTED"},
+			"1406": {TypeInvalidArgument, "ER_DATA_TOO_LONG"},
+			"1451": {TypeConflict, "ER_ROW_IS_REFERENCED_2"},
+			"1452": {TypeConflict, "ER_NO_REFERENCED_ROW_2"},
+			"3024": {TypeTimeout, "ER_QUERY_TIMEOUT"},
+		},
+		// the first word of the error reply
+		Redis: {
+			"ERR":         {TypeInternal, "ERR"},
+			"WRONGTYPE":   {TypeInvalidArgument, "WRONGTYPE"},
+			"NOAUTH":      {TypeUnauthenticated, "NOAUTH"},
+			"WRONGPASS":   {TypeUnauthenticated, "WRONGPASS"},
+			"NOPERM":      {TypePermissionDenied, "NOPERM"},
+			"OOM":         {TypeResourceExhausted, "OOM"},
+			"BUSY":        {TypeUnavailable, "BUSY"},
+			"LOADING":     {TypeUnavailable, "LOADING"},
+			"MASTERDOWN":  {TypeUnavailable, "MASTERDOWN"},
+			"CLUSTERDOWN": {TypeUnavailable, "CLUSTERDOWN"},
+			"TRYAGAIN":    {TypeUnavailable, "TRYAGAIN"},
+			"READONLY":    {TypePermissionDenied, "READONLY"},
+			"NOSCRIPT":    {TypeNotFound, "NOSCRIPT"},
+			"EXECABORT":   {TypeCancelled, "EXECABORT"},
+			"MOVED":       {TypeRedirect, "MOVED"},
+			"ASK":         {TypeRedirect, "ASK"},
+			"CROSSSLOT":   {TypeInvalidArgument, "CROSSSLOT"},
+		},
+		// see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+		GRPC: {
+			"1":  {TypeCancelled, "CANCELLED"},
+			"2":  {TypeUnknown, "UNKNOWN"},
+			"3":  {TypeInvalidArgument, "INVALID_ARGUMENT"},
+			"4":  {TypeTimeout, "DEADLINE_EXCEEDED"},
+			"5":  {TypeNotFound, "NOT_FOUND"},
+			"6":  {TypeConflict, "ALREADY_EXISTS"},
+			"7":  {TypePermissionDenied, "PERMISSION_DENIED"},
+			"8":  {TypeResourceExhausted, "RESOURCE_EXHAUSTED"},
+			"9":  {TypeInvalidArgument, "FAILED_PRECONDITION"},
+			"10": {TypeConflict, "ABORTED"},
+			"11": {TypeInvalidArgument, "OUT_OF_RANGE"},
+			"12": {TypeUnimplemented, "UNIMPLEMENTED"},
+			"13": {TypeInternal, "INTERNAL"},
+			"14": {TypeUnavailable, "UNAVAILABLE"},
+			"15": {TypeInternal, "DATA_LOSS"},
+			"16": {TypeUnauthenticated, "UNAUTHENTICATED"},
+		},
+		// see org.apache.thrift.TApplicationException
+		Thrift: {
+			"0":                 {TypeUnknown, "UNKNOWN"},
+			"1":                 {TypeUnimplemented, "UNKNOWN_METHOD"},
+			"2":                 {TypeInvalidArgument, "INVALID_MESSAGE_TYPE"},
+			"3":                 {TypeInvalidArgument, "WRONG_METHOD_NAME"},
+			"4":                 {TypeInternal, "BAD_SEQUENCE_ID"},
+			"5":                 {TypeInternal, "MISSING_RESULT"},
+			"6":                 {TypeInternal, "INTERNAL_ERROR"},
+			"7":                 {TypeInvalidArgument, "PROTOCOL_ERROR"},
+			"8":                 {TypeInvalidArgument, "INVALID_TRANSFORM"},
+			"9":                 {TypeInvalidArgument, "INVALID_PROTOCOL"},
+			"10":                {TypeInvalidArgument, "UNSUPPORTED_CLIENT_TYPE"},
+			ThriftUserException: {TypeUserException, "USER_EXCEPTION"},
+		},
+		// see org.apache.rocketmq.remoting.protocol.ResponseCode
+		RocketMQ: {
+			"1":  {TypeInternal, "SYSTEM_ERROR"},
+			"2":  {TypeResourceExhausted, "SYSTEM_BUSY"},
+			"3":  {TypeUnimplemented, "REQUEST_CODE_NOT_SUPPORTED"},
+			"4":  {TypeInternal, "TRANSACTION_FAILED"},
+			"13": {TypeInvalidArgument, "MESSAGE_ILLEGAL"},
+			"14": {TypeUnavailable, "SERVICE_NOT_AVAILABLE"},
+			"15": {TypeUnimplemented, "VERSION_NOT_SUPPORTED"},
+			"16": {TypePermissionDenied, "NO_PERMISSION"},
+			"17": {TypeNotFound, "TOPIC_NOT_EXIST"},
+			"23": {TypeInvalidArgument, "SUBSCRIPTION_PARSE_FAILED"},
+			"24": {TypeNotFound, "SUBSCRIPTION_NOT_EXIST"},
+			"25": {TypeConflict, "SUBSCRIPTION_NOT_LATEST"},
+			"26": {TypeNotFound, "SUBSCRIPTION_GROUP_NOT_EXIST"},
+		},
+		// see com.alipay.remoting.ResponseStatus, the codes are the decimal values of the statuses
+		Bolt: {
+			"1":  {TypeInternal, "ERROR"},
+			"2":  {TypeInternal, "SERVER_EXCEPTION"},
+			"3":  {TypeUnknown, "UNKNOWN"},
+			"4":  {TypeResourceExhausted, "SERVER_THREADPOOL_BUSY"},
+			"5":  {TypeUnavailable, "ERROR_COMM"},
+			"6":  {TypeUnimplemented, "NO_PROCESSOR"},
+			"7":  {TypeTimeout, "TIMEOUT"},
+			"8":  {TypeUnavailable, "CLIENT_SEND_ERROR"},
+			"9":  {TypeInvalidArgument, "CODEC_EXCEPTION"},
+			"16": {TypeUnavailable, "CONNECTION_CLOSED"},
+			"17": {TypeInternal, "SERVER_SERIAL_EXCEPTION"},
+			"18": {TypeInvalidArgument, "SERVER_DESERIAL_EXCEPTION"},
+		},
+		// see com.weibo.api.motan.exception.MotanErrorMsgConstant
+		Motan: {
+			"10001": {TypeInternal, "SERVICE_DEFAULT_ERROR"},
+			"10002": {TypeResourceExhausted, "SERVICE_REJECT"},
+			"10003": {TypeTimeout, "SERVICE_TIMEOUT"},
+			"10004": {TypeCancelled, "SERVICE_TASK_CANCEL"},
+			"10101": {TypeNotFound, "SERVICE_UNFOUND"},
+			"20001": {TypeInternal, "FRAMEWORK_DEFAULT_ERROR"},
+			"20002": {TypeInternal, "FRAMEWORK_ENCODE_ERROR"},
+			"20003": {TypeInvalidArgument, "FRAMEWORK_DECODE_ERROR"},
+			"30001": {TypeUserException, "BIZ_DEFAULT_EXCEPTION"},
+		},
+		// see brpc/errno.proto
+		BRPC: {
+			"1001": {TypeNotFound, "ENOSERVICE"},
+			"1002": {TypeUnimplemented, "ENOMETHOD"},
+			"1003": {TypeInvalidArgument, "EREQUEST"},
+			"1004": {TypeUnauthenticated, "ERPCAUTH"},
+			"1005": {TypeUnavailable, "ETOOMANYFAILS"},
+			"1008": {TypeTimeout, "ERPCTIMEDOUT"},
+			"1009": {TypeUnavailable, "EFAILEDSOCKET"},
+			"1011": {TypeResourceExhausted, "EOVERCROWDED"},
+			"1014": {TypeUnavailable, "EEOF"},
+			"1018": {TypeResourceExhausted, "EREJECT"},
+			"2001": {TypeInternal, "EINTERNAL"},
+			"2002": {TypeInternal, "ERESPONSE"},
+			"2003": {TypeUnavailable, "ELOGOFF"},
+			"2004": {TypeResourceExhausted, "ELIMIT"},
+			"2005": {TypeUnavailable, "ECLOSE"},
+		},
+		// see ServerError of PulsarApi.proto
+		Pulsar: {
+			"0":  {TypeInternal, "UnknownError"},
+			"1":  {TypeInternal, "MetadataError"},
+			"2":  {TypeInternal, "PersistenceError"},
+			"3":  {TypeUnauthenticated, "AuthenticationError"},
+			"4":  {TypePermissionDenied, "AuthorizationError"},
+			"5":  {TypeConflict, "ConsumerBusy"},
+			"6":  {TypeUnavailable, "ServiceNotReady"},
+			"7":  {TypeResourceExhausted, "ProducerBlockedQuotaExceededError"},
+			"8":  {TypeResourceExhausted, "ProducerBlockedQuotaExceededException"},
+			"9":  {TypeInvalidArgument, "ChecksumError"},
+			"10": {TypeUnimplemented, "UnsupportedVersionError"},
+			"11": {TypeNotFound, "TopicNotFound"},
+			"12": {TypeNotFound, "SubscriptionNotFound"},
+			"13": {TypeNotFound, "ConsumerNotFound"},
+			"14": {TypeResourceExhausted, "TooManyRequests"},
+			"15": {TypeUnavailable, "TopicTerminatedError"},
+			"16": {TypeConflict, "ProducerBusy"},
+			"17": {TypeInvalidArgument, "InvalidTopicName"},
+			"18": {TypeInvalidArgument, "IncompatibleSchema"},
+			"19": {TypeInternal, "ConsumerAssignError"},
+			"22": {TypePermissionDenied, "NotAllowedError"},
+			"25": {TypeConflict, "ProducerFenced"},
+		},
+		// see src/Common/ErrorCodes.cpp of clickhouse
+		ClickHouse: {
+			"16":   {TypeNotFound, "NO_SUCH_COLUMN_IN_TABLE"},
+			"36":   {TypeInvalidArgument, "BAD_ARGUMENTS"},
+			"43":   {TypeInvalidArgument, "ILLEGAL_TYPE_OF_ARGUMENT"},
+			"46":   {TypeNotFound, "UNKNOWN_FUNCTION"},
+			"47":   {TypeNotFound, "UNKNOWN_IDENTIFIER"},
+			"48":   {TypeUnimplemented, "NOT_IMPLEMENTED"},
+			"53":   {TypeInvalidArgument, "TYPE_MISMATCH"},
+			"57":   {TypeConflict, "TABLE_ALREADY_EXISTS"},
+			"60":   {TypeNotFound, "UNKNOWN_TABLE"},
+			"62":   {TypeInvalidArgument, "SYNTAX_ERROR"},
+			"81":   {TypeNotFound, "UNKNOWN_DATABASE"},
+			"115":  {TypeInvalidArgument, "UNKNOWN_SETTING"},
+			"158":  {TypeResourceExhausted, "TOO_MANY_ROWS"},
+			"159":  {TypeTimeout, "TIMEOUT_EXCEEDED"},
+			"160":  {TypeTimeout, "TOO_SLOW"},
+			"164":  {TypePermissionDenied, "READONLY"},
+			"202":  {TypeResourceExhausted, "TOO_MANY_SIMULTANEOUS_QUERIES"},
+			"209":  {TypeTimeout, "SOCKET_TIMEOUT"},
+			"210":  {TypeUnavailable, "NETWORK_ERROR"},
+			"241":  {TypeResourceExhausted, "MEMORY_LIMIT_EXCEEDED"},
+			"242":  {TypePermissionDenied, "TABLE_IS_READ_ONLY"},
+			"252":  {TypeResourceExhausted, "TOO_MANY_PARTS"},
+			"279":  {TypeUnavailable, "ALL_CONNECTION_TRIES_FAILED"},
+			"285":  {TypeUnavailable, "TOO_FEW_LIVE_REPLICAS"},
+			"307":  {TypeResourceExhausted, "TOO_MANY_BYTES"},
+			"394":  {TypeCancelled, "QUERY_WAS_CANCELLED"},
+			"396":  {TypeResourceExhausted, "TOO_MANY_ROWS_OR_BYTES"},
+			"497":  {TypePermissionDenied, "ACCESS_DENIED"},
+			"516":  {TypeUnauthenticated, "AUTHENTICATION_FAILED"},
+			"1002": {TypeInternal, "UNKNOWN_EXCEPTION"},
+		},
+		// ORA- error numbers, named after the predefined pl/sql exceptions where there is one
+		Oracle: {
+			"00001": {TypeConflict, "DUP_VAL_ON_INDEX"},
+			"00018": {TypeResourceExhausted, "MAXIMUM_SESSIONS_EXCEEDED"},
+			"00020": {TypeResourceExhausted, "MAXIMUM_PROCESSES_EXCEEDED"},
+			"00028": {TypeCancelled, "SESSION_KILLED"},
+			"00051": {TypeTimeout, "TIMEOUT_ON_RESOURCE"},
+			"00054": {TypeConflict, "RESOURCE_BUSY"},
+			"00060": {TypeConflict, "DEADLOCK_DETECTED"},
+			"00600": {TypeInternal, "INTERNAL_ERROR"},
+			"00900": {TypeInvalidArgument, "INVALID_SQL_STATEMENT"},
+			"00904": {TypeInvalidArgument, "INVALID_IDENTIFIER"},
+			"00933": {TypeInvalidArgument, "SQL_COMMAND_NOT_PROPERLY_ENDED"},
+			"00936": {TypeInvalidArgument, "MISSING_EXPRESSION"},
+			"00942": {TypeNotFound, "TABLE_OR_VIEW_DOES_NOT_EXIST"},
+			"01012": {TypeUnauthenticated, "NOT_LOGGED_ON"},
+			"01013": {TypeCancelled, "USER_REQUESTED_CANCEL"},
+			"01017": {TypeUnauthenticated, "LOGIN_DENIED"},
+			"01031": {TypePermissionDenied, "INSUFFICIENT_PRIVILEGES"},
+			"01034": {TypeUnavailable, "ORACLE_NOT_AVAILABLE"},
+			"01400": {TypeInvalidArgument, "CANNOT_INSERT_NULL"},
+			"01422": {TypeInvalidArgument, "TOO_MANY_ROWS"},
+			"01438": {TypeInvalidArgument, "VALUE_LARGER_THAN_PRECISION"},
+			"01476": {TypeInvalidArgument, "ZERO_DIVIDE"},
+			"01555": {TypeInternal, "SNAPSHOT_TOO_OLD"},
+			"01722": {TypeInvalidArgument, "INVALID_NUMBER"},
+			"02291": {TypeConflict, "PARENT_KEY_NOT_FOUND"},
+			"02292": {TypeConflict, "CHILD_RECORD_FOUND"},
+			"03113": {TypeUnavailable, "END_OF_FILE_ON_COMMUNICATION_CHANNEL"},
+			"03135": {TypeUnavailable, "CONNECTION_LOST_CONTACT"},
+			"04031": {TypeResourceExhausted, "UNABLE_TO_ALLOCATE_SHARED_MEMORY"},
+			"04068": {TypeInternal, "EXISTING_STATE_OF_PACKAGES_DISCARDED"},
+			"06502": {TypeInvalidArgument, "VALUE_ERROR"},
+			"06508": {TypeNotFound, "PROGRAM_UNIT_NOT_FOUND"},
+			"06550": {TypeInvalidArgument, "PLSQL_COMPILATION_ERROR"},
+			"08177": {TypeConflict, "CANNOT_SERIALIZE_ACCESS"},
+			"12899": {TypeInvalidArgument, "VALUE_TOO_LARGE_FOR_COLUMN"},
+			"30006": {TypeTimeout, "RESOURCE_BUSY_WAIT_TIMEOUT"},
+		},
+		// see RFC 5321 4.2.3 and RFC 4954 6
+		SMTP: {
+			"421": {TypeUnavailable, "SERVICE_NOT_AVAILABLE"},
+			"432": {TypeUnauthenticated, "PASSWORD_TRANSITION_NEEDED"},
+			"450": {TypeUnavailable, "MAILBOX_UNAVAILABLE"},
+			"451": {TypeInternal, "LOCAL_ERROR"},
+			"452": {TypeResourceExhausted, "INSUFFICIENT_STORAGE"},
+			"454": {TypeUnavailable, "TEMPORARY_AUTHENTICATION_FAILURE"},
+			"455": {TypeInvalidArgument, "PARAMETERS_NOT_ACCOMMODATED"},
+			"500": {TypeInvalidArgument, "SYNTAX_ERROR"},
+			"501": {TypeInvalidArgument, "SYNTAX_ERROR_IN_PARAMETERS"},
+			"502": {TypeUnimplemented, "COMMAND_NOT_IMPLEMENTED"},
+			"503": {TypeInvalidArgument, "BAD_SEQUENCE_OF_COMMANDS"},
+			"504": {TypeUnimplemented, "PARAMETER_NOT_IMPLEMENTED"},
+			"530": {TypeUnauthenticated, "AUTHENTICATION_REQUIRED"},
+			"534": {TypePermissionDenied, "AUTHENTICATION_MECHANISM_TOO_WEAK"},
+			"535": {TypeUnauthenticated, "AUTHENTICATION_FAILED"},
+			"550": {TypeNotFound, "MAILBOX_UNAVAILABLE"},
+			"551": {TypeNotFound, "USER_NOT_LOCAL"},
+			"552": {TypeResourceExhausted, "EXCEEDED_STORAGE_ALLOCATION"},
+			"553": {TypeInvalidArgument, "MAILBOX_NAME_NOT_ALLOWED"},
+			"554": {TypePermissionDenied, "TRANSACTION_FAILED"},
+			"555": {TypeInvalidArgument, "PARAMETERS_NOT_RECOGNIZED"},
+		},
+		// see RFC 4511 4.1.9, success, compareFalse, compareTrue, referral and saslBindInProgress are not errors
+		LDAP: {
+			"1":  {TypeInternal, "operationsError"},
+			"2":  {TypeInvalidArgument, "protocolError"},
+			"3":  {TypeTimeout, "timeLimitExceeded"},
+			"4":  {TypeResourceExhausted, "sizeLimitExceeded"},
+			"7":  {TypeUnimplemented, "authMethodNotSupported"},
+			"8":  {TypePermissionDenied, "strongerAuthRequired"},
+			"11": {TypeResourceExhausted, "adminLimitExceeded"},
+			"12": {TypeUnimplemented, "unavailableCriticalExtension"},
+			"13": {TypePermissionDenied, "confidentialityRequired"},
+			"16": {TypeNotFound, "noSuchAttribute"},
+			"17": {TypeInvalidArgument, "undefinedAttributeType"},
+			"18": {TypeInvalidArgument, "inappropriateMatching"},
+			"19": {TypeInvalidArgument, "constraintViolation"},
+			"20": {TypeConflict, "attributeOrValueExists"},
+			"21": {TypeInvalidArgument, "invalidAttributeSyntax"},
+			"32": {TypeNotFound, "noSuchObject"},
+			"33": {TypeInternal, "aliasProblem"},
+			"34": {TypeInvalidArgument, "invalidDNSyntax"},
+			"36": {TypeInternal, "aliasDereferencingProblem"},
+			"48": {TypeUnauthenticated, "inappropriateAuthentication"},
+			"49": {TypeUnauthenticated, "invalidCredentials"},
+			"50": {TypePermissionDenied, "insufficientAccessRights"},
+			"51": {TypeUnavailable, "busy"},
+			"52": {TypeUnavailable, "unavailable"},
+			"53": {TypePermissionDenied, "unwillingToPerform"},
+			"54": {TypeInternal, "loopDetect"},
+			"64": {TypeInvalidArgument, "namingViolation"},
+			"65": {TypeInvalidArgument, "objectClassViolation"},
+			"66": {TypeConflict, "notAllowedOnNonLeaf"},
+			"67": {TypeInvalidArgument, "notAllowedOnRDN"},
+			"68": {TypeConflict, "entryAlreadyExists"},
+			"69": {TypePermissionDenied, "objectClassModsProhibited"},
+			"71": {TypeUnimplemented, "affectsMultipleDSAs"},
+			"80": {TypeInternal, "other"},
+		},
+		// the codes reserved by the specification, -32000 to -32099 are defined by the servers
+		JSONRPC: {
+			"-32700": {TypeInvalidArgument, "PARSE_ERROR"},
+			"-32600": {TypeInvalidArgument, "INVALID_REQUEST"},
+			"-32601": {TypeUnimplemented, "METHOD_NOT_FOUND"},
+			"-32602": {TypeInvalidArgument, "INVALID_PARAMS"},
+			"-32603": {TypeInternal, "INTERNAL_ERROR"},
+		},
+	}
+}
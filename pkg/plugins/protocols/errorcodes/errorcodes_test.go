@@ -0,0 +1,45 @@
+package errorcodes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errorcodes.json")
+	if err := os.WriteFile(path, []byte(`{
+		"mysql": {"1062": {"type": "duplicate", "name": "DUPLICATE_KEY"}},
+		"hsf": {"3": {"type": "timeout", "name": "HSF_TIMEOUT"}}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("L7_ERROR_CODES_PATH", path)
+	d, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		protocol, code string
+		want           Entry
+	}{
+		{MySQL, "1062", Entry{"duplicate", "DUPLICATE_KEY"}},
+		{MySQL, "1205", Entry{TypeTimeout, "ER_LOCK_WAIT_TIMEOUT"}},
+		{"hsf", "3", Entry{TypeTimeout, "HSF_TIMEOUT"}},
+		{Dubbo, "31", Entry{TypeTimeout, "SERVER_TIMEOUT"}},
+		{GRPC, "4", Entry{TypeTimeout, "DEADLINE_EXCEEDED"}},
+		{Redis, "7", Entry{TypeUnknown, "7"}},
+	}
+	for _, c := range cases {
+		tags := map[string]string{}
+		d.Tag(tags, c.protocol, c.code)
+		if tags["error_type"] != c.want.Type || tags["error_name"] != c.want.Name {
+			t.Errorf("%s %s: unexpected tags %v", c.protocol, c.code, tags)
+		}
+	}
+
+	t.Setenv("L7_ERROR_CODES_PATH", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := New(); err != nil {
+		t.Errorf("missing dictionary file should be ignored, got %v", err)
+	}
+}
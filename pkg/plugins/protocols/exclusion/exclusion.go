@@ -0,0 +1,103 @@
+// Package exclusion configures the ports the L7 protocol plugins never parse,
+// e.g. TLS traffic without uprobes or custom binary protocols. The socket filters
+// drop such packets before any parsing, they still show up in flow level metrics.
+//
+//	L7_EXCLUDED_PORTS=[443,8443]                                 skipped by all protocol plugins, none by default
+//	L7_PROTOCOL_EXCLUDED_PORTS={"http":[9000],"mysql":[33060]}   skipped by the given plugin only
+//
+// The ports of both lists are merged, a plugin excludes at most 64 distinct ports. The plugins of the
+// encrypted protocols (tls, quic) parse the very traffic the global list is meant for, they only skip the
+// ports of their own protocol, see ApplyProtocol.
+package exclusion
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	mapExcludedPorts = "excluded_ports_map"
+	// maxExcludedPorts is EXCLUDED_PORTS_MAX_ENTRIES of ebpf/include/port_exclusion.h
+	maxExcludedPorts = 64
+)
+
+type Config struct {
+	Ports         []uint16            `env:"L7_EXCLUDED_PORTS"`
+	ProtocolPorts map[string][]uint16 `env:"L7_PROTOCOL_EXCLUDED_PORTS"`
+}
+
+var (
+	cfg     Config
+	cfgOnce sync.Once
+)
+
+func config() *Config {
+	cfgOnce.Do(func() {
+		envconf.MustLoad(&cfg)
+	})
+	return &cfg
+}
+
+// Ports returns the excluded ports of the protocol plugin.
+func Ports(protocol string) []uint16 {
+	return config().ports(protocol)
+}
+
+// ports merges the global and the protocol ports without duplicates.
+func (c *Config) ports(protocol string) []uint16 {
+	seen := make(map[uint16]bool)
+	ports := make([]uint16, 0, len(c.Ports)+len(c.ProtocolPorts[protocol]))
+	for _, list := range [][]uint16{c.Ports, c.ProtocolPorts[protocol]} {
+		for _, port := range list {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// ProtocolPorts returns the excluded ports of the protocol plugin but the global ones.
+func ProtocolPorts(protocol string) []uint16 {
+	return (&Config{ProtocolPorts: config().ProtocolPorts}).ports(protocol)
+}
+
+// portMap is the excluded ports map of a collection.
+type portMap interface {
+	Put(key, value interface{}) error
+}
+
+// Apply fills the excluded ports map of a loaded collection.
+func Apply(collection *ebpf.Collection, protocol string) error {
+	m, ok := collection.Maps[mapExcludedPorts]
+	if !ok {
+		return fmt.Errorf("map %s not found", mapExcludedPorts)
+	}
+	return fill(m, protocol, Ports(protocol))
+}
+
+// ApplyProtocol fills the excluded ports map of a loaded collection with the ports of the protocol only.
+func ApplyProtocol(collection *ebpf.Collection, protocol string) error {
+	m, ok := collection.Maps[mapExcludedPorts]
+	if !ok {
+		return fmt.Errorf("map %s not found", mapExcludedPorts)
+	}
+	return fill(m, protocol, ProtocolPorts(protocol))
+}
+
+func fill(m portMap, protocol string, ports []uint16) error {
+	if len(ports) > maxExcludedPorts {
+		return fmt.Errorf("too many excluded ports for %s: %d, max: %d", protocol, len(ports), maxExcludedPorts)
+	}
+	for _, port := range ports {
+		if err := m.Put(port, uint8(1)); err != nil {
+			return fmt.Errorf("exclude port %d: %v", port, err)
+		}
+	}
+	return nil
+}
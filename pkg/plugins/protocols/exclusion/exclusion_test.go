@@ -0,0 +1,79 @@
+package exclusion
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+type fakeMap map[uint16]uint8
+
+func (m fakeMap) Put(key, value interface{}) error {
+	if key.(uint16) == 0 {
+		return errors.New("invalid port")
+	}
+	m[key.(uint16)] = value.(uint8)
+	return nil
+}
+
+func TestPorts(t *testing.T) {
+	c := &Config{
+		Ports:         []uint16{8443, 9000},
+		ProtocolPorts: map[string][]uint16{"http": {9000, 9090}, "mysql": {33060}},
+	}
+	tests := []struct {
+		protocol string
+		expect   []uint16
+	}{
+		{"http", []uint16{8443, 9000, 9090}},
+		{"mysql", []uint16{8443, 9000, 33060}},
+		{"redis", []uint16{8443, 9000}},
+	}
+	for _, tt := range tests {
+		if ports := c.ports(tt.protocol); !reflect.DeepEqual(ports, tt.expect) {
+			t.Errorf("%s: expected %v, got %v", tt.protocol, tt.expect, ports)
+		}
+	}
+	if ports := (&Config{ProtocolPorts: c.ProtocolPorts}).ports("http"); !reflect.DeepEqual(ports, []uint16{9000, 9090}) {
+		t.Errorf("expected the protocol ports only, got %v", ports)
+	}
+	if ports := (&Config{}).ports("http"); len(ports) != 0 {
+		t.Errorf("expected no port excluded by default, got %v", ports)
+	}
+}
+
+func TestFill(t *testing.T) {
+	m := fakeMap{}
+	if err := fill(m, "http", []uint16{8443, 9000}); err != nil || len(m) != 2 || m[8443] != 1 {
+		t.Errorf("expected the ports in the map, got %v %v", m, err)
+	}
+
+	ports := make([]uint16, maxExcludedPorts+1)
+	for i := range ports {
+		ports[i] = uint16(10000 + i)
+	}
+	if err := fill(fakeMap{}, "http", ports[:maxExcludedPorts]); err != nil {
+		t.Errorf("expected %d ports to fit, got %v", maxExcludedPorts, err)
+	}
+	if err := fill(fakeMap{}, "http", ports); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("expected the limit error, got %v", err)
+	}
+	if err := fill(fakeMap{}, "http", []uint16{0}); err == nil {
+		t.Error("expected the error of the map")
+	}
+
+	// the duplicates of both lists count once towards the limit
+	c := &Config{Ports: ports[:maxExcludedPorts], ProtocolPorts: map[string][]uint16{"http": ports[:10]}}
+	if err := fill(fakeMap{}, "http", c.ports("http")); err != nil {
+		t.Errorf("expected the merged ports to fit, got %v", err)
+	}
+}
+
+func TestApplyWithoutMap(t *testing.T) {
+	if err := Apply(&ebpf.Collection{Maps: map[string]*ebpf.Map{}}, "http"); err == nil {
+		t.Error("expected an error without the excluded ports map")
+	}
+}
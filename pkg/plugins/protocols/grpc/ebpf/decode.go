@@ -0,0 +1,139 @@
+package ebpf
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/hpack"
+)
+
+const (
+	hpackDynamicTableSize = 4096
+	decoderIdleTimeout    = 5 * time.Minute
+)
+
+// connDecoder keeps the HPACK compression context of both directions of a connection.
+// HPACK is stateful, header blocks referencing dynamic table entries that were emitted
+// before the agent attached can not be resolved and are skipped.
+type connDecoder struct {
+	request  *hpack.Decoder
+	response *hpack.Decoder
+	lastSeen time.Time
+}
+
+type decoderCache struct {
+	sync.Mutex
+	decoders map[string]*connDecoder
+	clock    clock.Clock
+}
+
+func newDecoderCache() *decoderCache {
+	return &decoderCache{
+		decoders: make(map[string]*connDecoder),
+		clock:    clock.Real,
+	}
+}
+
+func (c *decoderCache) get(key *StreamKey) *connDecoder {
+	c.Lock()
+	defer c.Unlock()
+	connKey := fmt.Sprintf("%s:%d-%s:%d",
+		net.IP(key.SourceIP[:]).String(), key.SourcePort, net.IP(key.DestIP[:]).String(), key.DestPort)
+	d, ok := c.decoders[connKey]
+	if !ok {
+		d = &connDecoder{
+			request:  hpack.NewDecoder(hpackDynamicTableSize),
+			response: hpack.NewDecoder(hpackDynamicTableSize),
+		}
+		c.decoders[connKey] = d
+	}
+	d.lastSeen = c.clock.Now()
+	return d
+}
+
+func (c *decoderCache) gc() {
+	c.Lock()
+	defer c.Unlock()
+	for k, d := range c.decoders {
+		if c.clock.Since(d.lastSeen) > decoderIdleTimeout {
+			delete(c.decoders, k)
+		}
+	}
+}
+
+func decodeMetrics(decoders *decoderCache, key *StreamKey, data *GrpcStream) (*Metric, error) {
+	metric := &Metric{
+		SourceIP:      net.IP(key.SourceIP[:]).String(),
+		SourcePort:    key.SourcePort,
+		DestIP:        net.IP(key.DestIP[:]).String(),
+		DestPort:      key.DestPort,
+		StreamID:      key.StreamID,
+		GrpcStatus:    -1,
+		RequestBytes:  data.RequestBytes,
+		ResponseBytes: data.ResponseBytes,
+		Duration:      data.Duration,
+		SocketCookie:  data.Cookie,
+	}
+
+	d := decoders.get(key)
+	requestHeaders, err := d.request.DecodePartial(headerBlock(data.RequestHeaders[:], data.RequestHeaderLen))
+	if err != nil && len(requestHeaders) == 0 {
+		return nil, fmt.Errorf("decode request headers of stream %d: %v", key.StreamID, err)
+	}
+	for _, h := range requestHeaders {
+		switch h.Name {
+		case ":path":
+			metric.Path = h.Value
+		case ":authority":
+			metric.Authority = h.Value
+		case ":method":
+			metric.HttpMethod = h.Value
+		case "content-type":
+			metric.ContentType = h.Value
+		}
+	}
+	metric.Service, metric.Method = ParsePath(metric.Path)
+	if data.RequestMessageLen > 0 {
+		metric.RequestMessage = append([]byte(nil), data.RequestMessage[:min(int(data.RequestMessageLen), len(data.RequestMessage))]...)
+	}
+
+	// trailers-only responses carry grpc-status in the first (and only) header block.
+	responseHeaders, _ := d.response.DecodePartial(headerBlock(data.ResponseHeaders[:], data.ResponseHeaderLen))
+	trailers, _ := d.response.DecodePartial(headerBlock(data.Trailers[:], data.TrailerLen))
+	for _, h := range append(responseHeaders, trailers...) {
+		switch h.Name {
+		case ":status":
+			metric.HttpStatus, _ = strconv.Atoi(h.Value)
+		case "grpc-status":
+			if status, err := strconv.Atoi(h.Value); err == nil {
+				metric.GrpcStatus = status
+			}
+		case "grpc-message":
+			metric.GrpcMessage = h.Value
+		}
+	}
+	return metric, nil
+}
+
+func headerBlock(buf []byte, length uint16) []byte {
+	if int(length) > len(buf) {
+		return buf
+	}
+	return buf[:length]
+}
+
+// ParsePath splits the gRPC :path pseudo header, like /helloworld.Greeter/SayHello,
+// into the fully qualified service name and the method name.
+func ParsePath(path string) (service, method string) {
+	path = strings.TrimPrefix(path, "/")
+	idx := strings.LastIndex(path, "/")
+	if idx < 0 {
+		return path, ""
+	}
+	return path[:idx], path[idx+1:]
+}
@@ -0,0 +1,151 @@
+package ebpf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+func TestParsePath(t *testing.T) {
+	for _, c := range []struct {
+		path    string
+		service string
+		method  string
+	}{
+		{"/helloworld.Greeter/SayHello", "helloworld.Greeter", "SayHello"},
+		{"/erda.core.v1.UserService/GetUser", "erda.core.v1.UserService", "GetUser"},
+		{"/a/b/c", "a/b", "c"},
+		{"noslash", "noslash", ""},
+		{"", "", ""},
+	} {
+		service, method := ParsePath(c.path)
+		if service != c.service || method != c.method {
+			t.Errorf("%q: expected %q %q, got %q %q", c.path, c.service, c.method, service, method)
+		}
+	}
+}
+
+// literal returns an HPACK string literal without huffman coding.
+func literal(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func block(fields ...[]byte) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = append(b, f...)
+	}
+	return b
+}
+
+func stream(request, response, trailers []byte) *GrpcStream {
+	s := &GrpcStream{
+		RequestHeaderLen:  uint16(len(request)),
+		ResponseHeaderLen: uint16(len(response)),
+		TrailerLen:        uint16(len(trailers)),
+	}
+	copy(s.RequestHeaders[:], request)
+	copy(s.ResponseHeaders[:], response)
+	copy(s.Trailers[:], trailers)
+	return s
+}
+
+func TestDecodeMetrics(t *testing.T) {
+	decoders := newDecoderCache()
+	key := &StreamKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 50051, StreamID: 1}
+
+	for _, c := range []struct {
+		name     string
+		key      StreamKey
+		stream   *GrpcStream
+		expected Metric
+	}{
+		{
+			"indexed and literal fields",
+			*key,
+			stream(
+				block(
+					[]byte{0x83, 0x86},                                    // :method POST, :scheme http
+					[]byte{0x44}, literal("/helloworld.Greeter/SayHello"), // :path, incremental indexing
+					[]byte{0x41}, literal("localhost:50051"), // :authority, incremental indexing
+					[]byte{0x0f, 0x10}, literal("application/grpc"), // content-type, without indexing
+					[]byte{0x40}, literal("te"), literal("trailers"), // new name, incremental indexing
+				),
+				[]byte{0x88}, // :status 200
+				block([]byte{0x40}, literal("grpc-status"), literal("0")),
+			),
+			Metric{Path: "/helloworld.Greeter/SayHello", Service: "helloworld.Greeter", Method: "SayHello",
+				Authority: "localhost:50051", ContentType: "application/grpc", HttpMethod: "POST", HttpStatus: 200, GrpcStatus: 0},
+		},
+		{
+			"dynamic table of the connection",
+			StreamKey{SourceIP: key.SourceIP, DestIP: key.DestIP, SourcePort: key.SourcePort, DestPort: key.DestPort, StreamID: 3},
+			stream(
+				// te 62, :authority 63, :path 64
+				block([]byte{0x83, 0x86, 0xc0, 0xbf, 0xbe}, []byte{0x0f, 0x10}, literal("application/grpc")),
+				[]byte{0x88},
+				block([]byte{0xbe}, []byte{0x10}, literal("grpc-message"), literal("done")), // grpc-status 0, never indexed
+			),
+			Metric{Path: "/helloworld.Greeter/SayHello", Service: "helloworld.Greeter", Method: "SayHello",
+				Authority: "localhost:50051", ContentType: "application/grpc", HttpMethod: "POST", HttpStatus: 200, GrpcStatus: 0, GrpcMessage: "done"},
+		},
+		{
+			"trailers-only response",
+			StreamKey{SourceIP: key.SourceIP, DestIP: key.DestIP, SourcePort: key.SourcePort, DestPort: key.DestPort, StreamID: 5},
+			stream(
+				block([]byte{0x83, 0xc0}),
+				block([]byte{0x88}, []byte{0x40}, literal("grpc-status"), literal("14")),
+				nil,
+			),
+			Metric{Path: "/helloworld.Greeter/SayHello", Service: "helloworld.Greeter", Method: "SayHello",
+				HttpMethod: "POST", HttpStatus: 200, GrpcStatus: 14},
+		},
+		{
+			"connection attached after its first stream",
+			StreamKey{SourceIP: [4]byte{10, 0, 0, 3}, DestIP: key.DestIP, SourcePort: key.SourcePort, DestPort: key.DestPort, StreamID: 7},
+			stream(block([]byte{0x83, 0x86}, []byte{0x44}, literal("/pkg.Svc/Call"), []byte{0xbf}), []byte{0xbe}, nil),
+			Metric{Path: "/pkg.Svc/Call", Service: "pkg.Svc", Method: "Call", HttpMethod: "POST", GrpcStatus: -1},
+		},
+	} {
+		m, err := decodeMetrics(decoders, &c.key, c.stream)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		e := &c.expected
+		if m.Path != e.Path || m.Service != e.Service || m.Method != e.Method || m.Authority != e.Authority ||
+			m.ContentType != e.ContentType || m.HttpMethod != e.HttpMethod || m.HttpStatus != e.HttpStatus ||
+			m.GrpcStatus != e.GrpcStatus || m.GrpcMessage != e.GrpcMessage || m.StreamID != c.key.StreamID {
+			t.Errorf("%s: unexpected metric %+v", c.name, m)
+		}
+	}
+
+	// the request headers referencing entries of an unknown dynamic table are skipped.
+	unknown := &StreamKey{SourceIP: [4]byte{10, 0, 0, 4}, DestIP: key.DestIP, SourcePort: 41000, DestPort: 50051, StreamID: 9}
+	if m, err := decodeMetrics(decoders, unknown, stream([]byte{0xbe}, nil, nil)); err == nil {
+		t.Errorf("unexpected metric %+v", m)
+	}
+}
+
+func TestDecoderCacheGC(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	decoders := newDecoderCache()
+	decoders.clock = clk
+	idle := &StreamKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 50051}
+	active := &StreamKey{SourceIP: [4]byte{10, 0, 0, 3}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 50051}
+	decoders.get(idle)
+	decoders.get(active)
+	clk.Add(decoderIdleTimeout / 2)
+	decoders.get(active)
+	clk.Add(decoderIdleTimeout/2 + time.Second)
+	decoders.gc()
+	if len(decoders.decoders) != 1 {
+		t.Fatalf("expected the decoder of the active connection only, got %d decoders", len(decoders.decoders))
+	}
+	clk.Add(decoderIdleTimeout)
+	decoders.gc()
+	if len(decoders.decoders) != 0 {
+		t.Errorf("expected no decoder, got %d", len(decoders.decoders))
+	}
+}
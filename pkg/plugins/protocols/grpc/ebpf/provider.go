@@ -0,0 +1,89 @@
+package ebpf
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	programPath = "target/grpc.bpf.o"
+	programName = "socket__grpc_filter"
+	mapMetric   = "metrics_map"
+	mapCapture  = "grpc_capture_map"
+
+	// gcInterval is the interval the HPACK decoders of the idle connections are forgotten.
+	gcInterval = uint64(time.Minute)
+)
+
+type Interface interface {
+	Load() error
+	Close() error
+}
+
+type provider struct {
+	link    attach.Link
+	ch      chan Metric
+	capture bool
+
+	filter   *socketfilter.Filter
+	decoders *decoderCache
+}
+
+// New creates the probe of a veth, capture enables the capture of request messages for field extraction.
+func New(l attach.Link, ch chan Metric, capture bool) Interface {
+	return &provider{
+		link:     l,
+		ch:       ch,
+		capture:  capture,
+		decoders: newDecoderCache(),
+	}
+}
+
+func (e *provider) Load() error {
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		if err := exclusion.Apply(c, "grpc"); err != nil {
+			return err
+		}
+		if e.capture {
+			return c.Maps[mapCapture].Put(uint32(0), uint32(1))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m := e.filter.Collection.DetachMap(mapMetric)
+	var gc uint64
+	go e.filter.Loop(func(now uint64) {
+		if now-gc >= gcInterval {
+			e.decoders.gc()
+			gc = now
+		}
+		streams := socketfilter.Take[StreamKey, GrpcStream](m)
+		// feed the HPACK decoders in request order to keep the dynamic tables consistent.
+		sort.Slice(streams, func(i, j int) bool {
+			return streams[i].Val.RequestTimestamp < streams[j].Val.RequestTimestamp
+		})
+		for i := range streams {
+			metric, err := decodeMetrics(e.decoders, &streams[i].Key, &streams[i].Val)
+			if err != nil {
+				klog.Errorf("decode grpc metrics error: %v", err)
+				continue
+			}
+			e.ch <- *metric
+		}
+	})
+	return nil
+}
+
+func (e *provider) Close() error {
+	return e.filter.Close()
+}
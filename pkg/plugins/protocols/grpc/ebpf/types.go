@@ -0,0 +1,86 @@
+package ebpf
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	GrpcHeaderBlockSize = 128
+	GrpcMessageSize     = 128
+)
+
+const (
+	http2FlagEndStream = 0x1
+)
+
+type StreamKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+	StreamID   uint32
+}
+
+type GrpcStream struct {
+	RequestTimestamp  uint64
+	Duration          uint64
+	Cookie            uint64
+	RequestBytes      uint32
+	ResponseBytes     uint32
+	RequestHeaderLen  uint16
+	ResponseHeaderLen uint16
+	TrailerLen        uint16
+	RequestMessageLen uint16
+	Flags             uint8
+	RequestHeaders    [GrpcHeaderBlockSize]byte
+	ResponseHeaders   [GrpcHeaderBlockSize]byte
+	Trailers          [GrpcHeaderBlockSize]byte
+	RequestMessage    [GrpcMessageSize]byte
+}
+
+type Metric struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+	StreamID   uint32
+
+	Path        string
+	Service     string
+	Method      string
+	Authority   string
+	ContentType string
+	// HttpMethod is the :method pseudo header of the request.
+	HttpMethod string
+
+	// HttpStatus is the :status pseudo header of the response, 0 if not observed.
+	HttpStatus int
+	// GrpcStatus is the grpc-status of the trailers, -1 if not observed.
+	GrpcStatus  int
+	GrpcMessage string
+
+	RequestBytes  uint32
+	ResponseBytes uint32
+	Duration      uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+
+	// RequestMessage is the beginning of the first request message including the 5 bytes
+	// length prefix, only captured when field extraction is configured.
+	RequestMessage []byte
+}
+
+// IsGRPC reports whether the stream is a gRPC call, the other streams are plain HTTP/2 requests (e.g. h2c).
+func (m *Metric) IsGRPC() bool {
+	return m.GrpcStatus >= 0 || strings.HasPrefix(m.ContentType, "application/grpc")
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s grpc [%s:%d] --> [%s:%d][stream %d %s] ====> %d/%d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.StreamID, m.Path,
+		m.HttpStatus, m.GrpcStatus, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,274 @@
+// Package fields extracts configured fields of gRPC request messages into tags.
+//
+// The message types are resolved from a FileDescriptorSet, e.g. produced by
+// `protoc --include_imports --descriptor_set_out=services.pb` and mounted from a ConfigMap.
+// GRPC_EXTRACT_FIELDS maps the :path of a method to the field paths of its request,
+// nested fields are separated by dots:
+//
+//	{"/order.OrderService/CreateOrder": ["tenant_id", "header.region"]}
+//
+// Only the beginning of the first request message is captured, fields encoded after
+// the capture size or messages sent compressed are not extracted.
+package fields
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	// TagPrefix is prepended to the field path, e.g. grpc_request_header_region.
+	TagPrefix = "grpc_request_"
+
+	// compressed flag and message length
+	messagePrefixSize = 5
+)
+
+type Config struct {
+	DescriptorSetPath string              `env:"GRPC_DESCRIPTOR_SET_PATH"`
+	Fields            map[string][]string `env:"GRPC_EXTRACT_FIELDS"`
+}
+
+type Interface interface {
+	// Enabled reports whether any method is configured, request messages are only captured then.
+	Enabled() bool
+	// Extract returns the tags of the configured fields of the request message of path.
+	Extract(path string, message []byte) map[string]string
+}
+
+// field is a resolved field path, numbers and descriptors are in nesting order.
+type field struct {
+	tag     string
+	numbers []protowire.Number
+	leaf    protoreflect.FieldDescriptor
+}
+
+type extractor struct {
+	methods map[string][]field
+}
+
+// New loads the configuration from the environment, extraction is disabled without a descriptor set.
+func New() (Interface, error) {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	if cfg.DescriptorSetPath == "" || len(cfg.Fields) == 0 {
+		return &extractor{}, nil
+	}
+	raw, err := os.ReadFile(cfg.DescriptorSetPath)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(raw, set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %v", cfg.DescriptorSetPath, err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %v", cfg.DescriptorSetPath, err)
+	}
+	return newExtractor(files, cfg.Fields)
+}
+
+type descriptorResolver interface {
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+func newExtractor(files descriptorResolver, config map[string][]string) (*extractor, error) {
+	e := &extractor{methods: make(map[string][]field)}
+	for path, fieldPaths := range config {
+		service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid method path %q, want /package.Service/Method", path)
+		}
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %v", service, err)
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a service", service)
+		}
+		md := sd.Methods().ByName(protoreflect.Name(method))
+		if md == nil {
+			return nil, fmt.Errorf("method %s not found in service %s", method, service)
+		}
+		for _, fieldPath := range fieldPaths {
+			f, err := resolveField(md.Input(), fieldPath)
+			if err != nil {
+				return nil, fmt.Errorf("method %s: %v", path, err)
+			}
+			e.methods[path] = append(e.methods[path], f)
+		}
+	}
+	return e, nil
+}
+
+func resolveField(msg protoreflect.MessageDescriptor, fieldPath string) (field, error) {
+	f := field{tag: TagPrefix + strings.ReplaceAll(fieldPath, ".", "_")}
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		if msg == nil {
+			return f, fmt.Errorf("field %s: %s is not a message", fieldPath, names[i-1])
+		}
+		fd := msg.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return f, fmt.Errorf("field %s not found in %s", name, msg.FullName())
+		}
+		if fd.IsList() || fd.IsMap() {
+			return f, fmt.Errorf("field %s: repeated fields are not supported", fieldPath)
+		}
+		f.numbers = append(f.numbers, fd.Number())
+		f.leaf = fd
+		msg = fd.Message()
+	}
+	if f.leaf.Kind() == protoreflect.MessageKind || f.leaf.Kind() == protoreflect.GroupKind {
+		return f, fmt.Errorf("field %s is a message, select one of its fields", fieldPath)
+	}
+	return f, nil
+}
+
+func (e *extractor) Enabled() bool {
+	return len(e.methods) > 0
+}
+
+func (e *extractor) Extract(path string, message []byte) map[string]string {
+	fields, ok := e.methods[path]
+	if !ok || len(message) <= messagePrefixSize {
+		return nil
+	}
+	// compressed messages can not be decoded from a prefix
+	if message[0] != 0 {
+		return nil
+	}
+	size := binary.BigEndian.Uint32(message[1:messagePrefixSize])
+	payload := message[messagePrefixSize:]
+	if uint32(len(payload)) > size {
+		payload = payload[:size]
+	}
+	var tags map[string]string
+	for _, f := range fields {
+		if v, ok := extractField(payload, &f, 0); ok {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[f.tag] = v
+		}
+	}
+	return tags
+}
+
+// extractField walks the wire format of b, it stops at the first field that is cut off by the capture.
+// The last occurrence of a field wins, as in proto.Unmarshal.
+func extractField(b []byte, f *field, depth int) (string, bool) {
+	var (
+		value string
+		found bool
+	)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+		if num == f.numbers[depth] {
+			if depth < len(f.numbers)-1 {
+				if typ != protowire.BytesType {
+					break
+				}
+				v, n := protowire.ConsumeBytes(b)
+				if n < 0 {
+					break
+				}
+				if s, ok := extractField(v, f, depth+1); ok {
+					value, found = s, true
+				}
+				b = b[n:]
+				continue
+			}
+			if s, ok := formatValue(b, typ, f.leaf); ok {
+				value, found = s, true
+			}
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+	}
+	return value, found
+}
+
+func formatValue(b []byte, typ protowire.Type, fd protoreflect.FieldDescriptor) (string, bool) {
+	switch typ {
+	case protowire.VarintType:
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return "", false
+		}
+		switch fd.Kind() {
+		case protoreflect.BoolKind:
+			return strconv.FormatBool(v != 0), true
+		case protoreflect.EnumKind:
+			if ev := fd.Enum().Values().ByNumber(protoreflect.EnumNumber(int32(v))); ev != nil {
+				return string(ev.Name()), true
+			}
+			return strconv.FormatInt(int64(int32(v)), 10), true
+		case protoreflect.Int32Kind:
+			return strconv.FormatInt(int64(int32(v)), 10), true
+		case protoreflect.Int64Kind:
+			return strconv.FormatInt(int64(v), 10), true
+		case protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+			return strconv.FormatInt(protowire.DecodeZigZag(v), 10), true
+		default:
+			return strconv.FormatUint(v, 10), true
+		}
+	case protowire.Fixed32Type:
+		v, n := protowire.ConsumeFixed32(b)
+		if n < 0 {
+			return "", false
+		}
+		switch fd.Kind() {
+		case protoreflect.FloatKind:
+			return strconv.FormatFloat(float64(math.Float32frombits(v)), 'g', -1, 32), true
+		case protoreflect.Sfixed32Kind:
+			return strconv.FormatInt(int64(int32(v)), 10), true
+		default:
+			return strconv.FormatUint(uint64(v), 10), true
+		}
+	case protowire.Fixed64Type:
+		v, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return "", false
+		}
+		switch fd.Kind() {
+		case protoreflect.DoubleKind:
+			return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64), true
+		case protoreflect.Sfixed64Kind:
+			return strconv.FormatInt(int64(v), 10), true
+		default:
+			return strconv.FormatUint(v, 10), true
+		}
+	case protowire.BytesType:
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", false
+		}
+		if fd.Kind() == protoreflect.BytesKind {
+			return fmt.Sprintf("%x", v), true
+		}
+		return string(v), true
+	}
+	return "", false
+}
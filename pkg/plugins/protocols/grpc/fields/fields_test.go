@@ -0,0 +1,140 @@
+package fields
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testFiles(t *testing.T) descriptorResolver {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("order.proto"),
+		Package: proto.String("order"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Channel"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("WEB"), Number: proto.Int32(0)},
+				{Name: proto.String("APP"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Header"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("region", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")},
+			},
+			{
+				Name: proto.String("CreateOrderRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("tenant_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("header", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".order.Header"),
+					field("channel", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".order.Channel"),
+					field("delta", 4, descriptorpb.FieldDescriptorProto_TYPE_SINT64, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("OrderService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("CreateOrder"),
+				InputType:  proto.String(".order.CreateOrderRequest"),
+				OutputType: proto.String(".order.Header"),
+			}},
+		}},
+	}
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fd}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func grpcMessage(payload []byte) []byte {
+	b := make([]byte, messagePrefixSize, messagePrefixSize+len(payload))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func TestExtract(t *testing.T) {
+	e, err := newExtractor(testFiles(t), map[string][]string{
+		"/order.OrderService/CreateOrder": {"tenant_id", "header.region", "channel", "delta"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header []byte
+	header = protowire.AppendTag(header, 1, protowire.BytesType)
+	header = protowire.AppendString(header, "cn-hangzhou")
+	var payload []byte
+	payload = protowire.AppendTag(payload, 2, protowire.BytesType)
+	payload = protowire.AppendBytes(payload, header)
+	payload = protowire.AppendTag(payload, 3, protowire.VarintType)
+	payload = protowire.AppendVarint(payload, 1)
+	payload = protowire.AppendTag(payload, 4, protowire.VarintType)
+	payload = protowire.AppendVarint(payload, protowire.EncodeZigZag(-3))
+	payload = protowire.AppendTag(payload, 1, protowire.BytesType)
+	payload = protowire.AppendString(payload, "tenant-1")
+
+	tags := e.Extract("/order.OrderService/CreateOrder", grpcMessage(payload))
+	want := map[string]string{
+		"grpc_request_tenant_id":     "tenant-1",
+		"grpc_request_header_region": "cn-hangzhou",
+		"grpc_request_channel":       "APP",
+		"grpc_request_delta":         "-3",
+	}
+	if len(tags) != len(want) {
+		t.Fatalf("got %v, want %v", tags, want)
+	}
+	for k, v := range want {
+		if tags[k] != v {
+			t.Errorf("%s: got %q, want %q", k, tags[k], v)
+		}
+	}
+
+	// the capture cut off tenant_id
+	tags = e.Extract("/order.OrderService/CreateOrder", grpcMessage(payload)[:messagePrefixSize+len(payload)-2])
+	if _, ok := tags["grpc_request_tenant_id"]; ok || tags["grpc_request_channel"] != "APP" {
+		t.Errorf("truncated message: got %v", tags)
+	}
+
+	compressed := grpcMessage(payload)
+	compressed[0] = 1
+	if tags := e.Extract("/order.OrderService/CreateOrder", compressed); tags != nil {
+		t.Errorf("compressed message: got %v, want nil", tags)
+	}
+	if tags := e.Extract("/order.OrderService/Other", grpcMessage(payload)); tags != nil {
+		t.Errorf("unconfigured method: got %v, want nil", tags)
+	}
+}
+
+func TestNewExtractorInvalidConfig(t *testing.T) {
+	files := testFiles(t)
+	for _, config := range []map[string][]string{
+		{"order.OrderService": {"tenant_id"}},
+		{"/order.OrderService/Missing": {"tenant_id"}},
+		{"/order.OrderService/CreateOrder": {"missing"}},
+		{"/order.OrderService/CreateOrder": {"header"}},
+		{"/order.OrderService/CreateOrder": {"tenant_id.x"}},
+	} {
+		if _, err := newExtractor(files, config); err == nil {
+			t.Errorf("config %v: expected error", config)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package grpc
+
+import (
+	"runtime/debug"
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/grpc/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/grpc/fields"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/headsampling"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/meta"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/route"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_rpc"
+)
+
+// grpcStatusNames maps the canonical gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+var grpcStatusNames = map[int]string{
+	0:  "OK",
+	1:  "CANCELLED",
+	2:  "UNKNOWN",
+	3:  "INVALID_ARGUMENT",
+	4:  "DEADLINE_EXCEEDED",
+	5:  "NOT_FOUND",
+	6:  "ALREADY_EXISTS",
+	7:  "PERMISSION_DENIED",
+	8:  "RESOURCE_EXHAUSTED",
+	9:  "FAILED_PRECONDITION",
+	10: "ABORTED",
+	11: "OUT_OF_RANGE",
+	12: "UNIMPLEMENTED",
+	13: "INTERNAL",
+	14: "UNAVAILABLE",
+	15: "DATA_LOSS",
+	16: "UNAUTHENTICATED",
+}
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	extractor    fields.Interface
+	probes       *attach.Attacher
+	// http converts the plain HTTP/2 streams (h2c) to application_http.
+	http        meta.Interface
+	routes      route.Interface
+	headSampler headsampling.Interface
+	clock       clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	extractor, err := fields.New()
+	if err != nil {
+		return err
+	}
+	p.extractor = extractor
+	routes, err := route.New()
+	if err != nil {
+		return err
+	}
+	p.routes = routes
+	p.http = meta.New(p.Log, p.kprobeHelper, p.netNatHelper, errorCodes, routes)
+	headSampler, err := headsampling.New()
+	if err != nil {
+		return err
+	}
+	p.headSampler = headSampler
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("grpc", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch, p.extractor.Enabled())
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				p.Log.Errorf("panic: %v", err)
+				p.Log.Errorf("stack: %s", string(debug.Stack()))
+			}
+		}()
+		startup, events := kprobe.WaitSyncedQueue(p.kprobeHelper, "grpc", p.ch)
+		c <- startup
+		// sampled wraps the emit of a request kept by the head sampling as a share of the requests.
+		sampled := func(share float64, emit func(*metric.Metric)) func(*metric.Metric) {
+			return func(m *metric.Metric) {
+				if share < 1 {
+					m.Fields["sample_rate"] = share
+				}
+				emit(m)
+			}
+		}
+		emit := func(m *metric.Metric) { c <- m }
+		emitHTTP := func(m *metric.Metric) {
+			if slow := p.http.Slow(m); slow != nil {
+				c <- slow
+			}
+			c <- m
+		}
+		httpEnricher := p.http.Enricher()
+		flush := p.clock.NewTicker(enrich.FlushInterval)
+		defer flush.Stop()
+		for {
+			select {
+			case m := <-events:
+				if len(m.Path) > 0 && !m.IsGRPC() {
+					h := h2cMetric(&m)
+					share, keep := p.headSampler.Sample(p.namespace(&m), h.Method+" "+p.routes.Normalize(h.Path))
+					if keep {
+						httpEnricher.Submit(func() *metric.Metric { return p.http.Convert(h) }, sampled(share, emitHTTP))
+					}
+					continue
+				}
+				share, keep := p.headSampler.Sample(p.namespace(&m), m.Path)
+				if keep {
+					p.enricher.Submit(func() *metric.Metric { return p.convert(&m) }, sampled(share, emit))
+				}
+			case <-flush.C():
+				p.enricher.Flush()
+				httpEnricher.Flush()
+			}
+		}
+	}()
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	if len(m.Path) == 0 {
+		return nil
+	}
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"rpc_type":         "GRPC",
+			"rpc_target":       m.Path,
+			"rpc_service":      m.Service,
+			"rpc_method":       m.Method,
+			"grpc_authority":   m.Authority,
+			"http_status_code": strconv.Itoa(m.HttpStatus),
+		},
+		Fields: map[string]interface{}{
+			"elapsed_count":  1,
+			"elapsed_sum":    m.Duration,
+			"elapsed_max":    m.Duration,
+			"elapsed_min":    m.Duration,
+			"elapsed_mean":   m.Duration,
+			"request_bytes":  m.RequestBytes,
+			"response_bytes": m.ResponseBytes,
+		},
+	}
+
+	// a missing grpc-status means the stream was not a gRPC call or the trailers were lost,
+	// fall back to the HTTP/2 :status in that case.
+	isError := m.GrpcStatus > 0 || (m.GrpcStatus < 0 && m.HttpStatus >= 400)
+	output.Tags["error"] = strconv.FormatBool(isError)
+	if m.GrpcStatus >= 0 {
+		output.Tags["grpc_status_code"] = strconv.Itoa(m.GrpcStatus)
+		output.Tags["grpc_status"] = grpcStatusNames[m.GrpcStatus]
+		if len(m.GrpcMessage) > 0 {
+			output.Tags["grpc_message"] = m.GrpcMessage
+		}
+		if isError {
+			p.errorCodes.Tag(output.Tags, errorcodes.GRPC, strconv.Itoa(m.GrpcStatus))
+		}
+	}
+
+	for k, v := range p.extractor.Extract(m.Path, m.RequestMessage) {
+		output.Tags[k] = v
+	}
+
+	inCluster := p.enricher.Enrich(output, "GRPC", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		SocketCookie: m.SocketCookie,
+	})
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// unresolved targets are kept with their surrogate identity
+		if !enrich.Surrogate(output) {
+			return nil
+		}
+	}
+	return output
+}
+
+// namespace is the namespace of the server pod of the stream, of the client pod if the server is not a pod.
+func (p *provider) namespace(m *ebpf.Metric) string {
+	for _, ip := range []string{m.DestIP, m.SourceIP} {
+		if pod, err := p.kprobeHelper.GetPodByUID(ip); err == nil {
+			return pod.Namespace
+		}
+	}
+	return ""
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("grpc", &servicehub.Spec{
+		Services:             []string{"grpc"},
+		Description:          "ebpf for grpc",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package grpc
+
+import (
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/grpc/ebpf"
+	httpebpf "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+// h2cMetric returns the http request of a plain HTTP/2 stream, it is converted like the HTTP/1.x requests
+// of the http plugin. The probe decodes HTTP/2 on the connections starting with the preface or whose first
+// header block looks like a request, h2c included.
+func h2cMetric(m *ebpf.Metric) *httpebpf.Metric {
+	path, query, _ := strings.Cut(m.Path, "?")
+	return &httpebpf.Metric{
+		SourceIP:   m.SourceIP,
+		SourcePort: m.SourcePort,
+		DestIP:     m.DestIP,
+		DestPort:   m.DestPort,
+		Method:     m.HttpMethod,
+		Path:       path,
+		RawQuery:   query,
+		Version:    "HTTP/2",
+		// :authority replaces the Host header in HTTP/2
+		Headers: map[string]string{
+			"Host":         m.Authority,
+			"Content-Type": m.ContentType,
+		},
+		StatusCode:   uint16(m.HttpStatus),
+		Duration:     m.Duration,
+		SocketCookie: m.SocketCookie,
+	}
+}
@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/grpc/ebpf"
+)
+
+func TestH2CMetric(t *testing.T) {
+	m := &ebpf.Metric{
+		SourceIP:    "10.0.0.2",
+		SourcePort:  40000,
+		DestIP:      "10.0.0.1",
+		DestPort:    8080,
+		Path:        "/api/users?id=1",
+		Authority:   "users:8080",
+		ContentType: "application/json",
+		HttpMethod:  "GET",
+		HttpStatus:  404,
+		GrpcStatus:  -1,
+		Duration:    1000,
+	}
+	if m.IsGRPC() {
+		t.Fatalf("a json stream without grpc-status is not a gRPC call")
+	}
+	h := h2cMetric(m)
+	if h.Method != "GET" || h.Path != "/api/users" || h.RawQuery != "id=1" || h.Version != "HTTP/2" ||
+		h.StatusCode != 404 || h.Headers["Host"] != "users:8080" || h.Duration != 1000 {
+		t.Errorf("unexpected request %+v", h)
+	}
+
+	m.ContentType = "application/grpc+proto"
+	if !m.IsGRPC() {
+		t.Errorf("the gRPC content type should be classified as gRPC")
+	}
+	m.ContentType, m.GrpcStatus = "", 0
+	if !m.IsGRPC() {
+		t.Errorf("a stream with grpc-status should be classified as gRPC")
+	}
+}
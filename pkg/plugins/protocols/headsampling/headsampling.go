@@ -0,0 +1,242 @@
+// Package headsampling drops a share of the requests of the high QPS services before they are converted,
+// so that they do not overwhelm the plugins, the channel to the controller and the collector. The
+// requests are sampled per namespace, with a probability and a limit of requests per second per endpoint
+// (the method and route of http, the method of grpc):
+//
+//	HEAD_SAMPLING_RULES='{
+//	  "default": {"probability": 1, "rate": 0},
+//	  "namespaces": {
+//	    "shop": {"probability": 0.5, "rate": 200},
+//	    "kube-system": {"probability": 0}}}'
+//
+// A probability (1 when not set) keeps that share of the requests, a rate (0 for no limit) keeps at most
+// that many requests per second of every endpoint after it. The namespace of a request is the namespace
+// of its server pod, of its client pod when the server is not a pod. The rules are replaced live through
+// the local debug server of the agent (localhost:8777):
+//
+//	curl localhost:8777/debug/head-sampling
+//	curl -XPUT localhost:8777/debug/head-sampling -d '{"default": {"rate": 1000}}'
+//
+// The decision is made before the request is converted, the dropped requests are not in the slow
+// requests and the journeys either. The kept requests carry the share of the requests they stand for
+// in the sample_rate field, multiplied with the sampling of the plugin if any, see the http sampling.
+package headsampling
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const (
+	adminPath = "/debug/head-sampling"
+
+	// maxEndpoints bounds the rate limited endpoints, the idle ones are forgotten beyond it.
+	maxEndpoints = 10000
+	idleEndpoint = time.Minute
+)
+
+type Config struct {
+	// Rules is the initial Policy in json.
+	Rules string `env:"HEAD_SAMPLING_RULES"`
+}
+
+// Rule is the sampling of the requests of a namespace.
+type Rule struct {
+	// Probability is the share of the requests kept, in [0, 1], 1 when not set.
+	Probability *float64 `json:"probability,omitempty"`
+	// Rate is the maximum of requests kept per second and endpoint, 0 for no limit.
+	Rate float64 `json:"rate,omitempty"`
+}
+
+type Policy struct {
+	Default    Rule            `json:"default"`
+	Namespaces map[string]Rule `json:"namespaces,omitempty"`
+}
+
+type Interface interface {
+	// Sample returns whether the request to the endpoint of the namespace is kept and the share of the
+	// requests it stands for.
+	Sample(namespace, endpoint string) (float64, bool)
+}
+
+type endpointKey struct {
+	namespace string
+	endpoint  string
+}
+
+// window counts the requests of an endpoint in the current second.
+type window struct {
+	start    time.Time
+	arrivals float64
+	kept     float64
+	// previous is the arrivals of the previous second, the estimate of the share a kept request stands for.
+	previous float64
+}
+
+type sampler struct {
+	sync.Mutex
+	policy    Policy
+	endpoints map[endpointKey]*window
+	random    func() float64
+	now       func() time.Time
+}
+
+var (
+	shared     *sampler
+	sharedErr  error
+	sharedOnce sync.Once
+)
+
+// New returns the sampler shared by the plugins, its initial rules are loaded from the environment and
+// it is served on the debug server.
+func New() (Interface, error) {
+	sharedOnce.Do(func() {
+		cfg := Config{}
+		envconf.MustLoad(&cfg)
+		s := newSampler()
+		if cfg.Rules != "" {
+			var policy Policy
+			if err := json.Unmarshal([]byte(cfg.Rules), &policy); err != nil {
+				sharedErr = fmt.Errorf("invalid HEAD_SAMPLING_RULES: %v", err)
+				return
+			}
+			if err := s.set(policy); err != nil {
+				sharedErr = fmt.Errorf("invalid HEAD_SAMPLING_RULES: %v", err)
+				return
+			}
+		}
+		// served by the debug server of the agent on localhost only.
+		http.HandleFunc(adminPath, s.serveAdmin)
+		shared = s
+	})
+	if sharedErr != nil {
+		return nil, sharedErr
+	}
+	return shared, nil
+}
+
+func newSampler() *sampler {
+	return &sampler{
+		endpoints: make(map[endpointKey]*window),
+		random:    rand.Float64,
+		now:       time.Now,
+	}
+}
+
+func (s *sampler) Sample(namespace, endpoint string) (float64, bool) {
+	s.Lock()
+	defer s.Unlock()
+	rule, ok := s.policy.Namespaces[namespace]
+	if !ok {
+		rule = s.policy.Default
+	}
+	probability := 1.0
+	if rule.Probability != nil {
+		probability = *rule.Probability
+	}
+	switch {
+	case probability <= 0:
+		return 0, false
+	case probability < 1 && s.random() >= probability:
+		return 0, false
+	}
+	if rule.Rate <= 0 {
+		return probability, true
+	}
+
+	now := s.now()
+	k := endpointKey{namespace: namespace, endpoint: endpoint}
+	w, ok := s.endpoints[k]
+	if !ok {
+		if len(s.endpoints) >= maxEndpoints {
+			s.forget(now)
+		}
+		w = &window{start: now}
+		s.endpoints[k] = w
+	}
+	if elapsed := now.Sub(w.start); elapsed >= time.Second {
+		w.previous = 0
+		if elapsed < 2*time.Second {
+			w.previous = w.arrivals
+		}
+		w.start, w.arrivals, w.kept = now, 0, 0
+	}
+	w.arrivals++
+	if w.kept >= rule.Rate {
+		return 0, false
+	}
+	w.kept++
+	share := 1.0
+	if w.previous > rule.Rate {
+		share = rule.Rate / w.previous
+	}
+	return probability * share, true
+}
+
+// forget drops the windows of the idle endpoints, all windows if none is idle.
+func (s *sampler) forget(now time.Time) {
+	for k, w := range s.endpoints {
+		if now.Sub(w.start) > idleEndpoint {
+			delete(s.endpoints, k)
+		}
+	}
+	if len(s.endpoints) >= maxEndpoints {
+		s.endpoints = make(map[endpointKey]*window)
+	}
+}
+
+// set validates the policy and replaces the rules.
+func (s *sampler) set(policy Policy) error {
+	check := func(name string, r Rule) error {
+		if r.Probability != nil && (*r.Probability < 0 || *r.Probability > 1) {
+			return fmt.Errorf("probability %v of %s is not in [0, 1]", *r.Probability, name)
+		}
+		if r.Rate < 0 {
+			return fmt.Errorf("rate %v of %s is negative", r.Rate, name)
+		}
+		return nil
+	}
+	if err := check("the default rule", policy.Default); err != nil {
+		return err
+	}
+	for ns, r := range policy.Namespaces {
+		if err := check("namespace "+ns, r); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	s.policy = policy
+	s.endpoints = make(map[endpointKey]*window)
+	s.Unlock()
+	return nil
+}
+
+func (s *sampler) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var policy Policy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.set(policy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.Lock()
+	policy := s.policy
+	s.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(policy)
+}
@@ -0,0 +1,105 @@
+package headsampling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSample(t *testing.T) {
+	s := newSampler()
+	s.random = func() float64 { return 0.3 }
+	half, none := 0.5, 0.0
+	if err := s.set(Policy{
+		Namespaces: map[string]Rule{
+			"shop":        {Probability: &half},
+			"kube-system": {Probability: &none},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		namespace string
+		share     float64
+		keep      bool
+	}{
+		{"shop", 0.5, true},
+		{"kube-system", 0, false},
+		{"default", 1, true},
+		{"", 1, true},
+	} {
+		share, keep := s.Sample(c.namespace, "GET /")
+		if share != c.share || keep != c.keep {
+			t.Errorf("%s: got %v, %v, want %v, %v", c.namespace, share, keep, c.share, c.keep)
+		}
+	}
+	s.random = func() float64 { return 0.7 }
+	if _, keep := s.Sample("shop", "GET /"); keep {
+		t.Error("the requests above the probability are dropped")
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	s := newSampler()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	if err := s.set(Policy{Default: Rule{Rate: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	sample := func(n int, endpoint string) (kept int, share float64) {
+		for i := 0; i < n; i++ {
+			if sh, keep := s.Sample("shop", endpoint); keep {
+				kept++
+				share = sh
+			}
+		}
+		return
+	}
+	if kept, share := sample(8, "GET /orders"); kept != 2 || share != 1 {
+		t.Errorf("expected the first 2 requests of the second, got %d (%v)", kept, share)
+	}
+	if kept, _ := sample(3, "GET /users"); kept != 2 {
+		t.Errorf("the endpoints are limited apart, got %d", kept)
+	}
+	now = now.Add(time.Second)
+	// the kept requests stand for the 8 requests of the previous second
+	if kept, share := sample(8, "GET /orders"); kept != 2 || share != 0.25 {
+		t.Errorf("unexpected second window %d (%v)", kept, share)
+	}
+	now = now.Add(5 * time.Second)
+	if kept, share := sample(1, "GET /orders"); kept != 1 || share != 1 {
+		t.Errorf("an idle endpoint starts over, got %d (%v)", kept, share)
+	}
+}
+
+func TestSet(t *testing.T) {
+	over, negative := 1.5, -0.1
+	for _, policy := range []Policy{
+		{Default: Rule{Probability: &over}},
+		{Namespaces: map[string]Rule{"shop": {Probability: &negative}}},
+		{Namespaces: map[string]Rule{"shop": {Rate: -1}}},
+	} {
+		if err := newSampler().set(policy); err == nil {
+			t.Errorf("policy %+v should be rejected", policy)
+		}
+	}
+}
+
+func TestServeAdmin(t *testing.T) {
+	s := newSampler()
+	put := httptest.NewRecorder()
+	s.serveAdmin(put, httptest.NewRequest(http.MethodPut, adminPath, strings.NewReader(`{"namespaces": {"shop": {"rate": 100}}}`)))
+	if put.Code != http.StatusOK || !strings.Contains(put.Body.String(), `"shop":{"rate":100}`) {
+		t.Errorf("unexpected response %d %s", put.Code, put.Body.String())
+	}
+	invalid := httptest.NewRecorder()
+	s.serveAdmin(invalid, httptest.NewRequest(http.MethodPut, adminPath, strings.NewReader(`{"default": {"rate": -1}}`)))
+	if invalid.Code != http.StatusBadRequest {
+		t.Errorf("invalid rules should be rejected, got %d", invalid.Code)
+	}
+	if s.policy.Namespaces["shop"].Rate != 100 {
+		t.Errorf("invalid rules should not replace the rules, got %+v", s.policy)
+	}
+}
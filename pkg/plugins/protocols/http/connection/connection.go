@@ -0,0 +1,202 @@
+// Package connection measures the reuse of the http connections (keep-alive) between the pairs of pods.
+//
+// The requests captured at the client are counted by connection (its tuple). The connections whose tcp
+// handshake preceded their first request are new, the ones without requests for HTTP_CONNECTION_IDLE are
+// closed. The pairs of pods are reported by interval in application_http_connection:
+//
+//	tags     source_* / target_* of the pods, metric_source, _meta, _metric_scope, _metric_scope_id,
+//	         org_name, cluster_name
+//	fields   requests                  requests of the interval
+//	         connections               connections carrying them
+//	         new_connections           connections opened in the interval
+//	         closed_connections        connections closed in the interval
+//	         requests_per_connection   requests / connections
+//	         lifetime_mean, lifetime_max (ns)
+//	                                   first to last request of the closed connections, only of the ones
+//	                                   opened while the agent was running
+//
+// The services opening a connection per request have requests_per_connection and requests/new_connections
+// close to 1.
+package connection
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+const measurement = "application_http_connection"
+
+// pairTags are the tags of the pairs besides the source_* and target_* ones.
+var pairTags = []string{"metric_source", "_meta", "_metric_scope", "_metric_scope_id", "org_name", "cluster_name"}
+
+type Config struct {
+	// Idle is the time without requests after which a connection is closed.
+	Idle time.Duration `env:"HTTP_CONNECTION_IDLE" default:"2m"`
+}
+
+type Interface interface {
+	// Observe counts the request m of the converted output, only the requests captured at the client are counted.
+	Observe(m *ebpf.Metric, output *metric.Metric)
+	// Report returns the pairs of pods with requests or closed connections since the previous report.
+	Report(timestamp int64) []*metric.Metric
+}
+
+type pairKey struct {
+	source string
+	target string
+}
+
+type pair struct {
+	tags          map[string]string
+	requests      uint64
+	newConns      uint64
+	closedConns   uint64
+	lifetimeSum   int64
+	lifetimeCount int64
+	lifetimeMax   int64
+}
+
+type conn struct {
+	pair pairKey
+	// tags are the tags of the pair, for the intervals the connection closes without requests.
+	tags map[string]string
+	// opened is set when the handshake of the connection was seen, its lifetime is known then.
+	opened bool
+	first  int64
+	last   int64
+	// active is set when the connection carried requests since the previous report.
+	active bool
+}
+
+type tracker struct {
+	sync.Mutex
+	idle  time.Duration
+	conns map[string]*conn
+	pairs map[pairKey]*pair
+}
+
+func New() Interface {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	return newTracker(cfg.Idle)
+}
+
+func newTracker(idle time.Duration) *tracker {
+	return &tracker{
+		idle:  idle,
+		conns: make(map[string]*conn),
+		pairs: make(map[pairKey]*pair),
+	}
+}
+
+func (t *tracker) Observe(m *ebpf.Metric, output *metric.Metric) {
+	if !m.Client {
+		return
+	}
+	k := pairKey{source: output.Tags["source_service_instance_id"], target: output.Tags["target_service_instance_id"]}
+	if k.target == "" {
+		k.target = output.Tags["peer_address"]
+	}
+	id := fmt.Sprintf("%s:%d-%s:%d", m.SourceIP, m.SourcePort, m.DestIP, m.DestPort)
+	t.Lock()
+	defer t.Unlock()
+	p := t.pair(k, output.Tags)
+	p.requests++
+	c, ok := t.conns[id]
+	// a handshake starts a new connection on a reused tuple as well
+	if !ok || m.Phases.Connect > 0 {
+		if ok {
+			t.close(c)
+		}
+		c = &conn{pair: k, tags: p.tags, opened: m.Phases.Connect > 0, first: output.Timestamp}
+		t.conns[id] = c
+		if c.opened {
+			p.newConns++
+		}
+	}
+	c.last = output.Timestamp
+	c.active = true
+}
+
+// pair returns the pair of k, its tags are taken from the first request of the interval.
+func (t *tracker) pair(k pairKey, tags map[string]string) *pair {
+	if p, ok := t.pairs[k]; ok {
+		return p
+	}
+	p := &pair{tags: make(map[string]string)}
+	for name, value := range tags {
+		if strings.HasPrefix(name, "source_") || strings.HasPrefix(name, "target_") {
+			p.tags[name] = value
+		}
+	}
+	for _, name := range pairTags {
+		if value, ok := tags[name]; ok {
+			p.tags[name] = value
+		}
+	}
+	t.pairs[k] = p
+	return p
+}
+
+// close accounts the end of c in its pair.
+func (t *tracker) close(c *conn) {
+	p := t.pair(c.pair, c.tags)
+	p.closedConns++
+	if !c.opened {
+		return
+	}
+	lifetime := c.last - c.first
+	p.lifetimeSum += lifetime
+	p.lifetimeCount++
+	if lifetime > p.lifetimeMax {
+		p.lifetimeMax = lifetime
+	}
+}
+
+func (t *tracker) Report(timestamp int64) []*metric.Metric {
+	t.Lock()
+	defer t.Unlock()
+	active := make(map[pairKey]uint64)
+	for id, c := range t.conns {
+		if c.active {
+			active[c.pair]++
+			c.active = false
+		}
+		if timestamp-c.last > t.idle.Nanoseconds() {
+			t.close(c)
+			delete(t.conns, id)
+		}
+	}
+	ans := make([]*metric.Metric, 0, len(t.pairs))
+	for k, p := range t.pairs {
+		fields := map[string]interface{}{
+			"requests":           p.requests,
+			"connections":        active[k],
+			"new_connections":    p.newConns,
+			"closed_connections": p.closedConns,
+		}
+		if active[k] > 0 {
+			fields["requests_per_connection"] = float64(p.requests) / float64(active[k])
+		}
+		if p.lifetimeCount > 0 {
+			fields["lifetime_mean"] = p.lifetimeSum / p.lifetimeCount
+			fields["lifetime_max"] = p.lifetimeMax
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			OrgName:     p.tags["org_name"],
+			Tags:        p.tags,
+			Fields:      fields,
+		})
+	}
+	t.pairs = make(map[pairKey]*pair)
+	return ans
+}
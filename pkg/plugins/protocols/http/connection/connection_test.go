@@ -0,0 +1,76 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+func TestTracker(t *testing.T) {
+	tr := newTracker(time.Minute)
+	request := func(ts int64, port uint16, connect uint64) {
+		m := &ebpf.Metric{
+			SourceIP:   "10.0.0.2",
+			SourcePort: port,
+			DestIP:     "10.0.0.1",
+			DestPort:   8080,
+			Phases:     ebpf.Phases{Connect: connect},
+			Client:     true,
+		}
+		tr.Observe(m, &metric.Metric{
+			Timestamp: ts,
+			Tags: map[string]string{
+				"source_service_instance_id": "web-1",
+				"target_service_instance_id": "api-1",
+				"target_service_name":        "api",
+				"peer_address":               "10.0.0.1:8080",
+				"http_path":                  "/users",
+			},
+		})
+	}
+	second := time.Second.Nanoseconds()
+	// a kept-alive connection opened before the agent started
+	request(1*second, 40000, 0)
+	request(2*second, 40000, 0)
+	request(3*second, 40000, 0)
+	// a connection per request
+	request(1*second, 40001, 100)
+	request(2*second, 40002, 100)
+	// the server side of a request is not counted
+	tr.Observe(&ebpf.Metric{SourceIP: "10.0.0.2", SourcePort: 40000}, &metric.Metric{Tags: map[string]string{}})
+
+	report := tr.Report(10 * second)
+	if len(report) != 1 {
+		t.Fatalf("expected one pair, got %d", len(report))
+	}
+	m := report[0]
+	if m.Measurement != measurement || m.Tags["target_service_name"] != "api" || m.Tags["http_path"] != "" || m.Tags["peer_address"] != "" {
+		t.Errorf("unexpected tags %v", m.Tags)
+	}
+	if m.Fields["requests"] != uint64(5) || m.Fields["connections"] != uint64(3) || m.Fields["new_connections"] != uint64(2) ||
+		m.Fields["requests_per_connection"] != 5.0/3 || m.Fields["closed_connections"] != uint64(0) {
+		t.Errorf("unexpected fields %v", m.Fields)
+	}
+
+	// the idle connections close without requests, only the opened ones have a lifetime
+	request(70*second, 40001, 0)
+	report = tr.Report(75 * second)
+	if len(report) != 1 {
+		t.Fatalf("expected one pair, got %d", len(report))
+	}
+	m = report[0]
+	if m.Fields["requests"] != uint64(1) || m.Fields["connections"] != uint64(1) || m.Fields["closed_connections"] != uint64(2) ||
+		m.Fields["lifetime_max"] != int64(0) || m.Tags["target_service_name"] != "api" {
+		t.Errorf("unexpected fields %v", m.Fields)
+	}
+	report = tr.Report(200 * second)
+	if len(report) != 1 || report[0].Fields["closed_connections"] != uint64(1) || report[0].Fields["lifetime_max"] != 69*second ||
+		report[0].Fields["connections"] != uint64(0) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report := tr.Report(300 * second); len(report) != 0 {
+		t.Errorf("the pairs without connections should not be reported, got %d", len(report))
+	}
+}
@@ -1,8 +1,11 @@
 package ebpf
 
 import (
+	"bytes"
+	"encoding/binary"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -18,6 +21,14 @@ func decodeMetrics(connTuple *ConnTuple, data *HttpPackage) (*Metric, error) {
 		Headers:    make(map[string]string),
 		StatusCode: data.StatusCode,
 		Duration:   data.Duration,
+		Phases:     decodePhases(data),
+
+		RequestTimestamp:     data.RequestTimestamp,
+		ResponseTimestamp:    data.ResponseTimestamp,
+		ResponseEndTimestamp: data.ResponseEndTimestamp,
+		SocketCookie:         data.Cookie,
+		Client:               data.Side == HttpSideClient,
+		ResponseBytes:        data.ResponseBytes,
 	}
 
 	switch len(fragItems) {
@@ -28,6 +39,7 @@ func decodeMetrics(connTuple *ConnTuple, data *HttpPackage) (*Metric, error) {
 			return nil, err
 		}
 		metric.Path = parsedURL.Path
+		metric.RawQuery = parsedURL.RawQuery
 	default:
 		parts := strings.Split(fragItems[0], " ")
 		parsedURL, err := url.Parse(parts[0])
@@ -35,6 +47,7 @@ func decodeMetrics(connTuple *ConnTuple, data *HttpPackage) (*Metric, error) {
 			return nil, err
 		}
 		metric.Path = parsedURL.Path
+		metric.RawQuery = parsedURL.RawQuery
 
 		// try parse http version
 		if len(parts) >= 2 {
@@ -42,7 +55,12 @@ func decodeMetrics(connTuple *ConnTuple, data *HttpPackage) (*Metric, error) {
 		}
 
 		if len(fragItems) > 1 {
-			for _, header := range fragItems[1:] {
+			headers := fragItems[1:]
+			// a full fragment cuts off its last header line, its value would be wrong.
+			if data.RequestFragment[HttpPayloadSize-1] != 0 {
+				headers = headers[:len(headers)-1]
+			}
+			for _, header := range headers {
 				parts := strings.Split(header, ": ")
 				if len(parts) == 2 {
 					metric.Headers[parts[0]] = parts[1]
@@ -54,3 +72,121 @@ func decodeMetrics(connTuple *ConnTuple, data *HttpPackage) (*Metric, error) {
 
 	return &metric, nil
 }
+
+func decodePhases(data *HttpPackage) Phases {
+	p := Phases{Connect: data.ConnectDuration}
+	// the timestamps are in the order of the packets, a missing one leaves its phases out.
+	if data.RequestEndTimestamp >= data.RequestTimestamp {
+		p.RequestWrite = data.RequestEndTimestamp - data.RequestTimestamp
+	}
+	if data.ResponseTimestamp >= data.RequestEndTimestamp {
+		p.Server = data.ResponseTimestamp - data.RequestEndTimestamp
+	}
+	if data.ResponseEndTimestamp >= data.ResponseTimestamp {
+		p.ResponseRead = data.ResponseEndTimestamp - data.ResponseTimestamp
+	}
+	return p
+}
+
+// decodeBody returns the start of the body of a request, the body follows the headers in the first
+// packet or is sent apart in the next segment. The end of a packet without headers is the end of a
+// larger body, it is returned as well.
+func decodeBody(b *HttpBody) []byte {
+	packet := bytes.TrimRight(b.Packet[:], "\x00")
+	if i := bytes.Index(packet, []byte("\r\n\r\n")); i >= 0 {
+		packet = packet[i+4:]
+	}
+	if len(packet) == 0 && b.HasSegment == 1 {
+		return bytes.TrimRight(b.Segment[:], "\x00")
+	}
+	return packet
+}
+
+// decodeResponseBody returns the end of the first packet of a response, without the headers if they are
+// captured. The body of a chunked response keeps its chunk sizes.
+func decodeResponseBody(b *HttpBody) []byte {
+	if b.HasResponse != 1 {
+		return nil
+	}
+	packet := bytes.TrimRight(b.Response[:], "\x00")
+	if i := bytes.Index(packet, []byte("\r\n\r\n")); i >= 0 && i+4 < len(packet) {
+		packet = packet[i+4:]
+	}
+	return packet
+}
+
+// decodeHeaders returns the allowlisted headers of a head, the head is cut off at HttpHeadersSize and its
+// last header line is dropped if it is truncated.
+func decodeHeaders(head []byte, allow map[string]bool) map[string]string {
+	head = bytes.TrimRight(head, "\x00")
+	complete := false
+	if i := bytes.Index(head, []byte("\r\n\r\n")); i >= 0 {
+		head, complete = head[:i], true
+	}
+	lines := strings.Split(string(head), "\r\n")
+	if !complete && len(head) == HttpHeadersSize {
+		lines = lines[:len(lines)-1]
+	}
+	var headers map[string]string
+	for _, line := range lines[min(1, len(lines)):] {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !allow[name] {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) > HeaderValueSize {
+			value = value[:HeaderValueSize]
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[name] = value
+	}
+	return headers
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// decodeProxy returns the source address of a PROXY protocol header, v1 (text) or v2 (binary).
+// The headers of the LOCAL command and of the UNKNOWN protocol carry no address.
+func decodeProxy(header []byte) (string, uint16, bool) {
+	if bytes.HasPrefix(header, []byte("PROXY ")) {
+		line, _, ok := bytes.Cut(header, []byte("\r\n"))
+		if !ok {
+			return "", 0, false
+		}
+		// PROXY TCP4 192.0.2.1 198.51.100.1 56324 443
+		fields := strings.Fields(string(line))
+		if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+			return "", 0, false
+		}
+		ip := net.ParseIP(fields[2])
+		port, err := strconv.ParseUint(fields[4], 10, 16)
+		if ip == nil || err != nil {
+			return "", 0, false
+		}
+		return ip.String(), uint16(port), true
+	}
+	if !bytes.HasPrefix(header, proxyV2Signature) || len(header) < 16 {
+		return "", 0, false
+	}
+	// version 2, command PROXY
+	if header[12] != 0x21 {
+		return "", 0, false
+	}
+	addrs := header[16:]
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(addrs) < 12 {
+			return "", 0, false
+		}
+		return net.IP(addrs[0:4]).String(), binary.BigEndian.Uint16(addrs[8:10]), true
+	case 0x21: // TCP over IPv6
+		if len(addrs) < 36 {
+			return "", 0, false
+		}
+		return net.IP(addrs[0:16]).String(), binary.BigEndian.Uint16(addrs[32:34]), true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,145 @@
+package ebpf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodePhases(t *testing.T) {
+	cases := []struct {
+		name string
+		data HttpPackage
+		want Phases
+	}{
+		{
+			name: "new connection",
+			data: HttpPackage{
+				RequestTimestamp:     1000,
+				ConnectDuration:      300,
+				RequestEndTimestamp:  1400,
+				ResponseTimestamp:    3400,
+				ResponseEndTimestamp: 3900,
+			},
+			want: Phases{Connect: 300, RequestWrite: 400, Server: 2000, ResponseRead: 500},
+		},
+		{
+			name: "single packets",
+			data: HttpPackage{
+				RequestTimestamp:     1000,
+				RequestEndTimestamp:  1000,
+				ResponseTimestamp:    1800,
+				ResponseEndTimestamp: 1800,
+			},
+			want: Phases{Server: 800},
+		},
+		{
+			// a request segment retransmitted after the response started
+			name: "out of order",
+			data: HttpPackage{
+				RequestTimestamp:     1000,
+				RequestEndTimestamp:  2500,
+				ResponseTimestamp:    2000,
+				ResponseEndTimestamp: 2600,
+			},
+			want: Phases{RequestWrite: 1500, ResponseRead: 600},
+		},
+	}
+	for _, c := range cases {
+		if got := decodePhases(&c.data); got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDecodeHeaders(t *testing.T) {
+	allow := map[string]bool{"x-request-id": true, "content-type": true, "authorization": false}
+	head := "/api/users HTTP/1.1\r\nHost: svc\r\nX-Request-ID: abc-123\r\nAuthorization: Bearer x\r\ncontent-type : application/json\r\n\r\nbody"
+	got := decodeHeaders([]byte(head), allow)
+	if len(got) != 2 || got["x-request-id"] != "abc-123" || got["content-type"] != "application/json" {
+		t.Errorf("unexpected headers %v", got)
+	}
+
+	// the last line of a head cut off at the capture size is dropped
+	var b [HttpHeadersSize]byte
+	prefix := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nX-Request-ID: "
+	n := copy(b[:], prefix)
+	for i := n; i < len(b); i++ {
+		b[i] = 'a'
+	}
+	got = decodeHeaders(b[:], allow)
+	if _, ok := got["x-request-id"]; ok || got["content-type"] != "text/plain" {
+		t.Errorf("unexpected headers of a truncated head %v", got)
+	}
+
+	long := "/ HTTP/1.1\r\nX-Request-ID: " + strings.Repeat("b", 300) + "\r\n\r\n"
+	if got := decodeHeaders([]byte(long), allow); len(got["x-request-id"]) != HeaderValueSize {
+		t.Errorf("the values should be bounded, got %d bytes", len(got["x-request-id"]))
+	}
+}
+
+func TestDecodeSide(t *testing.T) {
+	key := ConnTuple{SourceIP: [4]byte{10, 0, 0, 2}, DestIP: [4]byte{10, 0, 0, 1}, SourcePort: 40000, DestPort: 8080}
+	data := HttpPackage{Method: HttpGet, StatusCode: 200}
+	copy(data.RequestFragment[:], "/api HTTP/1.1\r\nHost: svc\r\n")
+	m, err := decodeMetrics(&key, &data)
+	if err != nil || !m.Client || m.Path != "/api" || m.SourceIP != "10.0.0.2" {
+		t.Fatalf("unexpected client request %+v %v", m, err)
+	}
+	// the server veth records the same tuple, the client stays the source
+	data.Side = HttpSideServer
+	if m, err := decodeMetrics(&key, &data); err != nil || m.Client || m.SourceIP != "10.0.0.2" {
+		t.Errorf("unexpected server request %+v %v", m, err)
+	}
+}
+
+func TestSettled(t *testing.T) {
+	now := uint64(20e9)
+	cases := []struct {
+		name string
+		data HttpPackage
+		want bool
+	}{
+		{"read", HttpPackage{ResponseEndTimestamp: now - responseSettle}, true},
+		{"reading", HttpPackage{ResponseEndTimestamp: now - 1}, false},
+		// the chunks of a stream may be seconds apart
+		{"chunked", HttpPackage{ResponseEndTimestamp: now - responseSettle, Chunked: 1}, false},
+		{"last chunk", HttpPackage{ResponseEndTimestamp: now - 1, Chunked: 1, ResponseComplete: 1}, true},
+		{"last chunk lost", HttpPackage{ResponseEndTimestamp: now - chunkedSettle, Chunked: 1}, true},
+	}
+	for _, c := range cases {
+		if got := settled(&c.data, now); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDecodeProxy(t *testing.T) {
+	v2 := func(cmd, family byte, addrs []byte) []byte {
+		h := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), cmd, family, 0, byte(len(addrs)))
+		return append(h, addrs...)
+	}
+	v6 := make([]byte, 36)
+	v6[0], v6[1], v6[15] = 0x20, 0x01, 0x01
+	v6[32], v6[33] = 0x01, 0xbb
+	for _, c := range []struct {
+		name   string
+		header []byte
+		ip     string
+		port   uint16
+		ok     bool
+	}{
+		{"v1", []byte("PROXY TCP4 10.0.3.7 10.0.1.2 56324 443\r\nGET / HTTP/1.1\r\n"), "10.0.3.7", 56324, true},
+		{"v1 ipv6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "2001:db8::1", 56324, true},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", 0, false},
+		{"v1 truncated", []byte("PROXY TCP4 10.0.3.7 10.0"), "", 0, false},
+		{"v2", v2(0x21, 0x11, []byte{10, 0, 3, 7, 10, 0, 1, 2, 0xdc, 0x04, 0x01, 0xbb}), "10.0.3.7", 56324, true},
+		{"v2 ipv6", v2(0x21, 0x21, v6), "2001::1", 443, true},
+		{"v2 local", v2(0x20, 0x00, nil), "", 0, false},
+		{"not a header", []byte("GET / HTTP/1.1\r\n"), "", 0, false},
+	} {
+		ip, port, ok := decodeProxy(c.header)
+		if ip != c.ip || port != c.port || ok != c.ok {
+			t.Errorf("%s: got %s %d %v", c.name, ip, port, ok)
+		}
+	}
+}
@@ -1,24 +1,32 @@
 package ebpf
 
 import (
-	"bytes"
-	"fmt"
-	"os"
-	"runtime/debug"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/cilium/ebpf"
 	"k8s.io/klog/v2"
 
-	"github.com/erda-project/ebpf-agent/pkg/utils"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
 )
 
 const (
-	programPath = "target/http.bpf.o"
-	programName = "socket__filter_package"
-	mapFilter   = "filter_map"
-	mapMetric   = "metrics_map"
+	programPath      = "target/http.bpf.o"
+	programName      = "socket__filter_package"
+	mapMetric        = "metrics_map"
+	mapBody          = "http_body_map"
+	mapHeaders       = "http_headers_map"
+	mapHeadersConfig = "http_headers_config_map"
+	mapProxy         = "http_proxy_map"
+
+	// responseSettle is the time without new segments after which a response is complete,
+	// the phases of longer pauses within a response end at the pause.
+	responseSettle = uint64(200 * time.Millisecond)
+	// chunkedSettle replaces responseSettle for the chunked responses, they are complete with their last
+	// chunk and may stream their chunks apart.
+	chunkedSettle = uint64(10 * time.Second)
 )
 
 type Interface interface {
@@ -27,102 +35,115 @@ type Interface interface {
 }
 
 type provider struct {
-	ifIndex   int
-	ipAddress string
-	ch        chan Metric
+	link attach.Link
+	ch   chan Metric
+	// headers are the lower case names of the allowlisted headers.
+	headers map[string]bool
 
-	collection *ebpf.Collection
-	fd         int
-	sock       int
-	log        klog.Logger
+	filter *socketfilter.Filter
 }
 
 const (
-	SO_ATTACH_BPF = 0x32                     // 50
-	SO_DETACH_BPF = syscall.SO_DETACH_FILTER // 27
-	ProtocolICMP  = 1                        // Internet Control Message
+	SO_ATTACH_BPF = socketfilter.SO_ATTACH_BPF
+	SO_DETACH_BPF = socketfilter.SO_DETACH_BPF
+	ProtocolICMP  = 1 // Internet Control Message
 )
 
-func New(ifIndex int, ip string, ch chan Metric) Interface {
-	return &provider{
-		ifIndex:   ifIndex,
-		ipAddress: ip,
-		ch:        ch,
+// New attaches the program to the veth of the pod, the heads of the requests are only captured for the
+// headers of the allowlist.
+func New(l attach.Link, ch chan Metric, headers []string) Interface {
+	p := &provider{
+		link:    l,
+		ch:      ch,
+		headers: make(map[string]bool),
 	}
+	for _, h := range headers {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			p.headers[h] = true
+		}
+	}
+	return p
 }
 
 func (e *provider) Load() error {
-	programBytes, err := os.ReadFile(programPath)
-	if err != nil {
-		return err
-	}
-
-	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
-	if err != nil {
-		return err
-	}
-	e.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
-	if err != nil {
-		return err
-	}
-
-	program := e.collection.DetachProgram(programName)
-	if program == nil {
-		return fmt.Errorf("detach program %s failed", programName)
-	}
-
-	e.fd = program.FD()
-
-	e.sock, err = utils.OpenRawSock(e.ifIndex)
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		if err := exclusion.Apply(c, "http"); err != nil {
+			return err
+		}
+		if len(e.headers) > 0 {
+			return c.Maps[mapHeadersConfig].Put(uint32(0), uint32(1))
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-
-	if err := syscall.SetsockoptInt(e.sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, program.FD()); err != nil {
-		return err
-	}
-
-	if err := e.collection.DetachMap(mapFilter).Put(
-		utils.Htonl(utils.IP4toDec(e.ipAddress)), uint32(0),
-	); err != nil {
-		return err
-	}
-	m := e.collection.DetachMap(mapMetric)
-	go e.FanInMetric(m)
+	c := e.filter.Collection
+	m, bodies, heads, proxies := c.DetachMap(mapMetric), c.DetachMap(mapBody), c.DetachMap(mapHeaders), c.DetachMap(mapProxy)
+	go e.filter.Loop(func(now uint64) {
+		e.read(now, m, bodies, heads, proxies)
+	})
 	return nil
 }
 
-func (e *provider) FanInMetric(m *ebpf.Map) {
-	defer func() {
-		if err := recover(); err != nil {
-			klog.Errorf("panic: %v", err)
-			klog.Errorf("stack: %s", string(debug.Stack()))
-		}
-	}()
-
+// read passes the requests with a complete response at now (bpf_ktime_get_ns) to the channel, the others
+// are taken on a later read.
+func (e *provider) read(now uint64, m, bodies, heads, proxies *ebpf.Map) {
 	var (
-		key ConnTuple
-		val HttpPackage
+		key     ConnTuple
+		val     HttpPackage
+		body    HttpBody
+		headers HttpHeaders
+		proxy   HttpProxy
 	)
-	for {
-		for m.Iterate().Next(&key, &val) {
-			metric, err := decodeMetrics(&key, &val)
-			if err != nil {
-				klog.Errorf("decode metrics error: %v", err)
-			}
-			e.ch <- *metric
-			// clean map
-			if err := m.Delete(key); err != nil {
-				klog.Errorf("delete map error: %v", err)
-				continue
+	for m.Iterate().Next(&key, &val) {
+		// the response may still be read, it is taken on the next pass.
+		if now > 0 && !settled(&val, now) {
+			continue
+		}
+		metric, err := decodeMetrics(&key, &val)
+		if err != nil {
+			klog.Errorf("decode metrics error: %v", err)
+		}
+		// the bodies of POST requests are keyed like their metrics.
+		if val.Method == HttpPost && bodies.Lookup(key, &body) == nil {
+			metric.Body = decodeBody(&body)
+			metric.ResponseBody = decodeResponseBody(&body)
+			_ = bodies.Delete(key)
+		}
+		if len(e.headers) > 0 && heads.Lookup(key, &headers) == nil {
+			metric.RequestHeaders = decodeHeaders(headers.Request[:], e.headers)
+			if headers.HasResponse == 1 {
+				metric.ResponseHeaders = decodeHeaders(headers.Response[:], e.headers)
 			}
+			_ = heads.Delete(key)
+		}
+		// the PROXY header is kept for the following requests of the connection.
+		if proxies.Lookup(key, &proxy) == nil {
+			metric.ProxySourceIP, metric.ProxySourcePort, _ = decodeProxy(proxy.Header[:])
+		}
+		e.ch <- *metric
+		// clean map
+		if err := m.Delete(key); err != nil {
+			klog.Errorf("delete map error: %v", err)
+			continue
 		}
-		time.Sleep(1 * time.Second)
 	}
 }
 
+// settled reports whether the response of data is read at now (bpf_ktime_get_ns).
+func settled(data *HttpPackage, now uint64) bool {
+	if data.ResponseComplete == 1 {
+		return true
+	}
+	settle := responseSettle
+	if data.Chunked == 1 {
+		settle = chunkedSettle
+	}
+	return data.ResponseEndTimestamp+settle <= now
+}
+
 func (e *provider) Close() error {
-	_ = syscall.SetsockoptInt(e.sock, syscall.SOL_SOCKET, SO_DETACH_BPF, e.fd)
-	e.collection.Close()
-	return nil
+	return e.filter.Close()
 }
@@ -8,6 +8,11 @@ import (
 
 const (
 	HttpPayloadSize = 224
+	HttpBodySize    = 256
+	HttpHeadersSize = 512
+	// HeaderValueSize bounds the values of the captured headers.
+	HeaderValueSize = 128
+	HttpProxySize   = 112
 )
 
 type HttpMethod uint8
@@ -44,12 +49,59 @@ func (m HttpMethod) Unknown() string {
 	return "Unknown HttpMethod"
 }
 
+type HttpSide uint8
+
+const (
+	HttpSideClient HttpSide = iota
+	HttpSideServer
+)
+
 type HttpPackage struct {
 	RequestTimestamp uint64
 	Duration         uint64
 	StatusCode       uint16
 	Method           HttpMethod
 	RequestFragment  [HttpPayloadSize]byte
+	ConnectDuration  uint64
+	// RequestEndTimestamp is the last segment of the request, ResponseTimestamp and
+	// ResponseEndTimestamp the first and the last packet of the response.
+	RequestEndTimestamp  uint64
+	ResponseTimestamp    uint64
+	ResponseEndTimestamp uint64
+	// Cookie is the socket cookie of the client sending the request, 0 if it is not captured.
+	Cookie uint64
+	// Side is the end of the request the pod of the veth is, see http_side_t.
+	Side HttpSide
+	// ResponseBytes is the payload of the response packets, the head included.
+	ResponseBytes uint32
+	// Chunked is 1 for the chunked responses, ResponseComplete once their last chunk is seen.
+	Chunked          uint8
+	ResponseComplete uint8
+}
+
+// HttpBody is the start of the body of a POST request and the end of the first packet of its response,
+// see http_body_t.
+type HttpBody struct {
+	// Packet is the end of the first packet of the request, the headers precede the body if it is shorter.
+	Packet [HttpBodySize]byte
+	// Segment is the start of the next segment of the request.
+	Segment    [HttpBodySize]byte
+	HasSegment uint8
+	// Response is the end of the first packet of the response, the headers precede the body if it is shorter.
+	Response    [HttpBodySize]byte
+	HasResponse uint8
+}
+
+// HttpHeaders are the heads of a request and of its response, see http_headers_t.
+type HttpHeaders struct {
+	Request     [HttpHeadersSize]byte
+	Response    [HttpHeadersSize]byte
+	HasResponse uint8
+}
+
+// HttpProxy is the PROXY protocol header starting a connection, see http_proxy_t.
+type HttpProxy struct {
+	Header [HttpProxySize]byte
 }
 
 type ConnTuple struct {
@@ -66,10 +118,49 @@ type Metric struct {
 	DestPort   uint16
 	Method     string
 	Path       string
-	Version    string
-	Headers    map[string]string
-	StatusCode uint16
-	Duration   uint64
+	// RawQuery is the query of the request line as far as it is captured.
+	RawQuery string
+	Version  string
+	Headers  map[string]string
+	// RequestHeaders and ResponseHeaders are the allowlisted headers, keyed by their lower case names.
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
+	StatusCode      uint16
+	Duration        uint64
+	Phases          Phases
+	// Body is the start of the body of POST requests.
+	Body []byte
+	// ResponseBody is the end of the first packet of the responses to POST requests, the body of small responses.
+	ResponseBody []byte
+	// RequestTimestamp and ResponseTimestamp are the first packets of the request and of the
+	// response (bpf_ktime_get_ns), the window of the server. ResponseEndTimestamp is the last packet
+	// of the response, the capture of the request.
+	RequestTimestamp     uint64
+	ResponseTimestamp    uint64
+	ResponseEndTimestamp uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+	// Client is set when the request was captured at the client, otherwise it was captured at the server.
+	Client bool
+	// ResponseBytes is the size of the response with its head, up to its last chunk for the chunked responses.
+	ResponseBytes uint32
+	// ProxySourceIP and ProxySourcePort are the client of the PROXY protocol header of the connection, the
+	// source is then the L4 load balancer. Empty if the connection has no header.
+	ProxySourceIP   string
+	ProxySourcePort uint16
+}
+
+// Phases splits the latency of a request as seen by the pod capturing it, all durations are in nanoseconds.
+// Duration is RequestWrite + Server, the time to the first byte of the response.
+type Phases struct {
+	// Connect is the tcp handshake, it is only set for the first request of a connection captured at the client.
+	Connect uint64
+	// RequestWrite is the time from the first to the last segment of the request.
+	RequestWrite uint64
+	// Server is the time from the last segment of the request to the first packet of the response.
+	Server uint64
+	// ResponseRead is the time from the first to the last packet of the response.
+	ResponseRead uint64
 }
 
 func (m *Metric) String() string {
@@ -0,0 +1,107 @@
+// Package elasticsearch recognizes Elasticsearch REST requests among the HTTP requests,
+// see https://www.elastic.co/guide/en/elasticsearch/reference/current/rest-apis.html
+//
+// Only the document and search endpoints are classified, cluster and index management
+// requests (_cat, _cluster, _mapping ...) stay plain HTTP requests.
+package elasticsearch
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+// Request is a classified Elasticsearch request.
+type Request struct {
+	// Index is the target index pattern, comma separated for multiple targets and empty for
+	// requests without a target like GET /_search. Dates and other digit runs are replaced by '*'.
+	Index string
+	// Operation is the document or search operation, e.g. search, bulk, index or get.
+	Operation string
+}
+
+// endpoints are the operations of the path segments starting with '_'.
+var endpoints = map[string]string{
+	"_search":           "search",
+	"_msearch":          "msearch",
+	"_count":            "count",
+	"_bulk":             "bulk",
+	"_mget":             "mget",
+	"_create":           "create",
+	"_update":           "update",
+	"_delete_by_query":  "delete_by_query",
+	"_update_by_query":  "update_by_query",
+	"_explain":          "explain",
+	"_validate":         "validate",
+	"_field_caps":       "field_caps",
+	"_termvectors":      "termvectors",
+	"_mtermvectors":     "mtermvectors",
+	"_async_search":     "async_search",
+	"_pit":              "pit",
+	"_knn_search":       "knn_search",
+	"_terms_enum":       "terms_enum",
+	"_rank_eval":        "rank_eval",
+	"_search_shards":    "search_shards",
+	"_reindex":          "reindex",
+	"_sql":              "sql",
+	"_eql":              "eql",
+	"_doc":              "",
+	"_source":           "get_source",
+	"_search_template":  "search_template",
+	"_msearch_template": "msearch_template",
+}
+
+// docOperations are the operations of /<index>/_doc requests by method.
+var docOperations = map[string]string{
+	http.MethodGet:    "get",
+	http.MethodHead:   "exists",
+	http.MethodPut:    "index",
+	http.MethodPost:   "index",
+	http.MethodDelete: "delete",
+}
+
+// Classify returns the Elasticsearch request of an HTTP request, if the path is one of the classified endpoints.
+func Classify(method, path string) (Request, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) == 0 || segments[0] == "" {
+		return Request{}, false
+	}
+	var index string
+	if !strings.HasPrefix(segments[0], "_") {
+		index = segments[0]
+		segments = segments[1:]
+	}
+	if len(segments) == 0 {
+		return Request{}, false
+	}
+	operation, ok := endpoints[segments[0]]
+	if !ok {
+		return Request{}, false
+	}
+	switch segments[0] {
+	case "_doc":
+		// /<index>/_doc/<id> or POST /<index>/_doc
+		if index == "" {
+			return Request{}, false
+		}
+		if operation, ok = docOperations[method]; !ok {
+			return Request{}, false
+		}
+	case "_search":
+		if len(segments) > 1 && segments[1] == "scroll" {
+			operation = "scroll"
+		} else if len(segments) > 1 && segments[1] == "template" {
+			operation = "search_template"
+		}
+	case "_create", "_update", "_source", "_mget", "_explain", "_termvectors":
+		// document endpoints need a target index
+		if index == "" && segments[0] != "_mget" {
+			return Request{}, false
+		}
+	}
+	if index != "" {
+		index = dbstatement.KeyPattern(index)
+	}
+	return Request{Index: index, Operation: operation}, true
+}
@@ -0,0 +1,31 @@
+package elasticsearch
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		method, path string
+		want         Request
+		ok           bool
+	}{
+		{"POST", "/logs-2023.10.13/_search", Request{Index: "logs-*.*.*", Operation: "search"}, true},
+		{"GET", "/_search/scroll", Request{Operation: "scroll"}, true},
+		{"POST", "/_bulk", Request{Operation: "bulk"}, true},
+		{"PUT", "/orders/_doc/42", Request{Index: "orders", Operation: "index"}, true},
+		{"GET", "/orders/_doc/42", Request{Index: "orders", Operation: "get"}, true},
+		{"DELETE", "/orders/_doc/42", Request{Index: "orders", Operation: "delete"}, true},
+		{"POST", "/orders,users/_count", Request{Index: "orders,users", Operation: "count"}, true},
+		{"POST", "/orders/_update/42", Request{Index: "orders", Operation: "update"}, true},
+		{"GET", "/_cat/indices", Request{}, false},
+		{"GET", "/orders", Request{}, false},
+		{"GET", "/api/v1/users", Request{}, false},
+		{"GET", "/_doc/1", Request{}, false},
+		{"GET", "/", Request{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Classify(c.method, c.path)
+		if ok != c.ok || got != c.want {
+			t.Errorf("Classify(%s %s) = %+v, %v, want %+v, %v", c.method, c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
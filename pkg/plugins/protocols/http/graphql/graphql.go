@@ -0,0 +1,220 @@
+// Package graphql extracts the GraphQL operation of HTTP requests, see
+// https://graphql.org/learn/serving-over-http/
+//
+// POST requests carry the operation in a JSON body, only its start is captured by the kernel
+// so the body is scanned rather than decoded and may end anywhere. GET requests carry it in
+// the query and operationName parameters.
+package graphql
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+)
+
+// Operation is the GraphQL operation of a request.
+type Operation struct {
+	// Name is the operation name, empty for anonymous operations.
+	Name string
+	// Type is query, mutation or subscription, empty for persisted queries sent without a document.
+	Type string
+}
+
+const (
+	TypeQuery        = "query"
+	TypeMutation     = "mutation"
+	TypeSubscription = "subscription"
+)
+
+// Parse returns the GraphQL operation of an HTTP request, if the request carries a GraphQL document
+// or names a persisted query.
+func Parse(method, rawQuery string, body []byte) (Operation, bool) {
+	switch method {
+	case http.MethodGet:
+		params, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return Operation{}, false
+		}
+		return operation(params.Get("operationName"), []byte(params.Get("query")),
+			params.Get("extensions") != "" && params.Has("operationName"))
+	case http.MethodPost:
+		body = bytes.TrimLeft(body, " \t\r\n")
+		// batched requests are reported by their first operation.
+		body = bytes.TrimLeft(bytes.TrimPrefix(body, []byte("[")), " \t\r\n")
+		if len(body) == 0 || body[0] != '{' {
+			return Operation{}, false
+		}
+		name, named := stringField(body, "operationName")
+		document, _ := stringField(body, "query")
+		return operation(name, []byte(document), named && bytes.Contains(body, []byte(`"persistedQuery"`)))
+	}
+	return Operation{}, false
+}
+
+// operation picks the operation of a document, the named one if the document has several.
+// persisted tells whether a request without document refers to a persisted query.
+func operation(name string, document []byte, persisted bool) (Operation, bool) {
+	operations := parseDocument(document)
+	if len(operations) == 0 {
+		if persisted && isName(name) {
+			return Operation{Name: name}, true
+		}
+		return Operation{}, false
+	}
+	for _, op := range operations {
+		if name != "" && op.Name == name {
+			return op, true
+		}
+	}
+	op := operations[0]
+	if op.Name == "" && isName(name) {
+		op.Name = name
+	}
+	return op, true
+}
+
+// stringField returns the string value of the first member with the given key, the value is
+// unescaped and may be cut by the end of the body. Null and other values are not returned.
+func stringField(body []byte, key string) (string, bool) {
+	i := bytes.Index(body, []byte(`"`+key+`"`))
+	if i < 0 {
+		return "", false
+	}
+	rest := bytes.TrimLeft(body[i+len(key)+2:], " \t\r\n")
+	if len(rest) == 0 || rest[0] != ':' {
+		return "", false
+	}
+	rest = bytes.TrimLeft(rest[1:], " \t\r\n")
+	if len(rest) == 0 || rest[0] != '"' {
+		return "", false
+	}
+	value := make([]byte, 0, len(rest))
+	for j := 1; j < len(rest); j++ {
+		switch c := rest[j]; c {
+		case '"':
+			return string(value), true
+		case '\\':
+			j++
+			if j == len(rest) {
+				return string(value), false
+			}
+			switch rest[j] {
+			case 'n':
+				// line breaks end comments.
+				value = append(value, '\n')
+			case 't', 'r', 'f', 'b':
+				value = append(value, ' ')
+			case 'u':
+				// escaped characters are never part of names or keywords.
+				j += 4
+				value = append(value, ' ')
+			default:
+				value = append(value, rest[j])
+			}
+		default:
+			value = append(value, c)
+		}
+	}
+	return string(value), false
+}
+
+// parseDocument returns the operations defined at the top level of a document, as far as the document
+// is captured. Fragment definitions are skipped, a document of other text has no operations.
+func parseDocument(document []byte) []Operation {
+	var operations []Operation
+	for s := (scanner{src: document}); ; {
+		s.skipIgnored()
+		if s.done() {
+			return operations
+		}
+		if s.peek() == '{' {
+			operations = append(operations, Operation{Type: TypeQuery})
+			s.skipBlock()
+			continue
+		}
+		switch keyword := s.name(); keyword {
+		case TypeQuery, TypeMutation, TypeSubscription:
+			s.skipIgnored()
+			operations = append(operations, Operation{Name: s.name(), Type: keyword})
+			s.skipBlock()
+		case "fragment":
+			s.skipBlock()
+		default:
+			return operations
+		}
+	}
+}
+
+// scanner is a minimal GraphQL lexer, it only knows the tokens around definitions.
+type scanner struct {
+	src []byte
+	pos int
+}
+
+func (s *scanner) done() bool { return s.pos >= len(s.src) }
+
+func (s *scanner) peek() byte { return s.src[s.pos] }
+
+// skipIgnored skips white space, commas and comments.
+func (s *scanner) skipIgnored() {
+	for !s.done() {
+		switch s.peek() {
+		case ' ', '\t', '\r', '\n', ',':
+			s.pos++
+		case '#':
+			for !s.done() && s.peek() != '\n' && s.peek() != '\r' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// name reads a name token, it returns an empty string if there is none.
+func (s *scanner) name() string {
+	start := s.pos
+	for !s.done() && isNameByte(s.peek(), s.pos == start) {
+		s.pos++
+	}
+	return string(s.src[start:s.pos])
+}
+
+// skipBlock skips to the end of the next selection set, strings may contain braces.
+func (s *scanner) skipBlock() {
+	depth := 0
+	for ; !s.done(); s.pos++ {
+		switch s.peek() {
+		case '"':
+			for s.pos++; !s.done() && s.peek() != '"'; s.pos++ {
+				if s.peek() == '\\' {
+					s.pos++
+				}
+			}
+		case '#':
+			s.skipIgnored()
+			s.pos--
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				s.pos++
+				return
+			}
+		}
+	}
+}
+
+func isName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isNameByte(s[i], i == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
@@ -0,0 +1,39 @@
+package graphql
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		method, query, body string
+		want                Operation
+		ok                  bool
+	}{
+		{"POST", "", `{"query":"query GetUser($id: ID!) {\n  user(id: $id) { name }\n}","variables":{"id":"1"}}`,
+			Operation{Name: "GetUser", Type: "query"}, true},
+		{"POST", "", `{"operationName":"AddOrder","query":"mutation AddOrder { addOrder(input: {sku: \"a{\"}) { id } }"}`,
+			Operation{Name: "AddOrder", Type: "mutation"}, true},
+		{"POST", "", `{"query":"{ viewer { login } }"}`, Operation{Type: "query"}, true},
+		{"POST", "", `{"operationName":"Feed","query":"# feed\nfragment F on Post { id }\nquery Feed { posts { ...F } }"}`,
+			Operation{Name: "Feed", Type: "query"}, true},
+		{"POST", "", `{"operationName":"B","query":"query A { a } mutation B { b }"}`, Operation{Name: "B", Type: "mutation"}, true},
+		{"POST", "", `[{"query":"subscription OnEvent { event { id } }"},{"query":"query Other { x }"}]`,
+			Operation{Name: "OnEvent", Type: "subscription"}, true},
+		// the captured body ends inside the document.
+		{"POST", "", `{"query":"query Search($q: String) { search(q: $q) { tot`, Operation{Name: "Search", Type: "query"}, true},
+		{"POST", "", `{"operationName":"Hero","extensions":{"persistedQuery":{"version":1,"sha256Hash":"ecf4"}}}`,
+			Operation{Name: "Hero"}, true},
+		{"GET", "query=query%20Hero%20%7B%20hero%20%7B%20name%20%7D%20%7D", "", Operation{Name: "Hero", Type: "query"}, true},
+		{"GET", "operationName=Hero&query=%7Bhero%7Bname%7D%7D", "", Operation{Name: "Hero", Type: "query"}, true},
+		{"POST", "", `{"query":"select * from users"}`, Operation{}, false},
+		{"POST", "", `{"operationName":"Hero","variables":{}}`, Operation{}, false},
+		{"POST", "", `name=graphql`, Operation{}, false},
+		{"GET", "page=1", "", Operation{}, false},
+		{"PUT", "", `{"query":"{ a }"}`, Operation{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Parse(c.method, c.query, []byte(c.body))
+		if ok != c.ok || got != c.want {
+			t.Errorf("Parse(%s %q %q) = %+v, %v, want %+v, %v", c.method, c.query, c.body, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package grpcweb recognizes gRPC-Web calls among the HTTP requests, the calls of the browsers to the gRPC
+// services behind a gateway (e.g. Envoy or grpcwebproxy), see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
+//
+// A call is a POST to /package.Service/Method with an application/grpc-web content type, or with the
+// X-Grpc-Web header of the JavaScript clients when the content type is cut from the captured headers. The
+// messages are length-prefixed frames, the length of the first message of the request is read from the start
+// of its body. Unlike gRPC over HTTP/2 the trailers are sent in the body, as the last frame of the response,
+// and only the end of the first packet of the response is captured: the status is found for the small
+// responses and for the trailers-only responses, whose status is sent in the headers. The bodies of the
+// grpc-web-text variant are base64 encoded, the captured end is decoded from its last complete quantum.
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatBinary = "binary"
+	FormatText   = "text"
+
+	contentType = "application/grpc-web"
+	// frameHeaderLen is the flags byte and the big endian length of a frame.
+	frameHeaderLen = 5
+)
+
+// Call is a classified gRPC-Web call.
+type Call struct {
+	Service string
+	Method  string
+	// Format is binary or text (base64).
+	Format string
+	// RequestBytes is the length of the first message of the request, -1 if it is not captured.
+	RequestBytes int
+}
+
+// Status is the status of a call sent in its trailers.
+type Status struct {
+	Code    int
+	Message string
+}
+
+var (
+	rpcPath = regexp.MustCompile(`^/([^/]+)/([^/]+)$`)
+	status  = regexp.MustCompile(`(?i)grpc-status:[ \t]*(\d+)`)
+	message = regexp.MustCompile(`(?i)grpc-message:[ \t]*([^\r\n]*)`)
+)
+
+// Classify returns the gRPC-Web call of an HTTP request.
+func Classify(method, path string, headers map[string]string, body []byte) (Call, bool) {
+	if method != http.MethodPost {
+		return Call{}, false
+	}
+	m := rpcPath.FindStringSubmatch(path)
+	if m == nil {
+		return Call{}, false
+	}
+	ct, _ := header(headers, "Content-Type")
+	ct = strings.ToLower(ct)
+	_, hasWebHeader := header(headers, "X-Grpc-Web")
+	if !strings.HasPrefix(ct, contentType) && !hasWebHeader {
+		return Call{}, false
+	}
+	call := Call{Service: m[1], Method: m[2], Format: FormatBinary, RequestBytes: -1}
+	// application/grpc-web-text and application/grpc-web-text+proto, the clients sending the X-Grpc-Web
+	// header use the text format by default.
+	if strings.HasPrefix(ct, contentType+"-text") || (ct == "" && isBase64(body)) {
+		call.Format = FormatText
+	}
+	frame := body
+	if call.Format == FormatText {
+		// 8 characters are 6 bytes, the frame header and the start of the message.
+		if len(body) < 8 {
+			return call, true
+		}
+		frame = make([]byte, 6)
+		if _, err := base64.StdEncoding.Decode(frame, body[:8]); err != nil {
+			return call, true
+		}
+	}
+	// the flags are 0 or 1 (compressed) for the messages.
+	if len(frame) >= frameHeaderLen && frame[0]&^1 == 0 {
+		call.RequestBytes = int(binary.BigEndian.Uint32(frame[1:frameHeaderLen]))
+	}
+	return call, true
+}
+
+// ParseStatus returns the status of the captured end of a response, in the trailers frame of the body or
+// in the headers of a trailers-only response.
+func ParseStatus(response []byte, format string) (Status, bool) {
+	if s, ok := parseTrailers(response); ok || format != FormatText {
+		return s, ok
+	}
+	return parseTrailers(decodeText(response))
+}
+
+func parseTrailers(b []byte) (Status, bool) {
+	m := status.FindSubmatch(b)
+	if m == nil {
+		return Status{}, false
+	}
+	code, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return Status{}, false
+	}
+	s := Status{Code: code}
+	if m := message.FindSubmatch(b); m != nil {
+		// the message is percent-encoded
+		s.Message = string(m[1])
+		if unescaped, err := url.PathUnescape(s.Message); err == nil {
+			s.Message = unescaped
+		}
+	}
+	return s, true
+}
+
+// decodeText decodes the end of a base64 body. The frames may be encoded apart, each one padded, the last
+// one holds the trailers: the end is decoded from the last padding before it, aligned on the end.
+func decodeText(b []byte) []byte {
+	b = bytes.TrimSpace(b)
+	end := len(bytes.TrimRight(b, "="))
+	start := bytes.LastIndexByte(b[:end], '=') + 1
+	last := b[start:]
+	last = last[len(last)%4:]
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(last)))
+	n, err := base64.StdEncoding.Decode(decoded, last)
+	if err != nil {
+		return nil
+	}
+	return decoded[:n]
+}
+
+func isBase64(b []byte) bool {
+	if len(b) < 8 {
+		return false
+	}
+	for _, c := range b[:8] {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+func header(headers map[string]string, name string) (string, bool) {
+	if v, ok := headers[name]; ok {
+		return v, true
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,80 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+// frame builds a length-prefixed frame with the given flags.
+func frame(flags byte, payload string) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{flags}, uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func TestClassify(t *testing.T) {
+	message := frame(0, "\x0a\x05hello")
+	text := []byte(base64.StdEncoding.EncodeToString(message))
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		body    []byte
+		want    Call
+		ok      bool
+	}{
+		{"binary", "POST", "/helloworld.Greeter/SayHello", map[string]string{"Content-Type": "application/grpc-web+proto"}, message,
+			Call{Service: "helloworld.Greeter", Method: "SayHello", Format: FormatBinary, RequestBytes: 7}, true},
+		{"text", "POST", "/helloworld.Greeter/SayHello", map[string]string{"content-type": "application/grpc-web-text"}, text,
+			Call{Service: "helloworld.Greeter", Method: "SayHello", Format: FormatText, RequestBytes: 7}, true},
+		// the content type is cut from the captured headers
+		{"x-grpc-web", "POST", "/shop.Cart/Add", map[string]string{"X-Grpc-Web": "1"}, text,
+			Call{Service: "shop.Cart", Method: "Add", Format: FormatText, RequestBytes: 7}, true},
+		{"body not captured", "POST", "/shop.Cart/Add", map[string]string{"Content-Type": "application/grpc-web"}, nil,
+			Call{Service: "shop.Cart", Method: "Add", Format: FormatBinary, RequestBytes: -1}, true},
+		{"grpc", "POST", "/shop.Cart/Add", map[string]string{"Content-Type": "application/grpc"}, message, Call{}, false},
+		{"json", "POST", "/shop.Cart/Add", map[string]string{"Content-Type": "application/json"}, nil, Call{}, false},
+		{"not a method path", "POST", "/api/v1/cart", map[string]string{"Content-Type": "application/grpc-web"}, message, Call{}, false},
+		{"get", "GET", "/shop.Cart/Add", map[string]string{"Content-Type": "application/grpc-web"}, nil, Call{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Classify(c.method, c.path, c.headers, c.body)
+		if ok != c.ok || got != c.want {
+			t.Errorf("%s: Classify() = %+v, %v, want %+v, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	trailers := frame(0x80, "grpc-status:5\r\ngrpc-message:user%20not%20found\r\n")
+	response := append(frame(0, "\x0a\x02ok"), frame(0x80, "grpc-status:0\r\n")...)
+	cases := []struct {
+		name     string
+		response []byte
+		format   string
+		want     Status
+		ok       bool
+	}{
+		{"binary", response, FormatBinary, Status{Code: 0}, true},
+		{"binary error", trailers, FormatBinary, Status{Code: 5, Message: "user not found"}, true},
+		// the end of the first packet, the start of the message is cut
+		{"cut", response[4:], FormatBinary, Status{Code: 0}, true},
+		// every frame is encoded apart
+		{"text", []byte(base64.StdEncoding.EncodeToString(frame(0, "\x0a\x02ok")) + base64.StdEncoding.EncodeToString(trailers)),
+			FormatText, Status{Code: 5, Message: "user not found"}, true},
+		// the frames are encoded together, the start is cut off
+		{"text cut", []byte(base64.StdEncoding.EncodeToString(response))[3:], FormatText, Status{Code: 0}, true},
+		// a trailers-only response, the status is in the headers
+		{"trailers only", []byte("HTTP/1.1 200 OK\r\ncontent-type: application/grpc-web-text\r\ngrpc-status: 14\r\ngrpc-message: no healthy upstream\r\n\r\n"),
+			FormatText, Status{Code: 14, Message: "no healthy upstream"}, true},
+		{"message only", frame(0, "\x0a\x02ok"), FormatBinary, Status{}, false},
+		{"empty", nil, FormatText, Status{}, false},
+	}
+	for _, c := range cases {
+		got, ok := ParseStatus(c.response, c.format)
+		if ok != c.ok || got != c.want {
+			t.Errorf("%s: ParseStatus() = %+v, %v, want %+v, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -4,101 +4,118 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
-	"sync"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/cputime"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/headsampling"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/connection"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/journey"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/link"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/meta"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/route"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/sampling"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
 	"github.com/erda-project/erda-infra/base/logs"
 	"github.com/erda-project/erda-infra/base/servicehub"
 )
 
+const measurementJourney = "application_http_journey"
+
+type Config struct {
+	// CaptureHeaders are the comma separated headers of the requests and responses reported as tags
+	// (e.g. X-Request-ID,Content-Type), the heads are not captured when it is empty.
+	CaptureHeaders string `env:"HTTP_CAPTURE_HEADERS"`
+	// TraceHeaders captures the heads of the requests for the headers of their trace context as well,
+	// the headers within the request line fragment are read without it, see meta.TraceHeaders.
+	TraceHeaders bool `env:"HTTP_TRACE_HEADERS" default:"true"`
+	// ConnectionInterval is the interval of the reports of the connection reuse between the pods,
+	// see package connection.
+	ConnectionInterval time.Duration `env:"HTTP_CONNECTION_INTERVAL" default:"1m"`
+}
+
 // TODO: go:embed http.bpf.o
 type provider struct {
-	sync.RWMutex
-
 	Log          logs.Logger
+	cfg          Config
+	headers      []string
 	ch           chan ebpf.Metric
 	kprobeHelper kprobe.Interface
 	netNatHelper netfilter.Interface
 	meta         meta.Interface
-	engines      map[int]ebpf.Interface
+	journey      journey.Interface
+	connections  connection.Interface
+	links        link.Interface
+	sampler      sampling.Interface
+	headSampler  headsampling.Interface
+	routes       route.Interface
+	probes       *attach.Attacher
+	cpuTime      cputime.Interface
+	clock        clock.Clock
 }
 
 func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	for _, h := range strings.Split(p.cfg.CaptureHeaders, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			p.headers = append(p.headers, h)
+		}
+	}
+	if p.cfg.TraceHeaders {
+		p.headers = append(p.headers, meta.TraceHeaders...)
+	}
 	p.ch = make(chan ebpf.Metric, 100)
 	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
-	p.netNatHelper = ctx.Service("netfilter").(netfilter.Interface)
-	p.meta = meta.New(p.Log, p.kprobeHelper, p.netNatHelper)
-	p.engines = make(map[int]ebpf.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	routes, err := route.New()
+	if err != nil {
+		return err
+	}
+	p.routes = routes
+	p.meta = meta.New(p.Log, p.kprobeHelper, p.netNatHelper, errorCodes, routes)
+	p.journey = journey.New()
+	p.connections = connection.New()
+	p.links = link.New(p.local)
+	sampler, err := sampling.New()
+	if err != nil {
+		return err
+	}
+	p.sampler = sampler
+	headSampler, err := headsampling.New()
+	if err != nil {
+		return err
+	}
+	p.headSampler = headSampler
+	if c, ok := ctx.Service("cputime").(cputime.Interface); ok {
+		p.cpuTime = c
+	}
+	p.clock = clock.Real
 	return nil
 }
 
 func (p *provider) Gather(c chan *metric.Metric) {
-	vethes, err := p.kprobeHelper.GetVethes()
+	probes, err := attach.Start("http", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch, p.headers)
+		return e, e.Load()
+	})
 	if err != nil {
 		p.Log.Fatalf("failed to get vethes, err: %v", err)
 	}
-
-	p.Log.Debugf("get vethes: %+v", vethes)
-
-	for _, v := range vethes {
-		var (
-			lName  = v.Link.Attrs().Name
-			lIndex = v.Link.Attrs().Index
-			nIP    = v.Neigh.IP.String()
-		)
-		e := ebpf.New(lIndex, nIP, p.ch)
-		p.Log.Infof("gonna to load ebpf program for veth: %s (index: %d), ip: %s", lName, lIndex, nIP)
-		if err := e.Load(); err != nil {
-			p.Log.Errorf("failed to load ebpf program, err: %v", err)
-			continue
-		}
-		p.Lock()
-		p.engines[lIndex] = e
-		p.Unlock()
-	}
-	//ebpfProvider := ebpf.New(1, "127.0.0.1", p.ch)
-	//if err := ebpfProvider.Load(); err != nil {
-	//	panic(err)
-	//}
-
-	vethEvents := p.kprobeHelper.RegisterNetLinkListener()
-	go func() {
-		for {
-			select {
-			case event := <-vethEvents:
-				switch event.Type {
-				case kprobe.LinkAdd:
-					p.Log.Infof("veth add, index: %d, ip: %s", event.Link.Attrs().Index, event.Neigh.IP.String())
-					ebpfProvider := ebpf.New(event.Link.Attrs().Index, event.Neigh.IP.String(), p.ch)
-					if err := ebpfProvider.Load(); err != nil {
-						p.Log.Errorf("failed to load ebpf, err: %v", err)
-						continue
-					}
-					p.Lock()
-					p.engines[event.Link.Attrs().Index] = ebpfProvider
-					p.Unlock()
-				case kprobe.LinkDelete:
-					p.Log.Infof("veth del, index: %d", event.Link.Attrs().Index)
-					p.RLock()
-					ebpfProvider, ok := p.engines[event.Link.Attrs().Index]
-					p.RUnlock()
-					if ok {
-						ebpfProvider.Close()
-						p.Lock()
-						delete(p.engines, event.Link.Attrs().Index)
-						p.Unlock()
-					}
-				default:
-					p.Log.Infof("unknown event type: %v", event.Type)
-				}
-			}
-		}
-	}()
+	p.probes = probes
 
 	go func() {
 		defer func() {
@@ -108,14 +125,47 @@ func (p *provider) Gather(c chan *metric.Metric) {
 			}
 		}()
 
+		startup, events := kprobe.WaitSyncedQueue(p.kprobeHelper, "http", p.ch)
+		c <- startup
+		enricher := p.meta.Enricher()
+		flush := p.clock.NewTicker(enrich.FlushInterval)
+		defer flush.Stop()
+		connections := p.clock.NewTicker(p.cfg.ConnectionInterval)
+		defer connections.Stop()
 		for {
 			select {
-			case m := <-p.ch:
+			case m := <-events:
 				//p.Log.Infof("recive metric: %+v", m.String())
-				export := p.meta.Convert(&m)
-				if export != nil {
+				share, keep := p.headSampler.Sample(p.namespace(&m), m.Method+" "+p.routes.Normalize(m.Path))
+				if !keep {
+					continue
+				}
+				enricher.Submit(func() *metric.Metric { return p.meta.Convert(&m) }, func(export *metric.Metric) {
 					p.Log.Infof("recive metric: %+v", export.String())
-					c <- export
+					if share < 1 {
+						export.Fields["sample_rate"] = share
+					}
+					p.attributeCPU(&m, export)
+					if m.ResponseEndTimestamp != 0 {
+						export.CaptureTime = clock.FromKtime(m.ResponseEndTimestamp)
+					}
+					export.SocketCookie = m.SocketCookie
+					p.connections.Observe(&m, export)
+					// the captures of the client and of the server of a request share their trace.
+					if p.links == nil {
+						p.report(c, &m, export)
+						return
+					}
+					p.links.Link(&m, export, func() { p.report(c, &m, export) })
+				})
+			case <-flush.C():
+				enricher.Flush()
+				if p.links != nil {
+					p.links.Expire(p.clock.Now())
+				}
+			case <-connections.C():
+				for _, m := range p.connections.Report(p.clock.Now().UnixNano()) {
+					c <- m
 				}
 			}
 		}
@@ -130,20 +180,99 @@ func (p *provider) Gather(c chan *metric.Metric) {
 	}()
 }
 
-func (p *provider) Close() {
-	p.Lock()
-	for _, e := range p.engines {
-		e.Close()
+// report sends the converted request with its copies, slow requests are reported whether they are
+// sampled or not, journeys keep all requests of their sessions.
+func (p *provider) report(c chan *metric.Metric, m *ebpf.Metric, export *metric.Metric) {
+	if slow := p.meta.Slow(export); slow != nil {
+		c <- slow
+	}
+	j := p.journeyMetric(m, export)
+	if p.sample(export) {
+		c <- export
+	}
+	if j != nil {
+		c <- j
 	}
-	p.Unlock()
+}
+
+// local reports whether ip is a pod of the node, the linked requests are the ones between them.
+func (p *provider) local(ip string) bool {
+	return p.probes.Local(ip)
+}
+
+// attributeCPU adds the on-cpu time of the thread serving the request when the server runs on the node,
+// cpu_ratio tells the cpu-bound requests from the ones waiting on their dependencies.
+func (p *provider) attributeCPU(m *ebpf.Metric, export *metric.Metric) {
+	if p.cpuTime == nil || m.ResponseTimestamp <= m.RequestTimestamp {
+		return
+	}
+	s, ok := p.cpuTime.Request(m.SourceIP, m.SourcePort, m.RequestTimestamp, m.ResponseTimestamp)
+	if !ok {
+		return
+	}
+	export.Fields["cpu_time"] = s.CPU
+	export.Fields["cpu_ratio"] = s.Ratio()
+}
 
+// namespace is the namespace of the server pod of the request, of the client pod if the server is not a pod.
+func (p *provider) namespace(m *ebpf.Metric) string {
+	for _, ip := range []string{m.DestIP, m.SourceIP} {
+		if pod, err := p.kprobeHelper.GetPodByUID(ip); err == nil {
+			return pod.Namespace
+		}
+	}
+	return ""
+}
+
+// sample applies the per endpoint sampling weights, kept requests with a weight below 1 carry it in sample_rate,
+// multiplied with the share of the head sampling.
+func (p *provider) sample(export *metric.Metric) bool {
+	weight, keep := p.sampler.Sample(export.Tags["target_service_name"], export.Tags["http_path"])
+	if keep && weight < 1 {
+		if share, ok := export.Fields["sample_rate"].(float64); ok {
+			weight *= share
+		}
+		export.Fields["sample_rate"] = weight
+	}
+	return keep
+}
+
+// journeyMetric copies the request metric into application_http_journey when its session is sampled.
+func (p *provider) journeyMetric(m *ebpf.Metric, export *metric.Metric) *metric.Metric {
+	if p.journey == nil {
+		return nil
+	}
+	id, ok := p.journey.Sample(m.Headers)
+	if !ok {
+		return nil
+	}
+	j := &metric.Metric{
+		Name:        measurementJourney,
+		Measurement: measurementJourney,
+		Timestamp:   export.Timestamp,
+		Tags:        make(map[string]string, len(export.Tags)+1),
+		Fields:      make(map[string]interface{}, len(export.Fields)),
+	}
+	for k, v := range export.Tags {
+		j.Tags[k] = v
+	}
+	for k, v := range export.Fields {
+		j.Fields[k] = v
+	}
+	j.Tags["journey_id"] = id
+	return j
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
 }
 
 func init() {
 	servicehub.Register("http", &servicehub.Spec{
-		Services:     []string{"http"},
-		Description:  "ebpf for http",
-		Dependencies: []string{"kprobe", "netfilter"},
+		Services:             []string{"http"},
+		Description:          "ebpf for http",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology", "cputime"},
 		Creator: func() servicehub.Provider {
 			return &provider{}
 		},
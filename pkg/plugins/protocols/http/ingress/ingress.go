@@ -0,0 +1,227 @@
+// Package ingress attributes the requests through the ingress controllers (ingress-nginx, Traefik) to the
+// backend services they are routed to, so that the latency of the users outside the cluster, measured on
+// the requests to the controller, shows up next to the backend serving them.
+//
+//	HTTP_INGRESS_ENABLED=true   the requests through the controllers are not tagged unless it is set
+//	HTTP_INGRESS_TTL=1m         the time the backend of a route is kept after the last forwarded request
+//
+// Both legs of a request through a controller are tagged with ingress_controller (nginx, traefik),
+// ingress_host (the host the user addressed, X-Forwarded-Host of the upstream leg, Host otherwise) and
+// ingress_path (the route template). The controller forwards the request upstream (source is the
+// controller) before it responds to the user (target is the controller): the backend of the upstream leg
+// is recorded by controller pod, host, method and path, and the leg of the user is tagged with it in
+// upstream_service_id, upstream_service_name and upstream_service_instance_id. The routes rewriting the
+// path (e.g. nginx.ingress.kubernetes.io/rewrite-target) are not matched.
+//
+// The controllers are recognized by the app.kubernetes.io/name (or app) label of their pods, by the image
+// of their containers otherwise.
+package ingress
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+const (
+	ControllerNginx   = "nginx"
+	ControllerTraefik = "traefik"
+)
+
+// upstreamTags are the target tags of the upstream leg set on the leg of the user with the upstream_ prefix.
+var upstreamTags = []string{"service_id", "service_name", "service_instance_id"}
+
+type Config struct {
+	Enabled bool          `env:"HTTP_INGRESS_ENABLED" default:"true"`
+	TTL     time.Duration `env:"HTTP_INGRESS_TTL" default:"1m"`
+}
+
+type Interface interface {
+	// Tag tags output, the converted request m with the route template path, when its source or its
+	// target is an ingress controller.
+	Tag(output *metric.Metric, m *ebpf.Metric, path string)
+}
+
+// route is a route of a controller pod.
+type route struct {
+	ingress string
+	host    string
+	method  string
+	path    string
+}
+
+type upstream struct {
+	tags map[string]string
+	seen time.Time
+}
+
+type tracker struct {
+	sync.Mutex
+	ttl       time.Duration
+	pod       func(uid string) (corev1.Pod, error)
+	now       func() time.Time
+	upstreams map[route]*upstream
+	pruned    time.Time
+}
+
+// New returns the ingress attribution, nil unless HTTP_INGRESS_ENABLED is set. pod returns the pod of a uid.
+func New(pod func(uid string) (corev1.Pod, error)) Interface {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	if !cfg.Enabled {
+		return nil
+	}
+	return newTracker(cfg.TTL, pod)
+}
+
+func newTracker(ttl time.Duration, pod func(uid string) (corev1.Pod, error)) *tracker {
+	return &tracker{
+		ttl:       ttl,
+		pod:       pod,
+		now:       time.Now,
+		upstreams: make(map[route]*upstream),
+	}
+}
+
+func (t *tracker) Tag(output *metric.Metric, m *ebpf.Metric, path string) {
+	now := t.now()
+	// the source of the forwarded requests is the controller, the gateway when the client was resolved.
+	source := output.Tags["gateway_service_instance_id"]
+	if source == "" {
+		source = output.Tags["source_service_instance_id"]
+	}
+	if controller := t.controller(source); controller != "" {
+		host := Host(header(m.Headers, "X-Forwarded-Host"), header(m.Headers, "Host"))
+		r := route{ingress: source, host: host, method: m.Method, path: path}
+		tag(output, controller, r)
+		t.forward(r, output.Tags, now)
+		return
+	}
+	target := output.Tags["target_service_instance_id"]
+	if controller := t.controller(target); controller != "" {
+		r := route{ingress: target, host: Host("", header(m.Headers, "Host")), method: m.Method, path: path}
+		tag(output, controller, r)
+		if tags, ok := t.upstream(r, now); ok {
+			for name, value := range tags {
+				output.Tags[name] = value
+			}
+		}
+	}
+}
+
+func (t *tracker) controller(uid string) string {
+	if uid == "" {
+		return ""
+	}
+	pod, err := t.pod(uid)
+	if err != nil {
+		return ""
+	}
+	return Controller(pod)
+}
+
+// forward records the backend of a request a controller forwarded, targetTags are the tags of the request.
+func (t *tracker) forward(r route, targetTags map[string]string, now time.Time) {
+	if targetTags["target_service_instance_id"] == "" {
+		return
+	}
+	tags := make(map[string]string, len(upstreamTags))
+	for _, name := range upstreamTags {
+		tags["upstream_"+name] = targetTags["target_"+name]
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.upstreams[r] = &upstream{tags: tags, seen: now}
+	if now.Sub(t.pruned) < t.ttl {
+		return
+	}
+	t.pruned = now
+	for k, u := range t.upstreams {
+		if now.Sub(u.seen) > t.ttl {
+			delete(t.upstreams, k)
+		}
+	}
+}
+
+// upstream returns the upstream tags of the backend a route was last forwarded to.
+func (t *tracker) upstream(r route, now time.Time) (map[string]string, bool) {
+	t.Lock()
+	defer t.Unlock()
+	u, ok := t.upstreams[r]
+	if !ok || now.Sub(u.seen) > t.ttl {
+		return nil, false
+	}
+	return u.tags, true
+}
+
+func tag(output *metric.Metric, controller string, r route) {
+	output.Tags["ingress_controller"] = controller
+	output.Tags["ingress_path"] = r.path
+	if r.host != "" {
+		output.Tags["ingress_host"] = r.host
+	}
+}
+
+// Controller returns the ingress controller of pod, empty if it is not one.
+func Controller(pod corev1.Pod) string {
+	for _, label := range []string{"app.kubernetes.io/name", "app"} {
+		if controller := controllerName(pod.Labels[label]); controller != "" {
+			return controller
+		}
+	}
+	// e.g. registry.k8s.io/ingress-nginx/controller, nginx/nginx-ingress, traefik
+	for _, c := range pod.Spec.Containers {
+		image := strings.ToLower(c.Image)
+		switch {
+		case strings.Contains(image, "ingress-nginx/"), strings.Contains(image, "nginx-ingress"):
+			return ControllerNginx
+		case strings.Contains(image, "traefik"):
+			return ControllerTraefik
+		}
+	}
+	return ""
+}
+
+func controllerName(name string) string {
+	switch strings.ToLower(name) {
+	case "ingress-nginx", "nginx-ingress", "nginx-ingress-controller":
+		return ControllerNginx
+	case "traefik":
+		return ControllerTraefik
+	}
+	return ""
+}
+
+// Host returns the host the user addressed without its port, forwarded is the X-Forwarded-Host header
+// set by the controller on the upstream leg.
+func Host(forwarded, host string) string {
+	if forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		host = first
+	}
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// header returns the header name of the request, the header keys are kept as sent.
+func header(headers map[string]string, name string) string {
+	if value, ok := headers[name]; ok {
+		return value
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package ingress
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+func TestController(t *testing.T) {
+	for _, c := range []struct {
+		labels map[string]string
+		image  string
+		want   string
+	}{
+		{labels: map[string]string{"app.kubernetes.io/name": "ingress-nginx"}, want: ControllerNginx},
+		{labels: map[string]string{"app": "traefik"}, want: ControllerTraefik},
+		{image: "registry.k8s.io/ingress-nginx/controller:v1.9.4@sha256:5b16", want: ControllerNginx},
+		{image: "docker.io/library/traefik:v2.10", want: ControllerTraefik},
+		{labels: map[string]string{"app": "web"}, image: "nginx:1.25", want: ""},
+	} {
+		pod := corev1.Pod{}
+		pod.Labels = c.labels
+		if c.image != "" {
+			pod.Spec.Containers = []corev1.Container{{Image: c.image}}
+		}
+		if got := Controller(pod); got != c.want {
+			t.Errorf("Controller(%v, %s) = %q, want %q", c.labels, c.image, got, c.want)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	if h := Host("Shop.Example.com:443, proxy", "web.default.svc"); h != "shop.example.com" {
+		t.Errorf("the forwarded host should win, got %s", h)
+	}
+	if h := Host("", "shop.example.com:8080"); h != "shop.example.com" {
+		t.Errorf("unexpected host %s", h)
+	}
+}
+
+func TestTag(t *testing.T) {
+	pods := map[string]corev1.Pod{"nginx-1": {}, "web-1": {}}
+	nginx := pods["nginx-1"]
+	nginx.Labels = map[string]string{"app.kubernetes.io/name": "ingress-nginx"}
+	pods["nginx-1"] = nginx
+	tr := newTracker(time.Minute, func(uid string) (corev1.Pod, error) {
+		if pod, ok := pods[uid]; ok {
+			return pod, nil
+		}
+		return corev1.Pod{}, fmt.Errorf("no pod %s", uid)
+	})
+	now := time.Unix(1700000000, 0)
+	tr.now = func() time.Time { return now }
+
+	// the upstream leg: nginx forwards to web
+	forwarded := &metric.Metric{Tags: map[string]string{
+		"source_service_instance_id": "nginx-1",
+		"target_service_id":          "web", "target_service_name": "web", "target_service_instance_id": "web-1",
+	}}
+	tr.Tag(forwarded, &ebpf.Metric{Method: "GET", Headers: map[string]string{
+		"Host": "web.shop.svc", "X-Forwarded-Host": "shop.example.com",
+	}}, "/orders/{id}")
+	if forwarded.Tags["ingress_controller"] != ControllerNginx || forwarded.Tags["ingress_host"] != "shop.example.com" ||
+		forwarded.Tags["ingress_path"] != "/orders/{id}" {
+		t.Errorf("unexpected tags of the upstream leg %v", forwarded.Tags)
+	}
+
+	// the leg of the user
+	user := &metric.Metric{Tags: map[string]string{"target_service_instance_id": "nginx-1"}}
+	tr.Tag(user, &ebpf.Metric{Method: "GET", Headers: map[string]string{"Host": "shop.example.com"}}, "/orders/{id}")
+	if user.Tags["ingress_host"] != "shop.example.com" || user.Tags["upstream_service_id"] != "web" ||
+		user.Tags["upstream_service_instance_id"] != "web-1" {
+		t.Errorf("unexpected tags of the user leg %v", user.Tags)
+	}
+
+	// another route, and the route once the ttl is over
+	other := &metric.Metric{Tags: map[string]string{"target_service_instance_id": "nginx-1"}}
+	tr.Tag(other, &ebpf.Metric{Method: "POST", Headers: map[string]string{"Host": "shop.example.com"}}, "/orders/{id}")
+	if _, ok := other.Tags["upstream_service_id"]; ok || other.Tags["ingress_controller"] != ControllerNginx {
+		t.Errorf("unexpected tags of an unknown route %v", other.Tags)
+	}
+	now = now.Add(2 * time.Minute)
+	late := &metric.Metric{Tags: map[string]string{"target_service_instance_id": "nginx-1"}}
+	tr.Tag(late, &ebpf.Metric{Method: "GET", Headers: map[string]string{"Host": "shop.example.com"}}, "/orders/{id}")
+	if _, ok := late.Tags["upstream_service_id"]; ok {
+		t.Errorf("the upstream should be expired %v", late.Tags)
+	}
+
+	// neither end is a controller
+	plain := &metric.Metric{Tags: map[string]string{"source_service_instance_id": "web-1"}}
+	tr.Tag(plain, &ebpf.Metric{Method: "GET"}, "/")
+	if len(plain.Tags) != 1 {
+		t.Errorf("unexpected tags %v", plain.Tags)
+	}
+}
@@ -0,0 +1,93 @@
+// Package journey samples the HTTP requests of a fixed share of user sessions.
+//
+// The session is identified by a cookie (HTTP_JOURNEY_COOKIE, e.g. JSESSIONID) or a
+// request header (HTTP_JOURNEY_HEADER, e.g. X-Session-Id), the cookie wins when both are present.
+// The decision only depends on a hash of the session value, so every agent with the same
+// HTTP_JOURNEY_SAMPLE_RATE keeps the same sessions and their requests form a coherent
+// journey across services and nodes. The raw session value is never reported.
+//
+// Only the first bytes of a request are captured in kernel, sessions sent after long
+// urls or large headers are not seen.
+package journey
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+// buckets is the resolution of the sample rate, 0.01% of the sessions.
+const buckets = 10000
+
+type Config struct {
+	Cookie     string  `env:"HTTP_JOURNEY_COOKIE"`
+	Header     string  `env:"HTTP_JOURNEY_HEADER"`
+	SampleRate float64 `env:"HTTP_JOURNEY_SAMPLE_RATE" default:"0.01"`
+}
+
+type Interface interface {
+	// Sample returns the journey id of the session the request belongs to, if that session is sampled.
+	Sample(headers map[string]string) (string, bool)
+}
+
+type sampler struct {
+	cookie    string
+	header    string
+	threshold uint64
+}
+
+// New loads the configuration from the environment, it returns nil when no session key is configured.
+func New() Interface {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	if cfg.Cookie == "" && cfg.Header == "" {
+		return nil
+	}
+	return newSampler(cfg)
+}
+
+func newSampler(cfg Config) *sampler {
+	rate := cfg.SampleRate
+	if rate < 0 {
+		rate = 0
+	} else if rate > 1 {
+		rate = 1
+	}
+	return &sampler{
+		cookie:    cfg.Cookie,
+		header:    http.CanonicalHeaderKey(cfg.Header),
+		threshold: uint64(rate * buckets),
+	}
+}
+
+func (s *sampler) Sample(headers map[string]string) (string, bool) {
+	session := s.session(headers)
+	if session == "" {
+		return "", false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(session))
+	sum := h.Sum64()
+	if sum%buckets >= s.threshold {
+		return "", false
+	}
+	return strconv.FormatUint(sum, 16), true
+}
+
+func (s *sampler) session(headers map[string]string) string {
+	var session string
+	for k, v := range headers {
+		switch canonical := http.CanonicalHeaderKey(k); {
+		case s.cookie != "" && canonical == "Cookie":
+			if c, err := (&http.Request{Header: http.Header{"Cookie": {v}}}).Cookie(s.cookie); err == nil && c.Value != "" {
+				return c.Value
+			}
+		case s.header != "" && canonical == s.header:
+			session = strings.TrimSpace(v)
+		}
+	}
+	return session
+}
@@ -0,0 +1,47 @@
+package journey
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	all := newSampler(Config{Cookie: "JSESSIONID", Header: "x-session-id", SampleRate: 1})
+
+	id, ok := all.Sample(map[string]string{"Cookie": "theme=dark; JSESSIONID=abc123"})
+	if !ok || id == "" {
+		t.Fatalf("cookie session: got %q, %v", id, ok)
+	}
+	if headerID, ok := all.Sample(map[string]string{"X-Session-Id": "abc123"}); !ok || headerID != id {
+		t.Errorf("header session: got %q, %v, want %q", headerID, ok, id)
+	}
+	if cookieID, _ := all.Sample(map[string]string{"X-Session-Id": "other", "cookie": "JSESSIONID=abc123"}); cookieID != id {
+		t.Errorf("cookie should win over header: got %q, want %q", cookieID, id)
+	}
+	if _, ok := all.Sample(map[string]string{"Cookie": "theme=dark"}); ok {
+		t.Error("request without session should not be sampled")
+	}
+
+	none := newSampler(Config{Cookie: "JSESSIONID", SampleRate: 0})
+	if _, ok := none.Sample(map[string]string{"Cookie": "JSESSIONID=abc123"}); ok {
+		t.Error("rate 0 should not sample any session")
+	}
+
+	// the decision is consistent and close to the configured rate
+	some := newSampler(Config{Header: "X-Session-Id", SampleRate: 0.1})
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		headers := map[string]string{"X-Session-Id": "session-" + strconv.Itoa(i)}
+		_, first := some.Sample(headers)
+		_, second := some.Sample(headers)
+		if first != second {
+			t.Fatalf("inconsistent decision for %v", headers)
+		}
+		if first {
+			sampled++
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Errorf("sampled %d of 10000 sessions, want about 1000", sampled)
+	}
+}
@@ -0,0 +1,83 @@
+// Package jsonrpc recognizes JSON-RPC 2.0 calls among the HTTP requests, so that JSON-RPC services
+// (e.g. blockchain nodes) are reported per method, see https://www.jsonrpc.org/specification
+//
+// Only a part of the bodies is captured by the kernel, the start of the request body if it is sent apart
+// from the headers and the end of the first packet otherwise, and the end of the first packet of the
+// response. The bodies are scanned rather than decoded and may be cut anywhere. Batches are reported
+// by their first call. Errors are answered with 200, the error object is found at the end of small
+// responses, larger responses are results.
+package jsonrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// Call is a classified JSON-RPC call.
+type Call struct {
+	Method string
+	// Batch tells whether the call is the first of a batch.
+	Batch bool
+}
+
+// Error is the error object of a response.
+type Error struct {
+	Code    int
+	Message string
+}
+
+var (
+	version = regexp.MustCompile(`"jsonrpc"\s*:\s*"2\.0"`)
+	method  = regexp.MustCompile(`"method"\s*:\s*"((?:[^"\\]|\\.)+)"`)
+	batch   = regexp.MustCompile(`^\s*\[\s*\{`)
+	// errorObject matches the start of the error member of a response, it is null or missing on success.
+	errorObject = regexp.MustCompile(`"error"\s*:\s*\{`)
+	code        = regexp.MustCompile(`"code"\s*:\s*(-?\d+)`)
+	message     = regexp.MustCompile(`"message"\s*:\s*"((?:[^"\\]|\\.)*)"`)
+)
+
+// Parse returns the JSON-RPC call of an HTTP request, if the captured body is a JSON-RPC 2.0 request.
+func Parse(httpMethod string, body []byte) (Call, bool) {
+	if httpMethod != http.MethodPost || !version.Match(body) {
+		return Call{}, false
+	}
+	m := method.FindSubmatch(body)
+	if m == nil {
+		return Call{}, false
+	}
+	return Call{Method: unquote(m[1]), Batch: batch.Match(body)}, true
+}
+
+// ParseError returns the error object of the captured end of a response, its message is left
+// empty if it is cut.
+func ParseError(response []byte) (Error, bool) {
+	loc := errorObject.FindIndex(response)
+	if loc == nil {
+		return Error{}, false
+	}
+	object := response[loc[1]:]
+	m := code.FindSubmatch(object)
+	if m == nil {
+		return Error{}, false
+	}
+	c, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return Error{}, false
+	}
+	e := Error{Code: c}
+	if m := message.FindSubmatch(object); m != nil {
+		e.Message = unquote(m[1])
+	}
+	return e, true
+}
+
+// unquote returns the value of the content of a JSON string, the raw content if it is not valid.
+func unquote(content []byte) string {
+	var s string
+	if err := json.Unmarshal([]byte(`"`+string(content)+`"`), &s); err != nil {
+		return string(content)
+	}
+	return s
+}
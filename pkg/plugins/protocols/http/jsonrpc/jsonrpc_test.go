@@ -0,0 +1,53 @@
+package jsonrpc
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		method, body string
+		want         Call
+		ok           bool
+	}{
+		{"POST", `{"jsonrpc":"2.0","method":"eth_getBalance","params":["0x407d73d8a49eeb85d32cf465507dd71d507100c1","latest"],"id":1}`,
+			Call{Method: "eth_getBalance"}, true},
+		{"POST", `{"id": 7, "method": "subtract", "params": [42, 23], "jsonrpc": "2.0"}`, Call{Method: "subtract"}, true},
+		{"POST", `[{"jsonrpc":"2.0","method":"sum","params":[1,2,4],"id":"1"},{"jsonrpc":"2.0","method":"notify_hello","params":[7]}]`,
+			Call{Method: "sum", Batch: true}, true},
+		{"POST", `{"jsonrpc":"2.0","method":"tools\/run","id":1}`, Call{Method: "tools/run"}, true},
+		// the end of a large request sent with its headers in the first packet.
+		{"POST", `00000000000000000000000"],"method":"eth_sendRawTransaction","jsonrpc":"2.0","id":3}`,
+			Call{Method: "eth_sendRawTransaction"}, true},
+		{"POST", `{"method":"echo","params":["hi"],"id":1}`, Call{}, false},
+		{"POST", `{"jsonrpc":"2.0","result":19,"id":1}`, Call{}, false},
+		{"GET", `{"jsonrpc":"2.0","method":"sum","id":1}`, Call{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Parse(c.method, []byte(c.body))
+		if ok != c.ok || got != c.want {
+			t.Errorf("Parse(%s %s) = %+v, %v, want %+v, %v", c.method, c.body, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		response string
+		want     Error
+		ok       bool
+	}{
+		{`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"1"}`, Error{Code: -32601, Message: "Method not found"}, true},
+		{`{"jsonrpc": "2.0", "error": {"message": "insufficient funds for gas * price + value", "code": -32000}, "id": 3}`,
+			Error{Code: -32000, Message: "insufficient funds for gas * price + value"}, true},
+		{`{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid \"params\""},"id":1}`, Error{Code: -32602, Message: `invalid "params"`}, true},
+		{`{"jsonrpc":"2.0","error":{"code":-32603,"message":"exec`, Error{Code: -32603, Message: ""}, true},
+		{`{"jsonrpc":"2.0","result":{"code":1,"message":"ok"},"error":null,"id":1}`, Error{}, false},
+		{`{"jsonrpc":"2.0","result":"0x0234c8a3397aab58","id":1}`, Error{}, false},
+		{``, Error{}, false},
+	}
+	for _, c := range cases {
+		got, ok := ParseError([]byte(c.response))
+		if ok != c.ok || got != c.want {
+			t.Errorf("ParseError(%s) = %+v, %v, want %+v, %v", c.response, got, ok, c.want, c.ok)
+		}
+	}
+}
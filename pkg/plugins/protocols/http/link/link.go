@@ -0,0 +1,191 @@
+// Package link correlates the two captures of the requests between the pods of the node: the client pod
+// and the server pod both report the request, the client capture with span_kind=client. The captures of
+// a request are matched on the socket of the client (source ip and port, the destination of the client
+// capture may be the address of a service) and on their timing: the request reaches the server within
+// the window of the client, from its first request packet to the last packet of the response.
+//
+//	HTTP_LINK_ENABLED=true   the captures are reported as they are converted unless it is set
+//	HTTP_LINK_WINDOW=2s      the time a capture waits for the capture of the other end
+//
+// Only the captures whose other end is a pod of the node are held, the captures of the others are
+// reported at once. Linked captures share a trace: the client capture keeps the trace context the
+// client propagated, and gets a new trace_id and span_id without one; the server capture gets the
+// trace_id of the client capture and its span_id as parent_span_id. Both are tagged span_linked=true.
+// The captures without a match are reported once the window is over.
+package link
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+const tagLinked = "span_linked"
+
+type Config struct {
+	Enabled bool          `env:"HTTP_LINK_ENABLED" default:"true"`
+	Window  time.Duration `env:"HTTP_LINK_WINDOW" default:"2s"`
+}
+
+type Interface interface {
+	// Link passes the converted output of m to emit once it is linked to the capture of the other end,
+	// at once if the other end is not a pod of the node.
+	Link(m *ebpf.Metric, output *metric.Metric, emit func())
+	// Expire emits the captures held for longer than the window without a match.
+	Expire(now time.Time)
+}
+
+// capture is a capture waiting for the other end, start and end are its window (bpf_ktime_get_ns).
+type capture struct {
+	client bool
+	start  uint64
+	end    uint64
+	output *metric.Metric
+	emit   func()
+	held   time.Time
+}
+
+type linker struct {
+	sync.Mutex
+	window time.Duration
+	local  func(ip string) bool
+	now    func() time.Time
+	// pending are the captures waiting by the socket of their client.
+	pending map[string][]*capture
+}
+
+// New returns the linker of the captures, nil unless HTTP_LINK_ENABLED is set. local reports whether an ip
+// is a pod of the node.
+func New(local func(ip string) bool) Interface {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	if !cfg.Enabled {
+		return nil
+	}
+	return newLinker(cfg.Window, local)
+}
+
+func newLinker(window time.Duration, local func(ip string) bool) *linker {
+	return &linker{
+		window:  window,
+		local:   local,
+		now:     time.Now,
+		pending: make(map[string][]*capture),
+	}
+}
+
+func (l *linker) Link(m *ebpf.Metric, output *metric.Metric, emit func()) {
+	// the server of a client capture is the destination after the nat of the service.
+	peer := m.SourceIP
+	if m.Client {
+		peer = m.DestIP
+		if address := output.Tags["peer_address"]; address != "" {
+			peer = address[:strings.LastIndexByte(address, ':')]
+		}
+	}
+	if !l.local(peer) {
+		emit()
+		return
+	}
+	c := &capture{
+		client: m.Client,
+		start:  m.RequestTimestamp,
+		end:    m.ResponseEndTimestamp,
+		output: output,
+		emit:   emit,
+	}
+	if c.end == 0 {
+		c.end = m.ResponseTimestamp
+	}
+	k := fmt.Sprintf("%s:%d", m.SourceIP, m.SourcePort)
+
+	l.Lock()
+	pending := l.pending[k]
+	for i, other := range pending {
+		if other.client == c.client || !overlap(c, other) {
+			continue
+		}
+		l.pending[k] = append(pending[:i:i], pending[i+1:]...)
+		if len(l.pending[k]) == 0 {
+			delete(l.pending, k)
+		}
+		l.Unlock()
+		if c.client {
+			link(c.output, other.output)
+		} else {
+			link(other.output, c.output)
+		}
+		other.emit()
+		c.emit()
+		return
+	}
+	c.held = l.now()
+	l.pending[k] = append(pending, c)
+	l.Unlock()
+}
+
+// overlap reports whether the server capture is within the window of the client capture.
+func overlap(a, b *capture) bool {
+	client, server := a, b
+	if b.client {
+		client, server = b, a
+	}
+	return server.start >= client.start && server.start <= client.end
+}
+
+// link shares the trace of the client capture with the server capture.
+func link(client, server *metric.Metric) {
+	if client.Tags["trace_id"] == "" {
+		client.Tags["trace_id"] = server.Tags["trace_id"]
+		client.Tags["span_id"] = server.Tags["parent_span_id"]
+	}
+	if client.Tags["trace_id"] == "" {
+		client.Tags["trace_id"] = newID(2)
+	}
+	if client.Tags["span_id"] == "" {
+		client.Tags["span_id"] = newID(1)
+	}
+	server.Tags["trace_id"] = client.Tags["trace_id"]
+	server.Tags["parent_span_id"] = client.Tags["span_id"]
+	client.Tags[tagLinked] = "true"
+	server.Tags[tagLinked] = "true"
+}
+
+func (l *linker) Expire(now time.Time) {
+	var expired []*capture
+	l.Lock()
+	for k, pending := range l.pending {
+		kept := pending[:0]
+		for _, c := range pending {
+			if now.Sub(c.held) >= l.window {
+				expired = append(expired, c)
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.pending, k)
+		} else {
+			l.pending[k] = kept
+		}
+	}
+	l.Unlock()
+	for _, c := range expired {
+		c.emit()
+	}
+}
+
+// newID returns a random hex id of n*8 bytes, 8 bytes for span ids and 16 bytes for trace ids.
+func newID(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%016x", rand.Uint64())
+	}
+	return b.String()
+}
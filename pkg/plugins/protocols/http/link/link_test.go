@@ -0,0 +1,78 @@
+package link
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+func request(client bool, start, end uint64) (*ebpf.Metric, *metric.Metric) {
+	m := &ebpf.Metric{
+		SourceIP: "10.0.0.1", SourcePort: 43210, DestIP: "10.96.0.10", DestPort: 80,
+		RequestTimestamp: start, ResponseEndTimestamp: end, Client: client,
+	}
+	output := &metric.Metric{Tags: map[string]string{"peer_address": "10.0.0.2:8080"}}
+	if client {
+		output.Tags["span_kind"] = "client"
+	}
+	return m, output
+}
+
+func TestLink(t *testing.T) {
+	local := map[string]bool{"10.0.0.1": true, "10.0.0.2": true}
+	l := newLinker(time.Second, func(ip string) bool { return local[ip] })
+	var emitted []*metric.Metric
+	emit := func(output *metric.Metric) func() {
+		return func() { emitted = append(emitted, output) }
+	}
+
+	sm, server := request(false, 1100, 1900)
+	l.Link(sm, server, emit(server))
+	if len(emitted) != 0 {
+		t.Fatal("the server capture waits for the client capture")
+	}
+	// the client capture of another request on the connection
+	om, other := request(true, 3000, 4000)
+	l.Link(om, other, emit(other))
+	cm, client := request(true, 1000, 2000)
+	l.Link(cm, client, emit(client))
+	if len(emitted) != 2 || emitted[0] != server || emitted[1] != client {
+		t.Fatalf("expected the linked captures, got %v", emitted)
+	}
+	if client.Tags["trace_id"] == "" || client.Tags["span_id"] == "" ||
+		server.Tags["trace_id"] != client.Tags["trace_id"] || server.Tags["parent_span_id"] != client.Tags["span_id"] ||
+		client.Tags[tagLinked] != "true" || server.Tags[tagLinked] != "true" {
+		t.Errorf("unexpected trace tags, client %v, server %v", client.Tags, server.Tags)
+	}
+
+	l.Expire(time.Now())
+	if len(emitted) != 2 {
+		t.Error("the window of the other capture is not over")
+	}
+	l.Expire(time.Now().Add(time.Second))
+	if len(emitted) != 3 || emitted[2] != other || other.Tags[tagLinked] != "" || len(l.pending) != 0 {
+		t.Errorf("expected the capture without match, got %v", emitted)
+	}
+
+	delete(local, "10.0.0.2")
+	rm, remote := request(true, 5000, 6000)
+	l.Link(rm, remote, emit(remote))
+	if len(emitted) != 4 {
+		t.Error("the captures of the servers off the node are not held")
+	}
+}
+
+func TestLinkTraceContext(t *testing.T) {
+	client := &metric.Metric{Tags: map[string]string{}}
+	server := &metric.Metric{Tags: map[string]string{
+		"trace_id":       "4bf92f3577b34da6a3ce929d0e0e4736",
+		"parent_span_id": "00f067aa0ba902b7",
+	}}
+	link(client, server)
+	if client.Tags["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || client.Tags["span_id"] != "00f067aa0ba902b7" ||
+		server.Tags["parent_span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("the propagated trace context is kept, client %v, server %v", client.Tags, server.Tags)
+	}
+}
@@ -0,0 +1,45 @@
+package meta
+
+import (
+	"net"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+)
+
+// forwarded attributes output to the client pod of ip its source forwarded the request for, the clients
+// outside the cluster leave the gateway as the source.
+func (p *provider) forwarded(output *metric.Metric, ip string) {
+	if ip == "" {
+		return
+	}
+	client, err := p.k.GetPodByUID(ip)
+	if err != nil {
+		return
+	}
+	enrich.Forwarded(output, client)
+}
+
+// forwardedClient returns the ip of the client a proxy forwarded the request for: the first address of
+// X-Forwarded-For, X-Real-IP without it. The headers are only seen in the captured start of the request.
+func forwardedClient(headers map[string]string) string {
+	if xff := header(headers, "X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		return clientIP(first)
+	}
+	return clientIP(header(headers, "X-Real-IP"))
+}
+
+// clientIP returns the ip of an address of the headers, with or without its port.
+func clientIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
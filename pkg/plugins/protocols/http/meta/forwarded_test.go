@@ -0,0 +1,22 @@
+package meta
+
+import "testing"
+
+func TestForwardedClient(t *testing.T) {
+	for _, c := range []struct {
+		headers map[string]string
+		want    string
+	}{
+		{map[string]string{"X-Forwarded-For": "10.0.3.7, 10.0.1.2"}, "10.0.3.7"},
+		{map[string]string{"x-forwarded-for": "10.0.3.7:53412"}, "10.0.3.7"},
+		{map[string]string{"X-Forwarded-For": "[2001:db8::1]:443, 10.0.1.2"}, "2001:db8::1"},
+		{map[string]string{"X-Real-IP": "10.0.3.8"}, "10.0.3.8"},
+		// X-Forwarded-For wins, even when it can not be parsed
+		{map[string]string{"X-Forwarded-For": "unknown", "X-Real-IP": "10.0.3.8"}, ""},
+		{map[string]string{"Host": "api"}, ""},
+	} {
+		if got := forwardedClient(c.headers); got != c.want {
+			t.Errorf("forwardedClient(%v) = %q, want %q", c.headers, got, c.want)
+		}
+	}
+}
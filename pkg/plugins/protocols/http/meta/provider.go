@@ -3,160 +3,434 @@ package meta
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/erda-project/erda-infra/base/logs"
-	corev1 "k8s.io/api/core/v1"
 
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/elasticsearch"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/graphql"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/grpcweb"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ingress"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/jsonrpc"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/route"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/soap"
 )
 
 const (
 	measurementGroup         = "application_http"
 	measurementGroupError    = "application_http_error"
 	measurementGroupDuration = "application_http_slow"
+
+	measurementGroupDB      = "application_db"
+	measurementGroupDBError = "application_db_error"
+
+	measurementGroupRPC = "application_rpc"
 )
 
+type Config struct {
+	// URLQuery adds the query string of the requests to http_url, without the sensitive parameters.
+	URLQuery bool `env:"HTTP_URL_QUERY" default:"false"`
+	// SensitiveParams are the comma separated parameters stripped from the query strings, compared case-insensitively.
+	SensitiveParams string `env:"HTTP_URL_SENSITIVE_PARAMS" default:"token,access_token,refresh_token,id_token,password,passwd,pwd,secret,client_secret,api_key,apikey,auth,authorization,signature,sig,session,sessionid"`
+	// SlowThreshold is the duration above which the requests are slow, 0 disables application_http_slow.
+	// The pods of a service override it with the ebpf.erda.cloud/http-slow-threshold annotation.
+	SlowThreshold time.Duration `env:"HTTP_SLOW_THRESHOLD" default:"1s"`
+	// ErrorStatus are the status codes of the server errors, comma separated codes or ranges (e.g. 500-599,429).
+	ErrorStatus string `env:"HTTP_ERROR_STATUS" default:"500-599"`
+	// ClientErrorStatus are the status codes of the client errors, they are reported in application_http_error
+	// without being errors of the server. The other codes are successes.
+	ClientErrorStatus string `env:"HTTP_CLIENT_ERROR_STATUS" default:"400-499"`
+	// ForwardedSource attributes the requests forwarded by an ingress or a gateway to the client pod of their
+	// X-Forwarded-For (or X-Real-IP) header, the headers can be forged by the clients.
+	ForwardedSource bool `env:"HTTP_FORWARDED_SOURCE" default:"false"`
+	// UserAgentInternal are the comma separated tokens of the User-Agent headers of the services of the
+	// platform, classified as internal-service, compared case-insensitively.
+	UserAgentInternal string `env:"HTTP_USER_AGENT_INTERNAL" default:"kube-probe,prometheus,envoy,erda"`
+}
+
 type Interface interface {
+	// Convert converts the http request, the requests captured at the client are reported with span_kind=client.
+	// The trace context propagated by the request is reported in the trace tags, see traceContext.
 	Convert(metric *ebpf.Metric) *metric.Metric
+	// Slow returns the copy of the converted http request in application_http_slow if it exceeds the slow
+	// threshold of its target, nil otherwise.
+	Slow(output *metric.Metric) *metric.Metric
+	// Enricher is the enrichment of the converted metrics, it holds the conversions of pods missing in the cache.
+	Enricher() enrich.Interface
 }
 
 type provider struct {
-	l            logs.Logger
-	kprobeHelper kprobe.Interface
-	netNatHelper netfilter.Interface
+	l          logs.Logger
+	k          kprobe.Interface
+	enricher   enrich.Interface
+	errorCodes errorcodes.Interface
+	routes     route.Interface
+	ingresses  ingress.Interface
+	cfg        Config
+	sanitizer  *sanitizer
+	status     *statusPolicy
+	userAgents *userAgentClassifier
+	clock      clock.Clock
 }
 
-func New(l logs.Logger, k kprobe.Interface, n netfilter.Interface) Interface {
-	return &provider{
-		l:            l,
-		kprobeHelper: k,
-		netNatHelper: n,
+func New(l logs.Logger, k kprobe.Interface, n netfilter.Interface, errorCodes errorcodes.Interface, routes route.Interface) Interface {
+	p := &provider{
+		l:          l,
+		k:          k,
+		enricher:   enrich.New(k, n),
+		errorCodes: errorCodes,
+		routes:     routes,
+		ingresses:  ingress.New(k.GetPodByUID),
+		clock:      clock.Real,
 	}
+	envconf.MustLoad(&p.cfg)
+	p.sanitizer = newSanitizer(p.cfg.SensitiveParams)
+	p.userAgents = newUserAgentClassifier(p.cfg.UserAgentInternal)
+	status, err := newStatusPolicy(p.cfg.ErrorStatus, p.cfg.ClientErrorStatus)
+	if err != nil {
+		l.Errorf("invalid http status policy, the default one is used: %v", err)
+		status, _ = newStatusPolicy("500-599", "400-499")
+	}
+	p.status = status
+	return p
+}
+
+func (p *provider) Enricher() enrich.Interface {
+	return p.enricher
 }
 
 func (p *provider) Convert(m *ebpf.Metric) *metric.Metric {
+	output := p.convert(m)
+	if output == nil {
+		return nil
+	}
+	// the client and the server of a request both report it, the difference of their latencies is the network.
+	if m.Client {
+		output.Tags["span_kind"] = "client"
+	}
+	traceContext(output, m)
+	// before the source of the requests forwarded by the controllers becomes the client.
+	if p.ingresses != nil {
+		p.ingresses.Tag(output, m, p.routes.Normalize(m.Path))
+	}
+	// the PROXY protocol header is set by the load balancer, the forwarding headers may be forged by the clients.
+	if m.ProxySourceIP != "" {
+		p.forwarded(output, m.ProxySourceIP)
+	} else if p.cfg.ForwardedSource {
+		p.forwarded(output, forwardedClient(m.Headers))
+	}
+	return output
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
 	p.l.Infof("gonna to convert metrics: %+v", m)
+	if req, ok := elasticsearch.Classify(m.Method, m.Path); ok {
+		return p.convertElasticsearch(m, req)
+	}
+	if call, ok := grpcweb.Classify(m.Method, m.Path, m.Headers, m.Body); ok {
+		return p.convertGRPCWeb(m, call)
+	}
+	if call, ok := soap.Classify(m.Method, m.Path, m.Headers, m.Body); ok {
+		return p.convertSOAP(m, call)
+	}
+	if call, ok := jsonrpc.Parse(m.Method, m.Body); ok {
+		return p.convertJSONRPC(m, call)
+	}
 	measurement := measurementGroup
+	// the metrics aggregate on the route template of the path
+	path := p.routes.Normalize(m.Path)
 	output := &metric.Metric{
-		Timestamp: time.Now().UnixNano(),
+		Timestamp: p.clock.Now().UnixNano(),
 		Tags: map[string]string{
-			"metric_source":    "ebpf",
-			"_meta":            "true",
-			"_metric_scope":    "micro_service",
-			"span_kind":        "server",
 			"http_method":      m.Method,
-			"http_path":        m.Path,
+			"http_path":        path,
 			"http_status_code": strconv.Itoa(int(m.StatusCode)),
 			// TODO: diff with http_path?
-			"http_target":  m.Path,
+			"http_target":  path,
 			"http_version": m.Version,
-			// TODO: full url with query params, replace Host?
-			"http_url": fmt.Sprintf("http://%s:%d%s", m.DestIP, m.DestPort, m.Path),
-		},
-		Fields: map[string]interface{}{
-			"elapsed_count": 1,
-			"elapsed_sum":   m.Duration,
-			"elapsed_max":   m.Duration,
-			"elapsed_min":   m.Duration,
-			"elapsed_mean":  m.Duration,
 		},
+		Fields: enrich.Elapsed(m.Duration),
 	}
 	p.l.Infof("ebpf metrics: %s", m.String())
+	output.Tags["http_user_agent_class"] = p.userAgents.classify(header(m.Headers, "User-Agent"))
+	for name, value := range m.RequestHeaders {
+		if !isTraceHeader(name) {
+			output.Tags["http_request_header_"+headerTag(name)] = value
+		}
+	}
+	for name, value := range m.ResponseHeaders {
+		output.Tags["http_response_header_"+headerTag(name)] = value
+	}
+	// GraphQL endpoints serve every operation on a single path.
+	if op, ok := graphql.Parse(m.Method, m.RawQuery, m.Body); ok {
+		if op.Name != "" {
+			output.Tags["graphql_operation_name"] = op.Name
+		}
+		if op.Type != "" {
+			output.Tags["graphql_operation_type"] = op.Type
+		}
+	}
+	// latency breakdown seen by the pod capturing the request, reused connections have no connect phase.
+	// TLS handshakes are not visible, the plugin only decodes plaintext http.
+	output.Fields["phase_request_write"] = m.Phases.RequestWrite
+	output.Fields["phase_server"] = m.Phases.Server
+	output.Fields["phase_response_read"] = m.Phases.ResponseRead
+	if m.Phases.Connect > 0 {
+		output.Fields["phase_connect"] = m.Phases.Connect
+	}
+	// the chunked responses are read up to their last chunk
+	if m.ResponseBytes > 0 {
+		output.Fields["response_bytes"] = m.ResponseBytes
+	}
 
-	if m.StatusCode >= 400 {
+	class := p.status.classify(m.StatusCode)
+	if class != statusSuccess {
 		measurement = measurementGroupError
 	}
+	output.Tags["http_status_class"] = class.String()
+	output.Tags["error"] = strconv.FormatBool(class == statusServerError)
 
-	// TODO: how to define slow request?
+	// the requests above the slow threshold are copied to application_http_slow, see Slow
 	output.Measurement = measurement
 	output.Name = measurement
 
-	sourcePod, err := p.kprobeHelper.GetPodByUID(m.SourceIP)
-	if err != nil {
-		p.l.Errorf("failed to get pod by uid: %s, err: %v", m.SourceIP, err)
-	} else {
-		// source platform metadata
-		output.OrgName = sourcePod.Labels["DICE_ORG_NAME"]
-		output.Tags["_metric_scope_id"] = sourcePod.Annotations["msp.erda.cloud/terminus_key"]
-		output.Tags["source_application_id"] = sourcePod.Labels["DICE_APPLICATION_ID"]
-		output.Tags["source_application_name"] = sourcePod.Labels["DICE_APPLICATION_NAME"]
-		output.Tags["source_org_id"] = sourcePod.Labels["DICE_ORG_ID"]
-		output.Tags["source_project_id"] = sourcePod.Labels["DICE_PROJECT_ID"]
-		output.Tags["source_project_name"] = sourcePod.Labels["DICE_PROJECT_NAME"]
-		output.Tags["source_runtime_id"] = sourcePod.Labels["DICE_RUNTIME_ID"]
-		output.Tags["source_runtime_name"] = sourcePod.Annotations["msp.erda.cloud/runtime_name"]
-		//output.Tags["source_service_id"] = fmt.Sprintf("%s_%s_%s",
-		//	sourcePod.Labels["DICE_APPLICATION_ID"], sourcePod.Annotations["msp.erda.cloud/runtime_name"], sourcePod.Labels["DICE_SERVICE_NAME"])
-		output.Tags["source_service_id"] = sourcePod.Annotations["msp.erda.cloud/service_name"]
-		output.Tags["source_service_instance_id"] = string(sourcePod.UID)
-		output.Tags["source_service_name"] = sourcePod.Annotations["msp.erda.cloud/service_name"]
-		output.Tags["source_terminus_key"] = sourcePod.Annotations["msp.erda.cloud/terminus_key"]
-		output.Tags["source_workspace"] = sourcePod.Annotations["msp.erda.cloud/workspace"]
-	}
-
-	var target any
-	dstIP := m.DestIP
-	natInfo, exist := p.netNatHelper.GetNatInfo(m.SourceIP, m.SourcePort)
-	if exist {
-		dstIP = natInfo.ReplyDstIP
-		m.DestPort = natInfo.ReplyDstPort
-	}
-
-	pod, err := p.kprobeHelper.GetPodByUID(dstIP)
-	if err != nil {
-		svc, err := p.kprobeHelper.GetService(dstIP)
-		if err == nil {
-			target = svc
+	inCluster := p.enricher.Enrich(output, "HTTP", enrich.Endpoints{
+		SourceIP:   m.SourceIP,
+		SourcePort: m.SourcePort,
+		DestIP:     m.DestIP,
+		DestPort:   m.DestPort,
+	})
+	// external target
+	if !inCluster {
+		p.l.Infof("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// targets are named after the host the client addressed, unresolved ones keep their surrogate identity
+		if !enrich.External(output, header(m.Headers, "Host")) && !enrich.Surrogate(output) {
+			return nil
+		}
+	}
+	// TODO: replace Host?
+	output.Tags["http_url"] = fmt.Sprintf("http://%s%s", output.Tags["peer_address"], path)
+	if p.cfg.URLQuery {
+		if query := p.sanitizer.sanitize(m.RawQuery); query != "" {
+			output.Tags["http_url"] += "?" + query
+		}
+	}
+	return output
+}
+
+// headerTag returns the tag suffix of a captured header, e.g. x_request_id.
+func headerTag(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "-", "_")
+}
+
+// header returns the header name of the request, the header keys are kept as sent.
+func header(headers map[string]string, name string) string {
+	if value, ok := headers[name]; ok {
+		return value
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
 		}
-	} else {
-		target = pod
 	}
+	return ""
+}
 
-	// external target
-	if target == nil {
-		p.l.Infof("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, m.DestIP)
-		return nil
+// convertElasticsearch reports Elasticsearch requests as database calls.
+func (p *provider) convertElasticsearch(m *ebpf.Metric, req elasticsearch.Request) *metric.Metric {
+	isError := p.status.failed(m.StatusCode)
+	measurement := measurementGroupDB
+	if isError {
+		measurement = measurementGroupDBError
+	}
+	statement := m.Method + " " + req.Operation
+	if req.Index != "" {
+		statement += " " + req.Index
+	}
+	output := &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"db_type":          "elasticsearch",
+			"db_statement":     statement,
+			"db_operation":     req.Operation,
+			"es_index":         req.Index,
+			"es_operation":     req.Operation,
+			"http_method":      m.Method,
+			"http_status_code": strconv.Itoa(int(m.StatusCode)),
+			"error":            strconv.FormatBool(isError),
+		},
+		Fields: enrich.Elapsed(m.Duration),
 	}
 
-	// in cluster
-	switch t := target.(type) {
-	case corev1.Pod:
-		p.l.Infof("source(pod): %s/%d, target(pod): %s/%s", m.SourceIP, m.SourcePort, t.Namespace, t.Name)
-		output.Tags["cluster_name"] = t.Labels["DICE_CLUSTER_NAME"]
-		output.Tags["db_host"] = fmt.Sprintf("%s:%d", m.DestIP, m.DestPort)
-		output.Tags["org_name"] = t.Labels["DICE_ORG_NAME"]
-		// TODO: remove db_host
-		output.Tags["peer_address"] = output.Tags["db_host"]
-		// TODO: peer_hostname
-		output.Tags["peer_hostname"] = ""
-		output.OrgName = output.Tags["org_name"]
-
-		// target platform metadata
-		output.Tags["_metric_scope_id"] = t.Annotations["msp.erda.cloud/terminus_key"]
-		output.Tags["target_application_id"] = t.Labels["DICE_APPLICATION_ID"]
-		output.Tags["target_application_name"] = t.Labels["DICE_APPLICATION_NAME"]
-		output.Tags["target_org_id"] = t.Labels["DICE_ORG_ID"]
-		output.Tags["target_project_id"] = t.Labels["DICE_PROJECT_ID"]
-		output.Tags["target_project_name"] = t.Labels["DICE_PROJECT_NAME"]
-		output.Tags["target_runtime_id"] = t.Labels["DICE_RUNTIME_ID"]
-		output.Tags["target_runtime_name"] = t.Annotations["msp.erda.cloud/runtime_name"]
-		//output.Tags["target_service_id"] = fmt.Sprintf("%s_%s_%s",
-		//	t.Labels["DICE_APPLICATION_ID"], t.Annotations["msp.erda.cloud/runtime_name"], t.Labels["DICE_SERVICE_NAME"])
-		output.Tags["target_service_id"] = t.Annotations["msp.erda.cloud/service_name"]
-		output.Tags["target_service_instance_id"] = string(t.UID)
-		output.Tags["target_service_name"] = t.Annotations["msp.erda.cloud/service_name"]
-		output.Tags["target_terminus_key"] = t.Annotations["msp.erda.cloud/terminus_key"]
-		output.Tags["target_workspace"] = t.Annotations["msp.erda.cloud/workspace"]
-	case corev1.Service:
-		// TODO: service resource
-		p.l.Infof("source(pod): %s/%d, target(service): %s/%s", m.SourceIP, m.SourcePort, t.Namespace, t.Name)
-	default:
-		p.l.Errorf("unknown target type: %T", target)
+	inCluster := p.enricher.Enrich(output, "ELASTICSEARCH", enrich.Endpoints{
+		SourceIP:   m.SourceIP,
+		SourcePort: m.SourcePort,
+		DestIP:     m.DestIP,
+		DestPort:   m.DestPort,
+	})
+	output.Tags["db_host"] = output.Tags["peer_address"]
+	// clusters outside of kubernetes (e.g. cloud elasticsearch) are still reported, like the other databases.
+	if !inCluster {
+		p.l.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		enrich.External(output, header(m.Headers, "Host"))
 	}
+	return output
+}
 
+// convertSOAP reports SOAP and XML-RPC calls as rpc calls.
+func (p *provider) convertSOAP(m *ebpf.Metric, call soap.Call) *metric.Metric {
+	fault := soap.Fault(call, m.StatusCode)
+	isError := fault != "" || p.status.failed(m.StatusCode)
+	output := &metric.Metric{
+		Name:        measurementGroupRPC,
+		Measurement: measurementGroupRPC,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"rpc_type":         call.Type,
+			"rpc_target":       call.Service + "." + call.Method,
+			"rpc_service":      call.Service,
+			"rpc_method":       call.Method,
+			"http_method":      m.Method,
+			"http_path":        p.routes.Normalize(m.Path),
+			"http_status_code": strconv.Itoa(int(m.StatusCode)),
+			"error":            strconv.FormatBool(isError),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if call.Version != "" {
+		output.Tags["soap_version"] = call.Version
+	}
+	if call.Action != "" {
+		output.Tags["soap_action"] = call.Action
+	}
+	if fault != "" {
+		output.Tags["soap_fault_code"] = fault
+	}
+
+	inCluster := p.enricher.Enrich(output, call.Type, enrich.Endpoints{
+		SourceIP:   m.SourceIP,
+		SourcePort: m.SourcePort,
+		DestIP:     m.DestIP,
+		DestPort:   m.DestPort,
+	})
+	if !inCluster {
+		p.l.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// targets are named after the host the client addressed, unresolved ones keep their surrogate identity
+		if !enrich.External(output, header(m.Headers, "Host")) && !enrich.Surrogate(output) {
+			return nil
+		}
+	}
+	return output
+}
+
+// convertGRPCWeb reports gRPC-Web calls as rpc calls.
+func (p *provider) convertGRPCWeb(m *ebpf.Metric, call grpcweb.Call) *metric.Metric {
+	status, hasStatus := grpcweb.ParseStatus(m.ResponseBody, call.Format)
+	// the status of the larger responses is not captured, fall back to the http status.
+	isError := (hasStatus && status.Code != 0) || p.status.failed(m.StatusCode)
+	output := &metric.Metric{
+		Name:        measurementGroupRPC,
+		Measurement: measurementGroupRPC,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"rpc_type":         "GRPC_WEB",
+			"rpc_target":       m.Path,
+			"rpc_service":      call.Service,
+			"rpc_method":       call.Method,
+			"grpc_web_format":  call.Format,
+			"http_method":      m.Method,
+			"http_path":        m.Path,
+			"http_status_code": strconv.Itoa(int(m.StatusCode)),
+			"error":            strconv.FormatBool(isError),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if call.RequestBytes >= 0 {
+		output.Fields["request_bytes"] = call.RequestBytes
+	}
+	if hasStatus {
+		code := strconv.Itoa(status.Code)
+		output.Tags["grpc_status_code"] = code
+		output.Tags["grpc_status"] = "OK"
+		if e, ok := p.errorCodes.Lookup(errorcodes.GRPC, code); ok {
+			output.Tags["grpc_status"] = e.Name
+		}
+		if status.Message != "" {
+			output.Tags["grpc_message"] = status.Message
+		}
+		if isError {
+			p.errorCodes.Tag(output.Tags, errorcodes.GRPC, code)
+		}
+	}
+
+	inCluster := p.enricher.Enrich(output, "GRPC_WEB", enrich.Endpoints{
+		SourceIP:   m.SourceIP,
+		SourcePort: m.SourcePort,
+		DestIP:     m.DestIP,
+		DestPort:   m.DestPort,
+	})
+	if !inCluster {
+		p.l.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// targets are named after the host the client addressed, unresolved ones keep their surrogate identity
+		if !enrich.External(output, header(m.Headers, "Host")) && !enrich.Surrogate(output) {
+			return nil
+		}
+	}
+	return output
+}
+
+// convertJSONRPC reports JSON-RPC calls as rpc calls.
+func (p *provider) convertJSONRPC(m *ebpf.Metric, call jsonrpc.Call) *metric.Metric {
+	rpcErr, hasErr := jsonrpc.ParseError(m.ResponseBody)
+	isError := hasErr || p.status.failed(m.StatusCode)
+	path := p.routes.Normalize(m.Path)
+	output := &metric.Metric{
+		Name:        measurementGroupRPC,
+		Measurement: measurementGroupRPC,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"rpc_type":         "JSONRPC",
+			"rpc_target":       path + "." + call.Method,
+			"rpc_service":      path,
+			"rpc_method":       call.Method,
+			"jsonrpc_batch":    strconv.FormatBool(call.Batch),
+			"http_method":      m.Method,
+			"http_path":        path,
+			"http_status_code": strconv.Itoa(int(m.StatusCode)),
+			"error":            strconv.FormatBool(isError),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if hasErr {
+		code := strconv.Itoa(rpcErr.Code)
+		output.Tags["jsonrpc_error_code"] = code
+		output.Tags["jsonrpc_error_message"] = rpcErr.Message
+		p.errorCodes.Tag(output.Tags, errorcodes.JSONRPC, code)
+	}
+
+	inCluster := p.enricher.Enrich(output, "JSONRPC", enrich.Endpoints{
+		SourceIP:   m.SourceIP,
+		SourcePort: m.SourcePort,
+		DestIP:     m.DestIP,
+		DestPort:   m.DestPort,
+	})
+	if !inCluster {
+		p.l.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// targets are named after the host the client addressed, unresolved ones keep their surrogate identity
+		if !enrich.External(output, header(m.Headers, "Host")) && !enrich.Surrogate(output) {
+			return nil
+		}
+	}
 	return output
 }
@@ -0,0 +1,44 @@
+package meta
+
+import (
+	"net/url"
+	"strings"
+)
+
+// sanitizer strips the sensitive parameters from the query strings.
+type sanitizer struct {
+	sensitive map[string]bool
+}
+
+func newSanitizer(params string) *sanitizer {
+	s := &sanitizer{sensitive: make(map[string]bool)}
+	for _, p := range strings.Split(params, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			s.sensitive[p] = true
+		}
+	}
+	return s
+}
+
+// sanitize returns the raw query without its sensitive parameters, the others are kept as sent and in order.
+func (s *sanitizer) sanitize(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	pairs := strings.Split(rawQuery, "&")
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if s.sensitive[strings.ToLower(key)] {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,21 @@
+package meta
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	s := newSanitizer("token, Password,api_key")
+	for query, want := range map[string]string{
+		"":                      "",
+		"id=1&page=2":           "id=1&page=2",
+		"id=1&token=abc&page=2": "id=1&page=2",
+		"PASSWORD=x&q=a%26b":    "q=a%26b",
+		"api%5Fkey=k&flag":      "flag",
+		"token=a&token=b":       "",
+		"a=1&&b=2":              "a=1&b=2",
+		"tokenized=1":           "tokenized=1",
+	} {
+		if got := s.sanitize(query); got != want {
+			t.Errorf("sanitize(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
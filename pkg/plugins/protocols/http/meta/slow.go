@@ -0,0 +1,69 @@
+package meta
+
+import (
+	"net"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+// slowAnnotation is the slow threshold of the requests to a pod, e.g. 500ms.
+const slowAnnotation = "ebpf.erda.cloud/http-slow-threshold"
+
+func (p *provider) Slow(output *metric.Metric) *metric.Metric {
+	if output.Measurement != measurementGroup && output.Measurement != measurementGroupError {
+		return nil
+	}
+	duration, ok := output.Fields["elapsed_sum"].(uint64)
+	if !ok {
+		return nil
+	}
+	threshold := p.slowThreshold(output.Tags["peer_address"])
+	if threshold <= 0 || time.Duration(duration) <= threshold {
+		return nil
+	}
+	slow := &metric.Metric{
+		Name:         measurementGroupDuration,
+		Measurement:  measurementGroupDuration,
+		Timestamp:    output.Timestamp,
+		OrgName:      output.OrgName,
+		CaptureTime:  output.CaptureTime,
+		SocketCookie: output.SocketCookie,
+		Tags:         make(map[string]string, len(output.Tags)),
+		Fields:       make(map[string]interface{}, len(output.Fields)+1),
+	}
+	for k, v := range output.Tags {
+		slow.Tags[k] = v
+	}
+	for k, v := range output.Fields {
+		slow.Fields[k] = v
+	}
+	slow.Fields["slow_threshold"] = threshold.Nanoseconds()
+	return slow
+}
+
+// slowThreshold returns the slow threshold of the target, the one of its pod annotation or the global one.
+func (p *provider) slowThreshold(peerAddress string) time.Duration {
+	host, _, err := net.SplitHostPort(peerAddress)
+	if err != nil {
+		return p.cfg.SlowThreshold
+	}
+	pod, err := p.k.GetPodByUID(host)
+	if err != nil {
+		return p.cfg.SlowThreshold
+	}
+	return podThreshold(pod.Annotations, p.cfg.SlowThreshold)
+}
+
+// podThreshold parses the slow annotation of a pod, def when it is missing or invalid.
+func podThreshold(annotations map[string]string, def time.Duration) time.Duration {
+	v, ok := annotations[slowAnnotation]
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,48 @@
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestSlow(t *testing.T) {
+	p := &provider{cfg: Config{SlowThreshold: time.Second}}
+	request := func(measurement string, duration uint64) *metric.Metric {
+		return &metric.Metric{
+			Measurement: measurement,
+			Tags:        map[string]string{"http_path": "/orders/{id}"},
+			Fields:      map[string]interface{}{"elapsed_sum": duration},
+		}
+	}
+	if p.Slow(request(measurementGroup, uint64(time.Second))) != nil {
+		t.Errorf("requests at the threshold are not slow")
+	}
+	slow := p.Slow(request(measurementGroupError, uint64(2*time.Second)))
+	if slow == nil || slow.Measurement != measurementGroupDuration || slow.Tags["http_path"] != "/orders/{id}" ||
+		slow.Fields["elapsed_sum"] != uint64(2*time.Second) || slow.Fields["slow_threshold"] != int64(time.Second) {
+		t.Fatalf("unexpected slow request %+v", slow)
+	}
+	if p.Slow(request(measurementGroupRPC, uint64(2*time.Second))) != nil {
+		t.Errorf("only http requests are reported slow")
+	}
+	p.cfg.SlowThreshold = 0
+	if p.Slow(request(measurementGroup, uint64(time.Hour))) != nil {
+		t.Errorf("a threshold of 0 disables the slow requests")
+	}
+
+	for annotation, want := range map[string]time.Duration{
+		"500ms": 500 * time.Millisecond,
+		"0":     0,
+		"fast":  time.Second,
+		"-1s":   time.Second,
+	} {
+		if got := podThreshold(map[string]string{slowAnnotation: annotation}, time.Second); got != want {
+			t.Errorf("podThreshold(%q) = %v, want %v", annotation, got, want)
+		}
+	}
+	if got := podThreshold(nil, time.Second); got != time.Second {
+		t.Errorf("pods without annotation have the global threshold, got %v", got)
+	}
+}
@@ -0,0 +1,95 @@
+package meta
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// statusClass is the class of an http status code under the status policy.
+type statusClass uint8
+
+const (
+	statusSuccess statusClass = iota
+	statusClientError
+	statusServerError
+)
+
+func (c statusClass) String() string {
+	switch c {
+	case statusClientError:
+		return "client_error"
+	case statusServerError:
+		return "server_error"
+	}
+	return "success"
+}
+
+type statusRange struct {
+	from, to uint16
+}
+
+// statusPolicy classifies the status codes, the codes of neither list are successes. The server errors
+// are errors, the client errors are reported in application_http_error as well but are not errors of the
+// server.
+type statusPolicy struct {
+	serverErrors []statusRange
+	clientErrors []statusRange
+}
+
+func newStatusPolicy(serverErrors, clientErrors string) (*statusPolicy, error) {
+	p := &statusPolicy{}
+	var err error
+	if p.serverErrors, err = parseStatusRanges(serverErrors); err != nil {
+		return nil, fmt.Errorf("HTTP_ERROR_STATUS: %v", err)
+	}
+	if p.clientErrors, err = parseStatusRanges(clientErrors); err != nil {
+		return nil, fmt.Errorf("HTTP_CLIENT_ERROR_STATUS: %v", err)
+	}
+	return p, nil
+}
+
+// parseStatusRanges parses comma separated codes and ranges of codes, e.g. 500-599,429.
+func parseStatusRanges(s string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+		f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		t, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if err != nil || t < f {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		ranges = append(ranges, statusRange{from: uint16(f), to: uint16(t)})
+	}
+	return ranges, nil
+}
+
+func (p *statusPolicy) classify(code uint16) statusClass {
+	// server errors win over client errors listed in both
+	for _, r := range p.serverErrors {
+		if code >= r.from && code <= r.to {
+			return statusServerError
+		}
+	}
+	for _, r := range p.clientErrors {
+		if code >= r.from && code <= r.to {
+			return statusClientError
+		}
+	}
+	return statusSuccess
+}
+
+// failed reports whether the request failed for the rpc and database calls over http, both classes of errors.
+func (p *statusPolicy) failed(code uint16) bool {
+	return p.classify(code) != statusSuccess
+}
@@ -0,0 +1,41 @@
+package meta
+
+import "testing"
+
+func TestStatusPolicy(t *testing.T) {
+	p, err := newStatusPolicy("500-599", "400-499")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[uint16]statusClass{
+		200: statusSuccess,
+		201: statusSuccess,
+		204: statusSuccess,
+		301: statusSuccess,
+		404: statusClientError,
+		429: statusClientError,
+		500: statusServerError,
+		503: statusServerError,
+	} {
+		if got := p.classify(code); got != want {
+			t.Errorf("classify(%d) = %v, want %v", code, got, want)
+		}
+	}
+
+	// rate limiting is an error of the server, not found a success
+	p, err = newStatusPolicy(" 500-599, 429 ", "400-403,405-499")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.classify(429) != statusServerError || p.classify(404) != statusSuccess || !p.failed(400) || p.failed(404) {
+		t.Errorf("unexpected classification %v %v", p.classify(429), p.classify(404))
+	}
+	if p, _ := newStatusPolicy("", ""); p.classify(500) != statusSuccess {
+		t.Errorf("empty lists classify nothing as error")
+	}
+	for _, s := range []string{"5xx", "599-500", "70000"} {
+		if _, err := newStatusPolicy(s, ""); err == nil {
+			t.Errorf("%q should be rejected", s)
+		}
+	}
+}
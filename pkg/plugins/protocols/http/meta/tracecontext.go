@@ -0,0 +1,113 @@
+package meta
+
+import (
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+// TraceHeaders are the request headers propagating the trace context of the instrumented services, the
+// heads of the requests are captured for them unless HTTP_TRACE_HEADERS=false. They are reported as
+// trace tags rather than http_request_header_ tags.
+var TraceHeaders = []string{"traceparent", "b3", "x-b3-traceid", "x-b3-spanid"}
+
+func isTraceHeader(name string) bool {
+	for _, h := range TraceHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceContext tags output with the trace context of the request, so that the request joins the trace
+// of the instrumented services: trace_id, and the span of the client the parent-id of traceparent (the
+// span id of B3) is, parent_span_id of the requests captured at the server, span_id of the requests
+// captured at the client. The W3C traceparent wins over the B3 single header, the B3 single header over
+// the X-B3- headers of Zipkin (e.g. Spring Cloud Sleuth, Istio).
+func traceContext(output *metric.Metric, m *ebpf.Metric) {
+	traceID, spanID, ok := parseTraceparent(requestHeader(m, "traceparent"))
+	if !ok {
+		traceID, spanID, ok = parseB3(requestHeader(m, "b3"))
+	}
+	if !ok {
+		traceID, spanID, ok = parseB3Multi(requestHeader(m, "x-b3-traceid"), requestHeader(m, "x-b3-spanid"))
+	}
+	if !ok {
+		return
+	}
+	output.Tags["trace_id"] = traceID
+	if m.Client {
+		output.Tags["span_id"] = spanID
+	} else {
+		output.Tags["parent_span_id"] = spanID
+	}
+}
+
+// requestHeader returns the header of the request line fragment, or of the captured head if the header
+// was cut off the fragment.
+func requestHeader(m *ebpf.Metric, name string) string {
+	if value := header(m.Headers, name); value != "" {
+		return value
+	}
+	return m.RequestHeaders[strings.ToLower(name)]
+}
+
+// parseTraceparent returns the trace id and the parent id of a traceparent header,
+// version-trace_id-parent_id-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+// Versions above 00 may append fields, the ids are lower case hex and not all zero.
+func parseTraceparent(value string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false
+	}
+	traceID, parentID := parts[1], parts[2]
+	if !isHex(traceID, 32) || !isHex(parentID, 16) || !isHex(parts[3], 2) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, parentID, true
+}
+
+// parseB3 returns the trace id and the span id of a b3 header, trace_id-span_id[-sampled[-parent_span_id]],
+// e.g. 80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90. The sampling decision alone
+// (e.g. b3: 0) carries no trace context.
+func parseB3(value string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return "", "", false
+	}
+	return parseB3Multi(parts[0], parts[1])
+}
+
+// parseB3Multi returns the trace id and the span id of the X-B3-TraceId and X-B3-SpanId headers, the
+// 64 bit trace ids are left padded to 128 bit like the W3C ones.
+func parseB3Multi(traceID, spanID string) (string, string, bool) {
+	traceID, spanID = strings.TrimSpace(traceID), strings.TrimSpace(spanID)
+	if isHex(traceID, 16) {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if !isHex(traceID, 32) || !isHex(spanID, 16) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, spanID, true
+}
+
+// isHex reports whether s is n lower case hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
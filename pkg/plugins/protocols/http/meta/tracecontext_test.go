@@ -0,0 +1,98 @@
+package meta
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, c := range []struct {
+		value   string
+		traceID string
+		spanID  string
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		// future versions may append fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "", ""},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", ""},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", "", ""},
+		{"", "", ""},
+	} {
+		traceID, spanID, ok := parseTraceparent(c.value)
+		if traceID != c.traceID || spanID != c.spanID || ok != (c.traceID != "") {
+			t.Errorf("parseTraceparent(%q) = %q, %q, %v", c.value, traceID, spanID, ok)
+		}
+	}
+}
+
+func TestParseB3(t *testing.T) {
+	for _, c := range []struct {
+		value   string
+		traceID string
+		spanID  string
+	}{
+		{"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90", "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1", "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd1-d", "000000000000000064fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{"0", "", ""},
+		{"64fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90-x", "", ""},
+		{"0000000000000000-e457b5a2e4d86bd1", "", ""},
+		{"64fe8b2a57d3eff-e457b5a2e4d86bd1", "", ""},
+	} {
+		traceID, spanID, ok := parseB3(c.value)
+		if traceID != c.traceID || spanID != c.spanID || ok != (c.traceID != "") {
+			t.Errorf("parseB3(%q) = %q, %q, %v", c.value, traceID, spanID, ok)
+		}
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	server := &metric.Metric{Tags: map[string]string{}}
+	traceContext(server, &ebpf.Metric{Headers: map[string]string{"Traceparent": traceparent}})
+	if server.Tags["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Tags["parent_span_id"] != "00f067aa0ba902b7" ||
+		server.Tags["span_id"] != "" {
+		t.Errorf("the client span is the parent of the server request, got %v", server.Tags)
+	}
+
+	// the header was cut off the request line fragment, it is read from the captured head.
+	client := &metric.Metric{Tags: map[string]string{}}
+	traceContext(client, &ebpf.Metric{
+		Client:         true,
+		Headers:        map[string]string{"Host": "api"},
+		RequestHeaders: map[string]string{"traceparent": traceparent},
+	})
+	if client.Tags["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || client.Tags["span_id"] != "00f067aa0ba902b7" ||
+		client.Tags["parent_span_id"] != "" {
+		t.Errorf("the client span is the request captured at the client, got %v", client.Tags)
+	}
+
+	// Zipkin instrumented clients, traceparent wins over b3 and b3 over the X-B3- headers.
+	for _, c := range []struct {
+		headers map[string]string
+		traceID string
+	}{
+		{map[string]string{"X-B3-TraceId": "64fe8b2a57d3eff7", "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Sampled": "1"}, "000000000000000064fe8b2a57d3eff7"},
+		{map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-00f067aa0ba902b7-1", "X-B3-TraceId": "64fe8b2a57d3eff7", "X-B3-SpanId": "00f067aa0ba902b7"}, "80f198ee56343ba864fe8b2a57d3eff7"},
+		{map[string]string{"traceparent": traceparent, "b3": "80f198ee56343ba864fe8b2a57d3eff7-00f067aa0ba902b7-1"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+	} {
+		output := &metric.Metric{Tags: map[string]string{}}
+		traceContext(output, &ebpf.Metric{Headers: c.headers})
+		if output.Tags["trace_id"] != c.traceID || output.Tags["parent_span_id"] != "00f067aa0ba902b7" {
+			t.Errorf("unexpected trace context of %v: %v", c.headers, output.Tags)
+		}
+	}
+
+	none := &metric.Metric{Tags: map[string]string{}}
+	traceContext(none, &ebpf.Metric{Headers: map[string]string{"Traceparent": "invalid"}})
+	if len(none.Tags) != 0 {
+		t.Errorf("invalid trace context, got %v", none.Tags)
+	}
+}
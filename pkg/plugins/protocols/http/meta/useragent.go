@@ -0,0 +1,60 @@
+package meta
+
+import "strings"
+
+// userAgentClass is a rule of the classification, the user agents containing one of the tokens (lower case)
+// belong to the class.
+type userAgentClass struct {
+	name   string
+	tokens []string
+}
+
+// userAgentClasses are tried in order, the bots and the clients identify themselves as browsers as well.
+var userAgentClasses = []userAgentClass{
+	{"bot", []string{"bot", "spider", "crawler", "slurp"}},
+	{"curl", []string{"curl/", "wget/"}},
+	{"java-http-client", []string{"java/", "java-http-client", "apache-httpclient", "okhttp", "reactornetty", "jersey", "feign"}},
+	{"go-http-client", []string{"go-http-client", "fasthttp", "resty"}},
+	{"python-http-client", []string{"python-requests", "python-urllib", "python-httpx", "aiohttp"}},
+	{"node-http-client", []string{"node-fetch", "axios", "undici", "got ("}},
+	{"browser", []string{"mozilla/"}},
+}
+
+// userAgentClassifier reduces the User-Agent headers to a class (browser, curl, java-http-client ...), the raw
+// strings are not reported.
+type userAgentClassifier struct {
+	// internal are the tokens of the services of the platform, e.g. the probes of kubernetes.
+	internal []string
+}
+
+func newUserAgentClassifier(internal string) *userAgentClassifier {
+	c := &userAgentClassifier{}
+	for _, token := range strings.Split(internal, ",") {
+		if token = strings.ToLower(strings.TrimSpace(token)); token != "" {
+			c.internal = append(c.internal, token)
+		}
+	}
+	return c
+}
+
+// classify returns the class of the user agent: internal-service, one of userAgentClasses, none when the
+// header is missing and other.
+func (c *userAgentClassifier) classify(userAgent string) string {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		return "none"
+	}
+	for _, token := range c.internal {
+		if strings.Contains(ua, token) {
+			return "internal-service"
+		}
+	}
+	for _, class := range userAgentClasses {
+		for _, token := range class.tokens {
+			if strings.Contains(ua, token) {
+				return class.name
+			}
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,26 @@
+package meta
+
+import "testing"
+
+func TestClassifyUserAgent(t *testing.T) {
+	c := newUserAgentClassifier("kube-probe, Erda-")
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36": "browser",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                "bot",
+		"curl/8.4.0":               "curl",
+		"Java-http-client/17.0.2":  "java-http-client",
+		"Apache-HttpClient/4.5.13": "java-http-client",
+		"okhttp/4.12.0":            "java-http-client",
+		"Go-http-client/1.1":       "go-http-client",
+		"python-requests/2.31.0":   "python-http-client",
+		"axios/1.6.2":              "node-http-client",
+		"kube-probe/1.28":          "internal-service",
+		"erda-orchestrator/2.4":    "internal-service",
+		"PostmanRuntime/7.36.0":    "other",
+		"":                         "none",
+	} {
+		if got := c.classify(ua); got != want {
+			t.Errorf("classify(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
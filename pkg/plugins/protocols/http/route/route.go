@@ -0,0 +1,147 @@
+// Package route normalizes the http paths into route templates, so that the metrics of /orders/12345
+// and /orders/67890 aggregate on /orders/{id} instead of exploding the cardinality of http_path.
+//
+// The rules of HTTP_ROUTE_RULES are tried first, in order. The pattern is a regular expression matched
+// against the path, the matched part is replaced by the route ($1 expands to the first group):
+//
+//	HTTP_ROUTE_RULES='[
+//	  {"pattern": "^/users/[^/]+/avatar$", "route": "/users/{user}/avatar"},
+//	  {"pattern": "^/static/.*", "route": "/static/*"}]'
+//
+// The segments of the paths matching no rule are collapsed unless HTTP_ROUTE_AUTO=false:
+// numbers become {id}, uuids {uuid}, and long hex strings (e.g. object ids, digests) {hex}.
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+type Config struct {
+	// Rules are the Rule list in json.
+	Rules string `env:"HTTP_ROUTE_RULES"`
+	// Auto collapses the numeric, uuid and hex segments of the paths matching no rule.
+	Auto bool `env:"HTTP_ROUTE_AUTO" default:"true"`
+}
+
+// Rule replaces the part of the paths matching Pattern by Route.
+type Rule struct {
+	Pattern string `json:"pattern"`
+	Route   string `json:"route"`
+}
+
+type Interface interface {
+	// Normalize returns the route template of the path.
+	Normalize(path string) string
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	route   string
+}
+
+type normalizer struct {
+	rules []rule
+	auto  bool
+}
+
+// New loads the rules from the environment.
+func New() (Interface, error) {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	var rules []Rule
+	if cfg.Rules != "" {
+		if err := json.Unmarshal([]byte(cfg.Rules), &rules); err != nil {
+			return nil, fmt.Errorf("invalid HTTP_ROUTE_RULES: %v", err)
+		}
+	}
+	n, err := newNormalizer(rules, cfg.Auto)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_ROUTE_RULES: %v", err)
+	}
+	return n, nil
+}
+
+func newNormalizer(rules []Rule, auto bool) (*normalizer, error) {
+	n := &normalizer{auto: auto}
+	for _, r := range rules {
+		if r.Route == "" {
+			return nil, fmt.Errorf("pattern %q has no route", r.Pattern)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", r.Pattern, err)
+		}
+		n.rules = append(n.rules, rule{pattern: re, route: r.Route})
+	}
+	return n, nil
+}
+
+func (n *normalizer) Normalize(path string) string {
+	for _, r := range n.rules {
+		if r.pattern.MatchString(path) {
+			return r.pattern.ReplaceAllString(path, r.route)
+		}
+	}
+	if !n.auto {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if placeholder, ok := collapse(s); ok {
+			segments[i] = placeholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// collapse returns the placeholder of a variable segment.
+func collapse(s string) (string, bool) {
+	switch {
+	case s == "":
+		return "", false
+	case isDigits(s):
+		return "{id}", true
+	case isUUID(s):
+		return "{uuid}", true
+	case len(s) >= 16 && isHex(s) && strings.ContainsAny(s, "0123456789"):
+		return "{hex}", true
+	}
+	return "", false
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i] | 0x20
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUID matches the 8-4-4-4-12 hex form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, part := range strings.Split(s, "-") {
+		if len(part) != []int{8, 4, 4, 4, 12}[min(i, 4)] || !isHex(part) {
+			return false
+		}
+	}
+	return strings.Count(s, "-") == 4
+}
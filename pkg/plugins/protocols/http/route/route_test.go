@@ -0,0 +1,42 @@
+package route
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	n, err := newNormalizer([]Rule{
+		{Pattern: "^/users/[^/]+/avatar$", Route: "/users/{user}/avatar"},
+		{Pattern: "^/static/.*", Route: "/static/*"},
+		{Pattern: "^/v([0-9]+)/legacy/.*", Route: "/v$1/legacy"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"/orders/12345":                               "/orders/{id}",
+		"/orders/12345/items/7":                       "/orders/{id}/items/{id}",
+		"/carts/3f2504e0-4f89-11d3-9a0c-0305e82c3301": "/carts/{uuid}",
+		"/objects/507f1f77bcf86cd799439011":           "/objects/{hex}",
+		"/api/v1/users":                               "/api/v1/users",
+		"/api/deadbeefdeadbeef":                       "/api/deadbeefdeadbeef",
+		"/users/alice/avatar":                         "/users/{user}/avatar",
+		"/static/js/app.3f2504e0.js":                  "/static/*",
+		"/v2/legacy/a/b":                              "/v2/legacy",
+		"/":                                           "/",
+		"/3f2504e0-4f89-11d3-9a0c-0305e82c3301-x":     "/3f2504e0-4f89-11d3-9a0c-0305e82c3301-x",
+	} {
+		if got := n.Normalize(path); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	manual, _ := newNormalizer(nil, false)
+	if got := manual.Normalize("/orders/12345"); got != "/orders/12345" {
+		t.Errorf("the segments should only be collapsed automatically with auto, got %q", got)
+	}
+	if _, err := newNormalizer([]Rule{{Pattern: "(", Route: "/x"}}, true); err == nil {
+		t.Errorf("an invalid pattern should be rejected")
+	}
+	if _, err := newNormalizer([]Rule{{Pattern: "^/x"}}, true); err == nil {
+		t.Errorf("a rule without route should be rejected")
+	}
+}
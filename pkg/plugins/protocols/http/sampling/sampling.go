@@ -0,0 +1,197 @@
+// Package sampling keeps a configurable share of the HTTP requests per endpoint,
+// e.g. all requests to /checkout and 1% of the requests to /healthz.
+//
+// The rules are loaded from HTTP_SAMPLING_RULES at start and replaced live through the local
+// debug server of the agent (localhost:8777, next to pprof):
+//
+//	curl localhost:8777/debug/sampling                 # current rules
+//	curl -XPUT localhost:8777/debug/sampling -d '{
+//	  "default": 1,
+//	  "rules": [
+//	    {"path": "/checkout", "weight": 1},
+//	    {"path": "/healthz", "weight": 0.01},
+//	    {"service": "user-service", "path": "/api/*", "weight": 0.1}]}'
+//
+// A path is either exact or a prefix ending with *, the query string of a request is ignored.
+// The paths of the requests are their route templates (e.g. /orders/{id}), see the route package.
+// The rule with the longest path wins, a rule of the service wins over a rule of all services
+// with the same path. Requests matching no rule keep the default weight.
+//
+// Paths are only known after the request is decoded, so the decision is made in user space
+// before the metric is reported. Kept requests with a weight below 1 carry the weight in the
+// sample_rate field, the backend divides the counts by it.
+package sampling
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+const adminPath = "/debug/sampling"
+
+type Config struct {
+	// Rules is the initial Policy in json.
+	Rules string `env:"HTTP_SAMPLING_RULES"`
+}
+
+// Rule sets the share of the requests to an endpoint that is kept.
+type Rule struct {
+	// Service is the target service name, all services when empty.
+	Service string `json:"service,omitempty"`
+	Path    string `json:"path"`
+	// Weight is the share of the requests kept, in [0, 1].
+	Weight float64 `json:"weight"`
+}
+
+type Policy struct {
+	// Default is the weight of the requests matching no rule, 1 when not set.
+	Default *float64 `json:"default,omitempty"`
+	Rules   []Rule   `json:"rules"`
+}
+
+type Interface interface {
+	// Sample returns whether the request to the endpoint is kept and its weight.
+	Sample(service, path string) (float64, bool)
+}
+
+type sampler struct {
+	sync.RWMutex
+	policy Policy
+	// rules are sorted by precedence.
+	rules  []Rule
+	def    float64
+	random func() float64
+}
+
+// New loads the initial rules from the environment and serves the rules on the debug server.
+func New() (Interface, error) {
+	cfg := Config{}
+	envconf.MustLoad(&cfg)
+	s := newSampler()
+	if cfg.Rules != "" {
+		var policy Policy
+		if err := json.Unmarshal([]byte(cfg.Rules), &policy); err != nil {
+			return nil, fmt.Errorf("invalid HTTP_SAMPLING_RULES: %v", err)
+		}
+		if err := s.set(policy); err != nil {
+			return nil, fmt.Errorf("invalid HTTP_SAMPLING_RULES: %v", err)
+		}
+	}
+	// served by the debug server of the agent on localhost only.
+	http.HandleFunc(adminPath, s.serveAdmin)
+	return s, nil
+}
+
+func newSampler() *sampler {
+	return &sampler{def: 1, random: rand.Float64}
+}
+
+func (s *sampler) Sample(service, path string) (float64, bool) {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	s.RLock()
+	weight := s.def
+	for _, r := range s.rules {
+		if r.Service != "" && r.Service != service {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(r.Path, "*"); (ok && strings.HasPrefix(path, prefix)) || r.Path == path {
+			weight = r.Weight
+			break
+		}
+	}
+	s.RUnlock()
+	switch {
+	case weight >= 1:
+		return 1, true
+	case weight <= 0:
+		return 0, false
+	}
+	return weight, s.random() < weight
+}
+
+// set validates the policy and replaces the rules.
+func (s *sampler) set(policy Policy) error {
+	def := 1.0
+	if policy.Default != nil {
+		def = *policy.Default
+	}
+	if def < 0 || def > 1 {
+		return fmt.Errorf("default weight %v is not in [0, 1]", def)
+	}
+	seen := make(map[[2]string]bool)
+	for _, r := range policy.Rules {
+		if r.Path == "" || r.Path[0] != '/' {
+			return fmt.Errorf("path %q must start with /", r.Path)
+		}
+		if strings.Contains(strings.TrimSuffix(r.Path, "*"), "*") {
+			return fmt.Errorf("path %q may only end with *", r.Path)
+		}
+		if r.Weight < 0 || r.Weight > 1 {
+			return fmt.Errorf("weight %v of %s is not in [0, 1]", r.Weight, r.Path)
+		}
+		key := [2]string{r.Service, r.Path}
+		if seen[key] {
+			return fmt.Errorf("duplicate rule for service %q and path %s", r.Service, r.Path)
+		}
+		seen[key] = true
+	}
+	rules := append([]Rule(nil), policy.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		// the longest path first, an exact path before the prefix of the same length
+		li, lj := len(strings.TrimSuffix(rules[i].Path, "*")), len(strings.TrimSuffix(rules[j].Path, "*"))
+		if li != lj {
+			return li > lj
+		}
+		ei, ej := !strings.HasSuffix(rules[i].Path, "*"), !strings.HasSuffix(rules[j].Path, "*")
+		if ei != ej {
+			return ei
+		}
+		return rules[i].Service != "" && rules[j].Service == ""
+	})
+	policy.Default = &def
+	policy.Rules = append([]Rule(nil), policy.Rules...)
+	s.Lock()
+	s.policy, s.rules, s.def = policy, rules, def
+	s.Unlock()
+	return nil
+}
+
+func (s *sampler) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var policy Policy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.set(policy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.RLock()
+	policy := s.policy
+	s.RUnlock()
+	if policy.Default == nil {
+		def := 1.0
+		policy.Default = &def
+	}
+	if policy.Rules == nil {
+		policy.Rules = []Rule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(policy)
+}
@@ -0,0 +1,87 @@
+package sampling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	s := newSampler()
+	s.random = func() float64 { return 0.05 }
+	def := 0.5
+	if err := s.set(Policy{
+		Default: &def,
+		Rules: []Rule{
+			{Path: "/api/*", Weight: 0.2},
+			{Path: "/api/checkout", Weight: 1},
+			{Path: "/healthz", Weight: 0.01},
+			{Service: "user-service", Path: "/api/*", Weight: 0},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		service, path string
+		weight        float64
+		keep          bool
+	}{
+		{"order-service", "/api/checkout?id=1", 1, true},
+		{"user-service", "/api/checkout", 1, true},
+		{"order-service", "/api/orders", 0.2, true},
+		{"user-service", "/api/users", 0, false},
+		{"order-service", "/healthz", 0.01, false},
+		{"order-service", "/healthz/live", 0.5, true},
+		{"order-service", "/", 0.5, true},
+	}
+	for _, c := range cases {
+		weight, keep := s.Sample(c.service, c.path)
+		if weight != c.weight || keep != c.keep {
+			t.Errorf("%s %s: got %v, %v, want %v, %v", c.service, c.path, weight, keep, c.weight, c.keep)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, policy := range []Policy{
+		{Rules: []Rule{{Path: "api", Weight: 1}}},
+		{Rules: []Rule{{Path: "/api/*/users", Weight: 1}}},
+		{Rules: []Rule{{Path: "/api", Weight: 2}}},
+		{Rules: []Rule{{Path: "/api", Weight: 1}, {Path: "/api", Weight: 0.5}}},
+	} {
+		if err := newSampler().set(policy); err == nil {
+			t.Errorf("policy %+v should be rejected", policy)
+		}
+	}
+}
+
+func TestServeAdmin(t *testing.T) {
+	s := newSampler()
+	put := httptest.NewRequest(http.MethodPut, adminPath, strings.NewReader(`{"rules": [{"path": "/healthz", "weight": 0}]}`))
+	w := httptest.NewRecorder()
+	s.serveAdmin(w, put)
+	if w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	if _, keep := s.Sample("", "/healthz"); keep {
+		t.Error("the rules should apply live")
+	}
+
+	w = httptest.NewRecorder()
+	s.serveAdmin(w, httptest.NewRequest(http.MethodGet, adminPath, nil))
+	var policy Policy
+	if err := json.NewDecoder(w.Body).Decode(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Default == nil || *policy.Default != 1 || len(policy.Rules) != 1 || policy.Rules[0].Path != "/healthz" {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+
+	w = httptest.NewRecorder()
+	s.serveAdmin(w, httptest.NewRequest(http.MethodPut, adminPath, strings.NewReader(`{"default": 3}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid policy: got %d", w.Code)
+	}
+}
@@ -0,0 +1,147 @@
+// Package soap recognizes SOAP and XML-RPC calls among the HTTP requests, so that the legacy
+// services using them are reported as rpc calls, see https://www.w3.org/TR/soap/ and
+// http://xmlrpc.com/spec.md
+//
+// Only the request line, the first headers and a part of the body are captured by the kernel:
+// the start of the body if it is sent apart from the headers, the end of the first packet otherwise.
+// The method of a SOAP call is taken from the SOAPAction header (the action parameter of the content
+// type for SOAP 1.2), or from the first element of the body, which is also found by its closing tag at
+// the end of the envelope. The method of an XML-RPC call is its methodName.
+//
+// Response bodies are not captured, faults are told by the status code instead: the SOAP HTTP binding
+// answers every fault with 500, SOAP 1.2 answers the faults of the sender with 400. XML-RPC faults are
+// answered with 200 and are not detected.
+package soap
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	TypeSOAP   = "SOAP"
+	TypeXMLRPC = "XMLRPC"
+
+	Version11 = "1.1"
+	Version12 = "1.2"
+
+	namespace11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	namespace12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// Call is a classified SOAP or XML-RPC call.
+type Call struct {
+	// Type is SOAP or XMLRPC.
+	Type string
+	// Version is the SOAP version, empty if neither the headers nor the captured body tell it.
+	Version string
+	// Action is the SOAPAction of the call, empty if it is not captured.
+	Action string
+	// Service is the prefix of dotted XML-RPC method names, the path of the endpoint otherwise.
+	Service string
+	Method  string
+}
+
+var (
+	envelope  = regexp.MustCompile(`<(?:[\w.-]+:)?Envelope[\s>]|</(?:[\w.-]+:)?Envelope>`)
+	bodyStart = regexp.MustCompile(`<(?:[\w.-]+:)?Body(?:\s[^>]*)?>\s*(?:<!--.*?-->\s*)*<(?:[\w.-]+:)?([A-Za-z_][\w.-]*)`)
+	// bodyEnd matches the closing tag of the last element of the body, the element of the operation
+	// in the document/literal and rpc styles.
+	bodyEnd    = regexp.MustCompile(`</(?:[\w.-]+:)?([A-Za-z_][\w.-]*)>\s*</(?:[\w.-]+:)?Body>`)
+	methodCall = regexp.MustCompile(`<methodCall>\s*<methodName>\s*([^<\s]+)\s*</methodName>`)
+)
+
+// Classify returns the SOAP or XML-RPC call of an HTTP request, if its method is captured.
+func Classify(method, path string, headers map[string]string, body []byte) (Call, bool) {
+	if method != http.MethodPost {
+		return Call{}, false
+	}
+	if m := methodCall.FindSubmatch(body); m != nil {
+		call := Call{Type: TypeXMLRPC, Service: path, Method: string(m[1])}
+		if i := strings.LastIndexByte(call.Method, '.'); i > 0 && i < len(call.Method)-1 {
+			call.Service, call.Method = call.Method[:i], call.Method[i+1:]
+		}
+		return call, true
+	}
+
+	action, hasAction := header(headers, "SOAPAction")
+	contentType, _ := header(headers, "Content-Type")
+	isSOAP12 := strings.HasPrefix(strings.ToLower(contentType), "application/soap+xml")
+	if !hasAction && !isSOAP12 && !envelope.Match(body) {
+		return Call{}, false
+	}
+	call := Call{Type: TypeSOAP, Service: path, Action: strings.Trim(action, `"`)}
+	switch {
+	case isSOAP12 || bytes.Contains(body, []byte(namespace12)):
+		call.Version = Version12
+		if call.Action == "" {
+			call.Action = contentTypeAction(contentType)
+		}
+	case hasAction || bytes.Contains(body, []byte(namespace11)):
+		call.Version = Version11
+	}
+	call.Method = actionMethod(call.Action)
+	if call.Method == "" {
+		if m := bodyStart.FindSubmatch(body); m != nil {
+			call.Method = string(m[1])
+		} else if m := bodyEnd.FindSubmatch(body); m != nil {
+			call.Method = string(m[1])
+		}
+	}
+	if call.Method == "" {
+		return Call{}, false
+	}
+	return call, true
+}
+
+// Fault returns the fault code of a SOAP response with the given status code, empty for successful responses.
+func Fault(call Call, statusCode uint16) string {
+	if call.Type != TypeSOAP {
+		return ""
+	}
+	switch {
+	case statusCode == http.StatusInternalServerError && call.Version == Version12:
+		return "Receiver"
+	case statusCode == http.StatusInternalServerError:
+		return "Server"
+	case statusCode == http.StatusBadRequest && call.Version == Version12:
+		return "Sender"
+	}
+	return ""
+}
+
+// actionMethod returns the operation of a SOAPAction URI, its last path segment or fragment,
+// e.g. GetPrice of http://tempuri.org/IStockService/GetPrice or urn:stock#GetPrice.
+func actionMethod(action string) string {
+	action = strings.TrimRight(action, "/")
+	if i := strings.LastIndexAny(action, "/#:"); i >= 0 {
+		action = action[i+1:]
+	}
+	return action
+}
+
+// contentTypeAction returns the action parameter of a SOAP 1.2 content type.
+func contentTypeAction(contentType string) string {
+	for _, param := range strings.Split(contentType, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(k, "action") {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+// header looks up a header case-insensitively, the captured headers keep the case they were sent with.
+func header(headers map[string]string, name string) (string, bool) {
+	if v, ok := headers[name]; ok {
+		return v, true
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
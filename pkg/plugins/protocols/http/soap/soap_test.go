@@ -0,0 +1,71 @@
+package soap
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		body    string
+		want    Call
+		ok      bool
+	}{
+		{"soap action", "POST", map[string]string{"SOAPAction": `"http://tempuri.org/IStockService/GetPrice"`}, "",
+			Call{Type: TypeSOAP, Version: Version11, Action: "http://tempuri.org/IStockService/GetPrice", Service: "/ws/stock", Method: "GetPrice"}, true},
+		{"soap action fragment", "POST", map[string]string{"soapaction": "urn:stock#GetQuote"}, "",
+			Call{Type: TypeSOAP, Version: Version11, Action: "urn:stock#GetQuote", Service: "/ws/stock", Method: "GetQuote"}, true},
+		{"empty soap action", "POST", map[string]string{"SOAPAction": `""`},
+			`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Header/><soap:Body>` +
+				`<!-- order --><m:PlaceOrder xmlns:m="urn:orders"><m:sku>a</m:sku>`,
+			Call{Type: TypeSOAP, Version: Version11, Service: "/ws/stock", Method: "PlaceOrder"}, true},
+		{"soap 1.2 content type", "POST", map[string]string{"Content-Type": `application/soap+xml; charset=utf-8; action="urn:stock/GetPrice"`}, "",
+			Call{Type: TypeSOAP, Version: Version12, Action: "urn:stock/GetPrice", Service: "/ws/stock", Method: "GetPrice"}, true},
+		// the end of a large envelope sent with its headers in the first packet.
+		{"envelope end", "POST", nil, `ice>12.5</m:Price></m:UpdatePrice>
+  </soapenv:Body>
+</soapenv:Envelope>`,
+			Call{Type: TypeSOAP, Service: "/ws/stock", Method: "UpdatePrice"}, true},
+		{"soap 1.2 envelope", "POST", nil, `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><GetStatus/></env:Body></env:Envelope>`,
+			Call{Type: TypeSOAP, Version: Version12, Service: "/ws/stock", Method: "GetStatus"}, true},
+		{"xml-rpc", "POST", map[string]string{"Content-Type": "text/xml"}, `<?xml version="1.0"?>
+<methodCall>
+  <methodName>examples.getStateName</methodName>`,
+			Call{Type: TypeXMLRPC, Service: "examples", Method: "getStateName"}, true},
+		{"xml-rpc without service", "POST", nil, `<methodCall><methodName>ping</methodName></methodCall>`,
+			Call{Type: TypeXMLRPC, Service: "/ws/stock", Method: "ping"}, true},
+		{"envelope without method", "POST", map[string]string{"Content-Type": "text/xml"}, `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Hea`,
+			Call{}, false},
+		{"plain xml", "POST", map[string]string{"Content-Type": "text/xml"}, `<order><sku>a</sku></order>`, Call{}, false},
+		{"get", "GET", map[string]string{"SOAPAction": "urn:GetPrice"}, "", Call{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Classify(c.method, "/ws/stock", c.headers, []byte(c.body))
+		if ok != c.ok || got != c.want {
+			t.Errorf("%s: Classify() = %+v, %v, want %+v, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestFault(t *testing.T) {
+	soap11 := Call{Type: TypeSOAP, Version: Version11}
+	soap12 := Call{Type: TypeSOAP, Version: Version12}
+	cases := []struct {
+		call   Call
+		status uint16
+		want   string
+	}{
+		{soap11, 200, ""},
+		{soap11, 500, "Server"},
+		{Call{Type: TypeSOAP}, 500, "Server"},
+		{soap11, 400, ""},
+		{soap12, 500, "Receiver"},
+		{soap12, 400, "Sender"},
+		{Call{Type: TypeXMLRPC}, 500, ""},
+	}
+	for _, c := range cases {
+		if got := Fault(c.call, c.status); got != c.want {
+			t.Errorf("Fault(%+v, %d) = %q, want %q", c.call, c.status, got, c.want)
+		}
+	}
+}
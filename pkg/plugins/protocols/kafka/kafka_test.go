@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+)
+
+// kafkaEvent returns an entry of kafka_event as written by kafka_batch_enqueue_wrapper, the key is the
+// sock_key of the connection from the client to the broker, the value the kafka_transaction_t.
+func kafkaEvent(client string, clientPort uint16, broker string, brokerPort uint16, tx Transaction) ([]byte, []byte) {
+	key := make([]byte, 12)
+	copy(key[0:4], net.ParseIP(client).To4())
+	copy(key[4:8], net.ParseIP(broker).To4())
+	binary.LittleEndian.PutUint16(key[8:10], clientPort)
+	binary.LittleEndian.PutUint16(key[10:12], brokerPort)
+
+	val := make([]byte, 112)
+	binary.LittleEndian.PutUint64(val[0:8], tx.Duration)
+	binary.LittleEndian.PutUint64(val[8:16], tx.Cookie)
+	binary.LittleEndian.PutUint32(val[16:20], tx.RecordCount)
+	binary.LittleEndian.PutUint16(val[20:22], uint16(tx.ErrorCode))
+	val[22] = tx.RequestApiKey
+	val[23] = tx.RequestApiVersion
+	val[24] = uint8(len(tx.TopicName))
+	copy(val[25:], tx.TopicName)
+	return key, val
+}
+
+// fakeEnricher records the endpoints of the metrics, the brokers in 10.0.0.0/8 are in the cluster.
+type fakeEnricher struct {
+	enrich.Interface
+	endpoints []enrich.Endpoints
+}
+
+func (f *fakeEnricher) Enrich(m *metric.Metric, component string, e enrich.Endpoints) bool {
+	f.endpoints = append(f.endpoints, e)
+	m.Tags["peer_address"] = fmt.Sprintf("%s:%d", e.DestIP, e.DestPort)
+	return net.ParseIP(e.DestIP).To4()[0] == 10
+}
+
+func TestConvert2Metric(t *testing.T) {
+	cases := []struct {
+		name       string
+		client     string
+		broker     string
+		tx         Transaction
+		spanKind   string
+		status     string
+		errorCode  string
+		elapsed    uint64
+		records    uint32
+		apiName    string
+		apiVersion string
+	}{
+		{
+			name:   "produce paired with its response",
+			client: "10.0.1.2", broker: "10.0.1.5",
+			tx:       Transaction{Duration: uint64(3 * time.Millisecond), Cookie: 7, RecordCount: 2, RequestApiKey: apiKeyProduce, RequestApiVersion: 7, TopicName: "orders"},
+			spanKind: "producer", status: "PUBLISH_SUCCESS", elapsed: uint64(3 * time.Millisecond), records: 2,
+			apiName: "produce", apiVersion: "7",
+		},
+		{
+			name:   "produce with acks=0 has no response",
+			client: "10.0.1.2", broker: "10.0.1.5",
+			tx:       Transaction{RecordCount: 1, RequestApiKey: apiKeyProduce, RequestApiVersion: 9, TopicName: "audit"},
+			spanKind: "producer", status: "PUBLISH_SUCCESS", elapsed: 0, records: 1,
+			apiName: "produce", apiVersion: "9",
+		},
+		{
+			name:   "fetch paired with its response",
+			client: "10.0.1.3", broker: "10.0.1.5",
+			tx:       Transaction{Duration: uint64(500 * time.Millisecond), RecordCount: 10, RequestApiKey: apiKeyFetch, RequestApiVersion: 11, TopicName: "orders"},
+			spanKind: "consumer", status: "CONSUME_SUCCESS", elapsed: uint64(500 * time.Millisecond), records: 10,
+			apiName: "fetch", apiVersion: "11",
+		},
+		{
+			name:   "produce to an unknown topic",
+			client: "10.0.1.2", broker: "10.0.1.5",
+			tx:       Transaction{Duration: uint64(2 * time.Millisecond), ErrorCode: 3, RecordCount: 1, RequestApiKey: apiKeyProduce, RequestApiVersion: 9, TopicName: "missing"},
+			spanKind: "producer", status: "PUBLISH_FAILED", errorCode: "3", elapsed: uint64(2 * time.Millisecond), records: 1,
+			apiName: "produce", apiVersion: "9",
+		},
+		{
+			name:   "fetch from a partition moved to another broker",
+			client: "10.0.1.3", broker: "10.0.1.5",
+			tx:       Transaction{Duration: uint64(time.Millisecond), ErrorCode: 6, RequestApiKey: apiKeyFetch, RequestApiVersion: 12, TopicName: "orders"},
+			spanKind: "consumer", status: "CONSUME_FAILED", errorCode: "6", elapsed: uint64(time.Millisecond),
+			apiName: "fetch", apiVersion: "12",
+		},
+		{
+			name:   "broker outside the cluster",
+			client: "10.0.1.2", broker: "52.1.2.3",
+			tx:       Transaction{Duration: uint64(20 * time.Millisecond), RecordCount: 1, RequestApiKey: apiKeyProduce, RequestApiVersion: 7, TopicName: "events"},
+			spanKind: "producer", status: "PUBLISH_SUCCESS", elapsed: uint64(20 * time.Millisecond), records: 1,
+			apiName: "produce", apiVersion: "7",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ev, err := decodeEvent(kafkaEvent(c.client, 41000, c.broker, 9092, c.tx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &fakeEnricher{}
+			now := time.Unix(1700000000, 0)
+			p := &provider{enricher: f, clock: clock.NewFake(now)}
+			m := p.convert2Metric(&ev)
+			if m.Timestamp != now.UnixNano() {
+				t.Errorf("timestamp = %d, want %d", m.Timestamp, now.UnixNano())
+			}
+
+			// the connection is oriented from the client to the broker.
+			want := enrich.Endpoints{SourceIP: c.client, SourcePort: 41000, DestIP: c.broker, DestPort: 9092, SocketCookie: c.tx.Cookie}
+			if len(f.endpoints) != 1 || f.endpoints[0] != want {
+				t.Errorf("endpoints = %+v, want %+v", f.endpoints, want)
+			}
+			tags := map[string]string{
+				"src_ip":                  c.client,
+				"src_port":                "41000",
+				"dst_ip":                  c.broker,
+				"dst_port":                "9092",
+				"peer_address":            c.broker + ":9092",
+				"span_kind":               c.spanKind,
+				"message_bus_status":      c.status,
+				"message_bus_destination": c.tx.TopicName,
+				"request_api_name":        c.apiName,
+				"request_api_version":     c.apiVersion,
+				"error":                   strconv.FormatBool(c.errorCode != ""),
+				"kafka_error_code":        c.errorCode,
+			}
+			for k, v := range tags {
+				if m.Tags[k] != v {
+					t.Errorf("tag %s = %q, want %q", k, m.Tags[k], v)
+				}
+			}
+			if m.Fields["elapsed_count"] != 1 || m.Fields["elapsed_sum"] != c.elapsed || m.Fields["record_count"] != c.records {
+				t.Errorf("fields = %v, want elapsed %d and %d records", m.Fields, c.elapsed, c.records)
+			}
+		})
+	}
+}
+
+func TestDecodeEventShort(t *testing.T) {
+	key, val := kafkaEvent("10.0.1.2", 41000, "10.0.1.5", 9092, Transaction{TopicName: "orders"})
+	if _, err := decodeEvent(key[:8], val); err == nil {
+		t.Errorf("a short key should not be decoded")
+	}
+	if _, err := decodeEvent(key, val[:20]); err == nil {
+		t.Errorf("a short value should not be decoded")
+	}
+}
@@ -1,6 +1,10 @@
 package kafka
 
-import "encoding/binary"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
 
 type Event struct {
 	ConnTuple
@@ -14,9 +18,26 @@ type ConnTuple struct {
 	DestPort   uint16
 }
 
+// api keys, see https://kafka.apache.org/protocol.html#protocol_api_keys
+const (
+	apiKeyProduce uint8 = 0
+	apiKeyFetch   uint8 = 1
+)
+
+var apiKeyNames = map[uint8]string{
+	apiKeyProduce: "produce",
+	apiKeyFetch:   "fetch",
+}
+
 type Transaction struct {
-	RequestStarted    uint64
-	RecordCount       uint32
+	// Duration is the request_started of the kernel transaction, which holds the latency once the response completed,
+	// it is 0 for the produce requests with acks=0, which get no response and are still counted.
+	Duration uint64
+	// Cookie is the socket cookie of the client sending the request, 0 if it is not captured.
+	Cookie      uint64
+	RecordCount uint32
+	// ErrorCode is the error_code of the response, of the first partition reporting one for the fetch responses.
+	ErrorCode         int16
 	RequestApiKey     uint8
 	RequestApiVersion uint8
 	TopicNameSize     uint8
@@ -35,17 +56,31 @@ type ResponseContext struct {
 	Transaction
 }
 
+// decodeEvent decodes an entry of kafka_event, the key is the connection from the client to the broker.
+func decodeEvent(key, val []byte) (Event, error) {
+	conn := ConnTuple{}
+	if err := binary.Read(bytes.NewReader(key), binary.LittleEndian, &conn); err != nil {
+		return Event{}, fmt.Errorf("decode conn: %w", err)
+	}
+	if len(val) < 25 {
+		return Event{}, fmt.Errorf("decode transaction: %d bytes", len(val))
+	}
+	return Event{ConnTuple: conn, Transaction: decodeResponse(val)}, nil
+}
+
 func decodeResponse(data []byte) Transaction {
 	ans := Transaction{}
-	ans.RequestStarted = binary.LittleEndian.Uint64(data[0:8])
-	ans.RecordCount = binary.LittleEndian.Uint32(data[8:12])
-	ans.RequestApiKey = data[12]
-	ans.RequestApiVersion = data[13]
-	ans.TopicNameSize = data[14]
+	ans.Duration = binary.LittleEndian.Uint64(data[0:8])
+	ans.Cookie = binary.LittleEndian.Uint64(data[8:16])
+	ans.RecordCount = binary.LittleEndian.Uint32(data[16:20])
+	ans.ErrorCode = int16(binary.LittleEndian.Uint16(data[20:22]))
+	ans.RequestApiKey = data[22]
+	ans.RequestApiVersion = data[23]
+	ans.TopicNameSize = data[24]
 	topicEnd := len(data)
-	if 15+int(ans.TopicNameSize) < topicEnd {
-		topicEnd = 15 + int(ans.TopicNameSize)
+	if 25+int(ans.TopicNameSize) < topicEnd {
+		topicEnd = 25 + int(ans.TopicNameSize)
 	}
-	ans.TopicName = string(data[15:topicEnd])
+	ans.TopicName = string(data[25:topicEnd])
 	return ans
 }
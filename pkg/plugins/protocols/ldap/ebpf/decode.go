@@ -0,0 +1,233 @@
+package ebpf
+
+import (
+	"encoding/binary"
+)
+
+// tags of the LDAPMessage and of its protocolOp, see RFC 4511 4.1.1
+const (
+	tagSequence    = 0x30
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+
+	opBindRequest      = 0x60
+	opBindResponse     = 0x61
+	opUnbindRequest    = 0x42
+	opSearchRequest    = 0x63
+	opSearchResultDone = 0x65
+	opModifyRequest    = 0x66
+	opModifyResponse   = 0x67
+	opAddRequest       = 0x68
+	opAddResponse      = 0x69
+	opDelRequest       = 0x4a
+	opDelResponse      = 0x6b
+	opModifyDNRequest  = 0x6c
+	opModifyDNResponse = 0x6d
+	opCompareRequest   = 0x6e
+	opCompareResponse  = 0x6f
+	opAbandonRequest   = 0x50
+	opExtendedRequest  = 0x77
+	opExtendedResponse = 0x78
+
+	// choices of the authentication of BindRequest
+	authSimple = 0x80
+	authSasl   = 0xa3
+
+	extendedRequestName = 0x80
+	oidStartTLS         = "1.3.6.1.4.1.1466.20037"
+)
+
+// result codes, see RFC 4511 4.1.9
+const (
+	resultSuccess          = 0
+	resultCompareFalse     = 5
+	resultCompareTrue      = 6
+	resultReferral         = 10
+	resultSaslBindProgress = 14
+)
+
+var (
+	requests = map[byte]string{
+		opBindRequest:     OperationBind,
+		opSearchRequest:   OperationSearch,
+		opModifyRequest:   OperationModify,
+		opAddRequest:      OperationAdd,
+		opDelRequest:      OperationDelete,
+		opModifyDNRequest: OperationModifyDN,
+		opCompareRequest:  OperationCompare,
+		opExtendedRequest: OperationExtended,
+	}
+	// responses ending the operations, the entries and references of searches precede their done.
+	responses = map[byte]string{
+		opBindResponse:     OperationBind,
+		opSearchResultDone: OperationSearch,
+		opModifyResponse:   OperationModify,
+		opAddResponse:      OperationAdd,
+		opDelResponse:      OperationDelete,
+		opModifyDNResponse: OperationModifyDN,
+		opCompareResponse:  OperationCompare,
+		opExtendedResponse: OperationExtended,
+	}
+)
+
+type element struct {
+	tag     byte
+	content []byte
+	// complete is false if the content is cut by the end of the payload.
+	complete bool
+}
+
+// readElement reads a BER element, the content of the elements cut by the end of the buffer is
+// returned as far as it is captured.
+func readElement(buf []byte) (e element, rest []byte, ok bool) {
+	if len(buf) < 2 {
+		return e, nil, false
+	}
+	e.tag = buf[0]
+	length, header := int(buf[1]), 2
+	if length >= 0x80 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(buf) < 2+n {
+			return e, nil, false
+		}
+		length = 0
+		for _, b := range buf[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		header += n
+	}
+	end := header + length
+	if end > len(buf) {
+		return element{tag: e.tag, content: buf[header:]}, nil, true
+	}
+	return element{tag: e.tag, content: buf[header:end], complete: true}, buf[end:], true
+}
+
+// readInteger reads a complete INTEGER or ENUMERATED of up to 4 bytes.
+func readInteger(buf []byte, tag byte) (v int, rest []byte, ok bool) {
+	e, rest, ok := readElement(buf)
+	if !ok || e.tag != tag || !e.complete || len(e.content) == 0 || len(e.content) > 4 {
+		return 0, nil, false
+	}
+	for _, b := range e.content {
+		v = v<<8 | int(b)
+	}
+	return v, rest, true
+}
+
+type message struct {
+	id uint32
+	op byte
+	// body is the content of the protocolOp, possibly cut.
+	body []byte
+}
+
+// parseMessages returns the messages of a packet up to the first one cut by the end of the payload.
+func parseMessages(buf []byte) []message {
+	var ans []message
+	for len(buf) > 0 {
+		e, rest, ok := readElement(buf)
+		if !ok || e.tag != tagSequence {
+			break
+		}
+		id, content, ok := readInteger(e.content, tagInteger)
+		if !ok {
+			break
+		}
+		op, _, ok := readElement(content)
+		if !ok {
+			break
+		}
+		ans = append(ans, message{id: uint32(id), op: op.tag, body: op.content})
+		if !e.complete {
+			break
+		}
+		buf = rest
+	}
+	return ans
+}
+
+// parseResult returns the resultCode and the diagnosticMessage of an LDAPResult.
+func parseResult(body []byte) (code int, diagnostic string, ok bool) {
+	code, rest, ok := readInteger(body, tagEnumerated)
+	if !ok {
+		return 0, "", false
+	}
+	matched, rest, ok := readElement(rest)
+	if !ok || matched.tag != tagOctetString {
+		return code, "", true
+	}
+	if d, _, ok := readElement(rest); ok && d.tag == tagOctetString {
+		diagnostic = string(d.content)
+	}
+	return code, diagnostic, true
+}
+
+// bindMechanism returns SIMPLE or the SASL mechanism of a BindRequest.
+func bindMechanism(body []byte) string {
+	_, rest, ok := readInteger(body, tagInteger)
+	if !ok {
+		return ""
+	}
+	name, rest, ok := readElement(rest)
+	if !ok || name.tag != tagOctetString || !name.complete {
+		return ""
+	}
+	auth, _, ok := readElement(rest)
+	if !ok {
+		return ""
+	}
+	switch auth.tag {
+	case authSimple:
+		return "SIMPLE"
+	case authSasl:
+		if mechanism, _, ok := readElement(auth.content); ok && mechanism.tag == tagOctetString && mechanism.complete {
+			return string(mechanism.content)
+		}
+	}
+	return ""
+}
+
+// isStartTLS checks the requestName of an ExtendedRequest.
+func isStartTLS(body []byte) bool {
+	name, _, ok := readElement(body)
+	return ok && name.tag == extendedRequestName && name.complete && string(name.content) == oidStartTLS
+}
+
+// parseTail returns the message id and the resultCode of the search result done ending a packet,
+// see is_search_done of ebpf/plugins/ldap/main.c. The message id is encoded on 1 - 3 bytes.
+func parseTail(tail [LdapTailSize]byte) (id uint32, code int, ok bool) {
+	for n := 1; n <= 3; n++ {
+		s := 3 - n
+		if tail[s] != tagSequence || int(tail[s+1]) != 11+n || tail[s+2] != tagInteger || int(tail[s+3]) != n {
+			continue
+		}
+		var b [4]byte
+		copy(b[4-n:], tail[s+4:7])
+		return binary.BigEndian.Uint32(b[:]), int(tail[11]), true
+	}
+	return 0, 0, false
+}
+
+// isError checks the resultCode, comparisons answer by compareTrue/compareFalse, referrals and
+// the steps of SASL binds are not failures.
+func isError(code int) bool {
+	switch code {
+	case resultSuccess, resultCompareFalse, resultCompareTrue, resultReferral, resultSaslBindProgress:
+		return false
+	}
+	return true
+}
+
+// abandoned returns the message id of an AbandonRequest, its content is the integer.
+func abandoned(body []byte) (uint32, bool) {
+	if len(body) == 0 || len(body) > 4 {
+		return 0, false
+	}
+	var id uint32
+	for _, b := range body {
+		id = id<<8 | uint32(b)
+	}
+	return id, true
+}
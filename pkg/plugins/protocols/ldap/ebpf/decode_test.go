@@ -0,0 +1,136 @@
+package ebpf
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 389}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+// tlv encodes a BER element, contents over 127 bytes use the long form.
+func tlv(tag byte, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	if len(body) < 0x80 {
+		return append([]byte{tag, byte(len(body))}, body...)
+	}
+	return append([]byte{tag, 0x82, byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+func str(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+func msg(id byte, op []byte) []byte {
+	return tlv(tagSequence, tlv(tagInteger, []byte{id}), op)
+}
+
+func result(op byte, code byte, diagnostic string) []byte {
+	return tlv(op, tlv(tagEnumerated, []byte{code}), str(tagOctetString, ""), str(tagOctetString, diagnostic))
+}
+
+func packet(t *tracker, ts uint64, fromPod bool, payload []byte) []*Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	var ev LdapEvent
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	} else if len(payload) >= LdapTailSize {
+		copy(ev.Tail[:], payload[len(payload)-LdapTailSize:])
+		ev.Flag = 1
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], payload))
+	return t.handle(&key, &ev)
+}
+
+func TestParse(t *testing.T) {
+	simple := tlv(opBindRequest, tlv(tagInteger, []byte{3}), str(tagOctetString, "cn=admin,dc=example,dc=org"), str(authSimple, "secret"))
+	if got := bindMechanism(simple[2:]); got != "SIMPLE" {
+		t.Errorf("bindMechanism() = %q", got)
+	}
+	sasl := tlv(opBindRequest, tlv(tagInteger, []byte{3}), str(tagOctetString, ""), tlv(authSasl, str(tagOctetString, "GSSAPI"), str(tagOctetString, "token")))
+	if got := bindMechanism(sasl[2:]); got != "GSSAPI" {
+		t.Errorf("bindMechanism() = %q", got)
+	}
+	code, diagnostic, ok := parseResult(result(opBindResponse, 49, "80090308: LdapErr: DSID-0C09044E, data 52e")[2:])
+	if !ok || code != 49 || diagnostic != "80090308: LdapErr: DSID-0C09044E, data 52e" {
+		t.Errorf("parseResult() = %d, %q, %v", code, diagnostic, ok)
+	}
+
+	// a message cut by the end of the payload still gives its id and operation
+	entry := msg(2, tlv(0x64, str(tagOctetString, string(bytes.Repeat([]byte{'a'}, 600)))))
+	msgs := parseMessages(append(msg(1, result(opBindResponse, 0, "")), entry[:LdapPayloadSize-14]...))
+	if len(msgs) != 2 || msgs[0].op != opBindResponse || msgs[1].id != 2 || msgs[1].op != 0x64 {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+
+	var tail [LdapTailSize]byte
+	copy(tail[:], append([]byte{0xff, 0xff}, msg(0x7f, result(opSearchResultDone, 0, ""))...))
+	if id, code, ok := parseTail(tail); !ok || id != 0x7f || code != 0 {
+		t.Errorf("parseTail() = %d, %d, %v", id, code, ok)
+	}
+}
+
+func TestOperations(t *testing.T) {
+	tr := newTracker()
+	bind := tlv(opBindRequest, tlv(tagInteger, []byte{3}), str(tagOctetString, "cn=svc,dc=example,dc=org"), str(authSimple, "wrong"))
+	packet(tr, 1, true, msg(1, bind))
+	ms := packet(tr, 5, false, msg(1, result(opBindResponse, 49, "invalid credentials")))
+	if len(ms) != 1 || ms[0].Operation != OperationBind || ms[0].Mechanism != "SIMPLE" || !ms[0].Error ||
+		ms[0].ResultCode != 49 || ms[0].Diagnostic != "invalid credentials" || ms[0].Duration != 4 || ms[0].Captured != 5 ||
+		ms[0].DestPort != 389 {
+		t.Errorf("unexpected bind %+v", ms)
+	}
+
+	// outstanding operations are answered out of order, the entries precede the done of the search
+	search := tlv(opSearchRequest, str(tagOctetString, "dc=example,dc=org"))
+	modify := tlv(opModifyRequest, str(tagOctetString, "uid=bob,dc=example,dc=org"))
+	packet(tr, 10, true, append(msg(2, search), msg(3, modify)...))
+	entry := msg(2, tlv(0x64, str(tagOctetString, "uid=alice,dc=example,dc=org")))
+	ms = packet(tr, 12, false, bytes.Join([][]byte{msg(3, result(opModifyResponse, 50, "no write access")), entry, entry, msg(2, result(opSearchResultDone, 0, ""))}, nil))
+	if len(ms) != 2 || ms[0].Operation != OperationModify || !ms[0].Error || ms[1].Operation != OperationSearch || ms[1].Error {
+		t.Errorf("unexpected operations %+v", ms)
+	}
+
+	// the done of a large result ends a packet starting within an entry
+	packet(tr, 20, true, msg(4, search))
+	big := msg(4, tlv(0x64, str(tagOctetString, string(bytes.Repeat([]byte{'a'}, 1000)))))
+	packet(tr, 21, false, big[:600])
+	ms = packet(tr, 22, false, append(big[600:], msg(4, result(opSearchResultDone, 4, ""))...))
+	if len(ms) != 1 || ms[0].Operation != OperationSearch || ms[0].ResultCode != 4 || !ms[0].Error || ms[0].Duration != 2 {
+		t.Errorf("unexpected search %+v", ms)
+	}
+
+	// compare answers are not errors, abandoned operations are forgotten
+	packet(tr, 30, true, append(msg(5, tlv(opCompareRequest, str(tagOctetString, "cn=admins"))), msg(6, search)...))
+	packet(tr, 31, true, msg(7, tlv(opAbandonRequest, []byte{6})))
+	ms = packet(tr, 32, false, msg(5, result(opCompareResponse, resultCompareTrue, "")))
+	if len(ms) != 1 || ms[0].Error || len(tr.pending) != 0 {
+		t.Errorf("unexpected compare %+v, pending %d", ms, len(tr.pending))
+	}
+}
+
+func TestStartTLSAndExpire(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, msg(1, tlv(opExtendedRequest, str(extendedRequestName, oidStartTLS))))
+	ms := packet(tr, 2, false, msg(1, result(opExtendedResponse, 0, "")))
+	if len(ms) != 1 || ms[0].Operation != OperationExtended {
+		t.Errorf("unexpected extended operation %+v", ms)
+	}
+	packet(tr, 3, true, msg(2, tlv(opSearchRequest, str(tagOctetString, "dc=example,dc=org"))))
+	if len(tr.pending) != 0 {
+		t.Errorf("the session should be ignored after StartTLS")
+	}
+	tr.expire(connIdleTimeout + 5)
+	if len(tr.encrypted) != 0 {
+		t.Errorf("idle connections should be dropped")
+	}
+
+	packet(tr, 1, true, msg(1, tlv(opSearchRequest, str(tagOctetString, "dc=example,dc=org"))))
+	tr.expire(requestTimeout + 5)
+	if len(tr.pending) != 0 {
+		t.Errorf("requests without response should be dropped")
+	}
+}
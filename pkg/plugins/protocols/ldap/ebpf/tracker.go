@@ -0,0 +1,167 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// requestTimeout drops requests without response, clients give up long before.
+	requestTimeout = uint64(300e9)
+	// connIdleTimeout forgets the connections upgraded by StartTLS.
+	connIdleTimeout = uint64(600e9)
+)
+
+type pendingKey struct {
+	conn ConnKey
+	id   uint32
+}
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+	// startTLS upgrades the connection if it succeeds.
+	startTLS bool
+}
+
+// tracker pairs the requests with their responses by the message id, operations of a connection
+// may be outstanding at the same time. Connections are keyed in the client -> server direction,
+// the client is the pod attached to the veth.
+type tracker struct {
+	pending map[pendingKey]*pending
+	// encrypted holds the last packet of the connections upgraded by StartTLS, the rest of the
+	// session is not readable.
+	encrypted map[ConnKey]uint64
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		pending:   make(map[pendingKey]*pending),
+		encrypted: make(map[ConnKey]uint64),
+	}
+}
+
+// handle processes a packet, it returns the operations completed by it.
+func (t *tracker) handle(key *EventKey, ev *LdapEvent) []*Metric {
+	buf := ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]
+	// requests of the pod and the responses to it, sessions of server pods are ignored that way.
+	if ev.FromPod == 1 {
+		if _, ok := t.encrypted[key.Conn]; ok {
+			t.encrypted[key.Conn] = key.Timestamp
+			return nil
+		}
+		for _, msg := range parseMessages(buf) {
+			t.handleRequest(key, ev.Cookie, msg)
+		}
+		return nil
+	}
+	connKey := ConnKey{
+		SourceIP:   key.Conn.DestIP,
+		DestIP:     key.Conn.SourceIP,
+		SourcePort: key.Conn.DestPort,
+		DestPort:   key.Conn.SourcePort,
+	}
+	if _, ok := t.encrypted[connKey]; ok {
+		t.encrypted[connKey] = key.Timestamp
+		return nil
+	}
+	var done []*Metric
+	for _, msg := range parseMessages(buf) {
+		op, ok := responses[msg.op]
+		if !ok {
+			continue
+		}
+		pk := pendingKey{conn: connKey, id: msg.id}
+		p := t.pending[pk]
+		if p == nil || p.metric.Operation != op {
+			continue
+		}
+		code, diagnostic, ok := parseResult(msg.body)
+		if !ok {
+			continue
+		}
+		delete(t.pending, pk)
+		if p.startTLS && code == resultSuccess {
+			t.encrypted[connKey] = key.Timestamp
+		}
+		done = append(done, p.finish(code, diagnostic, key.Timestamp))
+	}
+	// the done of a large search result, the packet starts within its entries
+	if ev.Flag == 1 {
+		if id, code, ok := parseTail(ev.Tail); ok {
+			pk := pendingKey{conn: connKey, id: id}
+			if p := t.pending[pk]; p != nil && p.metric.Operation == OperationSearch {
+				delete(t.pending, pk)
+				done = append(done, p.finish(code, "", key.Timestamp))
+			}
+		}
+	}
+	return done
+}
+
+func (t *tracker) handleRequest(key *EventKey, cookie uint64, msg message) {
+	switch msg.op {
+	case opUnbindRequest:
+		// the client closes the connection, the outstanding operations are not answered
+		for pk := range t.pending {
+			if pk.conn == key.Conn {
+				delete(t.pending, pk)
+			}
+		}
+		return
+	case opAbandonRequest:
+		if id, ok := abandoned(msg.body); ok {
+			delete(t.pending, pendingKey{conn: key.Conn, id: id})
+		}
+		return
+	}
+	op, ok := requests[msg.op]
+	if !ok {
+		return
+	}
+	m := &Metric{
+		SourceIP:   net.IP(key.Conn.SourceIP[:]).String(),
+		SourcePort: key.Conn.SourcePort,
+		DestIP:     net.IP(key.Conn.DestIP[:]).String(),
+		DestPort:   key.Conn.DestPort,
+		Operation:  op,
+
+		SocketCookie: cookie,
+	}
+	p := &pending{metric: m, ts: key.Timestamp}
+	switch msg.op {
+	case opBindRequest:
+		m.Mechanism = bindMechanism(msg.body)
+	case opExtendedRequest:
+		p.startTLS = isStartTLS(msg.body)
+	}
+	t.pending[pendingKey{conn: key.Conn, id: msg.id}] = p
+}
+
+func (p *pending) finish(code int, diagnostic string, ts uint64) *Metric {
+	m := p.metric
+	m.ResultCode = code
+	if isError(code) {
+		m.Error = true
+		m.Diagnostic = diagnostic
+	}
+	m.Captured = ts
+	if ts > p.ts {
+		m.Duration = ts - p.ts
+	}
+	return m
+}
+
+// expire drops the requests without response within requestTimeout and the idle encrypted
+// connections at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for pk, p := range t.pending {
+		if now-p.ts > requestTimeout {
+			delete(t.pending, pk)
+		}
+	}
+	for conn, lastSeen := range t.encrypted {
+		if now-lastSeen > connIdleTimeout {
+			delete(t.encrypted, conn)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	LdapPayloadSize = 512
+	LdapTailSize    = 16
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+// LdapEvent is a packet, the Flag of its header is set if the packet ends with a search result done, Tail
+// holds the end of the packet.
+type LdapEvent struct {
+	socketfilter.Header
+	Tail    [LdapTailSize]byte
+	Payload [LdapPayloadSize]byte
+}
+
+// operations, see RFC 4511 4.2 - 4.12
+const (
+	OperationBind     = "bind"
+	OperationSearch   = "search"
+	OperationModify   = "modify"
+	OperationAdd      = "add"
+	OperationDelete   = "delete"
+	OperationModifyDN = "modify_dn"
+	OperationCompare  = "compare"
+	OperationExtended = "extended"
+)
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Operation string
+	// Mechanism is the authentication of binds, SIMPLE or the SASL mechanism, e.g. GSSAPI.
+	Mechanism string
+
+	ResultCode int
+	Error      bool
+	// Diagnostic is the diagnosticMessage of the result, e.g. the reason of Active Directory for
+	// invalidCredentials ("... data 52e ...").
+	Diagnostic string
+
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s ldap [%s:%d] --> [%s:%d][%s] ====> %d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Operation,
+		m.ResultCode, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,108 @@
+package ldap
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/ldap/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const measurementGroup = "application_ldap"
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("ldap", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "ldap", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"ldap_operation":   m.Operation,
+			"ldap_result_code": strconv.Itoa(m.ResultCode),
+			"error":            strconv.FormatBool(m.Error),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if m.Mechanism != "" {
+		output.Tags["ldap_bind_mechanism"] = m.Mechanism
+	}
+	if m.Error {
+		output.Tags["ldap_error"] = m.Diagnostic
+		p.errorCodes.Tag(output.Tags, errorcodes.LDAP, output.Tags["ldap_result_code"])
+	}
+
+	inCluster := p.enricher.Enrich(output, "LDAP", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	// directories outside the cluster (e.g. the domain controllers of Active Directory) are still reported,
+	// they are not part of the topology.
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("ldap", &servicehub.Spec{
+		Services:             []string{"ldap"},
+		Description:          "ebpf for ldap",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
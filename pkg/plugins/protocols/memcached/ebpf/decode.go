@@ -0,0 +1,219 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+const (
+	binaryHeaderSize    = 24
+	binaryMagicRequest  = 0x80
+	binaryMagicResponse = 0x81
+)
+
+// textCommands are the text and meta protocol commands, the value tells whether the command is a retrieval.
+var textCommands = map[string]bool{
+	"get":     true,
+	"gets":    true,
+	"gat":     true,
+	"gats":    true,
+	"mg":      true,
+	"set":     false,
+	"add":     false,
+	"replace": false,
+	"append":  false,
+	"prepend": false,
+	"cas":     false,
+	"delete":  false,
+	"incr":    false,
+	"decr":    false,
+	"touch":   false,
+	"ms":      false,
+	"md":      false,
+	"ma":      false,
+}
+
+// textErrors are the reply lines of failed text commands.
+var textErrors = map[string]bool{
+	"ERROR":        true,
+	"CLIENT_ERROR": true,
+	"SERVER_ERROR": true,
+}
+
+// binaryOpcodes maps the keyed opcodes of the binary protocol to the text command names,
+// quiet variants share the name of their command. Opcodes without a key (noop, version, stat, sasl) are not reported.
+var binaryOpcodes = map[uint8]string{
+	0x00: "get",
+	0x01: "set",
+	0x02: "add",
+	0x03: "replace",
+	0x04: "delete",
+	0x05: "incr",
+	0x06: "decr",
+	0x09: "get",
+	0x0c: "get",
+	0x0d: "get",
+	0x0e: "append",
+	0x0f: "prepend",
+	0x11: "set",
+	0x12: "add",
+	0x13: "replace",
+	0x14: "delete",
+	0x15: "incr",
+	0x16: "decr",
+	0x19: "append",
+	0x1a: "prepend",
+	0x1c: "touch",
+	0x1d: "gat",
+	0x1e: "gat",
+	0x23: "gat",
+	0x24: "gat",
+}
+
+// binaryStatuses are the names of the binary response statuses.
+var binaryStatuses = map[uint16]string{
+	0x00: "NO_ERROR",
+	0x01: "KEY_NOT_FOUND",
+	0x02: "KEY_EXISTS",
+	0x03: "VALUE_TOO_LARGE",
+	0x04: "INVALID_ARGUMENTS",
+	0x05: "ITEM_NOT_STORED",
+	0x06: "NON_NUMERIC_VALUE",
+	0x07: "WRONG_VBUCKET",
+	0x08: "AUTH_ERROR",
+	0x09: "AUTH_CONTINUE",
+	0x81: "UNKNOWN_COMMAND",
+	0x82: "OUT_OF_MEMORY",
+	0x83: "NOT_SUPPORTED",
+	0x84: "INTERNAL_ERROR",
+	0x85: "BUSY",
+	0x86: "TEMPORARY_FAILURE",
+}
+
+// decodeMetric converts a completed command to a metric, it returns nil if the
+// request is not a memcached command.
+func decodeMetric(key *EventKey, data *MemcachedEvent) *Metric {
+	request := data.Request[:min(int(data.RequestLen), len(data.Request))]
+	response := data.Response[:min(int(data.ResponseLen), len(data.Response))]
+	if len(request) == 0 || len(response) == 0 {
+		return nil
+	}
+	m := &Metric{
+		SourceIP:     net.IP(key.Conn.SourceIP[:]).String(),
+		SourcePort:   key.Conn.SourcePort,
+		DestIP:       net.IP(key.Conn.DestIP[:]).String(),
+		DestPort:     key.Conn.DestPort,
+		Duration:     data.Duration,
+		SocketCookie: data.Cookie,
+	}
+	var ok bool
+	if request[0] == binaryMagicRequest {
+		ok = decodeBinary(m, request, response)
+	} else {
+		ok = decodeText(m, request, response)
+	}
+	if !ok {
+		return nil
+	}
+	return m
+}
+
+func decodeText(m *Metric, request, response []byte) bool {
+	fields := strings.Fields(string(firstLine(request)))
+	if len(fields) == 0 {
+		return false
+	}
+	retrieval, ok := textCommands[fields[0]]
+	if !ok {
+		return false
+	}
+	m.Command = fields[0]
+	if len(fields) > 1 {
+		m.KeyPattern = dbstatement.KeyPattern(fields[1])
+		// the key was cut by the capture size
+		if len(fields) == 2 && !bytes.Contains(request, []byte("\r\n")) {
+			m.KeyPattern = strings.TrimSuffix(m.KeyPattern, "*") + "*"
+		}
+	}
+
+	line := string(firstLine(response))
+	status, message, _ := strings.Cut(line, " ")
+	switch {
+	case textErrors[status]:
+		m.Error = true
+		m.Status = status
+		m.ErrorMessage = message
+		return true
+	case len(status) > 0 && status[0] >= '0' && status[0] <= '9':
+		// new value of incr and decr
+		m.Status = "OK"
+	default:
+		m.Status = status
+	}
+	if retrieval {
+		switch status {
+		// text protocol values, meta protocol values and flags-only hits
+		case "VALUE", "VA", "HD":
+			m.Result = CacheResultHit
+		// the end of an empty get, the meta protocol miss
+		case "END", "EN":
+			m.Result = CacheResultMiss
+		}
+	}
+	return true
+}
+
+func decodeBinary(m *Metric, request, response []byte) bool {
+	if len(request) < binaryHeaderSize || len(response) < binaryHeaderSize || response[0] != binaryMagicResponse {
+		return false
+	}
+	command, ok := binaryOpcodes[request[1]]
+	if !ok {
+		return false
+	}
+	m.Binary = true
+	m.Command = command
+
+	keyLen := int(binary.BigEndian.Uint16(request[2:4]))
+	extrasLen := int(request[4])
+	if keyStart := binaryHeaderSize + extrasLen; keyLen > 0 && keyStart < len(request) {
+		keyEnd := min(keyStart+keyLen, len(request))
+		m.KeyPattern = dbstatement.KeyPattern(string(request[keyStart:keyEnd]))
+		if keyEnd < keyStart+keyLen {
+			m.KeyPattern = strings.TrimSuffix(m.KeyPattern, "*") + "*"
+		}
+	}
+
+	status := binary.BigEndian.Uint16(response[6:8])
+	m.Status = binaryStatuses[status]
+	if m.Status == "" {
+		m.Status = "UNKNOWN"
+	}
+	switch status {
+	case 0x00, 0x01:
+		if textCommands[command] {
+			m.Result = CacheResultHit
+			if status == 0x01 {
+				m.Result = CacheResultMiss
+			}
+		}
+	// the regular outcomes of add, replace and cas
+	case 0x02, 0x05:
+	default:
+		m.Error = true
+		body := response[binaryHeaderSize:]
+		m.ErrorMessage = string(body[:min(int(binary.BigEndian.Uint32(response[8:12])), len(body))])
+	}
+	return true
+}
+
+func firstLine(buf []byte) []byte {
+	if idx := bytes.Index(buf, []byte("\r\n")); idx >= 0 {
+		return buf[:idx]
+	}
+	return buf
+}
@@ -0,0 +1,148 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeText(t *testing.T) {
+	cases := []struct {
+		request, response string
+		command, key      string
+		result            CacheResult
+		status            string
+		error             bool
+	}{
+		{"get user:42:profile\r\n", "VALUE user:42:profile 0 5\r\nhello\r\nEND\r\n", "get", "user:*:profile", CacheResultHit, "VALUE", false},
+		{"gets user:42 user:43\r\n", "END\r\n", "gets", "user:*", CacheResultMiss, "END", false},
+		{"set session:7 0 60 5\r\nhello\r\n", "STORED\r\n", "set", "session:*", CacheResultNone, "STORED", false},
+		{"incr counter 1\r\n", "2\r\n", "incr", "counter", CacheResultNone, "OK", false},
+		{"mg user:1 v\r\n", "EN\r\n", "mg", "user:*", CacheResultMiss, "EN", false},
+		{"set k 0 0 1\r\nx\r\n", "SERVER_ERROR out of memory storing object\r\n", "set", "k", CacheResultNone, "SERVER_ERROR", true},
+	}
+	for _, c := range cases {
+		var data MemcachedEvent
+		data.RequestLen = uint16(copy(data.Request[:], c.request))
+		data.ResponseLen = uint16(copy(data.Response[:], c.response))
+		m := decodeMetric(&EventKey{}, &data)
+		if m == nil {
+			t.Errorf("%q: expected metric", c.request)
+			continue
+		}
+		if m.Command != c.command || m.KeyPattern != c.key || m.Result != c.result || m.Status != c.status || m.Error != c.error {
+			t.Errorf("%q: unexpected metric %+v", c.request, m)
+		}
+	}
+
+	var data MemcachedEvent
+	data.RequestLen = uint16(copy(data.Request[:], "gettext\r\n"))
+	data.ResponseLen = uint16(copy(data.Response[:], "ok\r\n"))
+	if m := decodeMetric(&EventKey{}, &data); m != nil {
+		t.Errorf("unknown commands should be ignored, got %+v", m)
+	}
+}
+
+func TestDecodeBinary(t *testing.T) {
+	request := func(opcode uint8, key string) []byte {
+		b := make([]byte, binaryHeaderSize+len(key))
+		b[0] = binaryMagicRequest
+		b[1] = opcode
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(key)))
+		copy(b[binaryHeaderSize:], key)
+		return b
+	}
+	response := func(status uint16, body string) []byte {
+		b := make([]byte, binaryHeaderSize+len(body))
+		b[0] = binaryMagicResponse
+		binary.BigEndian.PutUint16(b[6:8], status)
+		binary.BigEndian.PutUint32(b[8:12], uint32(len(body)))
+		copy(b[binaryHeaderSize:], body)
+		return b
+	}
+
+	var data MemcachedEvent
+	data.RequestLen = uint16(copy(data.Request[:], request(0x0c, "item:1001")))
+	data.ResponseLen = uint16(copy(data.Response[:], response(0x01, "Not found")))
+	m := decodeMetric(&EventKey{}, &data)
+	if m == nil || !m.Binary || m.Command != "get" || m.KeyPattern != "item:*" || m.Result != CacheResultMiss || m.Error {
+		t.Errorf("unexpected metric: %+v", m)
+	}
+
+	data = MemcachedEvent{}
+	data.RequestLen = uint16(copy(data.Request[:], request(0x01, "item:1001")))
+	data.ResponseLen = uint16(copy(data.Response[:], response(0x82, "Out of memory")))
+	m = decodeMetric(&EventKey{}, &data)
+	if m == nil || m.Command != "set" || !m.Error || m.Status != "OUT_OF_MEMORY" || m.ErrorMessage != "Out of memory" {
+		t.Errorf("unexpected metric: %+v", m)
+	}
+}
+
+// capture returns the raw key and value of the metrics_map entry the kernel writes for the payloads of a
+// request packet and of its response packet.
+func capture(request, response string) (key, value []byte) {
+	key = make([]byte, 24)
+	copy(key, []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	binary.LittleEndian.PutUint16(key[8:], 41000)
+	binary.LittleEndian.PutUint16(key[10:], 11211)
+	binary.LittleEndian.PutUint64(key[16:], 1000)
+
+	value = make([]byte, 32+MemcachedRequestSize+MemcachedResponseSize)
+	binary.LittleEndian.PutUint64(value[0:], 1000)
+	binary.LittleEndian.PutUint64(value[8:], 250000)
+	binary.LittleEndian.PutUint64(value[16:], 7)
+	binary.LittleEndian.PutUint16(value[24:], uint16(copy(value[32:32+MemcachedRequestSize], request)))
+	binary.LittleEndian.PutUint16(value[26:], uint16(copy(value[32+MemcachedRequestSize:], response)))
+	return key, value
+}
+
+func decode(t *testing.T, request, response string) *Metric {
+	rawKey, rawValue := capture(request, response)
+	var (
+		key  EventKey
+		data MemcachedEvent
+	)
+	if binary.Size(key) != len(rawKey) || binary.Size(data) != len(rawValue) {
+		t.Fatalf("the key and the event do not match the layout of the C structs, %d and %d bytes", binary.Size(key), binary.Size(data))
+	}
+	if err := binary.Read(bytes.NewReader(rawKey), binary.LittleEndian, &key); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Read(bytes.NewReader(rawValue), binary.LittleEndian, &data); err != nil {
+		t.Fatal(err)
+	}
+	return decodeMetric(&key, &data)
+}
+
+func TestDecodeCapture(t *testing.T) {
+	for _, c := range []struct {
+		name, request, response string
+		binary                  bool
+		command, key, status    string
+		result                  CacheResult
+	}{
+		{"text hit", "get product:8841\r\n", "VALUE product:8841 0 9\r\n{\"p\":12}\r\nEND\r\n", false, "get", "product:*", "VALUE", CacheResultHit},
+		{"text store", "set rate:1700000000 0 60 1\r\n1\r\n", "STORED\r\n", false, "set", "rate:*", "STORED", CacheResultNone},
+		{"text delete miss", "delete session:7731\r\n", "NOT_FOUND\r\n", false, "delete", "session:*", "NOT_FOUND", CacheResultNone},
+		// binary GETK (get) of item:1001: magic, opcode, key length, extras, data type, vbucket, body length,
+		// opaque, cas, key; the response is a miss with the error message as value.
+		{
+			"binary miss",
+			"\x80\x0c\x00\x09\x00\x00\x00\x00\x00\x00\x00\x09\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00item:1001",
+			"\x81\x0c\x00\x00\x00\x00\x00\x01\x00\x00\x00\x09\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00Not found",
+			true, "get", "item:*", "KEY_NOT_FOUND", CacheResultMiss,
+		},
+	} {
+		m := decode(t, c.request, c.response)
+		if m == nil {
+			t.Errorf("%s: expected metric", c.name)
+			continue
+		}
+		if m.SourceIP != "10.0.0.1" || m.DestPort != 11211 || m.Duration != 250000 || m.SocketCookie != 7 {
+			t.Errorf("%s: unexpected connection %+v", c.name, m)
+		}
+		if m.Binary != c.binary || m.Command != c.command || m.KeyPattern != c.key || m.Status != c.status || m.Result != c.result {
+			t.Errorf("%s: unexpected metric %+v", c.name, m)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package ebpf
+
+import (
+	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	programPath = "target/memcached.bpf.o"
+	programName = "socket__memcached_filter"
+	mapMetric   = "metrics_map"
+)
+
+type Interface interface {
+	Load() error
+	Close() error
+}
+
+type provider struct {
+	link attach.Link
+	ch   chan Metric
+
+	filter *socketfilter.Filter
+}
+
+func New(l attach.Link, ch chan Metric) Interface {
+	return &provider{
+		link: l,
+		ch:   ch,
+	}
+}
+
+func (e *provider) Load() error {
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		return exclusion.Apply(c, "memcached")
+	})
+	if err != nil {
+		return err
+	}
+	m := e.filter.Collection.DetachMap(mapMetric)
+	go e.filter.Loop(func(uint64) {
+		for _, entry := range socketfilter.Take[EventKey, MemcachedEvent](m) {
+			if metric := decodeMetric(&entry.Key, &entry.Val); metric != nil {
+				e.ch <- *metric
+			}
+		}
+	})
+	return nil
+}
+
+func (e *provider) Close() error {
+	return e.filter.Close()
+}
@@ -0,0 +1,89 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	MemcachedRequestSize  = 128
+	MemcachedResponseSize = 64
+)
+
+type ConnKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+type EventKey struct {
+	Conn ConnKey
+	// the C struct aligns request_ts to 8 bytes
+	_                uint32
+	RequestTimestamp uint64
+}
+
+type MemcachedEvent struct {
+	RequestTimestamp uint64
+	Duration         uint64
+	Cookie           uint64
+	RequestLen       uint16
+	ResponseLen      uint16
+	_                uint32
+	Request          [MemcachedRequestSize]byte
+	Response         [MemcachedResponseSize]byte
+}
+
+type CacheResult int
+
+const (
+	// CacheResultNone is the result of storage and arithmetic commands.
+	CacheResultNone CacheResult = iota
+	CacheResultHit
+	CacheResultMiss
+)
+
+func (r CacheResult) String() string {
+	switch r {
+	case CacheResultHit:
+		return "hit"
+	case CacheResultMiss:
+		return "miss"
+	default:
+		return ""
+	}
+}
+
+type Metric struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Binary reports whether the command used the binary protocol.
+	Binary bool
+	// Command is the lower case command name, e.g. get or set, binary opcodes are mapped to the same names.
+	Command string
+	// KeyPattern is the key with the variable parts replaced by '*', e.g. user:*:profile.
+	KeyPattern string
+	// Result is the outcome of retrieval commands, the first key decides for multi-gets.
+	Result CacheResult
+
+	Error bool
+	// Status is the reply status, e.g. NOT_STORED or SERVER_ERROR for the text protocol and the status name for the binary protocol.
+	Status       string
+	ErrorMessage string
+
+	Duration uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s memcached [%s:%d] --> [%s:%d][%s %s] ====> %s %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Command, m.KeyPattern,
+		m.Status, m.Result, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,117 @@
+package memcached
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/memcached/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup      = "application_cache"
+	measurementGroupError = measurementGroup + "_error"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("memcached", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "memcached", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	measurement := measurementGroup
+	if m.Error {
+		measurement = measurementGroupError
+	}
+	statement := m.Command
+	if m.KeyPattern != "" {
+		statement += " " + m.KeyPattern
+	}
+	output := &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"db_type":               "memcached",
+			"db_statement":          statement,
+			"memcached_command":     m.Command,
+			"memcached_key_pattern": m.KeyPattern,
+			"memcached_protocol":    "text",
+			"memcached_status":      m.Status,
+			"error":                 strconv.FormatBool(m.Error),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if m.Binary {
+		output.Tags["memcached_protocol"] = "binary"
+	}
+	// hit ratio of a pod pair is sum(hit_count) / (sum(hit_count) + sum(miss_count))
+	if m.Result != ebpf.CacheResultNone {
+		output.Tags["cache_result"] = m.Result.String()
+		output.Fields["hit_count"] = 0
+		output.Fields["miss_count"] = 0
+		output.Fields[m.Result.String()+"_count"] = 1
+	}
+	if m.Error {
+		output.Tags["memcached_error"] = m.ErrorMessage
+	}
+
+	p.enricher.Enrich(output, "MEMCACHED", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		SocketCookie: m.SocketCookie,
+	})
+	output.Tags["db_host"] = output.Tags["peer_address"]
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("memcached", &servicehub.Spec{
+		Services:             []string{"memcached"},
+		Description:          "ebpf for memcached",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,136 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+)
+
+// see com.weibo.api.motan.protocol.v2motan.MotanV2Header and MotanV2Codec
+const (
+	magic      = 0xF1F1
+	headerSize = 13
+
+	msgHeartbeat = 0x10
+	msgOneway    = 0x04
+	msgResponse  = 0x01
+
+	statusException = 1
+)
+
+// meta keys of motan2, see com.weibo.api.motan.protocol.v2motan.MotanV2Codec
+const (
+	metaPath    = "M_p"
+	metaMethod  = "M_m"
+	metaGroup   = "M_g"
+	metaVersion = "M_v"
+	metaError   = "M_e"
+)
+
+// serializationNames are the serialization numbers of motan2.
+var serializationNames = map[byte]string{
+	0: "hessian2",
+	1: "grpc-pb",
+	2: "json",
+	3: "msgpack",
+	4: "hprose",
+	5: "pb",
+	6: "simple",
+	7: "grpc-pb-json",
+}
+
+func serializationName(n byte) string {
+	if name, ok := serializationNames[n]; ok {
+		return name
+	}
+	return strconv.Itoa(int(n))
+}
+
+type message struct {
+	msgType       byte
+	status        byte
+	serialization byte
+	requestID     uint64
+	meta          map[string]string
+}
+
+func (m *message) isResponse() bool {
+	return m.msgType&msgResponse != 0
+}
+
+func (m *message) isOneway() bool {
+	return m.msgType&msgOneway != 0
+}
+
+// parseMessages parses the messages of a packet, the meta of the last one may be truncated.
+// Heartbeats are skipped.
+func parseMessages(buf []byte) []message {
+	var messages []message
+	for len(buf) >= headerSize+4 {
+		if binary.BigEndian.Uint16(buf[0:2]) != magic {
+			break
+		}
+		m := message{
+			msgType: buf[2],
+			// the version takes the upper 5 bits
+			status:        buf[3] & 0x07,
+			serialization: buf[4] >> 3,
+			requestID:     binary.BigEndian.Uint64(buf[5:13]),
+		}
+		metaSize := int(int32(binary.BigEndian.Uint32(buf[headerSize : headerSize+4])))
+		if metaSize < 0 {
+			break
+		}
+		rest := buf[headerSize+4:]
+		m.meta = parseMeta(rest[:min(metaSize, len(rest))])
+		if m.msgType&msgHeartbeat == 0 {
+			messages = append(messages, m)
+		}
+		if len(rest) < metaSize+4 {
+			break
+		}
+		bodySize := int(binary.BigEndian.Uint32(rest[metaSize : metaSize+4]))
+		if len(rest) < metaSize+4+bodySize {
+			break
+		}
+		buf = rest[metaSize+4+bodySize:]
+	}
+	return messages
+}
+
+// parseMeta parses the meta of a message, the keys and values are terminated by \n.
+// The entries before the truncation are kept.
+func parseMeta(b []byte) map[string]string {
+	meta := make(map[string]string)
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			return meta
+		}
+		key := string(b[:i])
+		b = b[i+1:]
+		j := bytes.IndexByte(b, '\n')
+		if j < 0 {
+			return meta
+		}
+		meta[key] = string(b[:j])
+		b = b[j+1:]
+	}
+}
+
+// motanError is the error meta of exception responses, see com.weibo.api.motan.util.ExceptionUtil.
+type motanError struct {
+	Code    int    `json:"errcode"`
+	Message string `json:"errmsg"`
+}
+
+// parseError returns the code and the message of the error meta, the raw meta is the message of
+// errors that are not json (e.g. truncated ones).
+func parseError(s string) (int, string) {
+	var e motanError
+	if err := json.Unmarshal([]byte(s), &e); err != nil {
+		return 0, s
+	}
+	return e.Code, e.Message
+}
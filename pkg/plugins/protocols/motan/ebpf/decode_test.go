@@ -0,0 +1,94 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func motanMessage(msgType, status byte, id uint64, meta ...string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, magic)
+	b = append(b, msgType, 1<<3|status, 1<<3)
+	b = binary.BigEndian.AppendUint64(b, id)
+	var m []byte
+	for _, s := range meta {
+		m = append(append(m, s...), '\n')
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(m)))
+	b = append(b, m...)
+	b = binary.BigEndian.AppendUint32(b, 4)
+	return append(b, "body"...)
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 8002}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, messages ...[]byte) []*Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	ev := MotanEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	var buf []byte
+	for _, m := range messages {
+		buf = append(buf, m...)
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	return t.handle(&key, &ev)
+}
+
+func TestCall(t *testing.T) {
+	tr := newTracker()
+	// pipelined requests, a heartbeat in between
+	packet(tr, 10, true,
+		motanMessage(0, 0, 1, metaPath, "com.example.OrderService", metaMethod, "create", metaGroup, "orders", metaVersion, "1.0"),
+		motanMessage(msgHeartbeat, 0, 2),
+		motanMessage(0, 0, 3, metaPath, "com.example.UserService", metaMethod, "get", metaGroup, "users"))
+	done := packet(tr, 30, false,
+		motanMessage(msgResponse, statusException, 3, metaError, `{"errcode":10003,"errmsg":"request timeout","errtype":1}`),
+		motanMessage(msgResponse, 0, 1))
+	if len(done) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(done))
+	}
+	m := done[0]
+	if m.Service != "com.example.UserService" || m.Method != "get" || m.Group != "users" || !m.Error ||
+		m.ErrorCode != 10003 || m.ErrorMessage != "request timeout" || m.Duration != 20 {
+		t.Errorf("unexpected call %+v", m)
+	}
+	m = done[1]
+	if m.Service != "com.example.OrderService" || m.Version != "1.0" || m.Serialization != "grpc-pb" || m.Error ||
+		m.SourcePort != 40000 || m.DestPort != 8002 {
+		t.Errorf("unexpected call %+v", m)
+	}
+}
+
+func TestOnewayAndServer(t *testing.T) {
+	tr := newTracker()
+	done := packet(tr, 1, true, motanMessage(msgOneway, 0, 5, metaPath, "com.example.LogService", metaMethod, "append"))
+	if len(done) != 1 || !done[0].Oneway || done[0].Method != "append" || done[0].Duration != 0 {
+		t.Fatalf("unexpected oneway calls %+v", done)
+	}
+	// requests to the pod are reported by their clients
+	packet(tr, 2, false, motanMessage(0, 0, 6, metaPath, "com.example.LogService"))
+	if done := packet(tr, 3, true, motanMessage(msgResponse, 0, 6)); len(done) != 0 {
+		t.Errorf("calls served by the pod should be ignored, got %+v", done)
+	}
+	packet(tr, 4, true, motanMessage(0, 0, 7, metaPath, "com.example.LogService"))
+	tr.expire(requestTimeout + 5)
+	if len(tr.conns) != 0 {
+		t.Errorf("requests without response should be dropped")
+	}
+}
+
+func TestTruncatedMeta(t *testing.T) {
+	b := motanMessage(0, 0, 1, metaPath, "com.example.OrderService", metaMethod, "create")
+	messages := parseMessages(b[:len(b)-12])
+	if len(messages) != 1 || messages[0].meta[metaPath] != "com.example.OrderService" || messages[0].meta[metaMethod] != "" {
+		t.Errorf("the entries before the truncation should be kept, got %+v", messages)
+	}
+	if code, msg := parseError(`{"errcode":1000`); code != 0 || msg != `{"errcode":1000` {
+		t.Errorf("unexpected error %d %s", code, msg)
+	}
+}
@@ -0,0 +1,57 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	MotanPayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type MotanEvent struct {
+	socketfilter.Header
+	Payload [MotanPayloadSize]byte
+}
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Service is the path of the request, the interface of java services.
+	Service       string
+	Method        string
+	Group         string
+	Version       string
+	Serialization string
+	Oneway        bool
+
+	Error bool
+	// ErrorCode and ErrorMessage are decoded from the error meta of exception responses, the code is 0 when missing.
+	ErrorCode    int
+	ErrorMessage string
+
+	// Duration is not set for oneway calls.
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s motan [%s:%d] --> [%s:%d][%s %s.%s] ====> error: %v [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Group, m.Service, m.Method,
+		m.Error, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,120 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+const (
+	statementIdleTimeout = 10 * time.Minute
+)
+
+type preparedStatement struct {
+	statement string
+	lastSeen  time.Time
+}
+
+// statementCache resolves the statement ids of COM_STMT_EXECUTE to the text
+// that was sent with COM_STMT_PREPARE on the same connection.
+type statementCache struct {
+	sync.Mutex
+	statements map[string]*preparedStatement
+	clock      clock.Clock
+}
+
+func newStatementCache() *statementCache {
+	return &statementCache{
+		statements: make(map[string]*preparedStatement),
+		clock:      clock.Real,
+	}
+}
+
+func statementKey(key *ConnKey, stmtID uint32) string {
+	return fmt.Sprintf("%s:%d-%s:%d/%d",
+		net.IP(key.SourceIP[:]).String(), key.SourcePort, net.IP(key.DestIP[:]).String(), key.DestPort, stmtID)
+}
+
+func (c *statementCache) put(key *ConnKey, stmtID uint32, statement string) {
+	c.Lock()
+	defer c.Unlock()
+	c.statements[statementKey(key, stmtID)] = &preparedStatement{
+		statement: statement,
+		lastSeen:  c.clock.Now(),
+	}
+}
+
+func (c *statementCache) get(key *ConnKey, stmtID uint32) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.statements[statementKey(key, stmtID)]
+	if !ok {
+		return "", false
+	}
+	s.lastSeen = c.clock.Now()
+	return s.statement, true
+}
+
+func (c *statementCache) gc() {
+	c.Lock()
+	defer c.Unlock()
+	for k, s := range c.statements {
+		if c.clock.Since(s.lastSeen) > statementIdleTimeout {
+			delete(c.statements, k)
+		}
+	}
+}
+
+// decodeMetric converts a completed command to a metric, it returns nil for
+// COM_STMT_PREPARE which is only recorded to resolve the following executions.
+func decodeMetric(statements *statementCache, key *EventKey, data *MysqlEvent) *Metric {
+	statement := dbstatement.Normalize(string(cString(data.Statement[:], int(data.StatementLen))))
+	switch data.Command {
+	case ComStmtPrepare:
+		if data.ResponseType == responseOK {
+			statements.put(&key.Conn, data.StmtID, statement)
+			return nil
+		}
+	case ComStmtExecute:
+		if s, ok := statements.get(&key.Conn, data.StmtID); ok {
+			statement = s
+		} else {
+			// prepared before the agent attached
+			statement = fmt.Sprintf("statement #%d", data.StmtID)
+		}
+	}
+
+	m := &Metric{
+		SourceIP:     net.IP(key.Conn.SourceIP[:]).String(),
+		SourcePort:   key.Conn.SourcePort,
+		DestIP:       net.IP(key.Conn.DestIP[:]).String(),
+		DestPort:     key.Conn.DestPort,
+		Command:      commandNames[data.Command],
+		Statement:    statement,
+		StmtID:       data.StmtID,
+		Duration:     data.Duration,
+		SocketCookie: data.Cookie,
+	}
+	if data.ResponseType == responseErr {
+		m.Error = true
+		m.ErrorCode = data.ErrorCode
+		m.ErrorMessage = string(cString(data.ErrorMsg[:], len(data.ErrorMsg)))
+	}
+	return m
+}
+
+func cString(buf []byte, length int) []byte {
+	if length > len(buf) {
+		length = len(buf)
+	}
+	buf = buf[:length]
+	if idx := bytes.IndexByte(buf, 0); idx >= 0 {
+		return buf[:idx]
+	}
+	return buf
+}
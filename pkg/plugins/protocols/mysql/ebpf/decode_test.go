@@ -0,0 +1,109 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// packet returns a mysql packet as sent on the wire: the 3 bytes payload length, the sequence id, the payload.
+func packet(seq byte, payload string) []byte {
+	b := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+	return append(b, payload...)
+}
+
+// capture returns the raw key and value of the metrics_map entry the kernel writes for a request and its
+// response, see handle_request and handle_response of ebpf/plugins/mysql/main.c.
+func capture(request, response []byte) (key, value []byte) {
+	key = make([]byte, 24)
+	copy(key, []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	binary.LittleEndian.PutUint16(key[8:], 41000)
+	binary.LittleEndian.PutUint16(key[10:], 3306)
+	binary.LittleEndian.PutUint64(key[16:], 1000)
+
+	value = make([]byte, 34+MysqlStatementSize+MysqlErrorMsgSize)
+	binary.LittleEndian.PutUint64(value[0:], 1000)
+	binary.LittleEndian.PutUint64(value[8:], 250000)
+	binary.LittleEndian.PutUint64(value[16:], 7)
+	command, payload := request[4], request[5:]
+	value[32] = command
+	if command == ComStmtExecute {
+		copy(value[24:28], payload[:4])
+	} else {
+		binary.LittleEndian.PutUint16(value[30:], uint16(copy(value[34:34+MysqlStatementSize], payload)))
+	}
+	value[33] = response[4]
+	switch {
+	case response[4] == responseErr:
+		copy(value[28:30], response[5:7])
+		msg := response[7:]
+		if msg[0] == '#' {
+			msg = msg[6:]
+		}
+		copy(value[34+MysqlStatementSize:], msg)
+	case command == ComStmtPrepare:
+		copy(value[24:28], response[5:9])
+	}
+	return key, value
+}
+
+func decode(t *testing.T, statements *statementCache, request, response []byte) *Metric {
+	rawKey, rawValue := capture(request, response)
+	var (
+		key  EventKey
+		data MysqlEvent
+	)
+	if binary.Size(key) != len(rawKey) || binary.Size(data) != len(rawValue) {
+		t.Fatalf("the key and the event do not match the layout of the C structs, %d and %d bytes", binary.Size(key), binary.Size(data))
+	}
+	if err := binary.Read(bytes.NewReader(rawKey), binary.LittleEndian, &key); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Read(bytes.NewReader(rawValue), binary.LittleEndian, &data); err != nil {
+		t.Fatal(err)
+	}
+	return decodeMetric(statements, &key, &data)
+}
+
+func TestDecodeMetric(t *testing.T) {
+	statements := newStatementCache()
+	m := decode(t, statements,
+		packet(0, "\x03SELECT id, total FROM orders WHERE user_id = 42 AND status = 'paid'"),
+		// column count of a result set
+		packet(1, "\x02"))
+	if m.SourceIP != "10.0.0.1" || m.SourcePort != 41000 || m.DestIP != "10.0.0.2" || m.DestPort != 3306 ||
+		m.Duration != 250000 || m.SocketCookie != 7 {
+		t.Errorf("unexpected connection of the query %+v", m)
+	}
+	if m.Command != "COM_QUERY" || m.Statement != "SELECT id, total FROM orders WHERE user_id = ? AND status = ?" || m.Error {
+		t.Errorf("unexpected query %+v", m)
+	}
+
+	m = decode(t, statements,
+		packet(0, "\x03SELECT * FROM order_items"),
+		packet(1, "\xff\x7a\x04#42S02Table 'shop.order_items' doesn't exist"))
+	if !m.Error || m.ErrorCode != 1146 || m.ErrorMessage != "Table 'shop.order_items' doesn't exist" {
+		t.Errorf("unexpected error %+v", m)
+	}
+
+	// COM_STMT_PREPARE_OK: status, statement id, columns, params, filler, warnings
+	if m := decode(t, statements,
+		packet(0, "\x16UPDATE users SET name = ? WHERE id = ?"),
+		packet(1, "\x00\x05\x00\x00\x00\x00\x00\x02\x00\x00\x00\x00")); m != nil {
+		t.Errorf("the prepared statements are not reported, got %+v", m)
+	}
+	// COM_STMT_EXECUTE: statement id, flags, iteration count, parameters
+	m = decode(t, statements,
+		packet(0, "\x17\x05\x00\x00\x00\x00\x01\x00\x00\x00\x00\x01\x0f\x00\x08\x00\x03bob\x2a\x00\x00\x00\x00\x00\x00\x00"),
+		// OK: affected rows, last insert id, status, warnings
+		packet(1, "\x00\x01\x00\x02\x00\x00\x00"))
+	if m.Command != "COM_STMT_EXECUTE" || m.StmtID != 5 || m.Statement != "UPDATE users SET name = ? WHERE id = ?" {
+		t.Errorf("unexpected execution %+v", m)
+	}
+	m = decode(t, statements,
+		packet(0, "\x17\x09\x00\x00\x00\x00\x01\x00\x00\x00"),
+		packet(1, "\x00\x00\x00\x02\x00\x00\x00"))
+	if m.StmtID != 9 || m.Statement != "statement #9" {
+		t.Errorf("the statements prepared before the agent attached are known by id, got %+v", m)
+	}
+}
@@ -0,0 +1,84 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	MysqlStatementSize = 256
+	MysqlErrorMsgSize  = 64
+)
+
+// command types, see https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_command_phase.html
+const (
+	ComQuery       uint8 = 0x03
+	ComStmtPrepare uint8 = 0x16
+	ComStmtExecute uint8 = 0x17
+)
+
+const (
+	responseOK  uint8 = 0x00
+	responseErr uint8 = 0xff
+)
+
+var commandNames = map[uint8]string{
+	ComQuery:       "COM_QUERY",
+	ComStmtPrepare: "COM_STMT_PREPARE",
+	ComStmtExecute: "COM_STMT_EXECUTE",
+}
+
+type ConnKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+type EventKey struct {
+	Conn ConnKey
+	// the C struct aligns request_ts to 8 bytes
+	_                uint32
+	RequestTimestamp uint64
+}
+
+type MysqlEvent struct {
+	RequestTimestamp uint64
+	Duration         uint64
+	Cookie           uint64
+	StmtID           uint32
+	ErrorCode        uint16
+	StatementLen     uint16
+	Command          uint8
+	ResponseType     uint8
+	Statement        [MysqlStatementSize]byte
+	ErrorMsg         [MysqlErrorMsgSize]byte
+}
+
+type Metric struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Command string
+	// Statement is the normalized sql statement, literals are replaced by '?'.
+	Statement string
+	StmtID    uint32
+
+	Error        bool
+	ErrorCode    uint16
+	ErrorMessage string
+
+	Duration uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s mysql [%s:%d] --> [%s:%d][%s %s] ====> %d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Command, m.Statement,
+		m.ErrorCode, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,159 @@
+package ebpf
+
+import (
+	"bytes"
+	"strings"
+)
+
+// tns packets and ttc messages, see ebpf/plugins/oracle/main.c
+const (
+	tnsHeaderSize = 12
+	tnsTypeData   = 6
+
+	ttcFunction  = 0x03
+	ttcPiggyback = 0x11
+
+	ttcOALL8     = 0x5e
+	ttcOCommit   = 0x0e
+	ttcORollback = 0x0f
+
+	// clrLongForm announces a text sent in chunks, each chunk is prefixed by its length up to an empty chunk.
+	clrLongForm = 0xfe
+
+	// errNoDataFound ends the fetch of the rows, it is not an error of the statement.
+	errNoDataFound = "01403"
+)
+
+// keywords start the statements of the calls, the text is found by them as the options
+// preceding it depend on the version of the client.
+var keywords = []string{
+	"SELECT", "INSERT", "UPDATE", "DELETE", "MERGE", "WITH", "BEGIN", "DECLARE", "CALL",
+	"CREATE", "ALTER", "DROP", "TRUNCATE", "LOCK", "GRANT", "REVOKE", "COMMIT", "ROLLBACK",
+	"SAVEPOINT", "SET", "EXPLAIN", "ANALYZE", "COMMENT", "RENAME",
+}
+
+type response struct {
+	code    string
+	message string
+}
+
+// parseCall returns the statement of a call: the text of an execution or COMMIT and ROLLBACK.
+// Piggybacked calls precede the call of the statement in the same packet.
+func parseCall(buf []byte) (string, bool) {
+	if len(buf) < tnsHeaderSize || buf[4] != tnsTypeData {
+		return "", false
+	}
+	msg := buf[tnsHeaderSize-2:]
+	if msg[0] == ttcPiggyback {
+		i := bytes.Index(msg, []byte{ttcFunction, ttcOALL8})
+		if i < 0 {
+			return "", false
+		}
+		msg = msg[i:]
+	}
+	if msg[0] != ttcFunction || len(msg) < 2 {
+		return "", false
+	}
+	switch msg[1] {
+	case ttcOALL8:
+		return statementText(msg[2:])
+	case ttcOCommit:
+		return "COMMIT", true
+	case ttcORollback:
+		return "ROLLBACK", true
+	default:
+		return "", false
+	}
+}
+
+// statementText finds the text of the statement of an execution, it is a length prefixed string
+// or chunks of the long form. The text may be truncated.
+func statementText(b []byte) (string, bool) {
+	for i := 1; i < len(b); i++ {
+		// the length may look like leading white space (e.g. \r), the text follows it
+		if b[i-1] == 0 || !startsStatement(b[i:]) {
+			continue
+		}
+		if i >= 2 && b[i-2] == clrLongForm {
+			return chunks(b[i-1:]), true
+		}
+		return string(b[i:min(i+int(b[i-1]), len(b))]), true
+	}
+	return "", false
+}
+
+func startsStatement(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n(")
+	for _, k := range keywords {
+		if len(b) < len(k) || !strings.EqualFold(string(b[:len(k)]), k) {
+			continue
+		}
+		if len(b) == len(k) || !isLetter(b[len(k)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func chunks(b []byte) string {
+	var s []byte
+	for len(b) > 0 && b[0] != 0 {
+		n := min(int(b[0]), len(b)-1)
+		s = append(s, b[1:1+n]...)
+		b = b[1+n:]
+	}
+	return string(s)
+}
+
+// parseResponse reads the ORA- error of the response to a call, responses without error are
+// successful. The end of the rows (ORA-01403) is not an error.
+func parseResponse(buf []byte) (response, bool) {
+	var resp response
+	if len(buf) < tnsHeaderSize || buf[4] != tnsTypeData {
+		return resp, false
+	}
+	b := buf[tnsHeaderSize-2:]
+	for {
+		i := bytes.Index(b, []byte("ORA-"))
+		if i < 0 || i+9 > len(b) {
+			return resp, true
+		}
+		code := b[i+4 : i+9]
+		b = b[i+4:]
+		if !isDigits(code) || string(code) == errNoDataFound {
+			continue
+		}
+		resp.code = string(code)
+		msg := b[5:]
+		if end := bytes.IndexFunc(msg, func(r rune) bool { return r == '\n' || r < ' ' }); end >= 0 {
+			msg = msg[:end]
+		}
+		resp.message = strings.TrimPrefix(string(msg), ": ")
+		return resp, true
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// operation returns the first keyword of a statement, e.g. SELECT.
+func operation(statement string) string {
+	statement = strings.TrimLeft(statement, " (")
+	end := strings.IndexFunc(statement, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(statement)
+	}
+	return strings.ToUpper(statement[:end])
+}
@@ -0,0 +1,124 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// dataPacket prefixes a ttc message with the tns header and the data flags.
+func dataPacket(msg []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(tnsHeaderSize+len(msg)))
+	b = append(b, 0, 0, tnsTypeData, 0, 0, 0, 0, 0)
+	return append(b, msg...)
+}
+
+// executePacket encodes an OALL8 call, the options before the text are the ones of ojdbc8.
+func executePacket(text string) []byte {
+	msg := []byte{ttcFunction, ttcOALL8, 0x04, 0x02, 0x80, 0x29, 0x00, 0x01, 0x01, byte(len(text)), 0x01, 0x01, 0x0d, 0x00, 0x00}
+	if len(text) < clrLongForm {
+		msg = append(msg, byte(len(text)))
+		return dataPacket(append(msg, text...))
+	}
+	msg = append(msg, clrLongForm)
+	for len(text) > 0 {
+		n := min(len(text), 64)
+		msg = append(append(msg, byte(n)), text[:n]...)
+		text = text[n:]
+	}
+	return dataPacket(append(msg, 0))
+}
+
+func errorPacket(code, message string) []byte {
+	msg := []byte{0x04, 0x01, 0x01, 0x02, 0x03, 0xaf, 0x00, 0x00}
+	msg = append(msg, byte(len(message)+11))
+	return dataPacket(append(msg, "ORA-"+code+": "+message+"\n"...))
+}
+
+func TestParseCall(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want string
+		ok   bool
+	}{
+		{"execute", executePacket("SELECT name FROM users WHERE id = :1"), "SELECT name FROM users WHERE id = :1", true},
+		{"leading spaces", executePacket("  begin pkg.run(:1); end;"), "  begin pkg.run(:1); end;", true},
+		{"long form", executePacket("SELECT " + strings.Repeat("col, ", 60) + "id FROM t"), "SELECT " + strings.Repeat("col, ", 60) + "id FROM t", true},
+		{"piggyback", dataPacket(append([]byte{ttcPiggyback, 0x69, 0x03, 0x01, 0x01, 0x05}, executePacket("DELETE FROM t")[tnsHeaderSize-2:]...)), "DELETE FROM t", true},
+		{"commit", dataPacket([]byte{ttcFunction, ttcOCommit, 0x05}), "COMMIT", true},
+		{"fetch", dataPacket([]byte{ttcFunction, 0x05, 0x06, 0x01, 0x0a}), "", false},
+		{"not data", append([]byte{0, 20, 0, 0, 1, 0, 0, 0}, make([]byte, 12)...), "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseCall(tt.buf)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: parseCall() = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	resp, ok := parseResponse(errorPacket("00942", "table or view does not exist"))
+	if !ok || resp.code != "00942" || resp.message != "table or view does not exist" {
+		t.Errorf("unexpected response %+v, %v", resp, ok)
+	}
+	resp, ok = parseResponse(errorPacket("01403", "no data found"))
+	if !ok || resp.code != "" {
+		t.Errorf("the end of the rows is not an error, got %+v, %v", resp, ok)
+	}
+	resp, ok = parseResponse(dataPacket([]byte{0x10, 0x17, 0x01, 0x02}))
+	if !ok || resp.code != "" {
+		t.Errorf("unexpected response %+v, %v", resp, ok)
+	}
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 1521}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, b []byte) *Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	var ev OracleEvent
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], b))
+	return t.handle(&key, &ev)
+}
+
+func TestTracker(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 10, true, executePacket("SELECT name FROM users WHERE id = 42"))
+	m := packet(tr, 25, false, dataPacket([]byte{0x10, 0x17, 0x01, 0x02}))
+	if m == nil || m.Statement != "SELECT name FROM users WHERE id = ?" || m.Operation != "SELECT" ||
+		m.Fingerprint == "" || m.Error || m.Duration != 15 || m.Captured != 25 || m.DestPort != 1521 {
+		t.Fatalf("unexpected call %+v", m)
+	}
+	if m := packet(tr, 30, false, dataPacket([]byte{0x07, 0x01})); m != nil {
+		t.Errorf("the following packets of the response should be ignored, got %+v", m)
+	}
+
+	packet(tr, 40, true, executePacket("INSERT INTO orders VALUES (:1, :2)"))
+	m = packet(tr, 45, false, errorPacket("00001", "unique constraint (APP.PK_ORDERS) violated"))
+	if m == nil || !m.Error || m.ErrorCode != "00001" || m.Operation != "INSERT" ||
+		m.ErrorMessage != "unique constraint (APP.PK_ORDERS) violated" {
+		t.Errorf("unexpected call %+v", m)
+	}
+}
+
+func TestServerAndExpire(t *testing.T) {
+	tr := newTracker()
+	// calls to the pod are reported by their clients
+	packet(tr, 1, false, executePacket("SELECT 1 FROM dual"))
+	if m := packet(tr, 2, true, dataPacket([]byte{0x10, 0x01})); m != nil {
+		t.Errorf("calls served by the pod should be ignored, got %+v", m)
+	}
+	packet(tr, 3, true, executePacket("SELECT 1 FROM dual"))
+	tr.expire(requestTimeout + 5)
+	if len(tr.pending) != 0 {
+		t.Errorf("calls without response should be dropped")
+	}
+}
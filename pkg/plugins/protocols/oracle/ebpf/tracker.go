@@ -0,0 +1,91 @@
+package ebpf
+
+import (
+	"net"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+const (
+	// requestTimeout drops calls without response, batch jobs run statements for minutes.
+	requestTimeout = uint64(600e9)
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+}
+
+// tracker pairs the calls with the first packet of their responses, the calls of a session are
+// answered before the next one is sent. Connections are keyed in the client -> server direction,
+// the client is the pod attached to the veth.
+type tracker struct {
+	pending map[ConnKey]*pending
+}
+
+func newTracker() *tracker {
+	return &tracker{pending: make(map[ConnKey]*pending)}
+}
+
+// handle processes a packet, it returns the call completed by it.
+func (t *tracker) handle(key *EventKey, ev *OracleEvent) *Metric {
+	buf := ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]
+	// calls of the pod and the responses to it, sessions of server pods are ignored that way.
+	if ev.FromPod == 1 {
+		text, ok := parseCall(buf)
+		if !ok {
+			return nil
+		}
+		statement := dbstatement.Normalize(text)
+		t.pending[key.Conn] = &pending{
+			metric: &Metric{
+				SourceIP:    net.IP(key.Conn.SourceIP[:]).String(),
+				SourcePort:  key.Conn.SourcePort,
+				DestIP:      net.IP(key.Conn.DestIP[:]).String(),
+				DestPort:    key.Conn.DestPort,
+				Statement:   statement,
+				Fingerprint: dbstatement.Fingerprint(statement),
+				Operation:   operation(statement),
+
+				SocketCookie: ev.Cookie,
+			},
+			ts: key.Timestamp,
+		}
+		return nil
+	}
+	connKey := ConnKey{
+		SourceIP:   key.Conn.DestIP,
+		DestIP:     key.Conn.SourceIP,
+		SourcePort: key.Conn.DestPort,
+		DestPort:   key.Conn.SourcePort,
+	}
+	p := t.pending[connKey]
+	if p == nil {
+		return nil
+	}
+	resp, ok := parseResponse(buf)
+	if !ok {
+		return nil
+	}
+	delete(t.pending, connKey)
+	m := p.metric
+	if resp.code != "" {
+		m.Error = true
+		m.ErrorCode = resp.code
+		m.ErrorMessage = resp.message
+	}
+	m.Captured = key.Timestamp
+	if key.Timestamp > p.ts {
+		m.Duration = key.Timestamp - p.ts
+	}
+	return m
+}
+
+// expire drops the calls without response within requestTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, p := range t.pending {
+		if now-p.ts > requestTimeout {
+			delete(t.pending, key)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	OraclePayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type OracleEvent struct {
+	socketfilter.Header
+	Payload [OraclePayloadSize]byte
+}
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Statement is the normalized statement, literals are replaced by '?', Fingerprint identifies it.
+	Statement   string
+	Fingerprint string
+	// Operation is the first keyword of the statement, e.g. SELECT or BEGIN for pl/sql blocks.
+	Operation string
+
+	Error bool
+	// ErrorCode is the number of the ORA- error, e.g. 00942.
+	ErrorCode    string
+	ErrorMessage string
+
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s oracle [%s:%d] --> [%s:%d][%s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Statement,
+		m.ErrorCode, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,170 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+const (
+	statementIdleTimeout = 10 * time.Minute
+)
+
+type preparedStatement struct {
+	statement string
+	lastSeen  time.Time
+}
+
+// statementCache resolves the statement names of Bind messages to the text
+// that was sent with Parse on the same connection.
+type statementCache struct {
+	sync.Mutex
+	statements map[string]*preparedStatement
+	clock      clock.Clock
+}
+
+func newStatementCache() *statementCache {
+	return &statementCache{
+		statements: make(map[string]*preparedStatement),
+		clock:      clock.Real,
+	}
+}
+
+func statementKey(key *ConnKey, name string) string {
+	return fmt.Sprintf("%s:%d-%s:%d/%s",
+		net.IP(key.SourceIP[:]).String(), key.SourcePort, net.IP(key.DestIP[:]).String(), key.DestPort, name)
+}
+
+func (c *statementCache) put(key *ConnKey, name, statement string) {
+	c.Lock()
+	defer c.Unlock()
+	c.statements[statementKey(key, name)] = &preparedStatement{
+		statement: statement,
+		lastSeen:  c.clock.Now(),
+	}
+}
+
+func (c *statementCache) get(key *ConnKey, name string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.statements[statementKey(key, name)]
+	if !ok {
+		return "", false
+	}
+	s.lastSeen = c.clock.Now()
+	return s.statement, true
+}
+
+func (c *statementCache) gc() {
+	c.Lock()
+	defer c.Unlock()
+	for k, s := range c.statements {
+		if c.clock.Since(s.lastSeen) > statementIdleTimeout {
+			delete(c.statements, k)
+		}
+	}
+}
+
+// decodeMetric converts a completed query to a metric. Parse and Bind are
+// pipelined with Execute and Sync, so they cover the whole extended query.
+func decodeMetric(statements *statementCache, key *EventKey, data *PgEvent) *Metric {
+	payload := data.Query[:]
+	if int(data.QueryLen) < len(payload) {
+		payload = payload[:data.QueryLen]
+	}
+	var statement string
+	switch data.Command {
+	case MsgQuery:
+		statement = dbstatement.Normalize(string(cString(payload)))
+	case MsgParse:
+		// Parse: statement name, query
+		name, rest := splitCString(payload)
+		statement = dbstatement.Normalize(string(cString(rest)))
+		if data.Error == 0 {
+			statements.put(&key.Conn, string(name), statement)
+		}
+	case MsgBind:
+		// Bind: portal name, statement name
+		_, rest := splitCString(payload)
+		name := string(cString(rest))
+		if s, ok := statements.get(&key.Conn, name); ok {
+			statement = s
+		} else {
+			// prepared before the agent attached
+			statement = fmt.Sprintf("statement %q", name)
+		}
+	}
+
+	m := &Metric{
+		SourceIP:     net.IP(key.Conn.SourceIP[:]).String(),
+		SourcePort:   key.Conn.SourcePort,
+		DestIP:       net.IP(key.Conn.DestIP[:]).String(),
+		DestPort:     key.Conn.DestPort,
+		Command:      commandNames[data.Command],
+		Statement:    statement,
+		Duration:     data.Duration,
+		SocketCookie: data.Cookie,
+	}
+	m.Operation, m.Rows = parseCommandTag(string(cString(data.Tag[:])))
+	if data.Error != 0 {
+		m.Error = true
+		m.ErrorCode, m.ErrorMessage = parseErrorFields(data.ErrorFields[:])
+	}
+	return m
+}
+
+// parseCommandTag splits a CommandComplete tag such as "INSERT 0 5" or "SELECT 3"
+// into the operation and the affected rows, rows is -1 when the tag has no count.
+func parseCommandTag(tag string) (string, int64) {
+	fields := strings.Fields(tag)
+	if len(fields) == 0 {
+		return "", -1
+	}
+	if len(fields) == 1 {
+		return fields[0], -1
+	}
+	rows, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return fields[0], -1
+	}
+	return fields[0], rows
+}
+
+// parseErrorFields reads the SQLSTATE and the message of an ErrorResponse body,
+// a sequence of one byte field types followed by a null terminated value.
+func parseErrorFields(buf []byte) (code, message string) {
+	for len(buf) > 0 && buf[0] != 0 {
+		field := buf[0]
+		var value []byte
+		value, buf = splitCString(buf[1:])
+		switch field {
+		case fieldCode:
+			code = string(value)
+		case fieldMessage:
+			message = string(value)
+		}
+	}
+	return code, message
+}
+
+func splitCString(buf []byte) ([]byte, []byte) {
+	idx := bytes.IndexByte(buf, 0)
+	if idx < 0 {
+		return buf, nil
+	}
+	return buf[:idx], buf[idx+1:]
+}
+
+func cString(buf []byte) []byte {
+	if idx := bytes.IndexByte(buf, 0); idx >= 0 {
+		return buf[:idx]
+	}
+	return buf
+}
@@ -0,0 +1,94 @@
+package ebpf
+
+import "testing"
+
+func TestParseCommandTag(t *testing.T) {
+	for _, c := range []struct {
+		tag       string
+		operation string
+		rows      int64
+	}{
+		{"SELECT 3", "SELECT", 3},
+		{"INSERT 0 5", "INSERT", 5},
+		{"UPDATE 0", "UPDATE", 0},
+		{"DELETE 12", "DELETE", 12},
+		{"COPY 1000", "COPY", 1000},
+		{"BEGIN", "BEGIN", -1},
+		{"CREATE TABLE", "CREATE", -1},
+		{"", "", -1},
+	} {
+		operation, rows := parseCommandTag(c.tag)
+		if operation != c.operation || rows != c.rows {
+			t.Errorf("%q: expected %s %d, got %s %d", c.tag, c.operation, c.rows, operation, rows)
+		}
+	}
+}
+
+func TestParseErrorFields(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		fields  string
+		code    string
+		message string
+	}{
+		{
+			"unique violation",
+			"SERROR\x00VERROR\x00C23505\x00Mduplicate key value violates unique constraint \"users_pkey\"\x00Fnbtinsert.c\x00\x00",
+			"23505", "duplicate key value violates unique constraint \"users_pkey\"",
+		},
+		{"undefined table", "SERROR\x00C42P01\x00Mrelation \"orders\" does not exist\x00\x00", "42P01", "relation \"orders\" does not exist"},
+		{"truncated by the capture", "SFATAL\x00C57P01\x00Mterminating conn", "57P01", "terminating conn"},
+		{"no field", "\x00", "", ""},
+	} {
+		code, message := parseErrorFields([]byte(c.fields))
+		if code != c.code || message != c.message {
+			t.Errorf("%s: expected %s %q, got %s %q", c.name, c.code, c.message, code, message)
+		}
+	}
+}
+
+func event(command uint8, payload, tag string) *PgEvent {
+	e := &PgEvent{Command: command, QueryLen: uint16(len(payload))}
+	copy(e.Query[:], payload)
+	copy(e.Tag[:], tag)
+	return e
+}
+
+func TestDecodeMetricBind(t *testing.T) {
+	statements := newStatementCache()
+	key := &EventKey{Conn: ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 5432}}
+	other := &EventKey{Conn: ConnKey{SourceIP: [4]byte{10, 0, 0, 3}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 5432}}
+
+	// Parse: statement name, query, parameter types
+	parse := decodeMetric(statements, key, event(MsgParse, "s1\x00SELECT name FROM users WHERE id = $1\x00\x00\x01", "PARSE"))
+	if parse.Command != "PARSE" || parse.Statement == "" {
+		t.Fatalf("unexpected parse %+v", parse)
+	}
+	for _, c := range []struct {
+		name      string
+		key       *EventKey
+		payload   string
+		statement string
+	}{
+		// Bind: portal name, statement name, parameters
+		{"prepared on the connection", key, "\x00s1\x00\x00\x01", parse.Statement},
+		{"prepared on another connection", other, "\x00s1\x00\x00\x01", `statement "s1"`},
+		{"prepared before the agent attached", key, "p1\x00s2\x00\x00\x00", `statement "s2"`},
+	} {
+		m := decodeMetric(statements, c.key, event(MsgBind, c.payload, "SELECT 1"))
+		if m.Command != "BIND" || m.Statement != c.statement || m.Operation != "SELECT" || m.Rows != 1 {
+			t.Errorf("%s: unexpected bind %+v", c.name, m)
+		}
+	}
+
+	// a failed Parse prepares no statement
+	failed := event(MsgParse, "s3\x00SELECT nope\x00", "")
+	failed.Error = 1
+	copy(failed.ErrorFields[:], "SERROR\x00C42703\x00Mcolumn \"nope\" does not exist\x00\x00")
+	if m := decodeMetric(statements, key, failed); !m.Error || m.ErrorCode != "42703" {
+		t.Errorf("unexpected failed parse %+v", m)
+	}
+	if _, ok := statements.get(&key.Conn, "s3"); ok {
+		t.Error("the statements failing to parse are not prepared")
+	}
+}
@@ -0,0 +1,75 @@
+package ebpf
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	programPath = "target/postgres.bpf.o"
+	programName = "socket__postgres_filter"
+	mapMetric   = "metrics_map"
+
+	// gcInterval is the interval the idle prepared statements are forgotten.
+	gcInterval = uint64(time.Minute)
+)
+
+type Interface interface {
+	Load() error
+	Close() error
+}
+
+type provider struct {
+	link attach.Link
+	ch   chan Metric
+
+	filter     *socketfilter.Filter
+	statements *statementCache
+}
+
+func New(l attach.Link, ch chan Metric) Interface {
+	return &provider{
+		link:       l,
+		ch:         ch,
+		statements: newStatementCache(),
+	}
+}
+
+func (e *provider) Load() error {
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		return exclusion.Apply(c, "postgres")
+	})
+	if err != nil {
+		return err
+	}
+	m := e.filter.Collection.DetachMap(mapMetric)
+	var gc uint64
+	go e.filter.Loop(func(now uint64) {
+		if now-gc >= gcInterval {
+			e.statements.gc()
+			gc = now
+		}
+		entries := socketfilter.Take[EventKey, PgEvent](m)
+		// a prepared statement must be known before its executions are decoded.
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Key.RequestTimestamp < entries[j].Key.RequestTimestamp
+		})
+		for i := range entries {
+			if metric := decodeMetric(e.statements, &entries[i].Key, &entries[i].Val); metric != nil {
+				e.ch <- *metric
+			}
+		}
+	})
+	return nil
+}
+
+func (e *provider) Close() error {
+	return e.filter.Close()
+}
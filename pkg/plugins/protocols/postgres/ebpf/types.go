@@ -0,0 +1,89 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	PgQuerySize = 256
+	PgTagSize   = 32
+	PgErrorSize = 128
+)
+
+// frontend message types, see https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	MsgQuery uint8 = 'Q'
+	MsgParse uint8 = 'P'
+	MsgBind  uint8 = 'B'
+)
+
+var commandNames = map[uint8]string{
+	MsgQuery: "QUERY",
+	MsgParse: "PARSE",
+	MsgBind:  "BIND",
+}
+
+// error and notice field types, see https://www.postgresql.org/docs/current/protocol-error-fields.html
+const (
+	fieldCode    = 'C'
+	fieldMessage = 'M'
+)
+
+type ConnKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+type EventKey struct {
+	Conn ConnKey
+	// the C struct aligns request_ts to 8 bytes
+	_                uint32
+	RequestTimestamp uint64
+}
+
+type PgEvent struct {
+	RequestTimestamp uint64
+	Duration         uint64
+	Cookie           uint64
+	Skip             uint32
+	QueryLen         uint16
+	Command          uint8
+	Error            uint8
+	Query            [PgQuerySize]byte
+	Tag              [PgTagSize]byte
+	ErrorFields      [PgErrorSize]byte
+}
+
+type Metric struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Command string
+	// Statement is the normalized sql statement, literals are replaced by '?'.
+	Statement string
+	// Operation is the command tag of CommandComplete without the row count, e.g. SELECT or INSERT.
+	Operation string
+	Rows      int64
+
+	Error bool
+	// ErrorCode is the SQLSTATE of the ErrorResponse.
+	ErrorCode    string
+	ErrorMessage string
+
+	Duration uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s postgres [%s:%d] --> [%s:%d][%s %s] ====> %s rows: %d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Command, m.Statement,
+		m.ErrorCode, m.Rows, time.Duration(m.Duration).String(),
+	)
+}
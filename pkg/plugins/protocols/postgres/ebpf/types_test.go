@@ -0,0 +1,14 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// TestEventKeySize checks the key matches the layout of struct event_key, the map lookups and deletes
+// of the drained events fail otherwise.
+func TestEventKeySize(t *testing.T) {
+	if size := binary.Size(EventKey{}); size != 24 {
+		t.Errorf("expected the 24 bytes of the C key, got %d", size)
+	}
+}
@@ -0,0 +1,272 @@
+package ebpf
+
+import (
+	"encoding/binary"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// see org.apache.pulsar.common.protocol.Commands and PulsarApi.proto
+const (
+	// total size and command size
+	headerSize = 8
+
+	// magicBrokerEntryMetadata prefixes the broker entry metadata of messages, magicCrc32c the checksum.
+	magicBrokerEntryMetadata = 0x0e02
+	magicCrc32c              = 0x0e01
+)
+
+// types of BaseCommand, the command of each type is the field of the same number.
+const (
+	typeSubscribe     = 4
+	typeProducer      = 5
+	typeSend          = 6
+	typeSendReceipt   = 7
+	typeSendError     = 8
+	typeMessage       = 9
+	typeCloseProducer = 15
+	typeCloseConsumer = 16
+)
+
+// field numbers of BaseCommand, the commands and MessageMetadata
+const (
+	baseType protowire.Number = 1
+
+	producerTopic protowire.Number = 1
+	producerID    protowire.Number = 2
+
+	subscribeTopic        protowire.Number = 1
+	subscribeSubscription protowire.Number = 2
+	subscribeSubType      protowire.Number = 3
+	subscribeConsumerID   protowire.Number = 4
+
+	// the producer id and the sequence id of sends, receipts and send errors
+	sendProducerID  protowire.Number = 1
+	sendSequenceID  protowire.Number = 2
+	sendNumMessages protowire.Number = 3
+	sendErrorCode   protowire.Number = 3
+	sendErrorText   protowire.Number = 4
+
+	// the consumer id of messages and of the close of consumers, the producer id of the close of producers
+	closeOrMessageID protowire.Number = 1
+
+	metadataNumMessagesInBatch protowire.Number = 11
+)
+
+// subTypeNames are the names of CommandSubscribe.SubType.
+var subTypeNames = map[uint64]string{
+	0: "Exclusive",
+	1: "Shared",
+	2: "Failover",
+	3: "Key_Shared",
+}
+
+// serverErrorNames are the names of ServerError.
+var serverErrorNames = map[int]string{
+	0:  "UnknownError",
+	1:  "MetadataError",
+	2:  "PersistenceError",
+	3:  "AuthenticationError",
+	4:  "AuthorizationError",
+	5:  "ConsumerBusy",
+	6:  "ServiceNotReady",
+	7:  "ProducerBlockedQuotaExceededError",
+	8:  "ProducerBlockedQuotaExceededException",
+	9:  "ChecksumError",
+	10: "UnsupportedVersionError",
+	11: "TopicNotFound",
+	12: "SubscriptionNotFound",
+	13: "ConsumerNotFound",
+	14: "TooManyRequests",
+	15: "TopicTerminatedError",
+	16: "ProducerBusy",
+	17: "InvalidTopicName",
+	18: "IncompatibleSchema",
+	19: "ConsumerAssignError",
+	20: "TransactionCoordinatorNotFound",
+	21: "InvalidTxnStatus",
+	22: "NotAllowedError",
+	23: "TransactionConflict",
+	24: "TransactionNotFound",
+	25: "ProducerFenced",
+}
+
+type command struct {
+	typ int
+	// producerID is the id of producers, consumerID the id of consumers.
+	producerID   uint64
+	consumerID   uint64
+	sequenceID   uint64
+	topic        string
+	subscription string
+	subType      string
+	numMessages  int
+	errorCode    int
+	errorText    string
+}
+
+// parseFrames parses the commands of a packet, the command of the last frame may be truncated.
+// Commands without the ids they are paired by are left out, as well as the untracked types.
+func parseFrames(buf []byte) []command {
+	var commands []command
+	for len(buf) >= headerSize {
+		total := int(binary.BigEndian.Uint32(buf[0:4]))
+		commandSize := int(binary.BigEndian.Uint32(buf[4:8]))
+		if commandSize+4 > total {
+			break
+		}
+		rest := buf[headerSize:]
+		c, ok := parseCommand(rest[:min(commandSize, len(rest))])
+		if ok && c.typ == typeMessage && commandSize <= len(rest) {
+			c.numMessages = numMessagesInBatch(rest[commandSize:min(total-4, len(rest))])
+		}
+		if ok {
+			commands = append(commands, c)
+		}
+		if 4+total > len(buf) {
+			break
+		}
+		buf = buf[4+total:]
+	}
+	return commands
+}
+
+// fields calls fn with the number and the value of each field of a protobuf message, the fields
+// before the truncation are visited.
+func fields(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return
+		}
+		fn(num, typ, b[:n])
+		b = b[n:]
+	}
+}
+
+func varint(typ protowire.Type, value []byte) (uint64, bool) {
+	if typ != protowire.VarintType {
+		return 0, false
+	}
+	v, n := protowire.ConsumeVarint(value)
+	return v, n > 0
+}
+
+func str(typ protowire.Type, value []byte) (string, bool) {
+	if typ != protowire.BytesType {
+		return "", false
+	}
+	v, n := protowire.ConsumeBytes(value)
+	return string(v), n > 0
+}
+
+// parseCommand parses the BaseCommand of a frame and the command of its type.
+func parseCommand(b []byte) (command, bool) {
+	c := command{numMessages: 1}
+	var (
+		hasType bool
+		nested  = make(map[protowire.Number]string)
+	)
+	fields(b, func(num protowire.Number, typ protowire.Type, value []byte) {
+		if num == baseType {
+			if v, ok := varint(typ, value); ok {
+				c.typ, hasType = int(v), true
+			}
+			return
+		}
+		if v, ok := str(typ, value); ok {
+			nested[num] = v
+		}
+	})
+	sub, ok := nested[protowire.Number(c.typ)]
+	if !hasType || !ok {
+		return c, false
+	}
+	seen := make(map[protowire.Number]bool)
+	fields([]byte(sub), func(num protowire.Number, typ protowire.Type, value []byte) {
+		if v, ok := varint(typ, value); ok {
+			seen[num] = true
+			switch {
+			case c.typ == typeProducer && num == producerID:
+				c.producerID = v
+			case c.typ == typeSubscribe && num == subscribeSubType:
+				c.subType = subTypeNames[v]
+			case c.typ == typeSubscribe && num == subscribeConsumerID:
+				c.consumerID = v
+			case c.typ == typeSend && num == sendNumMessages:
+				c.numMessages = int(v)
+			case c.typ == typeSendError && num == sendErrorCode:
+				c.errorCode = int(v)
+			case num == sendProducerID && (c.typ == typeSend || c.typ == typeSendReceipt || c.typ == typeSendError):
+				c.producerID = v
+			case num == sendSequenceID && (c.typ == typeSend || c.typ == typeSendReceipt || c.typ == typeSendError):
+				c.sequenceID = v
+			case num == closeOrMessageID && c.typ == typeCloseProducer:
+				c.producerID = v
+			case num == closeOrMessageID && (c.typ == typeMessage || c.typ == typeCloseConsumer):
+				c.consumerID = v
+			}
+			return
+		}
+		v, ok := str(typ, value)
+		if !ok {
+			return
+		}
+		seen[num] = true
+		switch {
+		case c.typ == typeProducer && num == producerTopic, c.typ == typeSubscribe && num == subscribeTopic:
+			c.topic = v
+		case c.typ == typeSubscribe && num == subscribeSubscription:
+			c.subscription = v
+		case c.typ == typeSendError && num == sendErrorText:
+			c.errorText = v
+		}
+	})
+	switch c.typ {
+	case typeProducer:
+		return c, seen[producerID]
+	case typeSubscribe:
+		return c, seen[subscribeConsumerID]
+	case typeSend, typeSendReceipt, typeSendError:
+		return c, seen[sendProducerID] && seen[sendSequenceID]
+	case typeMessage, typeCloseProducer, typeCloseConsumer:
+		return c, seen[closeOrMessageID]
+	default:
+		return c, false
+	}
+}
+
+// numMessagesInBatch reads the number of messages from the metadata of the payload of a message,
+// the payload may start with the broker entry metadata and the checksum. It is 1 if unknown.
+func numMessagesInBatch(b []byte) int {
+	if len(b) >= 6 && binary.BigEndian.Uint16(b[0:2]) == magicBrokerEntryMetadata {
+		size := int(binary.BigEndian.Uint32(b[2:6]))
+		if len(b) < 6+size {
+			return 1
+		}
+		b = b[6+size:]
+	}
+	if len(b) >= 6 && binary.BigEndian.Uint16(b[0:2]) == magicCrc32c {
+		b = b[6:]
+	}
+	if len(b) < 4 {
+		return 1
+	}
+	size := int(binary.BigEndian.Uint32(b[0:4]))
+	b = b[4:]
+	n := 1
+	fields(b[:min(size, len(b))], func(num protowire.Number, typ protowire.Type, value []byte) {
+		if num != metadataNumMessagesInBatch {
+			return
+		}
+		if v, ok := varint(typ, value); ok && v > 0 {
+			n = int(v)
+		}
+	})
+	return n
+}
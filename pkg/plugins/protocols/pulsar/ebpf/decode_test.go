@@ -0,0 +1,141 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// pb encodes the fields of a message, values are uint64, string or []byte (nested messages).
+func pb(kv ...interface{}) []byte {
+	var b []byte
+	for i := 0; i < len(kv); i += 2 {
+		num := protowire.Number(kv[i].(int))
+		switch v := kv[i+1].(type) {
+		case int:
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		case string:
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		case []byte:
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+	return b
+}
+
+func frame(typ int, cmd []byte, payload []byte) []byte {
+	base := pb(1, typ, typ, cmd)
+	b := binary.BigEndian.AppendUint32(nil, uint32(4+len(base)+len(payload)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(base)))
+	return append(append(b, base...), payload...)
+}
+
+func messagePayload(numMessages int) []byte {
+	meta := pb(1, "producer", 2, 7, 3, 1700000000000, int(metadataNumMessagesInBatch), numMessages)
+	b := binary.BigEndian.AppendUint16(nil, magicCrc32c)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(meta)))
+	return append(append(b, meta...), "body"...)
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 6650}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, frames ...[]byte) []*Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	ev := PulsarEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	var buf []byte
+	for _, f := range frames {
+		buf = append(buf, f...)
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	return t.handle(&key, &ev)
+}
+
+const orders = "persistent://public/default/orders"
+
+func TestSend(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, frame(typeProducer, pb(1, orders, 2, 3, 3, 1), nil))
+	// a ping in between, the payload of the sends follows the command
+	packet(tr, 10, true,
+		frame(typeSend, pb(1, 3, 2, 100, 3, 5), messagePayload(5)),
+		frame(18, nil, nil),
+		frame(typeSend, pb(1, 3, 2, 101), messagePayload(1)))
+	done := packet(tr, 30, false,
+		frame(typeSendError, pb(1, 3, 2, 101, 3, 7, 4, "quota exceeded"), nil),
+		frame(typeSendReceipt, pb(1, 3, 2, 100, 3, pb(1, 12, 2, 34)), nil))
+	if len(done) != 2 {
+		t.Fatalf("expected 2 sends, got %d", len(done))
+	}
+	m := done[0]
+	if m.Kind != KindSend || m.Topic != orders || !m.Error || m.ServerError != 7 ||
+		m.ServerErrorName != "ProducerBlockedQuotaExceededError" || m.ErrorMessage != "quota exceeded" || m.Duration != 20 {
+		t.Errorf("unexpected send %+v", m)
+	}
+	m = done[1]
+	if m.Topic != orders || m.Error || m.NumMessages != 5 || m.SourcePort != 40000 || m.DestPort != 6650 {
+		t.Errorf("unexpected send %+v", m)
+	}
+	if len(tr.conns[client].sends) != 0 {
+		t.Errorf("completed sends should be removed")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, frame(typeSubscribe, pb(1, orders, 2, "billing", 3, 3, 4, 9, 5, 1), nil))
+	done := packet(tr, 2, false, frame(typeMessage, pb(1, 9, 2, pb(1, 12, 2, 34)), messagePayload(10)))
+	if len(done) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(done))
+	}
+	m := done[0]
+	if m.Kind != KindMessage || m.Topic != orders || m.Subscription != "billing" || m.SubscriptionType != "Key_Shared" ||
+		m.NumMessages != 10 || m.Duration != 0 {
+		t.Errorf("unexpected message %+v", m)
+	}
+	// closed by the broker
+	packet(tr, 3, false, frame(typeCloseConsumer, pb(1, 9, 2, 0), nil))
+	if done := packet(tr, 4, false, frame(typeMessage, pb(1, 9), nil)); len(done) != 1 || done[0].Topic != "" || done[0].NumMessages != 1 {
+		t.Errorf("unexpected messages of an unknown consumer %+v", done)
+	}
+}
+
+func TestServerAndExpire(t *testing.T) {
+	tr := newTracker()
+	// sends to the pod are reported by their clients
+	packet(tr, 1, false, frame(typeSend, pb(1, 1, 2, 1), nil))
+	if done := packet(tr, 2, true, frame(typeSendReceipt, pb(1, 1, 2, 1), nil)); len(done) != 0 {
+		t.Errorf("sends served by the pod should be ignored, got %+v", done)
+	}
+	packet(tr, 3, true, frame(typeSend, pb(1, 1, 2, 2), nil))
+	tr.expire(requestTimeout + 5)
+	if len(tr.conns[client].sends) != 0 {
+		t.Errorf("sends without receipt should be dropped")
+	}
+	tr.expire(connIdleTimeout + 5)
+	if len(tr.conns) != 0 {
+		t.Errorf("idle connections should be dropped")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	f := frame(typeSubscribe, pb(1, orders, 2, "billing", 4, 9), nil)
+	if commands := parseFrames(f[:len(f)-3]); len(commands) != 0 {
+		t.Errorf("consumers without id should be left out, got %+v", commands)
+	}
+	f = frame(typeMessage, pb(1, 9), messagePayload(10))
+	if commands := parseFrames(f[:len(f)-8]); len(commands) != 1 || commands[0].numMessages != 1 {
+		t.Errorf("unexpected commands %+v", commands)
+	}
+}
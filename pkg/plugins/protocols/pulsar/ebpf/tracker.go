@@ -0,0 +1,167 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// requestTimeout drops sends without receipt, it is above the send timeout of the clients (30s).
+	requestTimeout = uint64(60e9)
+	// connIdleTimeout drops the producers and consumers of connections without packets, the clients
+	// ping the broker every 30s.
+	connIdleTimeout = uint64(300e9)
+	// maxPendingPerConn bounds the memory of connections whose receipts are not captured.
+	maxPendingPerConn = 4096
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+}
+
+type sendKey struct {
+	producerID uint64
+	sequenceID uint64
+}
+
+type consumer struct {
+	topic        string
+	subscription string
+	subType      string
+}
+
+// conn is the state of a client connection. Clients register their producers and consumers with
+// ids of the connection, the later commands only carry these ids.
+type conn struct {
+	producers map[uint64]string
+	consumers map[uint64]consumer
+	sends     map[sendKey]*pending
+	lastSeen  uint64
+	// cookie is the socket cookie of the client, taken from its packets.
+	cookie uint64
+}
+
+// tracker pairs the sends with their receipts by producer and sequence id of the connection.
+// Connections are keyed in the client -> broker direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]*conn
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]*conn)}
+}
+
+// handle processes the commands of a packet, it returns the sends completed by it and the messages
+// delivered by it.
+func (t *tracker) handle(key *EventKey, ev *PulsarEvent) []*Metric {
+	fromPod := ev.FromPod == 1
+	connKey := key.Conn
+	if !fromPod {
+		connKey = ConnKey{
+			SourceIP:   key.Conn.DestIP,
+			DestIP:     key.Conn.SourceIP,
+			SourcePort: key.Conn.DestPort,
+			DestPort:   key.Conn.SourcePort,
+		}
+	}
+	c := t.conns[connKey]
+	if c == nil {
+		c = &conn{
+			producers: make(map[uint64]string),
+			consumers: make(map[uint64]consumer),
+			sends:     make(map[sendKey]*pending),
+		}
+		t.conns[connKey] = c
+	}
+	c.lastSeen = key.Timestamp
+	if fromPod && ev.Cookie != 0 {
+		c.cookie = ev.Cookie
+	}
+	var done []*Metric
+	for _, cmd := range parseFrames(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]) {
+		// commands of the pod and the replies to it, connections of broker pods are ignored that way.
+		switch {
+		case cmd.typ == typeProducer && fromPod:
+			if len(c.producers) < maxPendingPerConn {
+				c.producers[cmd.producerID] = cmd.topic
+			}
+		case cmd.typ == typeSubscribe && fromPod:
+			if len(c.consumers) < maxPendingPerConn {
+				c.consumers[cmd.consumerID] = consumer{topic: cmd.topic, subscription: cmd.subscription, subType: cmd.subType}
+			}
+		// producers and consumers are closed by the clients, or by the brokers when the topic is unloaded.
+		case cmd.typ == typeCloseProducer:
+			delete(c.producers, cmd.producerID)
+		case cmd.typ == typeCloseConsumer:
+			delete(c.consumers, cmd.consumerID)
+		case cmd.typ == typeSend && fromPod:
+			if len(c.sends) >= maxPendingPerConn {
+				continue
+			}
+			c.sends[sendKey{cmd.producerID, cmd.sequenceID}] = &pending{
+				metric: &Metric{
+					SourceIP:    net.IP(connKey.SourceIP[:]).String(),
+					SourcePort:  connKey.SourcePort,
+					DestIP:      net.IP(connKey.DestIP[:]).String(),
+					DestPort:    connKey.DestPort,
+					Kind:        KindSend,
+					Topic:       c.producers[cmd.producerID],
+					NumMessages: cmd.numMessages,
+
+					SocketCookie: c.cookie,
+				},
+				ts: key.Timestamp,
+			}
+		case (cmd.typ == typeSendReceipt || cmd.typ == typeSendError) && !fromPod:
+			k := sendKey{cmd.producerID, cmd.sequenceID}
+			p, ok := c.sends[k]
+			if !ok {
+				continue
+			}
+			delete(c.sends, k)
+			m := p.metric
+			if cmd.typ == typeSendError {
+				m.Error = true
+				m.ServerError = cmd.errorCode
+				m.ServerErrorName = serverErrorNames[cmd.errorCode]
+				m.ErrorMessage = cmd.errorText
+			}
+			m.Captured = key.Timestamp
+			if key.Timestamp > p.ts {
+				m.Duration = key.Timestamp - p.ts
+			}
+			done = append(done, m)
+		case cmd.typ == typeMessage && !fromPod:
+			s := c.consumers[cmd.consumerID]
+			done = append(done, &Metric{
+				SourceIP:         net.IP(connKey.SourceIP[:]).String(),
+				SourcePort:       connKey.SourcePort,
+				DestIP:           net.IP(connKey.DestIP[:]).String(),
+				DestPort:         connKey.DestPort,
+				Kind:             KindMessage,
+				Topic:            s.topic,
+				Subscription:     s.subscription,
+				SubscriptionType: s.subType,
+				NumMessages:      cmd.numMessages,
+				Captured:         key.Timestamp,
+				SocketCookie:     c.cookie,
+			})
+		}
+	}
+	return done
+}
+
+// expire drops the sends without receipt within requestTimeout and the connections idle for
+// connIdleTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, c := range t.conns {
+		for k, p := range c.sends {
+			if now-p.ts > requestTimeout {
+				delete(c.sends, k)
+			}
+		}
+		if now-c.lastSeen > connIdleTimeout {
+			delete(t.conns, key)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	PulsarPayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type PulsarEvent struct {
+	socketfilter.Header
+	Payload [PulsarPayloadSize]byte
+}
+
+type Kind int
+
+const (
+	KindSend Kind = iota
+	KindMessage
+)
+
+func (k Kind) String() string {
+	if k == KindSend {
+		return "send"
+	}
+	return "message"
+}
+
+type Metric struct {
+	// Source is the client, Dest the broker.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Kind Kind
+	// Topic is empty for the producers and consumers registered before the capture started.
+	Topic string
+	// Subscription and SubscriptionType are set for messages.
+	Subscription     string
+	SubscriptionType string
+	// NumMessages is the number of messages of the batch.
+	NumMessages int
+
+	// ServerError is the error code of failed sends, ServerErrorName its name.
+	ServerError     int
+	ServerErrorName string
+	ErrorMessage    string
+	Error           bool
+
+	// Duration is not set for messages, they are pushed by the broker.
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s pulsar [%s:%d] --> [%s:%d][%s %s %s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Kind, m.Topic, m.Subscription,
+		m.ServerErrorName, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,133 @@
+package pulsar
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/pulsar/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_mq"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("pulsar", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "pulsar", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"message_bus_destination": m.Topic,
+			"pulsar_topic":            m.Topic,
+			"pulsar_command":          strings.ToUpper(m.Kind.String()),
+			"error":                   strconv.FormatBool(m.Error),
+		},
+		Fields: map[string]interface{}{
+			"elapsed_count": 1,
+			"elapsed_sum":   m.Duration,
+			"elapsed_max":   m.Duration,
+			"elapsed_min":   m.Duration,
+			"elapsed_mean":  m.Duration,
+			"message_count": m.NumMessages,
+		},
+	}
+	if m.Kind == ebpf.KindMessage {
+		output.Tags["pulsar_subscription"] = m.Subscription
+		output.Tags["pulsar_subscription_type"] = m.SubscriptionType
+	}
+	if m.Error {
+		output.Tags["pulsar_error"] = m.ServerErrorName
+		output.Tags["pulsar_error_message"] = m.ErrorMessage
+		p.errorCodes.Tag(output.Tags, errorcodes.Pulsar, strconv.Itoa(m.ServerError))
+	}
+
+	// the source is the client pod, the target the broker.
+	inCluster := p.enricher.Enrich(output, "PULSAR", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	switch m.Kind {
+	case ebpf.KindSend:
+		output.Tags["span_kind"] = "producer"
+		output.Tags["message_bus_status"] = "PUBLISH_SUCCESS"
+		if m.Error {
+			output.Tags["message_bus_status"] = "PUBLISH_FAILED"
+		}
+	case ebpf.KindMessage:
+		output.Tags["span_kind"] = "consumer"
+		// messages are pushed by the broker, their processing is not observed.
+		output.Tags["message_bus_status"] = "CONSUME_SUCCESS"
+	}
+	// brokers outside the cluster (e.g. StreamNative cloud) are still reported, they are not part of the topology.
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("pulsar", &servicehub.Spec{
+		Services:             []string{"pulsar"},
+		Description:          "ebpf for pulsar binary protocol",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,74 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// see https://www.rfc-editor.org/rfc/rfc9000#section-17.2 and https://www.rfc-editor.org/rfc/rfc9369#section-3.2
+const (
+	headerFormLong = 0x80
+	maxConnIDLen   = 20
+
+	version1 = 0x00000001
+	version2 = 0x6b3343cf
+)
+
+type packetType uint8
+
+const (
+	packetInitial packetType = iota
+	packetZeroRTT
+	packetHandshake
+	packetRetry
+	packetVersionNegotiation
+)
+
+type longHeader struct {
+	typ     packetType
+	version uint32
+}
+
+// parseLongHeader parses the invariant part of a long header and the type of the packet, it returns false
+// for short headers and malformed packets.
+func parseLongHeader(buf []byte) (longHeader, bool) {
+	var h longHeader
+	// first byte, version and the length of the destination connection id
+	if len(buf) < 6 || buf[0]&headerFormLong == 0 {
+		return h, false
+	}
+	h.version = binary.BigEndian.Uint32(buf[1:5])
+	dcidLen := int(buf[5])
+	if dcidLen > maxConnIDLen {
+		return h, false
+	}
+	if 6+dcidLen < len(buf) && int(buf[6+dcidLen]) > maxConnIDLen {
+		return h, false
+	}
+	if h.version == 0 {
+		h.typ = packetVersionNegotiation
+		return h, true
+	}
+	bits := packetType(buf[0]&0x30) >> 4
+	switch h.version {
+	case version2:
+		// the types of version 2 are rotated, Retry is 0 and Initial 1.
+		h.typ = (bits + 3) % 4
+	default:
+		h.typ = bits
+	}
+	return h, true
+}
+
+// VersionName is the name of a version, e.g. 1, 2 or draft-29.
+func VersionName(version uint32) string {
+	switch {
+	case version == version1:
+		return "1"
+	case version == version2:
+		return "2"
+	case version&0xffffff00 == 0xff000000:
+		return fmt.Sprintf("draft-%d", version&0xff)
+	}
+	return fmt.Sprintf("0x%08x", version)
+}
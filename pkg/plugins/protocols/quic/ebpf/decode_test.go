@@ -0,0 +1,110 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// longPacket builds the start of a long header packet with 8 bytes connection ids.
+func longPacket(typeBits byte, version uint32) []byte {
+	b := []byte{0xc0 | typeBits<<4}
+	b = binary.BigEndian.AppendUint32(b, version)
+	b = append(b, 8, 1, 2, 3, 4, 5, 6, 7, 8)
+	return append(b, 8, 9, 10, 11, 12, 13, 14, 15, 16)
+}
+
+func TestParseLongHeader(t *testing.T) {
+	cases := []struct {
+		name string
+		buf  []byte
+		want longHeader
+		ok   bool
+	}{
+		{"initial v1", longPacket(0, version1), longHeader{typ: packetInitial, version: version1}, true},
+		{"handshake v1", longPacket(2, version1), longHeader{typ: packetHandshake, version: version1}, true},
+		{"retry v1", longPacket(3, version1), longHeader{typ: packetRetry, version: version1}, true},
+		{"initial v2", longPacket(1, version2), longHeader{typ: packetInitial, version: version2}, true},
+		{"0-rtt v2", longPacket(2, version2), longHeader{typ: packetZeroRTT, version: version2}, true},
+		{"retry v2", longPacket(0, version2), longHeader{typ: packetRetry, version: version2}, true},
+		{"version negotiation", append([]byte{0x80, 0, 0, 0, 0, 0, 0}, binary.BigEndian.AppendUint32(nil, version1)...),
+			longHeader{typ: packetVersionNegotiation}, true},
+		{"short header", []byte{0x40, 1, 2, 3, 4, 5, 6, 7}, longHeader{}, false},
+		{"connection id too long", append([]byte{0xc0, 0, 0, 0, 1, 21}, make([]byte, 21)...), longHeader{version: version1}, false},
+		{"cut", []byte{0xc0, 0, 0}, longHeader{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseLongHeader(c.buf)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("%s: parseLongHeader() = %+v, %v, want %+v, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestVersionName(t *testing.T) {
+	for version, want := range map[uint32]string{version1: "1", version2: "2", 0xff00001d: "draft-29", 0x51303530: "0x51303530"} {
+		if got := VersionName(version); got != want {
+			t.Errorf("VersionName(%#x) = %s, want %s", version, got, want)
+		}
+	}
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 50000, DestPort: 443}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromClient bool, buf []byte) {
+	key := EventKey{Conn: server, Timestamp: ts}
+	ev := QuicEvent{}
+	if fromClient {
+		key.Conn = client
+		ev.Flag = 1
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	t.handle(&key, &ev)
+}
+
+func TestHandshake(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1000, true, longPacket(0, version1))
+	packet(tr, 1100, false, longPacket(3, version1))
+	packet(tr, 1200, true, longPacket(0, version1))
+	packet(tr, 1300, false, longPacket(2, version1))
+	conn := QuicConn{FirstTimestamp: 1000, LastTimestamp: 9000, ClientShortTimestamp: 1500, ServerShortTimestamp: 1400,
+		ClientPackets: 10, ServerPackets: 12, ClientBytes: 4000, ServerBytes: 9000, Cookie: 7, ServerIsPod: 1}
+	m := tr.report(client, &conn)
+	if m.Handshake != HandshakeComplete || m.HandshakeDuration != 500 || m.Version != "1" || !m.Retry || m.ZeroRTT ||
+		m.Duration != 8000 || !m.ServerIsPod || m.SocketCookie != 7 || m.DestPort != 443 || m.ClientBytes != 4000 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+	if len(tr.handshakes) != 0 {
+		t.Errorf("the handshake of a reported connection should be dropped")
+	}
+
+	// the server never completes the handshake
+	packet(tr, 20000, true, longPacket(0, version1))
+	packet(tr, 20100, true, longPacket(1, version1))
+	m = tr.report(client, &QuicConn{FirstTimestamp: 20000, LastTimestamp: 20100})
+	if m.Handshake != HandshakeIncomplete || m.HandshakeDuration != 0 || !m.ZeroRTT {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	// established before the program was attached
+	m = tr.report(client, &QuicConn{FirstTimestamp: 30000, LastTimestamp: 40000, ClientShortTimestamp: 30000})
+	if m.Handshake != HandshakeUnobserved || m.Version != "" {
+		t.Errorf("unexpected metric %+v", m)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	conn := QuicConn{LastTimestamp: 1e9}
+	if idle(&conn, 1e9+idleTimeout) || !idle(&conn, 2e9+idleTimeout) {
+		t.Errorf("connections should be idle after %d ns", idleTimeout)
+	}
+	tr := newTracker()
+	packet(tr, 1e9, true, longPacket(0, version1))
+	tr.expire(1e9 + 3*idleTimeout)
+	if len(tr.handshakes) != 0 {
+		t.Errorf("handshakes of unreported connections should expire")
+	}
+}
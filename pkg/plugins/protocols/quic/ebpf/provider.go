@@ -0,0 +1,79 @@
+package ebpf
+
+import (
+	"github.com/cilium/ebpf"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	protocol    = "quic"
+	programPath = "target/quic.bpf.o"
+	programName = "socket__quic_filter"
+	mapMetric   = "metrics_map"
+	mapConn     = "conn_map"
+)
+
+type Interface interface {
+	Load() error
+	Close() error
+}
+
+type provider struct {
+	link attach.Link
+	ch   chan Metric
+
+	filter *socketfilter.Filter
+}
+
+func New(l attach.Link, ch chan Metric) Interface {
+	return &provider{
+		link: l,
+		ch:   ch,
+	}
+}
+
+func (e *provider) Load() error {
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		// 443 is the port of QUIC, the global excluded ports are not applied.
+		return exclusion.ApplyProtocol(c, protocol)
+	})
+	if err != nil {
+		return err
+	}
+	t := newTracker()
+	conns := e.filter.Collection.DetachMap(mapConn)
+	go socketfilter.Drain(e.filter, e.filter.Collection.DetachMap(mapMetric), t.handle, func(now uint64) {
+		e.reportIdle(conns, t, now)
+		t.expire(now)
+	})
+	return nil
+}
+
+// reportIdle reports and removes the connections of the kernel gone idle.
+func (e *provider) reportIdle(conns *ebpf.Map, t *tracker, now uint64) {
+	var (
+		key  ConnKey
+		conn QuicConn
+		done []ConnKey
+	)
+	for it := conns.Iterate(); it.Next(&key, &conn); {
+		if idle(&conn, now) {
+			done = append(done, key)
+			e.ch <- *t.report(key, &conn)
+		}
+	}
+	for i := range done {
+		if err := conns.Delete(done[i]); err != nil {
+			klog.Errorf("delete map error: %v", err)
+		}
+	}
+}
+
+func (e *provider) Close() error {
+	return e.filter.Close()
+}
@@ -0,0 +1,21 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+)
+
+// TestExcludedPorts checks the QUIC port stays parsed whatever the global excluded ports, the filter
+// accepts nothing else.
+func TestExcludedPorts(t *testing.T) {
+	t.Setenv("L7_EXCLUDED_PORTS", "[443,8443]")
+	for _, port := range exclusion.ProtocolPorts(protocol) {
+		if port == QuicPort {
+			t.Errorf("the QUIC port %d is excluded", QuicPort)
+		}
+	}
+	if ports := exclusion.Ports(protocol); len(ports) != 2 {
+		t.Errorf("expected the global ports to be configured, got %v", ports)
+	}
+}
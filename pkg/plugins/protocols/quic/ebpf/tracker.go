@@ -0,0 +1,127 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// idleTimeout is the default max_idle_timeout of the common stacks, connections without packets
+	// for longer are reported as closed, the CONNECTION_CLOSE frames are encrypted.
+	idleTimeout = uint64(30e9)
+	// maxPending bounds the memory of the handshakes whose connections are not reported.
+	maxPending = 16384
+)
+
+type handshake struct {
+	version uint32
+	// initial is the first Initial packet of the client, last the last long header packet.
+	initial            uint64
+	last               uint64
+	retry              bool
+	versionNegotiation bool
+	zeroRTT            bool
+}
+
+// tracker records the long header packets of the handshakes until their connections go idle.
+// Connections are keyed in the client -> server direction.
+type tracker struct {
+	handshakes map[ConnKey]*handshake
+}
+
+func newTracker() *tracker {
+	return &tracker{handshakes: make(map[ConnKey]*handshake)}
+}
+
+// handle processes a long header packet.
+func (t *tracker) handle(key *EventKey, ev *QuicEvent) {
+	h, ok := parseLongHeader(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))])
+	if !ok {
+		return
+	}
+	connKey := key.Conn
+	if ev.Flag == 0 {
+		connKey = ConnKey{
+			SourceIP:   key.Conn.DestIP,
+			DestIP:     key.Conn.SourceIP,
+			SourcePort: key.Conn.DestPort,
+			DestPort:   key.Conn.SourcePort,
+		}
+	}
+	hs, ok := t.handshakes[connKey]
+	if !ok {
+		if len(t.handshakes) >= maxPending {
+			return
+		}
+		hs = &handshake{}
+		t.handshakes[connKey] = hs
+	}
+	hs.last = key.Timestamp
+	switch h.typ {
+	case packetVersionNegotiation:
+		hs.versionNegotiation = true
+		return
+	case packetRetry:
+		hs.retry = true
+	case packetZeroRTT:
+		hs.zeroRTT = true
+	case packetInitial:
+		if ev.Flag == 1 && hs.initial == 0 {
+			hs.initial = key.Timestamp
+		}
+	}
+	// the client starts again with the version chosen after a version negotiation.
+	hs.version = h.version
+}
+
+// report returns the metric of an idle connection.
+func (t *tracker) report(key ConnKey, conn *QuicConn) *Metric {
+	m := &Metric{
+		SourceIP:      net.IP(key.SourceIP[:]).String(),
+		SourcePort:    key.SourcePort,
+		DestIP:        net.IP(key.DestIP[:]).String(),
+		DestPort:      key.DestPort,
+		ServerIsPod:   conn.ServerIsPod == 1,
+		Handshake:     HandshakeUnobserved,
+		ClientPackets: conn.ClientPackets,
+		ServerPackets: conn.ServerPackets,
+		ClientBytes:   conn.ClientBytes,
+		ServerBytes:   conn.ServerBytes,
+		Captured:      conn.LastTimestamp,
+		SocketCookie:  conn.Cookie,
+	}
+	if conn.LastTimestamp > conn.FirstTimestamp {
+		m.Duration = conn.LastTimestamp - conn.FirstTimestamp
+	}
+	hs, ok := t.handshakes[key]
+	if !ok {
+		return m
+	}
+	delete(t.handshakes, key)
+	m.Version = VersionName(hs.version)
+	m.Retry = hs.retry
+	m.VersionNegotiation = hs.versionNegotiation
+	m.ZeroRTT = hs.zeroRTT
+	m.Handshake = HandshakeIncomplete
+	if conn.ClientShortTimestamp != 0 {
+		m.Handshake = HandshakeComplete
+		if hs.initial != 0 && conn.ClientShortTimestamp > hs.initial {
+			m.HandshakeDuration = conn.ClientShortTimestamp - hs.initial
+		}
+	}
+	return m
+}
+
+// idle reports whether a connection has no packets within idleTimeout at now (bpf_ktime_get_ns).
+func idle(conn *QuicConn, now uint64) bool {
+	return now > conn.LastTimestamp && now-conn.LastTimestamp > idleTimeout
+}
+
+// expire drops the handshakes whose connections were not reported at now (bpf_ktime_get_ns) although
+// they are idle for twice idleTimeout, e.g. the connection map was full.
+func (t *tracker) expire(now uint64) {
+	for key, hs := range t.handshakes {
+		if now-hs.last > 2*idleTimeout {
+			delete(t.handshakes, key)
+		}
+	}
+}
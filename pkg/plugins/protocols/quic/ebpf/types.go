@@ -0,0 +1,96 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	QuicPayloadSize = 64
+	// QuicPort is QUIC_PORT of the socket filter, the only port it accepts.
+	QuicPort = 443
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+// QuicEvent is a long header packet of a handshake, the Flag of its header is set for the packets of the
+// client.
+type QuicEvent struct {
+	socketfilter.Header
+	Payload [QuicPayloadSize]byte
+}
+
+// QuicConn are the counters of a connection kept by the kernel, see quic_conn_t.
+type QuicConn struct {
+	FirstTimestamp uint64
+	LastTimestamp  uint64
+	// ClientShortTimestamp and ServerShortTimestamp are the first short header (1-RTT) packets of both ends.
+	ClientShortTimestamp uint64
+	ServerShortTimestamp uint64
+	ClientPackets        uint64
+	ServerPackets        uint64
+	ClientBytes          uint64
+	ServerBytes          uint64
+	Cookie               uint64
+	ServerIsPod          uint8
+	_                    [7]uint8
+}
+
+// Handshake is the outcome of the handshake of a connection.
+type Handshake string
+
+const (
+	// HandshakeComplete connections sent 1-RTT packets from the client.
+	HandshakeComplete Handshake = "complete"
+	// HandshakeIncomplete connections went idle before the client sent 1-RTT packets.
+	HandshakeIncomplete Handshake = "incomplete"
+	// HandshakeUnobserved connections were established before the program was attached.
+	HandshakeUnobserved Handshake = "unobserved"
+)
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+	// ServerIsPod reports whether the server is the pod attached to the veth.
+	ServerIsPod bool
+
+	// Version is the name of the negotiated version, e.g. 1 or 2, empty if the handshake is unobserved.
+	Version   string
+	Handshake Handshake
+	// Retry reports whether the server validated the address of the client with a Retry packet,
+	// VersionNegotiation whether it rejected the first version of the client, ZeroRTT whether the
+	// client sent early data.
+	Retry              bool
+	VersionNegotiation bool
+	ZeroRTT            bool
+
+	// HandshakeDuration is the time from the first Initial packet to the first 1-RTT packet of the client.
+	HandshakeDuration uint64
+	// Duration is the time from the first to the last packet of the connection.
+	Duration      uint64
+	ClientPackets uint64
+	ServerPackets uint64
+	ClientBytes   uint64
+	ServerBytes   uint64
+
+	// Captured is the capture (bpf_ktime_get_ns) of the last packet of the connection.
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s quic [%s:%d] --> [%s:%d][%s %s] ====> %s",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Version, m.Handshake,
+		time.Duration(m.HandshakeDuration).String(),
+	)
+}
@@ -0,0 +1,124 @@
+// Package quic reports the QUIC connections (HTTP/3) of the pods to and from QUIC_PORT (443/udp), one
+// application_quic metric per connection once it goes idle.
+//
+// Only the long headers of the handshakes are in clear text, the frames are encrypted with keys that are
+// never on the wire: the streams of the connections, hence the HTTP/3 requests and their QPACK headers,
+// are not observable. The connections carry instead the version, the handshake latency (first Initial
+// packet to first 1-RTT packet of the client), whether the server sent a Retry or a Version Negotiation,
+// whether the client sent 0-RTT data, and the packets and bytes of both ends.
+package quic
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/quic/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_quic"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	// probes know the ips of the pods of the attached veths.
+	probes *attach.Attacher
+	clock  clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("quic", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "quic", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	// connections between two pods of the node are reported by the veth of the server
+	if !m.ServerIsPod && p.probes.Local(m.DestIP) {
+		return nil
+	}
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"quic_version":             m.Version,
+			"quic_handshake":           string(m.Handshake),
+			"quic_retry":               strconv.FormatBool(m.Retry),
+			"quic_version_negotiation": strconv.FormatBool(m.VersionNegotiation),
+			"quic_0rtt":                strconv.FormatBool(m.ZeroRTT),
+			"error":                    strconv.FormatBool(m.Handshake == ebpf.HandshakeIncomplete),
+		},
+		Fields: map[string]interface{}{
+			"connection_duration": m.Duration,
+			"client_packets":      m.ClientPackets,
+			"server_packets":      m.ServerPackets,
+			"client_bytes":        m.ClientBytes,
+			"server_bytes":        m.ServerBytes,
+		},
+	}
+	// the latency is the handshake, it is unknown for the connections established before the program was attached.
+	if m.Handshake == ebpf.HandshakeComplete && m.HandshakeDuration != 0 {
+		output.Fields["elapsed_count"] = 1
+		output.Fields["elapsed_sum"] = m.HandshakeDuration
+		output.Fields["elapsed_max"] = m.HandshakeDuration
+		output.Fields["elapsed_min"] = m.HandshakeDuration
+		output.Fields["elapsed_mean"] = m.HandshakeDuration
+	}
+	// the source is the client, the target the server, e.g. an ingress controller serving HTTP/3.
+	inCluster := p.enricher.Enrich(output, "QUIC", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("quic", &servicehub.Spec{
+		Services:             []string{"quic"},
+		Description:          "ebpf for quic connections",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
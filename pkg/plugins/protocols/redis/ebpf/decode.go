@@ -0,0 +1,119 @@
+package ebpf
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/dbstatement"
+)
+
+// keylessCommands have no key as first argument, their arguments are never reported
+// (AUTH and HELLO carry credentials).
+var keylessCommands = map[string]bool{
+	"AUTH":         true,
+	"HELLO":        true,
+	"PING":         true,
+	"ECHO":         true,
+	"SELECT":       true,
+	"QUIT":         true,
+	"INFO":         true,
+	"CLIENT":       true,
+	"CONFIG":       true,
+	"CLUSTER":      true,
+	"COMMAND":      true,
+	"DBSIZE":       true,
+	"FLUSHDB":      true,
+	"FLUSHALL":     true,
+	"MULTI":        true,
+	"EXEC":         true,
+	"DISCARD":      true,
+	"SCAN":         true,
+	"SCRIPT":       true,
+	"EVAL":         true,
+	"EVALSHA":      true,
+	"PUBLISH":      true,
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"TIME":         true,
+	"READONLY":     true,
+}
+
+// decodeMetric converts a completed command to a metric, it returns nil if the
+// request is not a RESP array of bulk strings.
+func decodeMetric(key *EventKey, data *RedisEvent) *Metric {
+	args, truncated := parseCommand(data.Request[:min(int(data.RequestLen), len(data.Request))], 2)
+	if len(args) == 0 {
+		return nil
+	}
+	m := &Metric{
+		SourceIP:     net.IP(key.Conn.SourceIP[:]).String(),
+		SourcePort:   key.Conn.SourcePort,
+		DestIP:       net.IP(key.Conn.DestIP[:]).String(),
+		DestPort:     key.Conn.DestPort,
+		Command:      strings.ToUpper(args[0]),
+		Duration:     data.Duration,
+		SocketCookie: data.Cookie,
+	}
+	if len(args) > 1 && !keylessCommands[m.Command] {
+		m.KeyPattern = dbstatement.KeyPattern(args[1])
+		if truncated {
+			m.KeyPattern = strings.TrimSuffix(m.KeyPattern, "*") + "*"
+		}
+	}
+
+	response := data.Response[:min(int(data.ResponseLen), len(data.Response))]
+	if len(response) > 0 && response[0] == '-' {
+		m.Error = true
+		message := response[1:]
+		if idx := bytes.Index(message, []byte("\r\n")); idx >= 0 {
+			message = message[:idx]
+		}
+		m.ErrorMessage = string(message)
+		m.ErrorType, _, _ = strings.Cut(m.ErrorMessage, " ")
+	}
+	return m
+}
+
+// parseCommand reads at most n bulk strings of the array in buf, truncated reports
+// that the last one was cut by the capture size.
+func parseCommand(buf []byte, n int) (args []string, truncated bool) {
+	line, buf, ok := readLine(buf)
+	if !ok || len(line) < 2 || line[0] != '*' {
+		return nil, false
+	}
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count <= 0 {
+		return nil, false
+	}
+	for i := 0; i < count && i < n; i++ {
+		line, buf, ok = readLine(buf)
+		if !ok || len(line) < 2 || line[0] != '$' {
+			return args, false
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 {
+			return args, false
+		}
+		if size > len(buf) {
+			return append(args, string(buf)), true
+		}
+		args = append(args, string(buf[:size]))
+		buf = buf[size:]
+		if len(buf) >= 2 {
+			buf = buf[2:]
+		}
+	}
+	return args, false
+}
+
+func readLine(buf []byte) (line, rest []byte, ok bool) {
+	idx := bytes.Index(buf, []byte("\r\n"))
+	if idx < 0 {
+		return nil, nil, false
+	}
+	return buf[:idx], buf[idx+2:], true
+}
@@ -0,0 +1,98 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeMetric(t *testing.T) {
+	var data RedisEvent
+	data.RequestLen = uint16(copy(data.Request[:], "*3\r\n$3\r\nset\r\n$11\r\nuser:1:name\r\n$3\r\nbob\r\n"))
+	data.ResponseLen = uint16(copy(data.Response[:], "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"))
+	m := decodeMetric(&EventKey{}, &data)
+	if m == nil {
+		t.Fatal("expected metric")
+	}
+	if m.Command != "SET" || m.KeyPattern != "user:*:name" {
+		t.Errorf("unexpected command: %s %s", m.Command, m.KeyPattern)
+	}
+	if !m.Error || m.ErrorType != "WRONGTYPE" {
+		t.Errorf("unexpected error: %v %s", m.Error, m.ErrorType)
+	}
+
+	data = RedisEvent{}
+	data.RequestLen = uint16(copy(data.Request[:], "*2\r\n$4\r\nAUTH\r\n$6\r\nsecret\r\n"))
+	data.ResponseLen = uint16(copy(data.Response[:], "+OK\r\n"))
+	m = decodeMetric(&EventKey{}, &data)
+	if m == nil || m.Command != "AUTH" || m.KeyPattern != "" || m.Error {
+		t.Errorf("unexpected metric: %+v", m)
+	}
+
+	data = RedisEvent{}
+	data.RequestLen = uint16(copy(data.Request[:], "PING\r\n"))
+	if m := decodeMetric(&EventKey{}, &data); m != nil {
+		t.Errorf("inline commands should be ignored, got %+v", m)
+	}
+}
+
+// capture returns the raw key and value of the metrics_map entry the kernel writes for the payloads of a
+// request packet and of its response packet.
+func capture(request, response string) (key, value []byte) {
+	key = make([]byte, 24)
+	copy(key, []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	binary.LittleEndian.PutUint16(key[8:], 41000)
+	binary.LittleEndian.PutUint16(key[10:], 6379)
+	binary.LittleEndian.PutUint64(key[16:], 1000)
+
+	value = make([]byte, 32+RedisRequestSize+RedisResponseSize)
+	binary.LittleEndian.PutUint64(value[0:], 1000)
+	binary.LittleEndian.PutUint64(value[8:], 250000)
+	binary.LittleEndian.PutUint64(value[16:], 7)
+	binary.LittleEndian.PutUint16(value[24:], uint16(copy(value[32:32+RedisRequestSize], request)))
+	binary.LittleEndian.PutUint16(value[26:], uint16(copy(value[32+RedisRequestSize:], response)))
+	return key, value
+}
+
+func decode(t *testing.T, request, response string) *Metric {
+	rawKey, rawValue := capture(request, response)
+	var (
+		key  EventKey
+		data RedisEvent
+	)
+	if binary.Size(key) != len(rawKey) || binary.Size(data) != len(rawValue) {
+		t.Fatalf("the key and the event do not match the layout of the C structs, %d and %d bytes", binary.Size(key), binary.Size(data))
+	}
+	if err := binary.Read(bytes.NewReader(rawKey), binary.LittleEndian, &key); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Read(bytes.NewReader(rawValue), binary.LittleEndian, &data); err != nil {
+		t.Fatal(err)
+	}
+	return decodeMetric(&key, &data)
+}
+
+func TestDecodeCapture(t *testing.T) {
+	for _, c := range []struct {
+		name, request, response string
+		command, key, errorType string
+	}{
+		{"get miss", "*2\r\n$3\r\nGET\r\n$13\r\nsession:90211\r\n", "$-1\r\n", "GET", "session:*", ""},
+		{"hset", "*4\r\n$4\r\nHSET\r\n$10\r\ncart:10042\r\n$4\r\nsku7\r\n$1\r\n2\r\n", ":1\r\n", "HSET", "cart:*", ""},
+		{"pipelined requests", "*2\r\n$4\r\nINCR\r\n$13\r\nhits:20240101\r\n*2\r\n$4\r\nINCR\r\n$5\r\ntotal\r\n", ":17\r\n:90\r\n", "INCR", "hits:*", ""},
+		{"cluster redirect", "*2\r\n$3\r\nGET\r\n$6\r\nuser:7\r\n", "-MOVED 3999 10.0.0.5:6379\r\n", "GET", "user:*", "MOVED"},
+		{"keyless", "*1\r\n$4\r\nPING\r\n", "+PONG\r\n", "PING", "", ""},
+	} {
+		m := decode(t, c.request, c.response)
+		if m == nil {
+			t.Errorf("%s: expected metric", c.name)
+			continue
+		}
+		if m.SourceIP != "10.0.0.1" || m.DestPort != 6379 || m.Duration != 250000 || m.SocketCookie != 7 {
+			t.Errorf("%s: unexpected connection %+v", c.name, m)
+		}
+		if m.Command != c.command || m.KeyPattern != c.key || m.ErrorType != c.errorType || m.Error != (c.errorType != "") {
+			t.Errorf("%s: unexpected metric %+v", c.name, m)
+		}
+	}
+}
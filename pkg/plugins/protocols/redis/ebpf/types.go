@@ -0,0 +1,65 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	RedisRequestSize  = 128
+	RedisResponseSize = 64
+)
+
+type ConnKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+type EventKey struct {
+	Conn ConnKey
+	// the C struct aligns request_ts to 8 bytes
+	_                uint32
+	RequestTimestamp uint64
+}
+
+type RedisEvent struct {
+	RequestTimestamp uint64
+	Duration         uint64
+	Cookie           uint64
+	RequestLen       uint16
+	ResponseLen      uint16
+	_                uint32
+	Request          [RedisRequestSize]byte
+	Response         [RedisResponseSize]byte
+}
+
+type Metric struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Command is the upper case command name, e.g. GET or HGETALL.
+	Command string
+	// KeyPattern is the key with the variable parts replaced by '*', e.g. user:*:profile.
+	KeyPattern string
+
+	Error bool
+	// ErrorType is the first word of the error reply, e.g. ERR, WRONGTYPE or MOVED.
+	ErrorType    string
+	ErrorMessage string
+
+	Duration uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s redis [%s:%d] --> [%s:%d][%s %s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Command, m.KeyPattern,
+		m.ErrorType, time.Duration(m.Duration).String(),
+	)
+}
@@ -0,0 +1,250 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+)
+
+// see org.apache.rocketmq.remoting.protocol.RemotingCommand
+const (
+	prefixSize         = 8
+	serializeJSON      = 0
+	serializeRocketMQ  = 1
+	binaryHeaderSize   = 13
+	flagResponse       = 1
+	flagOneway         = 2
+	responseSuccess    = 0
+	responseNotFound   = 19
+	responseRetryNow   = 20
+	responseOffsetMove = 21
+)
+
+type request struct {
+	name string
+	kind Kind
+	// topicField and groupField are the ext fields of the request header.
+	topicField, groupField string
+}
+
+// requests are the tracked request codes, see org.apache.rocketmq.remoting.protocol.RequestCode.
+var requests = map[int]request{
+	10:     {"SEND_MESSAGE", KindSend, "topic", "producerGroup"},
+	310:    {"SEND_MESSAGE_V2", KindSend, "b", "a"},
+	320:    {"SEND_BATCH_MESSAGE", KindSend, "b", "a"},
+	11:     {"PULL_MESSAGE", KindPull, "topic", "consumerGroup"},
+	361:    {"LITE_PULL_MESSAGE", KindPull, "topic", "consumerGroup"},
+	200050: {"POP_MESSAGE", KindPull, "topic", "consumerGroup"},
+}
+
+// responseNames are the names of the response codes, see org.apache.rocketmq.remoting.protocol.ResponseCode.
+var responseNames = map[int]string{
+	0:   "SUCCESS",
+	1:   "SYSTEM_ERROR",
+	2:   "SYSTEM_BUSY",
+	3:   "REQUEST_CODE_NOT_SUPPORTED",
+	4:   "TRANSACTION_FAILED",
+	10:  "FLUSH_DISK_TIMEOUT",
+	11:  "SLAVE_NOT_AVAILABLE",
+	12:  "FLUSH_SLAVE_TIMEOUT",
+	13:  "MESSAGE_ILLEGAL",
+	14:  "SERVICE_NOT_AVAILABLE",
+	15:  "VERSION_NOT_SUPPORTED",
+	16:  "NO_PERMISSION",
+	17:  "TOPIC_NOT_EXIST",
+	18:  "TOPIC_EXIST_ALREADY",
+	19:  "PULL_NOT_FOUND",
+	20:  "PULL_RETRY_IMMEDIATELY",
+	21:  "PULL_OFFSET_MOVED",
+	22:  "QUERY_NOT_FOUND",
+	23:  "SUBSCRIPTION_PARSE_FAILED",
+	24:  "SUBSCRIPTION_NOT_EXIST",
+	25:  "SUBSCRIPTION_NOT_LATEST",
+	26:  "SUBSCRIPTION_GROUP_NOT_EXIST",
+	209: "POLLING_FULL",
+	210: "POLLING_TIMEOUT",
+}
+
+// isError reports whether the response code is a failure of the request. Sends stored without
+// the configured replication are successful for the client, pulls without new messages as well.
+func isError(kind Kind, code int) bool {
+	switch code {
+	case responseSuccess:
+		return false
+	case 10, 11, 12:
+		return kind != KindSend
+	case responseNotFound, responseRetryNow, responseOffsetMove, 209, 210:
+		return kind != KindPull
+	default:
+		return true
+	}
+}
+
+type command struct {
+	code   int
+	flag   int
+	opaque int32
+	remark string
+	ext    map[string]string
+}
+
+func (c *command) isResponse() bool {
+	return c.flag&flagResponse != 0
+}
+
+func (c *command) isOneway() bool {
+	return c.flag&flagOneway != 0
+}
+
+// parseCommands parses the commands of a packet, the header of the last one may be truncated.
+// Commands without the code or the opaque can not be paired and are left out.
+func parseCommands(buf []byte) []command {
+	var commands []command
+	for len(buf) >= prefixSize {
+		total := int(binary.BigEndian.Uint32(buf[0:4]))
+		serialize := buf[4]
+		headerLen := int(binary.BigEndian.Uint32(buf[4:8]) & 0xffffff)
+		if headerLen+4 > total {
+			break
+		}
+		header := buf[prefixSize:min(prefixSize+headerLen, len(buf))]
+		var (
+			c  command
+			ok bool
+		)
+		switch serialize {
+		case serializeJSON:
+			c, ok = parseJSONHeader(header)
+		case serializeRocketMQ:
+			c, ok = parseBinaryHeader(header)
+		}
+		if ok {
+			commands = append(commands, c)
+		}
+		if 4+total > len(buf) {
+			break
+		}
+		buf = buf[4+total:]
+	}
+	return commands
+}
+
+// parseJSONHeader scans the keys of the json header instead of unmarshalling it, so that the fields
+// before the truncation are still read. The ext fields are strings, the other fields are top level keys.
+func parseJSONHeader(header []byte) (command, bool) {
+	c := command{ext: make(map[string]string)}
+	code, ok1 := jsonNumber(header, "code")
+	opaque, ok2 := jsonNumber(header, "opaque")
+	flag, ok3 := jsonNumber(header, "flag")
+	if !ok1 || !ok2 || !ok3 {
+		return c, false
+	}
+	c.code, c.opaque, c.flag = int(code), int32(opaque), int(flag)
+	c.remark, _ = jsonString(header, "remark")
+	if r, ok := requests[c.code]; ok && !c.isResponse() {
+		for _, field := range []string{r.topicField, r.groupField} {
+			if v, ok := jsonString(header, field); ok {
+				c.ext[field] = v
+			}
+		}
+	}
+	return c, true
+}
+
+// jsonValues returns the bytes following each occurrence of key, ext fields may share the names
+// of top level keys (e.g. flag of the send headers), the callers pick the occurrence of the right type.
+func jsonValues(header []byte, key string) [][]byte {
+	var ans [][]byte
+	pattern := []byte(`"` + key + `":`)
+	for {
+		i := bytes.Index(header, pattern)
+		if i < 0 {
+			return ans
+		}
+		header = header[i+len(pattern):]
+		ans = append(ans, bytes.TrimLeft(header, " "))
+	}
+}
+
+func jsonNumber(header []byte, key string) (int64, bool) {
+	for _, v := range jsonValues(header, key) {
+		end := 0
+		for end < len(v) && (v[end] == '-' || (v[end] >= '0' && v[end] <= '9')) {
+			end++
+		}
+		// the number may continue after the truncation
+		if end == 0 || end == len(v) {
+			continue
+		}
+		if n, err := strconv.ParseInt(string(v[:end]), 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func jsonString(header []byte, key string) (string, bool) {
+	for _, v := range jsonValues(header, key) {
+		if len(v) == 0 || v[0] != '"' {
+			continue
+		}
+		v = v[1:]
+		for i := 0; i < len(v); i++ {
+			if v[i] == '\\' {
+				i++
+				continue
+			}
+			if v[i] == '"' {
+				s, err := strconv.Unquote(`"` + string(v[:i]) + `"`)
+				return s, err == nil
+			}
+		}
+	}
+	return "", false
+}
+
+// parseBinaryHeader parses the header of the ROCKETMQ serialize type: code, language, version,
+// opaque, flag, remark and the ext fields.
+func parseBinaryHeader(header []byte) (command, bool) {
+	c := command{ext: make(map[string]string)}
+	if len(header) < binaryHeaderSize {
+		return c, false
+	}
+	c.code = int(int16(binary.BigEndian.Uint16(header[0:2])))
+	c.opaque = int32(binary.BigEndian.Uint32(header[5:9]))
+	c.flag = int(binary.BigEndian.Uint32(header[9:13]))
+	rest := header[binaryHeaderSize:]
+	next := func(n int) []byte {
+		if n < 0 || len(rest) < n {
+			rest = nil
+			return nil
+		}
+		b := rest[:n]
+		rest = rest[n:]
+		return b
+	}
+	u32 := func() int {
+		if b := next(4); b != nil {
+			return int(binary.BigEndian.Uint32(b))
+		}
+		return -1
+	}
+	c.remark = string(next(u32()))
+	extLen := u32()
+	ext := next(min(max(extLen, 0), len(rest)))
+	for len(ext) >= 2 {
+		keyLen := int(binary.BigEndian.Uint16(ext[0:2]))
+		if len(ext) < 2+keyLen+4 {
+			break
+		}
+		key := string(ext[2 : 2+keyLen])
+		ext = ext[2+keyLen:]
+		valueLen := int(binary.BigEndian.Uint32(ext[0:4]))
+		if len(ext) < 4+valueLen {
+			break
+		}
+		c.ext[key] = string(ext[4 : 4+valueLen])
+		ext = ext[4+valueLen:]
+	}
+	return c, true
+}
@@ -0,0 +1,106 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func jsonCommand(header, body string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(4+len(header)+len(body)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(header)))
+	b = append(b, header...)
+	return append(b, body...)
+}
+
+func binaryCommand(code int16, opaque int32, flag int32, remark string, ext map[string]string) []byte {
+	h := binary.BigEndian.AppendUint16(nil, uint16(code))
+	h = append(h, 0)
+	h = binary.BigEndian.AppendUint16(h, 399)
+	h = binary.BigEndian.AppendUint32(h, uint32(opaque))
+	h = binary.BigEndian.AppendUint32(h, uint32(flag))
+	h = binary.BigEndian.AppendUint32(h, uint32(len(remark)))
+	h = append(h, remark...)
+	var e []byte
+	for k, v := range ext {
+		e = binary.BigEndian.AppendUint16(e, uint16(len(k)))
+		e = append(e, k...)
+		e = binary.BigEndian.AppendUint32(e, uint32(len(v)))
+		e = append(e, v...)
+	}
+	h = binary.BigEndian.AppendUint32(h, uint32(len(e)))
+	h = append(h, e...)
+	b := binary.BigEndian.AppendUint32(nil, uint32(4+len(h)))
+	b = binary.BigEndian.AppendUint32(b, serializeRocketMQ<<24|uint32(len(h)))
+	return append(b, h...)
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 10911}
+	broker = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, commands ...[]byte) []*Metric {
+	key := EventKey{Conn: broker, Timestamp: ts}
+	ev := RocketmqEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	var buf []byte
+	for _, c := range commands {
+		buf = append(buf, c...)
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	return t.handle(&key, &ev)
+}
+
+func TestSendAndPull(t *testing.T) {
+	tr := newTracker()
+	send := `{"code":310,"extFields":{"a":"order-producer","b":"orders","flag":"0"},"flag":0,"language":"JAVA","opaque":7,"serializeTypeCurrentRPC":"JSON","version":399}`
+	pull := `{"code":11,"extFields":{"consumerGroup":"order-consumer","topic":"orders"},"flag":0,"language":"JAVA","opaque":8,"version":399}`
+	// both requests in one packet, the responses in reverse order
+	packet(tr, 10, true, jsonCommand(send, "body"), jsonCommand(pull, ""))
+	done := packet(tr, 20, false, jsonCommand(`{"code":19,"flag":1,"language":"JAVA","opaque":8,"serializeTypeCurrentRPC":"JSON","version":399}`, ""))
+	if len(done) != 1 {
+		t.Fatalf("expected the pull, got %d", len(done))
+	}
+	m := done[0]
+	if m.Kind != KindPull || m.Topic != "orders" || m.Group != "order-consumer" || m.Response != "PULL_NOT_FOUND" || m.Error || m.Duration != 10 {
+		t.Errorf("unexpected pull %+v", m)
+	}
+
+	done = packet(tr, 30, false, jsonCommand(`{"code":17,"flag":1,"opaque":7,"remark":"topic[orders] not exist"}`, ""))
+	if len(done) != 1 {
+		t.Fatalf("expected the send, got %d", len(done))
+	}
+	m = done[0]
+	if m.Kind != KindSend || m.Request != "SEND_MESSAGE_V2" || m.Topic != "orders" || m.Group != "order-producer" ||
+		!m.Error || m.Remark != "topic[orders] not exist" || m.Duration != 20 || m.SourcePort != 40000 {
+		t.Errorf("unexpected send %+v", m)
+	}
+}
+
+func TestBinaryHeader(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, binaryCommand(10, 3, 0, "", map[string]string{"topic": "logs", "producerGroup": "p"}))
+	// heartbeats are not tracked, oneway requests have no response
+	packet(tr, 1, true, binaryCommand(34, 4, 0, "", nil), binaryCommand(10, 5, flagOneway, "", nil))
+	done := packet(tr, 5, false, binaryCommand(0, 3, flagResponse, "", nil), binaryCommand(0, 4, flagResponse, "", nil))
+	if len(done) != 1 || done[0].Topic != "logs" || done[0].Group != "p" || done[0].Error || done[0].Duration != 4 {
+		t.Fatalf("unexpected metrics %+v", done)
+	}
+	tr.expire(requestTimeout + 2)
+	if len(tr.conns) != 0 {
+		t.Errorf("requests without response should be dropped")
+	}
+}
+
+func TestTruncatedHeader(t *testing.T) {
+	header := `{"code":310,"extFields":{"a":"p","b":"orders"},"flag":0,"opaque":12`
+	if commands := parseCommands(jsonCommand(header, "")[:8+len(header)-2]); len(commands) != 0 {
+		t.Errorf("commands without the complete opaque should be left out, got %+v", commands)
+	}
+	if commands := parseCommands(jsonCommand(header+`,"version":1}`, "")); len(commands) != 1 || commands[0].opaque != 12 {
+		t.Errorf("unexpected commands %+v", commands)
+	}
+}
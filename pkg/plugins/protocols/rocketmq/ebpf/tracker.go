@@ -0,0 +1,112 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// requestTimeout drops requests without response, it is above the long polling suspension of pulls.
+	requestTimeout = uint64(60e9)
+	// maxPendingPerConn bounds the memory of connections whose responses are not captured.
+	maxPendingPerConn = 4096
+)
+
+type pending struct {
+	metric *Metric
+	ts     uint64
+}
+
+// tracker pairs the requests with their responses by the opaque of the connection. Connections are
+// keyed in the client -> broker direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]map[int32]*pending
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]map[int32]*pending)}
+}
+
+// handle processes the commands of a packet, it returns the requests completed by it.
+func (t *tracker) handle(key *EventKey, ev *RocketmqEvent) []*Metric {
+	fromPod := ev.FromPod == 1
+	connKey := key.Conn
+	if !fromPod {
+		connKey = ConnKey{
+			SourceIP:   key.Conn.DestIP,
+			DestIP:     key.Conn.SourceIP,
+			SourcePort: key.Conn.DestPort,
+			DestPort:   key.Conn.SourcePort,
+		}
+	}
+	var done []*Metric
+	for _, c := range parseCommands(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]) {
+		// requests of the pod and responses to it, connections of broker pods are ignored that way.
+		if c.isResponse() == fromPod {
+			continue
+		}
+		if !c.isResponse() {
+			r, ok := requests[c.code]
+			// oneway requests have no outcome to report
+			if !ok || c.isOneway() {
+				continue
+			}
+			inflight := t.conns[connKey]
+			if inflight == nil {
+				inflight = make(map[int32]*pending)
+				t.conns[connKey] = inflight
+			}
+			if len(inflight) >= maxPendingPerConn {
+				continue
+			}
+			inflight[c.opaque] = &pending{
+				metric: &Metric{
+					SourceIP:    net.IP(connKey.SourceIP[:]).String(),
+					SourcePort:  connKey.SourcePort,
+					DestIP:      net.IP(connKey.DestIP[:]).String(),
+					DestPort:    connKey.DestPort,
+					Kind:        r.kind,
+					RequestCode: c.code,
+					Request:     r.name,
+					Topic:       c.ext[r.topicField],
+					Group:       c.ext[r.groupField],
+
+					SocketCookie: ev.Cookie,
+				},
+				ts: key.Timestamp,
+			}
+			continue
+		}
+		p, ok := t.conns[connKey][c.opaque]
+		if !ok {
+			continue
+		}
+		delete(t.conns[connKey], c.opaque)
+		m := p.metric
+		m.ResponseCode = c.code
+		m.Response = responseNames[c.code]
+		m.Error = isError(m.Kind, c.code)
+		if m.Error {
+			m.Remark = c.remark
+		}
+		m.Captured = key.Timestamp
+		if key.Timestamp > p.ts {
+			m.Duration = key.Timestamp - p.ts
+		}
+		done = append(done, m)
+	}
+	return done
+}
+
+// expire drops the requests without response within requestTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, inflight := range t.conns {
+		for opaque, p := range inflight {
+			if now-p.ts > requestTimeout {
+				delete(inflight, opaque)
+			}
+		}
+		if len(inflight) == 0 {
+			delete(t.conns, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	RocketmqPayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type RocketmqEvent struct {
+	socketfilter.Header
+	Payload [RocketmqPayloadSize]byte
+}
+
+type Kind int
+
+const (
+	KindSend Kind = iota
+	KindPull
+)
+
+func (k Kind) String() string {
+	if k == KindSend {
+		return "send"
+	}
+	return "pull"
+}
+
+type Metric struct {
+	// Source is the client, Dest the broker.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Kind Kind
+	// RequestCode is the code of the request, e.g. 310 for SEND_MESSAGE_V2, Request its name.
+	RequestCode int
+	Request     string
+	Topic       string
+	// Group is the producer group of sends and the consumer group of pulls.
+	Group string
+
+	ResponseCode int
+	Response     string
+	// Remark is the error message of failed requests.
+	Remark string
+	Error  bool
+
+	// Duration of pulls includes the time the broker holds long polling requests without new messages.
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s rocketmq [%s:%d] --> [%s:%d][%s %s %s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Request, m.Topic, m.Group,
+		m.Response, time.Duration(m.Duration).String(),
+	)
+}
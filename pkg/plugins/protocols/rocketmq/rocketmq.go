@@ -0,0 +1,126 @@
+package rocketmq
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/rocketmq/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_mq"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("rocketmq", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "rocketmq", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"message_bus_destination": m.Topic,
+			"rocketmq_topic":          m.Topic,
+			"rocketmq_group":          m.Group,
+			"rocketmq_request_code":   strconv.Itoa(m.RequestCode),
+			"rocketmq_request":        m.Request,
+			"rocketmq_response_code":  strconv.Itoa(m.ResponseCode),
+			"rocketmq_response":       m.Response,
+			"error":                   strconv.FormatBool(m.Error),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	if m.Error {
+		output.Tags["rocketmq_error"] = m.Remark
+		p.errorCodes.Tag(output.Tags, errorcodes.RocketMQ, strconv.Itoa(m.ResponseCode))
+	}
+
+	// the source is the client pod, the target the broker.
+	inCluster := p.enricher.Enrich(output, "ROCKETMQ", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	switch m.Kind {
+	case ebpf.KindSend:
+		output.Tags["span_kind"] = "producer"
+		output.Tags["message_bus_status"] = "PUBLISH_SUCCESS"
+		if m.Error {
+			output.Tags["message_bus_status"] = "PUBLISH_FAILED"
+		}
+	case ebpf.KindPull:
+		output.Tags["span_kind"] = "consumer"
+		output.Tags["message_bus_status"] = "CONSUME_SUCCESS"
+		if m.Error {
+			output.Tags["message_bus_status"] = "CONSUME_FAILED"
+		}
+	}
+	// brokers outside the cluster (e.g. cloud rocketmq) are still reported, they are not part of the topology.
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("rocketmq", &servicehub.Spec{
+		Services:             []string{"rocketmq"},
+		Description:          "ebpf for rocketmq remoting protocol",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
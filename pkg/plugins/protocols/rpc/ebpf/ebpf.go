@@ -12,6 +12,10 @@ import (
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/utils"
 )
 
 const (
@@ -26,6 +30,8 @@ type Ebpf struct {
 	IfIndex   int
 	IPaddress string
 	NodeName  string
+	// NetNS is the path of the network namespace of the interface, empty for the namespace of the node.
+	NetNS string
 	//hostnetwork类型的pod,使用pod来区分k8s的元数据
 	PortMap map[int32]K8SMeta
 	Ch      chan Metric
@@ -45,6 +51,8 @@ type Ebpf struct {
 	kprobeTcpRecvMsgKP    link.Link
 	kretprobeTcpRecvMsgKP link.Link
 	kprobeTcpCloseKP      link.Link
+
+	clock clock.Clock
 }
 
 type K8SMeta struct {
@@ -64,14 +72,13 @@ func NewEbpf(ifindex int, ip string, ch chan Metric) *Ebpf {
 		IfIndex:   ifindex,
 		Ch:        ch,
 		IPaddress: ip,
+		clock:     clock.Real,
 	}
 }
 
 func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 	klog.Infof("ip: %s, index: %d start rpc", e.IPaddress, e.IfIndex)
-	var err error
-	e.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
-	if err != nil {
+	if err := e.loadCollection(spec); err != nil {
 		return err
 	}
 	prog := e.collection.DetachProgram("rpc__filter_package")
@@ -86,6 +93,48 @@ func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 		return errors.New(msg)
 	}
 
+	var (
+		sock int
+		err  error
+	)
+	if e.NetNS != "" {
+		sock, err = utils.OpenRawSockAt(e.NetNS, e.IfIndex)
+	} else {
+		sock, err = OpenRawSock(e.IfIndex)
+	}
+	if err != nil {
+		return err
+	}
+
+	// register tail call
+	tailCallMap := e.collection.DetachMap("tail_jmp_map")
+	if err := tailCallMap.Update(uint32(1), uint32(amqpFilterProg.FD()), ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update tail call map: %v", err)
+	}
+
+	if err := syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, prog.FD()); err != nil {
+		return err
+	}
+	const keyIPAddr uint32 = 1
+	// inject target ip address, if request srcip no equal target ip, will drop
+	if err := e.collection.DetachMap("filter_map").Put(keyIPAddr, uint64(Htonl(IP4toDec(e.IPaddress)))); err != nil {
+		return err
+	}
+	e.watch()
+	return nil
+}
+
+// loadCollection loads the collection and attaches the tcp kprobes, shared by the socket and the tc modes.
+func (e *Ebpf) loadCollection(spec *ebpf.CollectionSpec) error {
+	var err error
+	e.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	if err := exclusion.Apply(e.collection, "rpc"); err != nil {
+		return err
+	}
+
 	e.tcpSendMsgProg = e.collection.DetachProgram("kprobe_tcp_sendmsg")
 	if e.tcpSendMsgProg == nil {
 		msg := fmt.Sprintf("Error: no program named %s found !", "kprobe_tcp_sendmsg")
@@ -126,29 +175,11 @@ func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 	}
 
 	e.kprobeTcpCloseKP, err = link.Kprobe(tcpCloseFN, e.kprobeTcpCloseProg, nil)
-	if err != nil {
-		return err
-	}
-
-	sock, err := OpenRawSock(e.IfIndex)
-	if err != nil {
-		return err
-	}
-
-	// register tail call
-	tailCallMap := e.collection.DetachMap("tail_jmp_map")
-	if err := tailCallMap.Update(uint32(1), uint32(amqpFilterProg.FD()), ebpf.UpdateAny); err != nil {
-		return fmt.Errorf("failed to update tail call map: %v", err)
-	}
+	return err
+}
 
-	if err := syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, prog.FD()); err != nil {
-		return err
-	}
-	const keyIPAddr uint32 = 1
-	// inject target ip address, if request srcip no equal target ip, will drop
-	if err := e.collection.DetachMap("filter_map").Put(keyIPAddr, uint64(Htonl(IP4toDec(e.IPaddress)))); err != nil {
-		return err
-	}
+// watch sends the calls of the trace maps to the channel.
+func (e *Ebpf) watch() {
 	go func() {
 		e.Lock()
 		m := e.collection.DetachMap("grpc_trace_map")
@@ -169,7 +200,7 @@ func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 				//	klog.Infof("metric: %v", metric.CovertMetric())
 				//}
 			}
-			time.Sleep(1 * time.Second)
+			e.clock.Sleep(1 * time.Second)
 		}
 	}()
 	go func() {
@@ -188,13 +219,12 @@ func (e *Ebpf) Load(spec *ebpf.CollectionSpec) error {
 				ev := DecodeAMQPMapItem(val)
 				klog.Infof("length: %d, amqp: %v", len(val), ev)
 			}
-			time.Sleep(1 * time.Second)
+			e.clock.Sleep(1 * time.Second)
 		}
 	}()
-	return nil
 }
 
-func (e *Ebpf) Close() {
+func (e *Ebpf) Close() error {
 	e.tcpSendMsgKP.Close()
 	e.kprobeTcpRecvMsgKP.Close()
 	e.kretprobeTcpRecvMsgKP.Close()
@@ -207,11 +237,14 @@ func (e *Ebpf) Close() {
 	e.tcpSendMsgProg.Close()
 
 	e.collection.Close()
+	return nil
 }
 
 func (e *Ebpf) Converet(p *MapPackage) *Metric {
 	m := new(Metric)
-	if p.RpcType == 1 {
+	if p.RpcType == 1 && p.Triple {
+		m.RpcType = RPC_TYPE_TRIPLE
+	} else if p.RpcType == 1 {
 		m.RpcType = RPC_TYPE_GRPC
 	} else if p.RpcType == 3 {
 		m.RpcType = RPC_TYPE_DUBBO
@@ -234,6 +267,8 @@ func (e *Ebpf) Converet(p *MapPackage) *Metric {
 	m.PathLen = p.PathLen
 	m.Status = p.Status
 	m.MysqlErr = p.MysqlErr
+	m.GrpcStatus = p.GrpcStatus
+	m.SocketCookie = p.Cookie
 	return m
 }
 
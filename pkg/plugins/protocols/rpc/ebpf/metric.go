@@ -28,6 +28,8 @@ const (
 	RPC_TYPE_DUBBO RpcType = "DUBBO"
 	// RPC_TYPE_GRPC grpc
 	RPC_TYPE_GRPC RpcType = "GRPC"
+	// RPC_TYPE_TRIPLE dubbo 3 triple, the gRPC compatible protocol of dubbo
+	RPC_TYPE_TRIPLE RpcType = "TRIPLE"
 	// RPC_TYPE_MYSQL mysql
 	RPC_TYPE_MYSQL RpcType = "MYSQL"
 	// RPC_TYPE_REDIS redis
@@ -57,6 +59,12 @@ type MapPackage struct {
 	Path         string
 	Status       string
 	MysqlErr     string
+	// Triple is set for grpc requests carrying dubbo 3 tri- headers.
+	Triple bool
+	// GrpcStatus is the grpc-status of trailers-only responses, -1 if not observed.
+	GrpcStatus int
+	// Cookie is the socket cookie of the client sending the request, 0 if it is not captured.
+	Cookie uint64
 }
 
 type AMQPMapPackage struct {
@@ -90,6 +98,10 @@ type Metric struct {
 	Path         string
 	Status       string
 	MysqlErr     string
+	// GrpcStatus is the grpc-status of trailers-only responses, -1 if not observed.
+	GrpcStatus int
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
 }
 
 func (m *Metric) CovertMetric() metric.Metric {
@@ -129,6 +141,10 @@ func DecodeMapItem(e []byte) *MapPackage {
 	m.Duration = binary.LittleEndian.Uint32(e[32:36])
 	m.Pid = binary.LittleEndian.Uint32(e[36:40])
 	m.PathLen = int(e[40])
+	m.GrpcStatus = -1
+	if len(e) >= 168 {
+		m.Cookie = binary.LittleEndian.Uint64(e[160:168])
+	}
 	var err error
 	if m.RpcType == 1 && m.PathLen > 0 && m.PathLen < 100 && m.PathLen+41 < len(e) {
 		m.Path, err = encodeHeader(e[41 : m.PathLen+41+1])
@@ -170,6 +186,10 @@ func DecodeMapItem(e []byte) *MapPackage {
 		if err != nil {
 			klog.Errorf("encode status header error: %v", err)
 		}
+		if len(e) >= 160 {
+			m.Triple = e[156] == 1
+			m.GrpcStatus = decodeGrpcStatus(e[157:160])
+		}
 	}
 	if m.RpcType == 5 {
 		if e[141] == 'O' {
@@ -189,11 +209,32 @@ func DecodeMapItem(e []byte) *MapPackage {
 		} else {
 			m.Status = strconv.FormatUint(uint64(binary.BigEndian.Uint16(e[144:146])), 10)
 		}
-		m.MysqlErr = string(e[146:])
+		m.MysqlErr = string(e[146:156])
 	}
 	return m
 }
 
+// decodeGrpcStatus decodes the raw grpc-status string literal, -1 if it is empty or invalid.
+func decodeGrpcStatus(literal []byte) int {
+	length := int(literal[0] & 0x7f)
+	if length == 0 || length >= len(literal) {
+		return -1
+	}
+	value := literal[1 : 1+length]
+	if literal[0]&0x80 != 0 {
+		decoded, err := hpack.HuffmanDecode(value)
+		if err != nil {
+			return -1
+		}
+		value = decoded
+	}
+	status, err := strconv.Atoi(string(value))
+	if err != nil || status < 0 {
+		return -1
+	}
+	return status
+}
+
 func DecodeAMQPMapItem(e []byte) *AMQPMapPackage {
 	m := new(AMQPMapPackage)
 	m.DstIP = net.IP(e[0:4]).String()
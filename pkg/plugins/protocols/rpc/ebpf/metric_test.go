@@ -0,0 +1,56 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/pkg/hpack"
+)
+
+func TestDecodeGrpcStatus(t *testing.T) {
+	huffman := hpack.HuffmanEncode([]byte("13"))
+	cases := []struct {
+		literal []byte
+		want    int
+	}{
+		{[]byte{0x01, '0', 0}, 0},
+		{[]byte{0x02, '1', '4'}, 14},
+		{append([]byte{0x80 | byte(len(huffman))}, huffman...), 13},
+		{[]byte{0, 0, 0}, -1},
+		{[]byte{0x01, 'x', 0}, -1},
+		{[]byte{0x05, '1', '2'}, -1},
+	}
+	for _, c := range cases {
+		if got := decodeGrpcStatus(c.literal); got != c.want {
+			t.Errorf("%x: got %d, want %d", c.literal, got, c.want)
+		}
+	}
+}
+
+func TestDecodeMapItemTriple(t *testing.T) {
+	e := make([]byte, 160)
+	e[0] = 1
+	e[4] = 2
+	e[156] = 1
+	copy(e[157:], []byte{0x01, '2'})
+	m := DecodeMapItem(e)
+	if !m.Triple || m.GrpcStatus != 2 {
+		t.Errorf("got triple %v, grpc status %d", m.Triple, m.GrpcStatus)
+	}
+	if c := (&Ebpf{}).Converet(m); c.RpcType != RPC_TYPE_TRIPLE || c.GrpcStatus != 2 {
+		t.Errorf("got %s, grpc status %d", c.RpcType, c.GrpcStatus)
+	}
+}
+
+func TestDecodeMapItemCookie(t *testing.T) {
+	e := make([]byte, 168)
+	e[0] = 1
+	binary.LittleEndian.PutUint64(e[160:], 0x1234)
+	if m := DecodeMapItem(e); m.Cookie != 0x1234 {
+		t.Errorf("got cookie %#x, want 0x1234", m.Cookie)
+	}
+	// packages of kernels built before the cookie have none.
+	if m := DecodeMapItem(make([]byte, 160)); m.Cookie != 0 {
+		t.Errorf("got cookie %#x, want 0", m.Cookie)
+	}
+}
@@ -0,0 +1,209 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+)
+
+const (
+	tcProgram     = "tc__filter_package"
+	tcAmqpProgram = "tc__amqp_filter"
+	tcFilterName  = "rpc"
+
+	// the slot of the filters on the clsact qdisc: a priority after the ones of the CNIs (e.g. Cilium takes
+	// 1) and a handle of their own. The program passes the packets on (TC_ACT_UNSPEC), the filters of the
+	// CNI still run; the packets a filter before it redirects are not traced.
+	tcFilterPriority = 0xc000
+	tcFilterHandle   = 0xe7da
+)
+
+// Classifier loads a single collection for all the veths: its program is attached to the ingress and the
+// egress of the clsact qdisc of every veth and finds the ip of the pod by the index of the veth, instead
+// of a collection and a raw socket per veth.
+type Classifier struct {
+	Ebpf
+
+	mu      sync.Mutex
+	prog    *ebpf.Program
+	ips     *ebpf.Map
+	filters map[int][]*netlink.BpfFilter
+}
+
+func NewClassifier(ch chan Metric) *Classifier {
+	return &Classifier{
+		Ebpf:    Ebpf{Ch: ch, clock: clock.Real},
+		filters: make(map[int][]*netlink.BpfFilter),
+	}
+}
+
+func (c *Classifier) Load(spec *ebpf.CollectionSpec) error {
+	klog.Infof("start rpc in tc mode")
+	if err := c.loadCollection(spec); err != nil {
+		return err
+	}
+	c.prog = c.collection.DetachProgram(tcProgram)
+	if c.prog == nil {
+		return fmt.Errorf("Error: no program named %s found !", tcProgram)
+	}
+	amqpFilterProg := c.collection.DetachProgram(tcAmqpProgram)
+	if amqpFilterProg == nil {
+		return fmt.Errorf("Error: no program named %s found !", tcAmqpProgram)
+	}
+	if err := c.collection.DetachMap("tc_tail_jmp_map").Update(uint32(1), uint32(amqpFilterProg.FD()), ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update tail call map: %v", err)
+	}
+	c.ips = c.collection.DetachMap("tc_filter_map")
+	c.watch()
+	return nil
+}
+
+// Attach attaches the program to the veth of index, ip is the ip of its pod.
+func (c *Classifier) Attach(index int, ip string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.ips.Put(uint32(index), uint64(Htonl(IP4toDec(ip)))); err != nil {
+		return err
+	}
+	if _, ok := c.filters[index]; ok {
+		return nil
+	}
+	// the clsact qdisc of the CNI is kept, with its filters.
+	if err := netlink.QdiscAdd(clsact(index)); err != nil && !errors.Is(err, unix.EEXIST) {
+		c.ips.Delete(uint32(index))
+		return fmt.Errorf("failed to add clsact qdisc: %v", err)
+	}
+	filters := classifierFilters(index, c.prog.FD())
+	for i, f := range filters {
+		if err := addFilter(f); err != nil {
+			for _, added := range filters[:i] {
+				delFilter(added)
+			}
+			c.ips.Delete(uint32(index))
+			return fmt.Errorf("failed to add tc filter: %v", err)
+		}
+	}
+	c.filters[index] = filters
+	return nil
+}
+
+// addFilter adds f, it fails rather than replacing a filter of its slot.
+func addFilter(f *netlink.BpfFilter) error {
+	existing, err := listFilters(f)
+	if err != nil {
+		return err
+	}
+	if other := taken(existing, f); other != nil {
+		return fmt.Errorf("the priority %d of the tc filters of the link %d is taken by a %s filter",
+			f.Priority, f.LinkIndex, other.Type())
+	}
+	return netlink.FilterAdd(f)
+}
+
+// delFilter deletes f if the filter of its slot is still the one of the plugin.
+func delFilter(f *netlink.BpfFilter) {
+	existing, err := listFilters(f)
+	if err != nil {
+		// the veth is gone with its filters
+		return
+	}
+	if owned(existing, f) {
+		if err := netlink.FilterDel(f); err != nil {
+			klog.Warningf("failed to delete tc filter of the link %d: %v", f.LinkIndex, err)
+		}
+	}
+}
+
+func listFilters(f *netlink.BpfFilter) ([]netlink.Filter, error) {
+	link, err := netlink.LinkByIndex(f.LinkIndex)
+	if err != nil {
+		return nil, err
+	}
+	return netlink.FilterList(link, f.Parent)
+}
+
+// taken returns the filter of existing holding the priority of f, nil if it is free.
+func taken(existing []netlink.Filter, f *netlink.BpfFilter) netlink.Filter {
+	for _, e := range existing {
+		if e.Attrs().Priority == f.Priority {
+			return e
+		}
+	}
+	return nil
+}
+
+// owned reports whether the filter of existing in the slot of f is a filter of the plugin.
+func owned(existing []netlink.Filter, f *netlink.BpfFilter) bool {
+	for _, e := range existing {
+		if e.Attrs().Priority != f.Priority || e.Attrs().Handle != f.Handle {
+			continue
+		}
+		bpf, ok := e.(*netlink.BpfFilter)
+		return ok && strings.HasPrefix(bpf.Name, tcFilterName)
+	}
+	return false
+}
+
+// Detach removes the program from the veth of index, the filters are gone already if the veth is.
+func (c *Classifier) Detach(index int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ips.Delete(uint32(index))
+	for _, f := range c.filters[index] {
+		delFilter(f)
+	}
+	delete(c.filters, index)
+}
+
+func (c *Classifier) Close() error {
+	c.mu.Lock()
+	for _, filters := range c.filters {
+		for _, f := range filters {
+			delFilter(f)
+		}
+	}
+	c.filters = make(map[int][]*netlink.BpfFilter)
+	c.mu.Unlock()
+	c.prog.Close()
+	return c.Ebpf.Close()
+}
+
+func clsact(index int) *netlink.GenericQdisc {
+	return &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+}
+
+// classifierFilters returns the filters of the program for both directions of the veth of index, the
+// requests and the responses of the pod cross the veth in opposite directions.
+func classifierFilters(index int, fd int) []*netlink.BpfFilter {
+	var filters []*netlink.BpfFilter
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		filters = append(filters, &netlink.BpfFilter{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: index,
+				Parent:    parent,
+				Handle:    netlink.MakeHandle(0, tcFilterHandle),
+				Protocol:  unix.ETH_P_ALL,
+				Priority:  tcFilterPriority,
+			},
+			Fd:           fd,
+			Name:         tcFilterName,
+			DirectAction: true,
+		})
+	}
+	return filters
+}
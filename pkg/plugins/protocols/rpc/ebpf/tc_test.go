@@ -0,0 +1,58 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestClassifierFilters(t *testing.T) {
+	filters := classifierFilters(12, 7)
+	if len(filters) != 2 {
+		t.Fatalf("expected a filter by direction, got %d", len(filters))
+	}
+	parents := map[uint32]bool{}
+	for _, f := range filters {
+		if f.LinkIndex != 12 || f.Fd != 7 || !f.DirectAction || f.Priority != tcFilterPriority || f.Handle == netlink.MakeHandle(0, 1) {
+			t.Errorf("unexpected filter %+v", f)
+		}
+		parents[f.Parent] = true
+	}
+	if !parents[netlink.HANDLE_MIN_INGRESS] || !parents[netlink.HANDLE_MIN_EGRESS] {
+		t.Errorf("expected the ingress and the egress of the clsact qdisc, got %v", parents)
+	}
+	if q := clsact(12); q.Type() != "clsact" || q.Parent != netlink.HANDLE_CLSACT {
+		t.Errorf("unexpected qdisc %+v", q)
+	}
+}
+
+func TestFilterSlot(t *testing.T) {
+	f := classifierFilters(12, 7)[0]
+	cilium := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{LinkIndex: 12, Parent: f.Parent, Handle: netlink.MakeHandle(0, 1), Priority: 1},
+		Name:        "bpf_lxc.o:[from-container]",
+	}
+	ours := &netlink.BpfFilter{FilterAttrs: f.FilterAttrs, Name: tcFilterName}
+	other := &netlink.U32{FilterAttrs: f.FilterAttrs}
+
+	if got := taken([]netlink.Filter{cilium}, f); got != nil {
+		t.Errorf("the slot of the CNI should not be taken by the plugin, got %+v", got)
+	}
+	if got := taken([]netlink.Filter{cilium, other}, f); got != other {
+		t.Errorf("the filter in the slot of the plugin should be reported, got %+v", got)
+	}
+	cases := []struct {
+		name     string
+		existing []netlink.Filter
+		owned    bool
+	}{
+		{name: "plugin filter", existing: []netlink.Filter{cilium, ours}, owned: true},
+		{name: "other filter in the slot", existing: []netlink.Filter{cilium, other}, owned: false},
+		{name: "only the CNI filter", existing: []netlink.Filter{cilium}, owned: false},
+	}
+	for _, c := range cases {
+		if got := owned(c.existing, f); got != c.owned {
+			t.Errorf("%s: owned = %v, want %v", c.name, got, c.owned)
+		}
+	}
+}
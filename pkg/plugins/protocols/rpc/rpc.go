@@ -3,16 +3,23 @@ package rpc
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"regexp"
+	"strconv"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	grpcebpf "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/grpc/ebpf"
 	rpcebpf "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/rpc/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
 	"github.com/erda-project/erda-infra/base/servicehub"
 	"k8s.io/klog/v2"
 )
@@ -25,22 +32,48 @@ const (
 	dbErrorMeasurementGroup  = dbMeasurementGroup + "_error"
 )
 
+const (
+	// attachModeSocket loads a collection per veth and attaches its program to a raw socket of the veth,
+	// attachModeTC loads a single collection and attaches its program to the clsact qdisc of every veth.
+	attachModeSocket = "socket"
+	attachModeTC     = "tc"
+)
+
 var (
 	pathRegexp = regexp.MustCompile(`(.*)!([a-zA-Z.]+)([0-9.]+)([a-zA-Z/;]+)`)
 )
 
+type Config struct {
+	// AttachMode is socket or tc, tc is lighter on the nodes with many pods and needs clsact (4.5+).
+	AttachMode string `env:"RPC_ATTACH_MODE" default:"socket"`
+}
+
 type provider struct {
-	sync.RWMutex
+	cfg          Config
 	ch           chan rpcebpf.Metric
 	kprobeHelper kprobe.Interface
 	netNatHelper netfilter.Interface
-	rpcProbes    map[int]*rpcebpf.Ebpf
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	classifier   *rpcebpf.Classifier
+	clock        clock.Clock
 }
 
 func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	if p.cfg.AttachMode != attachModeSocket && p.cfg.AttachMode != attachModeTC {
+		return fmt.Errorf("invalid RPC_ATTACH_MODE %q, expected %s or %s", p.cfg.AttachMode, attachModeSocket, attachModeTC)
+	}
 	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
-	p.netNatHelper = ctx.Service("netfilter").(netfilter.Interface)
-	p.rpcProbes = make(map[int]*rpcebpf.Ebpf)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
 	return nil
 }
 
@@ -52,93 +85,102 @@ func (p *provider) Gather(c chan *metric.Metric) {
 	if err != nil {
 		panic(err)
 	}
-	vethes, err := p.kprobeHelper.GetVethes()
-	if err != nil {
-		panic(err)
-	}
-	for _, veth := range vethes {
-		proj := rpcebpf.NewEbpf(veth.Link.Attrs().Index, veth.Neigh.IP.String(), p.ch)
-		if err := proj.Load(spec); err != nil {
-			klog.Errorf("failed to load ebpf, err: %v", err)
-			continue
+	if p.cfg.AttachMode == attachModeTC {
+		classifier := rpcebpf.NewClassifier(p.ch)
+		if err := classifier.Load(spec); err != nil {
+			klog.Errorf("failed to load ebpf in tc mode, err: %v", err)
+			return
 		}
-		p.Lock()
-		p.rpcProbes[veth.Link.Attrs().Index] = proj
-		p.Unlock()
+		p.classifier = classifier
 	}
-	go p.sendMetrics(c)
-	vethEvents := p.kprobeHelper.RegisterNetLinkListener()
-	for {
-		select {
-		case event := <-vethEvents:
-			switch event.Type {
-			case kprobe.LinkAdd:
-				klog.Infof("veth add, index: %d, ip: %s", event.Link.Attrs().Index, event.Neigh.IP.String())
-				p.Lock()
-				if _, ok := p.rpcProbes[event.Link.Attrs().Index]; ok {
-					p.Unlock()
-					continue
-				}
-				proj := rpcebpf.NewEbpf(event.Link.Attrs().Index, event.Neigh.IP.String(), p.ch)
-				if err := proj.Load(spec); err != nil {
-					klog.Errorf("failed to load ebpf, err: %v", err)
-					p.Unlock()
-					continue
-				}
-				p.rpcProbes[event.Link.Attrs().Index] = proj
-				p.Unlock()
-			case kprobe.LinkDelete:
-				klog.Infof("veth delete, index: %d, ip: %s", event.Link.Attrs().Index, event.Neigh.IP.String())
-				p.Lock()
-				proj, ok := p.rpcProbes[event.Link.Attrs().Index]
-				if ok {
-					proj.Close()
-					delete(p.rpcProbes, event.Link.Attrs().Index)
-				}
-				p.Unlock()
-			default:
-				klog.Infof("unknown event type: %v", event.Type)
+	probes, err := attach.Start("rpc", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		ip := l.IP
+		if len(l.Pods) > 0 {
+			// the calls of all the pods of a shared interface pass the filter of 0.0.0.0.
+			ip = net.IPv4zero.String()
+		}
+		if p.classifier != nil {
+			// the internal ports are only traced by raw sockets opened in their namespace.
+			if l.NetNS != "" {
+				return nil, fmt.Errorf("the interfaces in the namespaces of the pods are not traced in tc mode")
+			}
+			if err := p.classifier.Attach(l.Index, ip); err != nil {
+				return nil, err
 			}
+			return classified{p.classifier, l.Index}, nil
 		}
+		proj := rpcebpf.NewEbpf(l.Index, ip, p.ch)
+		proj.NetNS = l.NetNS
+		return proj, proj.Load(spec)
+	})
+	if err != nil {
+		panic(err)
+	}
+	p.probes = probes
+	go p.sendMetrics(c)
+}
+
+// classified is the tc filter of the classifier on an interface.
+type classified struct {
+	c     *rpcebpf.Classifier
+	index int
+}
+
+func (c classified) Close() error {
+	c.c.Detach(c.index)
+	return nil
+}
+
+// Close detaches the probes, the classifier last.
+func (p *provider) Close() {
+	p.probes.Close()
+	if p.classifier != nil {
+		p.classifier.Close()
 	}
 }
 
 func (p *provider) sendMetrics(c chan *metric.Metric) {
+	startup, events := kprobe.WaitSyncedQueue(p.kprobeHelper, "rpc", p.ch)
+	c <- startup
+	emit := func(m *metric.Metric) { c <- m }
+	flush := p.clock.NewTicker(enrich.FlushInterval)
+	defer flush.Stop()
 	for {
 		select {
-		case m := <-p.ch:
+		case m := <-events:
+			// mysql statements and redis commands are reported by the mysql and redis plugins
+			if m.RpcType == rpcebpf.RPC_TYPE_MYSQL || m.RpcType == rpcebpf.RPC_TYPE_REDIS {
+				continue
+			}
 			if len(m.Status) == 0 || len(m.Path) == 0 {
-				if m.RpcType == rpcebpf.RPC_TYPE_GRPC {
+				if m.RpcType == rpcebpf.RPC_TYPE_GRPC || m.RpcType == rpcebpf.RPC_TYPE_TRIPLE {
 					m.Path = "Unknown"
 				} else {
 					continue
 				}
 			}
-			mc := p.convertRpc2Metric(&m)
-			// ignore redis ping
-			if mc.Name == redisMeasurementGroup && strings.ToLower(mc.Tags["redis_command"]) == "ping" {
-				continue
-			}
-			c <- &mc
-			//klog.Infof("rpc metric: %+v", mc)
+			p.enricher.Submit(func() *metric.Metric {
+				mc := p.convertRpc2Metric(&m)
+				// ignore redis ping
+				if mc.Name == redisMeasurementGroup && strings.ToLower(mc.Tags["redis_command"]) == "ping" {
+					return nil
+				}
+				return &mc
+			}, emit)
+		case <-flush.C():
+			p.enricher.Flush()
 		}
 	}
 }
 
 func (p *provider) convertRpc2Metric(m *rpcebpf.Metric) metric.Metric {
 	res := metric.Metric{
-		Timestamp: time.Now().UnixNano(),
+		Timestamp: p.clock.Now().UnixNano(),
 		Tags:      map[string]string{},
-		Fields: map[string]interface{}{
-			"elapsed_count": 1,
-			"elapsed_sum":   m.Duration,
-			"elapsed_max":   m.Duration,
-			"elapsed_min":   m.Duration,
-			"elapsed_mean":  m.Duration,
-		},
+		Fields:    enrich.Elapsed(m.Duration),
 	}
 	switch m.RpcType {
-	case rpcebpf.RPC_TYPE_DUBBO, rpcebpf.RPC_TYPE_GRPC:
+	case rpcebpf.RPC_TYPE_DUBBO, rpcebpf.RPC_TYPE_GRPC, rpcebpf.RPC_TYPE_TRIPLE:
 		res.Name, res.Measurement = rpcMeasurementGroup, rpcMeasurementGroup
 	case rpcebpf.RPC_TYPE_MYSQL:
 		res.Name, res.Measurement = dbMeasurementGroup, dbMeasurementGroup
@@ -156,18 +198,28 @@ func (p *provider) convertRpc2Metric(m *rpcebpf.Metric) metric.Metric {
 			res.Tags["db_error"] = m.MysqlErr
 		}
 	}
-	res.Tags["metric_source"] = "ebpf"
-	res.Tags["_meta"] = "true"
-	res.Tags["_metric_scope"] = "micro_service"
-	res.Tags["span_kind"] = "server"
+	p.enricher.Enrich(&res, string(m.RpcType), enrich.Endpoints{
+		SourceIP:     m.SrcIP,
+		SourcePort:   m.SrcPort,
+		DestIP:       m.DstIP,
+		DestPort:     m.DstPort,
+		SocketCookie: m.SocketCookie,
+		Pid:          m.Pid,
+	})
 	res.Tags["rpc_type"] = string(m.RpcType)
-	res.Tags["peer_address"] = fmt.Sprintf("%s:%d", m.DstIP, m.DstPort)
-	if m.RpcType != rpcebpf.RPC_TYPE_REDIS {
+	if p.enricher.LegacyTags() && m.RpcType != rpcebpf.RPC_TYPE_REDIS {
 		res.Tags["peer_service"] = m.Path
 		res.Tags["method"] = m.Path
+		res.Tags["db_host"] = fmt.Sprintf("%s:%d", m.SrcIP, m.SrcPort)
+		// the rpc metrics were scoped by their source pod before the shared schema.
+		if scope, ok := res.Tags["source_terminus_key"]; ok {
+			res.Tags["_metric_scope_id"] = scope
+		}
+	}
+	// db_host is the address of the database for db and cache measurements.
+	if m.RpcType == rpcebpf.RPC_TYPE_MYSQL || m.RpcType == rpcebpf.RPC_TYPE_REDIS {
+		res.Tags["db_host"] = res.Tags["peer_address"]
 	}
-	res.Tags["component"] = string(m.RpcType)
-	res.Tags["db_host"] = fmt.Sprintf("%s:%d", m.SrcIP, m.SrcPort)
 	var rpcTarget, rpcMethod, rpcService, rpcVersion, serviceVersion string
 	rpcTarget = m.Path
 	parseLine := pathRegexp.FindStringSubmatch(m.Path)
@@ -186,7 +238,7 @@ func (p *provider) convertRpc2Metric(m *rpcebpf.Metric) metric.Metric {
 		if len(protocolList) >= 5 {
 			res.Tags["redis_args"] = protocolList[4]
 		}
-		res.Tags["redis_staus"] = m.Status
+		res.Tags["redis_status"] = m.Status
 		res.Tags["redis_sql"] = res.Tags["redis_command"] + " " + res.Tags["redis_args"]
 		res.Tags["db_statement"] = res.Tags["redis_sql"]
 	}
@@ -202,9 +254,12 @@ func (p *provider) convertRpc2Metric(m *rpcebpf.Metric) metric.Metric {
 			res.Name = rpcErrorMeasurementGroup
 			res.Measurement = rpcErrorMeasurementGroup
 			res.Tags["error"] = "true"
+			p.errorCodes.Tag(res.Tags, errorcodes.Dubbo, m.Status)
 		}
 		res.Tags["rpc_method"] = res.Tags["dubbo_method"]
 		res.Tags["rpc_service"] = res.Tags["dubbo_service"]
+	} else if m.RpcType == rpcebpf.RPC_TYPE_GRPC || m.RpcType == rpcebpf.RPC_TYPE_TRIPLE {
+		p.grpcTags(&res, m)
 	} else {
 		if m.Status == "200" {
 			res.Tags["error"] = "false"
@@ -212,61 +267,47 @@ func (p *provider) convertRpc2Metric(m *rpcebpf.Metric) metric.Metric {
 			res.Tags["error"] = "true"
 		}
 	}
-	sourcePod, err := p.kprobeHelper.GetPodByUID(m.SrcIP)
-	if err == nil {
-		res.OrgName = sourcePod.Labels["DICE_ORG_NAME"]
-		res.Tags["source_application_id"] = sourcePod.Labels["DICE_APPLICATION_ID"]
-		res.Tags["source_application_name"] = sourcePod.Labels["DICE_APPLICATION_NAME"]
-		res.Tags["source_org_id"] = sourcePod.Labels["DICE_ORG_ID"]
-		res.Tags["_metric_scope_id"] = sourcePod.Annotations["msp.erda.cloud/terminus_key"]
-		res.Tags["org_name"] = sourcePod.Labels["DICE_ORG_NAME"]
-		res.Tags["cluster_name"] = sourcePod.Labels["DICE_CLUSTER_NAME"]
-		res.Tags["source_project_id"] = sourcePod.Labels["DICE_PROJECT_ID"]
-		res.Tags["source_project_name"] = sourcePod.Labels["DICE_PROJECT_NAME"]
-		res.Tags["source_runtime_id"] = sourcePod.Labels["DICE_RUNTIME_ID"]
-		res.Tags["source_runtime_name"] = sourcePod.Annotations["msp.erda.cloud/runtime_name"]
-		//res.Tags["source_service_id"] = fmt.Sprintf("%s_%s_%s", sourcePod.Labels["DICE_APPLICATION_ID"], sourcePod.Annotations["msp.erda.cloud/runtime_name"], sourcePod.Labels["DICE_SERVICE_NAME"])
-		res.Tags["source_service_id"] = sourcePod.Annotations["msp.erda.cloud/service_name"]
-		res.Tags["source_service_name"] = sourcePod.Annotations["msp.erda.cloud/service_name"]
-		res.Tags["source_workspace"] = sourcePod.Annotations["msp.erda.cloud/workspace"]
-		res.Tags["source_terminus_key"] = sourcePod.Annotations["msp.erda.cloud/terminus_key"]
-	}
+	return res
+}
 
-	dstIP := m.DstIP
-	natInfo, exist := p.netNatHelper.GetNatInfo(m.SrcIP, m.SrcPort)
-	if exist {
-		dstIP = natInfo.ReplyDstIP
-		m.DstPort = natInfo.ReplyDstPort
+// grpcTags sets the service, method and status of gRPC and dubbo 3 triple calls, both use the
+// /<service>/<method> path. The grpc-status is only seen in trailers-only responses, which is how
+// most failed unary calls end, otherwise the :status of the response decides.
+func (p *provider) grpcTags(res *metric.Metric, m *rpcebpf.Metric) {
+	service, method := grpcebpf.ParsePath(m.Path)
+	if method != "" {
+		res.Tags["rpc_service"] = service
+		res.Tags["rpc_method"] = method
 	}
-	targetPod, err := p.kprobeHelper.GetPodByUID(dstIP)
-	if err == nil {
-		res.OrgName = targetPod.Labels["DICE_ORG_NAME"]
-		res.Tags["cluster_name"] = targetPod.Labels["DICE_CLUSTER_NAME"]
-		//res.Tags["_metric_scope_id"] = targetPod.Annotations["msp.erda.cloud/terminus_key"]
-		res.Tags["host_ip"] = targetPod.Status.HostIP
-		res.Tags["org_name"] = targetPod.Labels["DICE_ORG_NAME"]
-		res.Tags["target_application_id"] = targetPod.Labels["DICE_APPLICATION_ID"]
-		res.Tags["target_application_name"] = targetPod.Labels["DICE_APPLICATION_NAME"]
-		res.Tags["target_org_id"] = targetPod.Labels["DICE_ORG_ID"]
-		res.Tags["target_project_id"] = targetPod.Labels["DICE_PROJECT_ID"]
-		res.Tags["target_project_name"] = targetPod.Labels["DICE_PROJECT_NAME"]
-		res.Tags["target_runtime_id"] = targetPod.Labels["DICE_RUNTIME_ID"]
-		res.Tags["target_runtime_name"] = targetPod.Annotations["msp.erda.cloud/runtime_name"]
-		//res.Tags["target_service_id"] = fmt.Sprintf("%s_%s_%s", targetPod.Labels["DICE_APPLICATION_ID"], targetPod.Annotations["msp.erda.cloud/runtime_name"], targetPod.Labels["DICE_SERVICE_NAME"])
-		res.Tags["target_service_id"] = targetPod.Annotations["msp.erda.cloud/service_name"]
-		res.Tags["target_service_instance_id"] = string(targetPod.UID)
-		res.Tags["target_service_name"] = targetPod.Annotations["msp.erda.cloud/service_name"]
-		res.Tags["target_terminus_key"] = targetPod.Annotations["msp.erda.cloud/terminus_key"]
-		res.Tags["target_workspace"] = targetPod.Annotations["msp.erda.cloud/workspace"]
+	isError := m.GrpcStatus > 0 || (m.GrpcStatus < 0 && m.Status != "200")
+	res.Tags["error"] = strconv.FormatBool(isError)
+	if m.GrpcStatus >= 0 {
+		res.Tags["grpc_status_code"] = strconv.Itoa(m.GrpcStatus)
+		if isError {
+			p.errorCodes.Tag(res.Tags, errorcodes.GRPC, strconv.Itoa(m.GrpcStatus))
+		}
+	}
+	if m.RpcType != rpcebpf.RPC_TYPE_TRIPLE {
+		return
+	}
+	// reported like classic dubbo calls
+	if method != "" {
+		res.Tags["rpc_target"] = service + "." + method
+		res.Tags["dubbo_service"] = service
+		res.Tags["dubbo_method"] = method
+	}
+	if isError {
+		res.Name = rpcErrorMeasurementGroup
+		res.Measurement = rpcErrorMeasurementGroup
 	}
-	return res
 }
 
 func init() {
 	servicehub.Register("rpc", &servicehub.Spec{
-		Services:     []string{"rpc"},
-		Description:  "ebpf for rpc",
-		Dependencies: []string{"kprobe", "netfilter"},
+		Services:             []string{"rpc"},
+		Description:          "ebpf for rpc",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
 		Creator: func() servicehub.Provider {
 			return &provider{}
 		},
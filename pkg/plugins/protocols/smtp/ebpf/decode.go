@@ -0,0 +1,112 @@
+package ebpf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	// replyAuthContinue asks for the next line of an authentication, the command is not answered yet.
+	replyAuthContinue = 334
+	// replyStartData accepts the DATA command, the client sends the message.
+	replyStartData = 354
+	replyReady     = 220
+
+	// verbDataEnd is the pseudo command of the end of a message.
+	verbDataEnd = "."
+)
+
+type command struct {
+	verb string
+	arg  string
+}
+
+type reply struct {
+	code int
+	// last is false for the lines of a multiline reply but the last one.
+	last bool
+	text string
+}
+
+// lines splits a packet into its lines, the last one may be truncated.
+func lines(buf []byte) []string {
+	var ans []string
+	for len(buf) > 0 {
+		i := bytes.Index(buf, []byte("\r\n"))
+		if i < 0 {
+			ans = append(ans, string(buf))
+			break
+		}
+		ans = append(ans, string(buf[:i]))
+		buf = buf[i+2:]
+	}
+	return ans
+}
+
+// parseCommands returns the commands of a packet, clients supporting PIPELINING send several of them.
+func parseCommands(buf []byte) []command {
+	var ans []command
+	for _, line := range lines(buf) {
+		if len(line) < 4 {
+			continue
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if len(verb) != 4 && verb != "STARTTLS" {
+			continue
+		}
+		ans = append(ans, command{verb: verb, arg: arg})
+	}
+	return ans
+}
+
+// parseReplies returns the reply lines of a packet, see RFC 5321 4.2.
+func parseReplies(buf []byte) []reply {
+	var ans []reply
+	for _, line := range lines(buf) {
+		if len(line) < 3 {
+			continue
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil || code < 200 || code > 599 {
+			continue
+		}
+		r := reply{code: code, last: true}
+		if len(line) > 3 {
+			r.last = line[3] != '-'
+			r.text = strings.TrimSpace(line[4:])
+		}
+		ans = append(ans, r)
+	}
+	return ans
+}
+
+// senderDomain returns the domain of the address of MAIL FROM:<user@domain> SIZE=..., bounces have
+// an empty reverse path.
+func senderDomain(arg string) string {
+	start, end := strings.IndexByte(arg, '<'), strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	addr := arg[start+1 : end]
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return strings.ToLower(addr[i+1:])
+	}
+	return ""
+}
+
+// enhancedStatus returns the RFC 3463 status code starting the text of a reply, e.g. 5.1.1.
+func enhancedStatus(code int, text string) string {
+	status, _, _ := strings.Cut(text, " ")
+	parts := strings.Split(status, ".")
+	if len(parts) != 3 || parts[0] != strconv.Itoa(code/100) {
+		return ""
+	}
+	for _, p := range parts[1:] {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 999 {
+			return ""
+		}
+	}
+	return status
+}
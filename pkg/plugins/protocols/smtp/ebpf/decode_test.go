@@ -0,0 +1,109 @@
+package ebpf
+
+import (
+	"testing"
+)
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 25}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, s string) []*Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	var ev SmtpEvent
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+		if len(s) >= 3 && s[len(s)-3:] == ".\r\n" {
+			ev.Flag = kindDataEnd
+		}
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], s))
+	return t.handle(&key, &ev)
+}
+
+func TestParse(t *testing.T) {
+	if got := senderDomain("FROM:<Alerts@Example.COM> SIZE=1024"); got != "example.com" {
+		t.Errorf("senderDomain() = %q", got)
+	}
+	if got := senderDomain("FROM:<>"); got != "" {
+		t.Errorf("senderDomain() of a bounce = %q", got)
+	}
+	if got := enhancedStatus(550, "5.1.1 <bob@example.com>: Recipient address rejected"); got != "5.1.1" {
+		t.Errorf("enhancedStatus() = %q", got)
+	}
+	if got := enhancedStatus(250, "2.0.0 Ok: queued as 4F2A1"); got != "2.0.0" {
+		t.Errorf("enhancedStatus() = %q", got)
+	}
+	if got := enhancedStatus(550, "Mailbox unavailable"); got != "" {
+		t.Errorf("enhancedStatus() = %q", got)
+	}
+	replies := parseReplies([]byte("250-mail.example.com\r\n250-PIPELINING\r\n250 SMTPUTF8\r\n"))
+	if len(replies) != 3 || replies[0].last || !replies[2].last || replies[2].text != "SMTPUTF8" {
+		t.Errorf("unexpected replies %+v", replies)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, false, "220 mail.example.com ESMTP Postfix\r\n")
+	packet(tr, 2, true, "EHLO app-7d9f\r\n")
+	packet(tr, 3, false, "250-mail.example.com\r\n250-PIPELINING\r\n250 SMTPUTF8\r\n")
+	packet(tr, 4, true, "AUTH LOGIN\r\n")
+	packet(tr, 5, false, "334 VXNlcm5hbWU6\r\n")
+	packet(tr, 6, false, "334 UGFzc3dvcmQ6\r\n")
+	packet(tr, 7, false, "235 2.7.0 Authentication successful\r\n")
+
+	// pipelined envelope
+	packet(tr, 10, true, "MAIL FROM:<noreply@shop.example.com>\r\nRCPT TO:<a@example.org>\r\nRCPT TO:<b@example.org>\r\nRCPT TO:<c@example.org>\r\nDATA\r\n")
+	packet(tr, 12, false, "250 2.1.0 Ok\r\n250 2.1.5 Ok\r\n550 5.1.1 <b@example.org>: Recipient address rejected\r\n250 2.1.5 Ok\r\n354 End data with <CR><LF>.<CR><LF>\r\n")
+	packet(tr, 13, true, "Subject: order confirmed\r\n\r\nThanks for your order.\r\n")
+	packet(tr, 14, true, ".\r\n")
+	ms := packet(tr, 30, false, "250 2.0.0 Ok: queued as 4F2A1\r\n")
+	if len(ms) != 1 {
+		t.Fatalf("expected the delivered mail, got %+v", ms)
+	}
+	m := ms[0]
+	if m.SenderDomain != "shop.example.com" || m.Recipients != 2 || m.RejectedRecipients != 1 || m.ReplyCode != 250 ||
+		m.EnhancedStatus != "2.0.0" || m.Error || m.Duration != 20 || m.Captured != 30 || m.DestPort != 25 {
+		t.Errorf("unexpected transaction %+v", m)
+	}
+
+	// rejected sender
+	packet(tr, 40, true, "MAIL FROM:<spam@bad.example>\r\n")
+	ms = packet(tr, 41, false, "554 5.7.1 Sender rejected\r\n")
+	if len(ms) != 1 || !ms[0].Error || ms[0].FailedStage != StageMail || ms[0].ReplyCode != 554 || ms[0].ReplyText != "5.7.1 Sender rejected" {
+		t.Errorf("unexpected transaction %+v", ms)
+	}
+
+	// message rejected after the data, the dot sent with the end of the body
+	packet(tr, 50, true, "MAIL FROM:<noreply@shop.example.com>\r\n")
+	packet(tr, 51, false, "250 2.1.0 Ok\r\n")
+	packet(tr, 52, true, "RCPT TO:<a@example.org>\r\n")
+	packet(tr, 53, false, "250 2.1.5 Ok\r\n")
+	packet(tr, 54, true, "DATA\r\n")
+	packet(tr, 55, false, "354 go ahead\r\n")
+	packet(tr, 56, true, "Subject: hi\r\n\r\nbody\r\n.\r\n")
+	ms = packet(tr, 60, false, "552 5.3.4 Message size exceeds fixed limit\r\n")
+	if len(ms) != 1 || !ms[0].Error || ms[0].FailedStage != StageMessage || ms[0].Recipients != 1 {
+		t.Errorf("unexpected transaction %+v", ms)
+	}
+}
+
+func TestStartTLSAndExpire(t *testing.T) {
+	tr := newTracker()
+	packet(tr, 1, true, "STARTTLS\r\n")
+	packet(tr, 2, false, "220 2.0.0 Ready to start TLS\r\n")
+	packet(tr, 3, true, "MAIL FROM:<a@example.com>\r\n")
+	if c := tr.conns[client]; !c.encrypted || c.tx != nil {
+		t.Errorf("the session should be ignored after STARTTLS, got %+v", c)
+	}
+
+	tr = newTracker()
+	packet(tr, 1, true, "MAIL FROM:<a@example.com>\r\n")
+	tr.expire(transactionTimeout + 5)
+	if len(tr.conns) != 0 {
+		t.Errorf("idle connections should be dropped")
+	}
+}
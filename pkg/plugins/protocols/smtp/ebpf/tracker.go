@@ -0,0 +1,202 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// transactionTimeout drops the transactions without final reply, large messages take minutes.
+	transactionTimeout = uint64(600e9)
+	// connIdleTimeout forgets the connections without commands, e.g. kept open by connection pools.
+	connIdleTimeout = uint64(600e9)
+	// maxPending bounds the commands waiting for their reply, e.g. when replies were not captured.
+	maxPending = 64
+)
+
+type transaction struct {
+	metric *Metric
+	ts     uint64
+}
+
+// conn is the state of a client connection. Replies answer the commands in their order, even when
+// the client pipelines them.
+type conn struct {
+	pending []command
+	tx      *transaction
+	// data is set while the client sends a message, its packets are not commands.
+	data bool
+	// encrypted is set after STARTTLS, the rest of the session is not readable.
+	encrypted bool
+	lastSeen  uint64
+	// cookie is the socket cookie of the client, taken from its packets.
+	cookie uint64
+}
+
+// tracker follows the mail transactions of the SMTP sessions. Connections are keyed in the
+// client -> server direction, the client is the pod attached to the veth.
+type tracker struct {
+	conns map[ConnKey]*conn
+}
+
+func newTracker() *tracker {
+	return &tracker{conns: make(map[ConnKey]*conn)}
+}
+
+// handle processes a packet, it returns the transactions completed by it.
+func (t *tracker) handle(key *EventKey, ev *SmtpEvent) []*Metric {
+	buf := ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))]
+	// commands of the pod and the replies to it, sessions of server pods are ignored that way.
+	if ev.FromPod == 1 {
+		c := t.conns[key.Conn]
+		if c == nil {
+			c = &conn{}
+			t.conns[key.Conn] = c
+		}
+		c.lastSeen = key.Timestamp
+		if ev.Cookie != 0 {
+			c.cookie = ev.Cookie
+		}
+		t.handleCommands(key, c, ev.Flag, buf)
+		return nil
+	}
+	connKey := ConnKey{
+		SourceIP:   key.Conn.DestIP,
+		DestIP:     key.Conn.SourceIP,
+		SourcePort: key.Conn.DestPort,
+		DestPort:   key.Conn.SourcePort,
+	}
+	c := t.conns[connKey]
+	if c == nil || c.encrypted {
+		return nil
+	}
+	c.lastSeen = key.Timestamp
+	var done []*Metric
+	for _, r := range parseReplies(buf) {
+		if m := c.handleReply(r, key.Timestamp); m != nil {
+			done = append(done, m)
+		}
+	}
+	return done
+}
+
+func (t *tracker) handleCommands(key *EventKey, c *conn, kind uint8, buf []byte) {
+	if c.encrypted {
+		return
+	}
+	if c.data {
+		// the body of the message, only its end is captured
+		if kind == kindDataEnd {
+			c.data = false
+			c.push(command{verb: verbDataEnd})
+		}
+		return
+	}
+	for _, cmd := range parseCommands(buf) {
+		if cmd.verb == "MAIL" {
+			c.tx = &transaction{
+				metric: &Metric{
+					SourceIP:     net.IP(key.Conn.SourceIP[:]).String(),
+					SourcePort:   key.Conn.SourcePort,
+					DestIP:       net.IP(key.Conn.DestIP[:]).String(),
+					DestPort:     key.Conn.DestPort,
+					SenderDomain: senderDomain(cmd.arg),
+					SocketCookie: c.cookie,
+				},
+				ts: key.Timestamp,
+			}
+		}
+		c.push(cmd)
+	}
+}
+
+func (c *conn) push(cmd command) {
+	if len(c.pending) == maxPending {
+		c.pending = c.pending[1:]
+	}
+	c.pending = append(c.pending, cmd)
+}
+
+// handleReply pairs the last line of a reply with the oldest command, it returns the transaction
+// ended by it.
+func (c *conn) handleReply(r reply, ts uint64) *Metric {
+	if !r.last || r.code == replyAuthContinue {
+		return nil
+	}
+	// only DATA is accepted by 354, the commands before it lost their replies
+	if r.code == replyStartData {
+		for len(c.pending) > 0 && c.pending[0].verb != "DATA" {
+			c.pending = c.pending[1:]
+		}
+	}
+	// e.g. the greeting of the server
+	if len(c.pending) == 0 {
+		return nil
+	}
+	cmd := c.pending[0]
+	c.pending = c.pending[1:]
+	failed := r.code >= 400
+	switch cmd.verb {
+	case "MAIL":
+		if failed {
+			return c.finish(r, StageMail, ts)
+		}
+	case "RCPT":
+		if c.tx == nil {
+			return nil
+		}
+		if failed {
+			c.tx.metric.RejectedRecipients++
+		} else {
+			c.tx.metric.Recipients++
+		}
+	case "DATA":
+		if r.code == replyStartData {
+			c.data = true
+		} else if failed {
+			return c.finish(r, StageData, ts)
+		}
+	case verbDataEnd:
+		return c.finish(r, StageMessage, ts)
+	case "RSET":
+		c.tx = nil
+	case "STARTTLS":
+		if r.code == replyReady {
+			c.encrypted = true
+			c.pending, c.tx = nil, nil
+		}
+	}
+	return nil
+}
+
+func (c *conn) finish(r reply, stage string, ts uint64) *Metric {
+	if c.tx == nil {
+		return nil
+	}
+	m := c.tx.metric
+	m.ReplyCode = r.code
+	m.ReplyText = r.text
+	m.EnhancedStatus = enhancedStatus(r.code, r.text)
+	if r.code >= 400 {
+		m.Error = true
+		m.FailedStage = stage
+	}
+	if ts > c.tx.ts {
+		m.Duration = ts - c.tx.ts
+	}
+	m.Captured = ts
+	c.tx = nil
+	return m
+}
+
+// expire drops the transactions without final reply within transactionTimeout and the connections
+// idle for connIdleTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, c := range t.conns {
+		if c.tx != nil && now-c.tx.ts > transactionTimeout {
+			c.tx, c.data, c.pending = nil, false, nil
+		}
+		if now-c.lastSeen > connIdleTimeout {
+			delete(t.conns, key)
+		}
+	}
+}
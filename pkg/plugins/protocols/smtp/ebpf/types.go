@@ -0,0 +1,74 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	SmtpPayloadSize = 256
+)
+
+// kinds of the captured packets, see ebpf/plugins/smtp/main.c
+const (
+	kindLines   = 0
+	kindDataEnd = 1
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+// SmtpEvent is a packet, the Flag of its header is kindDataEnd for the packet ending a message, the payload
+// is then the end of the body.
+type SmtpEvent struct {
+	socketfilter.Header
+	Payload [SmtpPayloadSize]byte
+}
+
+// stages of the transaction rejecting a mail
+const (
+	StageMail    = "MAIL"
+	StageData    = "DATA"
+	StageMessage = "MESSAGE"
+)
+
+// Metric is a mail transaction, from MAIL FROM to the reply to the message.
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// SenderDomain is the domain of the MAIL FROM address, the addresses are not reported.
+	SenderDomain string
+	// Recipients are the RCPT TO accepted by the server, RejectedRecipients the ones it refused.
+	Recipients         int
+	RejectedRecipients int
+
+	// ReplyCode is the reply ending the transaction, EnhancedStatus its RFC 3463 status if any, e.g. 5.7.1.
+	ReplyCode      int
+	EnhancedStatus string
+	ReplyText      string
+	// FailedStage is the command rejecting the mail, empty if the mail was accepted.
+	FailedStage string
+	Error       bool
+
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s smtp [%s:%d] --> [%s:%d][%s rcpt %d/%d] ====> %d [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.SenderDomain,
+		m.Recipients, m.Recipients+m.RejectedRecipients,
+		m.ReplyCode, time.Duration(m.Duration).String(),
+	)
+}
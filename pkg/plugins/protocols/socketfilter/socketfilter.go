@@ -0,0 +1,215 @@
+// Package socketfilter loads the socket filters of the protocol plugins capturing the payloads of the
+// packets of a pod on its veth, and drains their events in the order of the packets.
+//
+// The events share their key and their head with ebpf/include/socket_event.h, the plugins only declare their
+// payload.
+package socketfilter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/utils"
+)
+
+const (
+	mapFilter = "filter_map"
+	// drainInterval is the interval the events are read from the kernel.
+	drainInterval = time.Second
+
+	SO_ATTACH_BPF = 0x32                     // 50
+	SO_DETACH_BPF = syscall.SO_DETACH_FILTER // 27
+)
+
+// ConnKey mirrors sock_key, the connection in the direction of the packet.
+type ConnKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+// EventKey mirrors socket_event_key.
+type EventKey struct {
+	Conn ConnKey
+	_    uint32
+	// Timestamp is the capture (bpf_ktime_get_ns) of the packet.
+	Timestamp uint64
+}
+
+// Header mirrors SOCKET_EVENT_HEADER, the head of the events followed by the payload of the protocol.
+type Header struct {
+	PayloadLen uint16
+	// FromPod is set if the packet was sent by the pod attached to the veth.
+	FromPod uint8
+	// Flag is left to the protocol, e.g. the transport of dns.
+	Flag uint8
+	_    uint32
+	// Cookie is the socket cookie of the sender, 0 for packets not owned by a local socket.
+	Cookie uint64
+}
+
+// Filter is a socket filter attached to a raw socket of the veth of a pod.
+type Filter struct {
+	Collection *ebpf.Collection
+	fd         int
+	sock       int
+	stopper    chan struct{}
+	clock      clock.Clock
+}
+
+// Load loads the program of path, lets configure fill its maps (e.g. the excluded ports) and attaches the
+// program to a raw socket of the interface of l, restricted to the packets of its pods (Link.IPs). The
+// programs without filter_map (kafka) see all the packets of the interface.
+func Load(path, program string, l attach.Link, configure func(*ebpf.Collection) error) (*Filter, error) {
+	programBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return nil, err
+	}
+	collection, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{Collection: collection, sock: -1, stopper: make(chan struct{}), clock: clock.Real}
+	if err := f.attach(program, l, configure); err != nil {
+		f.detach()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Filter) attach(program string, l attach.Link, configure func(*ebpf.Collection) error) error {
+	if err := configure(f.Collection); err != nil {
+		return err
+	}
+	prog := f.Collection.DetachProgram(program)
+	if prog == nil {
+		return fmt.Errorf("detach program %s failed", program)
+	}
+	f.fd = prog.FD()
+
+	var err error
+	if l.NetNS != "" {
+		f.sock, err = utils.OpenRawSockAt(l.NetNS, l.Index)
+	} else {
+		f.sock, err = utils.OpenRawSock(l.Index)
+	}
+	if err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(f.sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, f.fd); err != nil {
+		return err
+	}
+	filter, ok := f.Collection.Maps[mapFilter]
+	if !ok {
+		return nil
+	}
+	for _, ip := range l.IPs() {
+		if err := filter.Put(utils.Htonl(utils.IP4toDec(ip)), uint32(0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Filter) detach() {
+	if f.sock >= 0 {
+		_ = syscall.SetsockoptInt(f.sock, syscall.SOL_SOCKET, SO_DETACH_BPF, f.fd)
+		_ = syscall.Close(f.sock)
+	}
+	f.Collection.Close()
+}
+
+// Close stops the drain of the events and detaches the filter.
+func (f *Filter) Close() error {
+	close(f.stopper)
+	f.detach()
+	return nil
+}
+
+// Loop calls every each second until the filter is closed with the time of the call (bpf_ktime_get_ns),
+// e.g. to read the events of the filter and to expire the requests without response.
+func (f *Filter) Loop(every func(now uint64)) {
+	defer func() {
+		if err := recover(); err != nil {
+			klog.Errorf("panic: %v", err)
+			klog.Errorf("stack: %s", string(debug.Stack()))
+		}
+	}()
+	for {
+		select {
+		case <-f.stopper:
+			return
+		default:
+		}
+		var (
+			now uint64
+			ts  unix.Timespec
+		)
+		if unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts) == nil {
+			now = uint64(ts.Nano())
+		}
+		every(now)
+		f.clock.Sleep(drainInterval)
+	}
+}
+
+// Drain reads the events of m every second until the filter is closed: handle gets them in the order of the
+// packets, then tick gets the time of the read (bpf_ktime_get_ns), e.g. to expire the requests without
+// response. tick may be nil.
+func Drain[V any](f *Filter, m *ebpf.Map, handle func(*EventKey, *V), tick func(now uint64)) {
+	f.Loop(func(now uint64) {
+		Read(m, handle)
+		if tick != nil && now > 0 {
+			tick(now)
+		}
+	})
+}
+
+// Entry is an entry of a map.
+type Entry[K, V any] struct {
+	Key K
+	Val V
+}
+
+// Take removes the entries of m and returns them, for the plugins keying their events by their own key.
+func Take[K, V any](m *ebpf.Map) []Entry[K, V] {
+	var (
+		key     K
+		val     V
+		entries []Entry[K, V]
+	)
+	for m.Iterate().Next(&key, &val) {
+		entries = append(entries, Entry[K, V]{Key: key, Val: val})
+		if err := m.Delete(key); err != nil {
+			klog.Errorf("delete map error: %v", err)
+		}
+	}
+	return entries
+}
+
+// Read removes the events of m and passes them to handle in the order of the packets.
+func Read[V any](m *ebpf.Map, handle func(*EventKey, *V)) {
+	batch := Take[EventKey, V](m)
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].Key.Timestamp < batch[j].Key.Timestamp
+	})
+	for i := range batch {
+		handle(&batch[i].Key, &batch[i].Val)
+	}
+}
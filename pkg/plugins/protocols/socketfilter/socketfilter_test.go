@@ -0,0 +1,23 @@
+package socketfilter
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	// socket_event_key and SOCKET_EVENT_HEADER of ebpf/include/socket_event.h
+	if size := binary.Size(EventKey{}); size != 24 {
+		t.Errorf("expected the key on 24 bytes, got %d", size)
+	}
+	if size := binary.Size(Header{}); size != 16 {
+		t.Errorf("expected the header on 16 bytes, got %d", size)
+	}
+	event := struct {
+		Header
+		Payload [64]byte
+	}{}
+	if size := binary.Size(event); size != 16+64 {
+		t.Errorf("expected the payload right after the header, got %d bytes", size)
+	}
+}
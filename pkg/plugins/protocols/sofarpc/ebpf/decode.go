@@ -0,0 +1,226 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// see com.alipay.remoting.rpc.protocol.RpcCommandDecoder and RpcCommandDecoderV2
+const (
+	protocolV1 = 1
+	protocolV2 = 2
+
+	typeResponse = 0
+	typeRequest  = 1
+	typeOneway   = 2
+
+	cmdHeartbeat = 0
+	cmdRequest   = 1
+	cmdResponse  = 2
+
+	// switchCRC is the bit of the v2 protocol switch appending a crc32 to the command.
+	switchCRC = 0x01
+	crcSize   = 4
+
+	statusSuccess = 0
+)
+
+// header keys of sofa rpc, see com.alipay.sofa.rpc.common.RemotingConstants
+const (
+	headTargetService = "sofa_head_target_service"
+	headService       = "service"
+	headMethodName    = "sofa_head_method_name"
+	headTargetApp     = "sofa_head_target_app"
+)
+
+// codecNames are the serialization codes of sofa rpc.
+var codecNames = map[byte]string{
+	1:  "hessian2",
+	2:  "java",
+	11: "protobuf",
+	12: "json",
+}
+
+// statusNames are the names of the response statuses, see com.alipay.remoting.ResponseStatus.
+var statusNames = map[int]string{
+	0x00: "SUCCESS",
+	0x01: "ERROR",
+	0x02: "SERVER_EXCEPTION",
+	0x03: "UNKNOWN",
+	0x04: "SERVER_THREADPOOL_BUSY",
+	0x05: "ERROR_COMM",
+	0x06: "NO_PROCESSOR",
+	0x07: "TIMEOUT",
+	0x08: "CLIENT_SEND_ERROR",
+	0x09: "CODEC_EXCEPTION",
+	0x10: "CONNECTION_CLOSED",
+	0x11: "SERVER_SERIAL_EXCEPTION",
+	0x12: "SERVER_DESERIAL_EXCEPTION",
+}
+
+func codecName(codec byte) string {
+	if name, ok := codecNames[codec]; ok {
+		return name
+	}
+	return strconv.Itoa(int(codec))
+}
+
+func statusName(status int) string {
+	if name, ok := statusNames[status]; ok {
+		return name
+	}
+	return strconv.Itoa(status)
+}
+
+type command struct {
+	protocol  int
+	typ       byte
+	heartbeat bool
+	requestID uint32
+	codec     byte
+	status    int
+	class     string
+	header    map[string]string
+}
+
+func (c *command) isResponse() bool {
+	return c.typ == typeResponse
+}
+
+// service returns the interface, the version and the unique id of the target service,
+// sofa rpc sends them as interface:version[:uniqueId].
+func (c *command) service() (string, string, string) {
+	target := c.header[headTargetService]
+	if target == "" {
+		target = c.header[headService]
+	}
+	parts := strings.SplitN(target, ":", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// parseCommands parses the rpc commands of a packet, the class and the header of the last one may be
+// truncated. Heartbeats are skipped.
+func parseCommands(buf []byte) []command {
+	var commands []command
+	for len(buf) > 0 {
+		c, size, ok := parseCommand(buf)
+		if !ok {
+			break
+		}
+		if !c.heartbeat {
+			commands = append(commands, c)
+		}
+		if size > len(buf) {
+			break
+		}
+		buf = buf[size:]
+	}
+	return commands
+}
+
+// parseCommand parses the fixed header, the class and the header map of a command, it returns the
+// size of the whole command. The fixed header of v2 has the protocol version after the protocol
+// code and the protocol switch after the codec.
+func parseCommand(buf []byte) (command, int, bool) {
+	c := command{header: make(map[string]string)}
+	if len(buf) < 1 {
+		return c, 0, false
+	}
+	c.protocol = int(buf[0])
+	i := 1
+	switch c.protocol {
+	case protocolV1:
+	case protocolV2:
+		i++
+	default:
+		return c, 0, false
+	}
+	// type, command code, version, request id, codec
+	if len(buf) < i+9 {
+		return c, 0, false
+	}
+	c.typ = buf[i]
+	cmdCode := binary.BigEndian.Uint16(buf[i+1 : i+3])
+	c.requestID = binary.BigEndian.Uint32(buf[i+4 : i+8])
+	c.codec = buf[i+8]
+	i += 9
+	var protocolSwitch byte
+	if c.protocol == protocolV2 {
+		if len(buf) < i+1 {
+			return c, 0, false
+		}
+		protocolSwitch = buf[i]
+		i++
+	}
+	switch {
+	case c.typ == typeResponse && (cmdCode == cmdResponse || cmdCode == cmdHeartbeat):
+		if len(buf) < i+2 {
+			return c, 0, false
+		}
+		c.status = int(binary.BigEndian.Uint16(buf[i : i+2]))
+		i += 2
+	case (c.typ == typeRequest || c.typ == typeOneway) && (cmdCode == cmdRequest || cmdCode == cmdHeartbeat):
+		// timeout
+		i += 4
+	default:
+		return c, 0, false
+	}
+	if len(buf) < i+8 {
+		return c, 0, false
+	}
+	classLen := int(binary.BigEndian.Uint16(buf[i : i+2]))
+	headerLen := int(binary.BigEndian.Uint16(buf[i+2 : i+4]))
+	contentLen := int(binary.BigEndian.Uint32(buf[i+4 : i+8]))
+	i += 8
+	size := i + classLen + headerLen + contentLen
+	if protocolSwitch&switchCRC != 0 {
+		size += crcSize
+	}
+	if cmdCode == cmdHeartbeat {
+		c.heartbeat = true
+		return c, size, true
+	}
+	rest := buf[i:]
+	c.class = string(rest[:min(classLen, len(rest))])
+	rest = rest[min(classLen, len(rest)):]
+	c.header = parseHeader(rest[:min(headerLen, len(rest))])
+	return c, size, true
+}
+
+// parseHeader parses the header map of sofa rpc, the length prefixed keys and values, see
+// com.alipay.sofa.rpc.codec.common.SimpleMapSerializer. The entries before the truncation are kept.
+func parseHeader(b []byte) map[string]string {
+	header := make(map[string]string)
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := int(int32(binary.BigEndian.Uint32(b[0:4])))
+		b = b[4:]
+		// null strings
+		if n < 0 {
+			return "", true
+		}
+		if len(b) < n {
+			return "", false
+		}
+		s := string(b[:n])
+		b = b[n:]
+		return s, true
+	}
+	for {
+		key, ok := next()
+		if !ok {
+			return header
+		}
+		value, ok := next()
+		if !ok {
+			return header
+		}
+		header[key] = value
+	}
+}
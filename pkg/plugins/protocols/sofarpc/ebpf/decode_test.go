@@ -0,0 +1,141 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const sofaRequest = "com.alipay.sofa.rpc.core.request.SofaRequest"
+
+func header(kv ...string) []byte {
+	var b []byte
+	for _, s := range kv {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// boltRequest encodes a request of the v1 protocol, a request of the v2 protocol with crc if v2 is set.
+func boltRequest(v2 bool, typ byte, id uint32, class string, h []byte, content string) []byte {
+	b := []byte{protocolV1}
+	if v2 {
+		b = []byte{protocolV2, 1}
+	}
+	b = append(b, typ)
+	b = binary.BigEndian.AppendUint16(b, cmdRequest)
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = append(b, 1)
+	if v2 {
+		b = append(b, switchCRC)
+	}
+	b = binary.BigEndian.AppendUint32(b, 3000)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(class)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(content)))
+	b = append(append(append(b, class...), h...), content...)
+	if v2 {
+		b = append(b, 0, 0, 0, 0)
+	}
+	return b
+}
+
+func boltResponse(id uint32, status uint16) []byte {
+	b := []byte{protocolV1, typeResponse}
+	b = binary.BigEndian.AppendUint16(b, cmdResponse)
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint16(b, status)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 2)
+	return append(b, "ok"...)
+}
+
+func heartbeat(id uint32) []byte {
+	b := []byte{protocolV1, typeRequest}
+	b = binary.BigEndian.AppendUint16(b, cmdHeartbeat)
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = append(b, 1)
+	return append(b, make([]byte, 12)...)
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 12200}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, fromPod bool, commands ...[]byte) []*Metric {
+	key := EventKey{Conn: server, Timestamp: ts}
+	ev := BoltEvent{}
+	if fromPod {
+		key.Conn = client
+		ev.FromPod = 1
+	}
+	var buf []byte
+	for _, c := range commands {
+		buf = append(buf, c...)
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], buf))
+	return t.handle(&key, &ev)
+}
+
+func TestCall(t *testing.T) {
+	tr := newTracker()
+	order := header(headTargetService, "com.example.OrderService:1.0", headMethodName, "create", headTargetApp, "order")
+	user := header(headService, "com.example.UserService:1.0:blue", headMethodName, "get")
+	// pipelined requests, a heartbeat in between
+	packet(tr, 10, true, boltRequest(false, typeRequest, 1, sofaRequest, order, "body"), heartbeat(2),
+		boltRequest(true, typeRequest, 3, sofaRequest, user, "body"))
+	done := packet(tr, 30, false, boltResponse(3, 0x04), boltResponse(1, 0))
+	if len(done) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(done))
+	}
+	m := done[0]
+	if m.Service != "com.example.UserService" || m.Version != "1.0" || m.UniqueID != "blue" || m.Method != "get" ||
+		m.Protocol != 2 || !m.Error || m.Response != "SERVER_THREADPOOL_BUSY" || m.Duration != 20 {
+		t.Errorf("unexpected call %+v", m)
+	}
+	m = done[1]
+	if m.Service != "com.example.OrderService" || m.Method != "create" || m.TargetApp != "order" || m.Codec != "hessian2" ||
+		m.Class != sofaRequest || m.Error || m.Response != "SUCCESS" || m.SourcePort != 40000 || m.DestPort != 12200 {
+		t.Errorf("unexpected call %+v", m)
+	}
+	if len(tr.conns[client]) != 0 {
+		t.Errorf("completed calls should be removed")
+	}
+}
+
+func TestOnewayAndServer(t *testing.T) {
+	tr := newTracker()
+	h := header(headTargetService, "com.example.LogService:1.0", headMethodName, "append")
+	done := packet(tr, 1, true, boltRequest(false, typeOneway, 5, sofaRequest, h, ""))
+	if len(done) != 1 || !done[0].Oneway || done[0].Method != "append" || done[0].Duration != 0 {
+		t.Fatalf("unexpected oneway calls %+v", done)
+	}
+	// requests to the pod are reported by their clients
+	packet(tr, 2, false, boltRequest(false, typeRequest, 6, sofaRequest, h, ""))
+	if done := packet(tr, 3, true, boltResponse(6, 0)); len(done) != 0 {
+		t.Errorf("calls served by the pod should be ignored, got %+v", done)
+	}
+	packet(tr, 4, true, boltRequest(false, typeRequest, 7, sofaRequest, h, ""))
+	tr.expire(requestTimeout + 5)
+	if len(tr.conns) != 0 {
+		t.Errorf("requests without response should be dropped")
+	}
+}
+
+func TestTruncatedHeader(t *testing.T) {
+	h := header(headTargetService, "com.example.OrderService:1.0", headMethodName, "create")
+	b := boltRequest(false, typeRequest, 1, sofaRequest, h, "")
+	commands := parseCommands(b[:len(b)-3])
+	if len(commands) != 1 || commands[0].header[headMethodName] != "" || commands[0].header[headTargetService] == "" {
+		t.Errorf("the entries before the truncation should be kept, got %+v", commands)
+	}
+	if commands := parseCommands([]byte{protocolV1, 9, 0, 1}); len(commands) != 0 {
+		t.Errorf("unexpected commands %+v", commands)
+	}
+}
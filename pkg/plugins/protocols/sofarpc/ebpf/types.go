@@ -0,0 +1,62 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	BoltPayloadSize = 512
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type BoltEvent struct {
+	socketfilter.Header
+	Payload [BoltPayloadSize]byte
+}
+
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	// Protocol is the bolt protocol version, 1 or 2.
+	Protocol int
+	Codec    string
+	Oneway   bool
+	// Class is the class of the request content, e.g. com.alipay.sofa.rpc.core.request.SofaRequest.
+	Class string
+	// Service is the interface of the target service, Version and UniqueID distinguish its implementations.
+	Service   string
+	Version   string
+	UniqueID  string
+	Method    string
+	TargetApp string
+
+	// Status is the bolt response status, Response its name.
+	Status   int
+	Response string
+	Error    bool
+
+	// Duration is not set for oneway calls.
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s sofarpc [%s:%d] --> [%s:%d][%s.%s] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.Service, m.Method,
+		m.Response, time.Duration(m.Duration).String(),
+	)
+}
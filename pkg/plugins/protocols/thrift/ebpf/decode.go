@@ -0,0 +1,248 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+// see https://github.com/apache/thrift/blob/master/doc/specs/thrift-binary-protocol.md
+// and https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+const (
+	binaryVersion1    = 0x80
+	binaryVersion2    = 0x01
+	compactProtocolID = 0x82
+	compactVersion    = 1
+
+	typeStop = 0
+	// field types of the binary protocol
+	binaryTypeI32    = 8
+	binaryTypeString = 11
+	// field types of the compact protocol, in the lower 4 bits of the field header
+	compactTypeI32    = 5
+	compactTypeBinary = 8
+
+	// fieldSuccess is the return value in the result struct, declared exceptions have the ids of the IDL.
+	fieldSuccess = 0
+	// fields of TApplicationException
+	fieldExceptionMessage = 1
+	fieldExceptionType    = 2
+)
+
+type message struct {
+	protocol Protocol
+	framed   bool
+	typ      MessageType
+	name     string
+	seqID    int32
+	// body is the struct following the header, truncated to the capture size.
+	body []byte
+}
+
+func decodeMetric(key *EventKey, data *ThriftEvent) *Metric {
+	request := data.Request[:min(int(data.RequestLen), len(data.Request))]
+	response := data.Response[:min(int(data.ResponseLen), len(data.Response))]
+	call, ok := parseMessage(request)
+	if !ok || (call.typ != MessageCall && call.typ != MessageOneway) {
+		return nil
+	}
+	m := &Metric{
+		SourceIP:     net.IP(key.Conn.SourceIP[:]).String(),
+		SourcePort:   key.Conn.SourcePort,
+		DestIP:       net.IP(key.Conn.DestIP[:]).String(),
+		DestPort:     key.Conn.DestPort,
+		Protocol:     call.protocol,
+		Framed:       call.framed,
+		Method:       call.name,
+		MessageType:  call.typ,
+		SeqID:        call.seqID,
+		Duration:     data.Duration,
+		SocketCookie: data.Cookie,
+	}
+	if service, method, ok := strings.Cut(call.name, ":"); ok {
+		m.Service, m.Method = service, method
+	}
+	if call.typ == MessageOneway {
+		return m
+	}
+
+	reply, ok := parseMessage(response)
+	// the reply of another call of an asynchronous client, the multiplexed processors reply without the service prefix.
+	if !ok || reply.protocol != call.protocol || reply.seqID != call.seqID ||
+		(reply.name != call.name && reply.name != m.Method) {
+		return nil
+	}
+	switch reply.typ {
+	case MessageReply:
+		if id, ok := firstField(&reply); ok && id != fieldSuccess {
+			m.Exception = ExceptionUser
+			m.ExceptionFieldID = id
+		}
+	case MessageException:
+		m.Exception = ExceptionApplication
+		decodeApplicationException(m, &reply)
+	default:
+		return nil
+	}
+	return m
+}
+
+// parseMessage parses the message header of the strict binary or the compact protocol,
+// optionally prefixed by the frame size of the framed transport.
+func parseMessage(buf []byte) (message, bool) {
+	var msg message
+	if len(buf) >= 8 && buf[0] == 0 && (buf[4] == binaryVersion1 || buf[4] == compactProtocolID) {
+		msg.framed = true
+		buf = buf[4:]
+	}
+	if len(buf) < 2 {
+		return msg, false
+	}
+	r := &reader{buf: buf}
+	switch {
+	case buf[0] == binaryVersion1 && buf[1] == binaryVersion2:
+		msg.protocol = ProtocolBinary
+		msg.typ = MessageType(r.i32() & 0xff)
+		msg.name = r.binaryString()
+		msg.seqID = r.i32()
+	case buf[0] == compactProtocolID && buf[1]&0x1f == compactVersion:
+		msg.protocol = ProtocolCompact
+		r.byte()
+		msg.typ = MessageType(r.byte() >> 5)
+		msg.seqID = int32(r.varint())
+		msg.name = r.compactString()
+	default:
+		return msg, false
+	}
+	if r.err || msg.name == "" {
+		return msg, false
+	}
+	msg.body = r.buf
+	return msg, true
+}
+
+// firstField returns the id of the first field of the result struct, void methods return an empty struct.
+func firstField(msg *message) (int16, bool) {
+	r := &reader{buf: msg.body}
+	id, typ := r.fieldHeader(msg.protocol, 0)
+	if r.err {
+		return 0, false
+	}
+	if typ == typeStop {
+		return fieldSuccess, true
+	}
+	return id, true
+}
+
+// decodeApplicationException reads the message and the type of the TApplicationException, the message
+// may be truncated. Fields of other types can not be skipped without the struct definition, decoding stops at them.
+func decodeApplicationException(m *Metric, msg *message) {
+	r := &reader{buf: msg.body}
+	var id int16
+	for !r.err {
+		var typ uint8
+		id, typ = r.fieldHeader(msg.protocol, id)
+		if r.err || typ == typeStop {
+			return
+		}
+		switch {
+		case id == fieldExceptionMessage && msg.protocol == ProtocolBinary && typ == binaryTypeString:
+			m.ApplicationExceptionMessage = r.truncatedString(int(r.i32()))
+		case id == fieldExceptionMessage && msg.protocol == ProtocolCompact && typ == compactTypeBinary:
+			m.ApplicationExceptionMessage = r.truncatedString(int(r.varint()))
+		case id == fieldExceptionType && msg.protocol == ProtocolBinary && typ == binaryTypeI32:
+			m.ApplicationExceptionType = r.i32()
+		case id == fieldExceptionType && msg.protocol == ProtocolCompact && typ == compactTypeI32:
+			m.ApplicationExceptionType = zigzag(r.varint())
+		default:
+			return
+		}
+	}
+}
+
+// reader reads the primitives of both protocols, reads past the end return zero values and set err.
+type reader struct {
+	buf []byte
+	err bool
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err || n < 0 || len(r.buf) < n {
+		r.err = true
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) i16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) i32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) varint() uint64 {
+	if r.err {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = true
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) binaryString() string {
+	return string(r.next(int(r.i32())))
+}
+
+func (r *reader) compactString() string {
+	return string(r.next(int(r.varint())))
+}
+
+func (r *reader) truncatedString(n int) string {
+	if r.err || n < 0 {
+		r.err = true
+		return ""
+	}
+	return string(r.next(min(n, len(r.buf))))
+}
+
+// fieldHeader reads the header of the next field, last is the id of the previous field
+// the compact protocol encodes the ids as deltas to.
+func (r *reader) fieldHeader(protocol Protocol, last int16) (int16, uint8) {
+	b := r.byte()
+	if r.err || b == typeStop {
+		return 0, typeStop
+	}
+	if protocol == ProtocolBinary {
+		return r.i16(), b
+	}
+	typ := b & 0x0f
+	if delta := int16(b >> 4); delta != 0 {
+		return last + delta, typ
+	}
+	return int16(zigzag(r.varint())), typ
+}
+
+func zigzag(v uint64) int32 {
+	return int32(v>>1) ^ -int32(v&1)
+}
@@ -0,0 +1,83 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func binaryMessage(typ MessageType, name string, seqID int32, body ...byte) []byte {
+	b := []byte{binaryVersion1, binaryVersion2, 0, byte(typ)}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(name)))
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint32(b, uint32(seqID))
+	return append(b, body...)
+}
+
+func compactMessage(typ MessageType, name string, seqID int32, body ...byte) []byte {
+	b := []byte{compactProtocolID, byte(typ)<<5 | compactVersion}
+	b = binary.AppendUvarint(b, uint64(uint32(seqID)))
+	b = binary.AppendUvarint(b, uint64(len(name)))
+	b = append(b, name...)
+	return append(b, body...)
+}
+
+func framed(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...)
+}
+
+func event(request, response []byte) *ThriftEvent {
+	var data ThriftEvent
+	data.RequestLen = uint16(copy(data.Request[:], request))
+	data.ResponseLen = uint16(copy(data.Response[:], response))
+	return &data
+}
+
+func TestDecodeBinary(t *testing.T) {
+	// success field of type string
+	reply := binaryMessage(MessageReply, "getUser", 7, binaryTypeString, 0, 0, 0, 0, 0, 2, 'o', 'k', typeStop)
+	m := decodeMetric(&EventKey{}, event(framed(binaryMessage(MessageCall, "UserService:getUser", 7)), framed(reply)))
+	if m == nil {
+		t.Fatal("expected metric")
+	}
+	if m.Service != "UserService" || m.Method != "getUser" || m.SeqID != 7 || !m.Framed ||
+		m.Protocol != ProtocolBinary || m.Exception != ExceptionNone {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	// declared exception in field 1 of the result struct
+	reply = binaryMessage(MessageReply, "getUser", 8, 12, 0, 1, typeStop, typeStop)
+	m = decodeMetric(&EventKey{}, event(binaryMessage(MessageCall, "getUser", 8), reply))
+	if m == nil || m.Exception != ExceptionUser || m.ExceptionFieldID != 1 || m.Framed {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	exception := []byte{binaryTypeString, 0, fieldExceptionMessage, 0, 0, 0, 4, 'o', 'o', 'p', 's', binaryTypeI32, 0, fieldExceptionType, 0, 0, 0, 1, typeStop}
+	m = decodeMetric(&EventKey{}, event(binaryMessage(MessageCall, "getUser", 9), binaryMessage(MessageException, "getUser", 9, exception...)))
+	if m == nil || m.Exception != ExceptionApplication || m.ApplicationExceptionType != 1 || m.ApplicationExceptionMessage != "oops" {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	// the reply of another call
+	if m := decodeMetric(&EventKey{}, event(binaryMessage(MessageCall, "getUser", 10), binaryMessage(MessageReply, "getUser", 11, typeStop))); m != nil {
+		t.Errorf("replies of other calls should be ignored, got %+v", m)
+	}
+}
+
+func TestDecodeCompact(t *testing.T) {
+	m := decodeMetric(&EventKey{}, event(compactMessage(MessageCall, "ping", 300), compactMessage(MessageReply, "ping", 300, typeStop)))
+	if m == nil || m.Protocol != ProtocolCompact || m.Method != "ping" || m.SeqID != 300 || m.Exception != ExceptionNone {
+		t.Fatalf("unexpected metric %+v", m)
+	}
+
+	// type then message, the id of the type is a delta, the id of the message is zigzag encoded in the long form
+	exception := []byte{0x20 | compactTypeI32, 12, compactTypeBinary, 2, 3, 'b', 'a', 'd', typeStop}
+	m = decodeMetric(&EventKey{}, event(compactMessage(MessageCall, "ping", 1), compactMessage(MessageException, "ping", 1, exception...)))
+	if m == nil || m.ApplicationExceptionType != 6 || m.ApplicationExceptionMessage != "bad" {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	m = decodeMetric(&EventKey{}, event(compactMessage(MessageOneway, "log", 2), nil))
+	if m == nil || m.MessageType != MessageOneway || m.Duration != 0 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+}
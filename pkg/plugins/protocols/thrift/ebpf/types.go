@@ -0,0 +1,132 @@
+package ebpf
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ThriftRequestSize  = 128
+	ThriftResponseSize = 128
+)
+
+type ConnKey struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+type EventKey struct {
+	Conn ConnKey
+	// the C struct aligns request_ts to 8 bytes
+	_                uint32
+	RequestTimestamp uint64
+}
+
+type ThriftEvent struct {
+	RequestTimestamp uint64
+	Duration         uint64
+	Cookie           uint64
+	RequestLen       uint16
+	ResponseLen      uint16
+	_                uint32
+	Request          [ThriftRequestSize]byte
+	Response         [ThriftResponseSize]byte
+}
+
+type Protocol int
+
+const (
+	ProtocolBinary Protocol = iota
+	ProtocolCompact
+)
+
+func (p Protocol) String() string {
+	if p == ProtocolCompact {
+		return "compact"
+	}
+	return "binary"
+}
+
+// MessageType is the type of the thrift message of the call.
+type MessageType uint8
+
+const (
+	MessageCall      MessageType = 1
+	MessageReply     MessageType = 2
+	MessageException MessageType = 3
+	MessageOneway    MessageType = 4
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MessageCall:
+		return "call"
+	case MessageReply:
+		return "reply"
+	case MessageException:
+		return "exception"
+	case MessageOneway:
+		return "oneway"
+	default:
+		return "unknown"
+	}
+}
+
+// Exception is how the call failed.
+type Exception int
+
+const (
+	ExceptionNone Exception = iota
+	// ExceptionApplication is a TApplicationException of the server, e.g. an unknown method or an internal error.
+	ExceptionApplication
+	// ExceptionUser is an exception declared by the method in the IDL, the result struct has a field other than success.
+	ExceptionUser
+)
+
+func (e Exception) String() string {
+	switch e {
+	case ExceptionApplication:
+		return "application"
+	case ExceptionUser:
+		return "user"
+	default:
+		return ""
+	}
+}
+
+type Metric struct {
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+
+	Protocol Protocol
+	Framed   bool
+	// Service is the prefix of the multiplexed protocol ("Service:method"), empty for plain clients.
+	Service     string
+	Method      string
+	MessageType MessageType
+	// SeqID is the sequence id of the call, the reply was matched against it.
+	SeqID int32
+
+	Exception Exception
+	// ApplicationExceptionType and ApplicationExceptionMessage are set for ExceptionApplication.
+	ApplicationExceptionType    int32
+	ApplicationExceptionMessage string
+	// ExceptionFieldID is the field of the declared exception in the result struct for ExceptionUser.
+	ExceptionFieldID int16
+
+	Duration uint64
+	// SocketCookie is the socket cookie of the client, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s thrift [%s:%d] --> [%s:%d][%s %s:%s %d] ====> %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.MessageType, m.Service, m.Method, m.SeqID,
+		m.Exception, time.Duration(m.Duration).String(),
+	)
+}
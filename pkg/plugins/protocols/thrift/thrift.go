@@ -0,0 +1,129 @@
+package thrift
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/thrift/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup = "application_rpc"
+)
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+	probes       *attach.Attacher
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("thrift", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+	go enrich.Run(p.enricher, p.kprobeHelper, "thrift", p.ch, p.convert, c)
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	target := m.Method
+	if m.Service != "" {
+		target = m.Service + "." + m.Method
+	}
+	transport := "buffered"
+	if m.Framed {
+		transport = "framed"
+	}
+	isError := m.Exception != ebpf.ExceptionNone
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"rpc_type":            "THRIFT",
+			"rpc_target":          target,
+			"rpc_service":         m.Service,
+			"rpc_method":          m.Method,
+			"thrift_protocol":     m.Protocol.String(),
+			"thrift_transport":    transport,
+			"thrift_message_type": m.MessageType.String(),
+			"error":               strconv.FormatBool(isError),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	// the sequence ids only pair the replies with their calls, they are not tagged.
+	switch m.Exception {
+	case ebpf.ExceptionApplication:
+		output.Tags["thrift_exception"] = m.Exception.String()
+		output.Tags["thrift_exception_message"] = m.ApplicationExceptionMessage
+		p.errorCodes.Tag(output.Tags, errorcodes.Thrift, strconv.Itoa(int(m.ApplicationExceptionType)))
+	case ebpf.ExceptionUser:
+		output.Tags["thrift_exception"] = m.Exception.String()
+		output.Tags["thrift_exception_field_id"] = strconv.Itoa(int(m.ExceptionFieldID))
+		p.errorCodes.Tag(output.Tags, errorcodes.Thrift, errorcodes.ThriftUserException)
+	}
+
+	inCluster := p.enricher.Enrich(output, "THRIFT", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		SocketCookie: m.SocketCookie,
+	})
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		// unresolved targets are kept with their surrogate identity
+		if !enrich.Surrogate(output) {
+			return nil
+		}
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("thrift", &servicehub.Spec{
+		Services:             []string{"thrift"},
+		Description:          "ebpf for apache thrift rpc",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,103 @@
+package tls
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tls/ebpf"
+)
+
+type certificateKey struct {
+	// host is the server name of the handshakes, the peer address without it.
+	host   string
+	issuer string
+	serial string
+}
+
+type certificateEntry struct {
+	tags       map[string]string
+	notAfter   time.Time
+	handshakes uint64
+	lastSeen   int64
+}
+
+// certificates collects the leaf certificates presented by the servers between two reports.
+type certificates struct {
+	sync.Mutex
+	entries map[certificateKey]*certificateEntry
+}
+
+func newCertificates() *certificates {
+	return &certificates{entries: make(map[certificateKey]*certificateEntry)}
+}
+
+// observe records the certificate of the enriched handshake output of c.
+func (s *certificates) observe(output *metric.Metric, c *ebpf.Certificate) {
+	host := c.ServerName
+	if host == "" {
+		host = output.Tags["peer_address"]
+	}
+	leaf := c.Leaf
+	s.Lock()
+	defer s.Unlock()
+	k := certificateKey{host: host, issuer: leaf.Issuer.String(), serial: leaf.SerialNumber.String()}
+	e, ok := s.entries[k]
+	if !ok {
+		tags := make(map[string]string)
+		for name, value := range output.Tags {
+			if strings.HasPrefix(name, "target_") {
+				tags[name] = value
+			}
+		}
+		for _, name := range serverTags {
+			if value, ok := output.Tags[name]; ok {
+				tags[name] = value
+			}
+		}
+		tags["tls_host"] = host
+		tags["tls_server_name"] = c.ServerName
+		tags["tls_certificate_subject"] = leaf.Subject.CommonName
+		tags["tls_certificate_issuer"] = leaf.Issuer.CommonName
+		tags["tls_certificate_serial"] = fmt.Sprintf("%x", leaf.SerialNumber)
+		tags["tls_certificate_not_after"] = leaf.NotAfter.UTC().Format(time.RFC3339)
+		e = &certificateEntry{tags: tags, notAfter: leaf.NotAfter}
+		s.entries[k] = e
+	}
+	e.handshakes++
+	e.lastSeen = output.Timestamp
+}
+
+// report returns the certificates seen since the previous report with their days until expiry at timestamp.
+func (s *certificates) report(timestamp int64) []*metric.Metric {
+	s.Lock()
+	defer s.Unlock()
+	ans := make([]*metric.Metric, 0, len(s.entries))
+	for _, e := range s.entries {
+		days := math.Floor(e.notAfter.Sub(time.Unix(0, timestamp)).Hours() / 24)
+		tags := make(map[string]string, len(e.tags)+1)
+		for name, value := range e.tags {
+			tags[name] = value
+		}
+		tags["tls_certificate_expired"] = strconv.FormatBool(days < 0)
+		ans = append(ans, &metric.Metric{
+			Name:        certificateMeasurement,
+			Measurement: certificateMeasurement,
+			Timestamp:   timestamp,
+			OrgName:     e.tags["org_name"],
+			Tags:        tags,
+			Fields: map[string]interface{}{
+				"days_until_expiry": int64(days),
+				"not_after":         e.notAfter.UnixNano(),
+				"handshakes":        e.handshakes,
+				"last_seen":         e.lastSeen,
+			},
+		})
+	}
+	s.entries = make(map[certificateKey]*certificateEntry)
+	return ans
+}
@@ -0,0 +1,66 @@
+package tls
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tls/ebpf"
+)
+
+func TestCertificates(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	certs := newCertificates()
+	handshake := func(ts int64, serverName string, serial int64, notAfter time.Time) {
+		output := &metric.Metric{
+			Timestamp: ts,
+			Tags: map[string]string{
+				"peer_address":        "10.0.0.2:443",
+				"target_service_name": "api",
+				"source_service_name": "web",
+			},
+		}
+		certs.observe(output, &ebpf.Certificate{
+			Metric: ebpf.Metric{ServerName: serverName},
+			Leaf: &x509.Certificate{
+				SerialNumber: big.NewInt(serial),
+				Subject:      pkix.Name{CommonName: "api.internal"},
+				Issuer:       pkix.Name{CommonName: "internal-ca"},
+				NotAfter:     notAfter,
+			},
+		})
+	}
+	handshake(1, "api.internal", 1, now.Add(36*time.Hour))
+	handshake(2, "api.internal", 1, now.Add(36*time.Hour))
+	handshake(3, "", 2, now.Add(-time.Hour))
+
+	report := certs.report(now.UnixNano())
+	if len(report) != 2 {
+		t.Fatalf("expected two certificates, got %d", len(report))
+	}
+	for _, m := range report {
+		if m.Measurement != certificateMeasurement || m.Tags["target_service_name"] != "api" || m.Tags["source_service_name"] != "" {
+			t.Errorf("unexpected record %+v", m)
+		}
+		switch m.Tags["tls_host"] {
+		case "api.internal":
+			if m.Fields["days_until_expiry"] != int64(1) || m.Fields["handshakes"] != uint64(2) ||
+				m.Tags["tls_certificate_expired"] != "false" || m.Tags["tls_certificate_issuer"] != "internal-ca" {
+				t.Errorf("unexpected record %+v", m)
+			}
+		case "10.0.0.2:443":
+			// the server name was not captured
+			if m.Fields["days_until_expiry"] != int64(-1) || m.Tags["tls_certificate_expired"] != "true" {
+				t.Errorf("unexpected record %+v", m)
+			}
+		default:
+			t.Errorf("unexpected record %+v", m)
+		}
+	}
+	if report := certs.report(now.UnixNano()); len(report) != 0 {
+		t.Errorf("certificates are only reported for the interval they were seen in, got %d", len(report))
+	}
+}
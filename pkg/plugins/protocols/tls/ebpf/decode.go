@@ -0,0 +1,228 @@
+package ebpf
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// see RFC 8446 and RFC 5246
+const (
+	recordHeaderSize    = 5
+	handshakeHeaderSize = 4
+	randomSize          = 32
+
+	handshakeClientHello = 1
+	handshakeServerHello = 2
+	handshakeCertificate = 11
+
+	extensionServerName        = 0x0000
+	extensionSupportedVersions = 0x002b
+
+	VersionSSL30 = 0x0300
+	VersionTLS10 = 0x0301
+	VersionTLS11 = 0x0302
+	VersionTLS12 = 0x0303
+	VersionTLS13 = 0x0304
+)
+
+// helloRetryRequest is the random of server hellos asking the client for another key share, see RFC 8446 4.1.3.
+var helloRetryRequest = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+var versionNames = map[uint16]string{
+	VersionSSL30: "SSL 3.0",
+	VersionTLS10: "TLS 1.0",
+	VersionTLS11: "TLS 1.1",
+	VersionTLS12: "TLS 1.2",
+	VersionTLS13: "TLS 1.3",
+}
+
+func VersionName(v uint16) string {
+	if name, ok := versionNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// DeprecatedVersion reports whether the version is below TLS 1.2, see RFC 8996.
+func DeprecatedVersion(v uint16) bool {
+	return v < VersionTLS12
+}
+
+// legacyCiphers are the names of legacy suites crypto/tls does not implement.
+var legacyCiphers = map[uint16]string{
+	0x0001: "TLS_RSA_WITH_NULL_MD5",
+	0x0002: "TLS_RSA_WITH_NULL_SHA",
+	0x0003: "TLS_RSA_EXPORT_WITH_RC4_40_MD5",
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0006: "TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5",
+	0x0008: "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0009: "TLS_RSA_WITH_DES_CBC_SHA",
+	0x0014: "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0015: "TLS_DHE_RSA_WITH_DES_CBC_SHA",
+	0x0016: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0018: "TLS_DH_anon_WITH_RC4_128_MD5",
+	0x001b: "TLS_DH_anon_WITH_3DES_EDE_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0034: "TLS_DH_anon_WITH_AES_128_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003a: "TLS_DH_anon_WITH_AES_256_CBC_SHA",
+	0x003b: "TLS_RSA_WITH_NULL_SHA256",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x006b: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0x009e: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009f: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+}
+
+// CipherName returns the IANA name of the cipher suite, its hex code if unknown.
+func CipherName(id uint16) string {
+	if name, ok := legacyCiphers[id]; ok {
+		return name
+	}
+	return tls.CipherSuiteName(id)
+}
+
+// weakCipherParts are the parts of the names of broken or export grade suites.
+var weakCipherParts = []string{"_NULL_", "_EXPORT", "_anon_", "_RC4_", "_RC2_", "_DES_", "_DES40_", "_3DES_", "_MD5"}
+
+// WeakCipher reports whether the suite is insecure for crypto/tls or belongs to a broken family.
+// Suites of unknown names are not judged.
+func WeakCipher(id uint16) bool {
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.ID == id {
+			return true
+		}
+	}
+	name := CipherName(id)
+	for _, part := range weakCipherParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+type hello struct {
+	typ        byte
+	version    uint16
+	cipher     uint16
+	serverName string
+	// retry is set on hello retry requests, the server hello follows.
+	retry bool
+}
+
+// reader reads the fields of a hello, the fields after the truncation read as zero and fail ok.
+type reader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *reader) next(n int) []byte {
+	if !r.ok || n < 0 || len(r.b) < n {
+		r.ok = false
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) u8() int {
+	if b := r.next(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *reader) u16() int {
+	if b := r.next(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+// parseHello parses the hello starting the payload, server hellos fail when they are cut off.
+// The server name of client hellos is only set when its extension was captured.
+func parseHello(buf []byte) (hello, bool) {
+	var h hello
+	if len(buf) < recordHeaderSize+handshakeHeaderSize {
+		return h, false
+	}
+	h.typ = buf[recordHeaderSize]
+	r := &reader{b: buf[recordHeaderSize+handshakeHeaderSize:], ok: true}
+	h.version = uint16(r.u16())
+	random := r.next(randomSize)
+	r.next(r.u8())
+	switch h.typ {
+	case handshakeClientHello:
+		r.next(r.u16())
+		r.next(r.u8())
+	case handshakeServerHello:
+		h.cipher = uint16(r.u16())
+		r.u8()
+		if !r.ok {
+			return h, false
+		}
+		h.retry = bytes.Equal(random, helloRetryRequest)
+	default:
+		return h, false
+	}
+	if !r.ok {
+		return h, h.typ == handshakeClientHello
+	}
+	extLen := r.u16()
+	// the selected version of TLS 1.3 is an extension, server hellos are short enough to be captured whole.
+	if extLen > len(r.b) && h.typ == handshakeServerHello {
+		return h, false
+	}
+	exts := &reader{b: r.next(min(extLen, len(r.b))), ok: true}
+	for exts.ok && len(exts.b) > 0 {
+		typ := exts.u16()
+		data := &reader{b: exts.next(exts.u16()), ok: exts.ok}
+		switch {
+		case typ == extensionSupportedVersions && h.typ == handshakeServerHello:
+			if v := data.u16(); data.ok {
+				h.version = uint16(v)
+			}
+		case typ == extensionServerName && h.typ == handshakeClientHello:
+			// server name list: list length, name type (0 host name), name
+			data.u16()
+			if data.u8() == 0 {
+				if name := data.next(data.u16()); data.ok {
+					h.serverName = string(name)
+				}
+			}
+		}
+	}
+	return h, true
+}
+
+func (r *reader) u24() int {
+	if b := r.next(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// parseLeaf parses the first certificate of a certificate message, see RFC 5246 7.4.2.
+// complete is false while the message is too short to hold it.
+func parseLeaf(msg []byte) (leaf *x509.Certificate, complete bool, err error) {
+	r := &reader{b: msg, ok: true}
+	if typ := r.u8(); r.ok && typ != handshakeCertificate {
+		return nil, true, fmt.Errorf("unexpected handshake type %d", typ)
+	}
+	r.u24()
+	r.u24()
+	der := r.next(r.u24())
+	if !r.ok {
+		return nil, false, nil
+	}
+	leaf, err = x509.ParseCertificate(der)
+	return leaf, true, err
+}
@@ -0,0 +1,201 @@
+package ebpf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func extension(typ uint16, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+func record(typ byte, body []byte) []byte {
+	b := []byte{0x16, 0x03, 0x01}
+	b = binary.BigEndian.AppendUint16(b, uint16(handshakeHeaderSize+len(body)))
+	b = append(b, typ, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func clientHello(serverName string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, VersionTLS12)
+	b = append(b, make([]byte, randomSize)...)
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = append(b, 0x13, 0x01, 0xc0, 0x2f)
+	b = append(b, 1, 0)
+	name := binary.BigEndian.AppendUint16(nil, uint16(3+len(serverName)))
+	name = append(name, 0)
+	name = binary.BigEndian.AppendUint16(name, uint16(len(serverName)))
+	name = append(name, serverName...)
+	exts := extension(0x000a, []byte{0, 2, 0, 0x1d})
+	exts = append(exts, extension(extensionServerName, name)...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(exts)))
+	return record(handshakeClientHello, append(b, exts...))
+}
+
+func serverHello(version, cipher uint16, random []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, min(version, VersionTLS12))
+	if random == nil {
+		random = make([]byte, randomSize)
+	}
+	b = append(b, random...)
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, cipher)
+	b = append(b, 0)
+	var exts []byte
+	if version == VersionTLS13 {
+		exts = extension(extensionSupportedVersions, binary.BigEndian.AppendUint16(nil, VersionTLS13))
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(exts)))
+	return record(handshakeServerHello, append(b, exts...))
+}
+
+var (
+	client = ConnKey{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 40000, DestPort: 443}
+	server = ConnKey{SourceIP: client.DestIP, DestIP: client.SourceIP, SourcePort: client.DestPort, DestPort: client.SourcePort}
+)
+
+func packet(t *tracker, ts uint64, conn ConnKey, fromPod bool, payload []byte) *Metric {
+	key := EventKey{Conn: conn, Timestamp: ts}
+	ev := TLSEvent{}
+	if fromPod {
+		ev.FromPod = 1
+	}
+	ev.PayloadLen = uint16(copy(ev.Payload[:], payload))
+	return t.handle(&key, &ev)
+}
+
+func TestHandshake(t *testing.T) {
+	tr := newTracker()
+	if m := packet(tr, 10, client, false, clientHello("api.example.com")); m != nil {
+		t.Fatalf("client hellos complete nothing, got %+v", m)
+	}
+	// a hello retry request, the client hello is sent again
+	if m := packet(tr, 15, server, true, serverHello(VersionTLS13, 0x1301, helloRetryRequest)); m != nil {
+		t.Fatalf("hello retry requests complete nothing, got %+v", m)
+	}
+	packet(tr, 20, client, false, clientHello("api.example.com"))
+	m := packet(tr, 30, server, true, serverHello(VersionTLS13, 0x1301, nil))
+	if m == nil || m.Version != VersionTLS13 || CipherName(m.Cipher) != "TLS_AES_128_GCM_SHA256" ||
+		m.ServerName != "api.example.com" || m.Duration != 10 || !m.ServerIsPod || m.DestPort != 443 {
+		t.Fatalf("unexpected handshake %+v", m)
+	}
+	if len(tr.hellos) != 0 {
+		t.Errorf("completed handshakes should be removed")
+	}
+
+	// the client hello was not captured
+	m = packet(tr, 40, server, false, serverHello(VersionTLS10, 0x000a, nil))
+	if m == nil || VersionName(m.Version) != "TLS 1.0" || m.ServerName != "" || m.Duration != 0 || m.ServerIsPod {
+		t.Fatalf("unexpected handshake %+v", m)
+	}
+
+	packet(tr, 50, client, true, clientHello("db.example.com"))
+	tr.expire(helloTimeout + 51)
+	if len(tr.hellos) != 0 {
+		t.Errorf("client hellos without server hello should be dropped")
+	}
+}
+
+func certificateMessage(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "api.example.com"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u24 := func(n int) []byte { return []byte{byte(n >> 16), byte(n >> 8), byte(n)} }
+	b := append([]byte{handshakeCertificate}, u24(3+3+len(der))...)
+	b = append(b, u24(3+len(der))...)
+	b = append(b, u24(len(der))...)
+	return append(b, der...)
+}
+
+func chunk(tr *tracker, conn ConnKey, startSeq uint32, msg []byte, from, to int) *Certificate {
+	c := CertChunk{StartSeq: startSeq, Seq: startSeq + uint32(from), Total: uint32(len(msg))}
+	c.PayloadLen = uint16(copy(c.Payload[:], msg[from:to]))
+	return tr.handleCert(&EventKey{Conn: conn}, &c)
+}
+
+func TestCertificate(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	msg := certificateMessage(t, notAfter)
+	tr := newTracker()
+	packet(tr, 10, client, false, clientHello("api.example.com"))
+	packet(tr, 20, server, true, serverHello(VersionTLS12, 0xc02f, nil))
+	// the sequence numbers wrap, the chunks arrive out of order and retransmitted
+	const startSeq = 0xffffff00
+	if c := chunk(tr, server, startSeq, msg, 100, len(msg)); c != nil {
+		t.Fatalf("the leaf is not complete without its start")
+	}
+	if c := chunk(tr, server, startSeq, msg, 100, len(msg)); c != nil {
+		t.Fatalf("the leaf is not complete without its start")
+	}
+	c := chunk(tr, server, startSeq, msg, 0, 120)
+	if c == nil || c.Leaf.Subject.CommonName != "api.example.com" || !c.Leaf.NotAfter.Equal(notAfter) ||
+		c.ServerName != "api.example.com" || c.DestPort != 443 {
+		t.Fatalf("unexpected certificate %+v", c)
+	}
+	if len(tr.certs) != 0 {
+		t.Errorf("captured certificates should be removed")
+	}
+
+	// the certificate of TLS 1.3 is encrypted
+	packet(tr, 30, server, true, serverHello(VersionTLS13, 0x1301, nil))
+	if len(tr.certs) != 0 {
+		t.Errorf("tls 1.3 certificates cannot be captured")
+	}
+	// a resumed session has no certificate
+	packet(tr, 40, server, true, serverHello(VersionTLS12, 0xc02f, nil))
+	tr.expire(helloTimeout + 41)
+	if len(tr.certs) != 0 {
+		t.Errorf("certificates not captured should be dropped")
+	}
+}
+
+func TestTruncatedHello(t *testing.T) {
+	b := clientHello("api.example.com")
+	if h, ok := parseHello(b[:len(b)-5]); !ok || h.serverName != "" {
+		t.Errorf("truncated client hellos are kept without server name, got %+v %v", h, ok)
+	}
+	b = serverHello(VersionTLS13, 0x1301, nil)
+	if _, ok := parseHello(b[:len(b)-2]); ok {
+		t.Errorf("truncated server hellos should fail")
+	}
+}
+
+func TestWeakCipher(t *testing.T) {
+	cases := map[uint16]bool{
+		0x1301: false, // TLS_AES_128_GCM_SHA256
+		0xc02f: false, // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+		0x009e: false, // TLS_DHE_RSA_WITH_AES_128_GCM_SHA256
+		0x000a: true,  // TLS_RSA_WITH_3DES_EDE_CBC_SHA
+		0x0005: true,  // TLS_RSA_WITH_RC4_128_SHA
+		0x0003: true,  // TLS_RSA_EXPORT_WITH_RC4_40_MD5
+		0x0034: true,  // TLS_DH_anon_WITH_AES_128_CBC_SHA
+		0xfefe: false,
+	}
+	for id, want := range cases {
+		if got := WeakCipher(id); got != want {
+			t.Errorf("%s: got %v, want %v", CipherName(id), got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ebpf
+
+import (
+	"github.com/cilium/ebpf"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	protocol    = "tls"
+	programPath = "target/tls.bpf.o"
+	programName = "socket__tls_filter"
+	mapMetric   = "metrics_map"
+	mapCert     = "cert_map"
+)
+
+type Interface interface {
+	Load() error
+	Close() error
+}
+
+type provider struct {
+	link  attach.Link
+	ch    chan Metric
+	certs chan Certificate
+
+	filter *socketfilter.Filter
+}
+
+func New(l attach.Link, ch chan Metric, certs chan Certificate) Interface {
+	return &provider{
+		link:  l,
+		ch:    ch,
+		certs: certs,
+	}
+}
+
+func (e *provider) Load() error {
+	var err error
+	e.filter, err = socketfilter.Load(programPath, programName, e.link, func(c *ebpf.Collection) error {
+		// the handshakes are parsed on 443 as well, only the ports excluded for tls are skipped.
+		return exclusion.ApplyProtocol(c, protocol)
+	})
+	if err != nil {
+		return err
+	}
+	t := newTracker()
+	certMap := e.filter.Collection.DetachMap(mapCert)
+	go socketfilter.Drain(e.filter, e.filter.Collection.DetachMap(mapMetric), func(key *EventKey, val *TLSEvent) {
+		if metric := t.handle(key, val); metric != nil {
+			e.ch <- *metric
+		}
+	}, func(now uint64) {
+		// the certificates follow the server hellos handled above
+		socketfilter.Read(certMap, func(key *EventKey, chunk *CertChunk) {
+			if cert := t.handleCert(key, chunk); cert != nil {
+				e.certs <- *cert
+			}
+		})
+		t.expire(now)
+	})
+	return nil
+}
+
+func (e *provider) Close() error {
+	return e.filter.Close()
+}
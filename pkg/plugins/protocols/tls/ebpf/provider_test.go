@@ -0,0 +1,18 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/exclusion"
+)
+
+// TestExcludedPorts checks the handshakes on the standard HTTPS port stay parsed when the global excluded
+// ports list it, and the ports excluded for tls are still skipped.
+func TestExcludedPorts(t *testing.T) {
+	t.Setenv("L7_EXCLUDED_PORTS", "[443]")
+	t.Setenv("L7_PROTOCOL_EXCLUDED_PORTS", `{"tls":[9443]}`)
+	ports := exclusion.ProtocolPorts(protocol)
+	if len(ports) != 1 || ports[0] != 9443 {
+		t.Errorf("expected the ports excluded for tls only, got %v", ports)
+	}
+}
@@ -0,0 +1,152 @@
+package ebpf
+
+import (
+	"net"
+)
+
+const (
+	// helloTimeout drops client hellos without server hello.
+	helloTimeout = uint64(10e9)
+	// maxPending bounds the memory of the client hellos whose server hellos are not captured.
+	maxPending = 16384
+)
+
+type pending struct {
+	serverName string
+	ts         uint64
+	cookie     uint64
+}
+
+// certificate is the certificate message of a handshake being assembled.
+type certificate struct {
+	handshake Metric
+	ts        uint64
+	startSeq  uint32
+	// segments are the captured bytes of the message by their offset, retransmissions included.
+	segments map[uint32][]byte
+}
+
+// message returns the bytes of the message captured without gap from its start.
+func (c *certificate) message() []byte {
+	var msg []byte
+	for grown := true; grown; {
+		grown = false
+		for offset, b := range c.segments {
+			end := offset + uint32(len(b))
+			if offset <= uint32(len(msg)) && end > uint32(len(msg)) {
+				msg = append(msg, b[uint32(len(msg))-offset:]...)
+				grown = true
+			}
+		}
+	}
+	return msg
+}
+
+// tracker pairs the client hellos with the server hellos of the connections. Connections are keyed
+// in the client -> server direction.
+type tracker struct {
+	hellos map[ConnKey]*pending
+	// certs are the certificates following the server hellos, keyed in the server -> client direction.
+	certs map[ConnKey]*certificate
+}
+
+func newTracker() *tracker {
+	return &tracker{hellos: make(map[ConnKey]*pending), certs: make(map[ConnKey]*certificate)}
+}
+
+// handle processes the hello of a packet, it returns the handshake completed by a server hello.
+func (t *tracker) handle(key *EventKey, ev *TLSEvent) *Metric {
+	h, ok := parseHello(ev.Payload[:min(int(ev.PayloadLen), len(ev.Payload))])
+	if !ok {
+		return nil
+	}
+	if h.typ == handshakeClientHello {
+		if _, ok := t.hellos[key.Conn]; ok || len(t.hellos) < maxPending {
+			t.hellos[key.Conn] = &pending{serverName: h.serverName, ts: key.Timestamp, cookie: ev.Cookie}
+		}
+		return nil
+	}
+	// the client answers hello retry requests with a new client hello
+	if h.retry {
+		return nil
+	}
+	connKey := ConnKey{
+		SourceIP:   key.Conn.DestIP,
+		DestIP:     key.Conn.SourceIP,
+		SourcePort: key.Conn.DestPort,
+		DestPort:   key.Conn.SourcePort,
+	}
+	m := &Metric{
+		SourceIP:    net.IP(connKey.SourceIP[:]).String(),
+		SourcePort:  connKey.SourcePort,
+		DestIP:      net.IP(connKey.DestIP[:]).String(),
+		DestPort:    connKey.DestPort,
+		ServerIsPod: ev.FromPod == 1,
+		Version:     h.version,
+		Cipher:      h.cipher,
+	}
+	// the pod end of the connection sends the server hello of server pods and the client hello otherwise.
+	if m.ServerIsPod {
+		m.SocketCookie = ev.Cookie
+	}
+	if p, ok := t.hellos[connKey]; ok {
+		delete(t.hellos, connKey)
+		m.ServerName = p.serverName
+		if !m.ServerIsPod {
+			m.SocketCookie = p.cookie
+		}
+		m.Captured = key.Timestamp
+		if key.Timestamp > p.ts {
+			m.Duration = key.Timestamp - p.ts
+		}
+	}
+	// the certificate of TLS 1.3 is encrypted
+	if m.Version < VersionTLS13 {
+		if _, ok := t.certs[key.Conn]; ok || len(t.certs) < maxPending {
+			t.certs[key.Conn] = &certificate{handshake: *m, ts: key.Timestamp}
+		}
+	}
+	return m
+}
+
+// handleCert assembles the chunk of a certificate message, it returns the certificate once its leaf was captured.
+func (t *tracker) handleCert(key *EventKey, chunk *CertChunk) *Certificate {
+	c, ok := t.certs[key.Conn]
+	if !ok {
+		return nil
+	}
+	if c.segments == nil {
+		c.startSeq = chunk.StartSeq
+		c.segments = make(map[uint32][]byte)
+	}
+	offset := chunk.Seq - chunk.StartSeq
+	if chunk.StartSeq != c.startSeq || offset >= chunk.Total {
+		return nil
+	}
+	n := min(int(chunk.PayloadLen), len(chunk.Payload), int(chunk.Total-offset))
+	c.segments[offset] = append([]byte(nil), chunk.Payload[:n]...)
+	leaf, complete, err := parseLeaf(c.message())
+	if !complete {
+		return nil
+	}
+	delete(t.certs, key.Conn)
+	if err != nil {
+		return nil
+	}
+	return &Certificate{Metric: c.handshake, Leaf: leaf}
+}
+
+// expire drops the client hellos without server hello and the certificates not captured whole within
+// helloTimeout at now (bpf_ktime_get_ns).
+func (t *tracker) expire(now uint64) {
+	for key, p := range t.hellos {
+		if now-p.ts > helloTimeout {
+			delete(t.hellos, key)
+		}
+	}
+	for key, c := range t.certs {
+		if now-c.ts > helloTimeout {
+			delete(t.certs, key)
+		}
+	}
+}
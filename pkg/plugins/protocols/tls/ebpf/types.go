@@ -0,0 +1,71 @@
+package ebpf
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/socketfilter"
+)
+
+const (
+	TLSPayloadSize = 512
+	CertChunkSize  = 1024
+)
+
+type (
+	ConnKey  = socketfilter.ConnKey
+	EventKey = socketfilter.EventKey
+)
+
+type TLSEvent struct {
+	socketfilter.Header
+	Payload [TLSPayloadSize]byte
+}
+
+// CertChunk is a segment of the certificate message of a server, up to the end of the leaf certificate.
+type CertChunk struct {
+	// StartSeq is the tcp sequence number of the message, Seq the one of the payload.
+	StartSeq   uint32
+	Seq        uint32
+	Total      uint32
+	PayloadLen uint16
+	_          uint16
+	Payload    [CertChunkSize]byte
+}
+
+// Metric is a completed handshake, the negotiated parameters are those of the server hello.
+type Metric struct {
+	// Source is the client, Dest the server.
+	SourceIP   string
+	SourcePort uint16
+	DestIP     string
+	DestPort   uint16
+	// ServerIsPod reports whether the server is the pod attached to the veth.
+	ServerIsPod bool
+
+	Version uint16
+	Cipher  uint16
+	// ServerName is the SNI of the client hello, empty when the client hello was not seen.
+	ServerName string
+
+	// Duration is the time from the client hello to the server hello.
+	Duration uint64
+	Captured uint64
+	// SocketCookie is the socket cookie of the pod end of the connection, 0 if it is not captured.
+	SocketCookie uint64
+}
+
+func (m *Metric) String() string {
+	return fmt.Sprintf("%s tls [%s:%d] --> [%s:%d][%s] ====> %s %s [%s]",
+		time.Now().Format("2006-01-02 15:04:05"),
+		m.SourceIP, m.SourcePort, m.DestIP, m.DestPort, m.ServerName,
+		VersionName(m.Version), CipherName(m.Cipher), time.Duration(m.Duration).String(),
+	)
+}
+
+// Certificate is the leaf certificate presented by the server of a handshake.
+type Certificate struct {
+	Metric
+	Leaf *x509.Certificate
+}
@@ -0,0 +1,93 @@
+package tls
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tls/ebpf"
+)
+
+// serverTags are the tags of a handshake describing the server, the target_* tags are kept as well.
+var serverTags = []string{
+	"metric_source", "_meta", "_metric_scope", "_metric_scope_id", "org_name", "cluster_name",
+	"host_ip", "peer_address", "peer_hostname", "peer_service",
+}
+
+type inventoryKey struct {
+	server  string
+	version uint16
+	cipher  uint16
+}
+
+type inventoryEntry struct {
+	tags       map[string]string
+	handshakes uint64
+	lastSeen   int64
+}
+
+// inventory collects the servers negotiating a deprecated version or a weak cipher between two reports.
+type inventory struct {
+	sync.Mutex
+	entries map[inventoryKey]*inventoryEntry
+}
+
+func newInventory() *inventory {
+	return &inventory{entries: make(map[inventoryKey]*inventoryEntry)}
+}
+
+// observe records the enriched handshake output of m if it is insecure.
+func (i *inventory) observe(output *metric.Metric, m *ebpf.Metric) {
+	deprecated, weak := ebpf.DeprecatedVersion(m.Version), ebpf.WeakCipher(m.Cipher)
+	if !deprecated && !weak {
+		return
+	}
+	i.Lock()
+	defer i.Unlock()
+	k := inventoryKey{server: output.Tags["peer_address"], version: m.Version, cipher: m.Cipher}
+	e, ok := i.entries[k]
+	if !ok {
+		tags := make(map[string]string)
+		for name, value := range output.Tags {
+			if strings.HasPrefix(name, "target_") {
+				tags[name] = value
+			}
+		}
+		for _, name := range serverTags {
+			if value, ok := output.Tags[name]; ok {
+				tags[name] = value
+			}
+		}
+		tags["tls_version"] = ebpf.VersionName(m.Version)
+		tags["tls_cipher"] = ebpf.CipherName(m.Cipher)
+		tags["tls_deprecated_version"] = strconv.FormatBool(deprecated)
+		tags["tls_weak_cipher"] = strconv.FormatBool(weak)
+		e = &inventoryEntry{tags: tags}
+		i.entries[k] = e
+	}
+	e.handshakes++
+	e.lastSeen = output.Timestamp
+}
+
+// report returns the insecure servers seen since the previous report.
+func (i *inventory) report(timestamp int64) []*metric.Metric {
+	i.Lock()
+	defer i.Unlock()
+	ans := make([]*metric.Metric, 0, len(i.entries))
+	for _, e := range i.entries {
+		ans = append(ans, &metric.Metric{
+			Name:        inventoryMeasurement,
+			Measurement: inventoryMeasurement,
+			Timestamp:   timestamp,
+			OrgName:     e.tags["org_name"],
+			Tags:        e.tags,
+			Fields: map[string]interface{}{
+				"handshakes": e.handshakes,
+				"last_seen":  e.lastSeen,
+			},
+		})
+	}
+	i.entries = make(map[inventoryKey]*inventoryEntry)
+	return ans
+}
@@ -0,0 +1,52 @@
+package tls
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tls/ebpf"
+)
+
+func TestInventory(t *testing.T) {
+	inv := newInventory()
+	handshake := func(ts int64, version, cipher uint16) {
+		output := &metric.Metric{
+			Timestamp: ts,
+			Tags: map[string]string{
+				"peer_address":        "10.0.0.2:443",
+				"target_service_name": "legacy-api",
+				"source_service_name": "web",
+			},
+		}
+		inv.observe(output, &ebpf.Metric{Version: version, Cipher: cipher})
+	}
+	handshake(1, ebpf.VersionTLS13, 0x1301)
+	handshake(2, ebpf.VersionTLS10, 0xc013)
+	handshake(3, ebpf.VersionTLS10, 0xc013)
+	handshake(4, ebpf.VersionTLS12, 0x000a)
+
+	report := inv.report(10)
+	if len(report) != 2 {
+		t.Fatalf("expected the tls 1.0 and 3des servers, got %d", len(report))
+	}
+	for _, m := range report {
+		if m.Measurement != inventoryMeasurement || m.Tags["target_service_name"] != "legacy-api" || m.Tags["source_service_name"] != "" {
+			t.Errorf("unexpected record %+v", m)
+		}
+		switch m.Tags["tls_version"] {
+		case "TLS 1.0":
+			if m.Fields["handshakes"] != uint64(2) || m.Fields["last_seen"] != int64(3) || m.Tags["tls_weak_cipher"] != "false" {
+				t.Errorf("unexpected record %+v", m)
+			}
+		case "TLS 1.2":
+			if m.Tags["tls_cipher"] != "TLS_RSA_WITH_3DES_EDE_CBC_SHA" || m.Tags["tls_weak_cipher"] != "true" {
+				t.Errorf("unexpected record %+v", m)
+			}
+		default:
+			t.Errorf("unexpected record %+v", m)
+		}
+	}
+	if report := inv.report(20); len(report) != 0 {
+		t.Errorf("servers are only reported for the interval they were seen in, got %d", len(report))
+	}
+}
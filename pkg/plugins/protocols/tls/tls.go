@@ -0,0 +1,180 @@
+package tls
+
+import (
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/tls/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+const (
+	measurementGroup       = "application_tls"
+	inventoryMeasurement   = "application_tls_inventory"
+	certificateMeasurement = "application_tls_certificate"
+)
+
+type Config struct {
+	// InventoryInterval is the interval of the reports of the servers negotiating insecure parameters.
+	InventoryInterval time.Duration `env:"TLS_INVENTORY_INTERVAL" default:"10m"`
+	// CertificateInterval is the interval of the reports of the certificates presented by the servers.
+	CertificateInterval time.Duration `env:"TLS_CERTIFICATE_INTERVAL" default:"10m"`
+}
+
+type provider struct {
+	Log          logs.Logger
+	ch           chan ebpf.Metric
+	certs        chan ebpf.Certificate
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	enricher     enrich.Interface
+	cfg          Config
+	inventory    *inventory
+	certificates *certificates
+	// probes know the ips of the pods of the attached veths.
+	probes *attach.Attacher
+	clock  clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.ch = make(chan ebpf.Metric, 100)
+	p.certs = make(chan ebpf.Certificate, 100)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.enricher = enrich.New(p.kprobeHelper, p.netNatHelper)
+	envconf.MustLoad(&p.cfg)
+	p.inventory = newInventory()
+	p.certificates = newCertificates()
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	probes, err := attach.Start("tls", p.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf.New(l, p.ch, p.certs)
+		return e, e.Load()
+	})
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	p.probes = probes
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				p.Log.Errorf("panic: %v", err)
+				p.Log.Errorf("stack: %s", string(debug.Stack()))
+			}
+		}()
+		startup, events := kprobe.WaitSyncedQueue(p.kprobeHelper, "tls", p.ch)
+		c <- startup
+		inventoryTicker := p.clock.NewTicker(p.cfg.InventoryInterval)
+		defer inventoryTicker.Stop()
+		certificateTicker := p.clock.NewTicker(p.cfg.CertificateInterval)
+		defer certificateTicker.Stop()
+		emit := func(m *metric.Metric) { c <- m }
+		flush := p.clock.NewTicker(enrich.FlushInterval)
+		defer flush.Stop()
+		for {
+			select {
+			case m := <-events:
+				p.enricher.Submit(func() *metric.Metric { return p.convert(&m) }, emit)
+			case cert := <-p.certs:
+				p.enricher.Submit(func() *metric.Metric { return p.convertCertificate(&cert) }, func(m *metric.Metric) {
+					p.certificates.observe(m, &cert)
+				})
+			case <-flush.C():
+				p.enricher.Flush()
+			case <-inventoryTicker.C():
+				for _, m := range p.inventory.report(p.clock.Now().UnixNano()) {
+					c <- m
+				}
+			case <-certificateTicker.C():
+				for _, m := range p.certificates.report(p.clock.Now().UnixNano()) {
+					c <- m
+				}
+			}
+		}
+	}()
+}
+
+func (p *provider) convert(m *ebpf.Metric) *metric.Metric {
+	// handshakes between two pods of the node are reported by the veth of the server
+	if !m.ServerIsPod && p.probes.Local(m.DestIP) {
+		return nil
+	}
+	output := &metric.Metric{
+		Name:        measurementGroup,
+		Measurement: measurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"tls_version":            ebpf.VersionName(m.Version),
+			"tls_cipher":             ebpf.CipherName(m.Cipher),
+			"tls_server_name":        m.ServerName,
+			"tls_deprecated_version": strconv.FormatBool(ebpf.DeprecatedVersion(m.Version)),
+			"tls_weak_cipher":        strconv.FormatBool(ebpf.WeakCipher(m.Cipher)),
+		},
+		Fields: enrich.Elapsed(m.Duration),
+	}
+	// servers outside the cluster are still reported, clients of legacy external services need migration as well.
+	inCluster := p.enricher.Enrich(output, "TLS", enrich.Endpoints{
+		SourceIP:     m.SourceIP,
+		SourcePort:   m.SourcePort,
+		DestIP:       m.DestIP,
+		DestPort:     m.DestPort,
+		Captured:     m.Captured,
+		SocketCookie: m.SocketCookie,
+	})
+	if !inCluster {
+		p.Log.Debugf("source: %s/%d, target(external): %s", m.SourceIP, m.SourcePort, output.Tags["peer_address"])
+		enrich.External(output, m.ServerName)
+	}
+	p.inventory.observe(output, m)
+	return output
+}
+
+// convertCertificate enriches the handshake of the certificate, the certificates are reported by interval.
+func (p *provider) convertCertificate(c *ebpf.Certificate) *metric.Metric {
+	if !c.ServerIsPod && p.probes.Local(c.DestIP) {
+		return nil
+	}
+	output := &metric.Metric{Timestamp: p.clock.Now().UnixNano()}
+	inCluster := p.enricher.Enrich(output, "TLS", enrich.Endpoints{
+		SourceIP:     c.SourceIP,
+		SourcePort:   c.SourcePort,
+		DestIP:       c.DestIP,
+		DestPort:     c.DestPort,
+		Captured:     c.Captured,
+		SocketCookie: c.SocketCookie,
+	})
+	if !inCluster {
+		enrich.External(output, c.ServerName)
+	}
+	return output
+}
+
+func (p *provider) Close() {
+	p.probes.Close()
+}
+
+func init() {
+	servicehub.Register("tls", &servicehub.Spec{
+		Services:             []string{"tls"},
+		Description:          "ebpf for tls handshakes",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
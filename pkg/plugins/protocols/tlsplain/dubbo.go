@@ -0,0 +1,111 @@
+package tlsplain
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+const (
+	dubboHeaderSize = 16
+
+	dubboFlagRequest = 0x80
+	dubboFlagEvent   = 0x20
+	// dubboHessian2 is the serialization id of hessian2, the only one the invocations are decoded of.
+	dubboHessian2 = 2
+)
+
+// dubboFrame is a dubbo 2 request or response.
+type dubboFrame struct {
+	request bool
+	// event frames are heartbeats, they are not calls.
+	event  bool
+	status uint8
+	id     uint64
+	// invocation of the requests: dubbo version, interface, version of the service and method
+	dubboVersion string
+	service      string
+	version      string
+	method       string
+}
+
+func isDubbo(data []byte) bool {
+	return len(data) >= dubboHeaderSize && data[0] == 0xda && data[1] == 0xbb
+}
+
+// parseDubbo parses the frames of data, the batched frames of a call included. The last frame may be truncated.
+func parseDubbo(data []byte) []dubboFrame {
+	var frames []dubboFrame
+	for isDubbo(data) {
+		flag := data[2]
+		f := dubboFrame{
+			request: flag&dubboFlagRequest != 0,
+			event:   flag&dubboFlagEvent != 0,
+			status:  data[3],
+			id:      binary.BigEndian.Uint64(data[4:12]),
+		}
+		size := int(binary.BigEndian.Uint32(data[12:16]))
+		body := data[dubboHeaderSize:]
+		if size < len(body) {
+			body = body[:size]
+		}
+		if f.request && !f.event && flag&0x1f == dubboHessian2 {
+			f.dubboVersion, f.service, f.version, f.method = decodeInvocation(body)
+		}
+		frames = append(frames, f)
+		if size < 0 || dubboHeaderSize+size >= len(data) {
+			break
+		}
+		data = data[dubboHeaderSize+size:]
+	}
+	return frames
+}
+
+// decodeInvocation reads the leading strings of a hessian2 invocation: dubbo version, path, version and method.
+func decodeInvocation(body []byte) (dubboVersion, service, version, method string) {
+	var fields [4]string
+	for i := range fields {
+		s, n, ok := hessianString(body)
+		if !ok {
+			break
+		}
+		fields[i] = s
+		body = body[n:]
+	}
+	return fields[0], fields[1], fields[2], fields[3]
+}
+
+// hessianString decodes a hessian2 string (or null) of a single chunk, it returns the bytes consumed.
+// The length of the strings is in characters.
+func hessianString(b []byte) (string, int, bool) {
+	if len(b) == 0 {
+		return "", 0, false
+	}
+	var chars, n int
+	switch tag := b[0]; {
+	case tag == 'N':
+		return "", 1, true
+	case tag <= 0x1f:
+		chars, n = int(tag), 1
+	case tag >= 0x30 && tag <= 0x33:
+		if len(b) < 2 {
+			return "", 0, false
+		}
+		chars, n = int(tag-0x30)<<8|int(b[1]), 2
+	case tag == 'S':
+		if len(b) < 3 {
+			return "", 0, false
+		}
+		chars, n = int(binary.BigEndian.Uint16(b[1:3])), 3
+	default:
+		return "", 0, false
+	}
+	start := n
+	for i := 0; i < chars; i++ {
+		if n >= len(b) {
+			return "", 0, false
+		}
+		_, size := utf8.DecodeRune(b[n:])
+		n += size
+	}
+	return string(b[start:n]), n, true
+}
@@ -0,0 +1,85 @@
+package tlsplain
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+var httpMethods = []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// httpHead is the head of an HTTP/1 request or response, as far as it was captured.
+type httpHead struct {
+	method  string
+	path    string
+	query   string
+	version string
+	status  uint16
+	// headers are keyed as sent, like the headers of the http plugin.
+	headers map[string]string
+	// body is the start of the body, if the head was captured whole.
+	body []byte
+}
+
+func isHTTPRequest(data []byte) bool {
+	for _, m := range httpMethods {
+		if len(data) > len(m) && string(data[:len(m)]) == m && data[len(m)] == ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTTPResponse(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("HTTP/1."))
+}
+
+// parseRequest parses the head of a request, e.g. GET /api/users?id=1 HTTP/1.1.
+func parseRequest(data []byte) (*httpHead, bool) {
+	line, h := parseHead(data)
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		return nil, false
+	}
+	h.method = parts[0]
+	h.path, h.query, _ = strings.Cut(parts[1], "?")
+	h.version = parts[2]
+	return h, true
+}
+
+// parseResponse parses the head of a response, e.g. HTTP/1.1 200 OK.
+func parseResponse(data []byte) (*httpHead, bool) {
+	line, h := parseHead(data)
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	status, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return nil, false
+	}
+	h.version = parts[0]
+	h.status = uint16(status)
+	return h, true
+}
+
+// parseHead splits the start line from the headers, the last header line is dropped if the head was truncated.
+func parseHead(data []byte) (string, *httpHead) {
+	h := &httpHead{headers: make(map[string]string)}
+	head, body, complete := bytes.Cut(data, []byte("\r\n\r\n"))
+	if complete {
+		h.body = body
+	}
+	lines := strings.Split(string(head), "\r\n")
+	if !complete && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	for _, l := range lines[1:] {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+		h.headers[k] = strings.TrimSpace(v)
+	}
+	return lines[0], h
+}
@@ -0,0 +1,133 @@
+package tlsplain
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Library is the TLS library the plaintext was captured from, TLS_LIBRARY_* of ebpf/plugins/tlsplain/main.c.
+// It is the budget probe id of the events of the library as well.
+type Library uint8
+
+const (
+	LibraryJSSE      Library = 1
+	LibraryBoringSSL Library = 2
+	LibraryNodeJS    Library = 3
+	LibraryKTLS      Library = 4
+)
+
+// probe is a uprobe of a library, or a kprobe of a kernel library, ret attaches it to the return of the
+// function. The optional probes are skipped for the versions of the library missing the symbol.
+type probe struct {
+	symbol   string
+	program  string
+	ret      bool
+	optional bool
+}
+
+// library describes where the probes of a TLS library are attached.
+type library struct {
+	id   Library
+	name string
+	// match reports whether a file mapped executable by a process is the library, process whether the
+	// executable of the process may map it, nil for all processes.
+	match   func(path string) bool
+	process func(exe string) bool
+	probes  []probe
+	// kernel is set for the libraries of the kernel, their probes are kprobes attached once the module of the
+	// library is loaded.
+	kernel bool
+}
+
+var libraries = []library{
+	{
+		// the java agent of tools/jsse-probe, JSSE itself encrypts in java.
+		id:   LibraryJSSE,
+		name: "jsse",
+		match: func(path string) bool {
+			return filepath.Base(path) == "libjsseprobe.so"
+		},
+		probes: []probe{{symbol: "erda_jsse_probe", program: "uprobe_erda_jsse_probe"}},
+	},
+	{
+		// BoringSSL linked statically by the envoy sidecars of the meshes, its symbols are kept in the binary.
+		id:   LibraryBoringSSL,
+		name: "boringssl",
+		match: func(path string) bool {
+			return filepath.Base(path) == "envoy"
+		},
+		probes: []probe{
+			{symbol: "SSL_write", program: "uprobe_ssl_write"},
+			{symbol: "SSL_write", program: "uretprobe_ssl_write", ret: true},
+			{symbol: "SSL_read", program: "uprobe_ssl_read"},
+			{symbol: "SSL_read", program: "uretprobe_ssl_read", ret: true},
+		},
+	},
+	{
+		// node links OpenSSL statically (1.1.1 up to node 16, 3.x since node 17), the builds of the
+		// distributions link the libssl of the system instead.
+		id:   LibraryNodeJS,
+		name: "nodejs",
+		match: func(path string) bool {
+			base := filepath.Base(path)
+			return isNode(base) || strings.HasPrefix(base, "libssl.so")
+		},
+		process: func(exe string) bool {
+			return isNode(filepath.Base(exe))
+		},
+		probes: []probe{
+			{symbol: "SSL_write", program: "uprobe_node_ssl_write"},
+			{symbol: "SSL_write", program: "uretprobe_node_ssl_write", ret: true},
+			{symbol: "SSL_read", program: "uprobe_node_ssl_read"},
+			{symbol: "SSL_read", program: "uretprobe_node_ssl_read", ret: true},
+			// OpenSSL 1.1.1 and later
+			{symbol: "SSL_write_ex", program: "uprobe_node_ssl_write_ex", optional: true},
+			{symbol: "SSL_write_ex", program: "uretprobe_node_ssl_write_ex", ret: true, optional: true},
+			{symbol: "SSL_read_ex", program: "uprobe_node_ssl_read_ex", optional: true},
+			{symbol: "SSL_read_ex", program: "uretprobe_node_ssl_read_ex", ret: true, optional: true},
+		},
+	},
+	{
+		// the sockets the applications handed the keys of the session to (setsockopt TLS_TX and TLS_RX) encrypt
+		// in the kernel, the probes only see these sockets.
+		id:     LibraryKTLS,
+		name:   "ktls",
+		kernel: true,
+		probes: []probe{
+			{symbol: "tls_sw_sendmsg", program: "kprobe_tls_sw_sendmsg"},
+			{symbol: "tls_sw_recvmsg", program: "kprobe_tls_sw_recvmsg"},
+			{symbol: "tls_sw_recvmsg", program: "kretprobe_tls_sw_recvmsg", ret: true},
+			// NIC offload, CONFIG_TLS_DEVICE
+			{symbol: "tls_device_sendmsg", program: "kprobe_tls_device_sendmsg", optional: true},
+		},
+	},
+}
+
+func isNode(base string) bool {
+	return base == "node" || base == "nodejs"
+}
+
+func findLibrary(id Library) (library, bool) {
+	for _, lib := range libraries {
+		if lib.id == id {
+			return lib, true
+		}
+	}
+	return library{}, false
+}
+
+func (l Library) String() string {
+	if lib, ok := findLibrary(l); ok {
+		return lib.name
+	}
+	return "unknown"
+}
+
+// budgetProbes maps the libraries to their event budget ids, e.g. EBPF_PROBE_EVENT_BUDGETS={"jsse":20000}.
+func budgetProbes() map[string]uint32 {
+	ans := make(map[string]uint32, len(libraries))
+	for _, lib := range libraries {
+		ans[lib.name] = uint32(lib.id)
+	}
+	return ans
+}
@@ -0,0 +1,167 @@
+package tlsplain
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/erda-project/erda-infra/base/logs"
+)
+
+// processSettle is how long a process is scanned again after it was first seen, runtimes load their
+// libraries after the start (e.g. the java agents).
+const processSettle = 2 * time.Minute
+
+// fileID identifies a file across the mount namespaces of the containers. The device is left out: the files of
+// an image layer shared by the containers (e.g. the envoy of the sidecars) are on a different overlay device in
+// each container, but the kernel attaches the uprobes to the file of the layer, once would be enough and twice
+// doubles the events.
+type fileID struct {
+	ino   uint64
+	size  int64
+	mtime int64
+}
+
+// scanner attaches the probes of the libraries to the files mapped by the processes of the node. The
+// uprobes are attached to the files, once per file whatever the processes mapping it.
+type scanner struct {
+	log       logs.Logger
+	procPath  string
+	libraries []library
+	programs  map[string]*ebpf.Program
+	// attached are the files the probes were attached to, or failed to.
+	attached map[fileID]bool
+	// processes are the processes scanned, by the time they were first seen.
+	processes map[int]time.Time
+	links     []link.Link
+}
+
+func newScanner(log logs.Logger, procPath string, libs []library, programs map[string]*ebpf.Program) *scanner {
+	return &scanner{
+		log:       log,
+		procPath:  procPath,
+		libraries: libs,
+		programs:  programs,
+		attached:  make(map[fileID]bool),
+		processes: make(map[int]time.Time),
+	}
+}
+
+func (s *scanner) scan(now time.Time) {
+	entries, err := os.ReadDir(s.procPath)
+	if err != nil {
+		s.log.Errorf("failed to list processes of %s: %v", s.procPath, err)
+		return
+	}
+	alive := make(map[int]bool, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		alive[pid] = true
+		first, ok := s.processes[pid]
+		if !ok {
+			first = now
+			s.processes[pid] = now
+		}
+		if now.Sub(first) > processSettle {
+			continue
+		}
+		s.scanProcess(pid)
+	}
+	for pid := range s.processes {
+		if !alive[pid] {
+			delete(s.processes, pid)
+		}
+	}
+}
+
+func (s *scanner) scanProcess(pid int) {
+	dir := filepath.Join(s.procPath, strconv.Itoa(pid))
+	f, err := os.Open(filepath.Join(dir, "maps"))
+	if err != nil {
+		return
+	}
+	files := parseMaps(f)
+	f.Close()
+	exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+	for _, file := range files {
+		for _, lib := range s.libraries {
+			if !lib.match(file) || (lib.process != nil && !lib.process(exe)) {
+				continue
+			}
+			// the file as seen from the mount namespace of the process
+			s.attach(lib, filepath.Join(dir, "root", file))
+		}
+	}
+}
+
+func (s *scanner) attach(lib library, path string) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return
+	}
+	id := fileID{ino: st.Ino, size: st.Size, mtime: st.Mtim.Nano()}
+	if s.attached[id] {
+		return
+	}
+	s.attached[id] = true
+	ex, err := link.OpenExecutable(path)
+	if err != nil {
+		s.log.Errorf("failed to open %s library %s: %v", lib.name, path, err)
+		return
+	}
+	for _, p := range lib.probes {
+		var l link.Link
+		if p.ret {
+			l, err = ex.Uretprobe(p.symbol, s.programs[p.program], nil)
+		} else {
+			l, err = ex.Uprobe(p.symbol, s.programs[p.program], nil)
+		}
+		if errors.Is(err, link.ErrNoSymbol) && p.optional {
+			continue
+		}
+		if err != nil {
+			s.log.Errorf("failed to attach %s probe %s to %s: %v", lib.name, p.symbol, path, err)
+			continue
+		}
+		s.links = append(s.links, l)
+	}
+	s.log.Infof("attached %s probes to %s", lib.name, path)
+}
+
+func (s *scanner) close() {
+	for _, l := range s.links {
+		l.Close()
+	}
+}
+
+// parseMaps returns the files mapped executable in a /proc/<pid>/maps, each once.
+func parseMaps(r io.Reader) []string {
+	var files []string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// address perms offset dev inode path
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 || !strings.Contains(fields[1], "x") {
+			continue
+		}
+		path := strings.Join(fields[5:], " ")
+		if !strings.HasPrefix(path, "/") || strings.HasSuffix(path, "(deleted)") || seen[path] {
+			continue
+		}
+		seen[path] = true
+		files = append(files, path)
+	}
+	return files
+}
@@ -0,0 +1,217 @@
+package tlsplain
+
+import "time"
+
+// the durations are compared with bpf_ktime_get_ns timestamps
+const (
+	// responseSettle is how long a response is read before it is complete, HTTP/1 responses have no end
+	// marker the agent can rely on with the heads only.
+	responseSettle = uint64(200 * time.Millisecond)
+	// streamIdle is how long an idle connection is tracked, its unanswered requests are dropped with it.
+	streamIdle = uint64(2 * time.Minute)
+	// maxPending bounds the requests waiting for their response on a connection.
+	maxPending = 64
+)
+
+type protocol uint8
+
+const (
+	protocolUnknown protocol = iota
+	protocolHTTP
+	protocolDubbo
+)
+
+// exchange is a request and its response on a connection, the timestamps are bpf_ktime_get_ns.
+type exchange struct {
+	protocol protocol
+	library  Library
+	pid      uint32
+	client   endpoint
+	server   endpoint
+	// served is set when the exchange was captured in the server, otherwise in the client.
+	served bool
+
+	request  *httpHead
+	response *httpHead
+	call     *dubboFrame
+	status   uint8
+
+	start         uint64
+	requestEnd    uint64
+	responseStart uint64
+	responseEnd   uint64
+}
+
+// streamKey identifies a connection of a process, by the id the library gives it or by its tuple.
+type streamKey struct {
+	pid    uint32
+	id     uint64
+	local  endpoint
+	remote endpoint
+}
+
+type stream struct {
+	pid      uint32
+	library  Library
+	protocol protocol
+	local    endpoint
+	remote   endpoint
+	// resolved is set once the tuple was taken from a read, the tuple of a write may be the one of another
+	// socket of the thread for the libraries that do not know their socket.
+	resolved bool
+	// requestDirection is the direction of the requests, the process serves them when it receives them.
+	requestDirection uint8
+
+	// pending are the http requests waiting for their response, in order.
+	pending []*exchange
+	// responding is the http response being read.
+	responding *exchange
+	// calls are the dubbo requests waiting for their response, by request id.
+	calls map[uint64]*exchange
+	last  uint64
+}
+
+// tracker pairs the plaintext of the connections into exchanges.
+type tracker struct {
+	streams map[streamKey]*stream
+	emit    func(*exchange)
+}
+
+func newTracker(emit func(*exchange)) *tracker {
+	return &tracker{
+		streams: make(map[streamKey]*stream),
+		emit:    emit,
+	}
+}
+
+func (t *tracker) add(e *dataEvent) {
+	key := streamKey{pid: e.Pid, id: e.ConnID}
+	if e.ConnID == 0 {
+		key.local, key.remote = e.local(), e.remote()
+	}
+	s, ok := t.streams[key]
+	if !ok {
+		s = &stream{pid: e.Pid, library: e.Library, calls: make(map[uint64]*exchange)}
+		t.streams[key] = s
+	}
+	if !s.resolved {
+		s.local, s.remote = e.local(), e.remote()
+		s.resolved = e.ConnID == 0 || e.Direction == directionRecv
+	}
+	s.last = e.Ts
+	data := e.data()
+	if s.protocol == protocolUnknown {
+		// the connection is followed from its first recognized request
+		switch {
+		case isHTTPRequest(data):
+			s.protocol = protocolHTTP
+		case isDubbo(data) && data[2]&dubboFlagRequest != 0:
+			s.protocol = protocolDubbo
+		default:
+			return
+		}
+		s.requestDirection = e.Direction
+	}
+	switch s.protocol {
+	case protocolHTTP:
+		t.addHTTP(s, e.Direction, e.Ts, data)
+	case protocolDubbo:
+		t.addDubbo(s, e.Direction, e.Ts, data)
+	}
+}
+
+func (t *tracker) addHTTP(s *stream, direction uint8, ts uint64, data []byte) {
+	if direction == s.requestDirection {
+		if !isHTTPRequest(data) {
+			// the rest of the body of the last request
+			if n := len(s.pending); n > 0 {
+				s.pending[n-1].requestEnd = ts
+			}
+			return
+		}
+		// a new request on the connection ends the response to the previous one
+		t.settle(s)
+		head, ok := parseRequest(data)
+		if !ok || len(s.pending) >= maxPending {
+			return
+		}
+		s.pending = append(s.pending, &exchange{protocol: protocolHTTP, request: head, start: ts, requestEnd: ts})
+		return
+	}
+	if !isHTTPResponse(data) {
+		if s.responding != nil {
+			s.responding.responseEnd = ts
+		}
+		return
+	}
+	t.settle(s)
+	head, ok := parseResponse(data)
+	if !ok || len(s.pending) == 0 {
+		return
+	}
+	x := s.pending[0]
+	s.pending = s.pending[1:]
+	x.response = head
+	x.responseStart, x.responseEnd = ts, ts
+	// 1xx responses precede the final response of the request
+	if head.status < 200 {
+		s.pending = append([]*exchange{x}, s.pending...)
+		return
+	}
+	s.responding = x
+}
+
+func (t *tracker) addDubbo(s *stream, direction uint8, ts uint64, data []byte) {
+	for _, f := range parseDubbo(data) {
+		if f.event {
+			continue
+		}
+		if direction == s.requestDirection && f.request {
+			if len(s.calls) >= maxPending {
+				continue
+			}
+			call := f
+			s.calls[f.id] = &exchange{protocol: protocolDubbo, call: &call, start: ts, requestEnd: ts}
+			continue
+		}
+		x, ok := s.calls[f.id]
+		if !ok || f.request {
+			continue
+		}
+		delete(s.calls, f.id)
+		x.status = f.status
+		x.responseStart, x.responseEnd = ts, ts
+		t.finish(s, x)
+	}
+}
+
+// settle emits the response being read.
+func (t *tracker) settle(s *stream) {
+	if s.responding != nil {
+		t.finish(s, s.responding)
+		s.responding = nil
+	}
+}
+
+func (t *tracker) finish(s *stream, x *exchange) {
+	x.pid, x.library = s.pid, s.library
+	x.served = s.requestDirection == directionRecv
+	if x.served {
+		x.client, x.server = s.remote, s.local
+	} else {
+		x.client, x.server = s.local, s.remote
+	}
+	t.emit(x)
+}
+
+// flush emits the responses not read for responseSettle and drops the connections idle for streamIdle.
+func (t *tracker) flush(now uint64) {
+	for key, s := range t.streams {
+		if s.responding != nil && now > s.responding.responseEnd+responseSettle {
+			t.settle(s)
+		}
+		if now > s.last+streamIdle {
+			delete(t.streams, key)
+		}
+	}
+}
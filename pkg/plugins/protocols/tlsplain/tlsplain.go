@@ -0,0 +1,443 @@
+// Package tlsplain reports the HTTP/1 and dubbo calls carried by TLS connections, from the plaintext the
+// TLS libraries of the processes of the node encrypt and decrypt. The plugins decoding the packets on the
+// veths only see the ciphertext of these connections, e.g. of the Spring services serving HTTPS and of
+// the dubbo services with TLS enabled.
+//
+// The probes are uprobes attached to the libraries found in the maps of the processes (TLS_PLAINTEXT_PROC_PATH),
+// the processes are scanned every TLS_PLAINTEXT_SCAN_INTERVAL:
+//
+//	jsse         JSSE encrypts in java, the JVMs must run the agent of tools/jsse-probe, which hands the
+//	             plaintext of the SSLEngines to libjsseprobe.so
+//	boringssl    SSL_read and SSL_write of the envoy binaries, the sidecars of the istio meshes encrypt
+//	             all traffic of the pods with mTLS
+//	nodejs       SSL_read(_ex) and SSL_write(_ex) of the OpenSSL of the node binaries, or of the libssl
+//	             mapped by the node processes for the builds linking the system OpenSSL
+//	ktls         sendmsg and recvmsg of the kTLS sockets, which encrypt in the kernel. The kprobes are
+//	             attached once the tls module is loaded, the sockets of the libraries above are skipped
+//
+// The HTTP calls are reported like the ones of the http plugin (application_http*, with an https http_url),
+// the dubbo calls like the ones of the rpc plugin (application_rpc*). Both carry the tag tls_library. The
+// calls captured in the clients are reported with span_kind=client, the calls between two probed processes
+// of the node are reported by both. The inbound connections of the sidecars are redirected to the port of
+// envoy (15006 with istio), which is the port of the servers then.
+// Only HTTP/1 is decoded, the meshes upgrading the calls between the sidecars to HTTP/2 are not covered.
+//
+// TLS_PLAINTEXT_LIBRARIES selects the libraries probed, the events of each library are limited by the
+// event budget of its name, e.g. EBPF_PROBE_EVENT_BUDGETS={"jsse":20000}.
+package tlsplain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+	"golang.org/x/sys/unix"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/eventbudget"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/errorcodes"
+	httpebpf "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/meta"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/route"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+)
+
+const (
+	programPath = "target/tlsplain.bpf.o"
+	mapData     = "tls_data_map"
+
+	rpcMeasurementGroup      = "application_rpc"
+	rpcErrorMeasurementGroup = rpcMeasurementGroup + "_error"
+
+	pollInterval = time.Second
+	// budgetReportInterval is the interval of the ebpf_event_budget metrics of dropped events.
+	budgetReportInterval = 30 * time.Second
+	// dubboStatusOK is the status byte of the successful dubbo responses.
+	dubboStatusOK = 20
+)
+
+// kprobes follow the sockets read and written by the threads, the libraries not knowing their socket are
+// matched with them.
+var kprobes = map[string]string{
+	"tcp_sendmsg": "kprobe_tcp_sendmsg",
+	"tcp_recvmsg": "kprobe_tcp_recvmsg",
+}
+
+type Config struct {
+	ProcPath     string        `env:"TLS_PLAINTEXT_PROC_PATH" default:"/rootfs/proc"`
+	ScanInterval time.Duration `env:"TLS_PLAINTEXT_SCAN_INTERVAL" default:"30s"`
+	// Libraries is a comma separated list of the libraries probed.
+	Libraries string `env:"TLS_PLAINTEXT_LIBRARIES" default:"jsse,boringssl,nodejs"`
+}
+
+type provider struct {
+	Log logs.Logger
+
+	cfg          Config
+	kprobeHelper kprobe.Interface
+	meta         meta.Interface
+	enricher     enrich.Interface
+	errorCodes   errorcodes.Interface
+
+	collection *ebpf.Collection
+	links      []link.Link
+	budget     *eventbudget.Guard
+	scanner    *scanner
+	// kernelLibraries are the kernel libraries waiting for their module.
+	kernelLibraries []library
+	clock           clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	errorCodes, err := errorcodes.New()
+	if err != nil {
+		return err
+	}
+	p.errorCodes = errorCodes
+	routes, err := route.New()
+	if err != nil {
+		return err
+	}
+	p.meta = meta.New(p.Log, p.kprobeHelper, topology.NatHelper(ctx), errorCodes, routes)
+	p.enricher = p.meta.Enricher()
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	libs, err := selectLibraries(p.cfg.Libraries)
+	if err != nil {
+		p.Log.Errorf("invalid TLS_PLAINTEXT_LIBRARIES: %v", err)
+		return
+	}
+	if err := p.load(libs); err != nil {
+		p.Log.Errorf("failed to load tls plaintext ebpf program, err: %v", err)
+		return
+	}
+	c <- kprobe.WaitSynced(p.kprobeHelper, "tlsplain")
+	emit := func(m *metric.Metric) {
+		c <- m
+		if slow := p.meta.Slow(m); slow != nil {
+			c <- slow
+		}
+	}
+	t := newTracker(func(x *exchange) {
+		p.enricher.Submit(func() *metric.Metric { return p.convert(x) }, emit)
+	})
+	p.scanner.scan(p.clock.Now())
+
+	poll := p.clock.NewTicker(pollInterval)
+	defer poll.Stop()
+	scan := p.clock.NewTicker(p.cfg.ScanInterval)
+	defer scan.Stop()
+	flush := p.clock.NewTicker(enrich.FlushInterval)
+	defer flush.Stop()
+	budget := p.clock.NewTicker(budgetReportInterval)
+	defer budget.Stop()
+	m := p.collection.Maps[mapData]
+	for {
+		select {
+		case <-poll.C():
+			for _, e := range p.read(m) {
+				t.add(e)
+			}
+			var ts unix.Timespec
+			if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err == nil {
+				t.flush(uint64(ts.Nano()))
+			}
+		case now := <-scan.C():
+			p.scanner.scan(now)
+			p.attachKernel()
+		case <-flush.C():
+			p.enricher.Flush()
+		case <-budget.C():
+			p.reportBudget(c)
+		}
+	}
+}
+
+func selectLibraries(names string) ([]library, error) {
+	var ans []library
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, lib := range libraries {
+			if lib.name == name {
+				ans = append(ans, lib)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown library %q", name)
+		}
+	}
+	return ans, nil
+}
+
+func (p *provider) load(libs []library) error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	p.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	p.budget, err = eventbudget.Apply(p.collection, "tlsplain", budgetProbes())
+	if err != nil {
+		return err
+	}
+	for symbol, name := range kprobes {
+		l, err := link.Kprobe(symbol, p.collection.Programs[name], nil)
+		if err != nil {
+			return fmt.Errorf("failed to attach kprobe(%s): %v", symbol, err)
+		}
+		p.links = append(p.links, l)
+	}
+	programs := make(map[string]*ebpf.Program)
+	var userLibraries []library
+	for _, lib := range libs {
+		if lib.kernel {
+			p.kernelLibraries = append(p.kernelLibraries, lib)
+			continue
+		}
+		userLibraries = append(userLibraries, lib)
+		for _, probe := range lib.probes {
+			prog, ok := p.collection.Programs[probe.program]
+			if !ok {
+				return fmt.Errorf("program %s not found", probe.program)
+			}
+			programs[probe.program] = prog
+		}
+	}
+	p.scanner = newScanner(p.Log, p.cfg.ProcPath, userLibraries, programs)
+	p.attachKernel()
+	return nil
+}
+
+// attachKernel attaches the probes of the kernel libraries waiting for their module, e.g. the tls module is
+// loaded by the first socket enabling kTLS.
+func (p *provider) attachKernel() {
+	var waiting []library
+	for _, lib := range p.kernelLibraries {
+		links, err := p.attachKprobes(lib.probes)
+		if err != nil {
+			p.Log.Debugf("%s probes not attached, the module may not be loaded yet: %v", lib.name, err)
+			waiting = append(waiting, lib)
+			continue
+		}
+		p.links = append(p.links, links...)
+		p.Log.Infof("attached %s probes", lib.name)
+	}
+	p.kernelLibraries = waiting
+}
+
+// attachKprobes attaches all probes or none.
+func (p *provider) attachKprobes(probes []probe) ([]link.Link, error) {
+	var links []link.Link
+	for _, pr := range probes {
+		prog, ok := p.collection.Programs[pr.program]
+		if !ok {
+			return nil, fmt.Errorf("program %s not found", pr.program)
+		}
+		var (
+			l   link.Link
+			err error
+		)
+		if pr.ret {
+			l, err = link.Kretprobe(pr.symbol, prog, nil)
+		} else {
+			l, err = link.Kprobe(pr.symbol, prog, nil)
+		}
+		if err != nil && pr.optional {
+			continue
+		}
+		if err != nil {
+			for _, l := range links {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to attach kprobe(%s): %v", pr.symbol, err)
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// read drains the events of the map, oldest first.
+func (p *provider) read(m *ebpf.Map) []*dataEvent {
+	var (
+		key    uint64
+		val    dataEvent
+		events []*dataEvent
+	)
+	for m.Iterate().Next(&key, &val) {
+		e := val
+		events = append(events, &e)
+		if err := m.Delete(key); err != nil {
+			p.Log.Errorf("delete map error: %v", err)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Ts < events[j].Ts
+	})
+	return events
+}
+
+func (p *provider) reportBudget(c chan *metric.Metric) {
+	metrics, err := p.budget.Metrics(p.clock.Now().UnixNano())
+	if err != nil {
+		p.Log.Errorf("failed to read event budget, err: %v", err)
+		return
+	}
+	for _, m := range metrics {
+		p.Log.Warnf("%v plaintext events of %s dropped by the event budget", m.Fields["dropped_count"], m.Tags["probe"])
+		c <- m
+	}
+}
+
+func (p *provider) convert(x *exchange) *metric.Metric {
+	var output *metric.Metric
+	switch x.protocol {
+	case protocolHTTP:
+		output = p.convertHTTP(x)
+	case protocolDubbo:
+		output = p.convertDubbo(x)
+	}
+	if output == nil {
+		return nil
+	}
+	output.Tags["tls_library"] = x.library.String()
+	output.CaptureTime = clock.FromKtime(x.responseEnd)
+	return output
+}
+
+func (p *provider) convertHTTP(x *exchange) *metric.Metric {
+	phases := httpebpf.Phases{
+		RequestWrite: elapsed(x.start, x.requestEnd),
+		Server:       elapsed(x.requestEnd, x.responseStart),
+		ResponseRead: elapsed(x.responseStart, x.responseEnd),
+	}
+	m := &httpebpf.Metric{
+		SourceIP:             x.client.ip,
+		SourcePort:           x.client.port,
+		DestIP:               x.server.ip,
+		DestPort:             x.server.port,
+		Method:               x.request.method,
+		Path:                 x.request.path,
+		RawQuery:             x.request.query,
+		Version:              x.request.version,
+		Headers:              x.request.headers,
+		StatusCode:           x.response.status,
+		Duration:             phases.RequestWrite + phases.Server,
+		Phases:               phases,
+		RequestTimestamp:     x.start,
+		ResponseTimestamp:    x.responseStart,
+		ResponseEndTimestamp: x.responseEnd,
+		Client:               !x.served,
+	}
+	if m.Method == "POST" {
+		m.Body = x.request.body
+		m.ResponseBody = x.response.body
+	}
+	output := p.meta.Convert(m)
+	if output == nil {
+		return nil
+	}
+	if url, ok := output.Tags["http_url"]; ok {
+		output.Tags["http_url"] = "https://" + strings.TrimPrefix(url, "http://")
+	}
+	return output
+}
+
+// convertDubbo reports the call like the dubbo calls of the rpc plugin.
+func (p *provider) convertDubbo(x *exchange) *metric.Metric {
+	duration := elapsed(x.start, x.responseStart)
+	output := &metric.Metric{
+		Name:        rpcMeasurementGroup,
+		Measurement: rpcMeasurementGroup,
+		Timestamp:   p.clock.Now().UnixNano(),
+		Tags:        map[string]string{},
+		Fields:      enrich.Elapsed(duration),
+	}
+	p.enricher.Enrich(output, "DUBBO", enrich.Endpoints{
+		SourceIP:   x.client.ip,
+		SourcePort: x.client.port,
+		DestIP:     x.server.ip,
+		DestPort:   x.server.port,
+	})
+	if !x.served {
+		output.Tags["span_kind"] = "client"
+	}
+	call := x.call
+	output.Tags["rpc_type"] = "DUBBO"
+	output.Tags["rpc_target"] = call.service + "." + call.method
+	output.Tags["rpc_service"] = call.service
+	output.Tags["rpc_method"] = call.method
+	output.Tags["dubbo_service"] = call.service
+	output.Tags["dubbo_version"] = call.dubboVersion
+	output.Tags["dubbo_method"] = call.method
+	output.Tags["service_version"] = call.version
+	if x.status == dubboStatusOK {
+		output.Tags["error"] = "false"
+	} else {
+		output.Name = rpcErrorMeasurementGroup
+		output.Measurement = rpcErrorMeasurementGroup
+		output.Tags["error"] = "true"
+		p.errorCodes.Tag(output.Tags, errorcodes.Dubbo, strconv.Itoa(int(x.status)))
+	}
+	return output
+}
+
+// elapsed is end - start, 0 if a timestamp is missing or out of order.
+func elapsed(start, end uint64) uint64 {
+	if start == 0 || end < start {
+		return 0
+	}
+	return end - start
+}
+
+func (p *provider) Close() error {
+	if p.scanner != nil {
+		p.scanner.close()
+	}
+	for _, l := range p.links {
+		l.Close()
+	}
+	if p.collection != nil {
+		p.collection.Close()
+	}
+	return nil
+}
+
+func init() {
+	servicehub.Register("tlsplain", &servicehub.Spec{
+		Services:             []string{"tlsplain"},
+		Description:          "ebpf for the plaintext of tls connections",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
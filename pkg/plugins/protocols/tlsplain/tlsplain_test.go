@@ -0,0 +1,235 @@
+package tlsplain
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newEvent(ts uint64, connID uint64, direction uint8, local, remote [4]byte, lport, rport uint16, data string) *dataEvent {
+	e := &dataEvent{
+		Ts:         ts,
+		Pid:        100,
+		LocalIP:    local,
+		RemoteIP:   remote,
+		LocalPort:  lport,
+		RemotePort: rport,
+		ConnID:     connID,
+		Len:        uint32(len(data)),
+		Direction:  direction,
+		Library:    LibraryJSSE,
+	}
+	copy(e.Data[:], data)
+	return e
+}
+
+var (
+	serverIP = [4]byte{10, 0, 0, 1}
+	clientIP = [4]byte{10, 0, 0, 2}
+)
+
+func TestTrackHTTPServer(t *testing.T) {
+	var got []*exchange
+	tr := newTracker(func(x *exchange) { got = append(got, x) })
+	// the response is wrapped before the thread writes the socket, the tuple comes from the request read.
+	tr.add(newEvent(1000, 7, directionRecv, serverIP, clientIP, 8443, 40000,
+		"GET /api/users?id=1 HTTP/1.1\r\nHost: svc\r\nUser-Agent: curl\r\n\r\n"))
+	tr.add(newEvent(3000, 7, directionSend, [4]byte{10, 0, 0, 9}, clientIP, 1, 2, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+	tr.add(newEvent(3500, 7, directionSend, serverIP, clientIP, 8443, 40000, "body"))
+	if len(got) != 0 {
+		t.Fatalf("the response should be read until it settles")
+	}
+	tr.flush(3500 + responseSettle + 1)
+	if len(got) != 1 {
+		t.Fatalf("expected one exchange, got %d", len(got))
+	}
+	x := got[0]
+	if !x.served || x.server.String() != "10.0.0.1:8443" || x.client.String() != "10.0.0.2:40000" {
+		t.Errorf("unexpected endpoints %+v", x)
+	}
+	if x.request.method != "GET" || x.request.path != "/api/users" || x.request.query != "id=1" ||
+		x.request.version != "HTTP/1.1" || x.request.headers["User-Agent"] != "curl" {
+		t.Errorf("unexpected request %+v", x.request)
+	}
+	if x.response.status != 404 || x.start != 1000 || x.responseStart != 3000 || x.responseEnd != 3500 {
+		t.Errorf("unexpected response %+v", x)
+	}
+}
+
+func TestTrackHTTPClientOfLocalServer(t *testing.T) {
+	var got []*exchange
+	tr := newTracker(func(x *exchange) { got = append(got, x) })
+	// the server of the node serves the request
+	tr.add(newEvent(1000, 7, directionRecv, serverIP, clientIP, 8443, 40000, "GET / HTTP/1.1\r\n\r\n"))
+	// its client on the node sees the same exchange
+	tr.add(newEvent(900, 8, directionSend, clientIP, serverIP, 40000, 8443, "GET / HTTP/1.1\r\n\r\n"))
+	tr.add(newEvent(2000, 8, directionRecv, clientIP, serverIP, 40000, 8443, "HTTP/1.1 200 OK\r\n\r\n"))
+	// a new request ends the previous response
+	tr.add(newEvent(3000, 8, directionSend, clientIP, serverIP, 40000, 8443, "GET /next HTTP/1.1\r\n\r\n"))
+	// both ends report the exchange
+	if len(got) != 1 || got[0].served || got[0].server.String() != "10.0.0.1:8443" || got[0].response.status != 200 {
+		t.Fatalf("unexpected exchanges %+v", got)
+	}
+	// a client of an external server reports it
+	tr.add(newEvent(4000, 9, directionSend, clientIP, [4]byte{1, 1, 1, 1}, 40001, 443, "POST /pay HTTP/1.1\r\n\r\n{}"))
+	tr.add(newEvent(5000, 9, directionRecv, clientIP, [4]byte{1, 1, 1, 1}, 40001, 443, "HTTP/1.1 100 Continue\r\n\r\n"))
+	tr.add(newEvent(6000, 9, directionRecv, clientIP, [4]byte{1, 1, 1, 1}, 40001, 443, "HTTP/1.1 201 Created\r\n\r\nok"))
+	tr.flush(6000 + responseSettle + 1)
+	if len(got) != 2 || got[1].served || got[1].server.String() != "1.1.1.1:443" || got[1].response.status != 201 {
+		t.Fatalf("unexpected exchanges %+v", got)
+	}
+	if string(got[1].request.body) != "{}" || string(got[1].response.body) != "ok" {
+		t.Errorf("unexpected bodies %q %q", got[1].request.body, got[1].response.body)
+	}
+}
+
+func TestParseHeadTruncated(t *testing.T) {
+	h, ok := parseRequest([]byte("GET /a HTTP/1.1\r\nHost: svc\r\nCookie: abc"))
+	if !ok || h.headers["Host"] != "svc" || h.body != nil {
+		t.Fatalf("unexpected head %+v", h)
+	}
+	if _, ok := h.headers["Cookie"]; ok {
+		t.Errorf("the truncated header should be dropped")
+	}
+	if isHTTPRequest([]byte("GETX / HTTP/1.1")) || !isHTTPRequest([]byte("DELETE /a HTTP/1.1")) {
+		t.Errorf("unexpected request detection")
+	}
+}
+
+func hessianShort(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func dubboFrameBytes(flag, status byte, id uint64, body []byte) []byte {
+	b := make([]byte, dubboHeaderSize, dubboHeaderSize+len(body))
+	b[0], b[1], b[2], b[3] = 0xda, 0xbb, flag, status
+	binary.BigEndian.PutUint64(b[4:12], id)
+	binary.BigEndian.PutUint32(b[12:16], uint32(len(body)))
+	return append(b, body...)
+}
+
+func TestTrackDubbo(t *testing.T) {
+	var got []*exchange
+	tr := newTracker(func(x *exchange) { got = append(got, x) })
+	var body []byte
+	body = append(body, hessianShort("2.0.2")...)
+	body = append(body, hessianShort("org.apache.demo.DemoService")...)
+	body = append(body, hessianShort("1.0.0")...)
+	body = append(body, hessianShort("sayHello")...)
+	heartbeat := dubboFrameBytes(dubboFlagRequest|dubboFlagEvent|dubboHessian2, 0, 1, []byte{'N'})
+	request := dubboFrameBytes(dubboFlagRequest|0x40|dubboHessian2, 0, 2, body)
+	tr.add(newEvent(1000, 0, directionRecv, serverIP, clientIP, 20880, 40000, string(append(heartbeat, request...))))
+	tr.add(newEvent(2000, 0, directionSend, serverIP, clientIP, 20880, 40000, string(dubboFrameBytes(dubboHessian2, 70, 2, nil))))
+	if len(got) != 1 {
+		t.Fatalf("expected one call, got %d", len(got))
+	}
+	want := dubboFrame{request: true, id: 2, dubboVersion: "2.0.2", service: "org.apache.demo.DemoService", version: "1.0.0", method: "sayHello"}
+	if !reflect.DeepEqual(*got[0].call, want) || got[0].status != 70 || !got[0].served {
+		t.Errorf("unexpected call %+v %d", *got[0].call, got[0].status)
+	}
+}
+
+func TestHessianString(t *testing.T) {
+	s, n, ok := hessianString(append([]byte{0x30, 0x20}, strings.Repeat("a", 32)...))
+	if !ok || n != 34 || len(s) != 32 {
+		t.Errorf("unexpected medium string %q %d", s, n)
+	}
+	// the length is in characters
+	s, n, ok = hessianString(append([]byte{2}, "中a"...))
+	if !ok || s != "中a" || n != 5 {
+		t.Errorf("unexpected utf-8 string %q %d", s, n)
+	}
+	if _, _, ok := hessianString([]byte{5, 'a'}); ok {
+		t.Errorf("a truncated string should not be decoded")
+	}
+}
+
+func TestParseMaps(t *testing.T) {
+	maps := `7f1c0000-7f1c1000 r--p 00000000 08:01 1001 /opt/erda/jsse-probe/libjsseprobe.so
+7f1c1000-7f1c2000 r-xp 00001000 08:01 1001 /opt/erda/jsse-probe/libjsseprobe.so
+7f1c3000-7f1c4000 r-xp 00000000 08:01 1002 /usr/lib/libssl.so.3 (deleted)
+7f1c5000-7f1c6000 r-xp 00000000 00:00 0 [vdso]
+7f1c7000-7f1c8000 rw-p 00000000 00:00 0
+7f1c9000-7f1ca000 r-xp 00000000 08:01 1003 /app/my lib.so
+`
+	want := []string{"/opt/erda/jsse-probe/libjsseprobe.so", "/app/my lib.so"}
+	if got := parseMaps(strings.NewReader(maps)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTrackRedirectedServer(t *testing.T) {
+	var got []*exchange
+	tr := newTracker(func(x *exchange) { got = append(got, x) })
+	// the sidecar of the server serves on the redirected port
+	inbound := newEvent(1000, 0xc0ffee, directionRecv, serverIP, clientIP, 15006, 40000, "GET / HTTP/1.1\r\n\r\n")
+	inbound.Library = LibraryBoringSSL
+	tr.add(inbound)
+	// the sidecar of the client connects to the port of the service
+	for _, e := range []*dataEvent{
+		newEvent(900, 0xbeef, directionSend, clientIP, serverIP, 40000, 8080, "GET / HTTP/1.1\r\n\r\n"),
+		newEvent(2000, 0xbeef, directionRecv, clientIP, serverIP, 40000, 8080, "HTTP/1.1 200 OK\r\n\r\n"),
+	} {
+		e.Library = LibraryBoringSSL
+		tr.add(e)
+	}
+	tr.flush(2000 + responseSettle + 1)
+	if len(got) != 1 || got[0].served || got[0].server.String() != "10.0.0.1:8080" {
+		t.Errorf("the sidecar of the client should report the exchange with the port of the service, got %+v", got)
+	}
+	lib, _ := findLibrary(LibraryBoringSSL)
+	if !lib.match("/usr/local/bin/envoy") || lib.match("/usr/lib/libssl.so.3") {
+		t.Errorf("unexpected boringssl match")
+	}
+}
+
+func TestNodeLibrary(t *testing.T) {
+	lib, _ := findLibrary(LibraryNodeJS)
+	for path, want := range map[string]bool{
+		"/usr/local/bin/node":                   true,
+		"/usr/lib/x86_64-linux-gnu/libssl.so.3": true,
+		"/usr/lib/libcrypto.so.3":               false,
+	} {
+		if lib.match(path) != want {
+			t.Errorf("match %s, want %v", path, want)
+		}
+	}
+	if !lib.process("/usr/bin/nodejs") || lib.process("/usr/bin/python3") {
+		t.Errorf("the system libssl should only be probed for node processes")
+	}
+}
+
+func TestTrackKTLS(t *testing.T) {
+	var got []*exchange
+	tr := newTracker(func(x *exchange) { got = append(got, x) })
+	// the kernel knows the socket, the connections are keyed by their tuple
+	for _, e := range []*dataEvent{
+		newEvent(1000, 0, directionSend, clientIP, serverIP, 40000, 443, "GET /a HTTP/1.1\r\n\r\n"),
+		newEvent(1100, 0, directionSend, clientIP, serverIP, 40001, 443, "GET /b HTTP/1.1\r\n\r\n"),
+		newEvent(2000, 0, directionRecv, clientIP, serverIP, 40001, 443, "HTTP/1.1 500 Internal Server Error\r\n\r\n"),
+		newEvent(2100, 0, directionRecv, clientIP, serverIP, 40000, 443, "HTTP/1.1 200 OK\r\n\r\n"),
+	} {
+		e.Library = LibraryKTLS
+		tr.add(e)
+	}
+	tr.flush(2100 + responseSettle + 1)
+	if len(got) != 2 {
+		t.Fatalf("expected two exchanges, got %d", len(got))
+	}
+	for _, x := range got {
+		if (x.request.path == "/a") != (x.response.status == 200) || x.library != LibraryKTLS {
+			t.Errorf("the exchanges of the connections should not be mixed: %s %d", x.request.path, x.response.status)
+		}
+	}
+}
+
+func TestSelectLibraries(t *testing.T) {
+	libs, err := selectLibraries(" jsse, ktls ,")
+	if err != nil || len(libs) != 2 || libs[0].id != LibraryJSSE || !libs[1].kernel {
+		t.Errorf("unexpected libraries %v %v", libs, err)
+	}
+	if _, err := selectLibraries("jsse,gnutls"); err == nil {
+		t.Errorf("an unknown library should be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package tlsplain
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// dataSize is TLS_DATA_SIZE of ebpf/plugins/tlsplain/main.c
+	dataSize = 1024
+
+	directionSend = 0
+	directionRecv = 1
+)
+
+// dataEvent mirrors struct tls_data_event_t of ebpf/plugins/tlsplain/main.c
+type dataEvent struct {
+	Ts        uint64
+	Pid       uint32
+	Tid       uint32
+	LocalIP   [4]byte
+	RemoteIP  [4]byte
+	LocalPort uint16
+	// RemotePort is in host order like LocalPort.
+	RemotePort uint16
+	Pad0       uint32
+	ConnID     uint64
+	Len        uint32
+	Direction  uint8
+	Library    Library
+	Pad1       uint16
+	Data       [dataSize]byte
+}
+
+// data returns the captured plaintext of the call.
+func (e *dataEvent) data() []byte {
+	return e.Data[:min(int(e.Len), dataSize-1)]
+}
+
+// endpoint is an ip:port of a connection.
+type endpoint struct {
+	ip   string
+	port uint16
+}
+
+func (e endpoint) String() string {
+	return fmt.Sprintf("%s:%d", e.ip, e.port)
+}
+
+func (e *dataEvent) local() endpoint {
+	return endpoint{ip: net.IP(e.LocalIP[:]).String(), port: e.LocalPort}
+}
+
+func (e *dataEvent) remote() endpoint {
+	return endpoint{ip: net.IP(e.RemoteIP[:]).String(), port: e.RemotePort}
+}
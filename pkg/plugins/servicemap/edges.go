@@ -0,0 +1,191 @@
+package servicemap
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+// requestMeasurements are the L7 measurements of single requests, the copies of the requests
+// (e.g. application_http_slow) are left out.
+var requestMeasurements = map[string]bool{
+	"application_http":        true,
+	"application_http_error":  true,
+	"application_rpc":         true,
+	"application_rpc_error":   true,
+	"application_db":          true,
+	"application_db_error":    true,
+	"application_cache":       true,
+	"application_cache_error": true,
+	"application_mq":          true,
+	"application_dns":         true,
+	"application_tls":         true,
+	"application_smtp":        true,
+	"application_ldap":        true,
+	"application_quic":        true,
+}
+
+// nodeTags are the platform metadata of the ends of an edge, taken from the <source|target>_ tags.
+var nodeTags = []string{
+	"service_id",
+	"service_name",
+	"application_id",
+	"application_name",
+	"project_id",
+	"project_name",
+	"runtime_name",
+	"workspace",
+	"terminus_key",
+}
+
+type edgeKey struct {
+	source   string
+	target   string
+	protocol string
+	// sourceInstance and targetInstance are the pods of the edges between pods.
+	sourceInstance string
+	targetInstance string
+}
+
+type edgeEntry struct {
+	tags       map[string]string
+	orgName    string
+	count      float64
+	errors     float64
+	elapsedSum float64
+	elapsedMax int64
+}
+
+// edges aggregates the requests between two reports by their source and target.
+type edges struct {
+	sync.Mutex
+	pods    bool
+	entries map[edgeKey]*edgeEntry
+}
+
+func newEdges(pods bool) *edges {
+	return &edges{pods: pods, entries: make(map[edgeKey]*edgeEntry)}
+}
+
+// observe counts the request m to its edge, the requests kept by sampling count for the requests
+// they stand for.
+func (s *edges) observe(m *metric.Metric) {
+	if !requestMeasurements[m.Measurement] || !counted(m) {
+		return
+	}
+	t := m.Tags
+	k := edgeKey{
+		source:   firstOf(t["source_service_name"], "unknown"),
+		target:   firstOf(t["target_service_name"], t["peer_service"], t["peer_address"], "unknown"),
+		protocol: strings.ToLower(firstOf(t["component"], strings.TrimSuffix(strings.TrimPrefix(m.Measurement, "application_"), "_error"))),
+	}
+	if s.pods {
+		k.sourceInstance = t["source_service_instance_id"]
+		k.targetInstance = t["target_service_instance_id"]
+	}
+	weight := 1.0
+	if rate, ok := m.Fields["sample_rate"].(float64); ok && rate > 0 {
+		weight = 1 / rate
+	}
+	elapsed := toInt64(m.Fields["elapsed_sum"])
+
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[k]
+	if !ok {
+		tags := map[string]string{
+			"source_service_name": k.source,
+			"target_service_name": k.target,
+			"protocol":            k.protocol,
+			"cluster_name":        t["cluster_name"],
+			"org_name":            t["org_name"],
+		}
+		for _, name := range nodeTags {
+			for _, side := range []string{"source_", "target_"} {
+				if v := t[side+name]; v != "" && tags[side+name] == "" {
+					tags[side+name] = v
+				}
+			}
+		}
+		if s.pods {
+			tags["source_service_instance_id"] = k.sourceInstance
+			tags["target_service_instance_id"] = k.targetInstance
+		}
+		if t["target_surrogate"] == "true" || t["target_external"] == "true" {
+			tags["target_external"] = "true"
+		}
+		e = &edgeEntry{tags: tags, orgName: m.OrgName}
+		s.entries[k] = e
+	}
+	e.count += weight
+	if t["error"] == "true" {
+		e.errors += weight
+	}
+	e.elapsedSum += weight * float64(elapsed)
+	if elapsed > e.elapsedMax {
+		e.elapsedMax = elapsed
+	}
+}
+
+// counted reports whether the request is counted to its edge: the client and the server of a request
+// between two pods both report it, the server side wins. The requests captured at a client are only
+// counted when the target is not a pod.
+func counted(m *metric.Metric) bool {
+	return m.Tags["span_kind"] != "client" || m.Tags["target_service_instance_id"] == "" || m.Tags["target_surrogate"] == "true"
+}
+
+// report returns the edges seen since the previous report, interval is the time between two reports in seconds.
+func (s *edges) report(timestamp int64, interval float64) []*metric.Metric {
+	s.Lock()
+	defer s.Unlock()
+	ans := make([]*metric.Metric, 0, len(s.entries))
+	for _, e := range s.entries {
+		tags := make(map[string]string, len(e.tags))
+		for name, value := range e.tags {
+			tags[name] = value
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			OrgName:     e.orgName,
+			Tags:        tags,
+			Fields: map[string]interface{}{
+				"count":        e.count,
+				"error_count":  e.errors,
+				"error_rate":   e.errors / e.count,
+				"call_rate":    e.count / interval,
+				"elapsed_mean": e.elapsedSum / e.count,
+				"elapsed_max":  e.elapsedMax,
+			},
+		})
+	}
+	s.entries = make(map[edgeKey]*edgeEntry)
+	return ans
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
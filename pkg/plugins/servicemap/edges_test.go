@@ -0,0 +1,87 @@
+package servicemap
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func request(measurement, kind, source, target string, elapsed uint64, isError bool) *metric.Metric {
+	m := &metric.Metric{
+		Measurement: measurement,
+		OrgName:     "erda",
+		Tags: map[string]string{
+			"component":                  "Http",
+			"span_kind":                  kind,
+			"source_service_name":        source,
+			"source_service_instance_id": source + "-1",
+			"target_service_name":        target,
+			"target_service_instance_id": target + "-1",
+			"target_terminus_key":        "tk-" + target,
+		},
+		Fields: map[string]interface{}{"elapsed_sum": elapsed},
+	}
+	if isError {
+		m.Tags["error"] = "true"
+	}
+	return m
+}
+
+func TestEdges(t *testing.T) {
+	s := newEdges(false)
+	s.observe(request("application_http", "server", "web", "api", 100, false))
+	s.observe(request("application_http_error", "server", "web", "api", 300, true))
+	// reported by the client of the same request
+	s.observe(request("application_http", "client", "web", "api", 120, false))
+	// the target is not a pod, only the client reports it
+	external := request("application_http", "client", "web", "", 50, false)
+	delete(external.Tags, "target_service_instance_id")
+	external.Tags["peer_address"] = "93.184.216.34:443"
+	external.Tags["target_surrogate"] = "true"
+	s.observe(external)
+	sampled := request("application_http", "server", "admin", "api", 200, false)
+	sampled.Fields["sample_rate"] = 0.25
+	s.observe(sampled)
+	s.observe(request("application_http_slow", "server", "web", "api", 2000, false))
+
+	report := s.report(1000, 60)
+	if len(report) != 3 {
+		t.Fatalf("expected three edges, got %v", report)
+	}
+	for _, m := range report {
+		if m.Measurement != measurement || m.Tags["protocol"] != "http" || m.OrgName != "erda" {
+			t.Errorf("unexpected edge %+v", m)
+		}
+		if _, ok := m.Tags["source_service_instance_id"]; ok {
+			t.Errorf("the edges are between services, got %+v", m.Tags)
+		}
+		switch m.Tags["source_service_name"] + "->" + m.Tags["target_service_name"] {
+		case "web->api":
+			if m.Fields["count"] != 2.0 || m.Fields["error_count"] != 1.0 || m.Fields["error_rate"] != 0.5 ||
+				m.Fields["elapsed_mean"] != 200.0 || m.Fields["elapsed_max"] != int64(300) || m.Tags["target_terminus_key"] != "tk-api" {
+				t.Errorf("unexpected edge %+v", m)
+			}
+		case "web->93.184.216.34:443":
+			if m.Fields["count"] != 1.0 || m.Tags["target_external"] != "true" {
+				t.Errorf("unexpected edge %+v", m)
+			}
+		case "admin->api":
+			if m.Fields["count"] != 4.0 || m.Fields["call_rate"] != 4.0/60 {
+				t.Errorf("the sampled requests count for the requests they stand for, got %+v", m)
+			}
+		default:
+			t.Errorf("unexpected edge %+v", m)
+		}
+	}
+	if report := s.report(2000, 60); len(report) != 0 {
+		t.Errorf("edges are only reported for the interval they were seen in, got %d", len(report))
+	}
+
+	pods := newEdges(true)
+	pods.observe(request("application_http", "server", "web", "api", 100, false))
+	pods.observe(request("application_http", "server", "web", "api", 100, false))
+	if report := pods.report(1000, 60); len(report) != 1 || report[0].Tags["source_service_instance_id"] != "web-1" ||
+		report[0].Tags["target_service_instance_id"] != "api-1" || report[0].Fields["count"] != 2.0 {
+		t.Errorf("unexpected edges between pods %v", report)
+	}
+}
@@ -0,0 +1,93 @@
+// Package servicemap aggregates the L7 requests observed by the protocol plugins into the edges of the
+// service map, so that the calls between the services are discovered without instrumenting them.
+// (The topology provider exchanges the nat records of the agents, see package topology.)
+//
+// The plugin subscribes to the request measurements on the event bus, every SERVICE_MAP_INTERVAL (1m)
+// the edges seen since the previous report are reported as service_relation:
+//
+//	tags:   source_service_name, target_service_name, protocol (the component of the requests, e.g. http, grpc, mysql),
+//	        source_* / target_* service_id, application_*, project_*, runtime_name, workspace, terminus_key,
+//	        cluster_name, org_name, target_external (the target is not a pod)
+//	fields: count, error_count    requests and errors, the sampled requests count for the requests they stand for
+//	        call_rate, error_rate  requests per second, errors per request
+//	        elapsed_mean, elapsed_max
+//
+// The targets that are not pods are named after their k8s service, their surrogate or their address, the
+// sources that are not pods are unknown. The edges are between services, SERVICE_MAP_PODS=true reports the
+// edges between pods instead, with the source_service_instance_id and target_service_instance_id tags.
+//
+// A request between two pods is reported by the client and the server, it is counted once on the server
+// side, the requests of the clients are only counted when the target is not a pod.
+package servicemap
+
+import (
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/eventbus"
+)
+
+const (
+	measurement = "service_relation"
+
+	subscriptionSize = 10000
+)
+
+type Config struct {
+	Interval time.Duration `env:"SERVICE_MAP_INTERVAL" default:"1m"`
+	Pods     bool          `env:"SERVICE_MAP_PODS" default:"false"`
+}
+
+type provider struct {
+	Log logs.Logger
+
+	cfg   Config
+	edges *edges
+	clock clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.edges = newEdges(p.cfg.Pods)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	topics := make([]string, 0, len(requestMeasurements))
+	for m := range requestMeasurements {
+		topics = append(topics, m)
+	}
+	sub := eventbus.Subscribe("servicemap", subscriptionSize, topics...)
+	defer sub.Close()
+	ticker := p.clock.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			p.edges.observe(e.Metric)
+		case <-ticker.C():
+			for _, m := range p.edges.report(p.clock.Now().UnixNano(), p.cfg.Interval.Seconds()) {
+				c <- m
+			}
+		}
+	}
+}
+
+func init() {
+	servicehub.Register("servicemap", &servicehub.Spec{
+		Services:    []string{"servicemap"},
+		Description: "service map of the requests observed on the node",
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
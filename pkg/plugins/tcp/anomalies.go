@@ -0,0 +1,87 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/tcpevents"
+)
+
+// anomalies counts the retransmits and resets of the connections from the events of the tcpevents plugin,
+// which owns their kprobes, until they are merged into the statistics read from the kernel.
+type anomalies struct {
+	sync.Mutex
+	conns map[tcpConn]*tcpStats
+	// role returns the ROLE_* of the socket of a connection, recorded by the kernel as the handshake completes.
+	role func(tcpConn) uint8
+}
+
+func newAnomalies(role func(tcpConn) uint8) *anomalies {
+	return &anomalies{conns: make(map[tcpConn]*tcpStats), role: role}
+}
+
+func (a *anomalies) observe(e *tcpevents.Event) {
+	if e.Type != tcpevents.EventRetransmit && e.Type != tcpevents.EventResetSent && e.Type != tcpevents.EventResetReceived {
+		return
+	}
+	local, remote := net.ParseIP(e.LocalIP).To4(), net.ParseIP(e.RemoteIP).To4()
+	if local == nil || remote == nil {
+		return
+	}
+	k := tcpConn{SourcePort: e.LocalPort, DestPort: e.RemotePort}
+	copy(k.SourceIP[:], local)
+	copy(k.DestIP[:], remote)
+	a.Lock()
+	defer a.Unlock()
+	s, ok := a.conns[k]
+	if !ok {
+		s = &tcpStats{}
+		a.conns[k] = s
+	}
+	if s.Cookie == 0 {
+		s.Cookie = e.SocketCookie
+	}
+	switch e.Type {
+	case tcpevents.EventRetransmit:
+		s.Retransmits++
+		return
+	case tcpevents.EventResetSent:
+		s.ResetsSent++
+	case tcpevents.EventResetReceived:
+		s.ResetsReceived++
+	}
+	if s.Role == roleUnknown {
+		s.Role = a.role(k)
+	}
+}
+
+// merge adds the anomalies counted since the last merge to the statistics of the connections, joined by
+// socket cookie, by address for the sockets of which the kernel generated no cookie.
+func (a *anomalies) merge(stats map[tcpConn]tcpStats) {
+	a.Lock()
+	conns := a.conns
+	a.conns = make(map[tcpConn]*tcpStats)
+	a.Unlock()
+	byCookie := make(map[uint64]tcpConn, len(stats))
+	for k, v := range stats {
+		if v.Cookie != 0 {
+			byCookie[v.Cookie] = k
+		}
+	}
+	for k, s := range conns {
+		if joined, ok := byCookie[s.Cookie]; ok && s.Cookie != 0 {
+			k = joined
+		}
+		v := stats[k]
+		v.Retransmits += s.Retransmits
+		v.ResetsSent += s.ResetsSent
+		v.ResetsReceived += s.ResetsReceived
+		if v.Role == roleUnknown {
+			v.Role = s.Role
+		}
+		if v.Cookie == 0 {
+			v.Cookie = s.Cookie
+		}
+		stats[k] = v
+	}
+}
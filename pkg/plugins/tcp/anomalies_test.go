@@ -0,0 +1,68 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/tcpevents"
+)
+
+func TestAnomalies(t *testing.T) {
+	client := tcpConn{SourceIP: [4]byte{10, 0, 0, 1}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 8080}
+	server := tcpConn{SourceIP: [4]byte{10, 0, 0, 2}, DestIP: [4]byte{10, 0, 0, 1}, SourcePort: 8080, DestPort: 41000}
+	roles := map[tcpConn]uint8{client: roleClient}
+	a := newAnomalies(func(k tcpConn) uint8 { return roles[k] })
+	event := func(typ tcpevents.EventType, k tcpConn) *tcpevents.Event {
+		return &tcpevents.Event{
+			Type:       typ,
+			LocalIP:    net.IP(k.SourceIP[:]).String(),
+			LocalPort:  k.SourcePort,
+			RemoteIP:   net.IP(k.DestIP[:]).String(),
+			RemotePort: k.DestPort,
+		}
+	}
+	a.observe(event(tcpevents.EventRetransmit, client))
+	a.observe(event(tcpevents.EventRetransmit, client))
+	a.observe(event(tcpevents.EventResetSent, client))
+	a.observe(event(tcpevents.EventResetReceived, server))
+	a.observe(event(tcpevents.EventZeroWindow, tcpConn{SourceIP: [4]byte{10, 0, 0, 3}, DestIP: [4]byte{10, 0, 0, 1}}))
+	a.observe(&tcpevents.Event{Type: tcpevents.EventRetransmit, LocalIP: "fe80::1", RemoteIP: "fe80::2"})
+
+	stats := map[tcpConn]tcpStats{client: {RTTSum: 100, RTTCount: 1, RTTMax: 100}}
+	a.merge(stats)
+	if len(stats) != 2 {
+		t.Fatalf("unexpected connections %v", stats)
+	}
+	if s := stats[client]; s.Retransmits != 2 || s.ResetsSent != 1 || s.Role != roleClient || s.RTTCount != 1 {
+		t.Errorf("unexpected stats of the client %+v", s)
+	}
+	// the server was established before the agent started, its role is resolved by its port.
+	s := stats[server]
+	if s.ResetsReceived != 1 || s.Retransmits != 0 || s.Role != roleUnknown {
+		t.Errorf("unexpected stats of the server %+v", s)
+	}
+	resolveRole(server, &s)
+	if aborts, resets := resets(&s); aborts != 1 || resets != 0 {
+		t.Errorf("the reset received by the server should be a client abort, got %d aborts and %d resets", aborts, resets)
+	}
+
+	// the events are joined by socket cookie first, by address for the sockets unknown to the kernel stats.
+	rebound := event(tcpevents.EventRetransmit, tcpConn{SourceIP: [4]byte{10, 0, 0, 9}, DestIP: [4]byte{10, 0, 0, 2}, SourcePort: 41000, DestPort: 8080})
+	rebound.SocketCookie = 7
+	a.observe(rebound)
+	unknown := event(tcpevents.EventRetransmit, server)
+	unknown.SocketCookie = 8
+	a.observe(unknown)
+	stats = map[tcpConn]tcpStats{client: {Cookie: 7}}
+	a.merge(stats)
+	if len(stats) != 2 || stats[client].Retransmits != 1 || stats[server].Retransmits != 1 || stats[server].Cookie != 8 {
+		t.Errorf("unexpected joined connections %v", stats)
+	}
+
+	// the anomalies are merged once.
+	stats = map[tcpConn]tcpStats{}
+	a.merge(stats)
+	if len(stats) != 0 {
+		t.Errorf("unexpected connections %v", stats)
+	}
+}
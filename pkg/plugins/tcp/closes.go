@@ -0,0 +1,30 @@
+package tcp
+
+// roles of the sockets, ROLE_* of ebpf/plugins/tcp/main.c.
+const (
+	roleUnknown uint8 = 0
+	roleClient  uint8 = 1
+	roleServer  uint8 = 2
+)
+
+// resolveRole sets the role of the sockets established before the agent started, the role of which the
+// kernel does not know: the servers listen on the lower port, the clients are given ephemeral ports.
+func resolveRole(k tcpConn, s *tcpStats) {
+	if s.Role != roleUnknown {
+		return
+	}
+	if k.SourcePort < k.DestPort {
+		s.Role = roleServer
+	} else {
+		s.Role = roleClient
+	}
+}
+
+// resets returns the resets of a connection by the side aborting it: the resets sent by a client and
+// received by a server are client aborts, the others server resets.
+func resets(s *tcpStats) (clientAborts, serverResets uint64) {
+	if s.Role == roleServer {
+		return uint64(s.ResetsReceived), uint64(s.ResetsSent)
+	}
+	return uint64(s.ResetsSent), uint64(s.ResetsReceived)
+}
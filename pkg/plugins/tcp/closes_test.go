@@ -0,0 +1,26 @@
+package tcp
+
+import "testing"
+
+func TestResets(t *testing.T) {
+	server := tcpConn{SourcePort: 8080, DestPort: 41000}
+	client := tcpConn{SourcePort: 41000, DestPort: 8080}
+	for _, c := range []struct {
+		name           string
+		conn           tcpConn
+		stats          tcpStats
+		aborts, resets uint64
+	}{
+		{"client closing with unread data", client, tcpStats{ResetsSent: 1, Role: roleClient}, 1, 0},
+		{"server reset seen by the client", client, tcpStats{ResetsReceived: 1, Role: roleClient}, 0, 1},
+		{"server resetting", server, tcpStats{ResetsSent: 2, Role: roleServer}, 0, 2},
+		{"client abort seen by the server", server, tcpStats{ResetsReceived: 1, Role: roleServer}, 1, 0},
+		{"server established before the agent", server, tcpStats{ResetsSent: 1}, 0, 1},
+		{"client established before the agent", client, tcpStats{ResetsSent: 1}, 1, 0},
+	} {
+		resolveRole(c.conn, &c.stats)
+		if aborts, resets := resets(&c.stats); aborts != c.aborts || resets != c.resets {
+			t.Errorf("%s: expected %d client aborts and %d server resets, got %d and %d", c.name, c.aborts, c.resets, aborts, resets)
+		}
+	}
+}
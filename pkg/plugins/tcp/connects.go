@@ -0,0 +1,103 @@
+package tcp
+
+import (
+	"syscall"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const measurementConnect = "application_tcp_connect"
+
+// tcpConnect mirrors struct tcp_connect_t of ebpf/plugins/tcp/main.c.
+type tcpConnect struct {
+	Timestamp   uint64
+	Latency     uint64
+	SourceIP    [16]byte
+	DestIP      [16]byte
+	SourcePort  uint16
+	DestPort    uint16
+	Family      uint16
+	Established uint16
+	Err         uint32
+	Pad         uint32
+}
+
+type destinationKey struct {
+	source      string
+	destination string
+}
+
+type destination struct {
+	tags       map[string]string
+	orgName    string
+	connects   uint64
+	failures   uint64
+	refused    uint64
+	timeouts   uint64
+	latencySum uint64
+	latencyMax uint64
+}
+
+// connects aggregates the connects between two reports by their source pod and the address they dialed.
+type connects struct {
+	entries map[destinationKey]*destination
+}
+
+func newConnects() *connects {
+	return &connects{entries: make(map[destinationKey]*destination)}
+}
+
+// observe adds the connect c to its destination, conn carries the tags of the source pod and of the
+// destination, peer_address is the address dialed. The tags of the first connect are reported.
+func (cs *connects) observe(conn *metric.Metric, c *tcpConnect) {
+	k := destinationKey{source: conn.Tags["source_service_instance_id"], destination: conn.Tags["peer_address"]}
+	d, ok := cs.entries[k]
+	if !ok {
+		d = &destination{tags: conn.Tags, orgName: conn.OrgName}
+		cs.entries[k] = d
+	}
+	d.connects++
+	if c.Established != 0 {
+		d.latencySum += c.Latency
+		if c.Latency > d.latencyMax {
+			d.latencyMax = c.Latency
+		}
+		return
+	}
+	d.failures++
+	switch syscall.Errno(c.Err) {
+	case syscall.ECONNREFUSED:
+		d.refused++
+	case syscall.ETIMEDOUT:
+		d.timeouts++
+	}
+}
+
+// report returns the destinations connected to since the previous report, the latencies of the
+// established connects are in nanoseconds.
+func (cs *connects) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(cs.entries))
+	for _, d := range cs.entries {
+		fields := map[string]interface{}{
+			"connects":     d.connects,
+			"failures":     d.failures,
+			"refused":      d.refused,
+			"timeouts":     d.timeouts,
+			"failure_rate": float64(d.failures) / float64(d.connects),
+		}
+		if established := d.connects - d.failures; established > 0 {
+			fields["latency_mean"] = int64(d.latencySum / established)
+			fields["latency_max"] = int64(d.latencyMax)
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurementConnect,
+			Measurement: measurementConnect,
+			Timestamp:   timestamp,
+			OrgName:     d.orgName,
+			Tags:        d.tags,
+			Fields:      fields,
+		})
+	}
+	cs.entries = make(map[destinationKey]*destination)
+	return ans
+}
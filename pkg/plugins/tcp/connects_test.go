@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestConnects(t *testing.T) {
+	cs := newConnects()
+	conn := func(peer string) *metric.Metric {
+		return &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"source_service_instance_id": "web-1",
+			"peer_address":               peer,
+			"peer_service":               "api",
+		}}
+	}
+	cs.observe(conn("10.96.0.10:80"), &tcpConnect{Established: 1, Latency: 1000})
+	cs.observe(conn("10.96.0.10:80"), &tcpConnect{Established: 1, Latency: 3000})
+	cs.observe(conn("10.96.0.10:80"), &tcpConnect{Err: uint32(syscall.ECONNREFUSED)})
+	cs.observe(conn("10.96.0.10:80"), &tcpConnect{Err: uint32(syscall.ETIMEDOUT)})
+	cs.observe(conn("10.96.0.11:80"), &tcpConnect{})
+
+	reported := cs.report(1000)
+	if len(reported) != 2 {
+		t.Fatalf("expected a metric per address dialed, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurementConnect || m.OrgName != "erda" || m.Tags["peer_service"] != "api" {
+			t.Errorf("unexpected destination %+v", m)
+		}
+		switch m.Tags["peer_address"] {
+		case "10.96.0.10:80":
+			if m.Fields["connects"] != uint64(4) || m.Fields["failures"] != uint64(2) || m.Fields["refused"] != uint64(1) ||
+				m.Fields["timeouts"] != uint64(1) || m.Fields["failure_rate"] != 0.5 ||
+				m.Fields["latency_mean"] != int64(2000) || m.Fields["latency_max"] != int64(3000) {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+		case "10.96.0.11:80":
+			// closed by the client before the handshake completed
+			if m.Fields["failures"] != uint64(1) || m.Fields["refused"] != uint64(0) || m.Fields["timeouts"] != uint64(0) {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+			if _, ok := m.Fields["latency_mean"]; ok {
+				t.Error("no connect was established")
+			}
+		}
+	}
+	if len(cs.report(2000)) != 0 {
+		t.Error("the destinations are reported once")
+	}
+}
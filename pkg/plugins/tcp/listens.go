@@ -0,0 +1,92 @@
+package tcp
+
+import (
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const measurementListen = "application_tcp_listen"
+
+// listenKey mirrors struct listen_key_t of ebpf/plugins/tcp/main.c.
+type listenKey struct {
+	Addr [4]byte
+	Port uint16
+	Pad  uint16
+}
+
+// listenStats mirrors struct listen_stats_t of ebpf/plugins/tcp/main.c.
+type listenStats struct {
+	SynOverflows    uint64
+	AcceptOverflows uint64
+	AcceptQueueMax  uint32
+	Backlog         uint32
+}
+
+type listenerKey struct {
+	pod  string
+	port uint16
+}
+
+type listener struct {
+	tags            map[string]string
+	orgName         string
+	synOverflows    uint64
+	acceptOverflows uint64
+	acceptQueueMax  uint32
+	backlog         uint32
+}
+
+// listens aggregates the queues of the listening sockets between two reports by pod and port.
+type listens struct {
+	entries map[listenerKey]*listener
+}
+
+func newListens() *listens {
+	return &listens{entries: make(map[listenerKey]*listener)}
+}
+
+// observe adds the statistics s of the socket listening on port, pod carries the tags of its pod.
+func (ls *listens) observe(pod *metric.Metric, port uint16, s *listenStats) {
+	k := listenerKey{pod: pod.Tags["source_service_instance_id"], port: port}
+	l, ok := ls.entries[k]
+	if !ok {
+		l = &listener{tags: pod.Tags, orgName: pod.OrgName}
+		l.tags["listen_port"] = strconv.Itoa(int(port))
+		ls.entries[k] = l
+	}
+	l.synOverflows += s.SynOverflows
+	l.acceptOverflows += s.AcceptOverflows
+	if s.AcceptQueueMax > l.acceptQueueMax {
+		l.acceptQueueMax = s.AcceptQueueMax
+	}
+	if s.Backlog != 0 {
+		l.backlog = s.Backlog
+	}
+}
+
+// report returns the listening sockets observed since the previous report.
+func (ls *listens) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(ls.entries))
+	for _, l := range ls.entries {
+		fields := map[string]interface{}{
+			"syn_overflows":    l.synOverflows,
+			"accept_overflows": l.acceptOverflows,
+			"accept_queue_max": uint64(l.acceptQueueMax),
+			"backlog":          uint64(l.backlog),
+		}
+		if l.backlog > 0 {
+			fields["accept_queue_usage"] = float64(l.acceptQueueMax) / float64(l.backlog)
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurementListen,
+			Measurement: measurementListen,
+			Timestamp:   timestamp,
+			OrgName:     l.orgName,
+			Tags:        l.tags,
+			Fields:      fields,
+		})
+	}
+	ls.entries = make(map[listenerKey]*listener)
+	return ans
+}
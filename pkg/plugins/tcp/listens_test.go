@@ -0,0 +1,46 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestListens(t *testing.T) {
+	ls := newListens()
+	pod := func() *metric.Metric {
+		return &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"source_service_instance_id": "api-1",
+			"source_service_name":        "api",
+		}}
+	}
+	ls.observe(pod(), 8080, &listenStats{SynOverflows: 2, AcceptOverflows: 1, AcceptQueueMax: 64, Backlog: 128})
+	ls.observe(pod(), 8080, &listenStats{AcceptOverflows: 3, AcceptQueueMax: 32})
+	ls.observe(pod(), 9090, &listenStats{AcceptQueueMax: 1})
+
+	reported := ls.report(1000)
+	if len(reported) != 2 {
+		t.Fatalf("expected a metric per listening port, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurementListen || m.OrgName != "erda" || m.Tags["source_service_name"] != "api" {
+			t.Errorf("unexpected listen %+v", m)
+		}
+		switch m.Tags["listen_port"] {
+		case "8080":
+			if m.Fields["syn_overflows"] != uint64(2) || m.Fields["accept_overflows"] != uint64(4) ||
+				m.Fields["accept_queue_max"] != uint64(64) || m.Fields["accept_queue_usage"] != 0.5 {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+		case "9090":
+			if _, ok := m.Fields["accept_queue_usage"]; ok {
+				t.Error("the usage needs the backlog")
+			}
+		default:
+			t.Errorf("unexpected port %+v", m.Tags)
+		}
+	}
+	if len(ls.report(2000)) != 0 {
+		t.Error("the listens are reported once")
+	}
+}
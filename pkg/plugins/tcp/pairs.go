@@ -0,0 +1,109 @@
+package tcp
+
+import (
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const measurement = "application_tcp"
+
+// pairTags are the tags of the pairs besides the source_* and target_* ones.
+var pairTags = []string{"metric_source", "_meta", "_metric_scope", "_metric_scope_id", "org_name", "cluster_name"}
+
+type pairKey struct {
+	source string
+	target string
+}
+
+type pair struct {
+	tags        map[string]string
+	orgName     string
+	connections uint64
+	retransmits uint64
+	rttSum      uint64
+	rttCount    uint64
+	rttMax      uint32
+	// clientAborts and serverResets are the resets by the side aborting the connections.
+	clientAborts uint64
+	serverResets uint64
+	timeouts     uint64
+}
+
+// pairs aggregates the statistics of the connections between two reports by their pair of pods.
+type pairs struct {
+	entries map[pairKey]*pair
+}
+
+func newPairs() *pairs {
+	return &pairs{entries: make(map[pairKey]*pair)}
+}
+
+// observe adds the statistics s of a connection to its pair, conn carries the tags of the pods of the
+// connection and its peer_address, the role of the socket is resolved.
+func (ps *pairs) observe(conn *metric.Metric, s *tcpStats) {
+	if s.Retransmits == 0 && s.RTTCount == 0 && s.ResetsSent == 0 && s.ResetsReceived == 0 && s.Timeouts == 0 {
+		return
+	}
+	k := pairKey{source: conn.Tags["source_service_instance_id"], target: conn.Tags["target_service_instance_id"]}
+	if k.target == "" {
+		k.target = conn.Tags["peer_address"]
+	}
+	p, ok := ps.entries[k]
+	if !ok {
+		p = &pair{tags: make(map[string]string), orgName: conn.OrgName}
+		for name, value := range conn.Tags {
+			if strings.HasPrefix(name, "source_") || strings.HasPrefix(name, "target_") {
+				p.tags[name] = value
+			}
+		}
+		for _, name := range pairTags {
+			if value, ok := conn.Tags[name]; ok {
+				p.tags[name] = value
+			}
+		}
+		if k.target == conn.Tags["peer_address"] {
+			p.tags["peer_address"] = k.target
+		}
+		ps.entries[k] = p
+	}
+	p.connections++
+	p.retransmits += s.Retransmits
+	p.rttSum += s.RTTSum
+	p.rttCount += s.RTTCount
+	if s.RTTMax > p.rttMax {
+		p.rttMax = s.RTTMax
+	}
+	clientAborts, serverResets := resets(s)
+	p.clientAborts += clientAborts
+	p.serverResets += serverResets
+	p.timeouts += uint64(s.Timeouts)
+}
+
+// report returns the pairs observed since the previous report, the rtts are in nanoseconds.
+func (ps *pairs) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(ps.entries))
+	for _, p := range ps.entries {
+		fields := map[string]interface{}{
+			"connections":   p.connections,
+			"retransmits":   p.retransmits,
+			"client_aborts": p.clientAborts,
+			"server_resets": p.serverResets,
+			"timeouts":      p.timeouts,
+		}
+		if p.rttCount > 0 {
+			fields["rtt_mean"] = int64(p.rttSum/p.rttCount) * 1000
+			fields["rtt_max"] = int64(p.rttMax) * 1000
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			OrgName:     p.orgName,
+			Tags:        p.tags,
+			Fields:      fields,
+		})
+	}
+	ps.entries = make(map[pairKey]*pair)
+	return ans
+}
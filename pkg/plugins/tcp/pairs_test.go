@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestPairs(t *testing.T) {
+	ps := newPairs()
+	conn := func(source, target, peer string) *metric.Metric {
+		m := &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"metric_source": "ebpf",
+			"org_name":      "erda",
+			"peer_address":  peer,
+		}}
+		if source != "" {
+			m.Tags["source_service_instance_id"] = source
+			m.Tags["source_service_name"] = "web"
+		}
+		if target != "" {
+			m.Tags["target_service_instance_id"] = target
+			m.Tags["target_service_name"] = "api"
+		}
+		return m
+	}
+	ps.observe(conn("web-1", "api-1", "10.0.0.2:8080"), &tcpStats{Retransmits: 2, RTTSum: 300, RTTCount: 3, RTTMax: 150})
+	ps.observe(conn("web-1", "api-1", "10.0.0.2:8080"), &tcpStats{RTTSum: 100, RTTCount: 1, RTTMax: 100})
+	ps.observe(conn("web-1", "", "8.8.8.8:443"), &tcpStats{Retransmits: 1})
+	ps.observe(conn("web-1", "", "8.8.4.4:443"), &tcpStats{})
+
+	reported := ps.report(1000)
+	if len(reported) != 2 {
+		t.Fatalf("expected a metric per pair, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurement || m.OrgName != "erda" || m.Tags["source_service_name"] != "web" {
+			t.Errorf("unexpected pair %+v", m)
+		}
+		switch m.Tags["target_service_instance_id"] {
+		case "api-1":
+			if m.Fields["connections"] != uint64(2) || m.Fields["retransmits"] != uint64(2) ||
+				m.Fields["rtt_mean"] != int64(100000) || m.Fields["rtt_max"] != int64(150000) {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+			if _, ok := m.Tags["peer_address"]; ok {
+				t.Error("the pairs of pods are not split by address")
+			}
+		case "":
+			if m.Tags["peer_address"] != "8.8.8.8:443" || m.Fields["retransmits"] != uint64(1) {
+				t.Errorf("unexpected pair %+v", m)
+			}
+			if _, ok := m.Fields["rtt_mean"]; ok {
+				t.Error("no rtt was sampled")
+			}
+		}
+	}
+	if len(ps.report(2000)) != 0 {
+		t.Error("the pairs are reported once")
+	}
+}
+
+func TestPairsCloses(t *testing.T) {
+	ps := newPairs()
+	conn := &metric.Metric{Tags: map[string]string{
+		"source_service_instance_id": "gateway-1",
+		"target_service_instance_id": "api-1",
+		"peer_address":               "10.0.0.2:8080",
+	}}
+	ps.observe(conn, &tcpStats{ResetsSent: 1, Role: roleClient})
+	ps.observe(conn, &tcpStats{ResetsReceived: 2, Role: roleClient})
+	ps.observe(conn, &tcpStats{Timeouts: 1, Role: roleClient})
+
+	reported := ps.report(1000)
+	if len(reported) != 1 {
+		t.Fatalf("the connections closed abnormally are reported, got %v", reported)
+	}
+	fields := reported[0].Fields
+	if fields["connections"] != uint64(3) || fields["client_aborts"] != uint64(1) ||
+		fields["server_resets"] != uint64(2) || fields["timeouts"] != uint64(1) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
@@ -0,0 +1,406 @@
+// Package tcp reports the tcp statistics of the connections between the pods of the node and their peers,
+// so that the degradation of the network is told apart from the slowness of the applications. The
+// smoothed rtt of the sockets (sampled on the segments received by tcp_rcv_established) is counted in
+// kernel by connection, the retransmits and resets are counted from the events of the tcpevents plugin,
+// which owns their kprobes (tcp_retransmit_skb, tcp_send_active_reset, tcp_reset), the connections are
+// reported by pair of pods every TCP_INTERVAL (1m) in application_tcp:
+//
+//	tags     source_* of the pod of the socket, the side retransmitting and measuring the rtt
+//	         target_* of the peer pod, peer_address when the peer is not a pod (resolved through conntrack NAT)
+//	         service_address of the k8s service the peer was connected through (ClusterIP:port)
+//	         metric_source, _meta, _metric_scope, _metric_scope_id, org_name, cluster_name
+//	fields   connections          connections with segments, retransmits, resets or timeouts in the interval
+//	         retransmits          segments retransmitted
+//	         rtt_mean, rtt_max    smoothed rtt of the sockets (ns), averaged over the received segments
+//	         client_aborts        resets sent by the clients (tcp_send_active_reset), e.g. a gateway giving
+//	                              up on a request, or closing with unread data
+//	         server_resets        resets sent by the servers or received by the clients (tcp_reset), e.g.
+//	                              a server closing a keep-alive connection the client still writes to
+//	         timeouts             established connections given up by the retransmission or keepalive timer
+//
+// Both ends of a connection between two pods of the node report it, each as the source of its own
+// statistics. The connections of which neither end is a pod are left out. The role of the socket is
+// recorded as the handshake completes (SYN_SENT or SYN_RECV to ESTABLISHED), the sockets established
+// before the agent started are taken for servers when their port is the lower one.
+//
+// The connects of the pods (kprobes tcp_v4_connect and tcp_v6_connect, until the socket leaves SYN_SENT,
+// tracepoint sock/inet_sock_set_state) are reported by source pod and address dialed in
+// application_tcp_connect:
+//
+//	tags     source_* of the pod connecting
+//	         peer_address of the address dialed, peer_service of its k8s service or pod,
+//	         target_* of the pod when a pod is dialed (e.g. through a headless service)
+//	fields   connects, failures      connects and the ones failing
+//	         refused, timeouts       failures refused by the peer (ECONNREFUSED), without answer (ETIMEDOUT)
+//	         failure_rate            failures per connect
+//	         latency_mean, latency_max (ns)
+//	                                 connect call to established, of the established connects
+//
+// The connects and timeouts of the tracepoint are limited by the event budget of the inet_sock_set_state
+// probe (see the eventbudget package), the ones above it are left out and reported in ebpf_event_budget.
+// The retransmits and resets are limited by the event budget of the tcpevents kprobes the same way.
+//
+// The queues of the sockets the pods listen on (kprobes tcp_conn_request for the SYNs,
+// tcp_v4_syn_recv_sock for the ACKs completing the handshakes, inet_csk_accept for the connections
+// accepted) are reported by pod and port in application_tcp_listen, so that the backlogs too small for
+// the load are caught before the clients time out:
+//
+//	tags     source_* of the listening pod, listen_port
+//	fields   syn_overflows           SYNs received with the SYN queue full, dropped or answered with a syncookie
+//	         accept_overflows        SYNs and handshakes dropped with the accept queue full
+//	         accept_queue_max        deepest accept queue sampled as the connections are accepted
+//	         backlog                 backlog of the socket (listen, capped by net.core.somaxconn)
+//	         accept_queue_usage      accept_queue_max per backlog
+package tcp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/eventbudget"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/tcpevents"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+)
+
+const (
+	programPath = "target/tcp.bpf.o"
+	mapStats    = "tcp_stats_map"
+	mapConnects = "tcp_connect_map"
+	mapListens  = "listen_stats_map"
+	mapRoles    = "conn_role_map"
+
+	// connectsInterval is the interval the connects are read from the kernel, the map of the connects
+	// must not fill up within it.
+	connectsInterval = time.Second
+)
+
+// kprobes maps the attached kernel functions to their programs, the first argument of all of them is the struct sock.
+// The retransmits and resets are the kprobes of the tcpevents plugin.
+var kprobes = map[string]string{
+	"tcp_rcv_established":  "kprobe_tcp_rcv_established",
+	"tcp_v4_connect":       "kprobe_tcp_v4_connect",
+	"tcp_v6_connect":       "kprobe_tcp_v6_connect",
+	"tcp_conn_request":     "kprobe_tcp_conn_request",
+	"tcp_v4_syn_recv_sock": "kprobe_tcp_v4_syn_recv_sock",
+	"inet_csk_accept":      "kprobe_inet_csk_accept",
+}
+
+// budgetProbes maps the tracepoint to its event budget id, SET_STATE_PROBE of ebpf/plugins/tcp/main.c
+var budgetProbes = map[string]uint32{
+	"inet_sock_set_state": 0,
+}
+
+type Config struct {
+	Interval time.Duration `env:"TCP_INTERVAL" default:"1m"`
+}
+
+// tcpConn mirrors struct conn_key_t of ebpf/plugins/tcp/main.c, the local end is the source.
+type tcpConn struct {
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+}
+
+// tcpStats mirrors struct tcp_stats_t of ebpf/plugins/tcp/main.c, the rtts are in microseconds. The
+// retransmits and resets are counted from the tcpevents events, see anomalies.
+type tcpStats struct {
+	Retransmits uint64
+	RTTSum      uint64
+	RTTCount    uint64
+	RTTMax      uint32
+	// ResetsSent, ResetsReceived and Timeouts are the abnormal closes, Role the role of the socket.
+	ResetsSent     uint32
+	ResetsReceived uint32
+	Timeouts       uint32
+	Role           uint8
+	Pad            [7]byte
+	// Cookie is the socket cookie of the connection, 0 until it is generated.
+	Cookie uint64
+}
+
+type provider struct {
+	Log logs.Logger
+
+	cfg          Config
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	tcpEvents    tcpevents.Interface
+	collection   *ebpf.Collection
+	roles        *ebpf.Map
+	links        []link.Link
+	budget       *eventbudget.Guard
+	pairs        *pairs
+	connects     *connects
+	listens      *listens
+	anomalies    *anomalies
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.tcpEvents = ctx.Service("tcpevents").(tcpevents.Interface)
+	p.pairs = newPairs()
+	p.connects = newConnects()
+	p.listens = newListens()
+	p.anomalies = newAnomalies(p.role)
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	if err := p.load(); err != nil {
+		p.Log.Errorf("failed to load tcp ebpf program, err: %v", err)
+		return
+	}
+	if err := p.tcpEvents.Subscribe(p.anomalies.observe); err != nil {
+		p.Log.Warnf("failed to subscribe to the tcp events, the retransmits and resets are not counted, err: %v", err)
+	}
+	c <- kprobe.WaitSynced(p.kprobeHelper, "tcp")
+	stats := p.collection.DetachMap(mapStats)
+	connects := p.collection.DetachMap(mapConnects)
+	listens := p.collection.DetachMap(mapListens)
+	ticker := p.clock.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	connectsTicker := p.clock.NewTicker(connectsInterval)
+	defer connectsTicker.Stop()
+	for {
+		select {
+		case <-connectsTicker.C():
+			var (
+				key uint64
+				val tcpConnect
+			)
+			for connects.Iterate().Next(&key, &val) {
+				if conn, ok := p.destination(&val); ok {
+					p.connects.observe(conn, &val)
+				}
+				if err := connects.Delete(key); err != nil {
+					p.Log.Errorf("delete map error: %v", err)
+				}
+			}
+		case <-ticker.C():
+			var (
+				key tcpConn
+				val tcpStats
+			)
+			conns := make(map[tcpConn]tcpStats)
+			for stats.Iterate().Next(&key, &val) {
+				conns[key] = val
+				if err := stats.Delete(key); err != nil {
+					p.Log.Errorf("delete map error: %v", err)
+				}
+			}
+			p.anomalies.merge(conns)
+			for key, val := range conns {
+				if conn, ok := p.conn(key); ok {
+					conn.SocketCookie = val.Cookie
+					resolveRole(key, &val)
+					p.pairs.observe(conn, &val)
+				}
+			}
+			var (
+				listenKey   listenKey
+				listenStats listenStats
+			)
+			for listens.Iterate().Next(&listenKey, &listenStats) {
+				if pod, ok := p.listener(listenKey); ok {
+					p.listens.observe(pod, listenKey.Port, &listenStats)
+				}
+				if err := listens.Delete(listenKey); err != nil {
+					p.Log.Errorf("delete map error: %v", err)
+				}
+			}
+			now := p.clock.Now().UnixNano()
+			for _, m := range p.pairs.report(now) {
+				c <- m
+			}
+			for _, m := range p.connects.report(now) {
+				c <- m
+			}
+			for _, m := range p.listens.report(now) {
+				c <- m
+			}
+			p.reportBudget(c)
+		}
+	}
+}
+
+func (p *provider) reportBudget(c chan *metric.Metric) {
+	metrics, err := p.budget.Metrics(p.clock.Now().UnixNano())
+	if err != nil {
+		p.Log.Errorf("failed to read event budget, err: %v", err)
+		return
+	}
+	for _, m := range metrics {
+		p.Log.Warnf("%v events of tracepoint(%s) dropped by the event budget", m.Fields["dropped_count"], m.Tags["probe"])
+		c <- m
+	}
+}
+
+// role returns the role of the socket of a connection recorded by the kernel, roleUnknown if it was
+// established before the agent started.
+func (p *provider) role(k tcpConn) uint8 {
+	role := roleUnknown
+	if p.roles != nil {
+		_ = p.roles.Lookup(k, &role)
+	}
+	return role
+}
+
+// conn returns the tags of the pods of a connection, false if neither end is a pod.
+func (p *provider) conn(k tcpConn) (*metric.Metric, bool) {
+	localIP := net.IP(k.SourceIP[:]).String()
+	remoteIP, remotePort := net.IP(k.DestIP[:]).String(), k.DestPort
+	var serviceAddress string
+	// the sockets of the clients of a service are connected to its address.
+	if natInfo, ok := p.netNatHelper.GetNatInfo(localIP, k.SourcePort); ok {
+		remoteIP, remotePort = natInfo.ReplyDstIP, natInfo.ReplyDstPort
+		serviceAddress = natInfo.ServiceAddress()
+	}
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+		"peer_address":  fmt.Sprintf("%s:%d", remoteIP, remotePort),
+	}}
+	if serviceAddress != "" {
+		m.Tags["service_address"] = serviceAddress
+	}
+	local, localErr := p.kprobeHelper.GetPodByUID(localIP)
+	if localErr == nil {
+		enrich.PodTags(m, "source", local)
+	}
+	remote, remoteErr := p.kprobeHelper.GetPodByUID(remoteIP)
+	if remoteErr == nil {
+		enrich.PodTags(m, "target", remote)
+	}
+	return m, localErr == nil || remoteErr == nil
+}
+
+// destination returns the tags of the source pod and of the address dialed of a connect, false if
+// neither is a pod or a service.
+func (p *provider) destination(c *tcpConnect) (*metric.Metric, bool) {
+	sourceIP, destIP := net.IP(c.SourceIP[:]), net.IP(c.DestIP[:])
+	if c.Family == syscall.AF_INET {
+		sourceIP, destIP = sourceIP[:4], destIP[:4]
+	}
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+		"peer_address":  net.JoinHostPort(destIP.String(), strconv.Itoa(int(c.DestPort))),
+	}}
+	source, sourceErr := p.kprobeHelper.GetPodByUID(sourceIP.String())
+	if sourceErr == nil {
+		enrich.PodTags(m, "source", source)
+	}
+	if svc, err := p.kprobeHelper.GetService(destIP.String()); err == nil {
+		m.Tags["peer_service"] = svc.Name
+		return m, true
+	}
+	if target, err := p.kprobeHelper.GetPodByUID(destIP.String()); err == nil {
+		enrich.PodTags(m, "target", target)
+		m.Tags["peer_service"] = target.Annotations["msp.erda.cloud/service_name"]
+		return m, true
+	}
+	return m, sourceErr == nil
+}
+
+// listener returns the tags of the pod of a listening socket, false if it is not a pod.
+func (p *provider) listener(k listenKey) (*metric.Metric, bool) {
+	pod, err := p.kprobeHelper.GetPodByUID(net.IP(k.Addr[:]).String())
+	if err != nil {
+		return nil, false
+	}
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+	}}
+	enrich.PodTags(m, "source", pod)
+	return m, true
+}
+
+func (p *provider) load() error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	p.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	p.budget, err = eventbudget.Apply(p.collection, "tcp", budgetProbes)
+	if err != nil {
+		return err
+	}
+	p.roles = p.collection.DetachMap(mapRoles)
+	for symbol, name := range kprobes {
+		prog := p.collection.DetachProgram(name)
+		if prog == nil {
+			p.Log.Warnf("program %s not found", name)
+			continue
+		}
+		l, err := link.Kprobe(symbol, prog, nil)
+		if err != nil {
+			p.Log.Warnf("failed to attach kprobe(%s): %v", symbol, err)
+			continue
+		}
+		p.links = append(p.links, l)
+	}
+	l, err := link.Tracepoint("sock", "inet_sock_set_state", p.collection.DetachProgram("tracepoint_inet_sock_set_state"), nil)
+	if err != nil {
+		p.Log.Warnf("failed to attach tracepoint(sock/inet_sock_set_state), the connects and timeouts are not reported: %v", err)
+		return nil
+	}
+	p.links = append(p.links, l)
+	return nil
+}
+
+func (p *provider) Close() error {
+	for _, l := range p.links {
+		l.Close()
+	}
+	if p.roles != nil {
+		p.roles.Close()
+	}
+	if p.collection != nil {
+		p.collection.Close()
+	}
+	return nil
+}
+
+func init() {
+	servicehub.Register("tcp", &servicehub.Spec{
+		Services:             []string{"tcp"},
+		Description:          "ebpf for tcp retransmits, rtt, connects and listen queues of pods",
+		Dependencies:         []string{"kprobe", "netfilter", "tcpevents"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
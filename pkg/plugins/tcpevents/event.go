@@ -0,0 +1,116 @@
+package tcpevents
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+type EventType uint8
+
+const (
+	EventRetransmit    EventType = 1
+	EventZeroWindow    EventType = 2
+	EventResetSent     EventType = 3
+	EventResetReceived EventType = 4
+)
+
+var eventNames = map[EventType]string{
+	EventRetransmit:    "tcp.retransmit",
+	EventZeroWindow:    "tcp.zero_window",
+	EventResetSent:     "tcp.reset_sent",
+	EventResetReceived: "tcp.reset_received",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventNames[t]; ok {
+		return name
+	}
+	return "tcp.unknown"
+}
+
+// tcpEvent mirrors struct tcp_event_t of ebpf/plugins/tcpevents/main.c
+type tcpEvent struct {
+	Timestamp  uint64
+	SourceIP   [4]byte
+	DestIP     [4]byte
+	SourcePort uint16
+	DestPort   uint16
+	Type       uint8
+	State      uint8
+	Pad        uint16
+	Cookie     uint64
+}
+
+// Event is an anomaly observed on a connection, LocalIP/LocalPort is the side the kernel acted on,
+// e.g. the side that retransmitted or sent the reset.
+type Event struct {
+	Type       EventType
+	Timestamp  int64
+	LocalIP    string
+	LocalPort  uint16
+	RemoteIP   string
+	RemotePort uint16
+	// State is the kernel tcp state (TCP_ESTABLISHED = 1 ...) when the event happened.
+	State uint8
+	// SocketCookie is the socket cookie of the local side, 0 if it was never generated.
+	SocketCookie uint64
+}
+
+// Attributes returns the event attributes in the form attached to span events.
+func (e *Event) Attributes() map[string]string {
+	return map[string]string{
+		"local_address":  net.JoinHostPort(e.LocalIP, strconv.Itoa(int(e.LocalPort))),
+		"remote_address": net.JoinHostPort(e.RemoteIP, strconv.Itoa(int(e.RemotePort))),
+		"tcp_state":      strconv.Itoa(int(e.State)),
+	}
+}
+
+// SpanEvents returns the anomalies of the connection of the request m during the request as the events
+// of its span, the connection is the socket of the pod end (metric.Metric.SocketCookie).
+func SpanEvents(i Interface, m *metric.Metric) []metric.Event {
+	if m.SocketCookie == 0 {
+		return nil
+	}
+	end := m.CaptureTime
+	if end == 0 {
+		end = m.Timestamp
+	}
+	var ans []metric.Event
+	for _, e := range i.EventsByCookie(m.SocketCookie, end-elapsed(m), end) {
+		ans = append(ans, metric.Event{Name: e.Type.String(), Timestamp: e.Timestamp, Attributes: e.Attributes()})
+	}
+	return ans
+}
+
+func elapsed(m *metric.Metric) int64 {
+	switch n := m.Fields["elapsed_sum"].(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+func (e *Event) String() string {
+	return fmt.Sprintf("%s [%s:%d] <-> [%s:%d] state %d", e.Type, e.LocalIP, e.LocalPort, e.RemoteIP, e.RemotePort, e.State)
+}
+
+// connKey is independent of the direction, both sides of a connection map to the same key.
+func connKey(ip1 string, port1 uint16, ip2 string, port2 uint16) string {
+	a := net.JoinHostPort(ip1, strconv.Itoa(int(port1)))
+	b := net.JoinHostPort(ip2, strconv.Itoa(int(port2)))
+	if a > b {
+		a, b = b, a
+	}
+	return a + "-" + b
+}
@@ -0,0 +1,284 @@
+package tcpevents
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/sys/unix"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/eventbudget"
+)
+
+const (
+	programPath = "target/tcpevents.bpf.o"
+	mapEvents   = "tcp_events_map"
+
+	// maxEventsPerConn bounds the memory of connections stuck in a retransmit loop.
+	maxEventsPerConn = 32
+	eventRetention   = 2 * time.Minute
+	// budgetReportInterval is the interval of the ebpf_event_budget metrics of dropped events.
+	budgetReportInterval = 30 * time.Second
+)
+
+// kprobes maps the attached kernel functions to their programs, the first argument of all of them is the struct sock.
+var kprobes = map[string]string{
+	"tcp_retransmit_skb":    "kprobe_tcp_retransmit_skb",
+	"tcp_send_probe0":       "kprobe_tcp_send_probe0",
+	"tcp_send_active_reset": "kprobe_tcp_send_active_reset",
+	"tcp_reset":             "kprobe_tcp_reset",
+}
+
+// budgetProbes maps the attached kernel functions to their event budget ids, the TCP_EVENT_* type of ebpf/plugins/tcpevents/main.c
+var budgetProbes = map[string]uint32{
+	"tcp_retransmit_skb":    uint32(EventRetransmit),
+	"tcp_send_probe0":       uint32(EventZeroWindow),
+	"tcp_send_active_reset": uint32(EventResetSent),
+	"tcp_reset":             uint32(EventResetReceived),
+}
+
+// Interface provides the tcp anomalies (retransmits, zero window stalls and resets) observed on the node,
+// so that request spans can carry them as span events, see SpanEvents.
+type Interface interface {
+	// Events returns the anomalies of the connection in [start, end] (unix nano), oldest first.
+	// The connection may be given in either direction.
+	Events(srcIP string, srcPort uint16, dstIP string, dstPort uint16, start, end int64) []Event
+	// EventsByCookie returns the anomalies of the socket of cookie in [start, end] (unix nano), oldest first.
+	EventsByCookie(cookie uint64, start, end int64) []Event
+	// Subscribe passes the anomalies to handle as they are read from the kernel, the kprobes are loaded by
+	// the first subscriber if the plugin does not gather, e.g. for the tcp plugin counting them.
+	Subscribe(handle func(*Event)) error
+}
+
+type connEvents struct {
+	sync.Mutex
+	events []Event
+}
+
+type provider struct {
+	Log logs.Logger
+
+	once     sync.Once
+	startErr error
+	mu       sync.Mutex
+	handlers []func(*Event)
+
+	collection *ebpf.Collection
+	links      []link.Link
+	budget     *eventbudget.Guard
+	events     *cache.Cache
+	// cookies are the events of the local sockets by their cookie.
+	cookies *cache.Cache
+	// bootOffset converts bpf_ktime_get_ns (CLOCK_MONOTONIC) to unix nano.
+	bootOffset int64
+	clock      clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	p.clock = clock.Real
+	p.events = cache.New(eventRetention, 30*time.Second)
+	p.cookies = cache.New(eventRetention, 30*time.Second)
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return err
+	}
+	p.bootOffset = p.clock.Now().UnixNano() - ts.Nano()
+	return nil
+}
+
+func (p *provider) Events(srcIP string, srcPort uint16, dstIP string, dstPort uint16, start, end int64) []Event {
+	return between(p.events, connKey(srcIP, srcPort, dstIP, dstPort), start, end)
+}
+
+func (p *provider) EventsByCookie(cookie uint64, start, end int64) []Event {
+	if cookie == 0 {
+		return nil
+	}
+	return between(p.cookies, strconv.FormatUint(cookie, 10), start, end)
+}
+
+func between(c *cache.Cache, key string, start, end int64) []Event {
+	v, ok := c.Get(key)
+	if !ok {
+		return nil
+	}
+	ce := v.(*connEvents)
+	ce.Lock()
+	defer ce.Unlock()
+	var ans []Event
+	for _, e := range ce.events {
+		if e.Timestamp >= start && e.Timestamp <= end {
+			ans = append(ans, e)
+		}
+	}
+	return ans
+}
+
+func (p *provider) Subscribe(handle func(*Event)) error {
+	p.mu.Lock()
+	p.handlers = append(p.handlers, handle)
+	p.mu.Unlock()
+	return p.start()
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	if err := p.start(); err != nil {
+		p.Log.Errorf("failed to load tcp events ebpf program, err: %v", err)
+		return
+	}
+	ticker := p.clock.NewTicker(budgetReportInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		p.reportBudget(c)
+	}
+}
+
+// start loads the kprobes and reads their events once for the plugin and the subscribers.
+func (p *provider) start() error {
+	p.once.Do(func() {
+		if p.startErr = p.load(); p.startErr == nil {
+			go p.read(p.collection.DetachMap(mapEvents))
+		}
+	})
+	return p.startErr
+}
+
+func (p *provider) read(m *ebpf.Map) {
+	var (
+		key uint64
+		val tcpEvent
+	)
+	for {
+		batch := make([]tcpEvent, 0)
+		for m.Iterate().Next(&key, &val) {
+			batch = append(batch, val)
+			if err := m.Delete(key); err != nil {
+				p.Log.Errorf("delete map error: %v", err)
+			}
+		}
+		sort.Slice(batch, func(i, j int) bool {
+			return batch[i].Timestamp < batch[j].Timestamp
+		})
+		p.mu.Lock()
+		handlers := p.handlers
+		p.mu.Unlock()
+		for i := range batch {
+			e := p.add(&batch[i])
+			for _, handle := range handlers {
+				handle(&e)
+			}
+		}
+		p.clock.Sleep(1 * time.Second)
+	}
+}
+
+func (p *provider) load() error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	p.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+	p.budget, err = eventbudget.Apply(p.collection, "tcpevents", budgetProbes)
+	if err != nil {
+		return err
+	}
+	for symbol, name := range kprobes {
+		prog := p.collection.DetachProgram(name)
+		if prog == nil {
+			p.Log.Warnf("program %s not found", name)
+			continue
+		}
+		// some of the symbols are inlined by the compiler on a few kernels, the others still work without them.
+		l, err := link.Kprobe(symbol, prog, nil)
+		if err != nil {
+			p.Log.Warnf("failed to attach kprobe(%s): %v", symbol, err)
+			continue
+		}
+		p.links = append(p.links, l)
+	}
+	return nil
+}
+
+func (p *provider) reportBudget(c chan *metric.Metric) {
+	metrics, err := p.budget.Metrics(p.clock.Now().UnixNano())
+	if err != nil {
+		p.Log.Errorf("failed to read event budget, err: %v", err)
+		return
+	}
+	for _, m := range metrics {
+		p.Log.Warnf("%v events of kprobe(%s) dropped by the event budget", m.Fields["dropped_count"], m.Tags["probe"])
+		c <- m
+	}
+}
+
+func (p *provider) add(raw *tcpEvent) Event {
+	e := Event{
+		Type:         EventType(raw.Type),
+		Timestamp:    int64(raw.Timestamp) + p.bootOffset,
+		LocalIP:      net.IP(raw.SourceIP[:]).String(),
+		LocalPort:    raw.SourcePort,
+		RemoteIP:     net.IP(raw.DestIP[:]).String(),
+		RemotePort:   raw.DestPort,
+		State:        raw.State,
+		SocketCookie: raw.Cookie,
+	}
+	record(p.events, connKey(e.LocalIP, e.LocalPort, e.RemoteIP, e.RemotePort), e)
+	if e.SocketCookie != 0 {
+		record(p.cookies, strconv.FormatUint(e.SocketCookie, 10), e)
+	}
+	return e
+}
+
+func record(c *cache.Cache, key string, e Event) {
+	v, ok := c.Get(key)
+	if !ok {
+		v = &connEvents{}
+	}
+	ce := v.(*connEvents)
+	ce.Lock()
+	ce.events = append(ce.events, e)
+	if len(ce.events) > maxEventsPerConn {
+		ce.events = ce.events[len(ce.events)-maxEventsPerConn:]
+	}
+	ce.Unlock()
+	c.Set(key, ce, cache.DefaultExpiration)
+}
+
+func (p *provider) Close() error {
+	for _, l := range p.links {
+		l.Close()
+	}
+	if p.collection != nil {
+		p.collection.Close()
+	}
+	return nil
+}
+
+func init() {
+	servicehub.Register("tcpevents", &servicehub.Spec{
+		Services:     []string{"tcpevents"},
+		Description:  "ebpf for tcp retransmits, zero window stalls and resets",
+		Dependencies: []string{},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,52 @@
+package tcpevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestSpanEvents(t *testing.T) {
+	p := &provider{
+		events:  cache.New(eventRetention, 30*time.Second),
+		cookies: cache.New(eventRetention, 30*time.Second),
+	}
+	for _, raw := range []tcpEvent{
+		{Timestamp: 900, SourceIP: [4]byte{10, 0, 0, 2}, DestIP: [4]byte{10, 0, 0, 3}, SourcePort: 8080, DestPort: 40000,
+			Type: uint8(EventRetransmit), State: 1, Cookie: 7},
+		{Timestamp: 1600, SourceIP: [4]byte{10, 0, 0, 2}, DestIP: [4]byte{10, 0, 0, 3}, SourcePort: 8080, DestPort: 40000,
+			Type: uint8(EventRetransmit), State: 1, Cookie: 7},
+		{Timestamp: 1900, SourceIP: [4]byte{10, 0, 0, 2}, DestIP: [4]byte{10, 0, 0, 3}, SourcePort: 8080, DestPort: 40000,
+			Type: uint8(EventResetReceived), State: 1, Cookie: 7},
+		// another socket of the node.
+		{Timestamp: 1700, SourceIP: [4]byte{10, 0, 0, 4}, DestIP: [4]byte{10, 0, 0, 5}, SourcePort: 80, DestPort: 50000,
+			Type: uint8(EventResetSent), Cookie: 8},
+	} {
+		raw := raw
+		p.add(&raw)
+	}
+
+	m := &metric.Metric{Timestamp: 3000, CaptureTime: 2000, SocketCookie: 7, Fields: map[string]interface{}{"elapsed_sum": uint64(1000)}}
+	events := SpanEvents(p, m)
+	if len(events) != 2 {
+		t.Fatalf("expected the events of the socket during the request, got %+v", events)
+	}
+	if events[0].Name != "tcp.retransmit" || events[0].Timestamp != 1600 ||
+		events[0].Attributes["local_address"] != "10.0.0.2:8080" || events[0].Attributes["remote_address"] != "10.0.0.3:40000" {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	if events[1].Name != "tcp.reset_received" {
+		t.Errorf("unexpected event %+v", events[1])
+	}
+
+	if events := p.Events("10.0.0.3", 40000, "10.0.0.2", 8080, 0, 2000); len(events) != 3 {
+		t.Errorf("the events should be found by the connection in either direction, got %+v", events)
+	}
+	m.SocketCookie = 0
+	if events := SpanEvents(p, m); events != nil {
+		t.Errorf("the requests without socket cookie have no events, got %+v", events)
+	}
+}
@@ -0,0 +1,173 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/pkg/utils"
+)
+
+const (
+	programPath = "target/throughput.bpf.o"
+	programName = "socket__throughput_filter"
+	mapFilter   = "filter_map"
+	mapCounters = "counters_map"
+	mapFlows    = "flows_map"
+	mapWindow   = "window_map"
+
+	// indexes of counters_map.
+	counterSent     = uint32(0)
+	counterReceived = uint32(1)
+)
+
+type Interface interface {
+	Load() error
+	// Counters returns the counters of the pod summed over the cpus, they only grow. The window of the
+	// counters starts over at every call.
+	Counters() (Counters, error)
+	// Flows returns the flows of the pod with packets since the previous call, their counters start over.
+	Flows() []Flow
+	Close() error
+}
+
+type provider struct {
+	ifIndex   int
+	ipAddress string
+
+	collection *ebpf.Collection
+	counters   *ebpf.Map
+	flows      *ebpf.Map
+	window     *ebpf.Map
+	// current is the window counted in kernel, see window_map.
+	current uint64
+	fd      int
+	sock    int
+}
+
+const (
+	SO_ATTACH_BPF = 0x32                     // 50
+	SO_DETACH_BPF = syscall.SO_DETACH_FILTER // 27
+)
+
+func New(ifIndex int, ip string) Interface {
+	return &provider{
+		ifIndex:   ifIndex,
+		ipAddress: ip,
+	}
+}
+
+func (e *provider) Load() error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	e.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+
+	program := e.collection.DetachProgram(programName)
+	if program == nil {
+		return fmt.Errorf("detach program %s failed", programName)
+	}
+	e.fd = program.FD()
+
+	e.sock, err = utils.OpenRawSock(e.ifIndex)
+	if err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(e.sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, program.FD()); err != nil {
+		return err
+	}
+
+	if err := e.collection.DetachMap(mapFilter).Put(
+		utils.Htonl(utils.IP4toDec(e.ipAddress)), uint32(0),
+	); err != nil {
+		return err
+	}
+	e.counters = e.collection.DetachMap(mapCounters)
+	e.flows = e.collection.DetachMap(mapFlows)
+	e.window = e.collection.DetachMap(mapWindow)
+	return nil
+}
+
+func (e *provider) Counters() (Counters, error) {
+	var (
+		ans         Counters
+		sent, recvd uint64
+		err         error
+	)
+	if ans.Sent, sent, err = e.sum(counterSent); err != nil {
+		return Counters{}, err
+	}
+	if ans.Received, recvd, err = e.sum(counterReceived); err != nil {
+		return Counters{}, err
+	}
+	ans.WindowStart = sent
+	if recvd != 0 && (sent == 0 || recvd < sent) {
+		ans.WindowStart = recvd
+	}
+	// the packets counted from now on start the next window.
+	e.current++
+	if err := e.window.Put(uint32(0), e.current); err != nil {
+		return Counters{}, err
+	}
+	return ans, nil
+}
+
+// sum returns a counter summed over the cpus and the start of its current window.
+func (e *provider) sum(index uint32) (Counter, uint64, error) {
+	var (
+		perCPU []Counter
+		ans    Counter
+	)
+	if err := e.counters.Lookup(index, &perCPU); err != nil {
+		return Counter{}, 0, err
+	}
+	for _, c := range perCPU {
+		ans.Packets += c.Packets
+		ans.Bytes += c.Bytes
+	}
+	return ans, windowStart(e.current, perCPU), nil
+}
+
+func (e *provider) Flows() []Flow {
+	var (
+		key FlowKey
+		val FlowStats
+		ans []Flow
+	)
+	for e.flows.Iterate().Next(&key, &val) {
+		ans = append(ans, Flow{Key: key, Stats: val})
+		if err := e.flows.Delete(key); err != nil {
+			klog.Errorf("delete map error: %v", err)
+		}
+	}
+	return ans
+}
+
+func (e *provider) Close() error {
+	_ = syscall.SetsockoptInt(e.sock, syscall.SOL_SOCKET, SO_DETACH_BPF, e.fd)
+	_ = syscall.Close(e.sock)
+	if e.counters != nil {
+		e.counters.Close()
+	}
+	if e.flows != nil {
+		e.flows.Close()
+	}
+	if e.window != nil {
+		e.window.Close()
+	}
+	e.collection.Close()
+	return nil
+}
@@ -0,0 +1,69 @@
+package ebpf
+
+// Counter mirrors throughput_counter_t of ebpf/plugins/throughput/main.c, the bytes are the bytes of the
+// ip packets.
+type Counter struct {
+	Packets     uint64
+	Bytes       uint64
+	Window      uint64
+	WindowStart uint64
+}
+
+// Counters are the counters of the pod of a veth since the program was attached.
+type Counters struct {
+	Sent     Counter
+	Received Counter
+	// WindowStart is the bpf_ktime_get_ns of the first packet since the previous reading, 0 without packet.
+	WindowStart uint64
+}
+
+// Sub returns the counters since prev.
+func (c Counters) Sub(prev Counters) Counters {
+	return Counters{
+		Sent:     Counter{Packets: c.Sent.Packets - prev.Sent.Packets, Bytes: c.Sent.Bytes - prev.Sent.Bytes},
+		Received: Counter{Packets: c.Received.Packets - prev.Received.Packets, Bytes: c.Received.Bytes - prev.Received.Bytes},
+	}
+}
+
+// Less reports whether a counter of c is below the one of other, e.g. after the program was attached again.
+func (c Counters) Less(other Counters) bool {
+	return c.Sent.Packets < other.Sent.Packets || c.Sent.Bytes < other.Sent.Bytes ||
+		c.Received.Packets < other.Received.Packets || c.Received.Bytes < other.Received.Bytes
+}
+
+// FlowKey mirrors throughput_flow_key of ebpf/plugins/throughput/main.c, the pod is the pod of the veth.
+type FlowKey struct {
+	PodIP    [4]byte
+	PeerIP   [4]byte
+	PodPort  uint16
+	PeerPort uint16
+}
+
+// FlowStats mirrors throughput_flow_t of ebpf/plugins/throughput/main.c, the bytes are the bytes of the
+// ip packets.
+type FlowStats struct {
+	PacketsSent     uint64
+	BytesSent       uint64
+	PacketsReceived uint64
+	BytesReceived   uint64
+	// WindowStart is the bpf_ktime_get_ns of the first packet since the previous read.
+	WindowStart uint64
+}
+
+// Flow is a tcp or udp flow of the pod with its counters since the previous read.
+type Flow struct {
+	Key   FlowKey
+	Stats FlowStats
+}
+
+// windowStart returns the earliest start of the counters of window over the cpus, 0 if none counted a packet
+// in it.
+func windowStart(window uint64, perCPU []Counter) uint64 {
+	var ans uint64
+	for _, c := range perCPU {
+		if c.Window == window && c.WindowStart != 0 && (ans == 0 || c.WindowStart < ans) {
+			ans = c.WindowStart
+		}
+	}
+	return ans
+}
@@ -0,0 +1,18 @@
+package ebpf
+
+import "testing"
+
+func TestWindowStart(t *testing.T) {
+	perCPU := []Counter{
+		{Packets: 3, Window: 4, WindowStart: 900},
+		{Packets: 1, Window: 5, WindowStart: 1200},
+		{Packets: 2, Window: 5, WindowStart: 1100},
+		{Packets: 0},
+	}
+	if got := windowStart(5, perCPU); got != 1100 {
+		t.Errorf("expected the earliest start of the current window, got %d", got)
+	}
+	if got := windowStart(6, perCPU); got != 0 {
+		t.Errorf("a window without packets has no start, got %d", got)
+	}
+}
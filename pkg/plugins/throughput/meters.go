@@ -0,0 +1,78 @@
+package throughput
+
+import (
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/throughput/ebpf"
+)
+
+const (
+	measurementReceived = "pod_network_rx"
+	measurementSent     = "pod_network_tx"
+)
+
+type reading struct {
+	counters ebpf.Counters
+	at       time.Time
+}
+
+// meters turns the counters of the veths, which only grow, into the throughput between two readings.
+type meters struct {
+	last map[int]reading
+}
+
+func newMeters() *meters {
+	return &meters{last: make(map[int]reading)}
+}
+
+// observe returns the received and sent metrics of the pod of a veth since its previous reading, pod
+// carries the tags of the pod. The first reading of a veth, or the one after its counters started over,
+// only sets the baseline. The metrics are stamped with the first packet of the window counted in kernel,
+// with the previous reading if none, not with the reading, which may be delayed.
+func (ms *meters) observe(index int, pod *metric.Metric, c ebpf.Counters, now time.Time) []*metric.Metric {
+	prev, ok := ms.last[index]
+	ms.last[index] = reading{counters: c, at: now}
+	if !ok || c.Less(prev.counters) {
+		return nil
+	}
+	elapsed := now.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+	d := c.Sub(prev.counters)
+	timestamp := prev.at.UnixNano()
+	if c.WindowStart != 0 {
+		timestamp = clock.FromKtime(c.WindowStart)
+	}
+	return []*metric.Metric{
+		meter(measurementReceived, pod, d.Received, elapsed, timestamp),
+		meter(measurementSent, pod, d.Sent, elapsed, timestamp),
+	}
+}
+
+// forget drops the baseline of a veth, e.g. once it is deleted.
+func (ms *meters) forget(index int) {
+	delete(ms.last, index)
+}
+
+func meter(measurement string, pod *metric.Metric, c ebpf.Counter, elapsed float64, timestamp int64) *metric.Metric {
+	tags := make(map[string]string, len(pod.Tags))
+	for k, v := range pod.Tags {
+		tags[k] = v
+	}
+	return &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   timestamp,
+		OrgName:     pod.OrgName,
+		Tags:        tags,
+		Fields: map[string]interface{}{
+			"bytes":              c.Bytes,
+			"packets":            c.Packets,
+			"bytes_per_second":   float64(c.Bytes) / elapsed,
+			"packets_per_second": float64(c.Packets) / elapsed,
+		},
+	}
+}
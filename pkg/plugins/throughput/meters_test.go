@@ -0,0 +1,57 @@
+package throughput
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/throughput/ebpf"
+)
+
+func TestMeters(t *testing.T) {
+	ms := newMeters()
+	pod := &metric.Metric{OrgName: "erda", Tags: map[string]string{"source_service_instance_id": "api-1"}}
+	start := time.Unix(1000, 0)
+	counters := func(sent, received uint64) ebpf.Counters {
+		return ebpf.Counters{
+			Sent:     ebpf.Counter{Packets: sent, Bytes: sent * 100},
+			Received: ebpf.Counter{Packets: received, Bytes: received * 1000},
+		}
+	}
+
+	if got := ms.observe(3, pod, counters(10, 20), start); got != nil {
+		t.Fatalf("the first reading is the baseline, got %v", got)
+	}
+	got := ms.observe(3, pod, counters(40, 80), start.Add(30*time.Second))
+	if len(got) != 2 {
+		t.Fatalf("expected rx and tx, got %v", got)
+	}
+	rx, tx := got[0], got[1]
+	if rx.Measurement != measurementReceived || rx.Fields["packets"] != uint64(60) || rx.Fields["bytes_per_second"] != float64(2000) {
+		t.Errorf("unexpected rx %+v", rx)
+	}
+	if tx.Measurement != measurementSent || tx.Fields["bytes"] != uint64(3000) || tx.Fields["packets_per_second"] != float64(1) {
+		t.Errorf("unexpected tx %+v", tx)
+	}
+	if tx.OrgName != "erda" || tx.Tags["source_service_instance_id"] != "api-1" {
+		t.Errorf("unexpected tags %+v", tx)
+	}
+	if rx.Timestamp != start.UnixNano() || tx.Timestamp != start.UnixNano() {
+		t.Errorf("a window without a start in kernel is stamped with the previous reading, got %d", tx.Timestamp)
+	}
+	c := counters(50, 90)
+	c.WindowStart = 5e9
+	got = ms.observe(3, pod, c, start.Add(45*time.Second))
+	if len(got) != 2 || got[0].Timestamp != clock.FromKtime(5e9) || got[1].Timestamp != clock.FromKtime(5e9) {
+		t.Errorf("the window is stamped with its first packet in kernel, got %v", got)
+	}
+
+	if got := ms.observe(3, pod, counters(5, 5), start.Add(time.Minute)); got != nil {
+		t.Errorf("the counters started over are a new baseline, got %v", got)
+	}
+	ms.forget(3)
+	if got := ms.observe(3, pod, counters(50, 50), start.Add(2*time.Minute)); got != nil {
+		t.Errorf("a forgotten veth starts with a baseline, got %v", got)
+	}
+}
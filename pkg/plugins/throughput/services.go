@@ -0,0 +1,107 @@
+package throughput
+
+import (
+	"strings"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/throughput/ebpf"
+)
+
+const measurementServices = "application_service_traffic"
+
+// serviceTags are the tags of the service pairs besides the source_* and target_* ones, the instances
+// are left out since the pairs aggregate them.
+var serviceTags = []string{"metric_source", "_meta", "_metric_scope", "_metric_scope_id", "org_name", "cluster_name", "peer_service"}
+
+type servicePairKey struct {
+	source string
+	target string
+}
+
+type servicePair struct {
+	tags            map[string]string
+	orgName         string
+	flows           uint64
+	packetsSent     uint64
+	bytesSent       uint64
+	packetsReceived uint64
+	bytesReceived   uint64
+	// windowStart is the earliest first packet of the flows, bpf_ktime_get_ns.
+	windowStart uint64
+}
+
+// services aggregates the flows between two reports by pair of services, the traffic matrix of the node.
+type services struct {
+	entries map[servicePairKey]*servicePair
+}
+
+func newServices() *services {
+	return &services{entries: make(map[servicePairKey]*servicePair)}
+}
+
+// observe adds the counters of a flow to its pair, flow carries the tags of the pod and of the peer. The
+// peers that are not pods are keyed by their k8s service, otherwise by peer_address.
+func (ss *services) observe(flow *metric.Metric, s *ebpf.FlowStats) {
+	k := servicePairKey{source: flow.Tags["source_service_id"], target: flow.Tags["target_service_id"]}
+	if k.target == "" {
+		k.target = flow.Tags["peer_service"]
+	}
+	if k.target == "" {
+		k.target = flow.Tags["peer_address"]
+	}
+	p, ok := ss.entries[k]
+	if !ok {
+		p = &servicePair{tags: make(map[string]string), orgName: flow.OrgName}
+		for name, value := range flow.Tags {
+			if (strings.HasPrefix(name, "source_") || strings.HasPrefix(name, "target_")) && !strings.HasSuffix(name, "_service_instance_id") {
+				p.tags[name] = value
+			}
+		}
+		for _, name := range serviceTags {
+			if value, ok := flow.Tags[name]; ok {
+				p.tags[name] = value
+			}
+		}
+		if k.target == flow.Tags["peer_address"] {
+			p.tags["peer_address"] = k.target
+		}
+		ss.entries[k] = p
+	}
+	p.flows++
+	p.packetsSent += s.PacketsSent
+	p.bytesSent += s.BytesSent
+	p.packetsReceived += s.PacketsReceived
+	p.bytesReceived += s.BytesReceived
+	if s.WindowStart != 0 && (p.windowStart == 0 || s.WindowStart < p.windowStart) {
+		p.windowStart = s.WindowStart
+	}
+}
+
+// report returns the pairs with traffic since the previous report, stamped with the first packet of their
+// flows counted in kernel, with timestamp if unknown.
+func (ss *services) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(ss.entries))
+	for _, p := range ss.entries {
+		at := timestamp
+		if p.windowStart != 0 {
+			at = clock.FromKtime(p.windowStart)
+		}
+		ans = append(ans, &metric.Metric{
+			Name:        measurementServices,
+			Measurement: measurementServices,
+			Timestamp:   at,
+			OrgName:     p.orgName,
+			Tags:        p.tags,
+			Fields: map[string]interface{}{
+				"flows":            p.flows,
+				"packets_sent":     p.packetsSent,
+				"bytes_sent":       p.bytesSent,
+				"packets_received": p.packetsReceived,
+				"bytes_received":   p.bytesReceived,
+			},
+		})
+	}
+	ss.entries = make(map[servicePairKey]*servicePair)
+	return ans
+}
@@ -0,0 +1,64 @@
+package throughput
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/throughput/ebpf"
+)
+
+func TestServices(t *testing.T) {
+	ss := newServices()
+	flow := func(instance, target, peer string) *metric.Metric {
+		m := &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"metric_source":              "ebpf",
+			"source_service_id":          "web",
+			"source_service_instance_id": instance,
+			"peer_address":               peer,
+		}}
+		if target != "" {
+			m.Tags["target_service_id"] = target
+			m.Tags["target_service_instance_id"] = target + "-1"
+		}
+		return m
+	}
+	ss.observe(flow("web-1", "api", "10.0.1.5"), &ebpf.FlowStats{PacketsSent: 2, BytesSent: 200, PacketsReceived: 1, BytesReceived: 1000, WindowStart: 7e9})
+	ss.observe(flow("web-2", "api", "10.0.1.6"), &ebpf.FlowStats{PacketsSent: 1, BytesSent: 100, WindowStart: 6e9})
+	ss.observe(flow("web-1", "", "8.8.8.8"), &ebpf.FlowStats{PacketsSent: 1, BytesSent: 60})
+
+	reported := ss.report(1000)
+	if len(reported) != 2 {
+		t.Fatalf("expected a metric per pair of services, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurementServices || m.OrgName != "erda" || m.Tags["source_service_id"] != "web" {
+			t.Errorf("unexpected pair %+v", m)
+		}
+		if _, ok := m.Tags["source_service_instance_id"]; ok {
+			t.Error("the instances are aggregated")
+		}
+		switch m.Tags["target_service_id"] {
+		case "api":
+			if m.Fields["flows"] != uint64(2) || m.Fields["bytes_sent"] != uint64(300) || m.Fields["bytes_received"] != uint64(1000) {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+			if _, ok := m.Tags["peer_address"]; ok {
+				t.Error("the pairs of services are not split by address")
+			}
+			if m.Timestamp != clock.FromKtime(6e9) {
+				t.Errorf("the pair is stamped with its earliest flow, got %d", m.Timestamp)
+			}
+		case "":
+			if m.Tags["peer_address"] != "8.8.8.8" || m.Fields["bytes_sent"] != uint64(60) {
+				t.Errorf("unexpected pair %+v", m)
+			}
+			if m.Timestamp != 1000 {
+				t.Errorf("a pair without a start in kernel is stamped with the report, got %d", m.Timestamp)
+			}
+		}
+	}
+	if len(ss.report(2000)) != 0 {
+		t.Error("the pairs are reported once")
+	}
+}
@@ -0,0 +1,218 @@
+// Package throughput reports the network throughput of the pods of the node, so that the throughput is
+// at hand next to the latency of the L7 measurements without a separate exporter. The packets are counted
+// in kernel on the veth of the pods (socket filter, per-cpu counters), the counters are read every
+// THROUGHPUT_INTERVAL (30s) and reported by pod in pod_network_rx (received by the pod) and
+// pod_network_tx (sent by the pod):
+//
+//	tags     source_* of the pod
+//	         metric_source, _meta, _metric_scope, _metric_scope_id, org_name, cluster_name
+//	fields   bytes, packets                          since the previous reading, bytes of the ip packets
+//	         bytes_per_second, packets_per_second    averaged over the interval
+//
+// The ipv4 packets only are counted. The first reading of a veth only sets the baseline. The metrics are
+// stamped with the start of their window recorded in kernel (the first packet counted since the previous
+// reading), so that a delayed reading does not move them on the time axis.
+//
+// The tcp and udp flows of the pods are counted on the same veths and reported by pair of services every
+// THROUGHPUT_INTERVAL in application_service_traffic, the traffic matrix complementing the requests of
+// the topology:
+//
+//	tags     source_* of the service of the pod, without the instance
+//	         target_* of the service of the peer pod, resolved through conntrack NAT for the addresses of
+//	         the k8s services, peer_service of the k8s service otherwise, peer_address of the other peers
+//	         metric_source, _meta, _metric_scope, _metric_scope_id, org_name, cluster_name
+//	fields   flows                                 flows (pod and peer ports) of the interval
+//	         packets_sent, bytes_sent              sent by the service
+//	         packets_received, bytes_received      received by the service
+//
+// The traffic between two pods of the node is reported by both, each as the source.
+package throughput
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/coverage"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/throughput/ebpf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+)
+
+type Config struct {
+	Interval time.Duration `env:"THROUGHPUT_INTERVAL" default:"30s"`
+}
+
+type provider struct {
+	sync.RWMutex
+
+	Log          logs.Logger
+	cfg          Config
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	engines      map[int]ebpf.Interface
+	podIPs       map[int]string
+	meters       *meters
+	services     *services
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.engines = make(map[int]ebpf.Interface)
+	p.podIPs = make(map[int]string)
+	p.meters = newMeters()
+	p.services = newServices()
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	vethes, err := p.kprobeHelper.GetVethes()
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	for _, v := range vethes {
+		p.attach(v.Link.Attrs().Index, v.Neigh.IP.String())
+	}
+
+	vethEvents := p.kprobeHelper.RegisterNetLinkListener()
+	go func() {
+		for event := range vethEvents {
+			switch event.Type {
+			case kprobe.LinkAdd:
+				p.Log.Infof("veth add, index: %d, ip: %s", event.Link.Attrs().Index, event.Neigh.IP.String())
+				p.attach(event.Link.Attrs().Index, event.Neigh.IP.String())
+			case kprobe.LinkDelete:
+				p.Log.Infof("veth del, index: %d", event.Link.Attrs().Index)
+				p.Lock()
+				if e, ok := p.engines[event.Link.Attrs().Index]; ok {
+					e.Close()
+					delete(p.engines, event.Link.Attrs().Index)
+					delete(p.podIPs, event.Link.Attrs().Index)
+					p.meters.forget(event.Link.Attrs().Index)
+				}
+				p.Unlock()
+			default:
+				p.Log.Infof("unknown event type: %v", event.Type)
+			}
+		}
+	}()
+
+	c <- kprobe.WaitSynced(p.kprobeHelper, "throughput")
+	ticker := p.clock.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		now := p.clock.Now()
+		var reported []*metric.Metric
+		p.RLock()
+		for index, e := range p.engines {
+			counters, err := e.Counters()
+			if err != nil {
+				p.Log.Errorf("failed to read the counters of veth index: %d, err: %v", index, err)
+				continue
+			}
+			pod, ok := p.pod(p.podIPs[index])
+			if !ok {
+				continue
+			}
+			reported = append(reported, p.meters.observe(index, pod, counters, now)...)
+		}
+		for _, e := range p.engines {
+			for _, f := range e.Flows() {
+				p.services.observe(p.flow(&f.Key), &f.Stats)
+			}
+		}
+		p.RUnlock()
+		reported = append(reported, p.services.report(now.UnixNano())...)
+		for _, m := range reported {
+			c <- m
+		}
+	}
+}
+
+func (p *provider) attach(index int, ip string) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.engines[index]; ok {
+		return
+	}
+	e := ebpf.New(index, ip)
+	if err := e.Load(); err != nil {
+		p.Log.Errorf("failed to load throughput ebpf program for veth index: %d, ip: %s, err: %v", index, ip, err)
+		coverage.Quarantine("throughput", index, err)
+		return
+	}
+	p.engines[index] = e
+	p.podIPs[index] = ip
+}
+
+// pod returns the tags of the pod of a veth, false if the pod is not known yet.
+func (p *provider) pod(ip string) (*metric.Metric, bool) {
+	pod, err := p.kprobeHelper.GetPodByUID(ip)
+	if err != nil {
+		return nil, false
+	}
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+	}}
+	enrich.PodTags(m, "source", pod)
+	return m, true
+}
+
+// flow returns the tags of the pod and of the peer of a flow.
+func (p *provider) flow(k *ebpf.FlowKey) *metric.Metric {
+	podIP := net.IP(k.PodIP[:]).String()
+	peerIP := net.IP(k.PeerIP[:]).String()
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+		"peer_address":  peerIP,
+	}}
+	if pod, err := p.kprobeHelper.GetPodByUID(podIP); err == nil {
+		enrich.PodTags(m, "source", pod)
+	}
+	// the packets to a service leave the pod for its address, the pod behind it is the destination of the nat.
+	if natInfo, ok := p.netNatHelper.GetNatInfo(podIP, k.PodPort); ok {
+		peerIP = natInfo.ReplyDstIP
+	}
+	if pod, err := p.kprobeHelper.GetPodByUID(peerIP); err == nil {
+		enrich.PodTags(m, "target", pod)
+	} else if svc, err := p.kprobeHelper.GetService(peerIP); err == nil {
+		m.Tags["peer_service"] = svc.Name
+	}
+	return m
+}
+
+func (p *provider) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for _, e := range p.engines {
+		e.Close()
+	}
+}
+
+func init() {
+	servicehub.Register("throughput", &servicehub.Spec{
+		Services:             []string{"throughput"},
+		Description:          "ebpf for the network throughput and the traffic matrix of the pods",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
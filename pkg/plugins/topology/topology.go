@@ -0,0 +1,320 @@
+// Package topology exchanges the nat records of the node agents with each other.
+//
+// A client only sees the address it connected to, when that address is DNATed on
+// another node (node ports, external ips, host ports) the real server is only known
+// to the conntrack of that node. Every agent serves its records on
+// TOPOLOGY_EXCHANGE_ADDR and periodically pulls the records of a few random peers,
+// records learned from peers are served again, so they spread through the cluster
+// even if the agents only know part of each other.
+//
+// The records are served on the address of the node (HOST_IP) unless TOPOLOGY_EXCHANGE_ADDR says
+// otherwise, never on all the interfaces by default. With TOPOLOGY_EXCHANGE_TOKEN set, the agents only
+// serve the peers presenting the same token, and the responses of the peers are bounded by
+// TOPOLOGY_EXCHANGE_MAX_RESPONSE before they are merged.
+//
+// The provider implements netfilter.Interface, protocol plugins get it through
+// NatHelper and resolve the records of the local node first.
+package topology
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+	"github.com/patrickmn/go-cache"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+)
+
+const (
+	edgesPath = "/topology/edges"
+	// defaultPort is the port of the exchange when TOPOLOGY_EXCHANGE_ADDR is not set.
+	defaultPort = "9531"
+	// edgeTTL matches the retention of the nat records in netfilter.
+	edgeTTL = time.Minute
+)
+
+type Config struct {
+	Enabled bool `env:"TOPOLOGY_EXCHANGE_ENABLED"`
+	// Addr is the listen address, HOST_IP:9531 if empty and 127.0.0.1:9531 without HOST_IP.
+	Addr string `env:"TOPOLOGY_EXCHANGE_ADDR"`
+	// Token is the shared secret of the agents, sent as a bearer token, the records are served to
+	// anyone reaching Addr without it.
+	Token string `env:"TOPOLOGY_EXCHANGE_TOKEN"`
+	// MaxResponse bounds the bytes read from a peer per round.
+	MaxResponse int64 `env:"TOPOLOGY_EXCHANGE_MAX_RESPONSE" default:"16777216"`
+	// Peers is a comma separated list of agent addresses, dns names (e.g. a headless service
+	// of the daemonset) are resolved on every round.
+	Peers    string        `env:"TOPOLOGY_EXCHANGE_PEERS"`
+	Interval time.Duration `env:"TOPOLOGY_EXCHANGE_INTERVAL" default:"10s"`
+	Fanout   int           `env:"TOPOLOGY_EXCHANGE_FANOUT" default:"3"`
+}
+
+// Edge is a nat record of the node that observed it, Client is the original source ip:port.
+type Edge struct {
+	Client string            `json:"client"`
+	Nat    netfilter.NatInfo `json:"nat"`
+	Node   string            `json:"node"`
+	// Seen is the unix nano time the record was served by its node.
+	Seen int64 `json:"seen"`
+}
+
+type provider struct {
+	Log logs.Logger
+
+	cfg          Config
+	node         string
+	netNatHelper netfilter.Interface
+	remote       *cache.Cache
+	port         string
+	client       *http.Client
+	server       *http.Server
+	stopper      chan struct{}
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.netNatHelper = ctx.Service("netfilter").(netfilter.Interface)
+	p.node = os.Getenv("NODE_NAME")
+	p.remote = cache.New(edgeTTL, 10*time.Second)
+	p.client = &http.Client{Timeout: 3 * time.Second}
+	p.stopper = make(chan struct{})
+	p.clock = clock.Real
+	p.cfg.Addr = listenAddr(p.cfg.Addr, os.Getenv("HOST_IP"))
+	_, port, err := net.SplitHostPort(p.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid TOPOLOGY_EXCHANGE_ADDR %q: %v", p.cfg.Addr, err)
+	}
+	p.port = port
+	return nil
+}
+
+// listenAddr returns the configured address, by default the address of the node so that the records are not
+// served on the other interfaces of the host.
+func listenAddr(addr, hostIP string) string {
+	if addr != "" {
+		return addr
+	}
+	if hostIP == "" {
+		hostIP = "127.0.0.1"
+	}
+	return net.JoinHostPort(hostIP, defaultPort)
+}
+
+func (p *provider) Start() error {
+	if !p.cfg.Enabled {
+		return nil
+	}
+	if p.cfg.Token == "" {
+		p.Log.Warnf("TOPOLOGY_EXCHANGE_TOKEN is not set, the nat records are served to anyone reaching %s", p.cfg.Addr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(edgesPath, p.serveEdges)
+	p.server = &http.Server{Addr: p.cfg.Addr, Handler: mux}
+	go func() {
+		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			p.Log.Errorf("failed to serve topology exchange on %s, err: %v", p.cfg.Addr, err)
+		}
+	}()
+	go func() {
+		ticker := p.clock.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopper:
+				return
+			case <-ticker.C():
+				p.exchange()
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *provider) Close() error {
+	close(p.stopper)
+	if p.server != nil {
+		return p.server.Close()
+	}
+	return nil
+}
+
+// GetNatInfo resolves a connection by the records of this node, then by the records learned from peers.
+func (p *provider) GetNatInfo(ip string, port uint16) (netfilter.NatInfo, bool) {
+	if natInfo, ok := p.netNatHelper.GetNatInfo(ip, port); ok {
+		return natInfo, true
+	}
+	if e, ok := p.remote.Get(fmt.Sprintf("%s:%d", ip, port)); ok {
+		return e.(Edge).Nat, true
+	}
+	return netfilter.NatInfo{}, false
+}
+
+func (p *provider) NatEntries() map[string]netfilter.NatInfo {
+	return p.netNatHelper.NatEntries()
+}
+
+func (p *provider) edges() []Edge {
+	now := p.clock.Now().UnixNano()
+	local := p.netNatHelper.NatEntries()
+	ans := make([]Edge, 0, len(local))
+	for client, natInfo := range local {
+		ans = append(ans, Edge{Client: client, Nat: natInfo, Node: p.node, Seen: now})
+	}
+	for client, item := range p.remote.Items() {
+		if _, ok := local[client]; ok {
+			continue
+		}
+		ans = append(ans, item.Object.(Edge))
+	}
+	return ans
+}
+
+func (p *provider) serveEdges(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.edges()); err != nil {
+		p.Log.Warnf("failed to encode topology edges, err: %v", err)
+	}
+}
+
+// authorized reports whether the request presents the shared token, all of them do without a token.
+func (p *provider) authorized(r *http.Request) bool {
+	if p.cfg.Token == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(p.cfg.Token)) == 1
+}
+
+func (p *provider) exchange() {
+	peers := p.peers()
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > p.cfg.Fanout {
+		peers = peers[:p.cfg.Fanout]
+	}
+	for _, peer := range peers {
+		edges, err := p.pull(peer)
+		if err != nil {
+			p.Log.Debugf("failed to pull topology edges from %s, err: %v", peer, err)
+			continue
+		}
+		p.merge(edges)
+	}
+}
+
+// peers resolves the configured peers without the addresses of this node.
+func (p *provider) peers() []string {
+	self := make(map[string]bool)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				self[ipNet.IP.String()] = true
+			}
+		}
+	}
+	var ans []string
+	for _, peer := range strings.Split(p.cfg.Peers, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		hosts, err := net.LookupHost(peer)
+		if err != nil {
+			p.Log.Debugf("failed to resolve topology peer %s, err: %v", peer, err)
+			continue
+		}
+		for _, host := range hosts {
+			if !self[host] {
+				ans = append(ans, net.JoinHostPort(host, p.port))
+			}
+		}
+	}
+	return ans
+}
+
+func (p *provider) pull(peer string) ([]Edge, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+peer+edgesPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	// a response above the limit is dropped as a whole, its truncated json would not decode anyway.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.cfg.MaxResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > p.cfg.MaxResponse {
+		return nil, fmt.Errorf("response exceeds %d bytes", p.cfg.MaxResponse)
+	}
+	var edges []Edge
+	if err := json.Unmarshal(body, &edges); err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
+
+// merge keeps the most recent record of every client, records expire edgeTTL after
+// their node served them, not after they were relayed.
+func (p *provider) merge(edges []Edge) {
+	now := p.clock.Now()
+	for _, e := range edges {
+		if e.Node == p.node {
+			continue
+		}
+		ttl := edgeTTL - now.Sub(time.Unix(0, e.Seen))
+		if ttl <= 0 {
+			continue
+		}
+		if old, ok := p.remote.Get(e.Client); ok && old.(Edge).Seen >= e.Seen {
+			continue
+		}
+		p.remote.Set(e.Client, e, ttl)
+	}
+}
+
+// NatHelper returns the topology service when it is configured and the netfilter service otherwise.
+func NatHelper(ctx servicehub.Context) netfilter.Interface {
+	if t, ok := ctx.Service("topology").(netfilter.Interface); ok {
+		return t
+	}
+	return ctx.Service("netfilter").(netfilter.Interface)
+}
+
+func init() {
+	servicehub.Register("topology", &servicehub.Spec{
+		Services:     []string{"topology"},
+		Description:  "exchange of nat records between node agents",
+		Dependencies: []string{"netfilter"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
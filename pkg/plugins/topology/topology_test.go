@@ -0,0 +1,109 @@
+package topology
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+)
+
+func TestMerge(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	p := &provider{node: "node-a", remote: cache.New(edgeTTL, time.Minute), clock: clk}
+	seen := func(ago time.Duration) int64 { return clk.Now().Add(-ago).UnixNano() }
+	p.merge([]Edge{
+		{Client: "10.0.0.1:40000", Nat: netfilter.NatInfo{ReplyDstIP: "10.1.0.1", ReplyDstPort: 8080}, Node: "node-b", Seen: seen(10 * time.Second)},
+		{Client: "10.0.0.2:40000", Node: "node-b", Seen: seen(edgeTTL)},
+		{Client: "10.0.0.3:40000", Node: "node-a", Seen: seen(0)},
+	})
+	if _, ok := p.remote.Get("10.0.0.1:40000"); !ok {
+		t.Errorf("recent records of peers should be kept")
+	}
+	if _, ok := p.remote.Get("10.0.0.2:40000"); ok {
+		t.Errorf("records served edgeTTL ago should be dropped")
+	}
+	if _, ok := p.remote.Get("10.0.0.3:40000"); ok {
+		t.Errorf("records of this node should be ignored")
+	}
+
+	// relayed copies older than the known record do not replace it
+	clk.Add(5 * time.Second)
+	p.merge([]Edge{{Client: "10.0.0.1:40000", Node: "node-c", Seen: seen(30 * time.Second)}})
+	if e, _ := p.remote.Get("10.0.0.1:40000"); e.(Edge).Node != "node-b" {
+		t.Errorf("the most recent record should be kept, got %+v", e)
+	}
+}
+
+type natEntries map[string]netfilter.NatInfo
+
+func (n natEntries) GetNatInfo(ip string, port uint16) (netfilter.NatInfo, bool) {
+	return netfilter.NatInfo{}, false
+}
+
+func (n natEntries) NatEntries() map[string]netfilter.NatInfo { return n }
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		addr, hostIP, expect string
+	}{
+		{"", "192.168.0.3", "192.168.0.3:9531"},
+		{"", "", "127.0.0.1:9531"},
+		{":9600", "192.168.0.3", ":9600"},
+	}
+	for _, tt := range tests {
+		if addr := listenAddr(tt.addr, tt.hostIP); addr != tt.expect {
+			t.Errorf("listenAddr(%q, %q): expected %s, got %s", tt.addr, tt.hostIP, tt.expect, addr)
+		}
+	}
+}
+
+func TestExchangeToken(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	server := &provider{
+		cfg:          Config{Token: "secret"},
+		node:         "node-b",
+		netNatHelper: natEntries{"10.0.0.1:40000": {ReplyDstIP: "10.1.0.1", ReplyDstPort: 8080}},
+		remote:       cache.New(edgeTTL, time.Minute),
+		clock:        clk,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(server.serveEdges))
+	defer ts.Close()
+	peer := strings.TrimPrefix(ts.URL, "http://")
+
+	client := func(token string) *provider {
+		return &provider{cfg: Config{Token: token, MaxResponse: 1 << 20}, client: ts.Client()}
+	}
+	if _, err := client("").pull(peer); err == nil {
+		t.Error("the records must not be served without the token")
+	}
+	if _, err := client("guess").pull(peer); err == nil {
+		t.Error("the records must not be served with another token")
+	}
+	edges, err := client("secret").pull(peer)
+	if err != nil || len(edges) != 1 || edges[0].Node != "node-b" || edges[0].Nat.ReplyDstPort != 8080 {
+		t.Errorf("unexpected edges %+v, err: %v", edges, err)
+	}
+}
+
+func TestPullMaxResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"client":"10.0.0.1:40000","node":"node-b","seen":1}]`))
+	}))
+	defer ts.Close()
+	peer := strings.TrimPrefix(ts.URL, "http://")
+
+	p := &provider{cfg: Config{MaxResponse: 16}, client: ts.Client()}
+	if edges, err := p.pull(peer); err == nil {
+		t.Errorf("a response above the limit must be dropped, got %+v", edges)
+	}
+	p.cfg.MaxResponse = 1 << 10
+	if edges, err := p.pull(peer); err != nil || len(edges) != 1 {
+		t.Errorf("unexpected edges %+v, err: %v", edges, err)
+	}
+}
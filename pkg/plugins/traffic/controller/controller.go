@@ -2,11 +2,13 @@ package controller
 
 import (
 	_ "net/http/pprof"
+	"strconv"
 
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/klog"
 
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/attach"
 	ebpf2 "github.com/erda-project/ebpf-agent/pkg/plugins/protocols/http/ebpf"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/traffic/ebpf"
 )
@@ -14,14 +16,13 @@ import (
 type Controller struct {
 	stopper      chan struct{}
 	ch           chan ebpf.Metric
-	ebpfs        map[int]ebpf2.Interface
+	probes       *attach.Attacher
 	kprobeHelper kprobe.Interface
 }
 
 func NewController(ch chan ebpf.Metric, kprobeHelper kprobe.Interface) *Controller {
 	return &Controller{
 		ch:           ch,
-		ebpfs:        make(map[int]ebpf2.Interface),
 		kprobeHelper: kprobeHelper,
 	}
 }
@@ -29,55 +30,48 @@ func (c *Controller) Run() {
 	defer runtime.HandleCrash()
 
 	ch := make(chan ebpf2.Metric, 100)
-	vethes, err := c.kprobeHelper.GetVethes()
+	probes, err := attach.Start("traffic", c.kprobeHelper, func(l attach.Link) (attach.Probe, error) {
+		e := ebpf2.New(l, ch, nil)
+		return e, e.Load()
+	})
 	if err != nil {
 		panic(err)
 	}
-	for _, veth := range vethes {
-		ebpfProvider := ebpf2.New(veth.Link.Attrs().Index, veth.Neigh.IP.String(), ch)
-		if err := ebpfProvider.Load(); err != nil {
-			klog.Errorf("failed to load ebpf, err: %v", err)
-			continue
-		}
-		c.ebpfs[veth.Link.Attrs().Index] = ebpfProvider
-	}
-	//ebpfProvider := ebpf2.New(1, "127.0.0.1", ch)
-	//if err := ebpfProvider.Load(); err != nil {
-	//	panic(err)
-	//}
-	go func() {
-		for {
-			select {
-			case m := <-ch:
-				klog.Infof("metric: %v", m)
-			}
-		}
-	}()
-	vethEvents := c.kprobeHelper.RegisterNetLinkListener()
+	c.probes = probes
 	go func() {
-		for {
-			select {
-			case event := <-vethEvents:
-				switch event.Type {
-				case kprobe.LinkAdd:
-					klog.Infof("veth add, index: %d, ip: %s", event.Link.Attrs().Index, event.Neigh.IP.String())
-					ebpfProvider := ebpf2.New(event.Link.Attrs().Index, event.Neigh.IP.String(), ch)
-					if err := ebpfProvider.Load(); err != nil {
-						klog.Errorf("failed to load ebpf, err: %v", err)
-						continue
-					}
-					c.ebpfs[event.Link.Attrs().Index] = ebpfProvider
-				case kprobe.LinkDelete:
-					klog.Infof("veth del, index: %d", event.Link.Attrs().Index)
-					ebpfProvider, ok := c.ebpfs[event.Link.Attrs().Index]
-					if ok {
-						ebpfProvider.Close()
-						delete(c.ebpfs, event.Link.Attrs().Index)
-					}
-				default:
-					klog.Infof("unknown event type: %v", event.Type)
-				}
-			}
+		for m := range ch {
+			c.ch <- c.record(&m)
 		}
 	}()
 }
+
+// record returns the flow record of an http request, seen from the pod of the veth capturing it.
+func (c *Controller) record(m *ebpf2.Metric) ebpf.Metric {
+	r := ebpf.Metric{
+		SrcIP:        m.SourceIP,
+		SrcPort:      m.SourcePort,
+		DstIP:        m.DestIP,
+		DstPort:      m.DestPort,
+		Duration:     uint32(m.Duration / 1e6),
+		Method:       m.Method,
+		Protocol:     m.Version,
+		URL:          m.Path,
+		Code:         strconv.Itoa(int(m.StatusCode)),
+		SocketCookie: m.SocketCookie,
+	}
+	podIP := m.DestIP
+	if m.Client {
+		r.Flow = 1
+		podIP = m.SourceIP
+	}
+	pod, err := c.kprobeHelper.GetPodByUID(podIP)
+	if err != nil {
+		klog.V(4).Infof("no pod of the flow record %s: %v", podIP, err)
+		return r
+	}
+	r.PodName = pod.Name
+	r.NameSpace = pod.Namespace
+	r.NodeName = pod.Spec.NodeName
+	r.ServiceName = pod.Annotations["msp.erda.cloud/service_name"]
+	return r
+}
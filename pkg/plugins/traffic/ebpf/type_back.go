@@ -58,11 +58,15 @@ type Metric struct {
 	NodeName    string
 	NameSpace   string
 	ServiceName string
+	// SocketCookie is the socket cookie of the client of the request, the join key of the flow with the
+	// other plugins, 0 if it is not captured.
+	SocketCookie uint64
 }
 
 func (m *Metric) CovertMetric() *metric.Metric {
 	var metric metric.Metric
 	metric.Measurement = "http"
+	metric.SocketCookie = m.SocketCookie
 	metric.AddTags("podname", m.PodName)
 	metric.AddTags("nodename", m.NodeName)
 	metric.AddTags("namespace", m.NameSpace)
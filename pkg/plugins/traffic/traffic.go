@@ -7,6 +7,7 @@ import (
 	"k8s.io/klog"
 
 	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/traffic/controller"
 	"github.com/erda-project/ebpf-agent/pkg/plugins/traffic/ebpf"
@@ -18,11 +19,13 @@ type provider struct {
 	ch               chan ebpf.Metric
 	trafficCollector *controller.Controller
 	kprobeHelper     kprobe.Interface
+	clock            clock.Clock
 }
 
 func (p *provider) Init(ctx servicehub.Context) error {
 	p.ch = make(chan ebpf.Metric, 100)
 	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.clock = clock.Real
 	return nil
 }
 
@@ -30,7 +33,7 @@ func (p *provider) Gather(c chan *metric.Metric) {
 	control := controller.NewController(p.ch, p.kprobeHelper)
 	control.Run()
 	redMetric := make(map[string]red.RED)
-	calTicker := time.NewTicker(60 * time.Second)
+	calTicker := p.clock.NewTicker(60 * time.Second)
 	for {
 		select {
 		case m := <-p.ch:
@@ -56,7 +59,7 @@ func (p *provider) Gather(c chan *metric.Metric) {
 				}
 			}
 			c <- m.CovertMetric()
-		case <-calTicker.C:
+		case <-calTicker.C():
 			for k, v := range redMetric {
 				v.QPS = float32(v.RequestCount) / 60
 				v.ErrRate = float32(v.ErrCount) / float32(v.RequestCount) * 100
@@ -0,0 +1,112 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/pkg/utils"
+)
+
+const (
+	programPath = "target/udp.bpf.o"
+	programName = "socket__udp_filter"
+	mapFilter   = "filter_map"
+	mapFlows    = "flows_map"
+)
+
+type Interface interface {
+	Load() error
+	// Flows returns the flows of the pod with packets since the previous call, their counters start over.
+	Flows() []Flow
+	Close() error
+}
+
+type provider struct {
+	ifIndex   int
+	ipAddress string
+
+	collection *ebpf.Collection
+	flows      *ebpf.Map
+	fd         int
+	sock       int
+}
+
+const (
+	SO_ATTACH_BPF = 0x32                     // 50
+	SO_DETACH_BPF = syscall.SO_DETACH_FILTER // 27
+)
+
+func New(ifIndex int, ip string) Interface {
+	return &provider{
+		ifIndex:   ifIndex,
+		ipAddress: ip,
+	}
+}
+
+func (e *provider) Load() error {
+	programBytes, err := os.ReadFile(programPath)
+	if err != nil {
+		return err
+	}
+
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(programBytes))
+	if err != nil {
+		return err
+	}
+	e.collection, err = ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{})
+	if err != nil {
+		return err
+	}
+
+	program := e.collection.DetachProgram(programName)
+	if program == nil {
+		return fmt.Errorf("detach program %s failed", programName)
+	}
+	e.fd = program.FD()
+
+	e.sock, err = utils.OpenRawSock(e.ifIndex)
+	if err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(e.sock, syscall.SOL_SOCKET, SO_ATTACH_BPF, program.FD()); err != nil {
+		return err
+	}
+
+	if err := e.collection.DetachMap(mapFilter).Put(
+		utils.Htonl(utils.IP4toDec(e.ipAddress)), uint32(0),
+	); err != nil {
+		return err
+	}
+	e.flows = e.collection.DetachMap(mapFlows)
+	return nil
+}
+
+func (e *provider) Flows() []Flow {
+	var (
+		key FlowKey
+		val FlowStats
+		ans []Flow
+	)
+	for e.flows.Iterate().Next(&key, &val) {
+		ans = append(ans, Flow{Key: key, Stats: val})
+		if err := e.flows.Delete(key); err != nil {
+			klog.Errorf("delete map error: %v", err)
+		}
+	}
+	return ans
+}
+
+func (e *provider) Close() error {
+	_ = syscall.SetsockoptInt(e.sock, syscall.SOL_SOCKET, SO_DETACH_BPF, e.fd)
+	_ = syscall.Close(e.sock)
+	if e.flows != nil {
+		e.flows.Close()
+	}
+	e.collection.Close()
+	return nil
+}
@@ -0,0 +1,25 @@
+package ebpf
+
+// FlowKey mirrors udp_flow_key of ebpf/plugins/udp/main.c, the pod is the pod of the veth.
+type FlowKey struct {
+	PodIP    [4]byte
+	PeerIP   [4]byte
+	PodPort  uint16
+	PeerPort uint16
+}
+
+// FlowStats mirrors udp_flow_t of ebpf/plugins/udp/main.c, the bytes are the bytes of the ip packets.
+type FlowStats struct {
+	PacketsSent     uint64
+	BytesSent       uint64
+	PacketsReceived uint64
+	BytesReceived   uint64
+	// Cookie is the socket cookie of the pod, 0 if no packet owned by a local socket was counted.
+	Cookie uint64
+}
+
+// Flow is a flow of the pod with its counters since the previous read.
+type Flow struct {
+	Key   FlowKey
+	Stats FlowStats
+}
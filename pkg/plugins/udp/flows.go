@@ -0,0 +1,74 @@
+package udp
+
+import (
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/udp/ebpf"
+)
+
+const measurement = "application_udp"
+
+type peerKey struct {
+	pod  string
+	peer string
+}
+
+type peer struct {
+	tags            map[string]string
+	orgName         string
+	flows           uint64
+	packetsSent     uint64
+	bytesSent       uint64
+	packetsReceived uint64
+	bytesReceived   uint64
+}
+
+// peers aggregates the flows between two reports by pod and peer.
+type peers struct {
+	entries map[peerKey]*peer
+}
+
+func newPeers() *peers {
+	return &peers{entries: make(map[peerKey]*peer)}
+}
+
+// observe adds the counters of a flow to its peer, flow carries the tags of the pod and of the peer, the
+// tags of the first flow of a peer are reported.
+func (ps *peers) observe(flow *metric.Metric, s *ebpf.FlowStats) {
+	k := peerKey{pod: flow.Tags["source_service_instance_id"], peer: flow.Tags["target_service_instance_id"]}
+	if k.peer == "" {
+		k.peer = flow.Tags["peer_address"]
+	}
+	p, ok := ps.entries[k]
+	if !ok {
+		p = &peer{tags: flow.Tags, orgName: flow.OrgName}
+		ps.entries[k] = p
+	}
+	p.flows++
+	p.packetsSent += s.PacketsSent
+	p.bytesSent += s.BytesSent
+	p.packetsReceived += s.PacketsReceived
+	p.bytesReceived += s.BytesReceived
+}
+
+// report returns the peers with packets since the previous report.
+func (ps *peers) report(timestamp int64) []*metric.Metric {
+	ans := make([]*metric.Metric, 0, len(ps.entries))
+	for _, p := range ps.entries {
+		ans = append(ans, &metric.Metric{
+			Name:        measurement,
+			Measurement: measurement,
+			Timestamp:   timestamp,
+			OrgName:     p.orgName,
+			Tags:        p.tags,
+			Fields: map[string]interface{}{
+				"flows":            p.flows,
+				"packets_sent":     p.packetsSent,
+				"bytes_sent":       p.bytesSent,
+				"packets_received": p.packetsReceived,
+				"bytes_received":   p.bytesReceived,
+			},
+		})
+	}
+	ps.entries = make(map[peerKey]*peer)
+	return ans
+}
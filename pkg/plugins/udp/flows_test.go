@@ -0,0 +1,51 @@
+package udp
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/udp/ebpf"
+)
+
+func TestPeers(t *testing.T) {
+	ps := newPeers()
+	flow := func(target, peer string) *metric.Metric {
+		m := &metric.Metric{OrgName: "erda", Tags: map[string]string{
+			"source_service_instance_id": "game-1",
+			"peer_address":               peer,
+		}}
+		if target != "" {
+			m.Tags["target_service_instance_id"] = target
+		}
+		return m
+	}
+	ps.observe(flow("coredns-1", "10.96.0.10"), &ebpf.FlowStats{PacketsSent: 2, BytesSent: 120, PacketsReceived: 2, BytesReceived: 300})
+	ps.observe(flow("coredns-1", "10.96.0.10"), &ebpf.FlowStats{PacketsSent: 1, BytesSent: 60})
+	ps.observe(flow("", "203.0.113.7"), &ebpf.FlowStats{PacketsReceived: 10, BytesReceived: 12000})
+
+	reported := ps.report(1000)
+	if len(reported) != 2 {
+		t.Fatalf("expected a metric per peer, got %v", reported)
+	}
+	for _, m := range reported {
+		if m.Measurement != measurement || m.OrgName != "erda" || m.Timestamp != 1000 {
+			t.Errorf("unexpected peer %+v", m)
+		}
+		switch m.Tags["peer_address"] {
+		case "10.96.0.10":
+			if m.Fields["flows"] != uint64(2) || m.Fields["packets_sent"] != uint64(3) || m.Fields["bytes_sent"] != uint64(180) ||
+				m.Fields["packets_received"] != uint64(2) || m.Fields["bytes_received"] != uint64(300) {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+		case "203.0.113.7":
+			if m.Fields["flows"] != uint64(1) || m.Fields["bytes_received"] != uint64(12000) || m.Fields["packets_sent"] != uint64(0) {
+				t.Errorf("unexpected fields %v", m.Fields)
+			}
+		default:
+			t.Errorf("unexpected peer %v", m.Tags)
+		}
+	}
+	if len(ps.report(2000)) != 0 {
+		t.Error("the peers are reported once")
+	}
+}
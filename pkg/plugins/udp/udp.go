@@ -0,0 +1,172 @@
+// Package udp accounts the udp traffic of the pods of the node, so that the workloads speaking udp (dns,
+// quic, custom protocols) have at least the visibility of L4. The packets are counted by flow on the veth
+// of the pods (socket filter), the flows are reported by pod and peer every UDP_INTERVAL (1m) in
+// application_udp:
+//
+//	tags     source_* of the pod
+//	         target_* of the peer pod, resolved through conntrack NAT for the addresses of the services
+//	         peer_service of the k8s service the pod sent to, peer_address of the peer ip
+//	         service_address of the k8s service the pod sent to (ClusterIP:port), resolved through conntrack NAT
+//	         metric_source, _meta, _metric_scope, _metric_scope_id, org_name, cluster_name
+//	fields   flows                                 flows (pod and peer ports) of the interval
+//	         packets_sent, bytes_sent              sent by the pod, bytes of the ip packets
+//	         packets_received, bytes_received      received by the pod
+//
+// The peers that are not pods are reported by ip, without their ports, so that the ephemeral ports of
+// the clients do not split them. The destinations of a pod are its peers of the interval. The traffic
+// between two pods of the node is reported by both, each as the source.
+package udp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/coverage"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/topology"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/udp/ebpf"
+)
+
+type Config struct {
+	Interval time.Duration `env:"UDP_INTERVAL" default:"1m"`
+}
+
+type provider struct {
+	sync.RWMutex
+
+	Log          logs.Logger
+	cfg          Config
+	kprobeHelper kprobe.Interface
+	netNatHelper netfilter.Interface
+	engines      map[int]ebpf.Interface
+	peers        *peers
+	clock        clock.Clock
+}
+
+func (p *provider) Init(ctx servicehub.Context) error {
+	envconf.MustLoad(&p.cfg)
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.netNatHelper = topology.NatHelper(ctx)
+	p.engines = make(map[int]ebpf.Interface)
+	p.peers = newPeers()
+	p.clock = clock.Real
+	return nil
+}
+
+func (p *provider) Gather(c chan *metric.Metric) {
+	vethes, err := p.kprobeHelper.GetVethes()
+	if err != nil {
+		p.Log.Fatalf("failed to get vethes, err: %v", err)
+	}
+	for _, v := range vethes {
+		p.attach(v.Link.Attrs().Index, v.Neigh.IP.String())
+	}
+
+	vethEvents := p.kprobeHelper.RegisterNetLinkListener()
+	go func() {
+		for event := range vethEvents {
+			switch event.Type {
+			case kprobe.LinkAdd:
+				p.Log.Infof("veth add, index: %d, ip: %s", event.Link.Attrs().Index, event.Neigh.IP.String())
+				p.attach(event.Link.Attrs().Index, event.Neigh.IP.String())
+			case kprobe.LinkDelete:
+				p.Log.Infof("veth del, index: %d", event.Link.Attrs().Index)
+				p.Lock()
+				if e, ok := p.engines[event.Link.Attrs().Index]; ok {
+					e.Close()
+					delete(p.engines, event.Link.Attrs().Index)
+				}
+				p.Unlock()
+			default:
+				p.Log.Infof("unknown event type: %v", event.Type)
+			}
+		}
+	}()
+
+	c <- kprobe.WaitSynced(p.kprobeHelper, "udp")
+	ticker := p.clock.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		p.RLock()
+		for _, e := range p.engines {
+			for _, f := range e.Flows() {
+				p.peers.observe(p.flow(&f.Key), &f.Stats)
+			}
+		}
+		p.RUnlock()
+		for _, m := range p.peers.report(p.clock.Now().UnixNano()) {
+			c <- m
+		}
+	}
+}
+
+func (p *provider) attach(index int, ip string) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.engines[index]; ok {
+		return
+	}
+	e := ebpf.New(index, ip)
+	if err := e.Load(); err != nil {
+		p.Log.Errorf("failed to load udp ebpf program for veth index: %d, ip: %s, err: %v", index, ip, err)
+		coverage.Quarantine("udp", index, err)
+		return
+	}
+	p.engines[index] = e
+}
+
+// flow returns the tags of the pod and of the peer of a flow.
+func (p *provider) flow(k *ebpf.FlowKey) *metric.Metric {
+	podIP := net.IP(k.PodIP[:]).String()
+	peerIP := net.IP(k.PeerIP[:]).String()
+	m := &metric.Metric{Tags: map[string]string{
+		"metric_source": "ebpf",
+		"_meta":         "true",
+		"_metric_scope": "micro_service",
+		"peer_address":  peerIP,
+	}}
+	if pod, err := p.kprobeHelper.GetPodByUID(podIP); err == nil {
+		enrich.PodTags(m, "source", pod)
+	}
+	if svc, err := p.kprobeHelper.GetService(peerIP); err == nil {
+		m.Tags["peer_service"] = svc.Name
+	}
+	// the packets to a service leave the pod for its address, the pod behind it is the destination of the nat.
+	if natInfo, ok := p.netNatHelper.GetNatInfo(podIP, k.PodPort); ok {
+		peerIP = natInfo.ReplyDstIP
+		m.Tags["service_address"] = natInfo.ServiceAddress()
+	}
+	if pod, err := p.kprobeHelper.GetPodByUID(peerIP); err == nil {
+		enrich.PodTags(m, "target", pod)
+	}
+	return m
+}
+
+func (p *provider) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for _, e := range p.engines {
+		e.Close()
+	}
+}
+
+func init() {
+	servicehub.Register("udp", &servicehub.Spec{
+		Services:             []string{"udp"},
+		Description:          "ebpf for udp flows of the pods",
+		Dependencies:         []string{"kprobe", "netfilter"},
+		OptionalDependencies: []string{"topology"},
+		Creator: func() servicehub.Provider {
+			return &provider{}
+		},
+	})
+}
@@ -0,0 +1,218 @@
+// Package schema describes the measurements, tags and fields the agent produces per enabled plugin,
+// so that the Erda backend can provision the dashboards matching the capabilities of the node.
+//
+// The schema is learned from the metrics the plugins send to the controller: plugins only report
+// what the node supports (kernel features, configured extractors, ...), so a static list would
+// promise more than the node delivers. The controller reports it as ebpf_agent_schema, once at
+// startup, whenever a plugin reports a new measurement, tag or field, and every
+// AGENT_SCHEMA_REFRESH_INTERVAL for backends that missed the changes:
+//
+//	tags:   host, agent_version, plugin, measurement (not set on the plugin record)
+//	fields: measurements    the measurements of the plugin, plugin record only
+//	        tags, fields    the tag and field keys of the measurement
+//	        schema_hash     changes whenever one of the lists above changes
+//
+// The lists are sorted and comma separated. The keys only grow during the life of the agent.
+package schema
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const (
+	measurement = "ebpf_agent_schema"
+	// maxKeys bounds the keys kept per measurement, in case a plugin reports dynamic keys.
+	maxKeys = 256
+)
+
+type Config struct {
+	RefreshInterval time.Duration `env:"AGENT_SCHEMA_REFRESH_INTERVAL" default:"1h"`
+}
+
+// Version is the version of the agent, set with -ldflags "-X github.com/erda-project/ebpf-agent/pkg/schema.Version=...",
+// the vcs revision of the build otherwise.
+var Version = ""
+
+type key struct {
+	plugin      string
+	measurement string
+}
+
+type keys struct {
+	tags   map[string]bool
+	fields map[string]bool
+}
+
+type Registry struct {
+	sync.Mutex
+	host    string
+	version string
+	plugins []string
+	// measurements of the plugins in the order they were first seen.
+	measurements map[string][]string
+	keys         map[key]*keys
+	// changed records are reported by the next call of Metrics.
+	changedPlugins      map[string]bool
+	changedMeasurements map[key]bool
+}
+
+func New(host, version string) *Registry {
+	return &Registry{
+		host:                host,
+		version:             version,
+		measurements:        make(map[string][]string),
+		keys:                make(map[key]*keys),
+		changedPlugins:      make(map[string]bool),
+		changedMeasurements: make(map[key]bool),
+	}
+}
+
+// Enable sets the plugins of the agent, they are reported even before they produce metrics.
+func (r *Registry) Enable(plugins []string) {
+	r.Lock()
+	defer r.Unlock()
+	r.plugins = append([]string(nil), plugins...)
+	for _, p := range plugins {
+		r.changedPlugins[p] = true
+	}
+}
+
+// Observe learns the keys of a metric of the plugin.
+func (r *Registry) Observe(plugin string, m *metric.Metric) {
+	if m == nil || m.Measurement == "" {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	k := key{plugin: plugin, measurement: m.Measurement}
+	ks, ok := r.keys[k]
+	if !ok {
+		ks = &keys{tags: make(map[string]bool), fields: make(map[string]bool)}
+		r.keys[k] = ks
+		r.measurements[plugin] = append(r.measurements[plugin], m.Measurement)
+		r.changedPlugins[plugin] = true
+	}
+	for name := range m.Tags {
+		if !ks.tags[name] && len(ks.tags) < maxKeys {
+			ks.tags[name] = true
+			r.changedMeasurements[k] = true
+		}
+	}
+	for name := range m.Fields {
+		if !ks.fields[name] && len(ks.fields) < maxKeys {
+			ks.fields[name] = true
+			r.changedMeasurements[k] = true
+		}
+	}
+}
+
+// Metrics returns the records changed since the previous call, all records if all is set.
+func (r *Registry) Metrics(timestamp int64, all bool) []*metric.Metric {
+	r.Lock()
+	defer r.Unlock()
+	var ans []*metric.Metric
+	for _, plugin := range r.plugins {
+		if all || r.changedPlugins[plugin] {
+			measurements := append([]string(nil), r.measurements[plugin]...)
+			sort.Strings(measurements)
+			ans = append(ans, r.record(timestamp, plugin, "", map[string]string{
+				"measurements": strings.Join(measurements, ","),
+			}))
+		}
+		for _, name := range r.measurements[plugin] {
+			k := key{plugin: plugin, measurement: name}
+			if !all && !r.changedMeasurements[k] {
+				continue
+			}
+			ks := r.keys[k]
+			ans = append(ans, r.record(timestamp, plugin, name, map[string]string{
+				"tags":   sorted(ks.tags),
+				"fields": sorted(ks.fields),
+			}))
+		}
+	}
+	r.changedPlugins = make(map[string]bool)
+	r.changedMeasurements = make(map[key]bool)
+	return ans
+}
+
+func (r *Registry) record(timestamp int64, plugin, name string, lists map[string]string) *metric.Metric {
+	tags := map[string]string{
+		"host":          r.host,
+		"agent_version": r.version,
+		"plugin":        plugin,
+	}
+	if name != "" {
+		tags["measurement"] = name
+	}
+	h := fnv.New64a()
+	fields := make(map[string]interface{}, len(lists)+1)
+	for _, k := range []string{"measurements", "tags", "fields"} {
+		if v, ok := lists[k]; ok {
+			fields[k] = v
+			fmt.Fprintf(h, "%s=%s;", k, v)
+		}
+	}
+	fields["schema_hash"] = fmt.Sprintf("%x", h.Sum64())
+	return &metric.Metric{
+		Name:        measurement,
+		Measurement: measurement,
+		Timestamp:   timestamp,
+		Tags:        tags,
+		Fields:      fields,
+	}
+}
+
+func sorted(set map[string]bool) string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+var defaultRegistry = New(os.Getenv("NODE_NAME"), version())
+
+// Enable sets the plugins of the agent.
+func Enable(plugins []string) {
+	defaultRegistry.Enable(plugins)
+}
+
+// Observe learns the keys of a metric of the plugin.
+func Observe(plugin string, m *metric.Metric) {
+	defaultRegistry.Observe(plugin, m)
+}
+
+// Metrics returns the changed schema records of the agent, all records if all is set.
+func Metrics(timestamp int64, all bool) []*metric.Metric {
+	return defaultRegistry.Metrics(timestamp, all)
+}
+
+// AgentVersion returns the version of the agent.
+func AgentVersion() string {
+	return defaultRegistry.version
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestMetrics(t *testing.T) {
+	r := New("node-1", "v1")
+	r.Enable([]string{"http", "dns"})
+
+	records := r.Metrics(1, false)
+	if len(records) != 2 || records[0].Tags["plugin"] != "http" || records[0].Fields["measurements"] != "" {
+		t.Fatalf("the enabled plugins should be reported before their metrics: %+v", records)
+	}
+
+	r.Observe("http", &metric.Metric{
+		Measurement: "application_http",
+		Tags:        map[string]string{"http_path": "/", "http_method": "GET"},
+		Fields:      map[string]interface{}{"elapsed_sum": 1},
+	})
+	records = r.Metrics(2, false)
+	if len(records) != 2 {
+		t.Fatalf("expected the plugin and the new measurement, got %+v", records)
+	}
+	plugin, m := records[0], records[1]
+	if plugin.Tags["plugin"] != "http" || plugin.Fields["measurements"] != "application_http" {
+		t.Errorf("unexpected plugin record %+v", plugin)
+	}
+	if m.Tags["measurement"] != "application_http" || m.Tags["agent_version"] != "v1" || m.Tags["host"] != "node-1" ||
+		m.Fields["tags"] != "http_method,http_path" || m.Fields["fields"] != "elapsed_sum" {
+		t.Errorf("unexpected measurement record %+v", m)
+	}
+	hash := m.Fields["schema_hash"]
+
+	// known keys are not reported again, new ones are
+	r.Observe("http", &metric.Metric{Measurement: "application_http", Tags: map[string]string{"http_path": "/"}})
+	if records := r.Metrics(3, false); len(records) != 0 {
+		t.Errorf("unchanged schema should not be reported: %+v", records)
+	}
+	r.Observe("http", &metric.Metric{Measurement: "application_http", Fields: map[string]interface{}{"phase_connect": 1}})
+	records = r.Metrics(4, false)
+	if len(records) != 1 || records[0].Fields["fields"] != "elapsed_sum,phase_connect" || records[0].Fields["schema_hash"] == hash {
+		t.Errorf("new field should be reported: %+v", records)
+	}
+
+	if records := r.Metrics(5, true); len(records) != 3 {
+		t.Errorf("refresh should report all records, got %d", len(records))
+	}
+}
@@ -0,0 +1,269 @@
+package simulate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+)
+
+const (
+	namespace = "simulate"
+	nodeName  = "simulate-node"
+	hostIP    = "192.168.0.10"
+)
+
+var (
+	// podCIDR and serviceCIDR are the first addresses of the pods and the services.
+	podCIDR     = net.IPv4(10, 244, 0, 0)
+	serviceCIDR = net.IPv4(10, 96, 0, 0)
+	// externalCIDR is TEST-NET-3, never routed.
+	externalCIDR = net.IPv4(203, 0, 113, 0)
+)
+
+const (
+	protocolHTTP  = "http"
+	protocolGRPC  = "grpc"
+	protocolMySQL = "mysql"
+	protocolRedis = "redis"
+)
+
+type workload struct {
+	name     string
+	protocol string
+	port     uint16
+}
+
+// workloads are the deployments of the node, each one behind a service.
+var workloads = []workload{
+	{name: "frontend", protocol: protocolHTTP, port: 8080},
+	{name: "catalog", protocol: protocolHTTP, port: 8080},
+	{name: "checkout", protocol: protocolGRPC, port: 9090},
+	{name: "orders-db", protocol: protocolMySQL, port: 3306},
+	{name: "session-cache", protocol: protocolRedis, port: 6379},
+}
+
+// cluster is the synthetic node: the pods behind the veths, the informer cache of the pods and the
+// services, and the nat records of the connections to the services.
+type cluster struct {
+	sync.RWMutex
+	cfg   Config
+	rand  *rand.Rand
+	clock clock.Clock
+
+	// serial numbers the pods, their names, uids and ips.
+	serial uint32
+	links  map[int]kprobe.NeighLink
+	// linkPods are the pods behind the veths, including the ones not yet in the cache.
+	linkPods map[int]corev1.Pod
+	// pods is the informer cache, keyed by uid and ip.
+	pods      map[string]corev1.Pod
+	services  map[string]corev1.Service
+	nat       *cache.Cache
+	listeners []chan kprobe.NeighLinkEvent
+	synced    chan struct{}
+}
+
+func newCluster(cfg Config) *cluster {
+	if cfg.Pods < len(workloads) {
+		cfg.Pods = len(workloads)
+	}
+	c := &cluster{
+		cfg:      cfg,
+		rand:     rand.New(rand.NewSource(cfg.Seed)),
+		clock:    clock.Real,
+		links:    make(map[int]kprobe.NeighLink),
+		linkPods: make(map[int]corev1.Pod),
+		pods:     make(map[string]corev1.Pod),
+		services: make(map[string]corev1.Service),
+		nat:      cache.New(time.Minute, 10*time.Second),
+		synced:   make(chan struct{}),
+	}
+	// the pods running before the agent started are not cold starts.
+	started := c.clock.Now().Add(-time.Hour)
+	for i := 0; i < cfg.Pods; i++ {
+		pod := c.newPod(i%len(workloads), started)
+		c.addLink(pod)
+		c.cache(pod)
+	}
+	for i, w := range workloads {
+		svc := corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      w.name,
+				Namespace: namespace,
+				UID:       types.UID(fmt.Sprintf("simulate-svc-%d", i)),
+			},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: nthIP(serviceCIDR, uint32(i+10)).String(),
+				Selector:  map[string]string{"app": w.name},
+				Ports:     []corev1.ServicePort{{Port: int32(w.port)}},
+			},
+		}
+		c.services[svc.Spec.ClusterIP] = svc
+	}
+	close(c.synced)
+	return c
+}
+
+func nthIP(base net.IP, n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base.To4())+n)
+	return ip
+}
+
+func (c *cluster) newPod(w int, started time.Time) corev1.Pod {
+	c.serial++
+	wl := workloads[w]
+	name := fmt.Sprintf("%s-%d", wl.name, c.serial)
+	start := metav1.NewTime(started)
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			UID:               types.UID(fmt.Sprintf("simulate-pod-%d", c.serial)),
+			CreationTimestamp: start,
+			Labels: map[string]string{
+				"app":                   wl.name,
+				"DICE_ORG_NAME":         namespace,
+				"DICE_CLUSTER_NAME":     namespace,
+				"DICE_PROJECT_NAME":     namespace,
+				"DICE_APPLICATION_NAME": wl.name,
+			},
+			Annotations: map[string]string{
+				"msp.erda.cloud/service_name": wl.name,
+				"msp.erda.cloud/runtime_name": namespace,
+				"msp.erda.cloud/terminus_key": namespace,
+				"msp.erda.cloud/workspace":    "DEV",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Hostname: name,
+		},
+		Status: corev1.PodStatus{
+			Phase:     corev1.PodRunning,
+			HostIP:    hostIP,
+			PodIP:     nthIP(podCIDR, c.serial).String(),
+			StartTime: &start,
+		},
+	}
+}
+
+// addLink creates the veth of a pod, the index follows the serial of the pod.
+func (c *cluster) addLink(pod corev1.Pod) kprobe.NeighLink {
+	index := int(c.serial) + 100
+	link := kprobe.NeighLink{
+		Link: &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Index: index, Name: fmt.Sprintf("vethsim%d", c.serial)}},
+		Neigh: netlink.Neigh{
+			LinkIndex: index,
+			IP:        net.ParseIP(pod.Status.PodIP),
+		},
+	}
+	c.links[index] = link
+	c.linkPods[index] = pod
+	return link
+}
+
+func (c *cluster) cache(pod corev1.Pod) {
+	c.pods[string(pod.UID)] = pod
+	c.pods[pod.Status.PodIP] = pod
+}
+
+// replace deletes a random pod and starts another one of its workload at now, the new pod is added to
+// the cache after the informer delay.
+func (c *cluster) replace(now time.Time) {
+	c.Lock()
+	indexes := c.indexes()
+	if len(indexes) == 0 {
+		c.Unlock()
+		return
+	}
+	index := indexes[c.rand.Intn(len(indexes))]
+	removed, old := c.links[index], c.linkPods[index]
+	delete(c.links, index)
+	delete(c.linkPods, index)
+	delete(c.pods, string(old.UID))
+	delete(c.pods, old.Status.PodIP)
+
+	pod := c.newPod(workloadOf(old), now)
+	added := c.addLink(pod)
+	if c.cfg.InformerDelay <= 0 {
+		c.cache(pod)
+	} else {
+		time.AfterFunc(c.cfg.InformerDelay, func() {
+			c.Lock()
+			defer c.Unlock()
+			// the pod may be replaced again in the meantime
+			if _, ok := c.linkPods[added.Link.Attrs().Index]; ok {
+				c.cache(pod)
+			}
+		})
+	}
+	listeners := c.listeners
+	c.Unlock()
+
+	for _, ch := range listeners {
+		ch <- kprobe.NeighLinkEvent{Type: kprobe.LinkDelete, NeighLink: removed}
+		ch <- kprobe.NeighLinkEvent{Type: kprobe.LinkAdd, NeighLink: added}
+	}
+}
+
+func workloadOf(pod corev1.Pod) int {
+	for i, w := range workloads {
+		if w.name == pod.Labels["app"] {
+			return i
+		}
+	}
+	return 0
+}
+
+// indexes returns the indexes of the veths in order, the iteration of the maps is random and the seed
+// should reproduce the run.
+func (c *cluster) indexes() []int {
+	ans := make([]int, 0, len(c.links))
+	for index := range c.links {
+		ans = append(ans, index)
+	}
+	sort.Ints(ans)
+	return ans
+}
+
+// backends returns the pods behind the veths of a workload, whether they are cached or not.
+func (c *cluster) backends(w int) []corev1.Pod {
+	ans := make([]corev1.Pod, 0)
+	for _, index := range c.indexes() {
+		if pod := c.linkPods[index]; pod.Labels["app"] == workloads[w].name {
+			ans = append(ans, pod)
+		}
+	}
+	return ans
+}
+
+// serviceOf returns the service of a workload.
+func (c *cluster) serviceOf(w int) corev1.Service {
+	return c.services[nthIP(serviceCIDR, uint32(w+10)).String()]
+}
+
+// connect records the nat record of a connection from ip:port to a service, as the netfilter plugin does
+// for the connections translated by kube-proxy.
+func (c *cluster) connect(ip string, port uint16, svc corev1.Service, backend corev1.Pod, backendPort uint16) {
+	c.nat.Set(fmt.Sprintf("%s:%d", ip, port), netfilter.NatInfo{
+		OriDstIP:     svc.Spec.ClusterIP,
+		OriDstPort:   uint16(svc.Spec.Ports[0].Port),
+		ReplyDstIP:   backend.Status.PodIP,
+		ReplyDstPort: backendPort,
+	}, time.Minute)
+}
@@ -0,0 +1,243 @@
+package simulate
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/metric"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+// tickInterval is how often the generator sends the requests due at the rate.
+const tickInterval = 100 * time.Millisecond
+
+type httpOperation struct {
+	method string
+	path   string
+}
+
+type redisOperation struct {
+	command    string
+	keyPattern string
+}
+
+var (
+	httpOperations = []httpOperation{
+		{method: "GET", path: "/api/products"},
+		{method: "GET", path: "/api/products/{id}"},
+		{method: "POST", path: "/api/cart"},
+		{method: "GET", path: "/healthz"},
+	}
+	grpcMethods     = []string{"PlaceOrder", "GetQuote"}
+	mysqlStatements = []string{
+		"SELECT * FROM orders WHERE id = ?",
+		"INSERT INTO orders (user_id, total) VALUES (?, ?)",
+		"UPDATE orders SET status = ? WHERE id = ?",
+	}
+	redisOperations = []redisOperation{
+		{command: "GET", keyPattern: "session:*"},
+		{command: "SET", keyPattern: "session:*"},
+		{command: "EXPIRE", keyPattern: "session:*"},
+	}
+	// latencies are the medians of the requests of the protocols.
+	latencies = map[string]time.Duration{
+		protocolHTTP:  20 * time.Millisecond,
+		protocolGRPC:  10 * time.Millisecond,
+		protocolMySQL: 3 * time.Millisecond,
+		protocolRedis: 500 * time.Microsecond,
+	}
+)
+
+// request is a generated request, as decoded by the protocol plugins.
+type request struct {
+	protocol   string
+	sourceIP   string
+	sourcePort uint16
+	destIP     string
+	destPort   uint16
+	duration   uint64
+	error      bool
+	// operation is the index of the method, statement or command of the protocol.
+	operation int
+}
+
+type generator struct {
+	Log          logs.Logger
+	cluster      *cluster
+	kprobeHelper kprobe.Interface
+	enricher     enrich.Interface
+	rand         *rand.Rand
+}
+
+func (p *generator) Init(ctx servicehub.Context) error {
+	p.cluster = fixture()
+	p.kprobeHelper = ctx.Service("kprobe").(kprobe.Interface)
+	p.enricher = enrich.New(p.kprobeHelper, ctx.Service("netfilter").(netfilter.Interface))
+	p.rand = rand.New(rand.NewSource(p.cluster.cfg.Seed + 1))
+	return nil
+}
+
+func (p *generator) Gather(c chan *metric.Metric) {
+	p.Log.Infof("simulating %d pods, %.f requests/s, pods replaced every %s", p.cluster.cfg.Pods, p.cluster.cfg.Rate, p.cluster.cfg.Churn)
+	go func() {
+		c <- kprobe.WaitSynced(p.kprobeHelper, "simulate")
+		emit := func(m *metric.Metric) { c <- m }
+		flush := p.cluster.clock.NewTicker(enrich.FlushInterval)
+		defer flush.Stop()
+		tick := p.cluster.clock.NewTicker(tickInterval)
+		defer tick.Stop()
+		// budget carries the fraction of the requests due at low rates.
+		budget := 0.0
+		for {
+			select {
+			case <-tick.C():
+				budget += p.cluster.cfg.Rate * tickInterval.Seconds()
+				for ; budget >= 1; budget-- {
+					r := p.request()
+					p.enricher.Submit(func() *metric.Metric { return p.convert(&r) }, emit)
+				}
+			case <-flush.C():
+				p.enricher.Flush()
+			}
+		}
+	}()
+}
+
+// request generates a request from a random pod of the node, to a service or outside the cluster.
+func (p *generator) request() request {
+	p.cluster.RLock()
+	indexes := p.cluster.indexes()
+	client := p.cluster.linkPods[indexes[p.rand.Intn(len(indexes))]]
+	r := request{
+		sourceIP:   client.Status.PodIP,
+		sourcePort: uint16(32768 + p.rand.Intn(28232)),
+		error:      p.rand.Float64() < p.cluster.cfg.ErrorRatio,
+	}
+	if p.rand.Float64() < p.cluster.cfg.ExternalRatio {
+		r.protocol = protocolHTTP
+		r.destIP = nthIP(externalCIDR, uint32(1+p.rand.Intn(254))).String()
+		r.destPort = 80
+	} else {
+		w := p.rand.Intn(len(workloads))
+		svc, backends := p.cluster.serviceOf(w), p.cluster.backends(w)
+		backend := backends[p.rand.Intn(len(backends))]
+		r.protocol = workloads[w].protocol
+		r.destIP, r.destPort = svc.Spec.ClusterIP, uint16(svc.Spec.Ports[0].Port)
+		p.cluster.connect(r.sourceIP, r.sourcePort, svc, backend, workloads[w].port)
+	}
+	p.cluster.RUnlock()
+
+	// log-normal latencies, the slowest requests are about 5 times the median.
+	r.duration = uint64(float64(latencies[r.protocol]) * math.Exp(p.rand.NormFloat64()/2))
+	switch r.protocol {
+	case protocolHTTP:
+		r.operation = p.rand.Intn(len(httpOperations))
+	case protocolGRPC:
+		r.operation = p.rand.Intn(len(grpcMethods))
+	case protocolMySQL:
+		r.operation = p.rand.Intn(len(mysqlStatements))
+	case protocolRedis:
+		r.operation = p.rand.Intn(len(redisOperations))
+	}
+	return r
+}
+
+// convert converts a request like the plugin of its protocol.
+func (p *generator) convert(r *request) *metric.Metric {
+	output := &metric.Metric{
+		Timestamp: p.cluster.clock.Now().UnixNano(),
+		Tags: map[string]string{
+			"error": strconv.FormatBool(r.error),
+		},
+		Fields: map[string]interface{}{
+			"elapsed_count": 1,
+			"elapsed_sum":   r.duration,
+			"elapsed_max":   r.duration,
+			"elapsed_min":   r.duration,
+			"elapsed_mean":  r.duration,
+		},
+	}
+	var measurement, component string
+	switch r.protocol {
+	case protocolHTTP:
+		op := httpOperations[r.operation]
+		status := 200
+		measurement, component = "application_http", "HTTP"
+		if r.error {
+			status = 500
+			measurement = "application_http_error"
+		}
+		output.Tags["http_method"] = op.method
+		output.Tags["http_path"] = op.path
+		output.Tags["http_target"] = op.path
+		output.Tags["http_status_code"] = strconv.Itoa(status)
+		output.Tags["http_version"] = "1.1"
+	case protocolGRPC:
+		method := grpcMethods[r.operation]
+		measurement, component = "application_rpc", "GRPC"
+		output.Tags["rpc_type"] = "GRPC"
+		output.Tags["rpc_target"] = "/checkout.Checkout/" + method
+		output.Tags["rpc_service"] = "checkout.Checkout"
+		output.Tags["rpc_method"] = method
+		output.Tags["http_status_code"] = "200"
+		output.Tags["grpc_status_code"], output.Tags["grpc_status"] = "0", "OK"
+		if r.error {
+			output.Tags["grpc_status_code"], output.Tags["grpc_status"] = "14", "UNAVAILABLE"
+		}
+	case protocolMySQL:
+		measurement, component = "application_db", "MYSQL"
+		output.Tags["db_type"] = "mysql"
+		output.Tags["db_command"] = "COM_QUERY"
+		output.Tags["db_statement"] = mysqlStatements[r.operation]
+		if r.error {
+			measurement = "application_db_error"
+			output.Tags["db_error_code"] = "1213"
+			output.Tags["db_error"] = "Deadlock found when trying to get lock; try restarting transaction"
+		}
+	case protocolRedis:
+		op := redisOperations[r.operation]
+		measurement, component = "application_cache", "REDIS"
+		output.Tags["db_type"] = "redis"
+		output.Tags["db_statement"] = op.command + " " + op.keyPattern
+		output.Tags["redis_command"] = op.command
+		output.Tags["redis_key_pattern"] = op.keyPattern
+		if r.error {
+			measurement = "application_cache_error"
+			output.Tags["redis_error_type"] = "ERR"
+			output.Tags["redis_error"] = "ERR wrong number of arguments"
+		}
+	}
+	output.Name, output.Measurement = measurement, measurement
+
+	inCluster := p.enricher.Enrich(output, component, enrich.Endpoints{
+		SourceIP:   r.sourceIP,
+		SourcePort: r.sourcePort,
+		DestIP:     r.destIP,
+		DestPort:   r.destPort,
+	})
+	if r.protocol == protocolMySQL || r.protocol == protocolRedis {
+		output.Tags["db_host"] = output.Tags["peer_address"]
+	}
+	// external targets are kept with their surrogate identity, as the http plugin does.
+	if !inCluster && !enrich.Surrogate(output) {
+		return nil
+	}
+	return output
+}
+
+func init() {
+	servicehub.Register("simulate", &servicehub.Spec{
+		Services:     []string{"simulate"},
+		Description:  "generated protocol requests of the simulation",
+		Dependencies: []string{"kprobe", "netfilter"},
+		Creator: func() servicehub.Provider {
+			return &generator{}
+		},
+	})
+}
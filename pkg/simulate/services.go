@@ -0,0 +1,173 @@
+package simulate
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erda-project/ebpf-agent/pkg/clock"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe/kprobesysctl"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/netfilter"
+	"github.com/erda-project/erda-infra/base/servicehub"
+)
+
+// kprobeProvider is the kprobe service of the synthetic node.
+type kprobeProvider struct {
+	cluster *cluster
+	ticker  clock.Ticker
+}
+
+func (p *kprobeProvider) Init(ctx servicehub.Context) error {
+	p.cluster = fixture()
+	return nil
+}
+
+func (p *kprobeProvider) Start() error {
+	if p.cluster.cfg.Churn <= 0 {
+		return nil
+	}
+	p.ticker = p.cluster.clock.NewTicker(p.cluster.cfg.Churn)
+	go func() {
+		for now := range p.ticker.C() {
+			p.cluster.replace(now)
+		}
+	}()
+	return nil
+}
+
+func (p *kprobeProvider) Close() error {
+	if p.ticker != nil {
+		p.ticker.Stop()
+	}
+	return nil
+}
+
+// GetSysctlStat fails, the processes of the synthetic pods do not exist.
+func (p *kprobeProvider) GetSysctlStat(pid uint32) (kprobesysctl.SysctlStat, error) {
+	return kprobesysctl.SysctlStat{}, fmt.Errorf("failed to find sysctl stat for pid: %d", pid)
+}
+
+func (p *kprobeProvider) GetPodByUID(podUID string) (corev1.Pod, error) {
+	p.cluster.RLock()
+	defer p.cluster.RUnlock()
+	if pod, ok := p.cluster.pods[podUID]; ok {
+		return pod, nil
+	}
+	return corev1.Pod{}, fmt.Errorf("failed to find pod for uid: %s", podUID)
+}
+
+// GetPodByPID fails, the processes of the synthetic pods do not exist.
+func (p *kprobeProvider) GetPodByPID(pid uint32) (corev1.Pod, error) {
+	return corev1.Pod{}, fmt.Errorf("failed to find pod for pid: %d", pid)
+}
+
+func (p *kprobeProvider) GetLocalPods() []corev1.Pod {
+	p.cluster.RLock()
+	defer p.cluster.RUnlock()
+	ans := make([]corev1.Pod, 0)
+	for key, pod := range p.cluster.pods {
+		if key == string(pod.UID) {
+			ans = append(ans, pod)
+		}
+	}
+	return ans
+}
+
+func (p *kprobeProvider) GetService(ip string) (corev1.Service, error) {
+	p.cluster.RLock()
+	defer p.cluster.RUnlock()
+	if svc, ok := p.cluster.services[ip]; ok {
+		return svc, nil
+	}
+	return corev1.Service{}, fmt.Errorf("failed to get service from cache, ip: %s", ip)
+}
+
+func (p *kprobeProvider) RegisterNetLinkListener() <-chan kprobe.NeighLinkEvent {
+	p.cluster.Lock()
+	defer p.cluster.Unlock()
+	ch := make(chan kprobe.NeighLinkEvent, 10)
+	p.cluster.listeners = append(p.cluster.listeners, ch)
+	return ch
+}
+
+func (p *kprobeProvider) GetVethes() ([]kprobe.NeighLink, error) {
+	p.cluster.RLock()
+	defer p.cluster.RUnlock()
+	ans := make([]kprobe.NeighLink, 0, len(p.cluster.links))
+	for _, index := range p.cluster.indexes() {
+		ans = append(ans, p.cluster.links[index])
+	}
+	return ans, nil
+}
+
+// GetHostInterfaces is empty, the synthetic pods have veths only.
+func (p *kprobeProvider) GetHostInterfaces() []kprobe.NeighLink {
+	return nil
+}
+
+// GetSharedInterfaces is empty, the synthetic pods have veths only.
+func (p *kprobeProvider) GetSharedInterfaces() []kprobe.NeighLink {
+	return nil
+}
+
+// GetNamespacedInterfaces is empty, the synthetic pods have veths only.
+func (p *kprobeProvider) GetNamespacedInterfaces() []kprobe.NeighLink {
+	return nil
+}
+
+func (p *kprobeProvider) Synced() <-chan struct{} {
+	return p.cluster.synced
+}
+
+// netfilterProvider is the netfilter service of the synthetic node, the generator records the nat
+// records of its connections to the services.
+type netfilterProvider struct {
+	cluster *cluster
+}
+
+func (p *netfilterProvider) Init(ctx servicehub.Context) error {
+	p.cluster = fixture()
+	return nil
+}
+
+func (p *netfilterProvider) GetNatInfo(ip string, port uint16) (netfilter.NatInfo, bool) {
+	natInfo, ok := p.cluster.nat.Get(fmt.Sprintf("%s:%d", ip, port))
+	if !ok {
+		return netfilter.NatInfo{}, false
+	}
+	return natInfo.(netfilter.NatInfo), true
+}
+
+func (p *netfilterProvider) NatEntries() map[string]netfilter.NatInfo {
+	items := p.cluster.nat.Items()
+	ans := make(map[string]netfilter.NatInfo, len(items))
+	for k, v := range items {
+		ans[k] = v.Object.(netfilter.NatInfo)
+	}
+	return ans
+}
+
+var (
+	_ kprobe.Interface    = (*kprobeProvider)(nil)
+	_ netfilter.Interface = (*netfilterProvider)(nil)
+)
+
+func init() {
+	servicehub.Register("simulate.kprobe", &servicehub.Spec{
+		Services:     []string{"kprobe"},
+		Description:  "synthetic pods, services and veths of the simulation",
+		Dependencies: []string{},
+		Creator: func() servicehub.Provider {
+			return &kprobeProvider{}
+		},
+	})
+	servicehub.Register("simulate.netfilter", &servicehub.Spec{
+		Services:     []string{"netfilter"},
+		Description:  "synthetic nat records of the simulation",
+		Dependencies: []string{},
+		Creator: func() servicehub.Provider {
+			return &netfilterProvider{}
+		},
+	})
+}
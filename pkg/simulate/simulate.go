@@ -0,0 +1,55 @@
+// Package simulate runs the userspace of the agent without eBPF privileges, see Flag.
+//
+// The kprobe and netfilter services are replaced by a synthetic node: its pods and their veths, the
+// services selecting them and the nat records of the connections to the services. The pods are replaced
+// at SIMULATE_CHURN, their veths are announced to the listeners at once while the informer cache learns
+// the new pods SIMULATE_INFORMER_DELAY later, as on a busy api server. The simulate plugin generates the
+// http, grpc, mysql and redis requests of the pods at SIMULATE_RATE and converts them like the protocol
+// plugins, through the enrich package, so the controller, the exporters and the plugins reading the kprobe
+// service get the load of a node.
+package simulate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/envconf"
+)
+
+// Flag is the first argument of the agent selecting the simulation, e.g. ebpf-agent -simulate.
+const Flag = "-simulate"
+
+type Config struct {
+	// Pods is the number of pods of the node, they are spread over the workloads.
+	Pods int `env:"SIMULATE_PODS" default:"12"`
+	// Rate is the number of requests generated per second.
+	Rate float64 `env:"SIMULATE_RATE" default:"100"`
+	// Churn is how often a pod is replaced by a new one, 0 keeps the pods.
+	Churn time.Duration `env:"SIMULATE_CHURN" default:"30s"`
+	// InformerDelay is how long the new pods are missing in the cache after their veths are added.
+	InformerDelay time.Duration `env:"SIMULATE_INFORMER_DELAY" default:"2s"`
+	// ErrorRatio is the share of the requests failing.
+	ErrorRatio float64 `env:"SIMULATE_ERROR_RATIO" default:"0.05"`
+	// ExternalRatio is the share of the requests to addresses outside the cluster.
+	ExternalRatio float64 `env:"SIMULATE_EXTERNAL_RATIO" default:"0.1"`
+	// Seed seeds the fixtures and the requests, 0 seeds with the time.
+	Seed int64 `env:"SIMULATE_SEED" default:"0"`
+}
+
+var (
+	sharedCluster     *cluster
+	sharedClusterOnce sync.Once
+)
+
+// fixture is the synthetic node shared by the simulated services and the generator.
+func fixture() *cluster {
+	sharedClusterOnce.Do(func() {
+		cfg := Config{}
+		envconf.MustLoad(&cfg)
+		if cfg.Seed == 0 {
+			cfg.Seed = time.Now().UnixNano()
+		}
+		sharedCluster = newCluster(cfg)
+	})
+	return sharedCluster
+}
@@ -0,0 +1,67 @@
+package simulate
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/erda-project/ebpf-agent/pkg/plugins/kprobe"
+	"github.com/erda-project/ebpf-agent/pkg/plugins/protocols/enrich"
+)
+
+func TestReplace(t *testing.T) {
+	c := newCluster(Config{Pods: 8, Seed: 1})
+	k := &kprobeProvider{cluster: c}
+	events := k.RegisterNetLinkListener()
+	before, _ := k.GetVethes()
+	if len(before) != 8 || len(k.GetLocalPods()) != 8 {
+		t.Fatalf("the node should have 8 pods, got %d veths and %d pods", len(before), len(k.GetLocalPods()))
+	}
+
+	c.replace(time.Now())
+	deleted, added := <-events, <-events
+	if deleted.Type != kprobe.LinkDelete || added.Type != kprobe.LinkAdd {
+		t.Fatalf("unexpected events %s, %s", deleted.Type, added.Type)
+	}
+	if _, err := k.GetPodByUID(deleted.Neigh.IP.String()); err == nil {
+		t.Errorf("the deleted pod should be removed from the cache")
+	}
+	pod, err := k.GetPodByUID(added.Neigh.IP.String())
+	if err != nil {
+		t.Fatalf("the new pod should be cached without informer delay: %v", err)
+	}
+	if pod.Status.StartTime.Time.Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("the new pod should be started now, got %s", pod.Status.StartTime)
+	}
+	after, _ := k.GetVethes()
+	if len(after) != 8 {
+		t.Errorf("the node should keep 8 veths, got %d", len(after))
+	}
+}
+
+func TestRequest(t *testing.T) {
+	c := newCluster(Config{Pods: 10, Seed: 1})
+	g := &generator{
+		cluster:  c,
+		enricher: enrich.New(&kprobeProvider{cluster: c}, &netfilterProvider{cluster: c}),
+		rand:     rand.New(rand.NewSource(2)),
+	}
+	for i := 0; i < 100; i++ {
+		r := g.request()
+		m := g.convert(&r)
+		if m == nil {
+			t.Fatalf("request %+v should be converted", r)
+		}
+		svc, err := (&kprobeProvider{cluster: c}).GetService(r.destIP)
+		if err != nil {
+			t.Fatalf("requests should target the services: %v", err)
+		}
+		// the nat records resolve the service to one of its pods
+		if m.Tags["target_service_name"] != svc.Name || m.Tags["source_service_name"] == "" {
+			t.Errorf("request %+v converted without its pods: %v", r, m.Tags)
+		}
+		if m.Fields["elapsed_sum"] != r.duration || r.duration == 0 {
+			t.Errorf("unexpected duration %v", m.Fields["elapsed_sum"])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package supportbundle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+
+	"github.com/erda-project/ebpf-agent/pkg/kernelbtf"
+)
+
+// secretEnv matches the environment variables whose values are left out of the bundle.
+var secretEnv = regexp.MustCompile(`(?i)PASSWORD|TOKEN|SECRET|CREDENTIAL|AUTH`)
+
+// environ returns the environment of the agent sorted by name, secrets are redacted.
+func environ() []byte {
+	env := os.Environ()
+	sort.Strings(env)
+	var b bytes.Buffer
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if secretEnv.MatchString(k) && v != "" {
+			v = "<redacted>"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", k, v)
+	}
+	return b.Bytes()
+}
+
+type Capabilities struct {
+	KernelRelease string `json:"kernel_release"`
+	// BTF reports whether the kernel exposes its type information (/sys/kernel/btf/vmlinux).
+	BTF bool `json:"btf"`
+	// BTFSource is where the CO-RE programs take the kernel types from, "kernel" or the configured file or URL.
+	BTFSource string `json:"btf_source"`
+	// CgroupV2 reports whether the unified cgroup hierarchy is mounted.
+	CgroupV2     bool              `json:"cgroup_v2"`
+	ProgramTypes map[string]string `json:"program_types"`
+	MapTypes     map[string]string `json:"map_types"`
+}
+
+// program and map types used by the plugins.
+var (
+	programTypes = []ebpf.ProgramType{ebpf.SocketFilter, ebpf.Kprobe, ebpf.TracePoint, ebpf.SchedCLS}
+	mapTypes     = []ebpf.MapType{ebpf.Hash, ebpf.LRUHash, ebpf.PerCPUArray, ebpf.PerfEventArray, ebpf.RingBuf}
+)
+
+// capabilities probes the kernel features the plugins depend on, a type is either
+// "supported" or the reason it is not.
+func capabilities() Capabilities {
+	c := Capabilities{
+		ProgramTypes: make(map[string]string),
+		MapTypes:     make(map[string]string),
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		c.KernelRelease = unix.ByteSliceToString(uts.Release[:])
+	}
+	_, err := os.Stat("/sys/kernel/btf/vmlinux")
+	c.BTF = err == nil
+	c.BTFSource = kernelbtf.Origin()
+	_, err = os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	c.CgroupV2 = err == nil
+	for _, t := range programTypes {
+		c.ProgramTypes[t.String()] = support(features.HaveProgramType(t))
+	}
+	for _, t := range mapTypes {
+		c.MapTypes[t.String()] = support(features.HaveMapType(t))
+	}
+	return c
+}
+
+func support(err error) string {
+	if err == nil {
+		return "supported"
+	}
+	return err.Error()
+}
+
+// kernelInfo returns the kernel version, the boot parameters and the os release of the node.
+func kernelInfo() []byte {
+	var b bytes.Buffer
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		fmt.Fprintf(&b, "uname: %s %s %s %s\n\n",
+			unix.ByteSliceToString(uts.Sysname[:]), unix.ByteSliceToString(uts.Release[:]),
+			unix.ByteSliceToString(uts.Version[:]), unix.ByteSliceToString(uts.Machine[:]))
+	}
+	// the root of the node is mounted at /rootfs in the daemonset.
+	for _, path := range []string{"/proc/version", "/proc/cmdline", "/rootfs/etc/os-release", "/etc/os-release"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n%s\n", path, bytes.TrimSpace(data))
+	}
+	return b.Bytes()
+}
+
+type MapOccupancy struct {
+	ID         uint32 `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	MaxEntries uint32 `json:"max_entries"`
+	// Entries is the number of keys of hash maps, -1 for the other types.
+	Entries int `json:"entries"`
+}
+
+// mapOccupancy lists the bpf maps loaded on the node with the number of entries of the hash maps,
+// the maps of the agent are named after their sections (e.g. metrics_map, filter_map).
+func mapOccupancy() ([]MapOccupancy, error) {
+	var ans []MapOccupancy
+	var id ebpf.MapID
+	for {
+		next, err := ebpf.MapGetNextID(id)
+		if errors.Is(err, os.ErrNotExist) {
+			return ans, nil
+		}
+		if err != nil {
+			return ans, err
+		}
+		id = next
+		m, err := ebpf.NewMapFromID(id)
+		if err != nil {
+			// the map was released in between
+			continue
+		}
+		info, err := m.Info()
+		if err != nil {
+			m.Close()
+			continue
+		}
+		o := MapOccupancy{
+			ID:         uint32(id),
+			Name:       info.Name,
+			Type:       info.Type.String(),
+			MaxEntries: info.MaxEntries,
+			Entries:    -1,
+		}
+		switch info.Type {
+		case ebpf.Hash, ebpf.LRUHash, ebpf.PerCPUHash, ebpf.LRUCPUHash:
+			o.Entries = countKeys(m, int(info.MaxEntries))
+		}
+		m.Close()
+		ans = append(ans, o)
+	}
+}
+
+// countKeys walks the keys of a hash map, keys deleted during the walk may restart it,
+// the count is bounded by the size of the map.
+func countKeys(m *ebpf.Map, max int) int {
+	// a nil interface starts at the first key
+	var key interface{}
+	n := 0
+	for n < max {
+		next, err := m.NextKeyBytes(key)
+		if err != nil || next == nil {
+			break
+		}
+		key = next
+		n++
+	}
+	return n
+}
@@ -0,0 +1,132 @@
+package supportbundle
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"github.com/erda-project/erda-infra/base/logs/logrusx"
+	"github.com/sirupsen/logrus"
+	klogv1 "k8s.io/klog"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+const (
+	maxErrors  = 200
+	maxMetrics = 500
+	// selfMetricPrefix is the measurement prefix of the metrics about the agent itself,
+	// e.g. ebpf_plugin_startup and ebpf_event_budget.
+	selfMetricPrefix = "ebpf_"
+)
+
+// ring keeps the last entries written to it.
+type ring[T any] struct {
+	sync.Mutex
+	entries []T
+	next    int
+	size    int
+}
+
+func newRing[T any](size int) *ring[T] {
+	return &ring[T]{entries: make([]T, 0, size), size: size}
+}
+
+func (r *ring[T]) add(v T) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.entries) < r.size {
+		r.entries = append(r.entries, v)
+		return
+	}
+	r.entries[r.next] = v
+	r.next = (r.next + 1) % r.size
+}
+
+// list returns the entries from the oldest to the newest.
+func (r *ring[T]) list() []T {
+	r.Lock()
+	defer r.Unlock()
+	ans := make([]T, 0, len(r.entries))
+	ans = append(ans, r.entries[r.next:]...)
+	return append(ans, r.entries[:r.next]...)
+}
+
+var (
+	errorLines  = newRing[string](maxErrors)
+	selfMetrics = newRing[*metric.Metric](maxMetrics)
+)
+
+// RecordMetric keeps the self metrics of the agent for the bundle, the other metrics are ignored.
+func RecordMetric(m *metric.Metric) {
+	if m != nil && strings.HasPrefix(m.Measurement, selfMetricPrefix) {
+		selfMetrics.add(m)
+	}
+}
+
+// errorWriter receives the error lines of klog, each write is one line.
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	errorLines.add(string(bytes.TrimRight(p, "\n")))
+	return len(p), nil
+}
+
+// hookKlog keeps writing all klog lines to stderr and copies the errors to the ring. klog writes
+// a line to the outputs of its severity and all lower ones, only the error output is kept.
+func hookKlog() {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klogv1.InitFlags(fs)
+	_ = fs.Set("logtostderr", "false")
+	_ = fs.Set("alsologtostderr", "true")
+	klogv1.SetOutputBySeverity("INFO", io.Discard)
+	klogv1.SetOutputBySeverity("WARNING", io.Discard)
+	klogv1.SetOutputBySeverity("ERROR", errorWriter{})
+	klogv1.SetOutputBySeverity("FATAL", io.Discard)
+
+	fs = flag.NewFlagSet("klog/v2", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	_ = fs.Set("logtostderr", "false")
+	_ = fs.Set("alsologtostderr", "true")
+	klog.SetOutputBySeverity("INFO", io.Discard)
+	klog.SetOutputBySeverity("WARNING", io.Discard)
+	klog.SetOutputBySeverity("ERROR", errorWriter{})
+	klog.SetOutputBySeverity("FATAL", io.Discard)
+}
+
+// errorHook copies the errors of the provider loggers to the ring.
+type errorHook struct{}
+
+func (errorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (errorHook) Fire(e *logrus.Entry) error {
+	line := fmt.Sprintf("%s %s", e.Time.Format(time.RFC3339Nano), strings.ToUpper(e.Level.String()))
+	if module, ok := e.Data["module"]; ok {
+		line += fmt.Sprintf(" [%v]", module)
+	}
+	errorLines.add(line + " " + e.Message)
+	return nil
+}
+
+// newLogger creates the logger of the service hub as the hub does (LOG_LEVEL), with the error hook.
+func newLogger() logs.Logger {
+	var logger logs.Logger
+	if lvl, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL")); err == nil {
+		logger = logrusx.New(logrusx.WithLevel(lvl))
+	} else {
+		logger = logrusx.New()
+	}
+	if l, ok := logger.(*logrusx.Logger); ok {
+		l.Logger.AddHook(errorHook{})
+	}
+	return logger
+}
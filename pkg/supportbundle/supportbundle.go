@@ -0,0 +1,176 @@
+// Package supportbundle collects the state of the agent into a single archive to attach to bug reports.
+//
+// The running agent serves the archive on its local debug server (localhost:8777, next to pprof),
+// the support-bundle command of the agent binary downloads it:
+//
+//	kubectl exec -n <namespace> <agent pod> -- /main support-bundle -o /tmp/bundle.tar.gz
+//	kubectl cp <namespace>/<agent pod>:/tmp/bundle.tar.gz bundle.tar.gz
+//
+// The archive (tar.gz) contains:
+//
+//	bootstrap.yaml       plugins and provider config of the agent
+//	env.txt              environment of the agent, values of credentials are redacted
+//	capabilities.json    kernel release, BTF and its source, cgroup v2 and the bpf program and map types the plugins depend on
+//	maps.json            bpf maps of the node with the entries of the hash maps
+//	self_metrics.json    the last self metrics of the agent (ebpf_* measurements)
+//	errors.log           the last error lines of the agent
+//	kernel.txt           uname, /proc/version, /proc/cmdline and the os release of the node
+//
+// If the agent can not be reached the command collects what is available without it,
+// the archive then lacks self_metrics.json and errors.log.
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/erda-project/erda-infra/base/logs"
+	"k8s.io/klog"
+)
+
+const (
+	// Command is the argument of the agent binary that creates a bundle.
+	Command = "support-bundle"
+
+	adminPath = "/debug/support-bundle"
+)
+
+var bootstrapCfg string
+
+// Install records the errors of the agent for the bundles and serves them on the debug server,
+// it returns the logger of the service hub. It must run before the service hub is created.
+func Install(bootstrap string) logs.Logger {
+	bootstrapCfg = bootstrap
+	hookKlog()
+	// served by the debug server of the agent on localhost only.
+	http.HandleFunc(adminPath, serveBundle)
+	return newLogger()
+}
+
+func serveBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	if err := write(w, true); err != nil {
+		klog.Errorf("failed to write support bundle: %v", err)
+	}
+}
+
+// write writes the archive, the records of the agent are only available in its own process.
+func write(w io.Writer, records bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, data)
+	}
+
+	if bootstrapCfg != "" {
+		if err := add("bootstrap.yaml", []byte(bootstrapCfg)); err != nil {
+			return err
+		}
+	}
+	if err := add("env.txt", environ()); err != nil {
+		return err
+	}
+	if err := addJSON("capabilities.json", capabilities()); err != nil {
+		return err
+	}
+	maps, err := mapOccupancy()
+	if err != nil {
+		maps = append(maps, MapOccupancy{Name: fmt.Sprintf("incomplete: %v", err)})
+	}
+	if err := addJSON("maps.json", maps); err != nil {
+		return err
+	}
+	if records {
+		if err := addJSON("self_metrics.json", selfMetrics.list()); err != nil {
+			return err
+		}
+		var lines []byte
+		for _, line := range errorLines.list() {
+			lines = append(lines, line...)
+			lines = append(lines, '\n')
+		}
+		if err := add("errors.log", lines); err != nil {
+			return err
+		}
+	}
+	if err := add("kernel.txt", kernelInfo()); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Main runs the support-bundle command with its arguments, it returns the exit code.
+func Main(args []string) int {
+	fs := flag.NewFlagSet(Command, flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8777", "debug server of the running agent")
+	output := fs.String("o", "", "path of the archive (default erda-agent-support-<node>-<time>.tar.gz)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	path := *output
+	if path == "" {
+		node := os.Getenv("NODE_NAME")
+		if node == "" {
+			node, _ = os.Hostname()
+		}
+		path = fmt.Sprintf("erda-agent-support-%s-%s.tar.gz", node, time.Now().Format("20060102150405"))
+	}
+	if err := create(path, *addr); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create support bundle: %v\n", err)
+		return 1
+	}
+	fmt.Println(path)
+	return 0
+}
+
+func create(path, addr string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get("http://" + addr + adminPath)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		defer resp.Body.Close()
+		_, err = io.Copy(f, resp.Body)
+		return err
+	}
+	if err == nil {
+		resp.Body.Close()
+		err = fmt.Errorf("status %s", resp.Status)
+	}
+	fmt.Fprintf(os.Stderr, "agent not reachable at %s (%v), collecting without its self metrics and errors\n", addr, err)
+	return write(f, false)
+}
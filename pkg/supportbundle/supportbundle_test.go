@@ -0,0 +1,101 @@
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	klogv1 "k8s.io/klog"
+	"k8s.io/klog/v2"
+
+	"github.com/erda-project/ebpf-agent/metric"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing[int](3)
+	for i := 1; i <= 5; i++ {
+		r.add(i)
+	}
+	got := r.list()
+	if len(got) != 3 || got[0] != 3 || got[1] != 4 || got[2] != 5 {
+		t.Errorf("expected the last 3 entries in order, got %v", got)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("COLLECTOR_AUTH_PASSWORD", "secret")
+	t.Setenv("COLLECTOR_ADDR", "collector:7076")
+	env := string(environ())
+	if strings.Contains(env, "secret") || !strings.Contains(env, "COLLECTOR_AUTH_PASSWORD=<redacted>") {
+		t.Errorf("credentials should be redacted:\n%s", env)
+	}
+	if !strings.Contains(env, "COLLECTOR_ADDR=collector:7076\n") {
+		t.Errorf("settings should be kept:\n%s", env)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	bootstrapCfg = "agent.controller:\n"
+	RecordMetric(&metric.Metric{Measurement: "ebpf_plugin_startup"})
+	RecordMetric(&metric.Metric{Measurement: "application_http"})
+	errorHook{}.Fire(&logrus.Entry{
+		Time:    time.Now(),
+		Level:   logrus.ErrorLevel,
+		Message: "failed to load dns ebpf program",
+		Data:    logrus.Fields{"module": "dns"},
+	})
+
+	var buf bytes.Buffer
+	if err := write(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[h.Name] = string(data)
+	}
+	for _, name := range []string{"bootstrap.yaml", "env.txt", "capabilities.json", "maps.json", "self_metrics.json", "errors.log", "kernel.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if !strings.Contains(files["self_metrics.json"], "ebpf_plugin_startup") || strings.Contains(files["self_metrics.json"], "application_http") {
+		t.Errorf("only self metrics should be kept:\n%s", files["self_metrics.json"])
+	}
+	if !strings.Contains(files["errors.log"], "ERROR [dns] failed to load dns ebpf program") {
+		t.Errorf("unexpected errors:\n%s", files["errors.log"])
+	}
+}
+
+func TestHookKlog(t *testing.T) {
+	hookKlog()
+	klogv1.Infof("info line")
+	klog.Errorf("v2 error line")
+	klogv1.Errorf("v1 error line")
+	var found []string
+	for _, line := range errorLines.list() {
+		if strings.Contains(line, "line") {
+			found = append(found, line)
+		}
+	}
+	if len(found) != 2 || !strings.Contains(found[0], "v2 error line") || !strings.Contains(found[1], "v1 error line") {
+		t.Errorf("expected the error lines once, got %q", found)
+	}
+}
@@ -2,10 +2,13 @@ package utils
 
 import (
 	"encoding/binary"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
 	"unsafe"
+
+	"github.com/vishvananda/netns"
 )
 
 // ETH_P_IP: Internet Protocol version 4 (IPv4)
@@ -33,6 +36,28 @@ func OpenRawSock(index int) (int, error) {
 	return sock, nil
 }
 
+// OpenRawSockAt opens a raw socket for the interface index in the network namespace of path, the socket
+// stays in that namespace.
+func OpenRawSockAt(path string, index int) (int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		return 0, err
+	}
+	defer origin.Close()
+	target, err := netns.GetFromPath(path)
+	if err != nil {
+		return 0, err
+	}
+	defer target.Close()
+	if err := netns.Set(target); err != nil {
+		return 0, err
+	}
+	defer netns.Set(origin)
+	return OpenRawSock(index)
+}
+
 // Htons converts to network byte order short uint16.
 func Htons(i uint16) uint16 {
 	b := make([]byte, 2)
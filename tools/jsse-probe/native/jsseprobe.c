@@ -0,0 +1,20 @@
+// libjsseprobe.so hands the plaintext of the JSSE connections of the JVM to erda_jsse_probe, the
+// function the tlsplain plugin of the agent attaches its uprobe to. Nothing is recorded without the agent.
+#include <jni.h>
+#include <stdint.h>
+
+__attribute__((noinline, used, visibility("default"))) void erda_jsse_probe(uint64_t id, const char *buf, int len,
+                                                                              int direction) {
+    // keeps the call from being optimized away
+    __asm__ volatile("" ::"r"(id), "r"(buf), "r"(len), "r"(direction) : "memory");
+}
+
+JNIEXPORT void JNICALL Java_cloud_erda_jsseprobe_Probe_record(JNIEnv *env, jclass cls, jlong id, jint direction,
+                                                              jbyteArray buf, jint len) {
+    jbyte *data = (*env)->GetPrimitiveArrayCritical(env, buf, NULL);
+    if (data == NULL) {
+        return;
+    }
+    erda_jsse_probe((uint64_t)id, (const char *)data, len, direction);
+    (*env)->ReleasePrimitiveArrayCritical(env, buf, data, JNI_ABORT);
+}
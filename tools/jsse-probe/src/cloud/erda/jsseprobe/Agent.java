@@ -0,0 +1,37 @@
+package cloud.erda.jsseprobe;
+
+import java.lang.instrument.Instrumentation;
+import java.security.Security;
+
+/**
+ * Agent installs the probe provider in front of the JSSE provider of the JVM:
+ *
+ * <pre>
+ * JAVA_TOOL_OPTIONS=-javaagent:/opt/erda/jsse-probe/jsse-probe.jar=/opt/erda/jsse-probe/libjsseprobe.so
+ * </pre>
+ *
+ * The SSLContexts created afterwards hand the plaintext of their SSLEngines to libjsseprobe.so, where the
+ * tlsplain plugin of the ebpf agent reads it. SSLSockets (blocking HttpsURLConnection) are not probed.
+ */
+public final class Agent {
+    private static final String DEFAULT_LIBRARY = "/opt/erda/jsse-probe/libjsseprobe.so";
+
+    public static void premain(String args, Instrumentation inst) {
+        install(args);
+    }
+
+    public static void agentmain(String args, Instrumentation inst) {
+        install(args);
+    }
+
+    private static synchronized void install(String args) {
+        if (Security.getProvider(ProbeProvider.NAME) != null) {
+            return;
+        }
+        String library = args == null || args.isEmpty() ? DEFAULT_LIBRARY : args;
+        if (!Probe.load(library)) {
+            return;
+        }
+        Security.insertProviderAt(new ProbeProvider(), 1);
+    }
+}
@@ -0,0 +1,51 @@
+package cloud.erda.jsseprobe;
+
+import java.nio.ByteBuffer;
+
+final class Probe {
+    static final int SEND = 0;
+    static final int RECV = 1;
+    // MAX is TLS_DATA_SIZE - 1 of ebpf/plugins/tlsplain/main.c, the agent only decodes the heads of the messages.
+    private static final int MAX = 1023;
+
+    private static final ThreadLocal<byte[]> BUFFERS = ThreadLocal.withInitial(() -> new byte[MAX]);
+
+    private Probe() {
+    }
+
+    static boolean load(String library) {
+        try {
+            System.load(library);
+            return true;
+        } catch (Throwable t) {
+            System.err.println("jsse-probe: failed to load " + library + ": " + t);
+            return false;
+        }
+    }
+
+    private static native void record(long id, int direction, byte[] buf, int len);
+
+    /**
+     * record hands the bytes of the buffers between their given start positions and their current positions,
+     * the bytes consumed by a wrap or produced by an unwrap.
+     */
+    static void record(long id, int direction, ByteBuffer[] bufs, int offset, int length, int[] starts, int total) {
+        if (total <= 0) {
+            return;
+        }
+        byte[] copy = BUFFERS.get();
+        int n = 0;
+        for (int i = 0; i < length && n < MAX; i++) {
+            ByteBuffer b = bufs[offset + i];
+            int end = Math.min(b.position(), starts[i] + MAX - n);
+            for (int p = starts[i]; p < end; p++) {
+                copy[n++] = b.get(p);
+            }
+        }
+        try {
+            record(id, direction, copy, n);
+        } catch (Throwable ignored) {
+            // the probe must never fail the connection
+        }
+    }
+}
@@ -0,0 +1,103 @@
+package cloud.erda.jsseprobe;
+
+import java.security.KeyManagementException;
+import java.security.NoSuchAlgorithmException;
+import java.security.NoSuchProviderException;
+import java.security.Provider;
+import java.security.SecureRandom;
+
+import javax.net.ssl.KeyManager;
+import javax.net.ssl.SSLContext;
+import javax.net.ssl.SSLContextSpi;
+import javax.net.ssl.SSLEngine;
+import javax.net.ssl.SSLServerSocketFactory;
+import javax.net.ssl.SSLSessionContext;
+import javax.net.ssl.SSLSocketFactory;
+import javax.net.ssl.TrustManager;
+
+/** ProbeProvider serves the SSLContexts of SunJSSE with probed engines. */
+final class ProbeProvider extends Provider {
+    static final String NAME = "ErdaJsseProbe";
+    private static final String DELEGATE = "SunJSSE";
+
+    @SuppressWarnings("deprecation")
+    ProbeProvider() {
+        super(NAME, 1.0, "SunJSSE SSLContexts handing their plaintext to the erda ebpf agent");
+        put("SSLContext.Default", Default.class.getName());
+        put("SSLContext.TLS", TLS.class.getName());
+        put("SSLContext.TLSv1.2", TLSv12.class.getName());
+        put("SSLContext.TLSv1.3", TLSv13.class.getName());
+    }
+
+    public static final class Default extends Context {
+        public Default() throws NoSuchAlgorithmException, NoSuchProviderException {
+            super("Default");
+        }
+    }
+
+    public static final class TLS extends Context {
+        public TLS() throws NoSuchAlgorithmException, NoSuchProviderException {
+            super("TLS");
+        }
+    }
+
+    public static final class TLSv12 extends Context {
+        public TLSv12() throws NoSuchAlgorithmException, NoSuchProviderException {
+            super("TLSv1.2");
+        }
+    }
+
+    public static final class TLSv13 extends Context {
+        public TLSv13() throws NoSuchAlgorithmException, NoSuchProviderException {
+            super("TLSv1.3");
+        }
+    }
+
+    abstract static class Context extends SSLContextSpi {
+        private final SSLContext delegate;
+        private final boolean preinitialized;
+
+        Context(String protocol) throws NoSuchAlgorithmException, NoSuchProviderException {
+            delegate = SSLContext.getInstance(protocol, DELEGATE);
+            preinitialized = "Default".equals(protocol);
+        }
+
+        @Override
+        protected void engineInit(KeyManager[] km, TrustManager[] tm, SecureRandom random) throws KeyManagementException {
+            if (preinitialized) {
+                throw new KeyManagementException("the default SSLContext is initialized automatically");
+            }
+            delegate.init(km, tm, random);
+        }
+
+        @Override
+        protected SSLSocketFactory engineGetSocketFactory() {
+            return delegate.getSocketFactory();
+        }
+
+        @Override
+        protected SSLServerSocketFactory engineGetServerSocketFactory() {
+            return delegate.getServerSocketFactory();
+        }
+
+        @Override
+        protected SSLEngine engineCreateSSLEngine() {
+            return new ProbeEngine(delegate.createSSLEngine());
+        }
+
+        @Override
+        protected SSLEngine engineCreateSSLEngine(String host, int port) {
+            return new ProbeEngine(delegate.createSSLEngine(host, port));
+        }
+
+        @Override
+        protected SSLSessionContext engineGetServerSessionContext() {
+            return delegate.getServerSessionContext();
+        }
+
+        @Override
+        protected SSLSessionContext engineGetClientSessionContext() {
+            return delegate.getClientSessionContext();
+        }
+    }
+}